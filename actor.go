@@ -2,13 +2,12 @@
 // Use of this source code is governed by an ISC
 // license that can be found in the LICENSE file.
 
-package main
+package btcsim
 
 import (
 	"errors"
 	"fmt"
-	"io"
-	"log"
+	"math"
 	"math/rand"
 	"sync"
 	"time"
@@ -22,19 +21,50 @@ import (
 // minFee is the minimum tx fee that can be paid
 const minFee btcutil.Amount = 1e4 // 0.0001 BTC
 
+// ErrIncompleteSignature is raised when the wallet cannot sign all the
+// inputs of a transaction
+var ErrIncompleteSignature = errors.New("incomplete transaction signature")
+
+// ErrActorShutdown is raised when an actor is shut down while starting
+var ErrActorShutdown = errors.New("actor shut down")
+
+// idleDelay is the time an actor waits before competing for a transaction
+// request again when its profile decides to stay idle
+const idleDelay = 100 * time.Millisecond
+
 // utxoQueue is the queue of utxos belonging to a actor
 // utxos are queued after a block is received and are dispatched
 // to their respective owner from com.poolUtxos
 // they are dequeued from simulateTx and splitUtxos
 type utxoQueue struct {
+	// mtx guards utxos, which only queueUtxos changes but others count
+	mtx     sync.Mutex
 	utxos   []*TxOut
 	enqueue chan *TxOut
 	dequeue chan *TxOut
 }
 
+// len returns the number of utxos in the queue
+func (q *utxoQueue) len() int {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	return len(q.utxos)
+}
+
 // Actor describes an actor on the simulation network.  Each actor runs
 // independantly without external input to decide it's behavior.
 type Actor struct {
+	// paid and paidBy are the number of confirmed payments sent and
+	// received by the actor, consolidations and consolidated the number
+	// of consolidation transactions and the utxos they swept and
+	// chainRejected the number of chained transactions rejected, they are
+	// updated atomically and come first to be 64-bit aligned
+	paid           uint64
+	paidBy         uint64
+	consolidations uint64
+	consolidated   uint64
+	chainRejected  uint64
+
 	*Node
 	quit             chan struct{}
 	wg               sync.WaitGroup
@@ -42,44 +72,151 @@ type Actor struct {
 	utxoQueue        *utxoQueue
 	miningAddr       chan btcutil.Address
 	walletPassphrase string
+	profile          *Profile
+	counterparty     btcutil.Address
+	rand             *rand.Rand
+
+	// index is the slot the actor was started in, which its port, seed
+	// and saved state follow
+	index int
+
+	// paused is set while the actor must not send any transaction
+	pauseMtx sync.Mutex
+	paused   bool
+
+	// restored is set when the wallet and addresses were restored from
+	// a saved state, imported when the wallet was copied from a pre-built
+	// wallet database and only its addresses are created
+	restored bool
+	imported bool
+
+	// addressTypes are the types of the owned addresses created when the
+	// actor starts, one per address
+	addressTypes []string
+
+	// rival is the node double spends are sent to, it is set once the
+	// miner is started
+	rivalMtx sync.Mutex
+	rival    *rpcClient
+
+	// fees estimates fee rates from the transactions mined recently
+	fees *feeEstimator
+
+	// bumpables are the unconfirmed transactions replaced with a higher
+	// fee when a block is mined, as per the rbf fee policy
+	bumpMtx   sync.Mutex
+	bumpables []*bumpable
+	bump      chan struct{}
+
+	// floodTarget is the number of unconfirmed transactions the actor
+	// keeps in the mempool when it is the flooder, flooded are those
+	// not mined yet
+	floodTarget int
+	floodMtx    sync.Mutex
+	flooded     map[string]bool
+	floodBlock  chan struct{}
+
+	// consolidateBlock is signaled when a block is mined
+	consolidateBlock chan struct{}
+
+	// spent are the outputs of the actor spent before they were mined,
+	// which must not be queued once they are
+	spentMtx sync.Mutex
+	spent    map[wire.OutPoint]bool
+
+	// untracked are the transactions not requested by Communicate
+	untracked *txSet
+
+	// wallet holds the keys of the actor when it runs an in-process
+	// wallet instead of a btcwallet process
+	wallet *memWallet
+
+	// crashTest kills the wallet at points of the send pipeline when the
+	// crash test is enabled
+	crashTest *crashTest
+
+	// behavior drives the actor in addition to the requested payments,
+	// it is notified of the blocks and payments queued in behaviorBlocks
+	// and behaviorPayments
+	behavior         ActorBehavior
+	behaviorName     string
+	behaviorBlocks   chan int32
+	behaviorPayments chan *TxOut
+
+	// walletVersion is the name of the btcwallet version the actor runs,
+	// empty unless versions are compared
+	walletVersion string
+
+	// peers returns the running actors and txSent receives the records
+	// of the transactions sent by the behavior
+	peers  func() []*Actor
+	txSent chan<- *TxRecord
 }
 
-// TxOut is a valid tx output that can be used to generate transactions
+// TxOut is a valid tx output that can be used to generate transactions,
+// Coinbase is set for the outputs of a block reward
 type TxOut struct {
 	OutPoint *wire.OutPoint
 	Amount   btcutil.Amount
+	Coinbase bool
 }
 
 // NewActor creates a new actor which runs its own wallet process connecting
 // to the btcd node server specified by node, and listening for simulator
-// websocket connections on the specified port.
+// websocket connections on the specified port. With -inprocess, the actor
+// runs an in-process wallet connecting to node directly instead, and the
+// port only names the actor. The actor belongs to the run of node.
 func NewActor(node *Node, port uint16) (*Actor, error) {
+	cfg := node.cfg
+
 	// Please don't run this as root.
 	if port < 1024 {
 		return nil, errors.New("invalid actor port")
 	}
 
-	// Set btcwallet node args
-	args, err := newBtcwalletArgs(port, node.Args.(*btcdArgs))
-	if err != nil {
-		return nil, err
-	}
+	var btcwallet *Node
+	var wallet *memWallet
+	if cfg.InProcess {
+		args := newMemWalletArgs(port, node.Args, node.pool)
+		var err error
+		if btcwallet, err = NewNodeFromArgs(cfg, args, nil, nil); err != nil {
+			return nil, err
+		}
+		wallet = newMemWallet(cfg.netParams())
+	} else {
+		// Set btcwallet node args
+		args, err := newBtcwalletArgs(cfg, port, unwrapArgs(node.Args).(*btcdArgs))
+		if err != nil {
+			return nil, err
+		}
 
-	logFile, err := getLogFile(args.prefix)
-	if err != nil {
-		log.Printf("Cannot get log file, logging disabled: %v", err)
-	}
-	btcwallet, err := NewNodeFromArgs(args, nil, logFile)
-	if err != nil {
-		return nil, err
+		logFile, err := cfg.getLogFile(args.prefix)
+		if err != nil {
+			log.Warnf("Cannot get log file, logging disabled: %v", err)
+		}
+		if btcwallet, err = NewNodeFromArgs(cfg, cfg.provisioner.wrap(args), nil, logFile); err != nil {
+			return nil, err
+		}
 	}
 
 	a := Actor{
 		Node:             btcwallet,
 		quit:             make(chan struct{}),
-		ownedAddresses:   make([]btcutil.Address, *maxAddresses),
+		ownedAddresses:   make([]btcutil.Address, cfg.MaxAddresses),
 		miningAddr:       make(chan btcutil.Address),
 		walletPassphrase: "walletpass",
+		profile:          defaultProfile,
+		rand:             cfg.newRand(int64(port)),
+		bump:             make(chan struct{}, 1),
+		flooded:          make(map[string]bool),
+		floodBlock:       make(chan struct{}, 1),
+		consolidateBlock: make(chan struct{}, 1),
+		spent:            make(map[wire.OutPoint]bool),
+		wallet:           wallet,
+		behavior:         NopBehavior{},
+		behaviorName:     behaviorPassive,
+		behaviorBlocks:   make(chan int32, behaviorQueueSize),
+		behaviorPayments: make(chan *TxOut, behaviorQueueSize),
 		utxoQueue: &utxoQueue{
 			enqueue: make(chan *TxOut),
 			dequeue: make(chan *TxOut),
@@ -88,28 +225,134 @@ func NewActor(node *Node, port uint16) (*Actor, error) {
 	return &a, nil
 }
 
+// newIndexedActor creates the actor with the given index on node. Actors
+// running a btcwallet process listen on a port allocated for them, while
+// in-process wallets do not listen at all: they are only named after the
+// port they would prefer, so that thousands of them take no ports.
+func newIndexedActor(node *Node, i int) (*Actor, error) {
+	preferred := 18557 + i
+	if node.cfg.InProcess {
+		if preferred > math.MaxUint16 {
+			return nil, errors.New("too many in-process actors")
+		}
+		return NewActor(node, uint16(preferred))
+	}
+	port, err := node.cfg.allocPort(preferred)
+	if err != nil {
+		return nil, fmt.Errorf("cannot allocate actor port: %v", err)
+	}
+	return NewActor(node, port)
+}
+
 // Start creates the command to execute a wallet process and starts the
-// command in the background, attaching the command's stderr and stdout
-// to the passed writers. Nil writers may be used to discard output.
+// command in the background, its output goes to the rotating log file of
+// the actor in the run directory.
 //
 // In addition to starting the wallet process, this runs goroutines to
 // handle wallet notifications and requests the wallet process to create
 // an intial encrypted wallet, so that it can actually send and receive BTC.
 //
 // If the RPC client connection cannot be established or wallet cannot
-// be created, the wallet process is killed and the actor directory
-// removed.
-func (a *Actor) Start(stderr, stdout io.Writer, com *Communication) error {
-	connected := make(chan struct{})
+// be created, an error is returned and the caller must shut the actor
+// down, which kills the wallet process and removes the actor directory.
+//
+// An actor with an in-process wallet connects to its chain server and
+// creates its keys instead.
+func (a *Actor) Start(com *Communication) error {
+	types, err := assignAddressTypes(len(a.ownedAddresses), a.cfg.AddressTypeMix)
+	if err != nil {
+		return err
+	}
+	a.addressTypes = types
+
+	start := a.startWallet
+	if a.wallet != nil {
+		start = a.startMemWallet
+	}
+	if err := com.startup.run(a, start); err != nil {
+		return err
+	}
+
+	// Send a random address that will be used by the cpu miner.
+	select {
+	case a.miningAddr <- a.ownedAddresses[a.rand.Int()%len(a.ownedAddresses)]:
+	case <-a.quit:
+		return ErrActorShutdown
+	}
+
+	// Start a goroutine that queues up a set of utxos belonging to this
+	// actor. The utxos are sent from com.poolUtxos which in turn receives
+	// block notifications from sim.go
+	a.wg.Add(1)
+	go a.queueUtxos()
+
+	// The utxos of a restored or imported wallet were mined before the
+	// simulation started so they are queued from the wallet
+	if a.restored || a.imported {
+		a.wg.Add(1)
+		go a.queueWalletUtxos()
+	}
+
+	// Run the behavior of the actor, the flooder's is passive
+	a.untracked = com.untracked
+	a.peers = com.Actors
+	a.txSent = com.txStats.sent
+	if err := a.behavior.OnStart(a); err != nil {
+		closeBehavior(a)
+		return err
+	}
+	a.wg.Add(1)
+	go a.runBehavior(a.cfg.realDuration(a.cfg.BehaviorTick))
+
+	// The flooder only floods the mempool
+	if a.floodTarget > 0 {
+		a.wg.Add(1)
+		go a.flood(a.floodTarget, com.untracked, com.txStats.sent)
+		return nil
+	}
+
+	// Start a goroutine to simulate transactions.
+	a.wg.Add(1)
+	go a.simulateTx(com.downstream, com.matchmaker, com.txpool, com.txStats.sent)
+
+	// Start a goroutine to split utxos
+	a.wg.Add(1)
+	go a.splitUtxos(com.split, com.txpool, com.txStats.sent)
+
+	// Start a goroutine to sweep utxos when fees are low
+	if a.profile.Consolidate > 0 {
+		a.wg.Add(1)
+		go a.consolidate(a.profile.Consolidate, com.untracked, com.txStats.sent)
+	}
+
+	// Start a goroutine to replace transactions which are not mined in
+	// the next block
+	a.fees = com.txStats.fees
+	if p := a.feePolicy(); p != nil && p.name == feeRBF {
+		a.wg.Add(1)
+		go a.bumpFees(p.params[1], com.txStats.sent)
+	}
+
+	return nil
+}
+
+// startWallet starts the wallet process of the actor and connects to it,
+// it then creates the wallet, unlocks it and creates its addresses unless
+// they were restored
+func (a *Actor) startWallet() error {
+	// connected is buffered as the wallet may notify it is connected to
+	// btcd while Connect still waits for it to be ready
+	connected := make(chan struct{}, 1)
 	var firstConn bool
 	const timeoutSecs int64 = 3600 * 24
 
 	if err := a.Node.Start(); err != nil {
-		a.Shutdown()
-		com.errChan <- struct{}{}
 		return err
 	}
 
+	// unlocked is closed once the wallet is unlocked for the first time,
+	// it is unlocked again whenever the client reconnects after that
+	unlocked := make(chan struct{})
 	ntfnHandlers := &rpc.NotificationHandlers{
 		OnBtcdConnected: func(conn bool) {
 			if conn && !firstConn {
@@ -117,101 +360,225 @@ func (a *Actor) Start(stderr, stdout io.Writer, com *Communication) error {
 				connected <- struct{}{}
 			}
 		},
+		OnClientConnected: func() {
+			select {
+			case <-unlocked:
+				go a.unlockWallet(timeoutSecs)
+			default:
+			}
+		},
 	}
 	a.handlers = ntfnHandlers
 
 	if err := a.Connect(); err != nil {
-		a.Shutdown()
-		com.errChan <- struct{}{}
 		return err
 	}
 
 	// Wait for btcd to connect
-	<-connected
+	select {
+	case <-connected:
+	case <-a.quit:
+		return ErrActorShutdown
+	}
 
-	// Create the wallet.
-	if err := a.client.CreateEncryptedWallet(a.walletPassphrase); err != nil {
-		com.errChan <- struct{}{}
-		return err
+	// Create the wallet, a restored or imported wallet already exists.
+	if !a.restored && !a.imported {
+		if err := a.client.CreateEncryptedWallet(a.walletPassphrase); err != nil {
+			return err
+		}
 	}
 
 	// Wait for wallet sync
-	for i := 0; i < *maxConnRetries; i++ {
-		if _, err := a.client.GetBalance(""); err != nil {
-			time.Sleep(time.Duration(i) * 50 * time.Millisecond)
-			continue
+	err := a.probe("synced", a.cfg.ReadyTimeout, func() error {
+		_, err := a.client.GetBalance("")
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	// Unlock the wallet, the keys of new addresses of some types are
+	// exported, and create its addresses unless they were restored.
+	if err := a.unlockWallet(timeoutSecs); err != nil {
+		return err
+	}
+	close(unlocked)
+
+	if !a.restored {
+		log.Debugf("%s: Creating wallet addresses...", a)
+		for i := range a.ownedAddresses {
+			addr, err := a.newWalletAddress(a.addressTypes[i])
+			if err != nil {
+				log.Errorf("%s: Cannot create %s address #%d", a, a.addressTypes[i], i+1)
+				return err
+			}
+			a.ownedAddresses[i] = addr
 		}
-		break
+		log.Debugf("%s: Created %d wallet addresses", a, len(a.ownedAddresses))
 	}
+	return nil
+}
 
-	// Create wallet addresses and unlock wallet.
-	log.Printf("%s: Creating wallet addresses...", a)
+// startMemWallet connects the in-process wallet of the actor to its chain
+// server, with a client of its pool if any, and creates its addresses,
+// which are derived from an extended key with -hdwallets
+func (a *Actor) startMemWallet() error {
+	if pool := a.Args.(*memWalletArgs).pool; pool != nil {
+		client, err := pool.get()
+		if err != nil {
+			return err
+		}
+		a.client, a.shared = client, true
+	} else if err := a.Connect(); err != nil {
+		return err
+	}
+	if a.cfg.HDWallets {
+		if err := a.startHDWallet(uint32(a.cfg.HDGap)); err != nil {
+			return err
+		}
+	}
 	for i := range a.ownedAddresses {
-		fmt.Printf("\r%d/%d", i+1, len(a.ownedAddresses))
-		addr, err := a.client.GetNewAddress()
+		addr, err := a.wallet.newAddress(a.addressTypes[i])
 		if err != nil {
-			log.Printf("%s: Cannot create address #%d", a, i+1)
-			com.errChan <- struct{}{}
+			log.Errorf("%s: Cannot create %s address #%d", a, a.addressTypes[i], i+1)
 			return err
 		}
 		a.ownedAddresses[i] = addr
 	}
-	fmt.Printf("\n")
+	log.Debugf("%s: Created %d in-process wallet addresses", a, len(a.ownedAddresses))
+	return nil
+}
 
+// unlockWallet unlocks the wallet for the given number of seconds
+func (a *Actor) unlockWallet(timeoutSecs int64) error {
 	if err := a.client.WalletPassphrase(a.walletPassphrase, timeoutSecs); err != nil {
-		log.Printf("%s: Cannot unlock wallet: %v", a, err)
-		com.errChan <- struct{}{}
+		log.Errorf("%s: Cannot unlock wallet: %v", a, err)
 		return err
 	}
+	return nil
+}
 
-	// Send a random address that will be used by the cpu miner.
-	a.miningAddr <- a.ownedAddresses[rand.Int()%len(a.ownedAddresses)]
-
-	// Start a goroutine that queues up a set of utxos belonging to this
-	// actor. The utxos are sent from com.poolUtxos which in turn receives
-	// block notifications from sim.go
-	a.wg.Add(1)
-	go a.queueUtxos()
+// Pause stops the actor from sending transactions until Resume is called
+func (a *Actor) Pause() {
+	a.pauseMtx.Lock()
+	a.paused = true
+	a.pauseMtx.Unlock()
+}
 
-	// Start a goroutine to simulate transactions.
-	a.wg.Add(1)
-	go a.simulateTx(com.downstream, com.txpool)
+// Resume lets a paused actor send transactions again
+func (a *Actor) Resume() {
+	a.pauseMtx.Lock()
+	a.paused = false
+	a.pauseMtx.Unlock()
+}
 
-	// Start a goroutine to split utxos
-	a.wg.Add(1)
-	go a.splitUtxos(com.split, com.txpool)
+// Paused reports whether the actor is paused
+func (a *Actor) Paused() bool {
+	a.pauseMtx.Lock()
+	defer a.pauseMtx.Unlock()
+	return a.paused
+}
 
-	return nil
+// waitWhilePaused blocks as long as the actor is paused, it returns false
+// if the actor quits in the meantime
+func (a *Actor) waitWhilePaused() bool {
+	for a.Paused() {
+		select {
+		case <-time.After(idleDelay):
+		case <-a.quit:
+			return false
+		}
+	}
+	return true
 }
 
 // simulateTx runs as a goroutine and simulates transactions between actors
 //
-// It receives a random address downstream, dequeues a utxo, sends a raw
-// transaction to the address using the utxo as input
-func (a *Actor) simulateTx(downstream <-chan btcutil.Address, txpool chan<- struct{}) {
+// It receives a request downstream, dequeues a utxo, sends a raw
+// transaction to the payee picked by the matchmaker using the utxo as
+// input and records the payment with the matchmaker
+func (a *Actor) simulateTx(downstream <-chan struct{}, m *matchmaker, txpool chan<- struct{}, txSent chan<- *TxRecord) {
 	defer a.wg.Done()
 
 	for {
 		select {
 		case utxo := <-a.utxoQueue.dequeue:
+			if !a.waitWhilePaused() {
+				return
+			}
+			// stay idle as per the profile before competing
+			// for the next request
+			for a.rand.Float64() >= a.profile.Activity {
+				select {
+				case <-time.After(idleDelay):
+				case <-a.quit:
+					return
+				}
+			}
 			select {
-			case addr := <-downstream:
+			case <-downstream:
 				// Create a raw transaction
 				inputs := []btcjson.TransactionInput{{
 					Txid: utxo.OutPoint.Hash.String(),
 					Vout: utxo.OutPoint.Index,
 				}}
 
-				// Provide a fees of minFee to ensure the tx gets mined
-				// the utxo amount is guaranteed to be > maxSplit*minFee
-				amt := utxo.Amount - minFee
-				amounts := map[btcutil.Address]btcutil.Amount{
-					addr: amt,
+				// pick the payees, several for batch payouts
+				tos := make([]btcutil.Address, a.profile.batchSize())
+				var payments []*payment
+				paidTo := make(map[btcutil.Address]*payment)
+				for i := range tos {
+					payee, addr := m.payee(a)
+					tos[i] = a.profile.recipient(a, addr)
+					if tos[i] == addr && paidTo[addr] == nil {
+						p := &payment{payer: a, payee: payee}
+						paidTo[addr] = p
+						payments = append(payments, p)
+					}
+				}
+
+				// embed data in a fraction of the transactions
+				var extra []*wire.TxOut
+				size := estimateTxSize(len(inputs), len(tos)+1)
+				if a.cfg.DataFraction > 0 && a.rand.Float64() < a.cfg.DataFraction {
+					txOut, err := dataOutput(a.rand, a.cfg.DataCarrierSize)
+					if err != nil {
+						log.Errorf("%s: Cannot create data output: %v", a, err)
+					} else {
+						extra = append(extra, txOut)
+						size += dataOutputSize(a.cfg.DataCarrierSize)
+					}
+				}
+
+				// Provide a fee as per the fee policy, no more than
+				// half of the utxo amount which is guaranteed to be
+				// > maxSplit*minFee
+				fee := a.feeForSize(size)
+				if fee > utxo.Amount/2 {
+					fee = utxo.Amount / 2
+				}
+				amt := utxo.Amount - fee
+				var amounts map[btcutil.Address]btcutil.Amount
+				if m.invoices != nil && len(tos) == 1 && len(payments) == 1 {
+					// the payee asks for the amount to a fresh address
+					amounts = a.invoicePayment(m.invoices, payments[0], amt)
+					if amounts != nil {
+						delete(paidTo, tos[0])
+						tos[0] = payments[0].invoice.addr
+						paidTo[tos[0]] = payments[0]
+					}
+				}
+				if amounts == nil {
+					if len(tos) == 1 {
+						amounts = a.profile.payment(a, tos[0], amt)
+					} else {
+						amounts = a.profile.batchPayment(a, tos, amt)
+					}
 				}
 
-				err := a.sendRawTransaction(inputs, amounts)
+				msgTx, err := a.sendRawTransaction(inputs, amounts, extra...)
 				if err != nil {
-					log.Printf("%s: Error sending raw transaction: %v", a, err)
+					log.Errorf("%s: Error sending raw transaction: %v", a, err)
 					select {
 					case txpool <- struct{}{}:
 					case <-a.quit:
@@ -219,6 +586,30 @@ func (a *Actor) simulateTx(downstream <-chan btcutil.Address, txpool chan<- stru
 					}
 					continue
 				}
+				a.recordTx(msgTx, utxo.Amount, txSent)
+				if p := a.feePolicy(); p != nil && p.name == feeCPFP && len(tos) == 1 {
+					a.payForParent(msgTx, tos[0], fee, p.params[1], txSent)
+				} else if a.profile.Chain > 0 {
+					a.chain(msgTx, tos, a.profile.Chain, txSent)
+				}
+				if a.replaceable() {
+					a.addBumpable(&bumpable{
+						txid:    msgTx.TxSha().String(),
+						inputs:  inputs,
+						amounts: amounts,
+						paid:    tos,
+						extra:   extra,
+						in:      utxo.Amount,
+						fee:     fee,
+					})
+				}
+				if paid := paidPayments(payments, paidTo, amounts); len(paid) > 0 {
+					m.sent(msgTx.TxSha().String(), paid...)
+				}
+
+				if a.profile.DoubleSpend > 0 && a.rand.Float64() < a.profile.DoubleSpend {
+					a.doubleSpend(inputs, utxo.Amount, msgTx, txSent)
+				}
 
 			case <-a.quit:
 				return
@@ -229,18 +620,61 @@ func (a *Actor) simulateTx(downstream <-chan btcutil.Address, txpool chan<- stru
 	}
 }
 
+// SetRival sets the node double spends are sent to
+func (a *Actor) SetRival(client *rpcClient) {
+	a.rivalMtx.Lock()
+	a.rival = client
+	a.rivalMtx.Unlock()
+}
+
+// doubleSpend sends a transaction spending the inputs of the victim
+// transaction back to the actor, with a higher fee, to the rival node.
+// The conflicting transaction is recorded if the rival accepts it.
+func (a *Actor) doubleSpend(inputs []btcjson.TransactionInput, in btcutil.Amount,
+	victim *wire.MsgTx, txSent chan<- *TxRecord) {
+
+	a.rivalMtx.Lock()
+	rival := a.rival
+	a.rivalMtx.Unlock()
+	if rival == nil {
+		return
+	}
+
+	to := a.ownedAddresses[a.rand.Int()%len(a.ownedAddresses)]
+	amounts := map[btcutil.Address]btcutil.Amount{to: in - 2*minFee}
+	msgTx, err := a.createRawTransaction(inputs, amounts)
+	if err != nil {
+		log.Errorf("%s: Cannot create double spend: %v", a, err)
+		return
+	}
+	if _, err := rival.SendRawTransaction(msgTx, false); err != nil {
+		// the victim transaction reached the rival first
+		log.Debugf("%s: Double spend rejected: %v", a, err)
+		return
+	}
+	r := a.newTxRecord(msgTx, in)
+	r.DoubleSpend = victim.TxSha().String()
+	select {
+	case txSent <- r:
+	case <-a.quit:
+	}
+}
+
 // splitUtxos runs as a goroutine and builds up a large set of utxos that
 // can be used to simulate large tx/block ratios
 //
 // It receives a 'split' which is int that indicates the number of resultant utxos
 // the tx is sent to addresses from the same actor since we're only interested in
 // building up the utxo set
-func (a *Actor) splitUtxos(split <-chan int, txpool chan<- struct{}) {
+func (a *Actor) splitUtxos(split <-chan int, txpool chan<- struct{}, txSent chan<- *TxRecord) {
 	defer a.wg.Done()
 
 	for {
 		select {
 		case utxo := <-a.utxoQueue.dequeue:
+			if !a.waitWhilePaused() {
+				return
+			}
 			select {
 			case split := <-split:
 				// Create a raw transaction
@@ -260,7 +694,7 @@ func (a *Actor) splitUtxos(split <-chan int, txpool chan<- struct{}) {
 				// account this utxo which is consumed in the process
 
 				// set a rand start index for getting different random addrs
-				randomIndex := rand.Int() % len(a.ownedAddresses)
+				randomIndex := a.rand.Int() % len(a.ownedAddresses)
 				for i := 0; i <= split; i++ {
 					var to btcutil.Address
 					var change btcutil.Amount
@@ -277,7 +711,7 @@ func (a *Actor) splitUtxos(split <-chan int, txpool chan<- struct{}) {
 					} else {
 						// pick a random change amount which is less than amt
 						// but have a lower bound at minFee
-						change = btcutil.Amount(rand.Int63n(int64(amt) / 2))
+						change = btcutil.Amount(a.rand.Int63n(int64(amt) / 2))
 						if change < minFee {
 							change = minFee
 						}
@@ -286,9 +720,9 @@ func (a *Actor) splitUtxos(split <-chan int, txpool chan<- struct{}) {
 					amounts[to] = change
 				}
 
-				err := a.sendRawTransaction(inputs, amounts)
+				msgTx, err := a.sendRawTransaction(inputs, amounts)
 				if err != nil {
-					log.Printf("%s: Error sending raw transaction: %v", a, err)
+					log.Errorf("%s: Error sending raw transaction: %v", a, err)
 					select {
 					case txpool <- struct{}{}:
 					case <-a.quit:
@@ -296,6 +730,7 @@ func (a *Actor) splitUtxos(split <-chan int, txpool chan<- struct{}) {
 					}
 					continue
 				}
+				a.recordTx(msgTx, utxo.Amount, txSent)
 
 			case <-a.quit:
 				return
@@ -306,25 +741,96 @@ func (a *Actor) splitUtxos(split <-chan int, txpool chan<- struct{}) {
 	}
 }
 
-// sendRawTransaction creates a raw transaction, signs it and sends it
-func (a *Actor) sendRawTransaction(inputs []btcjson.TransactionInput, amounts map[btcutil.Address]btcutil.Amount) error {
+// createRawTransaction creates a raw transaction with the extra outputs
+// appended, such as OP_RETURN outputs, and signs it
+func (a *Actor) createRawTransaction(inputs []btcjson.TransactionInput,
+	amounts map[btcutil.Address]btcutil.Amount, extra ...*wire.TxOut) (*wire.MsgTx, error) {
+
+	a.crashTest.point(a, crashBeforeCreate)
 	msgTx, err := a.client.CreateRawTransaction(inputs, amounts)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	for _, txOut := range extra {
+		msgTx.AddTxOut(txOut)
+	}
+	if a.replaceable() {
+		for _, txIn := range msgTx.TxIn {
+			txIn.Sequence = rbfSequence
+		}
 	}
 	// sign it
-	msgTx, ok, err := a.client.SignRawTransaction(msgTx)
+	a.crashTest.point(a, crashBeforeSign)
+	msgTx, ok, err := a.signRawTransaction(msgTx, nil)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if !ok {
-		return err
+		return nil, ErrIncompleteSignature
+	}
+	return msgTx, nil
+}
+
+// signRawTransaction signs the inputs of the transaction spending outputs
+// of the actor, with the in-process wallet if any, and reports whether all
+// the inputs are signed. prevOuts are the outputs spent which the wallet
+// may not know about.
+func (a *Actor) signRawTransaction(msgTx *wire.MsgTx,
+	prevOuts []btcjson.RawTxInput) (*wire.MsgTx, bool, error) {
+
+	if a.wallet != nil {
+		return a.wallet.sign(a.client, msgTx, prevOuts)
+	}
+	if prevOuts == nil {
+		return a.client.SignRawTransaction(msgTx)
+	}
+	return a.client.SignRawTransaction2(msgTx, prevOuts)
+}
+
+// sendRawTransaction creates a raw transaction, signs it and sends it
+// It returns the signed transaction
+func (a *Actor) sendRawTransaction(inputs []btcjson.TransactionInput,
+	amounts map[btcutil.Address]btcutil.Amount, extra ...*wire.TxOut) (*wire.MsgTx, error) {
+
+	msgTx, err := a.createRawTransaction(inputs, amounts, extra...)
+	if err != nil {
+		return nil, err
 	}
 	// and finally send it.
+	a.crashTest.point(a, crashBeforeSend)
 	if _, err := a.client.SendRawTransaction(msgTx, false); err != nil {
-		return err
+		return nil, err
+	}
+	a.crashTest.point(a, crashAfterSend)
+	return msgTx, nil
+}
+
+// recordTx sends the statistics of a transaction spending inputs worth
+// in to the statistics collector
+func (a *Actor) recordTx(msgTx *wire.MsgTx, in btcutil.Amount, txSent chan<- *TxRecord) {
+	select {
+	case txSent <- a.newTxRecord(msgTx, in):
+	case <-a.quit:
+	}
+}
+
+// newTxRecord returns the record of a transaction sent by the actor
+// spending inputs worth in
+func (a *Actor) newTxRecord(msgTx *wire.MsgTx, in btcutil.Amount) *TxRecord {
+	var out btcutil.Amount
+	for _, txOut := range msgTx.TxOut {
+		out += btcutil.Amount(txOut.Value)
+	}
+	return &TxRecord{
+		TxID:     msgTx.TxSha().String(),
+		Actor:    a.String(),
+		Size:     msgTx.SerializeSize(),
+		Fee:      int64(in - out),
+		Inputs:   len(msgTx.TxIn),
+		Outputs:  len(msgTx.TxOut),
+		DataSize: dataSize(msgTx),
+		SentTime: time.Now(),
 	}
-	return nil
 }
 
 // queueUtxos receives utxos belonging to this actor and queues them up
@@ -348,14 +854,21 @@ out:
 				enqueue = nil
 				continue
 			}
+			if a.unmarkSpent(*n.OutPoint) {
+				continue
+			}
 			if len(a.utxoQueue.utxos) == 0 {
 				next = n
 				dequeue = a.utxoQueue.dequeue
 			}
+			a.utxoQueue.mtx.Lock()
 			a.utxoQueue.utxos = append(a.utxoQueue.utxos, n)
+			a.utxoQueue.mtx.Unlock()
 		case dequeue <- next:
+			a.utxoQueue.mtx.Lock()
 			a.utxoQueue.utxos[0] = nil
 			a.utxoQueue.utxos = a.utxoQueue.utxos[1:]
+			a.utxoQueue.mtx.Unlock()
 			if len(a.utxoQueue.utxos) != 0 {
 				next = a.utxoQueue.utxos[0]
 			} else {
@@ -0,0 +1,111 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil"
+)
+
+// The types of the receiving addresses of the actors
+const (
+	// addrP2PKH pays to the hash of a compressed public key
+	addrP2PKH = "p2pkh"
+
+	// addrUncompressed pays to the hash of an uncompressed public key
+	addrUncompressed = "uncompressed"
+
+	// addrP2SH pays to the hash of a 1-of-1 multisig redeem script
+	addrP2SH = "p2sh"
+)
+
+// addressTypes are the valid types of receiving addresses
+var addressTypes = map[string]bool{
+	addrP2PKH:        true,
+	addrUncompressed: true,
+	addrP2SH:         true,
+}
+
+// assignAddressTypes returns the types of n addresses given a mix of
+// types by weight, e.g. "p2pkh=70,p2sh=20,uncompressed=10". Each type
+// gets its share of the addresses, which are all p2pkh without a mix.
+func assignAddressTypes(n int, mix string) ([]string, error) {
+	assigned := make([]string, n)
+	for i := range assigned {
+		assigned[i] = addrP2PKH
+	}
+
+	weights, err := parseAssignments(mix)
+	if err != nil {
+		return nil, err
+	}
+	var total float64
+	ws := make([]float64, len(weights))
+	for i, w := range weights {
+		if !addressTypes[w[0]] {
+			names := make([]string, 0, len(addressTypes))
+			for name := range addressTypes {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return nil, fmt.Errorf("unknown address type %q, valid types are: %s",
+				w[0], strings.Join(names, ", "))
+		}
+		if ws[i], err = strconv.ParseFloat(w[1], 64); err != nil || ws[i] < 0 {
+			return nil, fmt.Errorf("invalid weight %q for address type %s", w[1], w[0])
+		}
+		total += ws[i]
+	}
+	if total > 0 {
+		// same rounding of the cumulative share as assignProfiles
+		var cumulative float64
+		start := 0
+		for i, w := range weights {
+			cumulative += ws[i]
+			end := int(cumulative/total*float64(n) + 0.5)
+			for j := start; j < end; j++ {
+				assigned[j] = w[0]
+			}
+			start = end
+		}
+	}
+	return assigned, nil
+}
+
+// newWalletAddress requests an address of the given type from the
+// btcwallet of the actor, which must be unlocked for the other types than
+// p2pkh. An uncompressed address imports the key of a new address in its
+// uncompressed form and a p2sh address wraps a new address in a 1-of-1
+// multisig script.
+func (a *Actor) newWalletAddress(kind string) (btcutil.Address, error) {
+	addr, err := a.client.GetNewAddress()
+	if err != nil {
+		return nil, err
+	}
+	switch kind {
+	case addrUncompressed:
+		wif, err := a.client.DumpPrivKey(addr)
+		if err != nil {
+			return nil, err
+		}
+		wif, err = btcutil.NewWIF(wif.PrivKey, &chaincfg.SimNetParams, false)
+		if err != nil {
+			return nil, err
+		}
+		if err := a.client.ImportPrivKeyRescan(wif, "", false); err != nil {
+			return nil, err
+		}
+		return btcutil.NewAddressPubKeyHash(btcutil.Hash160(wif.SerializePubKey()),
+			&chaincfg.SimNetParams)
+	case addrP2SH:
+		return a.client.AddMultisigAddress(1, []btcutil.Address{addr}, "")
+	}
+	return addr, nil
+}
@@ -0,0 +1,66 @@
+package btcsim
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+func TestAssignAddressTypes(t *testing.T) {
+	tests := []struct {
+		n    int
+		mix  string
+		want []string
+	}{
+		{3, "", []string{"p2pkh", "p2pkh", "p2pkh"}},
+		{4, "p2sh=1", []string{"p2sh", "p2sh", "p2sh", "p2sh"}},
+		{4, "p2pkh=50,p2sh=25,uncompressed=25",
+			[]string{"p2pkh", "p2pkh", "p2sh", "uncompressed"}},
+		{2, "p2pkh=0,uncompressed=0", []string{"p2pkh", "p2pkh"}},
+	}
+	for _, test := range tests {
+		got, err := assignAddressTypes(test.n, test.mix)
+		if err != nil {
+			t.Errorf("assignAddressTypes(%d, %q) error: %v", test.n, test.mix, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("assignAddressTypes(%d, %q) got %v want %v", test.n, test.mix, got, test.want)
+		}
+	}
+
+	for _, mix := range []string{"p2wpkh=1", "p2sh=-1", "p2sh=x", "p2sh"} {
+		if _, err := assignAddressTypes(4, mix); err == nil {
+			t.Errorf("assignAddressTypes(%q) expected error", mix)
+		}
+	}
+}
+
+func TestGetActorScriptHash(t *testing.T) {
+	owned, err := btcutil.NewAddressScriptHashFromHash(bytes.Repeat([]byte{1}, 20), &chaincfg.SimNetParams)
+	if err != nil {
+		t.Fatalf("NewAddressScriptHashFromHash error: %v", err)
+	}
+	multisig, err := btcutil.NewAddressScriptHashFromHash(bytes.Repeat([]byte{2}, 20), &chaincfg.SimNetParams)
+	if err != nil {
+		t.Fatalf("NewAddressScriptHashFromHash error: %v", err)
+	}
+	a, b := fakeActor("a"), fakeActor("b")
+	b.ownedAddresses = []btcutil.Address{owned}
+	com := NewCommunication(DefaultConfig())
+	actors := []*Actor{a, b}
+
+	script, _ := txscript.PayToAddrScript(owned)
+	if got, err := com.getActor(actors, wire.NewTxOut(1e8, script)); err != nil || got != b {
+		t.Errorf("getActor of a p2sh address of b got %v, %v want b", got, err)
+	}
+	script, _ = txscript.PayToAddrScript(multisig)
+	if _, err := com.getActor(actors, wire.NewTxOut(1e8, script)); err != errNoOwner {
+		t.Errorf("getActor of a multisig address error %v want %v", err, errNoOwner)
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcutil"
+)
+
+// distribution samples a value in BTC given its parameters
+type distribution struct {
+	// params are the names of the parameters, in order
+	params []string
+
+	// validate returns an error if the parameters are out of range
+	validate func(p []float64) error
+
+	sample func(r *rand.Rand, p []float64) float64
+}
+
+// distributions are the amount distributions by name
+var distributions = map[string]*distribution{
+	// uniform between min and max
+	"uniform": {
+		params: []string{"min", "max"},
+		validate: func(p []float64) error {
+			if p[0] < 0 || p[0] > p[1] {
+				return fmt.Errorf("parameters must satisfy 0 <= min <= max")
+			}
+			return nil
+		},
+		sample: func(r *rand.Rand, p []float64) float64 {
+			return p[0] + r.Float64()*(p[1]-p[0])
+		},
+	},
+	// exponential with the given mean
+	"exponential": {
+		params: []string{"mean"},
+		validate: func(p []float64) error {
+			if p[0] <= 0 {
+				return fmt.Errorf("mean must be positive")
+			}
+			return nil
+		},
+		sample: func(r *rand.Rand, p []float64) float64 {
+			return r.ExpFloat64() * p[0]
+		},
+	},
+	// log-normal whose logarithm has mean mu and standard deviation sigma
+	"lognormal": {
+		params: []string{"mu", "sigma"},
+		validate: func(p []float64) error {
+			if p[1] < 0 {
+				return fmt.Errorf("sigma must not be negative")
+			}
+			return nil
+		},
+		sample: func(r *rand.Rand, p []float64) float64 {
+			return math.Exp(p[0] + p[1]*r.NormFloat64())
+		},
+	},
+	// pareto with the given minimum (scale) and shape, heavier tailed
+	// as the shape decreases
+	"pareto": {
+		params: []string{"min", "shape"},
+		validate: func(p []float64) error {
+			if p[0] <= 0 || p[1] <= 0 {
+				return fmt.Errorf("min and shape must be positive")
+			}
+			return nil
+		},
+		sample: func(r *rand.Rand, p []float64) float64 {
+			// 1 - Float64() is in (0, 1]
+			return p[0] / math.Pow(1-r.Float64(), 1/p[1])
+		},
+	},
+}
+
+// amountDist is a distribution of payment amounts with its parameters
+type amountDist struct {
+	name   string
+	params []float64
+}
+
+// parseAmountDist parses a distribution of payment amounts in BTC given
+// as its name followed by its parameters, separated by colons, e.g.
+// "pareto:0.01:1.5". The empty string returns a nil distribution.
+func parseAmountDist(s string) (*amountDist, error) {
+	if s == "" {
+		return nil, nil
+	}
+	fields := strings.Split(s, ":")
+	d, ok := distributions[fields[0]]
+	if !ok {
+		names := make([]string, 0, len(distributions))
+		for name := range distributions {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown amount distribution %q, valid distributions are: %s",
+			fields[0], strings.Join(names, ", "))
+	}
+	if len(fields)-1 != len(d.params) {
+		return nil, fmt.Errorf("amount distribution %s: expected parameters %s",
+			fields[0], strings.Join(d.params, ":"))
+	}
+	dist := &amountDist{
+		name:   fields[0],
+		params: make([]float64, len(d.params)),
+	}
+	for i, f := range fields[1:] {
+		v, err := strconv.ParseFloat(f, 64)
+		if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil, fmt.Errorf("amount distribution %s: invalid %s %q",
+				fields[0], d.params[i], f)
+		}
+		dist.params[i] = v
+	}
+	if err := d.validate(dist.params); err != nil {
+		return nil, fmt.Errorf("amount distribution %s: %v", fields[0], err)
+	}
+	return dist, nil
+}
+
+// sample returns an amount drawn from the distribution, never more
+// than max
+func (d *amountDist) sample(r *rand.Rand, max btcutil.Amount) btcutil.Amount {
+	v := distributions[d.name].sample(r, d.params) * btcutil.SatoshiPerBitcoin
+	if v >= float64(max) {
+		return max
+	}
+	if v < 0 {
+		return 0
+	}
+	return btcutil.Amount(v)
+}
+
+// String returns the distribution as it is parsed
+func (d *amountDist) String() string {
+	s := d.name
+	for _, p := range d.params {
+		s += ":" + strconv.FormatFloat(p, 'g', -1, 64)
+	}
+	return s
+}
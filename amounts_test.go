@@ -0,0 +1,87 @@
+package btcsim
+
+import (
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcutil"
+)
+
+func TestParseAmountDist(t *testing.T) {
+	d, err := parseAmountDist("pareto:0.01:1.5")
+	if err != nil {
+		t.Fatalf("parseAmountDist error: %v", err)
+	}
+	if d.String() != "pareto:0.01:1.5" {
+		t.Errorf("parseAmountDist got %v want pareto:0.01:1.5", d)
+	}
+	if d, err := parseAmountDist(""); d != nil || err != nil {
+		t.Errorf("parseAmountDist got %v, %v want nil, nil", d, err)
+	}
+	invalid := []string{
+		"gamma:1:2",
+		"uniform:1",
+		"uniform:2:1",
+		"exponential:0",
+		"lognormal:0:-1",
+		"pareto:0.01:x",
+		"pareto:0.01:NaN",
+	}
+	for _, s := range invalid {
+		if _, err := parseAmountDist(s); err == nil {
+			t.Errorf("parseAmountDist(%q) expected error", s)
+		}
+	}
+}
+
+func TestAmountDistSample(t *testing.T) {
+	tests := []struct {
+		dist string
+		mean float64
+	}{
+		{"uniform:0.5:1.5", 1},
+		{"exponential:1", 1},
+		{"lognormal:0:0.5", math.Exp(0.125)},
+		{"pareto:0.5:3", 0.75},
+	}
+	r := rand.New(rand.NewSource(1))
+	max := btcutil.Amount(100 * btcutil.SatoshiPerBitcoin)
+	for _, test := range tests {
+		d, err := parseAmountDist(test.dist)
+		if err != nil {
+			t.Fatalf("parseAmountDist error: %v", err)
+		}
+		const n = 20000
+		var sum float64
+		for i := 0; i < n; i++ {
+			amt := d.sample(r, max)
+			if amt < 0 || amt > max {
+				t.Fatalf("%s: sample %v out of range", test.dist, amt)
+			}
+			sum += amt.ToBTC()
+		}
+		if mean := sum / n; math.Abs(mean-test.mean) > 0.05*test.mean {
+			t.Errorf("%s: mean %v want %v", test.dist, mean, test.mean)
+		}
+	}
+
+	d, _ := parseAmountDist("uniform:2:3")
+	if amt := d.sample(r, btcutil.SatoshiPerBitcoin); amt != btcutil.SatoshiPerBitcoin {
+		t.Errorf("sample got %v want it capped to 1 BTC", amt)
+	}
+}
+
+func TestReadProfilesAmounts(t *testing.T) {
+	ps, err := readProfiles(strings.NewReader("payer,1,1,1,random,,exponential:0.1"))
+	if err != nil {
+		t.Fatalf("readProfiles error: %v", err)
+	}
+	if ps[0].Amounts == nil || ps[0].Amounts.String() != "exponential:0.1" {
+		t.Errorf("readProfiles got amounts %v want exponential:0.1", ps[0].Amounts)
+	}
+	if _, err := readProfiles(strings.NewReader("payer,1,1,1,random,0,pareto")); err == nil {
+		t.Errorf("readProfiles expected error")
+	}
+}
@@ -0,0 +1,183 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Assertions check a value of the simulation when they are triggered and
+// fail the run if it does not compare as expected:
+//
+//   assert actor 2 balance >= 100 at block 50
+//   assert reorg depth == 3
+//   at 10m assert mempool < 5000
+//
+// Assertions without a trigger are checked when the simulation ends, those
+// whose trigger is not reached by then fail. The
+// values are the balance in BTC of an actor, the deepest reorg so far,
+// the height and mempool size of the node and the number of transactions
+// sent.
+
+// assertOps are the comparison operators of assertions
+var assertOps = map[string]func(a, b float64) bool{
+	"==": func(a, b float64) bool { return a == b },
+	"!=": func(a, b float64) bool { return a != b },
+	"<":  func(a, b float64) bool { return a < b },
+	"<=": func(a, b float64) bool { return a <= b },
+	">":  func(a, b float64) bool { return a > b },
+	">=": func(a, b float64) bool { return a >= b },
+}
+
+// assertAction compares a value of the simulation with an expected value
+type assertAction struct {
+	subject string
+	actor   int
+	op      string
+	value   float64
+}
+
+// assertLine moves the trigger of an assertion written as 'assert <value>
+// <op> <expected> at ...' in front of it, as for every other action.
+// Assertions without a trigger are checked at the end.
+func assertLine(fields []string) []string {
+	for i, f := range fields {
+		if f == "at" {
+			return append(append([]string(nil), fields[i:]...), fields[:i]...)
+		}
+	}
+	return append([]string{"at", "end"}, fields...)
+}
+
+// parseAssertAction parses 'assert <value> <op> <expected>' where value is
+// 'actor <i> balance', 'reorg depth', 'height', 'mempool' or 'txs'
+func parseAssertAction(args []string) (scenarioAction, error) {
+	usage := errors.New("expected 'assert <value> <op> <expected>' with value " +
+		"'actor <i> balance', 'reorg depth', 'height', 'mempool' or 'txs'")
+	if len(args) < 3 {
+		return nil, usage
+	}
+	n := len(args)
+	a := &assertAction{subject: strings.Join(args[:n-2], " "), op: args[n-2]}
+	if _, ok := assertOps[a.op]; !ok {
+		return nil, fmt.Errorf("unknown operator %q", a.op)
+	}
+	value, err := strconv.ParseFloat(args[n-1], 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expected value %q", args[n-1])
+	}
+	a.value = value
+
+	switch subject := args[:n-2]; {
+	case len(subject) == 3 && subject[0] == "actor" && subject[2] == "balance":
+		if a.actor, err = strconv.Atoi(subject[1]); err != nil {
+			return nil, fmt.Errorf("invalid actor index %q", subject[1])
+		}
+	case a.subject == "reorg depth", a.subject == "height", a.subject == "mempool",
+		a.subject == "txs":
+	default:
+		return nil, usage
+	}
+	return a, nil
+}
+
+func (a *assertAction) String() string {
+	return fmt.Sprintf("assert %s %s %v", a.subject, a.op, a.value)
+}
+
+// actual returns the current value of the subject of the assertion
+func (a *assertAction) actual(sc *Scenario) (float64, error) {
+	switch a.subject {
+	case "reorg depth":
+		return float64(sc.reorgDepth()), nil
+	case "height":
+		return float64(sc.com.mempool.Height()), nil
+	case "mempool":
+		return float64(sc.com.mempool.Size()), nil
+	case "txs":
+		return float64(sc.com.txStats.Count()), nil
+	}
+	actor, err := sc.actor(a.actor)
+	if err != nil {
+		return 0, err
+	}
+	if actor.wallet != nil {
+		return 0, errors.New("balances need a btcwallet actor")
+	}
+	balance, err := actor.client.GetBalance("")
+	if err != nil {
+		return 0, err
+	}
+	return balance.ToBTC(), nil
+}
+
+func (a *assertAction) run(sc *Scenario) error {
+	actual, err := a.actual(sc)
+	if err == nil && !assertOps[a.op](actual, a.value) {
+		err = fmt.Errorf("got %v", actual)
+	}
+	sc.assertMtx.Lock()
+	sc.assertions++
+	if err != nil {
+		sc.failures = append(sc.failures, fmt.Sprintf("%s: %v", a, err))
+	}
+	sc.assertMtx.Unlock()
+	return err
+}
+
+// Failures returns the number of assertions checked and the description
+// of those which failed
+func (sc *Scenario) Failures() (int, []string) {
+	sc.assertMtx.Lock()
+	defer sc.assertMtx.Unlock()
+	return sc.assertions, append([]string(nil), sc.failures...)
+}
+
+// observeReorg follows the depth of the reorgs of the node from the blocks
+// it disconnects and connects
+func (sc *Scenario) observeReorg(e *Event) {
+	sc.assertMtx.Lock()
+	defer sc.assertMtx.Unlock()
+	switch {
+	case e.Kind == eventReorg:
+		sc.depth++
+		if sc.depth > sc.maxDepth {
+			sc.maxDepth = sc.depth
+		}
+	case e.Node == 0:
+		sc.depth = 0
+	}
+}
+
+// reorgDepth returns the number of blocks disconnected by the deepest
+// reorg so far
+func (sc *Scenario) reorgDepth() int {
+	sc.assertMtx.Lock()
+	defer sc.assertMtx.Unlock()
+	return sc.maxDepth
+}
+
+// finish triggers the events of the end of the scenario and fails the
+// assertions the simulation stopped before
+func (sc *Scenario) finish() {
+	for _, e := range sc.endEvents {
+		sc.trigger(e)
+	}
+
+	sc.assertMtx.Lock()
+	defer sc.assertMtx.Unlock()
+	for _, events := range [][]*scenarioEvent{sc.blockEvents, sc.timeEvents} {
+		for _, e := range events {
+			if _, ok := e.action.(*assertAction); !ok || e.triggered {
+				continue
+			}
+			sc.assertions++
+			sc.failures = append(sc.failures, fmt.Sprintf("%s: not checked before the simulation stopped", e))
+		}
+	}
+}
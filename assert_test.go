@@ -0,0 +1,101 @@
+package btcsim
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadAssertions(t *testing.T) {
+	sc, err := readScenario(strings.NewReader(`
+assert actor 2 balance >= 100 at block 50
+assert reorg depth == 3
+at 10m assert mempool < 5000
+at end assert txs > 0
+`))
+	if err != nil {
+		t.Fatalf("readScenario error: %v", err)
+	}
+	if len(sc.blockEvents) != 1 || len(sc.timeEvents) != 1 || len(sc.endEvents) != 2 {
+		t.Fatalf("got %d block, %d time and %d end events, want 1, 1 and 2",
+			len(sc.blockEvents), len(sc.timeEvents), len(sc.endEvents))
+	}
+	want := []string{
+		"line 2: at block 50 assert actor 2 balance >= 100",
+		"line 3: at end assert reorg depth == 3",
+		"line 5: at end assert txs > 0",
+		"line 4: at 10m0s assert mempool < 5000",
+	}
+	events := append(append(sc.blockEvents, sc.endEvents...), sc.timeEvents...)
+	for i, e := range events {
+		if e.String() != want[i] {
+			t.Errorf("event %d got %q, want %q", i, e, want[i])
+		}
+	}
+	if a := sc.blockEvents[0].action.(*assertAction); a.actor != 2 || a.value != 100 {
+		t.Errorf("unexpected assertion %+v", a)
+	}
+}
+
+func TestReadAssertionErrors(t *testing.T) {
+	tests := []string{
+		"assert",
+		"assert txs > ",
+		"assert txs ~ 5",
+		"assert txs > many",
+		"assert actor x balance > 5",
+		"assert actor 1 utxos > 5",
+		"assert reorg == 3",
+		"assert txs > 5 at",
+	}
+	for _, test := range tests {
+		if _, err := readScenario(strings.NewReader(test)); err == nil {
+			t.Errorf("readScenario(%q) expected error", test)
+		}
+	}
+}
+
+func TestAssertReorgDepth(t *testing.T) {
+	sc := &Scenario{com: &Communication{mempool: newMempoolTracker(), txStats: NewTxStats(DefaultConfig())}}
+	// a reorg of 2 blocks, then one of 1 block seen by another node first
+	for _, e := range []*Event{
+		{Kind: eventReorg}, {Kind: eventReorg},
+		{Kind: eventBlockConnected}, {Kind: eventBlockConnected},
+		{Kind: eventReorg}, {Kind: eventBlockConnected, Node: 1},
+		{Kind: eventBlockConnected},
+	} {
+		sc.observeReorg(e)
+	}
+	pass := &assertAction{subject: "reorg depth", op: "==", value: 2}
+	fail := &assertAction{subject: "txs", op: ">", value: 0}
+	if err := pass.run(sc); err != nil {
+		t.Errorf("%v failed: %v", pass, err)
+	}
+	if err := fail.run(sc); err == nil {
+		t.Errorf("%v passed", fail)
+	}
+	n, failures := sc.Failures()
+	if n != 2 || len(failures) != 1 || failures[0] != "assert txs > 0: got 0" {
+		t.Errorf("Failures got %d, %q", n, failures)
+	}
+}
+
+func TestAssertPendingAtEnd(t *testing.T) {
+	sc, err := readScenario(strings.NewReader("assert txs >= 0 at block 10\n" +
+		"assert txs >= 0 at block 500\nat 1h assert txs >= 0\nassert txs >= 0\n"))
+	if err != nil {
+		t.Fatalf("readScenario error: %v", err)
+	}
+	sc.com = &Communication{mempool: newMempoolTracker(), txStats: NewTxStats(DefaultConfig())}
+	sc.trigger(sc.blockEvents[0])
+	sc.finish()
+
+	n, failures := sc.Failures()
+	want := []string{
+		"line 2: at block 500 assert txs >= 0: not checked before the simulation stopped",
+		"line 3: at 1h0m0s assert txs >= 0: not checked before the simulation stopped",
+	}
+	if n != 4 || !reflect.DeepEqual(failures, want) {
+		t.Errorf("Failures got %d, %q want 4, %q", n, failures, want)
+	}
+}
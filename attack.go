@@ -0,0 +1,333 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/btcsuite/btcd/wire"
+)
+
+// Attack strategies accepted by the -attack flag
+const (
+	// attackHonest publishes every block as soon as it is mined, it is
+	// the baseline the other strategies are compared to
+	attackHonest = "honest"
+
+	// attackPrivate withholds blocks until its private chain is longer
+	// than the public one after at least attackConfs public blocks, so
+	// that releasing it reverses transactions with as many confirmations.
+	// It gives up once the public chain leads by attackGiveUp blocks.
+	attackPrivate = "private"
+
+	// attackSelfish is the selfish mining strategy of Eyal and Sirer, it
+	// withholds blocks and only publishes them to win races against the
+	// honest miner. Ties are always won by the honest miner since it
+	// sees its own block first.
+	attackSelfish = "selfish"
+)
+
+// branch is the state of the chain of an attacker since it forked from
+// the public chain
+type branch struct {
+	// private is the number of blocks mined by the attacker, of which
+	// published have been published
+	private   int
+	published int
+
+	// honest is the number of blocks mined by the honest miner
+	honest int
+}
+
+// attackStrategy decides what an attacker does after each block. It
+// returns the number of private blocks which must be published, or
+// whether the attacker abandons its branch for the public chain.
+type attackStrategy interface {
+	onAttackerBlock(b *branch) (publish int, adopt bool)
+	onHonestBlock(b *branch) (publish int, adopt bool)
+}
+
+// honestStrategy publishes every block right away
+type honestStrategy struct{}
+
+func (s *honestStrategy) onAttackerBlock(b *branch) (int, bool) {
+	return b.private, false
+}
+
+func (s *honestStrategy) onHonestBlock(b *branch) (int, bool) {
+	return b.published, true
+}
+
+// privateStrategy mines a private chain to reverse confirmations
+type privateStrategy struct {
+	confs  int
+	giveUp int
+}
+
+func (s *privateStrategy) onAttackerBlock(b *branch) (int, bool) {
+	return s.decide(b)
+}
+
+func (s *privateStrategy) onHonestBlock(b *branch) (int, bool) {
+	if b.honest-b.private >= s.giveUp {
+		return b.published, true
+	}
+	return s.decide(b)
+}
+
+// decide releases the private chain once it reverses enough blocks
+func (s *privateStrategy) decide(b *branch) (int, bool) {
+	if b.honest >= s.confs && b.private > b.honest {
+		return b.private, false
+	}
+	return b.published, false
+}
+
+// selfishStrategy is the selfish mining strategy
+type selfishStrategy struct{}
+
+func (s *selfishStrategy) onAttackerBlock(b *branch) (int, bool) {
+	// the attacker was racing the honest miner with a chain of the same
+	// length, its new block wins the race
+	if b.honest > 0 && b.private-1 == b.honest {
+		return b.private, false
+	}
+	return b.published, false
+}
+
+func (s *selfishStrategy) onHonestBlock(b *branch) (int, bool) {
+	switch lead := b.private - (b.honest - 1); {
+	case lead <= 0:
+		// the honest chain is longer
+		return b.published, true
+	case lead == 1, lead == 2:
+		// race the honest block, or win with a longer chain
+		return b.private, false
+	default:
+		// match the honest chain while keeping the lead
+		return b.published + 1, false
+	}
+}
+
+// attackStrategies maps the names accepted by the -attack flag to the
+// functions creating the strategies of the run configured by cfg
+var attackStrategies = map[string]func(cfg *Config) attackStrategy{
+	attackHonest: func(cfg *Config) attackStrategy {
+		return &honestStrategy{}
+	},
+	attackPrivate: func(cfg *Config) attackStrategy {
+		return &privateStrategy{confs: cfg.AttackConfs, giveUp: cfg.AttackGiveUp}
+	},
+	attackSelfish: func(cfg *Config) attackStrategy {
+		return &selfishStrategy{}
+	},
+}
+
+// getAttackStrategy returns a new strategy with the given name for the run
+// configured by cfg
+func getAttackStrategy(cfg *Config, name string) (attackStrategy, error) {
+	newStrategy, ok := attackStrategies[name]
+	if !ok {
+		names := make([]string, 0, len(attackStrategies))
+		for name := range attackStrategies {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown attack strategy %q, valid strategies are: %s",
+			name, strings.Join(names, ", "))
+	}
+	return newStrategy(cfg), nil
+}
+
+// AttackStats are the statistics of an attack, blocks of branches which
+// are not resolved when the simulation ends are not counted
+type AttackStats struct {
+	Strategy string  `json:"strategy"`
+	Power    float64 `json:"power"`
+
+	// AttackerBlocks and HonestBlocks are the blocks mined by the attacker
+	// and the honest miner which ended in the public chain, Orphaned and
+	// Reversed those which did not
+	AttackerBlocks int `json:"attackerblocks"`
+	HonestBlocks   int `json:"honestblocks"`
+	Orphaned       int `json:"orphaned"`
+	Reversed       int `json:"reversed"`
+
+	// RevenueShare is the fraction of the blocks of the public chain mined
+	// by the attacker, to compare with its power
+	RevenueShare float64 `json:"revenueshare"`
+
+	// Reorgs is the number of times the public chain was replaced by the
+	// chain of the attacker and MaxReorgDepth the most blocks replaced
+	Reorgs        int `json:"reorgs"`
+	MaxReorgDepth int `json:"maxreorgdepth"`
+
+	// Attempts is the number of private branches resolved and Successes
+	// those which reversed at least Confs blocks
+	Confs     int `json:"confs"`
+	Attempts  int `json:"attempts"`
+	Successes int `json:"successes"`
+}
+
+// attacker mines a share of the blocks of the simulation on an isolated
+// fork miner and publishes them according to its strategy
+type attacker struct {
+	fork     *forkMiner
+	miner    *Miner
+	strategy attackStrategy
+	power    float64
+	rand     *rand.Rand
+	started  bool
+
+	// blocks are the hashes of the blocks of the current branch
+	branch branch
+	blocks []*wire.ShaHash
+
+	stats AttackStats
+}
+
+// newAttacker returns an attacker mining the given fraction of blocks
+// on the fork miner, competing with miner
+func newAttacker(fork *forkMiner, miner *Miner, strategy string, power float64) (*attacker, error) {
+	cfg := miner.cfg
+	s, err := getAttackStrategy(cfg, strategy)
+	if err != nil {
+		return nil, err
+	}
+	return &attacker{
+		fork:     fork,
+		miner:    miner,
+		strategy: s,
+		power:    power,
+		rand:     cfg.newRand(attackStream),
+		stats: AttackStats{
+			Strategy: strategy,
+			Power:    power,
+			Confs:    cfg.AttackConfs,
+		},
+	}, nil
+}
+
+// Mine mines blocks until the honest miner mines one, each block being
+// mined by the attacker with the probability of its power. The blocks
+// after the first one are mined according to the miner schedule.
+func (at *attacker) Mine(exit <-chan struct{}) error {
+	if !at.started {
+		// isolate the fork miner once it follows the public chain
+		if err := waitSync(at.fork.client, at.miner.client, exit); err != nil {
+			return err
+		}
+		at.fork.link.SetCut(true)
+		at.started = true
+	}
+
+	for {
+		if at.rand.Float64() >= at.power {
+			if err := at.miner.Generate(1); err != nil {
+				return err
+			}
+			at.branch.honest++
+			publish, adopt := at.strategy.onHonestBlock(&at.branch)
+			return at.apply(publish, adopt, exit)
+		}
+
+		hashes, err := at.fork.client.Generate(1)
+		if err != nil {
+			log.Errorf("%s: Cannot generate block: %v", at.fork, err)
+			return err
+		}
+		at.blocks = append(at.blocks, hashes...)
+		at.branch.private++
+		publish, adopt := at.strategy.onAttackerBlock(&at.branch)
+		if err := at.apply(publish, adopt, exit); err != nil {
+			return err
+		}
+
+		if at.miner.schedule != scheduleOnDemand {
+			select {
+			case <-time.After(at.miner.nextBlockDelay()):
+			case <-exit:
+				return nil
+			}
+		}
+	}
+}
+
+// apply publishes private blocks up to publish, or abandons the branch
+// for the public chain if adopt is set
+func (at *attacker) apply(publish int, adopt bool, exit <-chan struct{}) error {
+	b := &at.branch
+	if adopt {
+		at.stats.HonestBlocks += b.honest
+		at.stats.Orphaned += b.private
+		if b.private > 0 {
+			at.stats.Attempts++
+		}
+		at.reset()
+
+		// follow the public chain again
+		at.fork.link.SetCut(false)
+		defer at.fork.link.SetCut(true)
+		return waitSync(at.fork.client, at.miner.client, exit)
+	}
+
+	for b.published < publish {
+		block, err := at.fork.client.GetBlock(at.blocks[b.published])
+		if err != nil {
+			log.Errorf("%s: Cannot get block: %v", at.fork, err)
+			return err
+		}
+		if err := at.miner.client.SubmitBlock(block, nil); err != nil {
+			log.Errorf("%s: Cannot submit block: %v", at.miner, err)
+			return err
+		}
+		b.published++
+	}
+
+	// the public chain switches to the private one once it is longer
+	if b.published == b.private && b.private > b.honest {
+		at.stats.AttackerBlocks += b.private
+		at.stats.Reversed += b.honest
+		if b.honest > 0 {
+			at.stats.Reorgs++
+			if b.honest > at.stats.MaxReorgDepth {
+				at.stats.MaxReorgDepth = b.honest
+			}
+			log.Infof("%s: Reversed %d block(s) with %d private block(s)",
+				at.fork, b.honest, b.private)
+		}
+		at.stats.Attempts++
+		if b.honest >= at.stats.Confs {
+			at.stats.Successes++
+		}
+		at.reset()
+	}
+	return nil
+}
+
+// reset starts a new branch from the tip of the public chain
+func (at *attacker) reset() {
+	at.branch = branch{}
+	at.blocks = nil
+}
+
+// Stats returns the statistics of the attack, it must only be called
+// once the simulation is shut down
+func (at *attacker) Stats() *AttackStats {
+	s := at.stats
+	if total := s.AttackerBlocks + s.HonestBlocks; total > 0 {
+		s.RevenueShare = float64(s.AttackerBlocks) / float64(total)
+	}
+	return &s
+}
+
+// Shutdown stops the fork miner of the attacker
+func (at *attacker) Shutdown() {
+	at.fork.Shutdown()
+}
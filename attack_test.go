@@ -0,0 +1,118 @@
+package btcsim
+
+import "testing"
+
+func TestPrivateStrategy(t *testing.T) {
+	s := &privateStrategy{confs: 2, giveUp: 3}
+	tests := []struct {
+		attacker bool
+		b        branch
+		publish  int
+		adopt    bool
+	}{
+		// not enough confirmations to reverse yet
+		{true, branch{private: 2, honest: 1}, 0, false},
+		// the private chain is longer after enough confirmations
+		{true, branch{private: 3, honest: 2}, 3, false},
+		{false, branch{private: 4, honest: 3}, 4, false},
+		// the private chain is not longer
+		{false, branch{private: 2, honest: 2}, 0, false},
+		// the public chain leads by giveUp blocks
+		{false, branch{private: 1, honest: 4}, 0, true},
+	}
+	for i, test := range tests {
+		var publish int
+		var adopt bool
+		if test.attacker {
+			publish, adopt = s.onAttackerBlock(&test.b)
+		} else {
+			publish, adopt = s.onHonestBlock(&test.b)
+		}
+		if publish != test.publish || adopt != test.adopt {
+			t.Errorf("#%d: got %d, %v want %d, %v", i, publish, adopt,
+				test.publish, test.adopt)
+		}
+	}
+}
+
+func TestHonestStrategy(t *testing.T) {
+	s := &honestStrategy{}
+	if publish, adopt := s.onAttackerBlock(&branch{private: 1}); publish != 1 || adopt {
+		t.Errorf("onAttackerBlock got %d, %v want 1, false", publish, adopt)
+	}
+	if _, adopt := s.onHonestBlock(&branch{honest: 1}); !adopt {
+		t.Errorf("onHonestBlock got no adopt")
+	}
+}
+
+func TestAttackerResolve(t *testing.T) {
+	at := &attacker{stats: AttackStats{Confs: 2}}
+
+	// a private chain already published and longer than the public one
+	at.branch = branch{private: 3, published: 3, honest: 2}
+	if err := at.apply(3, false, nil); err != nil {
+		t.Fatalf("apply error: %v", err)
+	}
+	want := AttackStats{Confs: 2, AttackerBlocks: 3, Reversed: 2, Reorgs: 1,
+		MaxReorgDepth: 2, Attempts: 1, Successes: 1}
+	if at.stats != want {
+		t.Errorf("got stats %+v want %+v", at.stats, want)
+	}
+	if at.branch != (branch{}) {
+		t.Errorf("branch not reset: %+v", at.branch)
+	}
+}
+
+func TestGetAttackStrategy(t *testing.T) {
+	if _, err := getAttackStrategy(DefaultConfig(), "private"); err != nil {
+		t.Errorf("getAttackStrategy error: %v", err)
+	}
+	if _, err := getAttackStrategy(DefaultConfig(), "nice"); err == nil {
+		t.Errorf("getAttackStrategy expected error")
+	}
+}
+
+func TestSelfishStrategy(t *testing.T) {
+	s := &selfishStrategy{}
+	tests := []struct {
+		attacker bool
+		b        branch
+		publish  int
+		adopt    bool
+	}{
+		// withhold the first block
+		{true, branch{private: 1}, 0, false},
+		// the honest miner catches up, race it
+		{false, branch{private: 1, honest: 1}, 1, false},
+		// win the race
+		{true, branch{private: 2, published: 1, honest: 1}, 2, false},
+		// lose the race
+		{false, branch{private: 1, published: 1, honest: 2}, 1, true},
+		// a lead of two is published to win
+		{false, branch{private: 2, honest: 1}, 2, false},
+		// a larger lead only matches the honest chain
+		{false, branch{private: 4, published: 1, honest: 2}, 2, false},
+		// the honest miner found a block first
+		{false, branch{honest: 1}, 0, true},
+	}
+	for i, test := range tests {
+		var publish int
+		var adopt bool
+		if test.attacker {
+			publish, adopt = s.onAttackerBlock(&test.b)
+		} else {
+			publish, adopt = s.onHonestBlock(&test.b)
+		}
+		if publish != test.publish || adopt != test.adopt {
+			t.Errorf("#%d: got %d, %v want %d, %v", i, publish, adopt,
+				test.publish, test.adopt)
+		}
+	}
+}
+
+func TestAttackerStats(t *testing.T) {
+	at := &attacker{stats: AttackStats{AttackerBlocks: 3, HonestBlocks: 9}}
+	if got := at.Stats().RevenueShare; got != 0.25 {
+		t.Errorf("got revenue share %v, want 0.25", got)
+	}
+}
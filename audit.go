@@ -0,0 +1,200 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// Kinds of differences between a wallet and the chain found by the audit
+const (
+	// auditSpent is an output the wallet lists as unspent which is spent
+	// or unknown to the chain
+	auditSpent = "spent-output"
+
+	// auditAmount is an unspent output whose amount differs between the
+	// wallet and the chain
+	auditAmount = "amount-mismatch"
+
+	// auditStaleBlock is a transaction the wallet has confirmed in a
+	// block which is not part of the main chain
+	auditStaleBlock = "stale-block"
+
+	// auditUnconfirmed is an unconfirmed transaction of the wallet which
+	// is not in the mempool, such as a double spend which lost
+	auditUnconfirmed = "not-in-mempool"
+)
+
+const (
+	// auditMaxTxs is the maximum number of transactions of every wallet
+	// cross-checked with the chain
+	auditMaxTxs = 1 << 20
+
+	// auditMaxConf is the maximum number of confirmations of the unspent
+	// outputs listed, the default of listunspent
+	auditMaxConf = 9999999
+)
+
+// auditIssue is a difference between the view of a wallet and the chain
+type auditIssue struct {
+	actor  string
+	kind   string
+	txid   string
+	vout   uint32
+	detail string
+}
+
+// auditChain is the view of the chain the wallets are checked against
+type auditChain interface {
+	GetBlockCount() (int64, error)
+	GetBlockHash(height int64) (*wire.ShaHash, error)
+	GetRawMempool() ([]*wire.ShaHash, error)
+	GetTxOut(txHash *wire.ShaHash, index uint32, mempool bool) (*btcjson.GetTxOutResult, error)
+}
+
+// auditWallet cross-checks the unspent outputs and transactions listed by
+// the wallet of an actor with the chain and returns the differences
+func auditWallet(actor string, unspent []btcjson.ListUnspentResult,
+	txs []btcjson.ListTransactionsResult, chain auditChain) ([]*auditIssue, error) {
+	var issues []*auditIssue
+
+	for _, u := range unspent {
+		hash, err := wire.NewShaHashFromStr(u.TxID)
+		if err != nil {
+			return nil, err
+		}
+		out, err := chain.GetTxOut(hash, u.Vout, true)
+		if err != nil {
+			return nil, err
+		}
+		if out == nil {
+			issues = append(issues, &auditIssue{actor, auditSpent, u.TxID, u.Vout,
+				"listed as unspent by the wallet"})
+			continue
+		}
+		if out.Value != u.Amount {
+			issues = append(issues, &auditIssue{actor, auditAmount, u.TxID, u.Vout,
+				fmt.Sprintf("wallet %v BTC, chain %v BTC", u.Amount, out.Value)})
+		}
+	}
+
+	best, err := chain.GetBlockCount()
+	if err != nil {
+		return nil, err
+	}
+	mempool, err := chain.GetRawMempool()
+	if err != nil {
+		return nil, err
+	}
+	pooled := make(map[string]bool, len(mempool))
+	for _, hash := range mempool {
+		pooled[hash.String()] = true
+	}
+	// a transaction is listed once per output or input of the wallet
+	seen := make(map[string]bool)
+	for _, tx := range txs {
+		if seen[tx.TxID] {
+			continue
+		}
+		seen[tx.TxID] = true
+		if tx.Confirmations <= 0 {
+			if !pooled[tx.TxID] {
+				issues = append(issues, &auditIssue{actor, auditUnconfirmed, tx.TxID, tx.Vout,
+					"unconfirmed in the wallet"})
+			}
+			continue
+		}
+		height := best - tx.Confirmations + 1
+		hash, err := chain.GetBlockHash(height)
+		if err != nil {
+			return nil, err
+		}
+		if hash.String() != tx.BlockHash {
+			issues = append(issues, &auditIssue{actor, auditStaleBlock, tx.TxID, tx.Vout,
+				fmt.Sprintf("confirmed in block %s at height %d, main chain has %v",
+					tx.BlockHash, height, hash)})
+		}
+	}
+	return issues, nil
+}
+
+// auditWallets cross-checks the wallet of every actor with the chain as
+// seen through the wallet, logging the differences found
+func (com *Communication) auditWallets(actors []*Actor) {
+	var audited int
+	for _, a := range actors {
+		if a.client == nil || a.wallet != nil {
+			continue
+		}
+		unspent, err := a.client.ListUnspentMinMax(0, auditMaxConf)
+		if err != nil {
+			log.Errorf("%s: Cannot list unspent outputs: %v", a, err)
+			continue
+		}
+		txs, err := a.client.ListTransactionsCount("*", auditMaxTxs)
+		if err != nil {
+			log.Errorf("%s: Cannot list transactions: %v", a, err)
+			continue
+		}
+		issues, err := auditWallet(a.String(), unspent, txs, a.client)
+		if err != nil {
+			log.Errorf("%s: Cannot audit wallet: %v", a, err)
+			continue
+		}
+		for _, issue := range issues {
+			log.Warnf("%s: Audit found %s %s:%d, %s", a, issue.kind, issue.txid,
+				issue.vout, issue.detail)
+		}
+		audited++
+		com.auditIssues = append(com.auditIssues, issues...)
+	}
+	log.Infof("Audited %d wallets, found %d differences with the chain", audited,
+		len(com.auditIssues))
+}
+
+// writeAuditCSV writes the differences found by the audit as CSV with a
+// header row
+func writeAuditCSV(w io.Writer, issues []*auditIssue) error {
+	writer := csv.NewWriter(w)
+	header := []string{"actor", "kind", "txid", "vout", "detail"}
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+	for _, issue := range issues {
+		row := []string{
+			issue.actor,
+			issue.kind,
+			issue.txid,
+			strconv.Itoa(int(issue.vout)),
+			issue.detail,
+		}
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// writeAudit writes the differences found by the audit to the given path
+// as CSV
+func writeAudit(path string, issues []*auditIssue) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := writeAuditCSV(file, issues); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
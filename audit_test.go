@@ -0,0 +1,90 @@
+package btcsim
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// fakeChain is a chain of the given block hashes whose unspent outputs are
+// looked up by index only
+type fakeChain struct {
+	hashes  []*wire.ShaHash
+	outs    map[uint32]*btcjson.GetTxOutResult
+	mempool []*wire.ShaHash
+}
+
+func (c *fakeChain) GetBlockCount() (int64, error) {
+	return int64(len(c.hashes) - 1), nil
+}
+
+func (c *fakeChain) GetBlockHash(height int64) (*wire.ShaHash, error) {
+	return c.hashes[height], nil
+}
+
+func (c *fakeChain) GetRawMempool() ([]*wire.ShaHash, error) {
+	return c.mempool, nil
+}
+
+func (c *fakeChain) GetTxOut(txHash *wire.ShaHash, index uint32, mempool bool) (*btcjson.GetTxOutResult, error) {
+	return c.outs[index], nil
+}
+
+func TestAuditWallet(t *testing.T) {
+	chain := &fakeChain{
+		hashes: []*wire.ShaHash{{0}, {1}, {2}, {3}},
+		outs: map[uint32]*btcjson.GetTxOutResult{
+			0: {Value: 1},
+			1: {Value: 2},
+		},
+		mempool: []*wire.ShaHash{{4}},
+	}
+	unspent := []btcjson.ListUnspentResult{
+		{TxID: "a", Vout: 0, Amount: 1},
+		{TxID: "b", Vout: 1, Amount: 1.5},
+		{TxID: "c", Vout: 2, Amount: 3},
+	}
+	txs := []btcjson.ListTransactionsResult{
+		// confirmed in the main chain at height 2, listed twice
+		{TxID: "d", Confirmations: 2, BlockHash: chain.hashes[2].String()},
+		{TxID: "d", Confirmations: 2, BlockHash: chain.hashes[2].String()},
+		// confirmed in a block replaced by a reorg
+		{TxID: "e", Confirmations: 1, BlockHash: "stale"},
+		// unconfirmed, in the mempool or not
+		{TxID: chain.mempool[0].String()},
+		{TxID: "f", Vout: 1},
+	}
+	issues, err := auditWallet("actor", unspent, txs, chain)
+	if err != nil {
+		t.Fatalf("auditWallet error: %v", err)
+	}
+	want := []string{
+		"b " + auditAmount,
+		"c " + auditSpent,
+		"e " + auditStaleBlock,
+		"f " + auditUnconfirmed,
+	}
+	if len(issues) != len(want) {
+		t.Fatalf("auditWallet got %d issues, want %d", len(issues), len(want))
+	}
+	for i, issue := range issues {
+		if got := issue.txid + " " + issue.kind; got != want[i] || issue.actor != "actor" {
+			t.Errorf("issue %d got %s of %s, want %s", i, got, issue.actor, want[i])
+		}
+	}
+}
+
+func TestWriteAuditCSV(t *testing.T) {
+	var buf bytes.Buffer
+	issues := []*auditIssue{{"actor", auditSpent, "ab", 1, "listed as unspent by the wallet"}}
+	if err := writeAuditCSV(&buf, issues); err != nil {
+		t.Fatalf("writeAuditCSV error: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 || lines[1] != "actor,spent-output,ab,1,listed as unspent by the wallet" {
+		t.Errorf("writeAuditCSV got %q", lines)
+	}
+}
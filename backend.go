@@ -0,0 +1,115 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcd/chaincfg"
+)
+
+// Backends accepted by the -backend flag
+const (
+	// backendBtcd runs btcd nodes on simnet
+	backendBtcd = "btcd"
+
+	// backendBitcoind runs bitcoind nodes on regtest, the miner being a
+	// btcd node on regtest as well
+	backendBitcoind = "bitcoind"
+)
+
+// ChainServer is an Args which launches a chain server node, the backend
+// the simulation runs against
+type ChainServer interface {
+	Args
+
+	// SetListen sets the p2p and rpc listen addresses of the node
+	SetListen(listen, rpcListen string)
+
+	// ListenAddr returns the p2p listen address of the node
+	ListenAddr() string
+
+	// AddPeer adds a peer the node persistently connects to
+	AddPeer(addr string)
+
+	// SetRelayPolicy limits the mempool of the node to maxMempool
+	// megabytes and sets its minimum relay fee rate to minRelayFee
+	// satoshis per byte, a zero limit and a negative rate keep the
+	// defaults of the node
+	SetRelayPolicy(maxMempool int, minRelayFee float64) error
+}
+
+// relayFeeArg returns a fee rate in satoshis per byte in BTC per kilobyte,
+// the unit of the relay fee options of the nodes
+func relayFeeArg(rate float64) string {
+	return strconv.FormatFloat(rate*1000/1e8, 'f', 8, 64)
+}
+
+// backend creates the ChainServer of a node of the run configured by cfg
+// with the given prefix
+type backend func(cfg *Config, prefix string) (ChainServer, error)
+
+// backends maps the names accepted by the -backend flag to the functions
+// which create their chain servers
+var backends = map[string]backend{
+	backendBtcd: func(cfg *Config, prefix string) (ChainServer, error) {
+		return newBtcdArgs(cfg, prefix)
+	},
+	backendBitcoind: func(cfg *Config, prefix string) (ChainServer, error) {
+		return newBitcoindArgs(cfg, prefix)
+	},
+}
+
+// getBackend returns the backend with the given name
+func getBackend(name string) (backend, error) {
+	b, ok := backends[name]
+	if !ok {
+		names := make([]string, 0, len(backends))
+		for name := range backends {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown backend %q, valid backends are: %s",
+			name, strings.Join(names, ", "))
+	}
+	return b, nil
+}
+
+// checkBackend returns an error if the backend is unknown or the simulation
+// needs what its nodes lack. bitcoind only serves rpc over HTTP POST, so
+// btcwallet, which needs the websocket notifications of btcd, and the
+// watch-only actors cannot connect to it. Its nodes are polled for their
+// blocks and transactions instead.
+func checkBackend(name string, inProcess, docker bool, watchOnly int) error {
+	if _, err := getBackend(name); err != nil {
+		return err
+	}
+	if name == backendBtcd {
+		return nil
+	}
+	switch {
+	case !inProcess:
+		return fmt.Errorf("the %s backend needs in-process actors, "+
+			"btcwallet only connects to btcd", name)
+	case watchOnly > 0:
+		return errors.New("watch-only actors need the notifications of btcd nodes")
+	case docker:
+		return fmt.Errorf("%s nodes cannot run in docker containers", name)
+	}
+	return nil
+}
+
+// netParams returns the parameters of the network of the run, simnet with
+// btcd nodes and regtest, which btcd supports as well, with bitcoind nodes
+func (c *Config) netParams() *chaincfg.Params {
+	if c.BackendName == backendBitcoind {
+		return &chaincfg.RegressionNetParams
+	}
+	return &chaincfg.SimNetParams
+}
@@ -0,0 +1,86 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"github.com/btcsuite/btcutil"
+)
+
+// batchSize returns the number of recipients paid by each transaction of
+// the profile, at least one
+func (p *Profile) batchSize() int {
+	if p.Batch > 1 {
+		return p.Batch
+	}
+	return 1
+}
+
+// batchPayment splits amt between several recipients and a change address
+// of the actor, like exchange or mining pool payouts. Each recipient is
+// paid an amount drawn from the amount distribution if any, or else an
+// equal share of the spend fraction of the profile. Recipients which
+// would get less than minFee are skipped and a recipient picked several
+// times is paid once for each time.
+func (p *Profile) batchPayment(a *Actor, tos []btcutil.Address, amt btcutil.Amount) map[btcutil.Address]btcutil.Amount {
+	dist := p.Amounts
+	if dist == nil {
+		dist = a.cfg.defaultAmounts
+	}
+	var share btcutil.Amount
+	if dist == nil {
+		frac := p.MinSpend + a.rand.Float64()*(p.MaxSpend-p.MinSpend)
+		share = btcutil.Amount(float64(amt) * frac / float64(len(tos)))
+	}
+
+	amounts := make(map[btcutil.Address]btcutil.Amount, len(tos)+1)
+	left := amt
+	for _, to := range tos {
+		pay := share
+		if dist != nil {
+			pay = dist.sample(a.rand, left)
+		}
+		if pay < minFee || pay > left {
+			continue
+		}
+		amounts[to] += pay
+		left -= pay
+	}
+	if len(amounts) == 0 {
+		return p.payment(a, tos[0], amt)
+	}
+
+	// the change goes back to the actor unless it is too small, in which
+	// case it is added to the last recipient paid
+	changeAddr := a.ownedAddresses[a.rand.Int()%len(a.ownedAddresses)]
+	if _, ok := amounts[changeAddr]; left >= minFee && !ok {
+		amounts[changeAddr] = left
+		return amounts
+	}
+	for i := len(tos) - 1; i >= 0; i-- {
+		if _, ok := amounts[tos[i]]; ok {
+			amounts[tos[i]] += left
+			break
+		}
+	}
+	return amounts
+}
+
+// paidPayments sets the amounts of the payments to their payees, keyed by
+// their address in paidTo, and returns those paid something, as a batch
+// skips recipients
+func paidPayments(payments []*payment, paidTo map[btcutil.Address]*payment,
+	amounts map[btcutil.Address]btcutil.Amount) []*payment {
+
+	for addr, p := range paidTo {
+		p.amount = amounts[addr]
+	}
+	var paid []*payment
+	for _, p := range payments {
+		if p.amount > 0 {
+			paid = append(paid, p)
+		}
+	}
+	return paid
+}
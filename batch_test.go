@@ -0,0 +1,77 @@
+package btcsim
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcutil"
+)
+
+// fakeAddress is an address told apart by its string only
+type fakeAddress string
+
+func (a fakeAddress) String() string            { return string(a) }
+func (a fakeAddress) EncodeAddress() string     { return string(a) }
+func (a fakeAddress) ScriptAddress() []byte     { return []byte(a) }
+func (a fakeAddress) IsForNet(interface{}) bool { return true }
+
+func TestBatchPayment(t *testing.T) {
+	cfg := DefaultConfig()
+	a := &Actor{
+		Node:           &Node{cfg: cfg},
+		rand:           cfg.newRand(actorStream),
+		ownedAddresses: []btcutil.Address{fakeAddress("change")},
+	}
+	p := &Profile{MinSpend: 0.5, MaxSpend: 0.5, Batch: 4}
+	tos := []btcutil.Address{
+		fakeAddress("a"), fakeAddress("b"), fakeAddress("c"), fakeAddress("a"),
+	}
+	amt := btcutil.Amount(1e8)
+	amounts := p.batchPayment(a, tos, amt)
+
+	var total btcutil.Amount
+	for _, v := range amounts {
+		total += v
+	}
+	if total != amt {
+		t.Errorf("batchPayment pays %v want %v", total, amt)
+	}
+	if len(amounts) != 4 {
+		t.Errorf("batchPayment got %d outputs want 4", len(amounts))
+	}
+	if amounts[fakeAddress("a")] != amt/4 || amounts[fakeAddress("b")] != amt/8 {
+		t.Errorf("batchPayment got %v want a recipient picked twice paid twice", amounts)
+	}
+
+	// nobody is paid less than minFee, falling back to a single payment
+	amounts = p.batchPayment(a, tos, 4*minFee)
+	if len(amounts) != 2 || amounts[fakeAddress("a")] != 2*minFee {
+		t.Errorf("batchPayment got %v want a single payment", amounts)
+	}
+}
+
+func TestReadProfilesBatch(t *testing.T) {
+	ps, err := readProfiles(strings.NewReader("pool,1,1,1,random,,,,,50"))
+	if err != nil {
+		t.Fatalf("readProfiles error: %v", err)
+	}
+	if ps[0].Batch != 50 || ps[0].batchSize() != 50 {
+		t.Errorf("readProfiles got batch size %d want 50", ps[0].Batch)
+	}
+	if _, err := readProfiles(strings.NewReader("pool,1,1,1,random,,,,,-1")); err == nil {
+		t.Errorf("readProfiles expected error")
+	}
+}
+
+func TestPaidPayments(t *testing.T) {
+	a, b := &payment{}, &payment{}
+	payments := []*payment{a, b}
+	paidTo := map[btcutil.Address]*payment{fakeAddress("a"): a, fakeAddress("b"): b}
+
+	// b was skipped by the batch
+	amounts := map[btcutil.Address]btcutil.Amount{fakeAddress("a"): 1e6, fakeAddress("change"): 5e5}
+	paid := paidPayments(payments, paidTo, amounts)
+	if len(paid) != 1 || paid[0] != a || a.amount != 1e6 || b.amount != 0 {
+		t.Errorf("paid %v want only a with 1e6", paid)
+	}
+}
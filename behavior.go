@@ -0,0 +1,352 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+// behaviorPassive is the name of the behavior of actors which only send
+// the payments requested by the simulation
+const behaviorPassive = "passive"
+
+// tipAmount is the amount paid by the tipper behavior on every tick
+const tipAmount btcutil.Amount = 1e5 // 0.001 BTC
+
+// behaviorQueueSize is the number of blocks and payments queued for the
+// behavior of an actor, those received while the queue is full are
+// dropped rather than holding up the simulation
+const behaviorQueueSize = 64
+
+// ActorBehavior drives an actor in addition to the payments requested by
+// the simulation, e.g. to model a service reacting to the payments it
+// receives. The methods are called one at a time from a goroutine of the
+// actor so they may block, and send transactions with the exported
+// methods of the actor.
+type ActorBehavior interface {
+	// OnStart is called once the wallet of the actor is started, the
+	// actor fails to start if it returns an error
+	OnStart(a *Actor) error
+
+	// OnBlock is called when a block is mined, with its height
+	OnBlock(a *Actor, height int32)
+
+	// OnTick is called every -behaviortick of simulated time
+	OnTick(a *Actor)
+
+	// OnPaymentReceived is called when an output paying the actor is
+	// mined and queued to be spent
+	OnPaymentReceived(a *Actor, out *TxOut)
+}
+
+// NopBehavior does nothing, behaviors implementing only some of the
+// methods can embed it
+type NopBehavior struct{}
+
+// OnStart does nothing
+func (NopBehavior) OnStart(a *Actor) error { return nil }
+
+// OnBlock does nothing
+func (NopBehavior) OnBlock(a *Actor, height int32) {}
+
+// OnTick does nothing
+func (NopBehavior) OnTick(a *Actor) {}
+
+// OnPaymentReceived does nothing
+func (NopBehavior) OnPaymentReceived(a *Actor, out *TxOut) {}
+
+// tipper pays a small amount to another actor on every tick
+type tipper struct {
+	NopBehavior
+}
+
+// OnTick pays tipAmount to a random actor
+func (tipper) OnTick(a *Actor) {
+	to := a.Peer()
+	if to == nil {
+		return
+	}
+	payBehavior(a, "tip", map[btcutil.Address]btcutil.Amount{to: tipAmount})
+}
+
+// forwarder forwards a tenth of every output it receives to another actor
+type forwarder struct {
+	NopBehavior
+}
+
+// OnPaymentReceived forwards a tenth of the output to a random actor
+func (forwarder) OnPaymentReceived(a *Actor, out *TxOut) {
+	to := a.Peer()
+	amt := out.Amount / 10
+	if to == nil || amt < minFee {
+		return
+	}
+	payBehavior(a, "forward", map[btcutil.Address]btcutil.Amount{to: amt})
+}
+
+// payBehavior sends a payment of a built-in behavior, logging errors
+// other than a lack of funds which only delays it
+func payBehavior(a *Actor, what string, amounts map[btcutil.Address]btcutil.Amount) {
+	_, err := a.Pay(amounts)
+	switch err {
+	case nil:
+	case ErrInsufficientFunds:
+		log.Debugf("%s: Cannot %s: %v", a, what, err)
+	case ErrActorShutdown:
+	default:
+		log.Errorf("%s: Cannot %s: %v", a, what, err)
+	}
+}
+
+// behaviors are the constructors of the built-in behaviors by name,
+// behaviors registered with RegisterBehavior are added to it
+var (
+	behaviorsMtx sync.Mutex
+	behaviors    = map[string]func() ActorBehavior{
+		behaviorPassive: func() ActorBehavior { return NopBehavior{} },
+		"tipper":        func() ActorBehavior { return tipper{} },
+		"forwarder":     func() ActorBehavior { return forwarder{} },
+		behaviorDice:    func() ActorBehavior { return newDice() },
+		behaviorGambler: func() ActorBehavior { return gambler{} },
+	}
+)
+
+// RegisterBehavior registers a custom behavior under the given name, so
+// that actors can be given it with -behaviors. Every actor gets its own
+// behavior returned by newBehavior. It returns an error if the name is
+// already taken.
+func RegisterBehavior(name string, newBehavior func() ActorBehavior) error {
+	if name == "" || strings.ContainsAny(name, ",=") || strings.HasPrefix(name, behaviorExec) ||
+		isMerchant(name) || isPool(name) || isExchange(name) {
+		return fmt.Errorf("invalid behavior name %q", name)
+	}
+	behaviorsMtx.Lock()
+	defer behaviorsMtx.Unlock()
+	if _, ok := behaviors[name]; ok {
+		return fmt.Errorf("behavior %s is already registered", name)
+	}
+	behaviors[name] = newBehavior
+	return nil
+}
+
+// newBehavior returns a new behavior with the given name, a merchant
+// with its confirmation policy, a mining pool with its payout interval,
+// an exchange with its sweep interval, or running the external program whose command line follows
+// behaviorExec
+func newBehavior(name string) (ActorBehavior, error) {
+	if strings.HasPrefix(name, behaviorExec) {
+		return newExternal(strings.TrimPrefix(name, behaviorExec))
+	}
+	if isMerchant(name) {
+		return newMerchant(name)
+	}
+	if isPool(name) {
+		return newPool(name)
+	}
+	if isExchange(name) {
+		return newExchange(name)
+	}
+	behaviorsMtx.Lock()
+	defer behaviorsMtx.Unlock()
+	f, ok := behaviors[name]
+	if !ok {
+		names := make([]string, 0, len(behaviors))
+		for name := range behaviors {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown behavior %q, valid behaviors are: %s",
+			name, strings.Join(names, ", "))
+	}
+	return f(), nil
+}
+
+// assignBehaviors returns the names of the behaviors of n actors given per
+// actor assignments, e.g. "0=tipper,3=forwarder", the other actors being
+// passive
+func assignBehaviors(n int, perActor string) ([]string, error) {
+	assigned := make([]string, n)
+	for i := range assigned {
+		assigned[i] = behaviorPassive
+	}
+	overrides, err := parseAssignments(perActor)
+	if err != nil {
+		return nil, err
+	}
+	for _, o := range overrides {
+		i, err := strconv.Atoi(o[0])
+		if err != nil || i < 0 || i >= n {
+			return nil, fmt.Errorf("invalid actor index %q", o[0])
+		}
+		if _, err := newBehavior(o[1]); err != nil {
+			return nil, err
+		}
+		assigned[i] = o[1]
+	}
+	return assigned, nil
+}
+
+// setBehavior gives the actor a new behavior with the given name
+func (a *Actor) setBehavior(name string) error {
+	b, err := newBehavior(name)
+	if err != nil {
+		return err
+	}
+	a.behaviorName = name
+	a.behavior = b
+	return nil
+}
+
+// runBehavior runs as a goroutine and calls the behavior of the actor for
+// the blocks and payments it is notified of and every tick
+func (a *Actor) runBehavior(tick time.Duration) {
+	defer a.wg.Done()
+
+	defer closeBehavior(a)
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	for {
+		select {
+		case height := <-a.behaviorBlocks:
+			a.behavior.OnBlock(a, height)
+		case out := <-a.behaviorPayments:
+			a.behavior.OnPaymentReceived(a, out)
+		case <-ticker.C:
+			a.behavior.OnTick(a)
+		case <-a.quit:
+			return
+		}
+	}
+}
+
+// closeBehavior closes the behavior of the actor if it holds resources,
+// such as the process of an external behavior
+func closeBehavior(a *Actor) {
+	if c, ok := a.behavior.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			log.Warnf("%s: Cannot close behavior: %v", a, err)
+		}
+	}
+}
+
+// notifyBlock queues a block mined at the given height for the behavior
+// of the actor
+func (a *Actor) notifyBlock(height int32) {
+	if a.behaviorBlocks == nil {
+		return
+	}
+	select {
+	case a.behaviorBlocks <- height:
+	default:
+		log.Debugf("%s: Behavior busy, dropped block %d", a, height)
+	}
+}
+
+// notifyPayment queues an output received by the actor for its behavior
+func (a *Actor) notifyPayment(out *TxOut) {
+	if a.behaviorPayments == nil {
+		return
+	}
+	select {
+	case a.behaviorPayments <- out:
+	default:
+		log.Debugf("%s: Behavior busy, dropped payment of %v", a, out.Amount)
+	}
+}
+
+// Address returns one of the addresses of the actor picked at random
+func (a *Actor) Address() btcutil.Address {
+	return a.ownedAddresses[a.rand.Intn(len(a.ownedAddresses))]
+}
+
+// Peer returns an address of another running actor picked at random, nil
+// if there is none
+func (a *Actor) Peer() btcutil.Address {
+	if a.peers == nil {
+		return nil
+	}
+	var others []*Actor
+	for _, o := range a.peers() {
+		if o != a {
+			others = append(others, o)
+		}
+	}
+	if len(others) == 0 {
+		return nil
+	}
+	return others[a.rand.Intn(len(others))].Address()
+}
+
+// Pay sends a transaction paying the given amounts from utxos queued by
+// the actor, with the change back to one of its addresses, and returns
+// it. It waits while the actor is paused and returns ErrInsufficientFunds
+// if the queued utxos are not enough. The transaction is recorded with
+// the transaction statistics but not as a payment between actors.
+func (a *Actor) Pay(amounts map[btcutil.Address]btcutil.Amount) (*wire.MsgTx, error) {
+	if !a.waitWhilePaused() {
+		return nil, ErrActorShutdown
+	}
+	var total btcutil.Amount
+	for _, amt := range amounts {
+		total += amt
+	}
+
+	var utxos []*TxOut
+	var in, fee btcutil.Amount
+	for {
+		fee = a.fee(len(utxos), len(amounts)+1)
+		if len(utxos) > 0 && in >= total+fee {
+			break
+		}
+		select {
+		case utxo, ok := <-a.utxoQueue.dequeue:
+			if !ok {
+				a.requeue(utxos)
+				return nil, ErrActorShutdown
+			}
+			utxos = append(utxos, utxo)
+			in += utxo.Amount
+		default:
+			a.requeue(utxos)
+			return nil, ErrInsufficientFunds
+		}
+	}
+
+	outputs := make(map[btcutil.Address]btcutil.Amount, len(amounts)+1)
+	for addr, amt := range amounts {
+		outputs[addr] = amt
+	}
+	// change too small to be worth an output is left to the miner
+	if change := in - total - fee; change >= minFee {
+		outputs[a.Address()] += change
+	}
+	inputs := make([]btcjson.TransactionInput, len(utxos))
+	for i, utxo := range utxos {
+		inputs[i] = btcjson.TransactionInput{
+			Txid: utxo.OutPoint.Hash.String(),
+			Vout: utxo.OutPoint.Index,
+		}
+	}
+	msgTx, err := a.createRawTransaction(inputs, outputs)
+	if err == nil {
+		err = sendUntracked(a, msgTx, a.untracked)
+	}
+	if err != nil {
+		a.requeue(utxos)
+		return nil, err
+	}
+	a.recordTx(msgTx, in, a.txSent)
+	return msgTx, nil
+}
@@ -0,0 +1,107 @@
+package btcsim
+
+import (
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcutil"
+)
+
+// recorder records the calls to its methods
+type recorder struct {
+	NopBehavior
+	calls chan string
+}
+
+func (r *recorder) OnBlock(a *Actor, height int32)         { r.calls <- "block" }
+func (r *recorder) OnTick(a *Actor)                        { r.calls <- "tick" }
+func (r *recorder) OnPaymentReceived(a *Actor, out *TxOut) { r.calls <- "payment" }
+
+func TestRegisterBehavior(t *testing.T) {
+	newRecorder := func() ActorBehavior { return &recorder{} }
+	if err := RegisterBehavior("test-recorder", newRecorder); err != nil {
+		t.Fatalf("RegisterBehavior error: %v", err)
+	}
+	defer func() {
+		behaviorsMtx.Lock()
+		delete(behaviors, "test-recorder")
+		behaviorsMtx.Unlock()
+	}()
+	for _, name := range []string{"test-recorder", behaviorPassive, "", "a=b", "a,b"} {
+		if err := RegisterBehavior(name, newRecorder); err == nil {
+			t.Errorf("RegisterBehavior(%q) expected error", name)
+		}
+	}
+
+	// every actor gets its own behavior
+	b1, err := newBehavior("test-recorder")
+	if err != nil {
+		t.Fatalf("newBehavior error: %v", err)
+	}
+	b2, _ := newBehavior("test-recorder")
+	if b1 == b2 {
+		t.Errorf("newBehavior returned the same behavior twice")
+	}
+	if _, err := newBehavior("unknown"); err == nil {
+		t.Errorf("newBehavior expected error for an unknown behavior")
+	}
+}
+
+func TestAssignBehaviors(t *testing.T) {
+	assigned, err := assignBehaviors(4, "1=tipper,3=forwarder")
+	if err != nil {
+		t.Fatalf("assignBehaviors error: %v", err)
+	}
+	want := []string{behaviorPassive, "tipper", behaviorPassive, "forwarder"}
+	for i := range want {
+		if assigned[i] != want[i] {
+			t.Errorf("actor %d got behavior %s want %s", i, assigned[i], want[i])
+		}
+	}
+	for _, spec := range []string{"4=tipper", "x=tipper", "0=unknown", "tipper"} {
+		if _, err := assignBehaviors(4, spec); err == nil {
+			t.Errorf("assignBehaviors(%q) expected error", spec)
+		}
+	}
+}
+
+func TestRunBehavior(t *testing.T) {
+	r := &recorder{calls: make(chan string)}
+	a := fakeActor("a")
+	a.behavior = r
+	a.behaviorBlocks = make(chan int32, 1)
+	a.behaviorPayments = make(chan *TxOut, 1)
+	a.wg.Add(1)
+	go a.runBehavior(10 * time.Millisecond)
+	defer a.WaitForShutdown()
+	defer close(a.quit)
+
+	a.notifyBlock(1)
+	a.notifyPayment(&TxOut{Amount: 1e8})
+	got := make(map[string]bool)
+	for len(got) < 3 {
+		select {
+		case call := <-r.calls:
+			got[call] = true
+		case <-time.After(time.Second):
+			t.Fatalf("behavior got calls %v want block, payment and tick", got)
+		}
+	}
+}
+
+func TestPeer(t *testing.T) {
+	a := fakeActor("a")
+	a.rand = DefaultConfig().newRand(0)
+	if addr := a.Peer(); addr != nil {
+		t.Errorf("Peer got %v without other actors", addr)
+	}
+	b := fakeActor("b")
+	b.rand = DefaultConfig().newRand(1)
+	b.ownedAddresses = []btcutil.Address{fakeAddress("b")}
+	a.peers = func() []*Actor { return []*Actor{a, b} }
+	for i := 0; i < 10; i++ {
+		if addr := a.Peer(); addr != b.ownedAddresses[0] {
+			t.Fatalf("Peer got %v want %v", addr, b.ownedAddresses[0])
+		}
+	}
+}
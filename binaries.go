@@ -0,0 +1,118 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// versionTimeout is the time an executable is given to print its version
+const versionTimeout = 10 * time.Second
+
+// runVersion runs the executable with --version and returns its output, it
+// is replaced by the tests
+var runVersion = func(exe string) ([]byte, error) {
+	var out bytes.Buffer
+	cmd := exec.Command(exe, "--version")
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	timer := time.AfterFunc(versionTimeout, func() { cmd.Process.Kill() })
+	defer timer.Stop()
+	if err := cmd.Wait(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// parseVersionOutput returns the version printed by --version, the last
+// word of its first line without a leading v, e.g. 0.12.0-beta for
+// "btcd version 0.12.0-beta"
+func parseVersionOutput(out []byte) (string, error) {
+	line := strings.SplitN(strings.TrimSpace(string(out)), "\n", 2)[0]
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return "", errors.New("no version printed")
+	}
+	return strings.TrimPrefix(fields[len(fields)-1], "v"), nil
+}
+
+// versionMatches reports whether the version got is the version want or
+// one of its releases, e.g. 0.12.0-beta matches 0.12 and 0.12.0 but not
+// 0.1
+func versionMatches(got, want string) bool {
+	return got == want || strings.HasPrefix(got, want+".") ||
+		strings.HasPrefix(got, want+"-")
+}
+
+// checkExecutable runs the executable of the program with --version and
+// returns its version, or an error if it cannot run or is not the version
+// want when want is set
+func checkExecutable(program, exe, want string) (string, error) {
+	out, err := runVersion(exe)
+	if err != nil {
+		return "", fmt.Errorf("cannot run %s executable %s: %v", program, exe, err)
+	}
+	got, err := parseVersionOutput(out)
+	if err != nil {
+		return "", fmt.Errorf("cannot read the version of %s executable %s: %v",
+			program, exe, err)
+	}
+	if want != "" && !versionMatches(got, want) {
+		return "", fmt.Errorf("%s executable %s is version %s, version %s is required",
+			program, exe, got, want)
+	}
+	return got, nil
+}
+
+// checkExecutables checks that the btcd executable, the bitcoind executable
+// of the bitcoind backend and the btcwallet executables of the actors run
+// and are the expected versions, so that a wrong executable fails the
+// simulation at startup instead of in the middle of the run. The
+// executables run in the containers are not checked with docker, nor
+// btcwallet with in-process wallets. The executables and their versions
+// are those configured by cfg.
+func checkExecutables(cfg *Config, wallets []version, inProcess, docker bool) error {
+	if docker {
+		return nil
+	}
+	v, err := checkExecutable("btcd", cfg.BtcdExe, cfg.BtcdVersion)
+	if err != nil {
+		return err
+	}
+	log.Infof("Using btcd %s (%s)", v, cfg.BtcdExe)
+	if cfg.BackendName == backendBitcoind {
+		v, err := checkExecutable("bitcoind", cfg.BitcoindExe, "")
+		if err != nil {
+			return err
+		}
+		log.Infof("Using bitcoind %s (%s)", v, cfg.BitcoindExe)
+	}
+	if inProcess {
+		return nil
+	}
+	if len(wallets) == 0 {
+		wallets = []version{{name: "btcwallet", exe: cfg.BtcwalletExe}}
+	}
+	for _, w := range wallets {
+		want := ""
+		if w.exe == cfg.BtcwalletExe {
+			want = cfg.BtcwalletVersion
+		}
+		v, err := checkExecutable("btcwallet", w.exe, want)
+		if err != nil {
+			return err
+		}
+		log.Infof("Using btcwallet %s (%s)", v, w.exe)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package btcsim
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseVersionOutput(t *testing.T) {
+	tests := []struct {
+		out, want string
+	}{
+		{"btcd version 0.12.0-beta\n", "0.12.0-beta"},
+		{"btcwallet version 0.7.0-alpha", "0.7.0-alpha"},
+		{"Bitcoin Core Daemon version v0.21.0\nCopyright (C) 2009-2020\n", "0.21.0"},
+	}
+	for _, test := range tests {
+		got, err := parseVersionOutput([]byte(test.out))
+		if err != nil || got != test.want {
+			t.Errorf("parseVersionOutput(%q) = %q, %v, want %q", test.out, got, err, test.want)
+		}
+	}
+	if _, err := parseVersionOutput([]byte("\n")); err == nil {
+		t.Errorf("parseVersionOutput of an empty output succeeded")
+	}
+}
+
+func TestVersionMatches(t *testing.T) {
+	tests := []struct {
+		got, want string
+		match     bool
+	}{
+		{"0.12.0-beta", "0.12", true},
+		{"0.12.0-beta", "0.12.0", true},
+		{"0.12.0-beta", "0.12.0-beta", true},
+		{"0.12.0-beta", "0.1", false},
+		{"0.12.0-beta", "0.13", false},
+	}
+	for _, test := range tests {
+		if got := versionMatches(test.got, test.want); got != test.match {
+			t.Errorf("versionMatches(%q, %q) = %v, want %v", test.got, test.want, got, test.match)
+		}
+	}
+}
+
+func TestCheckExecutables(t *testing.T) {
+	prevRun := runVersion
+	defer func() { runVersion = prevRun }()
+	cfg := DefaultConfig()
+	outputs := map[string]string{
+		cfg.BtcdExe:          "btcd version 0.12.0-beta",
+		cfg.BtcwalletExe:     "btcwallet version 0.7.0-alpha",
+		"/opt/old/btcwallet": "btcwallet version 0.6.0-alpha",
+	}
+	var ran []string
+	runVersion = func(exe string) ([]byte, error) {
+		ran = append(ran, exe)
+		out, ok := outputs[exe]
+		if !ok {
+			return nil, errors.New("executable file not found in $PATH")
+		}
+		return []byte(out), nil
+	}
+
+	cfg.BtcdVersion, cfg.BtcwalletVersion = "0.12", "0.7"
+	if err := checkExecutables(cfg, nil, false, false); err != nil {
+		t.Errorf("checkExecutables error: %v", err)
+	}
+	// the pinned version only applies to the -btcwallet executable
+	wallets := []version{{"old", "/opt/old/btcwallet"}, {"new", cfg.BtcwalletExe}}
+	if err := checkExecutables(cfg, wallets, false, false); err != nil {
+		t.Errorf("checkExecutables of btcwallet versions error: %v", err)
+	}
+
+	ran = nil
+	if err := checkExecutables(cfg, nil, false, true); err != nil || len(ran) != 0 {
+		t.Errorf("checkExecutables with docker ran %v: %v", ran, err)
+	}
+	if err := checkExecutables(cfg, nil, true, false); err != nil || len(ran) != 1 {
+		t.Errorf("checkExecutables with in-process wallets ran %v: %v", ran, err)
+	}
+
+	cfg.BtcdVersion = "0.13"
+	err := checkExecutables(cfg, nil, false, false)
+	if err == nil || !strings.Contains(err.Error(), "is version 0.12.0-beta, version 0.13 is required") {
+		t.Errorf("checkExecutables of a wrong btcd version returned %v", err)
+	}
+	cfg.BtcdVersion = ""
+	err = checkExecutables(cfg, []version{{"missing", "/opt/missing/btcwallet"}}, false, false)
+	if err == nil || !strings.Contains(err.Error(), "cannot run btcwallet executable /opt/missing/btcwallet") {
+		t.Errorf("checkExecutables of a missing btcwallet returned %v", err)
+	}
+}
@@ -0,0 +1,175 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+
+	rpc "github.com/btcsuite/btcrpcclient"
+)
+
+// bitcoindArgs contains all the args and data required to launch a bitcoind
+// instance in regtest mode and connect the rpc client to it
+type bitcoindArgs struct {
+	RPCUser   string
+	RPCPass   string
+	Listen    string
+	RPCListen string
+	DataDir   string
+	AddNodes  []string
+	Extra     []string
+
+	prefix string
+	exe    string
+
+	// cfg is the configuration of the run the node belongs to
+	cfg *Config
+}
+
+// newBitcoindArgs returns a bitcoindArgs with all default values for the
+// run configured by cfg
+func newBitcoindArgs(cfg *Config, prefix string) (*bitcoindArgs, error) {
+	a := &bitcoindArgs{
+		Listen:    "127.0.0.1:18444",
+		RPCListen: "127.0.0.1:18443",
+		RPCUser:   "user",
+		RPCPass:   "pass",
+
+		prefix: prefix,
+		exe:    cfg.BitcoindExe,
+		cfg:    cfg,
+	}
+	if err := a.SetDefaults(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// SetDefaults sets the default values of args
+// it creates a tmp data directory and must
+// be cleaned up by calling Cleanup
+func (a *bitcoindArgs) SetDefaults() error {
+	datadir, err := a.cfg.tempDir(a.prefix + "-data")
+	if err != nil {
+		return err
+	}
+	a.DataDir = datadir
+	return nil
+}
+
+// String returns a printable name of this instance
+func (a *bitcoindArgs) String() string {
+	return a.prefix
+}
+
+// SetListen sets the p2p and rpc listen addresses
+func (a *bitcoindArgs) SetListen(listen, rpcListen string) {
+	a.Listen = listen
+	a.RPCListen = rpcListen
+}
+
+// ListenAddr returns the p2p listen address
+func (a *bitcoindArgs) ListenAddr() string {
+	return a.Listen
+}
+
+// AddPeer adds a peer to connect to using -addnode
+func (a *bitcoindArgs) AddPeer(addr string) {
+	a.AddNodes = append(a.AddNodes, addr)
+}
+
+// SetRelayPolicy sets the mempool size limit using -maxmempool and the
+// minimum relay fee using -minrelaytxfee
+func (a *bitcoindArgs) SetRelayPolicy(maxMempool int, minRelayFee float64) error {
+	if maxMempool > 0 {
+		a.Extra = append(a.Extra, fmt.Sprintf("-maxmempool=%d", maxMempool))
+	}
+	if minRelayFee >= 0 {
+		a.Extra = append(a.Extra, "-minrelaytxfee="+relayFeeArg(minRelayFee))
+	}
+	return nil
+}
+
+// Arguments returns an array of arguments that be used to launch the
+// bitcoind instance
+func (a *bitcoindArgs) Arguments() []string {
+	args := []string{
+		// -regtest
+		"-regtest",
+		// -server
+		"-server",
+		// -listen
+		"-listen",
+		// -disablewallet, the actors hold their keys
+		"-disablewallet",
+		// -printtoconsole, the output goes to the log file of the node
+		"-printtoconsole",
+	}
+	if a.RPCUser != "" {
+		// -rpcuser
+		args = append(args, fmt.Sprintf("-rpcuser=%s", a.RPCUser))
+	}
+	if a.RPCPass != "" {
+		// -rpcpassword
+		args = append(args, fmt.Sprintf("-rpcpassword=%s", a.RPCPass))
+	}
+	if a.Listen != "" {
+		// -bind and -port
+		host, port, err := net.SplitHostPort(a.Listen)
+		if err == nil {
+			args = append(args, fmt.Sprintf("-bind=%s", host))
+			args = append(args, fmt.Sprintf("-port=%s", port))
+		}
+	}
+	if a.RPCListen != "" {
+		// -rpcport
+		_, port, err := net.SplitHostPort(a.RPCListen)
+		if err == nil {
+			args = append(args, fmt.Sprintf("-rpcport=%s", port))
+		}
+	}
+	if a.DataDir != "" {
+		// -datadir
+		args = append(args, fmt.Sprintf("-datadir=%s", a.DataDir))
+	}
+	for _, addr := range a.AddNodes {
+		// -addnode
+		args = append(args, fmt.Sprintf("-addnode=%s", addr))
+	}
+	args = append(args, a.Extra...)
+	return args
+}
+
+// Command returns Cmd of the bitcoind instance
+func (a *bitcoindArgs) Command() *exec.Cmd {
+	return exec.Command(a.exe, a.Arguments()...)
+}
+
+// RPCConnConfig returns the rpc connection config that can be used
+// to connect to the bitcoind instance that is launched on Start
+// bitcoind only supports JSON-RPC over plain HTTP POST requests, so the
+// node is polled for its notifications
+func (a *bitcoindArgs) RPCConnConfig() rpc.ConnConfig {
+	return rpc.ConnConfig{
+		Host:                 a.RPCListen,
+		User:                 a.RPCUser,
+		Pass:                 a.RPCPass,
+		HTTPPostMode:         true,
+		DisableTLS:           true,
+		DisableAutoReconnect: true,
+	}
+}
+
+// Cleanup removes the tmp data directory
+func (a *bitcoindArgs) Cleanup() error {
+	if err := os.RemoveAll(a.DataDir); err != nil {
+		log.Errorf("Cannot remove dir %s: %v", a.DataDir, err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package btcsim
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNewBitcoindArgs(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.BitcoindExe = "/opt/bitcoin/bin/bitcoind"
+	args, err := newBitcoindArgs(cfg, "node")
+	if err != nil {
+		t.Fatalf("newBitcoindArgs error: %v", err)
+	}
+	defer args.Cleanup()
+	args.SetListen("127.0.0.1:28555", "127.0.0.1:28556")
+	args.AddPeer("127.0.0.1:18555")
+
+	expectedArguments := []string{
+		"-regtest",
+		"-server",
+		"-listen",
+		"-disablewallet",
+		"-printtoconsole",
+		"-rpcuser=user",
+		"-rpcpassword=pass",
+		"-bind=127.0.0.1",
+		"-port=28555",
+		"-rpcport=28556",
+		"-datadir=" + args.DataDir,
+		"-addnode=127.0.0.1:18555",
+	}
+	if arguments := args.Arguments(); !reflect.DeepEqual(arguments, expectedArguments) {
+		t.Errorf("newBitcoindArgs expected: %v, got %v", expectedArguments, arguments)
+	}
+	if cmd := args.Command(); cmd.Path != cfg.BitcoindExe {
+		t.Errorf("command runs %s, want %s", cmd.Path, cfg.BitcoindExe)
+	}
+
+	conf := args.RPCConnConfig()
+	if !conf.HTTPPostMode || !conf.DisableTLS || conf.Host != "127.0.0.1:28556" {
+		t.Errorf("bitcoind rpc must use plain HTTP POST mode, got %+v", conf)
+	}
+}
+
+func TestBitcoindRelayPolicy(t *testing.T) {
+	bitcoind, err := newBitcoindArgs(DefaultConfig(), "node")
+	if err != nil {
+		t.Fatalf("newBitcoindArgs error: %v", err)
+	}
+	defer bitcoind.Cleanup()
+	if err := bitcoind.SetRelayPolicy(0, -1); err != nil || len(bitcoind.Extra) != 0 {
+		t.Errorf("bitcoind default relay policy got %v, %v", bitcoind.Extra, err)
+	}
+	if err := bitcoind.SetRelayPolicy(50, 0); err != nil {
+		t.Fatalf("bitcoind SetRelayPolicy error: %v", err)
+	}
+	want := []string{"-maxmempool=50", "-minrelaytxfee=0.00000000"}
+	if !reflect.DeepEqual(bitcoind.Extra, want) {
+		t.Errorf("bitcoind relay policy got %v want %v", bitcoind.Extra, want)
+	}
+}
+
+func TestGetBackend(t *testing.T) {
+	cfg := DefaultConfig()
+	for _, name := range []string{backendBtcd, backendBitcoind} {
+		newArgs, err := getBackend(name)
+		if err != nil {
+			t.Errorf("getBackend(%s) error: %v", name, err)
+			continue
+		}
+		args, err := newArgs(cfg, "node")
+		if err != nil {
+			t.Errorf("%s backend error: %v", name, err)
+			continue
+		}
+		if got := executable(args); got != name {
+			t.Errorf("%s backend runs %s", name, got)
+		}
+		args.Cleanup()
+	}
+	if _, err := getBackend("bcoin"); err == nil {
+		t.Errorf("getBackend expected error")
+	}
+}
+
+func TestCheckBackend(t *testing.T) {
+	tests := []struct {
+		name      string
+		inProcess bool
+		docker    bool
+		watchOnly int
+		ok        bool
+	}{
+		{backendBtcd, false, true, 2, true},
+		{backendBitcoind, true, false, 0, true},
+		{backendBitcoind, false, false, 0, false},
+		{backendBitcoind, true, true, 0, false},
+		{backendBitcoind, true, false, 1, false},
+		{"bcoin", true, false, 0, false},
+	}
+	for i, test := range tests {
+		err := checkBackend(test.name, test.inProcess, test.docker, test.watchOnly)
+		if (err == nil) != test.ok {
+			t.Errorf("test %d: got error %v, want ok %v", i, err, test.ok)
+		}
+	}
+}
+
+func TestBitcoindNetwork(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.BackendName = backendBitcoind
+	if cfg.netParams() == DefaultConfig().netParams() {
+		t.Errorf("the bitcoind backend runs on the network of btcd")
+	}
+
+	// the btcd miner peers with the bitcoind nodes on regtest
+	miner, err := newBtcdArgs(cfg, "miner")
+	if err != nil {
+		t.Fatalf("newBtcdArgs error: %v", err)
+	}
+	defer miner.Cleanup()
+	if arg := miner.Arguments()[0]; arg != "--regtest" {
+		t.Errorf("btcd network argument got %s, want --regtest", arg)
+	}
+}
@@ -0,0 +1,167 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+)
+
+// BlockComposition is the composition of a mined block compared with the
+// transactions of actors it left in the mempool, so that the selection of
+// the miner can be audited
+type BlockComposition struct {
+	Height int32 `json:"height"`
+
+	// Size is the serialized size of the block in bytes and Weight its
+	// weight, four times its size as blocks carry no witness, Txs the
+	// number of its transactions including the coinbase
+	Size   int `json:"size"`
+	Weight int `json:"weight"`
+	Txs    int `json:"txs"`
+
+	// Fees is the total fee of the transactions of actors in the block
+	// and MinRate, MedianRate and MaxRate their fee rates in satoshis per
+	// byte
+	Fees       int64   `json:"fees"`
+	MinRate    float64 `json:"minrate"`
+	MedianRate float64 `json:"medianrate"`
+	MaxRate    float64 `json:"maxrate"`
+
+	// Mempool is the number of transactions of actors left in the
+	// mempool, MempoolMedianRate and MempoolMaxRate their fee rates, and
+	// Skipped the number of those paying a higher fee rate than the
+	// lowest one of the block
+	Mempool           int     `json:"mempool"`
+	MempoolMedianRate float64 `json:"mempoolmedianrate"`
+	MempoolMaxRate    float64 `json:"mempoolmaxrate"`
+	Skipped           int     `json:"skipped"`
+}
+
+// newBlockComposition returns the composition of the block given the fee
+// rates of the transactions of actors it included and of those it left in
+// the mempool
+func newBlockComposition(b *blockTxs, fees int64, included, left []float64) *BlockComposition {
+	c := &BlockComposition{
+		Height:  b.height,
+		Txs:     len(b.txids),
+		Fees:    fees,
+		Mempool: len(left),
+	}
+	if b.block != nil {
+		c.Size = b.block.MsgBlock().SerializeSize()
+		c.Weight = 4 * c.Size
+	}
+	in := append([]float64(nil), included...)
+	sort.Float64s(in)
+	if len(in) > 0 {
+		c.MinRate = in[0]
+		c.MedianRate = in[len(in)/2]
+		c.MaxRate = in[len(in)-1]
+	}
+	out := append([]float64(nil), left...)
+	sort.Float64s(out)
+	if len(out) > 0 {
+		c.MempoolMedianRate = out[len(out)/2]
+		c.MempoolMaxRate = out[len(out)-1]
+	}
+	if len(in) > 0 {
+		c.Skipped = len(out) - sort.Search(len(out), func(i int) bool {
+			return out[i] > c.MinRate
+		})
+	}
+	return c
+}
+
+// BlockSelection summarizes the composition of the blocks mined
+type BlockSelection struct {
+	Blocks   int     `json:"blocks"`
+	MeanSize float64 `json:"meansize"`
+	MeanTxs  float64 `json:"meantxs"`
+
+	// Skipping is the number of blocks which left transactions paying a
+	// higher fee rate than the lowest one they included in the mempool,
+	// Skipped the number of such transactions
+	Skipping int `json:"skipping"`
+	Skipped  int `json:"skipped"`
+}
+
+// newBlockSelection summarizes the composition of the blocks
+func newBlockSelection(blocks []*BlockComposition) *BlockSelection {
+	s := &BlockSelection{Blocks: len(blocks)}
+	if len(blocks) == 0 {
+		return s
+	}
+	for _, b := range blocks {
+		s.MeanSize += float64(b.Size)
+		s.MeanTxs += float64(b.Txs)
+		if b.Skipped > 0 {
+			s.Skipping++
+			s.Skipped += b.Skipped
+		}
+	}
+	s.MeanSize /= float64(len(blocks))
+	s.MeanTxs /= float64(len(blocks))
+	return s
+}
+
+// String returns the summary of the blocks in a line of the summary
+func (s *BlockSelection) String() string {
+	return fmt.Sprintf("%d blocks of %.0f bytes and %.1f transactions on average, %d left %d transactions paying more than their lowest fee rate in the mempool",
+		s.Blocks, s.MeanSize, s.MeanTxs, s.Skipping, s.Skipped)
+}
+
+// writeBlockStatsCSV writes the composition of every block as CSV with a
+// header row
+func writeBlockStatsCSV(w io.Writer, blocks []*BlockComposition) error {
+	writer := csv.NewWriter(w)
+	header := []string{"height", "size", "weight", "txs", "fees", "minrate", "medianrate",
+		"maxrate", "mempool", "mempoolmedianrate", "mempoolmaxrate", "skipped"}
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+	formatRate := func(rate float64) string {
+		return strconv.FormatFloat(rate, 'f', 2, 64)
+	}
+	for _, b := range blocks {
+		row := []string{
+			strconv.Itoa(int(b.Height)),
+			strconv.Itoa(b.Size),
+			strconv.Itoa(b.Weight),
+			strconv.Itoa(b.Txs),
+			strconv.FormatInt(b.Fees, 10),
+			formatRate(b.MinRate),
+			formatRate(b.MedianRate),
+			formatRate(b.MaxRate),
+			strconv.Itoa(b.Mempool),
+			formatRate(b.MempoolMedianRate),
+			formatRate(b.MempoolMaxRate),
+			strconv.Itoa(b.Skipped),
+		}
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// writeBlockStats writes the composition of every block to the given path
+// as CSV
+func writeBlockStats(path string, blocks []*BlockComposition) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := writeBlockStatsCSV(file, blocks); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
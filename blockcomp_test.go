@@ -0,0 +1,41 @@
+package btcsim
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestNewBlockComposition(t *testing.T) {
+	b := &blockTxs{height: 7, txids: []string{"coinbase", "a", "b", "c"}}
+	c := newBlockComposition(b, 3000, []float64{10, 5, 20}, []float64{1, 4, 6, 30})
+	if c.Height != 7 || c.Txs != 4 || c.Fees != 3000 || c.Size != 0 {
+		t.Errorf("got %+v", c)
+	}
+	if c.MinRate != 5 || c.MedianRate != 10 || c.MaxRate != 20 {
+		t.Errorf("got rates %v %v %v", c.MinRate, c.MedianRate, c.MaxRate)
+	}
+	// 6 and 30 sat/B pay more than the lowest rate of the block
+	if c.Mempool != 4 || c.Skipped != 2 || c.MempoolMaxRate != 30 || c.MempoolMedianRate != 6 {
+		t.Errorf("got mempool %+v", c)
+	}
+
+	empty := newBlockComposition(&blockTxs{height: 8}, 0, nil, []float64{1})
+	if empty.Skipped != 0 {
+		t.Errorf("empty block skipped %d transactions", empty.Skipped)
+	}
+
+	s := newBlockSelection([]*BlockComposition{c, empty})
+	if s.Blocks != 2 || s.MeanTxs != 2 || s.Skipping != 1 || s.Skipped != 2 {
+		t.Errorf("got selection %+v", s)
+	}
+
+	var buf bytes.Buffer
+	if err := writeBlockStatsCSV(&buf, []*BlockComposition{c}); err != nil {
+		t.Fatalf("writeBlockStatsCSV error: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 || lines[1] != "7,0,0,4,3000,5.00,10.00,20.00,4,6.00,30.00,2" {
+		t.Errorf("got CSV:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Block size limits accepted by btcd for block templates
+const (
+	minBlockSizeLimit = 1000
+	maxBlockSizeLimit = 999000
+)
+
+// checkBlockSize returns an error if the block template size limits are
+// out of the range accepted by btcd, a negative priority size keeps the
+// default of the node
+func checkBlockSize(min, max, priority int) error {
+	if max < minBlockSizeLimit || max > maxBlockSizeLimit {
+		return fmt.Errorf("maximum block size must be in [%d, %d]",
+			minBlockSizeLimit, maxBlockSizeLimit)
+	}
+	if min < 0 || min > max {
+		return fmt.Errorf("minimum block size must be in [0, %d]", max)
+	}
+	if priority > max {
+		return fmt.Errorf("high priority block size must not exceed %d", max)
+	}
+	return nil
+}
+
+// blockTemplateArgs returns the arguments passing the block template size
+// limits configured by cfg to a mining btcd instance
+func blockTemplateArgs(cfg *Config) []string {
+	args := []string{fmt.Sprintf("--blockmaxsize=%d", cfg.MaxBlockSize)}
+	if cfg.MinBlockSize > 0 {
+		args = append(args, fmt.Sprintf("--blockminsize=%d", cfg.MinBlockSize))
+	}
+	// with a fee market, fill blocks by fee rate only rather than
+	// reserving space for high priority transactions
+	switch {
+	case cfg.feeMarket:
+		args = append(args, "--blockprioritysize=0")
+	case cfg.PrioritySize >= 0:
+		args = append(args, fmt.Sprintf("--blockprioritysize=%d", cfg.PrioritySize))
+	}
+	return args
+}
+
+// parseBlockSizes parses a comma separated list of maximum block sizes
+func parseBlockSizes(s string) ([]int, error) {
+	var sizes []int
+	for _, field := range strings.Split(s, ",") {
+		size, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil {
+			return nil, fmt.Errorf("invalid block size %q", field)
+		}
+		if err := checkBlockSize(0, size, -1); err != nil {
+			return nil, err
+		}
+		sizes = append(sizes, size)
+	}
+	return sizes, nil
+}
+
+// BlockSizeResult is the aggregate of the runs with a maximum block size
+type BlockSizeResult struct {
+	MaxBlockSize int        `json:"maxblocksize"`
+	Aggregate    *Aggregate `json:"aggregate"`
+}
+
+// BlockSizeComparison compares the runs of a campaign across maximum
+// block sizes
+type BlockSizeComparison struct {
+	Results []BlockSizeResult `json:"results"`
+}
+
+// Write writes a human readable form of the comparison to w, with the
+// mean throughput, fee level and mempool backlog of every block size
+func (c *BlockSizeComparison) Write(w io.Writer) error {
+	for _, r := range c.Results {
+		a := r.Aggregate
+		line := fmt.Sprintf("Block size %d bytes: %d runs (%d failed), %.2f tx/s, %.0f of %.0f transactions confirmed, median fee rate %.2f sat/B, mempool high-water mark %.0f",
+			r.MaxBlockSize, a.Runs, a.Failed, a.TPS.Mean, a.Confirmed.Mean,
+			a.Transactions.Mean, a.FeeRate.Mean, a.MaxMempool.Mean)
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runBlockSizes runs a campaign of n simulations configured by cfg for
+// every maximum block size and compares them. Run i of every block size
+// uses the seed plus i, so that block sizes are compared with the same
+// transaction load.
+func runBlockSizes(cfg *Config, sizes []int, n int, parallel bool) error {
+	if err := checkRuns(cfg, n, parallel); err != nil {
+		return err
+	}
+
+	c := &BlockSizeComparison{}
+	failed, code := true, exitPass
+	for i, size := range sizes {
+		log.Infof("Running %d simulation(s) with a maximum block size of %d bytes...", n, size)
+		summaries, batchCode := runBatch(cfg, i*n+1, n, parallel, fmt.Sprintf("-maxblocksize=%d", size))
+		if batchCode > code {
+			code = batchCode
+		}
+		agg := NewAggregate(summaries)
+		c.Results = append(c.Results, BlockSizeResult{
+			MaxBlockSize: size,
+			Aggregate:    agg,
+		})
+		if agg.Failed < n {
+			failed = false
+		}
+	}
+
+	log.Infof("Block size comparison:")
+	c.Write(os.Stdout)
+	if cfg.SummaryPath != "" {
+		if err := writeJSON(cfg.SummaryPath, c); err != nil {
+			log.Errorf("Cannot write block size comparison: %v", err)
+			return err
+		}
+	}
+	if failed {
+		return errors.New("all runs failed")
+	}
+	return batchError(code)
+}
@@ -0,0 +1,76 @@
+package btcsim
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCheckBlockSize(t *testing.T) {
+	tests := []struct {
+		min, max, priority int
+		valid              bool
+	}{
+		{0, 999000, -1, true},
+		{1000, 20000, 5000, true},
+		{0, 999, -1, false},
+		{0, 1000000, -1, false},
+		{30000, 20000, -1, false},
+		{0, 20000, 30000, false},
+	}
+	for _, test := range tests {
+		err := checkBlockSize(test.min, test.max, test.priority)
+		if (err == nil) != test.valid {
+			t.Errorf("checkBlockSize(%d, %d, %d) got error %v",
+				test.min, test.max, test.priority, err)
+		}
+	}
+}
+
+func TestParseBlockSizes(t *testing.T) {
+	sizes, err := parseBlockSizes("10000, 100000,999000")
+	if err != nil {
+		t.Fatalf("parseBlockSizes error: %v", err)
+	}
+	if want := []int{10000, 100000, 999000}; !reflect.DeepEqual(sizes, want) {
+		t.Errorf("parseBlockSizes got %v want %v", sizes, want)
+	}
+	for _, s := range []string{"", "10000,x", "100"} {
+		if _, err := parseBlockSizes(s); err == nil {
+			t.Errorf("parseBlockSizes(%q) expected error", s)
+		}
+	}
+}
+
+func TestBlockTemplateArgs(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MinBlockSize, cfg.PrioritySize = 5000, 2000
+
+	args := strings.Join(blockTemplateArgs(cfg), " ")
+	for _, want := range []string{"--blockmaxsize=", "--blockminsize=5000", "--blockprioritysize=2000"} {
+		if !strings.Contains(args, want) {
+			t.Errorf("blockTemplateArgs got %q, missing %q", args, want)
+		}
+	}
+}
+
+func TestBlockSizeComparison(t *testing.T) {
+	c := &BlockSizeComparison{Results: []BlockSizeResult{
+		{10000, NewAggregate([]*Summary{{TPS: 1, MedianFeeRate: 20}})},
+		{999000, NewAggregate([]*Summary{{TPS: 5, MedianFeeRate: 1}, nil})},
+	}}
+	var buf bytes.Buffer
+	if err := c.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"Block size 10000 bytes: 1 runs (0 failed), 1.00 tx/s",
+		"median fee rate 20.00 sat/B",
+		"Block size 999000 bytes: 2 runs (1 failed), 5.00 tx/s",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("comparison output missing %q:\n%s", want, buf.String())
+		}
+	}
+}
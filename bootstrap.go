@@ -0,0 +1,71 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"sync"
+)
+
+// bootstrap runs as a goroutine and mines the blocks up to the one before
+// the start block one at a time with generate instead of the cpu miner.
+// Once actors own mature utxos, up to txs transactions splitting one of
+// them in two are mined in every block, so that the simulation starts
+// with a large utxo set.
+func (com *Communication) bootstrap(miner *Miner, txs int) {
+	defer com.wg.Done()
+
+	for {
+		height, err := miner.client.GetBlockCount()
+		if err != nil {
+			log.Errorf("%s: Cannot get block count: %v", miner, err)
+			com.Exit()
+			return
+		}
+		if height >= int64(com.cfg.StartBlock)-1 {
+			return
+		}
+
+		// never request more splits than there are queued utxos, so that
+		// every request is taken by an actor
+		var available int
+		for _, a := range com.Actors() {
+			available += a.utxoQueue.len()
+		}
+		n := txs
+		if n > available {
+			n = available
+		}
+
+		var wg sync.WaitGroup
+		cancel := make(chan struct{})
+		for i := 0; i < n; i++ {
+			select {
+			case com.split <- 1:
+				wg.Add(1)
+				go com.txPoolRecv(&wg, cancel)
+			case <-com.exit:
+				return
+			}
+		}
+		if !com.waitTxPool(&wg, cancel) {
+			return
+		}
+
+		if _, err := miner.client.Generate(1); err != nil {
+			log.Errorf("%s: Cannot generate block: %v", miner, err)
+			com.Exit()
+			return
+		}
+		// wait for the utxos of the block to be queued, the last block
+		// is synced with Communicate instead
+		if height+1 < int64(com.cfg.StartBlock)-1 {
+			select {
+			case <-com.bootstrapped:
+			case <-com.exit:
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package btcsim
+
+import (
+	"testing"
+	"time"
+)
+
+// mockMiner returns a miner whose node is a new wallet of the chain
+func mockMiner(c *MockChain) *Miner {
+	n, _ := NewNodeFromArgs(c.cfg, &fakeArgs{name: "miner"}, nil, nil)
+	n.client = c.NewWallet().client()
+	return &Miner{Node: n}
+}
+
+func TestBootstrapBlocks(t *testing.T) {
+	chain := NewMockChain(DefaultConfig())
+	chain.cfg.StartBlock = 5
+	miner := mockMiner(chain)
+	com := NewCommunication(chain.cfg)
+	done := make(chan struct{})
+	com.wg.Add(1)
+	go func() {
+		com.bootstrap(miner, 10)
+		close(done)
+	}()
+
+	// bootstrap waits for every block but the last one to be processed
+	synced := 0
+	for {
+		select {
+		case com.bootstrapped <- int32(synced + 1):
+			synced++
+			continue
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("bootstrap did not finish")
+		}
+		break
+	}
+	if height, _ := miner.client.GetBlockCount(); height != 4 {
+		t.Errorf("bootstrapped to height %d, want 4", height)
+	}
+	if synced != 3 {
+		t.Errorf("bootstrap synced with %d blocks, want 3", synced)
+	}
+}
+
+func TestBootstrapSplits(t *testing.T) {
+	chain := NewMockChain(DefaultConfig())
+	chain.cfg.StartBlock = 3
+	miner := mockMiner(chain)
+	a := chain.NewActor("a")
+	mineMock(t, a, a)
+	// the mock queue is a channel, the utxo is counted as queueUtxos
+	// would count it
+	a.utxoQueue.utxos = make([]*TxOut, 1)
+
+	com := NewCommunication(chain.cfg)
+	com.addActor(a)
+	txSent := make(chan *TxRecord)
+	a.wg.Add(1)
+	go a.splitUtxos(com.split, com.txpool, txSent)
+	// accept the transactions sent as the miner does once they reach
+	// the mempool
+	go func() {
+		for range txSent {
+			com.txpool <- struct{}{}
+		}
+	}()
+	defer close(txSent)
+	defer StopMockActors(a)
+
+	com.wg.Add(1)
+	com.bootstrap(miner, 10)
+
+	if height, _ := miner.client.GetBlockCount(); height != 2 {
+		t.Fatalf("bootstrapped to height %d, want 2", height)
+	}
+	hash, _ := miner.client.GetBlockHash(2)
+	block, _ := miner.client.GetBlock(hash)
+	if n := len(block.Transactions()); n != 2 {
+		t.Errorf("bootstrap block has %d transactions, want the coinbase and a split", n)
+	}
+}
@@ -1,9 +1,9 @@
-package main
+package btcsim
 
 import (
+	"errors"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"os"
 	"os/exec"
 	"strings"
@@ -26,14 +26,23 @@ type btcdArgs struct {
 	DebugLevel string
 	Extra      []string
 
-	prefix       string
-	exe          string
-	endpoint     string
+	prefix   string
+	exe      string
+	endpoint string
+
+	// cfg is the configuration of the run the node belongs to
+	cfg *Config
+
+	// certFile and keyFile are the cert pair of the rpc server, generated
+	// in the data directory, and certificates the cert the clients trust
+	certFile     string
+	keyFile      string
 	certificates []byte
 }
 
-// newBtcdArgs returns a btcdArgs with all default values
-func newBtcdArgs(prefix string) (*btcdArgs, error) {
+// newBtcdArgs returns a btcdArgs with all default values for the run
+// configured by cfg
+func newBtcdArgs(cfg *Config, prefix string) (*btcdArgs, error) {
 	a := &btcdArgs{
 		Listen:    "127.0.0.1:18555",
 		RPCListen: "127.0.0.1:18556",
@@ -41,8 +50,9 @@ func newBtcdArgs(prefix string) (*btcdArgs, error) {
 		RPCPass:   "pass",
 
 		prefix:   prefix,
-		exe:      "btcd",
+		exe:      cfg.BtcdExe,
 		endpoint: "ws",
+		cfg:      cfg,
 	}
 	if err := a.SetDefaults(); err != nil {
 		return nil, err
@@ -51,20 +61,23 @@ func newBtcdArgs(prefix string) (*btcdArgs, error) {
 }
 
 // SetDefaults sets the default values of args
-// it creates tmp data and log directories and must
-// be cleaned up by calling Cleanup
+// it creates tmp data and log directories, with the cert pair of the rpc
+// server in the data directory, and must be cleaned up by calling Cleanup
 func (a *btcdArgs) SetDefaults() error {
-	datadir, err := ioutil.TempDir("", a.prefix+"-data")
+	datadir, err := a.cfg.tempDir(a.prefix + "-data")
 	if err != nil {
 		return err
 	}
 	a.DataDir = datadir
-	logdir, err := ioutil.TempDir("", a.prefix+"-logs")
+	logdir, err := a.cfg.tempDir(a.prefix + "-logs")
 	if err != nil {
 		return err
 	}
 	a.LogDir = logdir
-	cert, err := ioutil.ReadFile(CertFile)
+	if a.certFile, a.keyFile, err = newCertPair(a.cfg, a.DataDir); err != nil {
+		return err
+	}
+	cert, err := ioutil.ReadFile(a.certFile)
 	if err != nil {
 		return err
 	}
@@ -77,12 +90,45 @@ func (a *btcdArgs) String() string {
 	return a.prefix
 }
 
+// SetListen sets the p2p and rpc listen addresses
+func (a *btcdArgs) SetListen(listen, rpcListen string) {
+	a.Listen = listen
+	a.RPCListen = rpcListen
+}
+
+// ListenAddr returns the p2p listen address
+func (a *btcdArgs) ListenAddr() string {
+	return a.Listen
+}
+
+// AddPeer adds a peer to connect to using --addpeer
+func (a *btcdArgs) AddPeer(addr string) {
+	a.Extra = append(a.Extra, "--addpeer="+addr)
+}
+
+// SetRelayPolicy sets the minimum relay fee using --minrelaytxfee, btcd
+// has no mempool size limit
+func (a *btcdArgs) SetRelayPolicy(maxMempool int, minRelayFee float64) error {
+	if maxMempool > 0 {
+		return errors.New("btcd has no mempool size limit")
+	}
+	if minRelayFee >= 0 {
+		a.Extra = append(a.Extra, "--minrelaytxfee="+relayFeeArg(minRelayFee))
+	}
+	return nil
+}
+
 // Arguments returns an array of arguments that be used to launch the
 // btcd instance
 func (a *btcdArgs) Arguments() []string {
 	args := []string{}
-	// --simnet
-	args = append(args, fmt.Sprintf("--%s", strings.ToLower(wire.SimNet.String())))
+	if a.cfg.BackendName == backendBitcoind {
+		// --regtest, the network of the bitcoind nodes
+		args = append(args, "--regtest")
+	} else {
+		// --simnet
+		args = append(args, fmt.Sprintf("--%s", strings.ToLower(wire.SimNet.String())))
+	}
 	if a.RPCUser != "" {
 		// --rpcuser
 		args = append(args, fmt.Sprintf("--rpcuser=%s", a.RPCUser))
@@ -104,9 +150,9 @@ func (a *btcdArgs) Arguments() []string {
 		args = append(args, fmt.Sprintf("--rpcconnect=%s", a.RPCConnect))
 	}
 	// --rpccert
-	args = append(args, fmt.Sprintf("--rpccert=%s", CertFile))
+	args = append(args, fmt.Sprintf("--rpccert=%s", a.certFile))
 	// --rpckey
-	args = append(args, fmt.Sprintf("--rpckey=%s", KeyFile))
+	args = append(args, fmt.Sprintf("--rpckey=%s", a.keyFile))
 	if a.DataDir != "" {
 		// --datadir
 		args = append(args, fmt.Sprintf("--datadir=%s", a.DataDir))
@@ -154,7 +200,7 @@ func (a *btcdArgs) Cleanup() error {
 	var err error
 	for _, dir := range dirs {
 		if err = os.RemoveAll(dir); err != nil {
-			log.Printf("Cannot remove dir %s: %v", dir, err)
+			log.Errorf("Cannot remove dir %s: %v", dir, err)
 		}
 	}
 	return err
@@ -1,14 +1,13 @@
-package main
+package btcsim
 
-import "testing"
+import (
+	"reflect"
+	"testing"
+)
 
 func TestnewBtcdArgs(t *testing.T) {
 	prefix := "miner"
-	err := genCertPair(CertFile, KeyFile)
-	if err != nil {
-		t.Errorf("genCertPair error: %v", err)
-	}
-	args, err := newBtcdArgs(prefix)
+	args, err := newBtcdArgs(DefaultConfig(), prefix)
 	defer args.Cleanup()
 	if err != nil {
 		t.Errorf("newBtcdArgs error: %v", err)
@@ -23,6 +22,7 @@ func TestnewBtcdArgs(t *testing.T) {
 		// don't test these literally
 		DataDir: "/tmp/user/1000/miner-data948809262",
 		LogDir:  "/tmp/user/1000/miner-logs649955253",
+		cfg:     DefaultConfig(),
 	}
 	if len(expectedArgs.Arguments()) != len(args.Arguments()) {
 		t.Errorf("newBtcdArgs wrong len expected: %v, got %v", len(expectedArgs.Arguments()), len(args.Arguments()))
@@ -40,3 +40,27 @@ func TestnewBtcdArgs(t *testing.T) {
 		}
 	}
 }
+
+func TestSetRelayPolicy(t *testing.T) {
+	if fee := relayFeeArg(2.5); fee != "0.00002500" {
+		t.Errorf("relayFeeArg(2.5) got %s want 0.00002500", fee)
+	}
+
+	btcd, err := newBtcdArgs(DefaultConfig(), "node")
+	if err != nil {
+		t.Fatalf("newBtcdArgs error: %v", err)
+	}
+	defer btcd.Cleanup()
+	if err := btcd.SetRelayPolicy(0, -1); err != nil || len(btcd.Extra) != 0 {
+		t.Errorf("btcd default relay policy got %v, %v", btcd.Extra, err)
+	}
+	if err := btcd.SetRelayPolicy(0, 10); err != nil {
+		t.Fatalf("btcd SetRelayPolicy error: %v", err)
+	}
+	if want := []string{"--minrelaytxfee=0.00010000"}; !reflect.DeepEqual(btcd.Extra, want) {
+		t.Errorf("btcd relay policy got %v want %v", btcd.Extra, want)
+	}
+	if err := btcd.SetRelayPolicy(50, -1); err == nil {
+		t.Errorf("btcd accepted a mempool size limit")
+	}
+}
@@ -0,0 +1,209 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+/*
+Package btcsim simulates bitcoin networks made of btcd or bitcoind nodes,
+wallets and actors sending transactions to each other, so that the behavior
+of the nodes and wallets can be observed under load.
+
+A simulation is configured by a Config, each field of which is set by a
+flag of the btcsim command. Other programs, such as the tests of a project
+embedding simulations, start from DefaultConfig and change the fields they
+need before creating one:
+
+	cfg := btcsim.DefaultConfig()
+	cfg.NumActors = 4
+	cfg.StopAfterBlocks = 20
+	sim, err := btcsim.New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sim.Start()
+	result := sim.Result(err)
+
+Start returns once the simulation finished. Its error is a failed check when
+the simulation ran but an assertion, the balance invariant or the wallet
+audit failed, and Summary returns what happened during the run.
+*/
+package btcsim
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"time"
+)
+
+// parseArgs returns the configuration set by the given command line
+// arguments, with the flags of the run replayed with -replay and those
+// implied by -scale unless given
+func parseArgs(args []string) (*Config, error) {
+	cfg := DefaultConfig()
+	fs := cfg.flagSet()
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if cfg.ReplayPath != "" {
+		if err := applyRecording(cfg, fs, args); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.ScaleMode {
+		if err := applyScaleMode(fs); err != nil {
+			return nil, fmt.Errorf("cannot apply scale mode: %v", err)
+		}
+	}
+	return cfg, nil
+}
+
+// initRun picks the seed of the run configured by c unless it is set and
+// creates its run dir
+func (c *Config) initRun() error {
+	// make sure the app data dir exists
+	if !fileExists(AppDataDir) {
+		if err := os.Mkdir(AppDataDir, 0700); err != nil {
+			return fmt.Errorf("cannot create app data dir: %v", err)
+		}
+	}
+
+	// all random decisions are derived from the seed so that a run can
+	// be reproduced by passing the same seed
+	if c.Seed == 0 {
+		c.Seed = time.Now().UnixNano()
+	}
+	log.Infof("Using seed %d", c.Seed)
+
+	if err := c.initRunDir(); err != nil {
+		return fmt.Errorf("cannot create run dir: %v", err)
+	}
+	log.Infof("Using run dir %s", c.runDir)
+	return nil
+}
+
+// campaign reports whether c configures a campaign of several runs
+func (c *Config) campaign() bool {
+	return c.BlockSizes != "" || c.SweepActors != "" || c.SweepTxRates != "" ||
+		c.SweepBlockIntervals != "" || c.BtcdVersions != "" || c.Runs > 1
+}
+
+// Main runs the btcsim command with the given arguments, without the
+// program name, and returns its exit code. Campaigns of several runs
+// execute the running program again for each run, so only commands
+// calling Main with their own arguments can run them. The ps and clean
+// commands, btcsim ps and btcsim clean, list and remove the data and
+// processes left by previous runs.
+func Main(args []string) int {
+	if len(args) > 0 {
+		switch args[0] {
+		case "clean":
+			return clean()
+		case "ps":
+			return ps()
+		}
+	}
+	cfg, err := parseArgs(args)
+	if err == flag.ErrHelp {
+		return exitPass
+	} else if err != nil {
+		log.Criticalf("%v", err)
+		return exitError
+	}
+	if err := log.SetLevel(cfg.LogLevelName); err != nil {
+		log.Criticalf("%v", err)
+		return exitError
+	}
+	// Keep the log output of this run apart from that of any other
+	// simulation running on the same machine
+	if cfg.RunID != 0 {
+		log.SetPrefix(fmt.Sprintf("run %d: ", cfg.RunID))
+	}
+	// Use all processor cores.
+	runtime.GOMAXPROCS(runtime.NumCPU())
+
+	if cfg.ProfileAddr != "" {
+		go func() {
+			listenAddr := net.JoinHostPort("", cfg.ProfileAddr)
+			log.Infof("Profile server listening on %s", listenAddr)
+			profileRedirect := http.RedirectHandler("/debug/pprof",
+				http.StatusSeeOther)
+			http.Handle("/", profileRedirect)
+			log.Errorf("Profile server: %v", http.ListenAndServe(listenAddr, nil))
+		}()
+	}
+
+	// campaigns run their simulations as child processes, keeping their
+	// summaries in the run dir of this one
+	if cfg.campaign() {
+		if err := cfg.initRun(); err != nil {
+			log.Criticalf("%v", err)
+			return exitError
+		}
+		return runCampaign(cfg)
+	}
+
+	simulation, err := New(cfg)
+	if err != nil {
+		log.Errorf("Cannot create simulation: %v", err)
+		return exitError
+	}
+	err = simulation.Start()
+	if _, failed := err.(*checkError); failed {
+		log.Errorf("Simulation failed: %v", err)
+	} else if err != nil {
+		log.Errorf("Cannot start simulation: %v", err)
+	}
+	if cfg.ResultsPath != "" {
+		if err := simulation.writeResults(cfg.ResultsPath, err); err != nil {
+			log.Errorf("Cannot write results: %v", err)
+		}
+	}
+	return exitCode(err)
+}
+
+// runCampaign runs the campaign of several runs configured by cfg and
+// returns its exit code
+func runCampaign(cfg *Config) int {
+	if cfg.BlockSizes != "" {
+		sizes, err := parseBlockSizes(cfg.BlockSizes)
+		if err != nil {
+			log.Errorf("Cannot run block size campaign: %v", err)
+			return exitError
+		}
+		return runsExitCode(runBlockSizes(cfg, sizes, cfg.Runs, cfg.Parallel))
+	}
+
+	if cfg.SweepActors != "" || cfg.SweepTxRates != "" || cfg.SweepBlockIntervals != "" {
+		points, err := parseSweep(cfg, cfg.SweepActors, cfg.SweepTxRates, cfg.SweepBlockIntervals)
+		if err != nil {
+			log.Errorf("Cannot run parameter sweep: %v", err)
+			return exitError
+		}
+		return runsExitCode(runSweep(cfg, points, cfg.Runs, cfg.Parallel))
+	}
+
+	if cfg.BtcdVersions != "" {
+		versions, err := parseVersions("btcd", cfg.BtcdVersions)
+		if err != nil {
+			log.Errorf("Cannot run btcd version campaign: %v", err)
+			return exitError
+		}
+		return runsExitCode(runBtcdVersions(cfg, versions, cfg.Runs, cfg.Parallel))
+	}
+
+	return runsExitCode(runSims(cfg, cfg.Runs, cfg.Parallel))
+}
+
+// runsExitCode returns the exit code of several runs which returned err,
+// the highest exit code of the runs when some failed
+func runsExitCode(err error) int {
+	if _, failed := err.(*checkError); failed {
+		log.Errorf("Simulations failed: %v", err)
+	} else if err != nil {
+		log.Errorf("Cannot run simulations: %v", err)
+	}
+	return exitCode(err)
+}
@@ -0,0 +1,101 @@
+package btcsim
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseArgsInvalidFlag(t *testing.T) {
+	// invalid arguments are returned rather than exiting the process
+	if _, err := parseArgs([]string{"-nosuchflag"}); err == nil {
+		t.Errorf("parseArgs accepted an unknown flag")
+	}
+	if _, err := parseArgs([]string{"-actors", "many"}); err == nil {
+		t.Errorf("parseArgs accepted an invalid value")
+	}
+}
+
+func TestParseArgs(t *testing.T) {
+	cfg, err := parseArgs([]string{"-actors", "4", "-duration", "1m"})
+	if err != nil {
+		t.Fatalf("parseArgs error: %v", err)
+	}
+	if cfg.NumActors != 4 || cfg.Duration != time.Minute {
+		t.Errorf("parseArgs got %d actors for %v, want 4 for 1m", cfg.NumActors, cfg.Duration)
+	}
+	// every parse starts from the defaults
+	if cfg, err = parseArgs(nil); err != nil {
+		t.Fatalf("parseArgs error: %v", err)
+	}
+	if !reflect.DeepEqual(cfg, DefaultConfig()) {
+		t.Errorf("parseArgs kept the values of a previous parse")
+	}
+}
+
+func TestFlagsApart(t *testing.T) {
+	// the flags of programs embedding simulations are left alone
+	if flag.Lookup("actors") != nil {
+		t.Errorf("simulation flag defined on the command line flags")
+	}
+	fs := DefaultConfig().flagSet()
+	if fs.Lookup("actors") == nil || fs.Lookup("duration") == nil {
+		t.Errorf("simulation flags missing")
+	}
+}
+
+func TestConfigArgs(t *testing.T) {
+	cfg := DefaultConfig()
+	if args := cfg.args(nil); len(args) != 0 {
+		t.Errorf("args of the default config = %v, want none", args)
+	}
+	cfg.NumActors = 4
+	cfg.Duration = time.Minute
+	cfg.Seed = 7
+	args := cfg.args(map[string]bool{"seed": true})
+	want := []string{"-actors=4", "-stop-after-duration=1m0s"}
+	if !reflect.DeepEqual(args, want) {
+		t.Errorf("args = %v, want %v", args, want)
+	}
+	// the args set the same configuration again
+	parsed, err := parseArgs(args)
+	if err != nil {
+		t.Fatalf("parseArgs error: %v", err)
+	}
+	if parsed.NumActors != 4 || parsed.Duration != time.Minute || parsed.Seed != 0 {
+		t.Errorf("parseArgs(%v) = %d actors, %v, seed %d", args, parsed.NumActors, parsed.Duration, parsed.Seed)
+	}
+}
+
+func TestNewCopiesConfig(t *testing.T) {
+	root, err := ioutil.TempDir("", "btcsim-new")
+	if err != nil {
+		t.Fatalf("TempDir error: %v", err)
+	}
+	defer os.RemoveAll(root)
+	prevRoot := runsRoot
+	defer func() { runsRoot = prevRoot }()
+	runsRoot = root
+
+	cfg := DefaultConfig()
+	first, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+	second, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+	if cfg.Seed != 0 || cfg.runDir != "" {
+		t.Errorf("New changed the config passed to it")
+	}
+	if first.cfg.Seed == 0 || first.cfg.runDir == "" {
+		t.Errorf("New did not pick a seed and run dir")
+	}
+	if first.cfg.runDir == second.cfg.runDir {
+		t.Errorf("simulations share the run dir %s", first.cfg.runDir)
+	}
+}
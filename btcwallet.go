@@ -1,9 +1,8 @@
-package main
+package btcsim
 
 import (
 	"fmt"
 	"io/ioutil"
-	"log"
 	"os"
 	"os/exec"
 	"strings"
@@ -31,21 +30,31 @@ type btcwalletArgs struct {
 	prefix   string
 	exe      string
 	endpoint string
+
+	// cfg is the configuration of the run the node belongs to
+	cfg *Config
+
+	// certFile and keyFile are the cert pair of the rpc server, generated
+	// in the data directory, whose cert is the Certificates the clients
+	// trust. CAFile is the cert of the btcd node.
+	certFile string
+	keyFile  string
 }
 
-// newBtcwalletArgs returns a btcwalletArgs with all default values
-func newBtcwalletArgs(port uint16, nodeArgs *btcdArgs) (*btcwalletArgs, error) {
+// newBtcwalletArgs returns a btcwalletArgs with all default values for the
+// run configured by cfg
+func newBtcwalletArgs(cfg *Config, port uint16, nodeArgs *btcdArgs) (*btcwalletArgs, error) {
 	a := &btcwalletArgs{
-		RPCListen:    fmt.Sprintf("127.0.0.1:%d", port),
-		RPCConnect:   "127.0.0.1:18556",
-		Username:     "user",
-		Password:     "pass",
-		Certificates: nodeArgs.certificates,
-		CAFile:       CertFile,
+		RPCListen:  fmt.Sprintf("127.0.0.1:%d", port),
+		RPCConnect: nodeArgs.RPCListen,
+		Username:   "user",
+		Password:   "pass",
+		CAFile:     nodeArgs.certFile,
 
 		prefix:   fmt.Sprintf("actor-%d", port),
-		exe:      "btcwallet",
+		exe:      cfg.BtcwalletExe,
 		endpoint: "ws",
+		cfg:      cfg,
 	}
 	if err := a.SetDefaults(); err != nil {
 		return nil, err
@@ -54,19 +63,25 @@ func newBtcwalletArgs(port uint16, nodeArgs *btcdArgs) (*btcwalletArgs, error) {
 }
 
 // SetDefaults sets the default values of args
-// it creates tmp data and log directories and must
-// be cleaned up by calling Cleanup
+// it creates tmp data and log directories, with the cert pair of the rpc
+// server in the data directory, and must be cleaned up by calling Cleanup
 func (a *btcwalletArgs) SetDefaults() error {
-	datadir, err := ioutil.TempDir("", a.prefix+"-data")
+	datadir, err := a.cfg.tempDir(a.prefix + "-data")
 	if err != nil {
 		return err
 	}
 	a.DataDir = datadir
-	logdir, err := ioutil.TempDir("", a.prefix+"-logs")
+	logdir, err := a.cfg.tempDir(a.prefix + "-logs")
 	if err != nil {
 		return err
 	}
 	a.LogDir = logdir
+	if a.certFile, a.keyFile, err = newCertPair(a.cfg, a.DataDir); err != nil {
+		return err
+	}
+	if a.Certificates, err = ioutil.ReadFile(a.certFile); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -98,9 +113,9 @@ func (a *btcwalletArgs) Arguments() []string {
 		args = append(args, fmt.Sprintf("--rpcconnect=%s", a.RPCConnect))
 	}
 	// --rpccert
-	args = append(args, fmt.Sprintf("--rpccert=%s", CertFile))
+	args = append(args, fmt.Sprintf("--rpccert=%s", a.certFile))
 	// --rpckey
-	args = append(args, fmt.Sprintf("--rpckey=%s", KeyFile))
+	args = append(args, fmt.Sprintf("--rpckey=%s", a.keyFile))
 	if a.CAFile != "" {
 		// --cafile
 		args = append(args, fmt.Sprintf("--cafile=%s", a.CAFile))
@@ -152,7 +167,7 @@ func (a *btcwalletArgs) Cleanup() error {
 	var err error
 	for _, dir := range dirs {
 		if err = os.RemoveAll(dir); err != nil {
-			log.Printf("Cannot remove dir %s: %v", dir, err)
+			log.Errorf("Cannot remove dir %s: %v", dir, err)
 		}
 	}
 	return err
@@ -1,20 +1,19 @@
-package main
+package btcsim
 
 import (
 	"os"
+	"path/filepath"
 	"testing"
 )
 
 func TestnewBtcwalletArgs(t *testing.T) {
-	btcdArgs, err := newBtcdArgs("node")
-	args, err := newBtcwalletArgs(18554, btcdArgs)
+	btcdArgs, err := newBtcdArgs(DefaultConfig(), "node")
+	args, err := newBtcwalletArgs(DefaultConfig(), 18554, btcdArgs)
 	defer btcdArgs.Cleanup()
 	defer args.Cleanup()
 	if err != nil {
 		t.Errorf("newBtcwalletArgs error: %v", err)
 	}
-	defer os.Remove(CertFile)
-	defer os.Remove(KeyFile)
 	expectedArgs := &btcwalletArgs{
 		// fixed
 		RPCListen:  "127.0.0.1:18554",
@@ -43,3 +42,37 @@ func TestnewBtcwalletArgs(t *testing.T) {
 		}
 	}
 }
+
+func TestCertPairs(t *testing.T) {
+	node, err := newBtcdArgs(DefaultConfig(), "node")
+	if err != nil {
+		t.Fatalf("newBtcdArgs error: %v", err)
+	}
+	defer node.Cleanup()
+	wallet, err := newBtcwalletArgs(DefaultConfig(), 18554, node)
+	if err != nil {
+		t.Fatalf("newBtcwalletArgs error: %v", err)
+	}
+
+	// every process has a cert pair of its own in its data directory, the
+	// wallet trusting the cert of its node
+	for _, f := range []string{node.certFile, node.keyFile, wallet.certFile, wallet.keyFile} {
+		if !fileExists(f) {
+			t.Errorf("cert pair file %s not generated", f)
+		}
+	}
+	if filepath.Dir(node.certFile) != node.DataDir || filepath.Dir(wallet.certFile) != wallet.DataDir {
+		t.Errorf("cert pairs %s and %s not in the data directories", node.certFile, wallet.certFile)
+	}
+	if wallet.CAFile != node.certFile {
+		t.Errorf("wallet trusts %s, want the cert of its node %s", wallet.CAFile, node.certFile)
+	}
+	if string(node.RPCConnConfig().Certificates) == "" || wallet.certFile == node.certFile {
+		t.Errorf("cert pairs shared between the node and the wallet")
+	}
+
+	wallet.Cleanup()
+	if _, err := os.Stat(wallet.certFile); !os.IsNotExist(err) {
+		t.Errorf("cert of the wallet not removed by Cleanup")
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// blockBurst releases a burst of transactions right after every block,
+// on top of the transaction rate, modeling the mempool refilling once a
+// block is found
+type blockBurst struct {
+	// size is the number of transactions of every burst
+	size int
+
+	// decay is the time constant of the exponential decay of the rate of
+	// the burst, the whole burst is released at once if zero
+	decay time.Duration
+}
+
+// parseBlockBurst parses a block burst given as its size and decay
+// separated by a colon, e.g. "200:30s". The empty string returns a nil
+// burst.
+func parseBlockBurst(s string) (*blockBurst, error) {
+	if s == "" {
+		return nil, nil
+	}
+	fields := strings.Split(s, ":")
+	if len(fields) != 2 {
+		return nil, fmt.Errorf("block burst %q: expected parameters size:decay", s)
+	}
+	size, err := strconv.Atoi(fields[0])
+	if err != nil || size <= 0 {
+		return nil, fmt.Errorf("block burst %q: invalid size %q", s, fields[0])
+	}
+	decay, err := time.ParseDuration(fields[1])
+	if err != nil || decay < 0 {
+		return nil, fmt.Errorf("block burst %q: invalid decay %q", s, fields[1])
+	}
+	return &blockBurst{size: size, decay: decay}, nil
+}
+
+// checkBlockBurst returns an error if block bursts are released while the
+// transaction rate is not limited, as they would make no difference
+func checkBlockBurst(b *blockBurst, rate float64, load *loadProfile) error {
+	if b != nil && rate <= 0 && load == nil {
+		return errors.New("block bursts need a limited rate, set with -txrate or -load")
+	}
+	return nil
+}
+
+// rate returns the rate of the burst the given time after a block, such
+// that size transactions are released in total. It is zero when the
+// burst is released at once.
+func (b *blockBurst) rate(since time.Duration) float64 {
+	if b == nil || b.decay == 0 {
+		return 0
+	}
+	tau := b.decay.Seconds()
+	return float64(b.size) / tau * math.Exp(-since.Seconds()/tau)
+}
+
+// String returns the burst as it is parsed
+func (b *blockBurst) String() string {
+	return fmt.Sprintf("%d:%v", b.size, b.decay)
+}
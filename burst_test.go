@@ -0,0 +1,94 @@
+package btcsim
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestParseBlockBurst(t *testing.T) {
+	b, err := parseBlockBurst("200:30s")
+	if err != nil {
+		t.Fatalf("parseBlockBurst error: %v", err)
+	}
+	if b.size != 200 || b.decay != 30*time.Second || b.String() != "200:30s" {
+		t.Errorf("parseBlockBurst got %v", b)
+	}
+	if b, err := parseBlockBurst(""); b != nil || err != nil {
+		t.Errorf("parseBlockBurst got %v, %v want nil, nil", b, err)
+	}
+	for _, s := range []string{"200", "0:30s", "-5:30s", "200:soon", "200:-1s"} {
+		if _, err := parseBlockBurst(s); err == nil {
+			t.Errorf("parseBlockBurst(%q) expected error", s)
+		}
+	}
+}
+
+func TestBlockBurstRate(t *testing.T) {
+	b := &blockBurst{size: 100, decay: 10 * time.Second}
+	if got := b.rate(0); got != 10 {
+		t.Errorf("rate right after the block got %v want 10", got)
+	}
+	if got := b.rate(10 * time.Second); math.Abs(got-10/math.E) > 1e-9 {
+		t.Errorf("rate after the decay got %v want %v", got, 10/math.E)
+	}
+	// the whole burst is released over time
+	var total float64
+	for s := 0; s < 1000; s++ {
+		total += b.rate(time.Duration(s)*time.Second + 500*time.Millisecond)
+	}
+	if math.Abs(total-100) > 1 {
+		t.Errorf("burst released %v transactions want 100", total)
+	}
+
+	// a burst released at once and no burst have no rate
+	if got := (&blockBurst{size: 100}).rate(0); got != 0 {
+		t.Errorf("rate of a burst released at once got %v want 0", got)
+	}
+	var none *blockBurst
+	if got := none.rate(0); got != 0 {
+		t.Errorf("rate of no burst got %v want 0", got)
+	}
+}
+
+func TestCheckBlockBurst(t *testing.T) {
+	b := &blockBurst{size: 10}
+	if err := checkBlockBurst(b, 0, nil); err == nil {
+		t.Errorf("burst without rate limit expected error")
+	}
+	if err := checkBlockBurst(b, 5, nil); err != nil {
+		t.Errorf("burst with -txrate error: %v", err)
+	}
+	if err := checkBlockBurst(nil, 0, nil); err != nil {
+		t.Errorf("no burst error: %v", err)
+	}
+}
+
+func TestThrottleGrant(t *testing.T) {
+	th := newThrottle(0.001)
+	defer th.stop()
+	th.grant(2)
+	exit := make(chan struct{})
+	for i := 0; i < 2; i++ {
+		done := make(chan bool, 1)
+		go func() { done <- th.wait(exit) }()
+		select {
+		case ok := <-done:
+			if !ok {
+				t.Errorf("granted transaction %d not allowed", i)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("granted transaction %d blocked", i)
+		}
+	}
+	// the grants are used up
+	done := make(chan bool, 1)
+	go func() { done <- th.wait(exit) }()
+	select {
+	case <-done:
+		t.Errorf("transaction allowed after the grants were used up")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(exit)
+	<-done
+}
@@ -0,0 +1,108 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"bytes"
+	"sync/atomic"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+// changeIndex returns the index of the first output of a transaction
+// which pays none of the given addresses and is worth spending, -1 if
+// there is none
+func changeIndex(msgTx *wire.MsgTx, paid []btcutil.Address) (int, error) {
+	scripts := make([][]byte, len(paid))
+	for i, addr := range paid {
+		script, err := txscript.PayToAddrScript(addr)
+		if err != nil {
+			return -1, err
+		}
+		scripts[i] = script
+	}
+out:
+	for i, txOut := range msgTx.TxOut {
+		if btcutil.Amount(txOut.Value) < minFee {
+			continue
+		}
+		for _, script := range scripts {
+			if bytes.Equal(txOut.PkScript, script) {
+				continue out
+			}
+		}
+		return i, nil
+	}
+	return -1, nil
+}
+
+// chain spends the change of a transaction paying the given addresses
+// back to the actor, then the output of that transaction and so on, so
+// that up to depth unconfirmed transactions descend from the first one.
+// The chain stops early when an output is too small to pay the fee or a
+// transaction is rejected, for instance for exceeding the ancestor limits
+// of the mempool.
+func (a *Actor) chain(parent *wire.MsgTx, paid []btcutil.Address, depth int, txSent chan<- *TxRecord) {
+	index, err := changeIndex(parent, paid)
+	if err != nil {
+		log.Errorf("%s: Cannot find change output: %v", a, err)
+		return
+	}
+	if index < 0 {
+		// the transaction has no change to spend
+		return
+	}
+
+	fee := a.feeForSize(estimateTxSize(1, 1))
+	for d := 1; d <= depth; d++ {
+		in := btcutil.Amount(parent.TxOut[index].Value)
+		if in-fee < minFee {
+			return
+		}
+		hash := parent.TxSha()
+		op := wire.NewOutPoint(&hash, uint32(index))
+		inputs := []btcjson.TransactionInput{{
+			Txid: hash.String(),
+			Vout: op.Index,
+		}}
+		to := a.ownedAddresses[a.rand.Int()%len(a.ownedAddresses)]
+		amounts := map[btcutil.Address]btcutil.Amount{to: in - fee}
+		msgTx, err := a.createRawTransaction(inputs, amounts)
+		if err != nil {
+			log.Errorf("%s: Cannot create chained transaction: %v", a, err)
+			return
+		}
+
+		// the output must not be queued once the parent is mined, and
+		// the chained transaction is not a requested transaction
+		a.markSpent(*op)
+		a.untracked.add(msgTx.TxSha())
+		if _, err := a.client.SendRawTransaction(msgTx, false); err != nil {
+			a.unmarkSpent(*op)
+			a.untracked.take(msgTx.TxSha())
+			atomic.AddUint64(&a.chainRejected, 1)
+			log.Debugf("%s: Chained transaction at depth %d rejected: %v", a, d, err)
+			return
+		}
+		r := a.newTxRecord(msgTx, in)
+		r.Parent = hash.String()
+		r.Depth = d
+		select {
+		case txSent <- r:
+		case <-a.quit:
+			return
+		}
+		parent, index = msgTx, 0
+	}
+}
+
+// ChainRejected returns the number of chained transactions of the actor
+// which were rejected
+func (a *Actor) ChainRejected() uint64 {
+	return atomic.LoadUint64(&a.chainRejected)
+}
@@ -0,0 +1,41 @@
+package btcsim
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/wire"
+)
+
+func TestChangeIndex(t *testing.T) {
+	msgTx := &wire.MsgTx{TxOut: []*wire.TxOut{
+		{Value: 0, PkScript: []byte{0x6a}},
+		{Value: int64(minFee / 2), PkScript: []byte{1}},
+		{Value: int64(minFee), PkScript: []byte{2}},
+	}}
+	index, err := changeIndex(msgTx, nil)
+	if err != nil {
+		t.Fatalf("changeIndex error: %v", err)
+	}
+	if index != 2 {
+		t.Errorf("changeIndex got %d want 2", index)
+	}
+
+	msgTx.TxOut = msgTx.TxOut[:2]
+	if index, _ := changeIndex(msgTx, nil); index != -1 {
+		t.Errorf("changeIndex got %d want -1 without outputs worth spending", index)
+	}
+}
+
+func TestReadProfilesChain(t *testing.T) {
+	ps, err := readProfiles(strings.NewReader("chainer,1,1,1,random,,,,,,50"))
+	if err != nil {
+		t.Fatalf("readProfiles error: %v", err)
+	}
+	if ps[0].Chain != 50 {
+		t.Errorf("readProfiles got chain depth %d want 50", ps[0].Chain)
+	}
+	if _, err := readProfiles(strings.NewReader("chainer,1,1,1,random,,,,,,-1")); err == nil {
+		t.Errorf("readProfiles expected error")
+	}
+}
@@ -0,0 +1,42 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"time"
+)
+
+// chaos runs as a goroutine and kills the wallet process of a random
+// actor on average every interval, until the simulation exits. Actors
+// restart their wallet by themselves when chaos is enabled.
+func (com *Communication) chaos(interval time.Duration) {
+	defer com.wg.Done()
+
+	r := com.cfg.newRand(chaosStream)
+	for {
+		select {
+		case <-time.After(time.Duration(r.ExpFloat64() * float64(interval))):
+		case <-com.exit:
+			return
+		}
+
+		var running []*Actor
+		for _, a := range com.Actors() {
+			if a.running() {
+				running = append(running, a)
+			}
+		}
+		if len(running) == 0 {
+			continue
+		}
+		a := running[r.Intn(len(running))]
+		log.Infof("%s: Killing wallet process", a)
+		if err := a.Kill(); err != nil {
+			log.Errorf("%s: Cannot kill wallet process: %v", a, err)
+			continue
+		}
+		com.crashes++
+	}
+}
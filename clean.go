@@ -0,0 +1,268 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// processAlive reports whether the process with the given pid is running.
+// It is set during init depending on the platform, and otherwise reports
+// every process alive so that no run dir is removed while it is in use.
+var processAlive = func(pid int) bool { return true }
+
+// runProcess is a node process of a run, found from its pid file
+type runProcess struct {
+	name string
+	pid  int
+}
+
+// runInfo describes a run dir left in runsRoot
+type runInfo struct {
+	dir string
+	// pid is the pid of the btcsim process of the run, zero if unknown
+	pid int
+	// running is set while the btcsim process of the run is alive
+	running bool
+	size    int64
+	// procs are the node processes of the run which are still alive,
+	// stranded by a crashed btcsim process unless the run is running
+	procs []*runProcess
+}
+
+// readPidFile returns the pid written to the file at path, zero if there
+// is none
+func readPidFile(path string) int {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil || pid <= 0 {
+		return 0
+	}
+	return pid
+}
+
+// runInProgress reports whether the run dir belongs to a simulation which
+// is still running, that is whether the btcsim process whose pid it holds
+// is alive. A run dir only appears in runsRoot once it holds the pid, so
+// those without one are not in progress.
+func runInProgress(dir string) bool {
+	pid := readPidFile(filepath.Join(dir, runPidFile))
+	return pid != 0 && (pid == os.Getpid() || processAlive(pid))
+}
+
+// readRun returns the state of the run dir and of its node processes
+func readRun(dir string) (*runInfo, error) {
+	r := &runInfo{
+		dir:     dir,
+		pid:     readPidFile(filepath.Join(dir, runPidFile)),
+		running: runInProgress(dir),
+		size:    dirSize(dir),
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pid"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		if filepath.Base(path) == runPidFile {
+			continue
+		}
+		name := strings.TrimSuffix(filepath.Base(path), ".pid")
+		if pid := readPidFile(path); pid != 0 && processAlive(pid) {
+			r.procs = append(r.procs, &runProcess{name: name, pid: pid})
+		}
+	}
+	return r, nil
+}
+
+// listRuns returns the run dirs in root sorted by name
+func listRuns(root string) ([]*runInfo, error) {
+	infos, err := ioutil.ReadDir(root)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var runs []*runInfo
+	for _, info := range infos {
+		if !info.IsDir() || !strings.HasPrefix(info.Name(), "run-") {
+			continue
+		}
+		r, err := readRun(filepath.Join(root, info.Name()))
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, r)
+	}
+	return runs, nil
+}
+
+// writeRuns writes a line per run and per node process of the run to w
+func writeRuns(w io.Writer, runs []*runInfo) error {
+	for _, r := range runs {
+		state := "finished"
+		switch {
+		case r.running:
+			state = fmt.Sprintf("running (pid %d)", r.pid)
+		case len(r.procs) > 0:
+			state = "crashed"
+		}
+		_, err := fmt.Fprintf(w, "%s\t%s\t%.1f MB\n", r.dir, state,
+			float64(r.size)/1e6)
+		if err != nil {
+			return err
+		}
+		for _, p := range r.procs {
+			if _, err := fmt.Fprintf(w, "\t%s\tpid %d\n", p.name, p.pid); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// readProcCmdline returns the command line of a process from procfs mounted
+// at root
+func readProcCmdline(root string, pid int) (string, error) {
+	b, err := ioutil.ReadFile(filepath.Join(root, strconv.Itoa(pid), "cmdline"))
+	if err != nil {
+		return "", err
+	}
+	return strings.Replace(string(b), "\x00", " ", -1), nil
+}
+
+// psCmdline returns the command line of a process as listed by ps, for the
+// platforms without procfs such as darwin and the BSDs
+func psCmdline(pid int) (string, error) {
+	out, err := exec.Command("ps", "-o", "command=", "-p", strconv.Itoa(pid)).Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// cmdlineOfPid returns the command line of the process with the given pid,
+// from procfs when it is mounted and from ps otherwise. It is replaced by
+// the tests.
+var cmdlineOfPid = func(pid int) (string, error) {
+	if _, err := os.Stat(procRoot); err != nil {
+		return psCmdline(pid)
+	}
+	return readProcCmdline(procRoot, pid)
+}
+
+// stopProcess interrupts a stranded node process and kills it if it does
+// not exit within stopTimeout. It is not a child of this process, so it is
+// polled until it exits.
+func stopProcess(pid int) error {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	if err := p.Signal(os.Interrupt); err != nil {
+		return p.Kill()
+	}
+	deadline := time.Now().Add(stopTimeout)
+	for processAlive(pid) {
+		if time.Now().After(deadline) {
+			log.Warnf("Process %d did not exit after %v, killing it", pid, stopTimeout)
+			return p.Kill()
+		}
+		time.Sleep(probeInterval)
+	}
+	return nil
+}
+
+// stopStranded stops the node processes stranded by the run. A process is
+// only stopped once its command line shows it is a node of the run, as its
+// pid may have been reused since; the run is kept if that cannot be told.
+func stopStranded(r *runInfo) error {
+	for _, p := range r.procs {
+		cmdline, err := cmdlineOfPid(p.pid)
+		if err != nil && !processAlive(p.pid) {
+			// the process exited since the run was read
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("cannot tell whether process %d is %s: %v",
+				p.pid, p.name, err)
+		}
+		if !strings.Contains(cmdline, r.dir) {
+			// the pid was reused by another process
+			continue
+		}
+		log.Infof("Stopping stranded %s (pid %d) of %s", p.name, p.pid, r.dir)
+		if err := stopProcess(p.pid); err != nil {
+			return fmt.Errorf("cannot stop %s (pid %d): %v", p.name, p.pid, err)
+		}
+	}
+	return nil
+}
+
+// cleanRuns stops the processes stranded by the runs in root which are
+// not in progress anymore, removes their run dirs and returns their paths
+func cleanRuns(root string) ([]string, error) {
+	runs, err := listRuns(root)
+	if err != nil {
+		return nil, err
+	}
+	var removed []string
+	for _, r := range runs {
+		if r.running {
+			log.Infof("Keeping run dir %s of a running simulation", r.dir)
+			continue
+		}
+		if err := stopStranded(r); err != nil {
+			log.Warnf("Keeping run dir %s: %v", r.dir, err)
+			continue
+		}
+		if err := os.RemoveAll(r.dir); err != nil {
+			return removed, err
+		}
+		removed = append(removed, r.dir)
+	}
+	return removed, nil
+}
+
+// clean runs the btcsim clean command, which stops the processes stranded
+// by crashed runs and removes the data of the runs which are not in
+// progress
+func clean() int {
+	removed, err := cleanRuns(runsRoot)
+	for _, dir := range removed {
+		log.Infof("Removed run dir %s", dir)
+	}
+	if err != nil {
+		log.Errorf("Cannot clean run dirs: %v", err)
+		return exitError
+	}
+	log.Infof("Removed %d run dirs from %s", len(removed), runsRoot)
+	return exitPass
+}
+
+// ps runs the btcsim ps command, which lists the run dirs with their state
+// and size, and the node processes still alive in each
+func ps() int {
+	runs, err := listRuns(runsRoot)
+	if err == nil {
+		err = writeRuns(os.Stdout, runs)
+	}
+	if err != nil {
+		log.Errorf("Cannot list run dirs: %v", err)
+		return exitError
+	}
+	return exitPass
+}
@@ -0,0 +1,219 @@
+package btcsim
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCleanRuns(t *testing.T) {
+	root, err := ioutil.TempDir("", "btcsim-clean")
+	if err != nil {
+		t.Fatalf("TempDir error: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	prevRoot, prevAlive := runsRoot, processAlive
+	defer func() { runsRoot, processAlive = prevRoot, prevAlive }()
+	runsRoot = filepath.Join(root, "runs")
+	processAlive = func(pid int) bool { return pid == 42 }
+
+	// a run dir of this process is in progress
+	cfg := &Config{RunID: 3}
+	if err := cfg.initRunDir(); err != nil {
+		t.Fatalf("initRunDir error: %v", err)
+	}
+	current := cfg.runDir
+	if !strings.HasPrefix(filepath.Base(current), "run-3-") ||
+		readPidFile(filepath.Join(current, runPidFile)) != os.Getpid() {
+		t.Errorf("got run dir %s without the pid of the process", current)
+	}
+
+	mkRun := func(name, pid string) string {
+		dir := filepath.Join(runsRoot, name)
+		if err := os.Mkdir(dir, 0700); err != nil {
+			t.Fatalf("Mkdir error: %v", err)
+		}
+		if pid != "" {
+			err := ioutil.WriteFile(filepath.Join(dir, runPidFile), []byte(pid), 0600)
+			if err != nil {
+				t.Fatalf("WriteFile error: %v", err)
+			}
+		}
+		return dir
+	}
+	running := mkRun("run-0-running", "42\n")
+	finished := mkRun("run-0-finished", "43\n")
+	nopid := mkRun("run-1-nopid", "")
+	other := mkRun("state", "")
+	// a run dir being set up has no pid yet
+	setup := mkRun(".run-2-setup", "")
+
+	removed, err := cleanRuns(runsRoot)
+	if err != nil {
+		t.Fatalf("cleanRuns error: %v", err)
+	}
+	if len(removed) != 2 {
+		t.Errorf("cleanRuns removed %v, want 2 run dirs", removed)
+	}
+	for _, dir := range []string{current, running, other, setup} {
+		if !fileExists(dir) {
+			t.Errorf("%s removed", dir)
+		}
+	}
+	for _, dir := range []string{finished, nopid} {
+		if fileExists(dir) {
+			t.Errorf("%s not removed", dir)
+		}
+	}
+
+	// there is nothing to clean before the first run
+	if removed, err := cleanRuns(filepath.Join(root, "missing")); err != nil || removed != nil {
+		t.Errorf("cleanRuns of a missing root = %v, %v", removed, err)
+	}
+}
+
+func TestRunInProgress(t *testing.T) {
+	dir, err := ioutil.TempDir("", "btcsim-run")
+	if err != nil {
+		t.Fatalf("TempDir error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		pid  string
+		want bool
+	}{
+		{fmt.Sprintf("%d\n", os.Getpid()), true},
+		{"garbage", false},
+		{"0", false},
+	}
+	for _, test := range tests {
+		err := ioutil.WriteFile(filepath.Join(dir, runPidFile), []byte(test.pid), 0600)
+		if err != nil {
+			t.Fatalf("WriteFile error: %v", err)
+		}
+		if got := runInProgress(dir); got != test.want {
+			t.Errorf("runInProgress with pid %q = %v, want %v", test.pid, got, test.want)
+		}
+	}
+}
+
+func TestWriteRuns(t *testing.T) {
+	runs := []*runInfo{
+		{dir: "/runs/run-0-a", pid: 7, running: true, size: 2e6,
+			procs: []*runProcess{{"btcd-main", 8}}},
+		{dir: "/runs/run-0-b", size: 5e5, procs: []*runProcess{{"btcd-main", 9}}},
+		{dir: "/runs/run-1-c"},
+	}
+	var b bytes.Buffer
+	if err := writeRuns(&b, runs); err != nil {
+		t.Fatalf("writeRuns error: %v", err)
+	}
+	want := "/runs/run-0-a\trunning (pid 7)\t2.0 MB\n" +
+		"\tbtcd-main\tpid 8\n" +
+		"/runs/run-0-b\tcrashed\t0.5 MB\n" +
+		"\tbtcd-main\tpid 9\n" +
+		"/runs/run-1-c\tfinished\t0.0 MB\n"
+	if b.String() != want {
+		t.Errorf("writeRuns wrote %q, want %q", b.String(), want)
+	}
+}
+
+func TestStopStranded(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no sleep command")
+	}
+	dir, err := ioutil.TempDir("", "run-0-")
+	if err != nil {
+		t.Fatalf("TempDir error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// start a stranded node of the run, and a process which reused the
+	// pid of another node of the run
+	start := func(name string) *exec.Cmd {
+		cmd := exec.Command("sleep", "30")
+		if err := cmd.Start(); err != nil {
+			t.Fatalf("Start error: %v", err)
+		}
+		pid := []byte(fmt.Sprintf("%d\n", cmd.Process.Pid))
+		err := ioutil.WriteFile(filepath.Join(dir, name+".pid"), pid, 0600)
+		if err != nil {
+			t.Fatalf("WriteFile error: %v", err)
+		}
+		return cmd
+	}
+	node, other := start("btcd-main"), start("btcwallet-main")
+	defer other.Process.Kill()
+	exited := make(chan error, 1)
+	go func() { exited <- node.Wait() }()
+
+	prev := cmdlineOfPid
+	defer func() { cmdlineOfPid = prev }()
+	cmdlineOfPid = func(pid int) (string, error) {
+		if pid == node.Process.Pid {
+			return "btcd --datadir=" + filepath.Join(dir, "btcd-main-data"), nil
+		}
+		return "sleep 30", nil
+	}
+
+	r, err := readRun(dir)
+	if err != nil {
+		t.Fatalf("readRun error: %v", err)
+	}
+	if r.running || len(r.procs) != 2 {
+		t.Fatalf("readRun = %+v, want a crashed run with 2 processes", r)
+	}
+	if err := stopStranded(r); err != nil {
+		t.Fatalf("stopStranded error: %v", err)
+	}
+	select {
+	case <-exited:
+	case <-time.After(time.Second):
+		t.Errorf("stranded node not stopped")
+	}
+	if !processAlive(other.Process.Pid) {
+		t.Errorf("process which reused the pid of a node stopped")
+	}
+
+	// the run is kept when the processes cannot be told apart
+	cmdlineOfPid = func(pid int) (string, error) {
+		return "", os.ErrNotExist
+	}
+	if err := stopStranded(r); err == nil || !strings.Contains(err.Error(), "cannot tell") {
+		t.Errorf("stopStranded of unknown processes returned %v", err)
+	}
+	// unless they exited since
+	other.Process.Kill()
+	other.Wait()
+	if err := stopStranded(r); err != nil {
+		t.Errorf("stopStranded of exited processes error: %v", err)
+	}
+}
+
+func TestPsCmdline(t *testing.T) {
+	if _, err := exec.LookPath("ps"); err != nil {
+		t.Skip("ps not found")
+	}
+	cmd := exec.Command("sleep", "30")
+	if err := cmd.Start(); err != nil {
+		t.Skipf("cannot start sleep: %v", err)
+	}
+	defer cmd.Wait()
+	defer cmd.Process.Kill()
+	cmdline, err := psCmdline(cmd.Process.Pid)
+	if err != nil {
+		t.Fatalf("psCmdline error: %v", err)
+	}
+	if cmdline != "sleep 30" {
+		t.Errorf("psCmdline = %q, want %q", cmdline, "sleep 30")
+	}
+}
@@ -0,0 +1,42 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// The durations and rates given on the command line are in simulated
+// time, which runs timeScale times faster than real time. This covers the
+// block interval, the intervals of the actors' activities, the scenario
+// and load schedules and the transaction rate, while timeouts guarding
+// rpc calls and processes stay in real time.
+
+// checkTimeScale returns an error if the time scale is not a positive
+// number
+func checkTimeScale(scale float64) error {
+	if !(scale > 0) || math.IsInf(scale, 0) {
+		return fmt.Errorf("invalid time scale %v, it must be positive", scale)
+	}
+	return nil
+}
+
+// realDuration returns the real time a simulated duration lasts
+func (c *Config) realDuration(d time.Duration) time.Duration {
+	return time.Duration(float64(d) / c.TimeScale)
+}
+
+// simDuration returns the simulated time elapsed during a real duration
+func (c *Config) simDuration(d time.Duration) time.Duration {
+	return time.Duration(float64(d) * c.TimeScale)
+}
+
+// realRate returns the number of events per real second of a rate given
+// per simulated second
+func (c *Config) realRate(rate float64) float64 {
+	return rate * c.TimeScale
+}
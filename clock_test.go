@@ -0,0 +1,33 @@
+package btcsim
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestCheckTimeScale(t *testing.T) {
+	for _, scale := range []float64{1, 0.5, 100} {
+		if err := checkTimeScale(scale); err != nil {
+			t.Errorf("checkTimeScale(%v) error: %v", scale, err)
+		}
+	}
+	for _, scale := range []float64{0, -1, math.NaN(), math.Inf(1)} {
+		if err := checkTimeScale(scale); err == nil {
+			t.Errorf("checkTimeScale(%v) expected error", scale)
+		}
+	}
+}
+
+func TestTimeScale(t *testing.T) {
+	cfg := &Config{TimeScale: 100}
+	if d := cfg.realDuration(10 * time.Minute); d != 6*time.Second {
+		t.Errorf("realDuration got %v want 6s", d)
+	}
+	if d := cfg.simDuration(6 * time.Second); d != 10*time.Minute {
+		t.Errorf("simDuration got %v want 10m0s", d)
+	}
+	if r := cfg.realRate(0.5); r != 50 {
+		t.Errorf("realRate got %v want 50", r)
+	}
+}
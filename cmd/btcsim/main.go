@@ -0,0 +1,16 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	_ "net/http/pprof"
+	"os"
+
+	"github.com/btcsuite/btcsim"
+)
+
+func main() {
+	os.Exit(btcsim.Main(os.Args[1:]))
+}
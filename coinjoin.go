@@ -0,0 +1,201 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcutil"
+)
+
+// checkCoinjoin returns an error if the number of coinjoin participants is
+// out of range, zero disables coinjoins
+func checkCoinjoin(n int) error {
+	if n < 0 || n == 1 {
+		return fmt.Errorf("number of coinjoin participants must be 0 or at least 2")
+	}
+	return nil
+}
+
+// coinjoin runs as a goroutine and, on average every interval, acts as a
+// coordinator: it picks n running actors, collects an utxo from each of
+// them and assembles a joint transaction paying each participant the same
+// amount, which they all sign in turn, until the simulation exits
+func (com *Communication) coinjoin(n int, interval time.Duration) {
+	defer com.wg.Done()
+
+	r := com.cfg.newRand(coinjoinStream)
+	for {
+		select {
+		case <-time.After(time.Duration(r.ExpFloat64() * float64(interval))):
+		case <-com.exit:
+			return
+		}
+
+		var running []*Actor
+		for _, a := range com.Actors() {
+			if a.running() && a.floodTarget == 0 && !a.Paused() {
+				running = append(running, a)
+			}
+		}
+		if len(running) < n {
+			log.Debugf("Not enough running actors for a coinjoin of %d", n)
+			continue
+		}
+		perm := r.Perm(len(running))
+		participants := make([]*Actor, n)
+		for i := range participants {
+			participants[i] = running[perm[i]]
+		}
+		if err := com.joinPayment(r, participants); err != nil {
+			log.Errorf("Cannot make coinjoin of %d participants: %v", n, err)
+		}
+	}
+}
+
+// joinPayment collects an utxo from each of the given actors, skipping
+// those who have none, and sends the coinjoin transaction spending them
+func (com *Communication) joinPayment(r *rand.Rand, candidates []*Actor) (err error) {
+	var participants []*Actor
+	var utxos []*TxOut
+	for _, a := range candidates {
+		select {
+		case utxo, ok := <-a.utxoQueue.dequeue:
+			if ok {
+				participants = append(participants, a)
+				utxos = append(utxos, utxo)
+			}
+		default:
+			log.Debugf("%s: No utxo to join", a)
+		}
+	}
+	// give the utxos back unless the transaction is sent. The node may
+	// have taken it even if sending failed, so once it was broadcast only
+	// the utxos it did not spend are given back.
+	broadcast := false
+	defer func() {
+		if err == nil {
+			return
+		}
+		for i, a := range participants {
+			if broadcast && !a.unspent(utxos[i]) {
+				continue
+			}
+			a.requeue(utxos[i : i+1])
+		}
+	}()
+	if len(participants) < 2 {
+		return nil
+	}
+
+	inputs := make([]btcjson.TransactionInput, len(utxos))
+	prevOuts := make([]btcjson.RawTxInput, len(utxos))
+	var in btcutil.Amount
+	for i, utxo := range utxos {
+		txOut, err := participants[i].client.GetTxOut(&utxo.OutPoint.Hash, utxo.OutPoint.Index, true)
+		if err != nil {
+			return err
+		}
+		if txOut == nil {
+			// the other utxos are given back, but not this one
+			participants = append(participants[:i:i], participants[i+1:]...)
+			utxos = append(utxos[:i:i], utxos[i+1:]...)
+			return fmt.Errorf("output %v is spent", utxo.OutPoint)
+		}
+		inputs[i] = btcjson.TransactionInput{
+			Txid: utxo.OutPoint.Hash.String(),
+			Vout: utxo.OutPoint.Index,
+		}
+		prevOuts[i] = btcjson.RawTxInput{
+			Txid:         utxo.OutPoint.Hash.String(),
+			Vout:         utxo.OutPoint.Index,
+			ScriptPubKey: txOut.ScriptPubKey.Hex,
+		}
+		in += utxo.Amount
+	}
+
+	// participants share the fee equally
+	n := len(participants)
+	fee := participants[0].feeForSize(estimateTxSize(n, 2*n))
+	amounts, err := joinOutputs(r, participants, utxos, fee)
+	if err != nil {
+		return err
+	}
+	msgTx, err := participants[0].client.CreateRawTransaction(inputs, amounts)
+	if err != nil {
+		return err
+	}
+
+	// each participant only signs its own input, so the transaction is
+	// complete once the last one signed
+	var complete bool
+	for _, a := range participants {
+		if msgTx, complete, err = a.signRawTransaction(msgTx, prevOuts); err != nil {
+			return err
+		}
+	}
+	if !complete {
+		return ErrIncompleteSignature
+	}
+	broadcast = true
+	if err := sendUntracked(participants[n-1], msgTx, com.untracked); err != nil {
+		return err
+	}
+
+	rec := participants[0].newTxRecord(msgTx, in)
+	rec.Participants = n
+	select {
+	case com.txStats.sent <- rec:
+	case <-com.exit:
+	}
+	log.Debugf("Coinjoin %s of %d participants", msgTx.TxSha(), n)
+	return nil
+}
+
+// unspent returns whether the given utxo of the actor is neither spent in
+// the chain nor in the mempool, errors counting as spent
+func (a *Actor) unspent(utxo *TxOut) bool {
+	txOut, err := a.client.GetTxOut(&utxo.OutPoint.Hash, utxo.OutPoint.Index, true)
+	return err == nil && txOut != nil
+}
+
+// joinOutputs returns the outputs of a coinjoin spending the given utxos
+// of the participants and paying the given fee. Each participant pays an
+// equal share of the fee and gets the same amount, the largest all of
+// them can afford, to one of its addresses, and its change to another one.
+// Change too small to be worth an output goes to the fee.
+func joinOutputs(r *rand.Rand, participants []*Actor, utxos []*TxOut, fee btcutil.Amount) (map[btcutil.Address]btcutil.Amount, error) {
+	n := btcutil.Amount(len(participants))
+	share := (fee + n - 1) / n
+	smallest := utxos[0].Amount
+	for _, utxo := range utxos[1:] {
+		if utxo.Amount < smallest {
+			smallest = utxo.Amount
+		}
+	}
+	denomination := smallest - share
+	if denomination < minFee {
+		return nil, ErrInsufficientFunds
+	}
+
+	amounts := make(map[btcutil.Address]btcutil.Amount, 2*len(participants))
+	for i, a := range participants {
+		j := r.Intn(len(a.ownedAddresses))
+		amounts[a.ownedAddresses[j]] += denomination
+		change := utxos[i].Amount - denomination - share
+		if change < minFee {
+			continue
+		}
+		// keep the change apart from the joint output when possible
+		if len(a.ownedAddresses) > 1 {
+			j = (j + 1 + r.Intn(len(a.ownedAddresses)-1)) % len(a.ownedAddresses)
+		}
+		amounts[a.ownedAddresses[j]] += change
+	}
+	return amounts, nil
+}
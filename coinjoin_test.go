@@ -0,0 +1,102 @@
+package btcsim
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+func TestCheckCoinjoin(t *testing.T) {
+	for _, n := range []int{0, 2, 5} {
+		if err := checkCoinjoin(n); err != nil {
+			t.Errorf("checkCoinjoin(%d) error: %v", n, err)
+		}
+	}
+	for _, n := range []int{-1, 1} {
+		if err := checkCoinjoin(n); err == nil {
+			t.Errorf("checkCoinjoin(%d) expected error", n)
+		}
+	}
+}
+
+func TestJoinOutputs(t *testing.T) {
+	participants := []*Actor{
+		{ownedAddresses: []btcutil.Address{fakeAddress("a1"), fakeAddress("a2")}},
+		{ownedAddresses: []btcutil.Address{fakeAddress("b1"), fakeAddress("b2")}},
+		{ownedAddresses: []btcutil.Address{fakeAddress("c1")}},
+	}
+	utxos := []*TxOut{{Amount: 1e6}, {Amount: 3e6}, {Amount: 1e6 + minFee/2}}
+	fee := btcutil.Amount(3000)
+	amounts, err := joinOutputs(DefaultConfig().newRand(coinjoinStream), participants, utxos, fee)
+	if err != nil {
+		t.Fatalf("joinOutputs error: %v", err)
+	}
+
+	var total, in btcutil.Amount
+	for _, v := range amounts {
+		total += v
+	}
+	for _, utxo := range utxos {
+		in += utxo.Amount
+	}
+	// the third participant's change is too small and goes to the fee
+	if want := in - fee - minFee/2; total != want {
+		t.Errorf("joinOutputs pays %v want %v", total, want)
+	}
+	// three joint outputs and the change of the second participant, the
+	// first one has none left
+	if len(amounts) != 4 {
+		t.Errorf("joinOutputs got %d outputs want 4: %v", len(amounts), amounts)
+	}
+	denomination := btcutil.Amount(1e6 - 1000)
+	if amounts[fakeAddress("c1")] != denomination {
+		t.Errorf("joinOutputs got %v want a joint output of %v", amounts, denomination)
+	}
+
+	if _, err := joinOutputs(DefaultConfig().newRand(coinjoinStream), participants, utxos, 3e6); err != ErrInsufficientFunds {
+		t.Errorf("joinOutputs got error %v want %v", err, ErrInsufficientFunds)
+	}
+}
+
+// failingSendWallet fails to send transactions, after the chain accepted
+// them when accept is set
+type failingSendWallet struct {
+	*MockWallet
+	accept bool
+}
+
+func (w *failingSendWallet) SendRawTransaction(tx *wire.MsgTx, allowHighFees bool) (*wire.ShaHash, error) {
+	if w.accept {
+		w.MockWallet.SendRawTransaction(tx, allowHighFees)
+	}
+	return nil, errors.New("connection lost")
+}
+
+func TestJoinPaymentRequeue(t *testing.T) {
+	for _, accept := range []bool{false, true} {
+		chain := NewMockChain(DefaultConfig())
+		a, b := chain.NewActor("a"), chain.NewActor("b")
+		mineMock(t, a, a, b)
+		mineMock(t, b, a, b)
+		b.client.calls = &failingSendWallet{b.client.calls.(*MockWallet), accept}
+
+		com := NewCommunication(DefaultConfig())
+		if err := com.joinPayment(DefaultConfig().newRand(coinjoinStream), []*Actor{a, b}); err == nil {
+			t.Errorf("coinjoin sent with accept %v", accept)
+		}
+		// the utxos are given back only if the transaction was not
+		// broadcast
+		want := 1
+		if accept {
+			want = 0
+		}
+		for _, p := range []*Actor{a, b} {
+			if got := len(queued(p)); got != want {
+				t.Errorf("%v got %d utxos back with accept %v, want %d", p, got, accept, want)
+			}
+		}
+		StopMockActors(a, b)
+	}
+}
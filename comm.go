@@ -2,20 +2,19 @@
 // Use of this source code is governed by an ISC
 // license that can be found in the LICENSE file.
 
-package main
+package btcsim
 
 import (
 	"errors"
-	"fmt"
-	"log"
 	"math"
 	"math/rand"
+	"net"
 	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/btcsuite/btcd/blockchain"
-	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/btcsuite/btcd/txscript"
 	"github.com/btcsuite/btcd/wire"
 	rpc "github.com/btcsuite/btcrpcclient"
@@ -27,6 +26,7 @@ import (
 type Block struct {
 	hash   *wire.ShaHash
 	height int32
+	time   time.Time
 }
 
 // blockQueue is a queue of blocks received from OnBlockConnected
@@ -37,96 +37,588 @@ type blockQueue struct {
 	processed chan *Block
 }
 
+// throttle limits the rate at which transactions are requested from
+// actors, the rate can be changed while transactions are being requested
+// and transactions can be granted on top of it
+type throttle struct {
+	mtx     sync.Mutex
+	rate    float64
+	ticker  *time.Ticker
+	changed chan struct{}
+	granted int
+}
+
+// newThrottle returns a throttle allowing rate transactions per second,
+// a rate of zero or less disables it
+func newThrottle(rate float64) *throttle {
+	t := &throttle{changed: make(chan struct{})}
+	t.setRate(rate)
+	return t
+}
+
+// setRate changes the number of transactions allowed per second, a rate
+// of zero or less disables the throttle. The ticker is only restarted if
+// the rate changes, so that setting the same rate again keeps its phase.
+func (t *throttle) setRate(rate float64) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if rate <= 0 {
+		rate = 0
+	}
+	if rate == t.rate {
+		return
+	}
+	t.rate = rate
+	if t.ticker != nil {
+		t.ticker.Stop()
+		t.ticker = nil
+	}
+	if rate > 0 {
+		t.ticker = time.NewTicker(time.Duration(float64(time.Second) / rate))
+	}
+	// wake up waiters so they use the new ticker
+	close(t.changed)
+	t.changed = make(chan struct{})
+}
+
+// grant lets n transactions through right away, regardless of the rate
+func (t *throttle) grant(n int) {
+	t.mtx.Lock()
+	t.granted += n
+	t.mtx.Unlock()
+}
+
+// stop stops the throttle ticker
+func (t *throttle) stop() {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if t.ticker != nil {
+		t.ticker.Stop()
+	}
+}
+
+// wait blocks until the next transaction is allowed, returning false if
+// exit is closed in the meantime. It never blocks when disabled.
+func (t *throttle) wait(exit <-chan struct{}) bool {
+	for {
+		t.mtx.Lock()
+		if t.granted > 0 {
+			t.granted--
+			t.mtx.Unlock()
+			return true
+		}
+		var tick <-chan time.Time
+		if t.ticker != nil {
+			tick = t.ticker.C
+		}
+		changed := t.changed
+		t.mtx.Unlock()
+
+		if tick == nil {
+			return true
+		}
+		select {
+		case <-tick:
+			return true
+		case <-changed:
+		case <-exit:
+			return false
+		}
+	}
+}
+
 // Communication is consisted of the necessary primitives used
 // for communication between the main goroutine and actors.
 type Communication struct {
+	cfg           *Config
 	wg            sync.WaitGroup
-	downstream    chan btcutil.Address
+	exitOnce      sync.Once
+	downstream    chan struct{}
 	timeReceived  chan time.Time
 	blockTxCount  chan int
 	exit          chan struct{}
-	errChan       chan struct{}
+	errChan       chan *Actor
 	height        chan int32
 	split         chan int
 	txpool        chan struct{}
 	coinbaseQueue chan *btcutil.Tx
 	blockQueue    *blockQueue
+	txStats       *TxStats
+	rand          *rand.Rand
+	throttle      *throttle
+
+	// load varies the rate of the throttle, season scales it with daily
+	// and weekly cycles and burst releases bursts of transactions after
+	// every block, if set
+	load   *loadProfile
+	season *seasonality
+	burst  *blockBurst
+
+	// actors are the running actors of the simulation, they can be
+	// added and removed while it runs so they are protected by actorsMtx
+	// along with the nodes they connect to, the index of the next actor
+	// started and the miner once it runs
+	actorsMtx sync.RWMutex
+	actors    []*Actor
+	nodes     []*Node
+	nextActor int
+	miner     *Miner
+
+	// growth is the schedule the number of actors follows once they
+	// started, nil to keep it, and scaleMtx serializes the changes of the
+	// number of actors to a target
+	growth   *actorGrowth
+	scaleMtx sync.Mutex
+
+	// wallets are the btcwallet versions run by the actors when versions
+	// are compared
+	wallets []version
+
+	// owners indexes the actors by the addresses they own
+	ownersMtx sync.RWMutex
+	owners    map[string]*Actor
+
+	// accepted is the number of requested transactions accepted by the
+	// miner, it is updated atomically
+	accepted uint64
+
+	// scenario is the optional scenario of the simulation, it is
+	// sent the height of every connected block over scenarioHeights
+	scenario        *Scenario
+	scenarioHeights chan int32
+
+	// events delivers the events of the simulation to the components
+	// subscribed to them
+	events *eventBus
+
+	// untracked are the transactions the miner must not report to
+	// txpool as they were not requested by Communicate
+	untracked *txSet
+
+	// bootstrapped is sent the height of every block processed while
+	// bootstrapping
+	bootstrapped chan int32
+
+	// matchmaker picks the actors paid by the requested transactions
+	matchmaker *matchmaker
+
+	// fundAmount is the amount sent to every actor without utxos
+	// before the first transactions are generated, zero disables it
+	fundAmount btcutil.Amount
+
+	// attacker competes with the miner when an attack is simulated
+	attacker *attacker
+
+	// mempool follows the mempool of the node from its notifications
+	mempool *mempoolTracker
+
+	// evictions finds the transactions evicted from the mempool of the
+	// node, the mempool is only reconciled with the node when it is nil
+	evictions *evictionTracker
+
+	// fuzz follows the transactions of the edge cases of the relay
+	// policy, it is nil unless they are sent
+	fuzz *fuzzTracker
+
+	// timelocks follows the transactions locked to a future height or
+	// time, it is nil unless they are created
+	timelocks *timelockTracker
+
+	// startup limits the number of actor wallets starting at once and
+	// records the actors which failed to start
+	startup *startController
+
+	// balances, crashes and failed are set while the simulation runs and
+	// must only be read after WaitForShutdown returns
+	balances  map[string]btcutil.Amount
+	crashes   int
+	failed    int
+	dustStats DustStats
+
+	// orphanStats are the numbers of transactions broadcast before their
+	// parents and accepted once they arrived
+	orphanStats OrphanStats
+
+	// invariantChecks and invariantViolations are the number of balance
+	// invariant checks and of those which found a discrepancy, they must
+	// only be read after WaitForShutdown returns
+	invariantChecks     int
+	invariantViolations int
+
+	// removedBalances is the sum of the balances of the actors removed
+	// while the balance invariant is checked, as their coins stay in
+	// wallets which no longer run
+	removedBalances btcutil.Amount
+
+	// crashTest kills wallets at points of their send pipeline when the
+	// crash test is enabled
+	crashTest *crashTest
+
+	// auditIssues are the differences between the wallets and the chain
+	// found at shutdown, they must only be read after WaitForShutdown
+	// returns
+	auditIssues []*auditIssue
+
+	// mempoolSamples are the mempool divergence samples taken by the
+	// mempool monitor, they must only be read after WaitForShutdown
+	// returns
+	mempoolSamples []*mempoolSample
+
+	// resourceSamples are the resource usage samples of the processes
+	// taken by the resource monitor, they must only be read after
+	// WaitForShutdown returns
+	resourceSamples []*resourceSample
+
+	// watchers are the watch-only actors and watchStats their comparison
+	// with the chain at shutdown, which must only be read after
+	// WaitForShutdown returns
+	watchers   []*watchOnly
+	watchStats []*WatchOnlyStats
+
+	// txGraph is the transaction graph of the chain at shutdown and
+	// labels the actors owning its addresses and transactions, they must
+	// only be read after WaitForShutdown returns
+	txGraph *txGraph
+	labels  *labels
+
+	// utxoSet follows the utxo set of the chain with -utxosnapshots
+	utxoSet *utxoSet
+
+	// links are the proxied links between the nodes, which faults can
+	// be injected in
+	links []*link
 }
 
 // NewCommunication creates a new data structure with all the
 // necessary primitives for a fully functional simulation to
-// happen.
-func NewCommunication() *Communication {
-	return &Communication{
-		downstream:    make(chan btcutil.Address, *numActors),
-		timeReceived:  make(chan time.Time, *numActors),
-		blockTxCount:  make(chan int, *numActors),
+// happen in the run configured by cfg.
+func NewCommunication(cfg *Config) *Communication {
+	com := &Communication{
+		cfg:           cfg,
+		downstream:    make(chan struct{}, cfg.NumActors),
+		timeReceived:  make(chan time.Time, cfg.NumActors),
+		blockTxCount:  make(chan int, cfg.NumActors),
 		height:        make(chan int32),
 		split:         make(chan int),
 		txpool:        make(chan struct{}),
 		coinbaseQueue: make(chan *btcutil.Tx, blockchain.CoinbaseMaturity),
 		exit:          make(chan struct{}),
-		errChan:       make(chan struct{}, *numActors),
+		errChan:       make(chan *Actor),
+		txStats:       NewTxStats(cfg),
+		rand:          cfg.newRand(comStream),
+		throttle:      newThrottle(cfg.realRate(cfg.TxRate)),
+
+		scenarioHeights: make(chan int32),
+		bootstrapped:    make(chan int32),
+		untracked:       newTxSet(),
+		events:          newEventBus(),
+		mempool:         newMempoolTracker(),
+		startup:         newStartController(cfg.StartConcurrency, cfg.StartTimeout),
+		balances:        make(map[string]btcutil.Amount),
+		owners:          make(map[string]*Actor),
 		blockQueue: &blockQueue{
 			enqueue:   make(chan *Block),
 			dequeue:   make(chan *Block),
 			processed: make(chan *Block),
 		},
 	}
+	com.matchmaker = newMatchmaker(cfg, matchRandom, com.Actors)
+	if cfg.CrashInterval > 0 {
+		com.crashTest = newCrashTest()
+	}
+	com.txStats.events = com.events
+	com.txStats.mempool = com.mempool
+
+	// every event is logged, payments, actors and stats follow the
+	// blocks mined and failed actors are handed to the actor watcher
+	com.events.subscribe(logEvent, eventKinds...)
+	com.events.subscribe(com.blockMined, eventBlockMined)
+	com.events.subscribe(com.matchmaker.replaced, eventTxSent)
+	com.events.subscribe(func(e *Event) {
+		com.indexActor(e.Actor)
+	}, eventActorStarted)
+	com.events.subscribe(com.mempool.mined, eventBlockMined)
+	com.events.subscribe(com.mempool.accepted, eventTxAccepted)
+	if cfg.UtxoSnapshotBlocks > 0 {
+		com.utxoSet = newUtxoSet(cfg.UtxoSnapshotBlocks)
+		com.events.subscribe(com.utxoSet.mined, eventBlockMined)
+	}
+	com.events.subscribe(func(e *Event) {
+		if e.Node != 0 {
+			return
+		}
+		select {
+		case com.timeReceived <- e.Time:
+		case <-com.exit:
+		}
+	}, eventTxAccepted)
+	com.events.subscribe(func(e *Event) {
+		select {
+		case com.errChan <- e.Actor:
+		case <-com.exit:
+		}
+	}, eventActorFailed)
+	return com
+}
+
+// blockMined confirms the payments mined in a block, lets the actors know
+// and records the block with the transaction statistics
+func (com *Communication) blockMined(e *Event) {
+	com.matchmaker.confirm(e.Height, e.Block.txids)
+	for _, a := range com.Actors() {
+		a.blockMined(e.Block.txids)
+		a.notifyBlock(e.Height)
+	}
+	select {
+	case com.txStats.blocks <- e.Block:
+	case <-com.exit:
+	}
+}
+
+// Exit signals every goroutine of the simulation to stop. It is safe to
+// call Exit more than once.
+func (com *Communication) Exit() {
+	com.exitOnce.Do(func() {
+		close(com.exit)
+	})
+}
+
+// Actors returns the actors currently taking part in the simulation
+func (com *Communication) Actors() []*Actor {
+	com.actorsMtx.RLock()
+	defer com.actorsMtx.RUnlock()
+	return append([]*Actor(nil), com.actors...)
+}
+
+// addActor adds a running actor to the simulation
+func (com *Communication) addActor(a *Actor) {
+	com.actorsMtx.Lock()
+	com.actors = append(com.actors, a)
+	com.actorsMtx.Unlock()
+}
+
+// removeActor removes an actor from the simulation, it returns false if
+// the actor is not part of it. The balance of the actor is kept for the
+// balance invariant, so it must be removed before it is shut down.
+func (com *Communication) removeActor(a *Actor) bool {
+	var balance btcutil.Amount
+	if com.cfg.InvariantBlocks > 0 && a.client != nil {
+		var err error
+		if balance, err = a.client.GetBalanceMinConf("", 0); err != nil {
+			log.Warnf("%s: Cannot get the balance of the removed actor, the balance "+
+				"invariant no longer holds: %v", a, err)
+		}
+	}
+
+	com.actorsMtx.Lock()
+	defer com.actorsMtx.Unlock()
+	for i, actor := range com.actors {
+		if actor == a {
+			com.actors = append(com.actors[:i], com.actors[i+1:]...)
+			com.removedBalances += balance
+			return true
+		}
+	}
+	return false
 }
 
 // Start handles the main part of a simulation by starting
 // all the necessary goroutines.
-func (com *Communication) Start(actors []*Actor, node *Node, txCurve map[int32]*Row) (tpsChan chan float64, tpbChan chan int) {
+func (com *Communication) Start(actors []*Actor, nodes []*Node, txCurve map[int32]*Row) (tpsChan chan float64, tpbChan chan int) {
 	tpsChan = make(chan float64, 1)
 	tpbChan = make(chan int, 1)
 
-	// Start actors
-	for _, a := range actors {
+	com.actorsMtx.Lock()
+	com.actors = append([]*Actor(nil), actors...)
+	com.nodes = nodes
+	com.nextActor = len(actors)
+	com.actorsMtx.Unlock()
+
+	// Start a goroutine to remove failed actors
+	com.wg.Add(1)
+	go com.watchActors()
+
+	// Start actors, at most -startrate per second, those which fail are
+	// removed from the simulation
+	interval := startInterval(com.cfg.StartRate)
+	for i, a := range actors {
+		if i > 0 && interval > 0 {
+			select {
+			case <-time.After(interval):
+			case <-com.exit:
+			}
+		}
 		com.wg.Add(1)
 		go func(a *Actor, com *Communication) {
 			defer com.wg.Done()
-			if err := a.Start(os.Stderr, os.Stdout, com); err != nil {
-				log.Printf("%s: Cannot start actor: %v", a, err)
-				a.Shutdown()
-				node.Shutdown()
+			if err := a.Start(com); err != nil {
+				if err != ErrActorShutdown {
+					log.Errorf("%s: Cannot start actor: %v", a, err)
+					com.actorFailed(a)
+				}
+				return
 			}
+			com.events.publish(&Event{Kind: eventActorStarted, Actor: a})
+			com.wg.Add(1)
+			go com.watchActor(a)
 		}(a, com)
 	}
 
-	// Start a goroutine to check if all actors have failed
+	// Start a goroutine to collect transaction statistics
 	com.wg.Add(1)
-	go com.failedActors()
+	go func() {
+		defer com.wg.Done()
+		com.txStats.collect(com.exit)
+	}()
 
-	miningAddrs := make([]btcutil.Address, *numActors)
-	for i, a := range actors {
+	miningAddrs := make([]btcutil.Address, 0, len(actors))
+	var poolAddrs []btcutil.Address
+	for _, a := range actors {
 		select {
-		case miningAddrs[i] = <-a.miningAddr:
+		case addr := <-a.miningAddr:
+			miningAddrs = append(miningAddrs, addr)
+			if isPool(a.behaviorName) {
+				poolAddrs = append(poolAddrs, addr)
+			}
 		case <-a.quit:
 			// This actor has quit
 			select {
 			case <-com.exit:
 				close(tpsChan)
 				close(tpbChan)
+				com.wg.Add(1)
+				go com.Shutdown(nil, nodes)
 				return
 			default:
 			}
+		case <-com.exit:
+			// The simulation was interrupted while actors were starting
+			close(tpsChan)
+			close(tpbChan)
+			com.wg.Add(1)
+			go com.Shutdown(nil, nodes)
+			return
 		}
 	}
 
+	// Mining pools get every block reward to pay their hashers
+	if len(poolAddrs) > 0 {
+		miningAddrs = poolAddrs
+	}
+
 	// Start mining.
-	miner, err := NewMiner(miningAddrs, com.exit, com.height, com.txpool)
+	miner, err := NewMiner(com.cfg, miningAddrs, com.exit, com.height, com.txpool, com.untracked)
 	if err != nil {
-		close(com.exit)
+		com.Exit()
 		close(tpsChan)
 		close(tpbChan)
 		com.wg.Add(1)
-		go com.Shutdown(miner, actors, node)
+		go com.Shutdown(miner, nodes)
 		return
 	}
 
+	// Double spends are sent directly to the miner
+	for _, a := range actors {
+		a.SetRival(miner.client)
+	}
+	com.actorsMtx.Lock()
+	com.miner = miner
+	com.actorsMtx.Unlock()
+
 	// Add mining node listen interface as a node
-	node.client.AddNode("localhost:18550", rpc.ANAdd)
+	node := nodes[0]
+	node.client.AddNode(miner.Args.(ChainServer).ListenAddr(), rpc.ANAdd)
+
+	// Start the attacker competing with the miner
+	if com.cfg.AttackName != "" {
+		fork, err := newForkMiner(com.cfg, "attacker", 18540, 18541,
+			node.Args.(ChainServer), miningAddrs)
+		if err == nil {
+			com.attacker, err = newAttacker(fork, miner, com.cfg.AttackName, com.cfg.AttackPower)
+			if err != nil {
+				fork.Shutdown()
+			}
+		}
+		if err != nil {
+			log.Errorf("Cannot start attacker: %v", err)
+			com.Exit()
+		} else {
+			miner.attacker = com.attacker
+		}
+	}
+
+	// Start the control API
+	if com.cfg.ControlAddr != "" {
+		l, err := net.Listen("tcp", listenAddr(com.cfg.ControlAddr))
+		if err != nil {
+			log.Errorf("Cannot listen for the control API: %v", err)
+			com.Exit()
+		} else {
+			com.wg.Add(1)
+			go com.serveControl(l, newController(com, miner))
+		}
+	}
+
+	// Start the dashboard
+	if com.cfg.DashboardAddr != "" {
+		l, err := net.Listen("tcp", listenAddr(com.cfg.DashboardAddr))
+		if err != nil {
+			log.Errorf("Cannot listen for the dashboard: %v", err)
+			com.Exit()
+		} else {
+			com.wg.Add(1)
+			go com.serveDashboard(l, newDashboard(com, nodes, newController(com, miner)))
+		}
+	}
+
+	// Start the terminal UI
+	if com.cfg.TUI {
+		com.wg.Add(1)
+		go com.runTUI(os.Stdout, nodes)
+	}
+
+	// Start a goroutine to run the scenario
+	if com.scenario != nil {
+		com.scenario.com = com
+		com.scenario.actors = actors
+		com.scenario.miner = miner
+		if com.scenario.reorgs() {
+			fork, err := newForkMiner(com.cfg, "forkminer", 18552, 18553,
+				node.Args.(ChainServer), miningAddrs)
+			if err != nil {
+				log.Errorf("Cannot start fork miner: %v", err)
+				com.Exit()
+			}
+			com.scenario.fork = fork
+			com.scenario.node = node
+		}
+		com.events.subscribe(func(e *Event) {
+			select {
+			case com.scenarioHeights <- e.Height:
+			case <-com.exit:
+			}
+		}, eventBlockMined)
+		com.events.subscribe(com.scenario.observeReorg, eventReorg, eventBlockConnected)
+		com.wg.Add(1)
+		go func() {
+			defer com.wg.Done()
+			com.scenario.run(com.scenarioHeights, com.exit)
+		}()
+	}
+
+	// Start a goroutine to grow or shrink the number of actors
+	if com.growth != nil {
+		com.wg.Add(1)
+		go com.autoscale(com.growth, miner)
+	}
+
+	// Start the watch-only actors, the addresses of the actors are known
+	// once they are mining
+	if com.cfg.WatchOnlyActors > 0 {
+		com.startWatchOnly(com.cfg.WatchOnlyActors, actors, nodes)
+	}
 
 	// Start a goroutine to estimate tps
 	com.wg.Add(1)
@@ -136,19 +628,144 @@ func (com *Communication) Start(actors []*Actor, node *Node, txCurve map[int32]*
 	com.wg.Add(1)
 	go com.estimateTpb(tpbChan)
 
+	// Start a goroutine to mine the blocks before the start block
+	if com.cfg.Bootstrap {
+		com.wg.Add(1)
+		go com.bootstrap(miner, com.cfg.BootstrapTxs)
+	}
+
 	// Start a goroutine to coordinate transactions
 	com.wg.Add(1)
-	go com.Communicate(txCurve, miner, actors)
+	go com.Communicate(txCurve, miner)
 
 	com.wg.Add(1)
 	go com.queueBlocks()
 
 	com.wg.Add(1)
-	go com.poolUtxos(node.client, actors)
+	go com.poolUtxos(node.client)
+
+	// the height and mempool of the node are then tracked from its
+	// notifications
+	height, err := node.client.GetBlockCount()
+	if err != nil {
+		log.Errorf("Cannot get block count: %v", err)
+	}
+	mempool, err := node.client.GetRawMempool()
+	if err != nil {
+		log.Errorf("Cannot get mempool: %v", err)
+	}
+	com.mempool.seed(int32(height), mempool)
+
+	// Start a goroutine to find the transactions evicted from the mempool,
+	// which removes them from the tracked mempool, or else to reconcile it
+	// with the node
+	if com.evictions != nil {
+		com.wg.Add(1)
+		go com.trackEvictions(com.evictions, node.client)
+	} else {
+		com.wg.Add(1)
+		go com.reconcileMempool(node.client)
+	}
+
+	// Start a goroutine to follow the fee estimates of the node
+	if o := com.txStats.oracle; o != nil {
+		com.events.subscribe(o.mined, eventBlockMined)
+		com.wg.Add(1)
+		go com.estimateFees(o, node.client)
+	}
+
+	// Start a goroutine to vary the transaction rate
+	if com.load != nil || com.season != nil || com.burst != nil {
+		com.startRateControl()
+	}
+
+	// Start a goroutine to report the status of the simulation
+	if com.cfg.StatusInterval > 0 {
+		com.wg.Add(1)
+		go com.reportStatus(com.cfg.StatusInterval)
+	}
+
+	// Start a goroutine to check the balance invariant
+	if com.cfg.InvariantBlocks > 0 {
+		com.startBalanceChecks(node, com.cfg.InvariantBlocks)
+	}
+
+	// Start a goroutine to compare the mempools of the nodes
+	if com.cfg.MempoolInterval > 0 && len(nodes) > 1 {
+		com.wg.Add(1)
+		go com.monitorMempools(nodes, com.cfg.MempoolInterval)
+	}
+
+	// Start a goroutine to sample the resource usage of the processes
+	if com.cfg.ResourceInterval > 0 {
+		com.wg.Add(1)
+		go com.monitorResources(com.cfg.ResourceInterval)
+	}
+
+	// Start a goroutine to kill wallets within their send pipeline
+	if com.crashTest != nil {
+		com.wg.Add(1)
+		go com.crash(com.cfg.realDuration(com.cfg.CrashInterval))
+	}
+
+	// Start a goroutine to kill actors at random
+	if com.cfg.ChaosInterval > 0 {
+		com.wg.Add(1)
+		go com.chaos(com.cfg.realDuration(com.cfg.ChaosInterval))
+	}
+
+	// Start a goroutine to make multisig payments between actors
+	if com.cfg.MultisigScheme != "" {
+		m, n, _ := parseMultisig(com.cfg.MultisigScheme)
+		com.wg.Add(1)
+		go com.multisig(m, n, com.cfg.realDuration(com.cfg.MultisigInterval))
+	}
+
+	// Start a goroutine to coordinate coinjoins between actors
+	if com.cfg.CoinjoinParticipants > 0 {
+		com.wg.Add(1)
+		go com.coinjoin(com.cfg.CoinjoinParticipants, com.cfg.realDuration(com.cfg.CoinjoinInterval))
+	}
+
+	// Start a goroutine to create and consolidate dust outputs
+	if com.cfg.DustOutputs > 0 {
+		com.wg.Add(1)
+		go com.dust(com.cfg.DustOutputs, btcutil.Amount(com.cfg.DustAmount), com.cfg.realDuration(com.cfg.DustInterval))
+	}
+
+	// Start a goroutine to broadcast transactions before their parents
+	if com.cfg.OrphanInterval > 0 {
+		com.wg.Add(1)
+		go com.orphans(com.cfg.realDuration(com.cfg.OrphanInterval), com.cfg.realDuration(com.cfg.OrphanDelay))
+	}
+
+	// Start a goroutine to send transactions of edge cases of the relay
+	// policy
+	if com.fuzz != nil {
+		com.wg.Add(1)
+		go com.fuzzPolicy(com.fuzz, com.cfg.realDuration(com.cfg.FuzzInterval))
+	}
+
+	// Start a goroutine to create transactions locked to a future height
+	// or time and send them until they are accepted
+	if com.timelocks != nil {
+		com.wg.Add(1)
+		go com.sendTimelocks(com.timelocks, com.cfg.realDuration(com.cfg.TimelockInterval),
+			com.cfg.TimelockBlocks, com.cfg.realDuration(com.cfg.TimelockDelay))
+	}
+
+	// Start a goroutine to stop the simulation after the given duration
+	if com.cfg.Duration > 0 {
+		com.wg.Add(1)
+		go com.timeout(com.cfg.realDuration(com.cfg.Duration))
+	}
+
+	// Stop the simulation after the given number of blocks or transactions
+	com.stopAfter(com.cfg.StopAfterBlocks, com.cfg.StopAfterTxs)
 
 	// Start a goroutine for shuting down the simulation when appropriate
 	com.wg.Add(1)
-	go com.Shutdown(miner, actors, node)
+	go com.Shutdown(miner, nodes)
 
 	return
 }
@@ -202,7 +819,7 @@ out:
 
 // poolUtxos receives a new block notification from the node server
 // and pools the newly mined utxos to the corresponding actor's a.utxo
-func (com *Communication) poolUtxos(client *rpc.Client, actors []*Actor) {
+func (com *Communication) poolUtxos(client *rpcClient) {
 	defer com.wg.Done()
 	// Update utxo pool on each block connected
 	for {
@@ -213,9 +830,30 @@ func (com *Communication) poolUtxos(client *rpc.Client, actors []*Actor) {
 			}
 			block, err := client.GetBlock(b.hash)
 			if err != nil {
-				log.Printf("Cannot get block: %v", err)
+				log.Errorf("Cannot get block: %v", err)
 				return
 			}
+			// record the transactions mined in this block
+			txs := &blockTxs{
+				height: b.height,
+				time:   b.time,
+				block:  block,
+			}
+			for _, tx := range block.Transactions() {
+				txs.txids = append(txs.txids, tx.Sha().String())
+			}
+			com.events.publish(&Event{
+				Kind:   eventBlockMined,
+				Time:   b.time,
+				Height: b.height,
+				Block:  txs,
+			})
+			select {
+			case <-com.exit:
+				return
+			default:
+			}
+			actors := com.Actors()
 			// add new outputs to unspent pool
 			for i, tx := range block.Transactions() {
 			next:
@@ -239,45 +877,65 @@ func (com *Communication) poolUtxos(client *rpc.Client, actors []*Actor) {
 							vout = tx.MsgTx().TxOut[n]
 						}
 					}
-					// fetch actor who owns this output
+					// fetch actor who owns this output, p2sh outputs
+					// may pay a multisig address owned by no single
+					// actor
 					var actor *Actor
-					if len(actors) == 1 {
+					if len(actors) == 1 && !isScriptHash(vout.PkScript) {
 						actor = actors[0]
 					} else {
 						actor, err = com.getActor(actors, vout)
+						if err == errNoAddress {
+							continue next
+						}
+						if err == errNoOwner && isScriptHash(vout.PkScript) {
+							continue next
+						}
 						if err != nil {
-							log.Printf("Cannot get actor: %v", err)
+							log.Errorf("Cannot get actor: %v", err)
 							continue next
 						}
 					}
 					txout := com.getUtxo(tx, vout, uint32(n))
+					txout.Coinbase = i == 0
 					// to be usable, the utxo amount should be
 					// split-able after deducting the fee
-					if txout.Amount > btcutil.Amount((*maxSplit))*(minFee) {
+					if txout.Amount > btcutil.Amount((com.cfg.MaxSplit))*(minFee) {
 						// if it's usable, add utxo to actor's pool
 						select {
 						case actor.utxoQueue.enqueue <- txout:
+							actor.notifyPayment(txout)
+						case <-actor.quit:
+							// the actor has been removed
 						case <-com.exit:
 						}
 					}
 				}
 			}
+			// allow bootstrap to sync with the processed block
+			if com.cfg.Bootstrap && b.height < int32(com.cfg.StartBlock)-1 {
+				select {
+				case com.bootstrapped <- b.height:
+				case <-com.exit:
+					return
+				}
+			}
 			// allow Communicate to sync with the processed block
-			if b.height == int32(*startBlock)-1 {
+			if b.height == int32(com.cfg.StartBlock)-1 {
 				select {
 				case com.blockQueue.processed <- b:
 				case <-com.exit:
 					return
 				}
 			}
-			if b.height >= int32(*startBlock) {
+			if b.height >= int32(com.cfg.StartBlock) {
 				var txCount, utxoCount int
 				for _, a := range actors {
-					utxoCount += len(a.utxoQueue.utxos)
+					utxoCount += a.utxoQueue.len()
 				}
 				txCount = len(block.Transactions())
-				log.Printf("Block %s (height %d) attached with %d transactions", b.hash, b.height, txCount)
-				log.Printf("%d transaction outputs available to spend", utxoCount)
+				log.Infof("Block %s (height %d) attached with %d transactions", b.hash, b.height, txCount)
+				log.Debugf("%d transaction outputs available to spend", utxoCount)
 				select {
 				case com.blockQueue.processed <- b:
 				case <-com.exit:
@@ -295,31 +953,46 @@ func (com *Communication) poolUtxos(client *rpc.Client, actors []*Actor) {
 	}
 }
 
+var (
+	// errNoAddress is returned by getActor for an output paying no
+	// address, which no actor can own
+	errNoAddress = errors.New("output pays no address")
+
+	// errNoOwner is returned by getActor for an output paying an address
+	// of no actor
+	errNoOwner = errors.New("cannot find any actor who owns this tx output")
+)
+
 // getActor returns the actor to which this vout belongs to
 func (com *Communication) getActor(actors []*Actor,
 	vout *wire.TxOut) (*Actor, error) {
 	// get addrs which own this utxo
 	_, addrs, _, err := txscript.ExtractPkScriptAddrs(vout.PkScript,
-		&chaincfg.SimNetParams)
+		com.cfg.netParams())
 	if err != nil {
 		return nil, err
 	}
+	// OP_RETURN and non-standard outputs pay no address
+	if len(addrs) == 0 {
+		return nil, errNoAddress
+	}
 
 	// we're expecting only 1 addr since we created a standard p2pkh tx
 	addr := addrs[0].String()
-	// find which actor this addr belongs to
-	// TODO: could probably be optimized by creating
-	// a global addr -> actor index rather than looking
-	// up each actor addrs
+	// find which actor this addr belongs to, actors are indexed once
+	// started but their addresses may be paid before
+	if actor, ok := com.owner(addr); ok {
+		return actor, nil
+	}
 	for _, actor := range actors {
 		for _, actorAddr := range actor.ownedAddresses {
 			if addr == actorAddr.String() {
+				com.indexActor(actor)
 				return actor, nil
 			}
 		}
 	}
-	err = errors.New("cannot find any actor who owns this tx output")
-	return nil, err
+	return nil, errNoOwner
 }
 
 // getUtxo returns a TxOut from Tx and Vout
@@ -333,25 +1006,15 @@ func (com *Communication) getUtxo(tx *btcutil.Tx,
 	return &unspent
 }
 
-// failedActors checks for actors that aborted the simulation
-func (com *Communication) failedActors() {
+// timeout stops the simulation once the given duration has elapsed
+func (com *Communication) timeout(d time.Duration) {
 	defer com.wg.Done()
 
-	var failedActors int
-
-	for {
-		select {
-		case <-com.errChan:
-			failedActors++
-
-			// All actors have failed
-			if failedActors == *numActors {
-				close(com.exit)
-				return
-			}
-		case <-com.exit:
-			return
-		}
+	select {
+	case <-time.After(d):
+		log.Infof("Simulation duration of %v elapsed", d)
+		com.Exit()
+	case <-com.exit:
 	}
 }
 
@@ -413,22 +1076,30 @@ func (com *Communication) estimateTpb(tpbChan chan<- int) {
 
 // Communicate generates tx and controls the mining according
 // to the input block height vs tx count curve
-func (com *Communication) Communicate(txCurve map[int32]*Row, miner *Miner, actors []*Actor) {
+func (com *Communication) Communicate(txCurve map[int32]*Row, miner *Miner) {
 	defer com.wg.Done()
+	defer com.throttle.stop()
+
+	// lastBlock is the time when the last block was connected
+	var lastBlock time.Time
+
+	// funded is set once actors have been funded
+	funded := com.fundAmount == 0
 
 	for {
 		select {
 		case h := <-com.height:
+			lastBlock = time.Now()
 
 			// stop simulation if we're at the last block
-			if h > int32(*stopBlock) {
-				close(com.exit)
+			if h > int32(com.cfg.StopBlock) {
+				com.Exit()
 				return
 			}
 
 			// disable mining until the required no. of tx are in mempool
 			if err := miner.StopMining(); err != nil {
-				close(com.exit)
+				com.Exit()
 				return
 			}
 
@@ -439,11 +1110,26 @@ func (com *Communication) Communicate(txCurve map[int32]*Row, miner *Miner, acto
 				return
 			}
 
+			// receivers of the requested transactions are cancelled
+			// if actors stop sending them
 			var wg sync.WaitGroup
+			cancel := make(chan struct{})
+			actors := com.Actors()
+
+			// fund actors without utxos before the first transactions,
+			// the funding transaction is mined with them
+			if !funded {
+				funded = true
+				if com.fundActors(actors, com.fundAmount) {
+					wg.Add(1)
+					go com.txPoolRecv(&wg, cancel)
+				}
+			}
+
 			// count the number of utxos available in total
 			var utxoCount int
 			for _, a := range actors {
-				utxoCount += len(a.utxoQueue.utxos)
+				utxoCount += a.utxoQueue.len()
 			}
 
 			// the required transactions are divided into two groups because we need some of them to
@@ -499,7 +1185,7 @@ func (com *Communication) Communicate(txCurve map[int32]*Row, miner *Miner, acto
 			// no of utxos
 			reqTxCount := row.txCount
 			if reqTxCount > utxoCount {
-				log.Printf("Warning: capping no of transactions at %v based on no of available utxos", utxoCount)
+				log.Warnf("Capping no of transactions at %v based on no of available utxos", utxoCount)
 				// cap the total no of tx at the no of available utxos
 				reqTxCount = utxoCount
 			}
@@ -512,9 +1198,9 @@ func (com *Communication) Communicate(txCurve map[int32]*Row, miner *Miner, acto
 				// totalUtxos = 18000/2 = 9000
 				// totalTx = 120000 - 9000 = 3000
 				multiplier = int(math.Ceil(float64(reqUtxoCount) / float64(reqTxCount)))
-				if multiplier > *maxSplit {
+				if multiplier > com.cfg.MaxSplit {
 					// cap maximum splits at maxSplit
-					multiplier = *maxSplit
+					multiplier = com.cfg.MaxSplit
 				}
 				totalUtxos = reqUtxoCount / multiplier
 			}
@@ -525,19 +1211,30 @@ func (com *Communication) Communicate(txCurve map[int32]*Row, miner *Miner, acto
 			}
 
 			if reqTxCount > 0 {
-				log.Printf("Generating %v transactions ...", reqTxCount)
+				log.Infof("Generating %v transactions ...", reqTxCount)
 			}
+			// stalled is set when no actor takes a request, e.g. because
+			// the actors owning utxos died, the remaining ones are skipped
+			var stalled bool
 			if totalTx > 0 {
-				for i := 0; i < totalTx; i++ {
-					fmt.Printf("\r%d/%d", i+1, reqTxCount)
-					a := actors[rand.Int()%len(actors)]
-					addr := a.ownedAddresses[rand.Int()%len(a.ownedAddresses)]
+				for i := 0; i < totalTx && !stalled; i++ {
+					com.cfg.printProgress(i+1, reqTxCount)
+					if !com.wait() {
+						return
+					}
 					select {
-					case com.downstream <- addr:
-						// For every address sent downstream (one transaction about to happen),
+					case com.downstream <- struct{}{}:
+						// For every request sent downstream (one transaction about to happen),
 						// spawn a goroutine to listen for an accepted transaction in the mempool
 						wg.Add(1)
-						go com.txPoolRecv(&wg)
+						go com.txPoolRecv(&wg, cancel)
+					case <-time.After(txStallTimeout):
+						if com.anyPaused() {
+							i--
+							continue
+						}
+						log.Warnf("No actor took a transaction request for %v, skipping the remaining ones", txStallTimeout)
+						stalled = true
 					case <-com.exit:
 						return
 					}
@@ -545,26 +1242,38 @@ func (com *Communication) Communicate(txCurve map[int32]*Row, miner *Miner, acto
 			}
 
 			if totalUtxos > 0 {
-				for i := 0; i < totalUtxos; i++ {
-					fmt.Printf("\r%d/%d", i+totalTx+1, reqTxCount)
+				for i := 0; i < totalUtxos && !stalled; i++ {
+					com.cfg.printProgress(i+totalTx+1, reqTxCount)
+					if !com.wait() {
+						return
+					}
 					select {
 					case com.split <- multiplier:
 						// For every address sent downstream (one transaction about to happen),
 						// spawn a goroutine to listen for an accepted transaction in the mempool
 						wg.Add(1)
-						go com.txPoolRecv(&wg)
+						go com.txPoolRecv(&wg, cancel)
+					case <-time.After(txStallTimeout):
+						if com.anyPaused() {
+							i--
+							continue
+						}
+						log.Warnf("No actor took a transaction request for %v, skipping the remaining ones", txStallTimeout)
+						stalled = true
 					case <-com.exit:
 						return
 					}
 				}
 			}
 
-			fmt.Printf("\n")
-			log.Printf("Waiting for miner...")
-			wg.Wait()
-			// mine the above tx in the next block
-			if err := miner.StartMining(); err != nil {
-				close(com.exit)
+			com.cfg.endProgress()
+			log.Debugf("Waiting for miner...")
+			if !com.waitTxPool(&wg, cancel) {
+				return
+			}
+			// mine the above tx in the next block as per the schedule
+			if err := miner.MineNext(lastBlock, com.exit); err != nil {
+				com.Exit()
 				return
 			}
 		case <-com.exit:
@@ -573,21 +1282,73 @@ func (com *Communication) Communicate(txCurve map[int32]*Row, miner *Miner, acto
 	}
 }
 
+// wait blocks until the throttle allows the next transaction, returning
+// false if the simulation exits in the meantime
+func (com *Communication) wait() bool {
+	return com.throttle.wait(com.exit)
+}
+
 // Shutdown shuts down the simulation by killing the mining and the
-// initial node processes and shuts down all actors.
-func (com *Communication) Shutdown(miner *Miner, actors []*Actor, node *Node) {
+// node processes and shuts down all actors.
+func (com *Communication) Shutdown(miner *Miner, nodes []*Node) {
 	defer com.wg.Done()
 
 	<-com.exit
-	if miner != nil {
+	// the miner data is needed to save the state
+	save := com.cfg.SaveStatePath != "" && miner != nil
+	if miner != nil && !save {
+		miner.Shutdown()
+	}
+	if com.scenario != nil && com.scenario.fork != nil {
+		com.scenario.fork.Shutdown()
+	}
+	if com.attacker != nil {
+		com.attacker.Shutdown()
+	}
+	actors := com.Actors()
+	// the assertions of the end of the scenario need the actors
+	if com.scenario != nil {
+		com.scenario.finish()
+	}
+	// wallets killed by the crash test are always audited
+	if com.cfg.AuditPath != "" || com.crashTest != nil {
+		com.auditWallets(actors)
+	}
+	// the chain is compared with the notifications before the nodes
+	// are shut down
+	com.checkWatchOnly()
+	// and so is the transaction graph
+	if (com.cfg.TxGraphPath != "" || com.cfg.LabelsPath != "") && len(nodes) > 0 {
+		g, err := com.buildTxGraph(nodes[0])
+		if err != nil {
+			log.Errorf("Cannot build transaction graph: %v", err)
+		} else if com.cfg.LabelsPath != "" {
+			com.labels = com.buildLabels(g)
+		}
+		com.txGraph = g
+	}
+	// record the final balances before actors are shut down
+	for _, a := range actors {
+		if a.client == nil || a.wallet != nil {
+			continue
+		}
+		balance, err := a.client.GetBalance("")
+		if err != nil {
+			log.Errorf("%s: Cannot get balance: %v", a, err)
+			continue
+		}
+		com.balances[a.String()] = balance
+	}
+	if save {
+		if err := com.saveState(com.cfg.SaveStatePath, miner, nodes, actors); err != nil {
+			log.Errorf("Cannot save state: %v", err)
+		}
 		miner.Shutdown()
 	}
 	for _, a := range actors {
 		a.Shutdown()
 	}
-	if node != nil {
-		node.Shutdown()
-	}
+	shutdownNodes(nodes)
 }
 
 // WaitForShutdown waits until every goroutine inside com.Start
@@ -598,11 +1359,13 @@ func (com *Communication) WaitForShutdown() {
 
 // txPoolRecv listens for transactions accepted in the miner mempool
 // or errors happened during the creation or send of a transaction.
-func (com *Communication) txPoolRecv(wg *sync.WaitGroup) {
+func (com *Communication) txPoolRecv(wg *sync.WaitGroup, cancel <-chan struct{}) {
 	defer wg.Done()
 
 	select {
 	case <-com.txpool:
+		atomic.AddUint64(&com.accepted, 1)
+	case <-cancel:
 	case <-com.exit:
 	}
 }
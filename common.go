@@ -1,14 +1,13 @@
-package main
+package btcsim
 
 import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"os"
 	"os/exec"
-	"path/filepath"
 	"runtime"
+	"sync"
 	"time"
 
 	rpc "github.com/btcsuite/btcrpcclient"
@@ -18,6 +17,16 @@ import (
 // to the node within maxConnRetries * 50ms
 var ErrConnectionTimeOut = errors.New("connection timeout")
 
+const (
+	// stopTimeout is the time to wait for a node process to exit after
+	// it has been interrupted, before it is killed
+	stopTimeout = 30 * time.Second
+
+	// restartDelay is the time to wait before restarting a node process
+	// which exited unexpectedly
+	restartDelay = time.Second
+)
+
 // Args is an interface which specifies how to access all the data required
 // to launch and connect to a RPC server, typically btcd or btcwallet
 type Args interface {
@@ -29,42 +38,88 @@ type Args interface {
 }
 
 // Node is a RPC server node, typically btcd or btcwallet and functions to
-// manage the instance
+// manage the instance, the node of an in-process wallet has no process
 // All functions common to btcd and btcwallet go here while btcdArgs and
 // btcwalletArgs hold the different implementations
 type Node struct {
 	Args
+	cfg      *Config
 	handlers *rpc.NotificationHandlers
-	cmd      *exec.Cmd
-	client   *rpc.Client
+	client   *rpcClient
 	pidFile  string
+	output   io.Writer
+
+	// pool is the pool of clients shared by the in-process wallets
+	// connected to the node, if any, and shared is set when the client of
+	// the node belongs to such a pool and must not be shut down with it
+	pool   *clientPool
+	shared bool
+
+	// restart defines whether the node process is restarted when it
+	// exits unexpectedly, after restartAfter or restartDelay if unset
+	restart      bool
+	restartAfter time.Duration
+
+	// mtx protects cmd and exited which are replaced on restart
+	mtx    sync.Mutex
+	cmd    *exec.Cmd
+	exited chan struct{}
+	quit   chan struct{}
+
+	// dead is closed when the process exited unexpectedly and is not
+	// restarted
+	dead chan struct{}
 }
 
 // NewNodeFromArgs starts a new node using the args provided, sets the handlers
-// and loggers. It does not start the node process, Start() should be called for that
-func NewNodeFromArgs(args Args, handlers *rpc.NotificationHandlers, w io.Writer) (*Node, error) {
+// and loggers of the run configured by cfg. It does not start the node
+// process, Start() should be called for that
+func NewNodeFromArgs(cfg *Config, args Args, handlers *rpc.NotificationHandlers, w io.Writer) (*Node, error) {
 	n := Node{
 		Args:     args,
+		cfg:      cfg,
 		handlers: handlers,
+		output:   w,
+		quit:     make(chan struct{}),
+		dead:     make(chan struct{}),
 	}
+	n.cmd = n.command()
+	return &n, nil
+}
+
+// command returns a new Cmd of the node with the output attached
+func (n *Node) command() *exec.Cmd {
 	cmd := n.Command()
-	n.cmd = cmd
-	if w != nil {
-		n.cmd.Stdout = w
-		n.cmd.Stderr = w
+	if cmd != nil && n.output != nil {
+		cmd.Stdout = n.output
+		cmd.Stderr = n.output
 	}
-	return &n, nil
+	return cmd
 }
 
 // Start stats the node command
-// It writes a pidfile to AppDataDir with the name of the process
+// It writes a pidfile to the run directory with the name of the process
 // which can be used to terminate the process in case of a hang or panic
+// The process is monitored until it exits, and restarted if it exits
+// unexpectedly and restart is set
 func (n *Node) Start() error {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	if err := n.start(); err != nil {
+		return err
+	}
+	go n.monitor()
+	return nil
+}
+
+// start starts the node command and writes the pidfile
+// It must be called with the mutex held
+func (n *Node) start() error {
 	if err := n.cmd.Start(); err != nil {
 		return err
 	}
-	pid, err := os.Create(filepath.Join(AppDataDir,
-		fmt.Sprintf("%s.pid", n.Args)))
+	n.exited = make(chan struct{})
+	pid, err := os.Create(n.cfg.runPath(fmt.Sprintf("%s.pid", n.Args)))
 	if err != nil {
 		return err
 	}
@@ -78,15 +133,93 @@ func (n *Node) Start() error {
 	return nil
 }
 
-// Connect tries to connect to the launched node and sets the
-// client field. It returns an error if the connection times out
+// monitor runs as a goroutine and waits for the node process to exit
+// If the process exits before Stop is called, it is restarted when
+// restart is set. The rpc client reconnects by itself in that case
+func (n *Node) monitor() {
+	for {
+		n.mtx.Lock()
+		cmd, exited := n.cmd, n.exited
+		n.mtx.Unlock()
+
+		err := cmd.Wait()
+		close(exited)
+
+		select {
+		case <-n.quit:
+			return
+		default:
+		}
+		log.Warnf("%s: Process exited unexpectedly: %v", n, err)
+		if !n.restart {
+			close(n.dead)
+			return
+		}
+
+		delay := n.restartAfter
+		if delay == 0 {
+			delay = restartDelay
+		}
+		select {
+		case <-time.After(delay):
+		case <-n.quit:
+			return
+		}
+
+		n.mtx.Lock()
+		select {
+		case <-n.quit:
+			n.mtx.Unlock()
+			return
+		default:
+		}
+		log.Infof("%s: Restarting process", n)
+		n.cmd = n.command()
+		err = n.start()
+		n.mtx.Unlock()
+		if err != nil {
+			log.Errorf("%s: Cannot restart process: %v", n, err)
+			close(n.dead)
+			return
+		}
+	}
+}
+
+// Dead returns a channel which is closed when the node process exited
+// unexpectedly and is not restarted
+func (n *Node) Dead() <-chan struct{} {
+	return n.dead
+}
+
+// Kill kills the node process as if it crashed, it is restarted if
+// restart is set
+func (n *Node) Kill() error {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	if n.cmd == nil || n.cmd.Process == nil {
+		return errors.New("process not started")
+	}
+	return n.cmd.Process.Kill()
+}
+
+// Connect waits for the launched node to listen, connects to it and sets
+// the client field, then waits for the node to serve rpc calls. It returns
+// an error if the connection times out, or if the node is not ready within
+// -readytimeout
 func (n *Node) Connect() error {
 	var client *rpc.Client
 	var err error
 
 	rpcConf := n.RPCConnConfig()
+	if n.restart {
+		// let the client reconnect when the process is restarted
+		rpcConf.DisableAutoReconnect = false
+	}
 
-	for i := 0; i < *maxConnRetries; i++ {
+	if err := n.probe("listening", n.cfg.ReadyTimeout, dialProbe(rpcConf.Host)); err != nil {
+		return err
+	}
+	for i := 0; i < n.cfg.MaxConnRetries; i++ {
 		if client, err = rpc.New(&rpcConf, n.handlers); err != nil {
 			time.Sleep(time.Duration(i) * 50 * time.Millisecond)
 			continue
@@ -96,31 +229,65 @@ func (n *Node) Connect() error {
 	if client == nil {
 		return ErrConnectionTimeOut
 	}
-	n.client = client
-	return nil
+	n.client = newRPCClient(n.cfg, client, n.String())
+	return n.probe("ready", n.cfg.ReadyTimeout, n.readyProbe())
 }
 
 // Stop interrupts a process and waits until it exits
 // On windows, interrupt is not supported, so a kill
 // signal is used instead
+// If the process does not exit within stopTimeout, it is killed
 func (n *Node) Stop() error {
-	if n.cmd == nil || n.cmd.Process == nil {
+	n.mtx.Lock()
+	select {
+	case <-n.quit:
+		// already stopped
+		n.mtx.Unlock()
+		return nil
+	default:
+		close(n.quit)
+	}
+	cmd, exited := n.cmd, n.exited
+	n.mtx.Unlock()
+
+	if cmd == nil || cmd.Process == nil || exited == nil {
 		// return if not properly initialized
 		// or error starting the process
 		return nil
 	}
-	defer n.cmd.Wait()
+	select {
+	case <-exited:
+		// already exited
+		return nil
+	default:
+	}
+
+	var err error
 	if runtime.GOOS == "windows" {
-		return n.cmd.Process.Signal(os.Kill)
+		err = cmd.Process.Signal(os.Kill)
+	} else {
+		err = cmd.Process.Signal(os.Interrupt)
 	}
-	return n.cmd.Process.Signal(os.Interrupt)
+	if err != nil {
+		return err
+	}
+	select {
+	case <-exited:
+	case <-time.After(stopTimeout):
+		log.Warnf("%s: Process did not exit after %v, killing it", n, stopTimeout)
+		if err := cmd.Process.Kill(); err != nil {
+			return err
+		}
+		<-exited
+	}
+	return nil
 }
 
 // Cleanup cleanups process and args files
 func (n *Node) Cleanup() error {
 	if n.pidFile != "" {
 		if err := os.Remove(n.pidFile); err != nil {
-			log.Printf("Cannot remove file %s: %v", n.pidFile, err)
+			log.Errorf("Cannot remove file %s: %v", n.pidFile, err)
 		}
 	}
 	return n.Args.Cleanup()
@@ -128,14 +295,20 @@ func (n *Node) Cleanup() error {
 
 // Shutdown stops a node and cleansup
 func (n *Node) Shutdown() {
-	if n.client != nil {
+	if n.pool != nil {
+		n.pool.Shutdown()
+	}
+	if n.client != nil && !n.shared {
 		n.client.Shutdown()
 	}
 	if err := n.Stop(); err != nil {
-		log.Printf("%s: Cannot stop node: %v", n, err)
+		log.Errorf("%s: Cannot stop node: %v", n, err)
 	}
 	if err := n.Cleanup(); err != nil {
-		log.Printf("%s: Cannot cleanup: %v", n, err)
+		log.Errorf("%s: Cannot cleanup: %v", n, err)
+	}
+	if c, ok := n.output.(io.Closer); ok {
+		c.Close()
 	}
-	log.Printf("%s: Shutdown", n)
+	log.Infof("%s: Shutdown", n)
 }
@@ -0,0 +1,133 @@
+package btcsim
+
+import (
+	"os/exec"
+	"testing"
+	"time"
+
+	rpc "github.com/btcsuite/btcrpcclient"
+)
+
+// fakeArgs implements the Args interface and launches an arbitrary
+// command instead of btcd or btcwallet
+type fakeArgs struct {
+	name string
+	args []string
+}
+
+func (a *fakeArgs) Arguments() []string           { return a.args }
+func (a *fakeArgs) Command() *exec.Cmd            { return exec.Command(a.name, a.args...) }
+func (a *fakeArgs) RPCConnConfig() rpc.ConnConfig { return rpc.ConnConfig{} }
+func (a *fakeArgs) Cleanup() error                { return nil }
+func (a *fakeArgs) String() string                { return "fake-" + a.name }
+
+func TestNodeStop(t *testing.T) {
+	n, err := NewNodeFromArgs(DefaultConfig(), &fakeArgs{name: "sleep", args: []string{"60"}}, nil, nil)
+	if err != nil {
+		t.Fatalf("NewNodeFromArgs error: %v", err)
+	}
+	if err := n.Start(); err != nil {
+		t.Skipf("cannot start fake node: %v", err)
+	}
+	defer n.Cleanup()
+	if err := n.Stop(); err != nil {
+		t.Errorf("Stop error: %v", err)
+	}
+	select {
+	case <-n.exited:
+	default:
+		t.Errorf("Stop returned before the process exited")
+	}
+	// stopping twice is a no-op
+	if err := n.Stop(); err != nil {
+		t.Errorf("second Stop error: %v", err)
+	}
+}
+
+func TestNodeRestart(t *testing.T) {
+	n, err := NewNodeFromArgs(DefaultConfig(), &fakeArgs{name: "true"}, nil, nil)
+	if err != nil {
+		t.Fatalf("NewNodeFromArgs error: %v", err)
+	}
+	n.restart = true
+	if err := n.Start(); err != nil {
+		t.Skipf("cannot start fake node: %v", err)
+	}
+	defer n.Cleanup()
+
+	n.mtx.Lock()
+	first := n.cmd
+	n.mtx.Unlock()
+
+	// wait for the process to exit and be restarted
+	deadline := time.Now().Add(5 * restartDelay)
+	for time.Now().Before(deadline) {
+		n.mtx.Lock()
+		cmd := n.cmd
+		n.mtx.Unlock()
+		if cmd != first {
+			if err := n.Stop(); err != nil {
+				t.Errorf("Stop error: %v", err)
+			}
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	n.Stop()
+	t.Errorf("node process was not restarted")
+}
+
+func TestNodeDead(t *testing.T) {
+	n, err := NewNodeFromArgs(DefaultConfig(), &fakeArgs{name: "true"}, nil, nil)
+	if err != nil {
+		t.Fatalf("NewNodeFromArgs error: %v", err)
+	}
+	if err := n.Start(); err != nil {
+		t.Skipf("cannot start fake node: %v", err)
+	}
+	defer n.Cleanup()
+	defer n.Stop()
+
+	select {
+	case <-n.Dead():
+	case <-time.After(5 * time.Second):
+		t.Errorf("node process exited but is not reported dead")
+	}
+}
+
+func TestNodeKill(t *testing.T) {
+	n, err := NewNodeFromArgs(DefaultConfig(), &fakeArgs{name: "sleep", args: []string{"60"}}, nil, nil)
+	if err != nil {
+		t.Fatalf("NewNodeFromArgs error: %v", err)
+	}
+	n.restart = true
+	n.restartAfter = 10 * time.Millisecond
+	if err := n.Start(); err != nil {
+		t.Skipf("cannot start fake node: %v", err)
+	}
+	defer n.Cleanup()
+
+	n.mtx.Lock()
+	first := n.cmd
+	n.mtx.Unlock()
+	if err := n.Kill(); err != nil {
+		t.Fatalf("Kill error: %v", err)
+	}
+
+	// the killed process is restarted after restartAfter
+	deadline := time.Now().Add(restartDelay)
+	for time.Now().Before(deadline) {
+		n.mtx.Lock()
+		cmd := n.cmd
+		n.mtx.Unlock()
+		if cmd != first {
+			if err := n.Stop(); err != nil {
+				t.Errorf("Stop error: %v", err)
+			}
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	n.Stop()
+	t.Errorf("killed node process was not restarted")
+}
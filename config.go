@@ -0,0 +1,675 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"flag"
+	"fmt"
+	"runtime"
+	"time"
+
+	"github.com/btcsuite/btcutil"
+)
+
+// Config is the configuration of a simulation, each field being set by the
+// flag of the btcsim command documented with it. DefaultConfig returns the
+// configuration of the command run without flags, which Main updates from
+// its arguments before passing it to New.
+type Config struct {
+	// MaxConnRetries defines the number of times to retry rpc client connections
+	MaxConnRetries int
+
+	// ReadyTimeout is the time to wait for a started node to serve rpc
+	ReadyTimeout time.Duration
+
+	// NumActors defines the number of actors to spawn
+	NumActors int
+
+	// FloodTarget is the number of unconfirmed transactions a dedicated
+	// actor, launched in addition to the others, keeps in the mempool
+	FloodTarget int
+
+	// NumNodes defines the number of chain server nodes to launch, actors
+	// are distributed evenly across them
+	NumNodes int
+
+	// BackendName defines the implementation of the chain server nodes
+	BackendName string
+
+	// BtcdExe is the btcd executable run by the nodes and the miner
+	BtcdExe string
+
+	// BitcoindExe is the bitcoind executable run by the nodes with the
+	// bitcoind backend
+	BitcoindExe string
+
+	// BtcdVersion is the version the btcd executable must report at
+	// startup, any version when empty
+	BtcdVersion string
+
+	// DockerMode launches the nodes, the miner and the wallets in docker
+	// containers instead of local processes
+	DockerMode bool
+
+	// DockerImages defines the images running each executable
+	DockerImages string
+
+	// DockerConfig defines the image, resource limits and network of the
+	// containers, globally or per container
+	DockerConfig string
+
+	// TopologyName defines how the btcd nodes are connected to each other
+	TopologyName string
+
+	// MatchingName defines how the actors paid by each transaction are
+	// picked
+	MatchingName string
+
+	// ProfileMix defines the mix of actor profiles by weight
+	ProfileMix string
+
+	// ActorProfiles defines the profiles of individual actors
+	ActorProfiles string
+
+	// ActorBehaviors defines the behaviors of individual actors, the
+	// others being passive, exec: followed by a command line runs an
+	// external program as behavior
+	ActorBehaviors string
+
+	// BtcwalletExe is the btcwallet executable run by the actors, unless
+	// BtcwalletVersions assigns them other executables by name
+	BtcwalletExe      string
+	BtcwalletVersions string
+
+	// BtcwalletVersion is the version the btcwallet executable must
+	// report at startup, any version when empty
+	BtcwalletVersion string
+
+	// ActorWallets defines the btcwallet versions of individual actors
+	ActorWallets string
+
+	// BehaviorTick defines the interval between two ticks of the actor
+	// behaviors
+	BehaviorTick time.Duration
+
+	// ProfilePath is the path to a CSV file containing custom profiles
+	ProfilePath string
+
+	// AmountDistName defines the distribution of the amounts paid by
+	// actors whose profile has none
+	AmountDistName string
+
+	// StopBlock defines how many blocks have to connect to the blockchain
+	// before the simulation normally stops
+	StopBlock int
+
+	// StartBlock defines after which block the blockchain is start enough to start
+	// controlled mining as per the tx curve
+	StartBlock int
+
+	// MaxAddresses defines the number of addresses to generate per actor
+	MaxAddresses int
+
+	// MaxBlockSize defines the maximum block size to be passed as -blockmaxsize to the miner
+	MaxBlockSize int
+
+	// MinBlockSize and PrioritySize are passed as -blockminsize and
+	// -blockprioritysize to the miner, a negative priority size keeps
+	// the default of btcd
+	MinBlockSize int
+	PrioritySize int
+
+	// MaxMempool and MinRelayFee are the relay policy of the nodes, a zero
+	// limit and a negative rate keep the defaults of the backend
+	MaxMempool  int
+	MinRelayFee float64
+
+	// Rebroadcast makes the actors rebroadcast their transactions evicted
+	// from the mempool of the node
+	Rebroadcast bool
+
+	// BlockSizes is a campaign of maximum block sizes, -runs simulations
+	// are run with each of them and compared
+	BlockSizes string
+
+	// BtcdVersions is a campaign of btcd executables, -runs simulations
+	// of the same scenario are run with each and compared
+	BtcdVersions string
+
+	// SweepActors, SweepTxRates and SweepBlockIntervals are a campaign
+	// sweeping the grid of their values, -runs simulations are run at
+	// every combination and compared
+	SweepActors         string
+	SweepTxRates        string
+	SweepBlockIntervals string
+
+	// MaxSplit defines the maximum number of pieces to divide a utxo into
+	MaxSplit int
+
+	// DataFraction is the fraction of the transactions of actors which
+	// embed DataCarrierSize bytes in an OP_RETURN output
+	DataFraction    float64
+	DataCarrierSize int
+
+	// DustOutputs is the number of outputs worth between DustAmount and
+	// twice DustAmount created by an actor, on average every DustInterval,
+	// which are consolidated once the actor owns as many
+	DustOutputs  int
+	DustAmount   int64
+	DustInterval time.Duration
+
+	// OrphanInterval is the average interval at which an actor sends a
+	// transaction over the p2p network before its parent, which is sent
+	// OrphanDelay later
+	OrphanInterval time.Duration
+	OrphanDelay    time.Duration
+
+	// FuzzInterval is the average interval at which an actor sends a
+	// transaction of the next edge case of the relay policy
+	FuzzInterval time.Duration
+
+	// TimelockInterval is the average interval at which an actor creates
+	// a transaction locked up to TimelockBlocks ahead of the tip or up to
+	// TimelockDelay ahead of now, which is sent until accepted
+	TimelockInterval time.Duration
+	TimelockBlocks   int
+	TimelockDelay    time.Duration
+
+	// FeePolicyName defines the fee policy of actors whose profile has none
+	FeePolicyName string
+
+	// ProfileAddr is the listen address of the profiling server
+	ProfileAddr string
+
+	// TxCurvePath is the path to a CSV file containing the block, utxo count, tx count
+	TxCurvePath string
+
+	// Latency, jitter and bandwidth shape the links between nodes, which
+	// then connect to each other through proxies when any of them is set
+	Latency   time.Duration
+	Jitter    time.Duration
+	Bandwidth int
+
+	// RestartNodes defines whether btcd nodes that exit unexpectedly
+	// are restarted
+	RestartNodes bool
+
+	// LogLevelName is the level of the messages logged by the simulator
+	LogLevelName string
+
+	// MaxLogSize is the size in bytes after which the log files of the
+	// nodes and wallets in the run directory are rotated
+	MaxLogSize int64
+
+	// Bootstrap defines whether the blocks before the start block are
+	// mined with generate, with up to BootstrapTxs transactions each,
+	// instead of empty blocks mined by the cpu miner
+	Bootstrap    bool
+	BootstrapTxs int
+
+	// SaveStatePath and LoadStatePath are the directories the state of
+	// the chain and wallets is saved to when the simulation stops and
+	// resumed from, to skip mining the initial blocks again
+	SaveStatePath string
+	LoadStatePath string
+
+	// AddressTypeMix is the mix of the types of the receiving addresses
+	// of the actors by weight
+	AddressTypeMix string
+
+	// HDWallets makes the actors derive their addresses from BIP32
+	// extended keys, watching HDGap addresses past the last one used
+	HDWallets bool
+	HDGap     uint
+
+	// WatchOnlyActors is the number of watch-only actors observing the
+	// payments to the actors
+	WatchOnlyActors int
+
+	// Invoices makes the payees of the payments issue invoices to fresh
+	// addresses, which expire after InvoiceExpiry of simulated time
+	Invoices      bool
+	InvoiceExpiry time.Duration
+
+	// InvoiceStatsPath is the path to write the lifecycle of every
+	// invoice to at the end of the simulation
+	InvoiceStatsPath string
+
+	// TxGraphPath is the path to write the graph of the transactions of
+	// the chain to at the end of the simulation
+	TxGraphPath string
+
+	// LabelsPath is the path to write the actors owning every address
+	// and transaction to at the end of the simulation
+	LabelsPath string
+
+	// WalletDBDir is the directory of the pre-built wallet databases the
+	// actors are started from, wallet-i.db for actor i
+	WalletDBDir string
+
+	// TxStatsPath is the path to write the statistics of every transaction
+	// sent by actors to at the end of the simulation
+	TxStatsPath string
+
+	// FeeStatsPath is the path to write the fees of the transactions mined
+	// in every block to at the end of the simulation
+	FeeStatsPath string
+
+	// BlockStatsPath is the path to write the composition of every block
+	// compared with the mempool to at the end of the simulation
+	BlockStatsPath string
+
+	// LatencyStatsPath is the path to write the distribution of the
+	// confirmation latency by fee band to at the end of the simulation
+	LatencyStatsPath string
+
+	// FeeAccuracyPath is the path to write the accuracy of the fee rates
+	// estimated by the node when transactions were sent to, estimates are
+	// only recorded when it is set
+	FeeAccuracyPath string
+
+	// FeeTargets are the confirmation targets in blocks the node is asked
+	// to estimate fee rates for with -feeaccuracy
+	FeeTargets string
+
+	// PropagationStatsPath is the path to write the delay of every node
+	// to connect every block to, with several nodes
+	PropagationStatsPath string
+
+	// SummaryPath is the path to write the summary of the simulation to
+	SummaryPath string
+
+	// ReportPath is the path to write the HTML report of the simulation to
+	ReportPath string
+
+	// ResultsPath is the path to write whether the simulation passed its
+	// checks to, for harnesses running it
+	ResultsPath string
+
+	// Runs is the number of independent simulations to run, their
+	// summaries are aggregated. Runs are numbered from 1 and run i uses
+	// the seed plus i
+	Runs     int
+	Parallel bool
+
+	// RunID identifies a run among several, it namespaces the run
+	// directory, preferred ports and log output. It is set by -runs
+	RunID int
+
+	// Seed is the seed of every random decision taken by the simulation
+	Seed int64
+
+	// ScenarioPath is the path to a scenario file of timed events
+	ScenarioPath string
+
+	// FundAmount is the amount in BTC sent to actors without any utxo once
+	// the initial blocks are mined
+	FundAmount float64
+
+	// StatusInterval is the interval at which the status of the simulation
+	// is logged, zero disables it
+	StatusInterval time.Duration
+
+	// InvariantBlocks is the number of blocks between two checks of the
+	// balances of the actors against the coins issued
+	InvariantBlocks int
+
+	// AuditPath is the path to write the differences between the wallets
+	// of the actors and the chain found at shutdown to
+	AuditPath string
+
+	// MempoolInterval is the interval at which the mempools of the nodes
+	// are compared, and MempoolStatsPath the path to write the samples to
+	MempoolInterval  time.Duration
+	MempoolStatsPath string
+
+	// UtxoSnapshotBlocks is the number of blocks between snapshots of the
+	// utxo set, and UtxoStatsPath the path to write the snapshots to
+	UtxoSnapshotBlocks int
+	UtxoStatsPath      string
+
+	// ResourceInterval is the interval at which the resource usage of the
+	// node and wallet processes is sampled, and ResourceStatsPath the path
+	// to write the samples to
+	ResourceInterval  time.Duration
+	ResourceStatsPath string
+
+	// RPCStatsPath is the path to write the latency of the rpc calls by
+	// node and method to, the calls are timed only when it is set
+	RPCStatsPath string
+
+	// ControlAddr is the address of the HTTP control API
+	ControlAddr string
+
+	// GrowthSpec defines the number of actors to grow or shrink to and the
+	// simulated duration to reach it over
+	GrowthSpec string
+
+	// DashboardAddr is the address of the live web dashboard
+	DashboardAddr string
+
+	// TUI renders the live state of the simulation in the terminal
+	TUI bool
+
+	// EventsOutPath is the path of the file or pipe every event of the
+	// simulation is streamed to
+	EventsOutPath string
+
+	// RecordPath is the path to record the run to and ReplayPath the path
+	// of a recorded run to replay
+	RecordPath string
+	ReplayPath string
+
+	// RPCTracePath is the path of the trace of every rpc call
+	RPCTracePath string
+
+	// AttackName is the strategy of the attacker competing with the miner,
+	// empty for no attack. The attacker mines a fraction AttackPower of
+	// the blocks
+	AttackName   string
+	AttackPower  float64
+	AttackConfs  int
+	AttackGiveUp int
+
+	// InProcess defines whether actors run in-process wallets signing
+	// with their own keys instead of btcwallet processes
+	InProcess bool
+
+	// RPCConns is the number of connections to each node shared by the
+	// in-process wallets, StartRate limits the number of actors started
+	// per second and ScaleMode sets both for thousands of actors
+	RPCConns  int
+	StartRate float64
+	ScaleMode bool
+
+	// StartConcurrency limits the number of actor wallets starting at
+	// once and StartTimeout is the time each is given to start, those
+	// which do not start in time fail
+	StartConcurrency int
+	StartTimeout     time.Duration
+
+	// ReplaceActors defines whether actors which fail are replaced by
+	// new actors with the same profile, failed actors are always removed
+	ReplaceActors bool
+
+	// ChaosInterval is the average interval at which the wallet process of
+	// a random actor is killed, it is restarted after ChaosDelay
+	ChaosInterval time.Duration
+	ChaosDelay    time.Duration
+
+	// CrashInterval is the average interval at which the wallet process
+	// of a random actor is killed at a random point of its next send,
+	// the wallets are audited at shutdown
+	CrashInterval time.Duration
+
+	// MultisigScheme defines the m-of-n multisig addresses actors pay to
+	// and spend from together, on average every MultisigInterval
+	MultisigScheme   string
+	MultisigInterval time.Duration
+
+	// CoinjoinParticipants is the number of actors joining their utxos in
+	// a single transaction, on average every CoinjoinInterval
+	CoinjoinParticipants int
+	CoinjoinInterval     time.Duration
+
+	// Duration defines how long the simulation runs before it is stopped,
+	// zero means the simulation only stops at StopBlock, -duration is kept
+	// as an alias
+	Duration time.Duration
+
+	// StopAfterBlocks and StopAfterTxs stop the simulation once as many
+	// blocks have been mined since transactions started, or transactions
+	// sent, zero for no limit
+	StopAfterBlocks int
+	StopAfterTxs    int
+
+	// TxRate defines the maximum number of transactions per second that
+	// actors are asked to generate, zero means no limit
+	TxRate float64
+
+	// LoadProfileName varies the transaction rate over the simulation
+	// instead of the constant TxRate
+	LoadProfileName string
+
+	// BlockBurstSpec releases a burst of transactions after every block
+	// on top of the transaction rate
+	BlockBurstSpec string
+
+	// SeasonalitySpec scales the transaction rate with daily and weekly
+	// cycles over simulated days
+	SeasonalitySpec string
+
+	// BlockInterval defines the time between two blocks mined during the
+	// simulation, its meaning depends on the mining schedule
+	BlockInterval time.Duration
+
+	// TimeScale speeds up the simulated time, the block interval, the
+	// intervals of the actors, the scenario and load schedules and the
+	// transaction rate all being in simulated time
+	TimeScale float64
+
+	// MiningSchedule defines when blocks are mined during the simulation
+	MiningSchedule string
+
+	// runDir and the fields below are the state of the run, set by New and
+	// Start rather than by the caller. runDir holds the data, log and pid
+	// files of the run
+	runDir string
+
+	// recording, rpcStats and rpcTracer record, time and trace the rpc
+	// calls of the run when enabled
+	recording *rpcRecording
+	rpcStats  *rpcRecorder
+	rpcTracer *rpcTrace
+
+	// provisioner runs the nodes and wallets in docker containers with
+	// -docker and loadedState is the state resumed with -load-state
+	provisioner *dockerProvisioner
+	loadedState *simState
+
+	// defaultAmounts and defaultFees are the amount distribution and fee
+	// policy of the actors whose profile has none, and feeMarket is set
+	// when fees vary so that the miner orders transactions by fee rate
+	defaultAmounts *amountDist
+	defaultFees    *feePolicy
+	feeMarket      bool
+}
+
+// DefaultConfig returns the configuration of the btcsim command run without
+// flags.
+func DefaultConfig() *Config {
+	c := new(Config)
+	c.flagSet()
+	return c
+}
+
+// flagSet returns the flags of the btcsim command setting the fields of c,
+// which are reset to their defaults. They are kept apart from the flags of
+// programs embedding simulations and a flag which cannot be parsed is
+// returned rather than exiting.
+func (c *Config) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("btcsim", flag.ContinueOnError)
+	fs.IntVar(&c.MaxConnRetries, "maxconnretries", 15, "Maximum retries to connect to rpc client")
+	fs.DurationVar(&c.ReadyTimeout, "readytimeout", time.Minute, "Time to wait for the rpc server of a started btcd, btcwallet or bitcoind process to listen and then to serve calls")
+	fs.IntVar(&c.NumActors, "actors", 1, "Number of actors to be launched")
+	fs.IntVar(&c.FloodTarget, "flood", 0, "Number of unconfirmed transactions kept in the mempool by an additional flooding actor")
+	fs.IntVar(&c.NumNodes, "nodes", 1, "Number of chain server nodes to be launched")
+	fs.StringVar(&c.BackendName, "backend", backendBtcd, "Chain server implementation of the nodes: btcd, or bitcoind on regtest with in-process actors")
+	fs.StringVar(&c.BtcdExe, "btcd", "btcd", "Path of the btcd executable")
+	fs.StringVar(&c.BitcoindExe, "bitcoind", "bitcoind", "Path of the bitcoind executable of the bitcoind backend")
+	fs.StringVar(&c.BtcdVersion, "btcd-version", "", "Required version of the btcd executable, e.g. 0.12, checked at startup")
+	fs.BoolVar(&c.DockerMode, "docker", false, "Launch the nodes, the miner and the wallets in docker containers")
+	fs.StringVar(&c.DockerImages, "dockerimages", "btcd=btcsuite/btcd,btcwallet=btcsuite/btcwallet",
+		"Docker images running each executable")
+	fs.StringVar(&c.DockerConfig, "dockerconfig", "",
+		"Image, cpus, memory and network of the docker containers, for all or per container, e.g. cpus=1,memory=512m,node-1.image=btcd:old,node-2.network=isolated")
+	fs.StringVar(&c.TopologyName, "topology", "mesh", "Topology of the btcd nodes: mesh, ring, star or random")
+	fs.StringVar(&c.MatchingName, "matching", "random", "How payees are picked: random, preferential or fixed")
+	fs.StringVar(&c.ProfileMix, "profiles", "",
+		"Mix of actor profiles by weight, e.g. spender=60,hoarder=30,faucet=10")
+	fs.StringVar(&c.ActorProfiles, "actorprofiles", "",
+		"Profiles of individual actors by index, e.g. 0=faucet,3=exchange")
+	fs.StringVar(&c.ActorBehaviors, "behaviors", "",
+		"Behaviors of individual actors by index, e.g. 0=tipper,3=forwarder,4=exec:python3 actor.py, the others are passive")
+	fs.StringVar(&c.BtcwalletExe, "btcwallet", "btcwallet", "Path of the btcwallet executable")
+	fs.StringVar(&c.BtcwalletVersions, "btcwallets", "",
+		"Comma separated btcwallet executables to compare by name, e.g. old=/opt/btcwallet-0.1/btcwallet,new=btcwallet, the actors being split evenly across them")
+	fs.StringVar(&c.BtcwalletVersion, "btcwallet-version", "", "Required version of the btcwallet executable, e.g. 0.7, checked at startup")
+	fs.StringVar(&c.ActorWallets, "actorwallets", "",
+		"btcwallet versions of individual actors by index, e.g. 0=old,3=new")
+	fs.DurationVar(&c.BehaviorTick, "behaviortick", time.Minute, "Interval between two ticks of the actor behaviors")
+	fs.StringVar(&c.ProfilePath, "profilefile", "",
+		"Path to the CSV file containing name, activity, min spend, max spend, recipient and optionally double spend probability and amount distribution fields of custom profiles")
+	fs.StringVar(&c.AmountDistName, "amountdist", "",
+		"Distribution of payment amounts in BTC, e.g. uniform:0.01:1, exponential:0.5, lognormal:-2:1.5 or pareto:0.01:1.5, spend fractions of profiles are used if empty")
+	fs.IntVar(&c.StopBlock, "stopblock", 15000, "Block height to stop the simulation at")
+	fs.IntVar(&c.StartBlock, "startblock", 15000, "Block height to start the simulation at")
+	fs.IntVar(&c.MaxAddresses, "maxaddresses", 100, "Maximum addresses per actor")
+	fs.IntVar(&c.MaxBlockSize, "maxblocksize", 999000, "Maximum block size in bytes used by the miner")
+	fs.IntVar(&c.MinBlockSize, "minblocksize", 0, "Minimum block size in bytes used by the miner")
+	fs.IntVar(&c.PrioritySize, "prioritysize", -1, "Block size in bytes used by the miner for high priority transactions, negative for the btcd default")
+	fs.IntVar(&c.MaxMempool, "maxmempool", 0, "Mempool size limit of the nodes in MB, 0 for the default of the nodes, which btcd does not support yet so it needs the bitcoind backend")
+	fs.Float64Var(&c.MinRelayFee, "minrelayfee", -1, "Minimum relay fee rate of the nodes in satoshis per byte, negative for the backend default")
+	fs.BoolVar(&c.Rebroadcast, "rebroadcast", false, "Rebroadcast the transactions of actors evicted from the mempool")
+	fs.StringVar(&c.BlockSizes, "blocksizes", "", "Comma separated maximum block sizes to compare, running -runs simulations with each")
+	fs.StringVar(&c.BtcdVersions, "btcdversions", "", "Comma separated btcd executables to compare by name, e.g. old=/opt/btcd-0.9/btcd,new=btcd, running -runs simulations with each")
+	fs.StringVar(&c.SweepActors, "sweepactors", "", "Comma separated numbers of actors to sweep, running -runs simulations at every combination with -sweeptxrates and -sweepblockintervals")
+	fs.StringVar(&c.SweepTxRates, "sweeptxrates", "", "Comma separated tx rates to sweep, see -sweepactors")
+	fs.StringVar(&c.SweepBlockIntervals, "sweepblockintervals", "", "Comma separated block intervals to sweep, see -sweepactors")
+	fs.IntVar(&c.MaxSplit, "maxsplit", 100, "Maximum number of pieces to divide a utxo into")
+	fs.Float64Var(&c.DataFraction, "datafraction", 0, "Fraction of transactions embedding data in an OP_RETURN output")
+	fs.IntVar(&c.DataCarrierSize, "datasize", 40, "Size in bytes of the data embedded in OP_RETURN outputs")
+	fs.IntVar(&c.DustOutputs, "dust", 0, "Number of tiny outputs created by an actor at a time, 0 to disable")
+	fs.Int64Var(&c.DustAmount, "dustamount", int64(dustThreshold), "Minimum amount in satoshis of tiny outputs, they are worth up to twice as much")
+	fs.DurationVar(&c.DustInterval, "dustinterval", 10*time.Second, "Average interval at which tiny outputs are created or consolidated")
+	fs.DurationVar(&c.OrphanInterval, "orphans", 0, "Average interval at which an actor broadcasts a transaction before its parent, 0 to disable")
+	fs.DurationVar(&c.OrphanDelay, "orphandelay", 2*time.Second, "Time between the broadcast of a transaction and of its parent with -orphans")
+	fs.DurationVar(&c.FuzzInterval, "fuzz", 0, "Average interval at which an actor sends a transaction exercising an edge case of the relay policy, 0 to disable")
+	fs.DurationVar(&c.TimelockInterval, "timelocks", 0, "Average interval at which an actor creates a transaction locked to a future height or time, 0 to disable")
+	fs.IntVar(&c.TimelockBlocks, "timelockblocks", 3, "Maximum number of blocks ahead of the tip a transaction is locked to with -timelocks")
+	fs.DurationVar(&c.TimelockDelay, "timelockdelay", time.Minute, "Maximum time ahead a transaction is locked to with -timelocks")
+	fs.StringVar(&c.FeePolicyName, "feepolicy", "",
+		"Fee policy with rates in satoshis per byte, e.g. fixed:10, random:1:50, estimate:6 or rbf:5:1.5, a fee of 0.0001 BTC is paid if empty")
+	fs.StringVar(&c.ProfileAddr, "profile", "6060", "Listen address for profiling server")
+	fs.StringVar(&c.TxCurvePath, "txcurve", "",
+		"Path to the CSV File containing block, utxo count, tx count fields")
+	fs.DurationVar(&c.Latency, "latency", 0, "Delay added to the links between nodes")
+	fs.DurationVar(&c.Jitter, "jitter", 0, "Maximum random delay added on top of the latency of the links between nodes")
+	fs.IntVar(&c.Bandwidth, "bandwidth", 0, "Maximum bandwidth in bytes per second of each direction of the links between nodes, 0 for no limit")
+	fs.BoolVar(&c.RestartNodes, "restartnodes", false, "Restart btcd nodes that exit unexpectedly")
+	fs.StringVar(&c.LogLevelName, "loglevel", "info", "Level of the messages logged: trace, debug, info, warn, error, critical or off")
+	fs.Int64Var(&c.MaxLogSize, "logsize", 10*1024*1024, "Size in bytes after which node and wallet log files are rotated, 0 to disable")
+	fs.BoolVar(&c.Bootstrap, "bootstrap", false, "Mine the blocks before the start block with generate, including -bootstraptxs transactions each")
+	fs.IntVar(&c.BootstrapTxs, "bootstraptxs", 100, "Maximum number of utxo splitting transactions per block mined while bootstrapping")
+	fs.StringVar(&c.SaveStatePath, "save-state", "", "Directory to save the chain and wallet state to when the simulation stops")
+	fs.StringVar(&c.LoadStatePath, "load-state", "", "Directory of a state saved with -save-state to resume the simulation from")
+	fs.StringVar(&c.AddressTypeMix, "addresstypes", "", "Mix of the types of the receiving addresses of every actor by weight, types are p2pkh, uncompressed and p2sh, e.g. p2pkh=70,p2sh=20,uncompressed=10, all p2pkh if empty")
+	fs.BoolVar(&c.HDWallets, "hdwallets", false, "Actors derive their addresses from BIP32 extended keys, requires -inprocess until btcwallet can import extended keys")
+	fs.UintVar(&c.HDGap, "hdgap", 20, "Gap limit of the addresses derived by HD wallet actors")
+	fs.IntVar(&c.WatchOnlyActors, "watchonly", 0, "Number of watch-only actors, each watching the addresses of an actor from another node to check the notifications of its payments and its balance")
+	fs.BoolVar(&c.Invoices, "invoices", false, "Payees issue an invoice for every payment to a single payee, with a fresh address and the amount they ask, which the payer fulfills")
+	fs.DurationVar(&c.InvoiceExpiry, "invoiceexpiry", 10*time.Minute, "Simulated time after which the invoices not paid expire")
+	fs.StringVar(&c.InvoiceStatsPath, "invoicestats", "", "Path to write the lifecycle of every invoice to as CSV")
+	fs.StringVar(&c.TxGraphPath, "txgraph", "",
+		"Path to write the transaction graph of the chain labeled with the actors to, as GraphML if it ends with .graphml, DOT otherwise")
+	fs.StringVar(&c.LabelsPath, "labels", "", "Path to write the actor, profile and behavior owning every address and transaction to as JSON")
+	fs.StringVar(&c.WalletDBDir, "walletdbs", "", "Directory of pre-built btcwallet databases to start the actors from, wallet-<i>.db for actor i, the other actors create new wallets")
+	fs.StringVar(&c.TxStatsPath, "txstats", "",
+		"Path to write transaction statistics to, as JSON if it ends with .json, CSV otherwise")
+	fs.StringVar(&c.FeeStatsPath, "feestats", "", "Path to write the fee rate histogram of every block to as CSV")
+	fs.StringVar(&c.BlockStatsPath, "blockstats", "", "Path to write the size, fees and fee rates of every block and of the transactions it left in the mempool to as CSV")
+	fs.StringVar(&c.LatencyStatsPath, "latencystats", "", "Path to write the confirmation latency distribution by fee rate band to as CSV")
+	fs.StringVar(&c.FeeAccuracyPath, "feeaccuracy", "", "Path to write the accuracy of the node's fee estimates by confirmation target to as CSV")
+	fs.StringVar(&c.FeeTargets, "feetargets", "1,2,3,6", "Comma separated confirmation targets in blocks to evaluate the fee estimates for")
+	fs.StringVar(&c.PropagationStatsPath, "propagationstats", "", "Path to write the propagation delay of every block across nodes to as CSV")
+	fs.StringVar(&c.SummaryPath, "summary", "", "Path to write the JSON summary of the simulation to")
+	fs.StringVar(&c.ReportPath, "report", "", "Path to write a self-contained HTML report with charts of the simulation to")
+	fs.StringVar(&c.ResultsPath, "results", "", "Path to write the JSON pass/fail result of the simulation to, with failed assertions and error counts")
+	fs.IntVar(&c.Runs, "runs", 1, "Number of independent simulations to run and aggregate")
+	fs.BoolVar(&c.Parallel, "parallel", false, "Run the simulations of -runs in parallel instead of one after the other")
+	fs.IntVar(&c.RunID, "runid", 0, "ID of the run among several, set by -runs")
+	fs.Int64Var(&c.Seed, "seed", 0, "Seed for all random decisions, the current time if 0")
+	fs.StringVar(&c.ScenarioPath, "scenario", "", "Path to a scenario file of timed events to run during the simulation")
+	fs.Float64Var(&c.FundAmount, "fund", 0,
+		"Amount in BTC to send to every actor without spendable outputs before transactions start, 0 to disable")
+	fs.DurationVar(&c.StatusInterval, "status", 0, "Interval at which to log the height, mempool size, tx rate and healthy actors, 0 to disable")
+	fs.IntVar(&c.InvariantBlocks, "invariants", 0, "Number of blocks between checks of actor balances against the coins issued, 0 to disable")
+	fs.StringVar(&c.AuditPath, "audit", "", "Path to write the differences between actor wallets and the chain found at shutdown to as CSV, disabled if empty")
+	fs.DurationVar(&c.MempoolInterval, "mempoolmonitor", 0, "Interval at which to compare the mempools of the nodes, 0 to disable")
+	fs.StringVar(&c.MempoolStatsPath, "mempoolstats", "", "Path to write the mempool divergence samples to as CSV")
+	fs.IntVar(&c.UtxoSnapshotBlocks, "utxosnapshots", 0, "Number of blocks between snapshots of the size, dust and age of the utxo set, 0 to disable")
+	fs.StringVar(&c.UtxoStatsPath, "utxostats", "", "Path to write the utxo set snapshots taken with --utxosnapshots to as CSV")
+	fs.DurationVar(&c.ResourceInterval, "resourcemonitor", 0, "Interval at which to sample the cpu, memory, file descriptor and disk usage of the node and wallet processes, 0 to disable (linux only)")
+	fs.StringVar(&c.ResourceStatsPath, "resourcestats", "", "Path to write the resource usage samples to as CSV")
+	fs.StringVar(&c.RPCStatsPath, "rpcstats", "", "Path to write the latency histograms of the rpc calls by method and node to as CSV, rpc calls are timed only when set")
+	fs.StringVar(&c.ControlAddr, "control", "", "Address to serve the HTTP control API on, e.g. localhost:18600, localhost if no host is given, empty to disable")
+	fs.StringVar(&c.GrowthSpec, "grow", "", "Number of actors to grow or shrink to linearly once they started and the duration to reach it over, e.g. 200:30m")
+	fs.StringVar(&c.DashboardAddr, "dashboard", "", "Address to serve the live web dashboard on, e.g. localhost:18601, localhost if no host is given, empty to disable")
+	fs.BoolVar(&c.TUI, "tui", false, "Render the nodes, actors and events of the simulation live in the terminal, the log is written to btcsim.log in the run directory meanwhile")
+	fs.StringVar(&c.EventsOutPath, "events-out", "", "Path of a file or pipe to stream every simulation event to as newline-delimited JSON, - for the standard output")
+	fs.StringVar(&c.RecordPath, "record", "", "Path to record the seed, flags and rpc results of the run to, so that it can be replayed with -replay")
+	fs.StringVar(&c.ReplayPath, "replay", "", "Path of a run recorded with -record to replay with its seed and flags, its rpc calls are answered from the recording until it has none left")
+	fs.StringVar(&c.RPCTracePath, "rpc-trace", "", "Path to trace every rpc request and response of the simulator and actors to, as a HAR-like JSON file")
+	fs.StringVar(&c.AttackName, "attack", "", "Strategy of an attacker competing with the miner: honest, private or selfish, empty for no attack")
+	fs.Float64Var(&c.AttackPower, "attackpower", 0.3, "Fraction of the blocks mined by the attacker")
+	fs.IntVar(&c.AttackConfs, "attackconfs", 6, "Number of confirmations the private attacker reverses before releasing its chain")
+	fs.IntVar(&c.AttackGiveUp, "attackgiveup", 6, "Number of blocks the public chain leads by before the private attacker gives up")
+	fs.BoolVar(&c.InProcess, "inprocess", false, "Run actors as in-process wallets instead of btcwallet processes")
+	fs.IntVar(&c.RPCConns, "rpcconns", 0, "Number of connections to each node shared by in-process wallets, 0 for one connection per actor")
+	fs.Float64Var(&c.StartRate, "startrate", 0, "Maximum number of actors started per second, 0 for no limit")
+	fs.BoolVar(&c.ScaleMode, "scale", false, "High-scale mode for thousands of actors, implies -inprocess and sets -rpcconns, -startrate and -maxaddresses unless given")
+	fs.IntVar(&c.StartConcurrency, "startconcurrency", runtime.NumCPU(), "Maximum number of actor wallets starting at once, 0 for no limit")
+	fs.DurationVar(&c.StartTimeout, "starttimeout", 5*time.Minute, "Time an actor wallet is given to start before the actor fails, 0 for no limit")
+	fs.BoolVar(&c.ReplaceActors, "replaceactors", false, "Replace actors which fail with new actors of the same profile")
+	fs.DurationVar(&c.ChaosInterval, "chaos", 0, "Average interval at which a random actor's wallet process is killed, 0 to disable")
+	fs.DurationVar(&c.ChaosDelay, "chaosdelay", 5*time.Second, "Delay before a killed wallet process is restarted")
+	fs.DurationVar(&c.CrashInterval, "crashtest", 0, "Average interval at which a random actor's wallet process is killed while sending a transaction, 0 to disable")
+	fs.StringVar(&c.MultisigScheme, "multisig", "", "Multisig scheme of the payments between groups of actors, e.g. 2-of-3, disabled if empty")
+	fs.DurationVar(&c.MultisigInterval, "multisiginterval", 30*time.Second, "Average interval between multisig payments")
+	fs.IntVar(&c.CoinjoinParticipants, "coinjoin", 0, "Number of actors taking part in each coinjoin transaction, 0 to disable")
+	fs.DurationVar(&c.CoinjoinInterval, "coinjoininterval", 30*time.Second, "Average interval between coinjoin transactions")
+	fs.DurationVar(&c.Duration, "stop-after-duration", 0, "Maximum duration of the simulation, 0 for no limit")
+	fs.IntVar(&c.StopAfterBlocks, "stop-after-blocks", 0, "Number of blocks mined once transactions start to stop the simulation after, 0 for no limit")
+	fs.IntVar(&c.StopAfterTxs, "stop-after-txs", 0, "Number of transactions sent to stop the simulation after, 0 for no limit")
+	fs.Float64Var(&c.TxRate, "txrate", 0, "Maximum transactions per second to generate, 0 for no limit")
+	fs.StringVar(&c.LoadProfileName, "load", "",
+		"Load profile varying the transactions per second, e.g. linear:1:50:10m, step:5:50:2m, sine:25:20:10m or burst:5:100:1m:10s, -txrate is used if empty")
+	fs.StringVar(&c.BlockBurstSpec, "blockburst", "",
+		"Burst of transactions released after every block on top of the rate, as size:decay, e.g. 200:30s, all at once if the decay is 0s")
+	fs.StringVar(&c.SeasonalitySpec, "seasonality", "",
+		"Daily and weekly cycles of the rate, as day length:daily amplitude:weekend factor, e.g. 10m:0.5:0.7")
+	fs.DurationVar(&c.BlockInterval, "blockinterval", 0,
+		"Interval between blocks mined during the simulation, minimum for the curve schedule and mean for poisson")
+	fs.Float64Var(&c.TimeScale, "timescale", 1, "Factor by which simulated time runs faster than real time, e.g. 100 to mine 10m blocks every 6s")
+	fs.StringVar(&c.MiningSchedule, "miningschedule", scheduleCurve,
+		"When to mine blocks: curve, interval, poisson or ondemand")
+	fs.Var(fs.Lookup("stop-after-duration").Value, "duration", "Alias of -stop-after-duration")
+	return fs
+}
+
+// args returns the command line arguments of the flags whose values in c
+// differ from the defaults, except those in skip, so that a child process
+// parsing them runs with the same configuration
+func (c *Config) args(skip map[string]bool) []string {
+	// the flags are bound to a copy of c, set once they have been bound
+	// since binding resets the fields to their defaults
+	cur := new(Config)
+	fs := cur.flagSet()
+	*cur = *c
+	var args []string
+	fs.VisitAll(func(f *flag.Flag) {
+		// -duration is an alias of -stop-after-duration
+		if skip[f.Name] || f.Name == "duration" || f.Value.String() == f.DefValue {
+			return
+		}
+		args = append(args, fmt.Sprintf("-%s=%s", f.Name, f.Value))
+	})
+	return args
+}
+
+var (
+	// AppDataDir is the path to the working directory set using btcutil.AppDataDir
+	AppDataDir = btcutil.AppDataDir("btcsim", false)
+)
@@ -0,0 +1,102 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"sync/atomic"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcutil"
+)
+
+// lowFees reports whether the fee rates of the transactions mined in the
+// last block are no higher than usual, i.e. than over the last
+// maxEstimateBlocks blocks, or if no transaction was mined
+func (e *feeEstimator) lowFees() bool {
+	last, ok := e.estimate(1)
+	if !ok {
+		return true
+	}
+	usual, _ := e.estimate(maxEstimateBlocks)
+	return last <= usual
+}
+
+// consolidate runs as a goroutine and, whenever a block is mined while
+// fees are low, sweeps the utxos of the actor into one of its addresses
+// if it has at least threshold of them queued
+func (a *Actor) consolidate(threshold int, untracked *txSet, txSent chan<- *TxRecord) {
+	defer a.wg.Done()
+
+	for {
+		select {
+		case <-a.consolidateBlock:
+		case <-a.quit:
+			return
+		}
+		if a.Paused() || a.utxoQueue.len() < threshold || !a.fees.lowFees() {
+			continue
+		}
+
+		var utxos []*TxOut
+		var in btcutil.Amount
+	dequeue:
+		for len(utxos) < maxConsolidateInputs {
+			select {
+			case utxo, ok := <-a.utxoQueue.dequeue:
+				if !ok {
+					return
+				}
+				utxos = append(utxos, utxo)
+				in += utxo.Amount
+			default:
+				break dequeue
+			}
+		}
+		if len(utxos) < 2 {
+			a.requeue(utxos)
+			continue
+		}
+
+		inputs := make([]btcjson.TransactionInput, len(utxos))
+		for i, utxo := range utxos {
+			inputs[i] = btcjson.TransactionInput{
+				Txid: utxo.OutPoint.Hash.String(),
+				Vout: utxo.OutPoint.Index,
+			}
+		}
+		to := a.ownedAddresses[a.rand.Intn(len(a.ownedAddresses))]
+		amt := in - a.feeForSize(estimateTxSize(len(inputs), 1))
+		msgTx, err := a.createRawTransaction(inputs, map[btcutil.Address]btcutil.Amount{to: amt})
+		if err == nil {
+			err = sendUntracked(a, msgTx, untracked)
+		}
+		if err != nil {
+			log.Errorf("%s: Cannot consolidate %d utxos: %v", a, len(utxos), err)
+			a.requeue(utxos)
+			continue
+		}
+		log.Debugf("%s: Consolidated %d utxos worth %v", a, len(utxos), in)
+		atomic.AddUint64(&a.consolidated, uint64(len(utxos)))
+		atomic.AddUint64(&a.consolidations, 1)
+		a.recordTx(msgTx, in, txSent)
+	}
+}
+
+// requeue queues utxos which were dequeued but not spent again
+func (a *Actor) requeue(utxos []*TxOut) {
+	for _, utxo := range utxos {
+		select {
+		case a.utxoQueue.enqueue <- utxo:
+		case <-a.quit:
+			return
+		}
+	}
+}
+
+// Consolidations returns the number of consolidation transactions sent by
+// the actor and the number of utxos they swept
+func (a *Actor) Consolidations() (txs, utxos uint64) {
+	return atomic.LoadUint64(&a.consolidations), atomic.LoadUint64(&a.consolidated)
+}
@@ -0,0 +1,61 @@
+package btcsim
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/wire"
+)
+
+func TestLowFees(t *testing.T) {
+	e := &feeEstimator{}
+	if !e.lowFees() {
+		t.Errorf("lowFees got false want true without blocks")
+	}
+	e.add([]float64{10, 20, 30})
+	e.add([]float64{50})
+	if e.lowFees() {
+		t.Errorf("lowFees got true want false after an expensive block")
+	}
+	e.add([]float64{5})
+	if !e.lowFees() {
+		t.Errorf("lowFees got false want true after a cheap block")
+	}
+}
+
+func TestRequeue(t *testing.T) {
+	a := &Actor{
+		quit: make(chan struct{}),
+		utxoQueue: &utxoQueue{
+			enqueue: make(chan *TxOut),
+			dequeue: make(chan *TxOut),
+		},
+	}
+	a.wg.Add(1)
+	go a.queueUtxos()
+	defer close(a.quit)
+
+	utxos := []*TxOut{
+		{OutPoint: &wire.OutPoint{Index: 0}},
+		{OutPoint: &wire.OutPoint{Index: 1}},
+	}
+	a.requeue(utxos)
+	for i := range utxos {
+		if utxo := <-a.utxoQueue.dequeue; utxo != utxos[i] {
+			t.Errorf("dequeued %v want %v", utxo, utxos[i])
+		}
+	}
+}
+
+func TestReadProfilesConsolidate(t *testing.T) {
+	ps, err := readProfiles(strings.NewReader("sweeper,1,1,1,random,,,,30"))
+	if err != nil {
+		t.Fatalf("readProfiles error: %v", err)
+	}
+	if ps[0].Consolidate != 30 {
+		t.Errorf("readProfiles got consolidation threshold %d want 30", ps[0].Consolidate)
+	}
+	if _, err := readProfiles(strings.NewReader("sweeper,1,1,1,random,,,,1")); err == nil {
+		t.Errorf("readProfiles expected error")
+	}
+}
@@ -0,0 +1,329 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// controller drives a running simulation from the HTTP control API
+//
+// Every endpoint only accepts POST requests:
+//
+//	/pause?actor=name    pause an actor, or every actor if none is given
+//	/resume?actor=name   resume an actor, or every actor if none is given
+//	/txrate?rate=n       limit transactions to n per second, 0 for no limit
+//	/block               mine a block
+//	/actors/add          start a new actor, ?profile=name sets its profile
+//	/actors/remove?actor=name  shut an actor down
+//	/fault?kind=kind     inject a fault: kill the wallet of a random actor,
+//	                     partition the nodes in two halves or heal them
+//	/shutdown            stop the simulation
+type controller struct {
+	com   *Communication
+	miner *Miner
+
+	// rand picks the actors whose wallet is killed
+	rand *rand.Rand
+}
+
+// newController returns a controller of a simulation
+func newController(com *Communication, miner *Miner) *controller {
+	return &controller{
+		com:   com,
+		miner: miner,
+		rand:  com.cfg.newRand(faultStream),
+	}
+}
+
+// handler returns the HTTP handler of the control API
+func (c *controller) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/pause", c.post(c.pause))
+	mux.HandleFunc("/resume", c.post(c.resume))
+	mux.HandleFunc("/txrate", c.post(c.txRate))
+	mux.HandleFunc("/block", c.post(c.block))
+	mux.HandleFunc("/actors/add", c.post(c.addActor))
+	mux.HandleFunc("/actors/remove", c.post(c.removeActor))
+	mux.HandleFunc("/actors/scale", c.post(c.scaleActors))
+	mux.HandleFunc("/fault", c.post(c.fault))
+	mux.HandleFunc("/shutdown", c.post(c.shutdown))
+	return mux
+}
+
+// errBadRequest is wrapped by the errors caused by invalid requests
+type errBadRequest struct {
+	err error
+}
+
+func (e *errBadRequest) Error() string {
+	return e.err.Error()
+}
+
+// badRequest returns an error reported with a 400 status
+func badRequest(format string, args ...interface{}) error {
+	return &errBadRequest{fmt.Errorf(format, args...)}
+}
+
+// listenAddr returns the address the control API or the dashboard listens
+// on. Neither authenticates its clients, so an address without a host
+// binds to localhost rather than to every interface.
+func listenAddr(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || host != "" {
+		return addr
+	}
+	return net.JoinHostPort("localhost", port)
+}
+
+// sameOrigin reports whether a request was sent by a page served from the
+// host it is sent to, so that other sites opened in a browser cannot drive
+// the simulation. Requests without an Origin do not come from a page.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+// post returns a handler only accepting POST requests from the same
+// origin, which replies with the message returned by f or its error
+func (c *controller) post(f func(r *http.Request) (string, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !sameOrigin(r) {
+			http.Error(w, "cross-origin request", http.StatusForbidden)
+			return
+		}
+		msg, err := f(r)
+		if err != nil {
+			status := http.StatusInternalServerError
+			if _, ok := err.(*errBadRequest); ok {
+				status = http.StatusBadRequest
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		log.Infof("Control: %s", msg)
+		fmt.Fprintln(w, msg)
+	}
+}
+
+// actors returns the actor named by the actor parameter of the request,
+// or every actor if there is none
+func (c *controller) actors(r *http.Request) ([]*Actor, error) {
+	name := r.FormValue("actor")
+	actors := c.com.Actors()
+	if name == "" {
+		return actors, nil
+	}
+	for _, a := range actors {
+		if a.String() == name {
+			return []*Actor{a}, nil
+		}
+	}
+	return nil, badRequest("no actor named %q", name)
+}
+
+func (c *controller) pause(r *http.Request) (string, error) {
+	actors, err := c.actors(r)
+	if err != nil {
+		return "", err
+	}
+	for _, a := range actors {
+		a.Pause()
+	}
+	return fmt.Sprintf("paused %d actor(s)", len(actors)), nil
+}
+
+func (c *controller) resume(r *http.Request) (string, error) {
+	actors, err := c.actors(r)
+	if err != nil {
+		return "", err
+	}
+	for _, a := range actors {
+		a.Resume()
+	}
+	return fmt.Sprintf("resumed %d actor(s)", len(actors)), nil
+}
+
+func (c *controller) txRate(r *http.Request) (string, error) {
+	rate, err := strconv.ParseFloat(r.FormValue("rate"), 64)
+	if err != nil || rate < 0 {
+		return "", badRequest("invalid rate %q", r.FormValue("rate"))
+	}
+	c.com.throttle.setRate(c.com.cfg.realRate(rate))
+	if rate == 0 {
+		return "removed the tx rate limit", nil
+	}
+	return fmt.Sprintf("limited the tx rate to %v tx/s", rate), nil
+}
+
+func (c *controller) block(r *http.Request) (string, error) {
+	if c.miner.schedule == scheduleOnDemand {
+		c.miner.RequestBlock()
+		return "requested a block", nil
+	}
+	if err := c.miner.Generate(1); err != nil {
+		return "", err
+	}
+	return "mined a block", nil
+}
+
+func (c *controller) addActor(r *http.Request) (string, error) {
+	profile := defaultProfile
+	if name := r.FormValue("profile"); name != "" {
+		p, err := getProfile(name)
+		if err != nil {
+			return "", &errBadRequest{err}
+		}
+		profile = p
+	}
+	behavior := behaviorPassive
+	if name := r.FormValue("behavior"); name != "" {
+		if _, err := newBehavior(name); err != nil {
+			return "", &errBadRequest{err}
+		}
+		behavior = name
+	}
+	wallet := r.FormValue("wallet")
+	if wallet != "" {
+		if _, err := assignWallets(1, c.com.wallets, "0="+wallet); err != nil {
+			return "", &errBadRequest{err}
+		}
+	}
+
+	a, err := c.com.startActor(profile, behavior, wallet, c.miner.client)
+	if err != nil {
+		return "", err
+	}
+	c.com.addActor(a)
+	c.com.wg.Add(1)
+	go c.com.watchActor(a)
+	return fmt.Sprintf("added %s", a), nil
+}
+
+func (c *controller) removeActor(r *http.Request) (string, error) {
+	if r.FormValue("actor") == "" {
+		return "", badRequest("missing actor")
+	}
+	actors, err := c.actors(r)
+	if err != nil {
+		return "", err
+	}
+	a := actors[0]
+	if len(c.com.Actors()) == 1 {
+		return "", badRequest("cannot remove the last actor")
+	}
+	if !c.com.removeActor(a) {
+		return "", badRequest("no actor named %q", a)
+	}
+	a.Shutdown()
+	return fmt.Sprintf("removed %s", a), nil
+}
+
+func (c *controller) scaleActors(r *http.Request) (string, error) {
+	n, err := strconv.Atoi(r.FormValue("count"))
+	if err != nil || n < 1 {
+		return "", badRequest("invalid count %q", r.FormValue("count"))
+	}
+	delta, err := c.com.scaleActors(n, c.miner)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("scaled by %+d to %d actors", delta, len(c.com.Actors())), nil
+}
+
+// Kinds of faults injected with /fault
+const (
+	faultKill      = "kill"
+	faultPartition = "partition"
+	faultHeal      = "heal"
+)
+
+func (c *controller) fault(r *http.Request) (string, error) {
+	switch kind := r.FormValue("kind"); kind {
+	case faultKill:
+		var running []*Actor
+		for _, a := range c.com.Actors() {
+			if a.running() {
+				running = append(running, a)
+			}
+		}
+		if len(running) == 0 {
+			return "", badRequest("no actor runs a wallet process")
+		}
+		a := running[c.rand.Intn(len(running))]
+		if err := a.Kill(); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("killed the wallet of %s", a), nil
+	case faultPartition, faultHeal:
+		links := c.com.links
+		if len(links) == 0 {
+			return "", badRequest("nodes are not linked through proxies")
+		}
+		if kind == faultHeal {
+			heal(links)
+			return "healed the nodes", nil
+		}
+		var n int
+		for _, l := range links {
+			if l.from >= n {
+				n = l.from + 1
+			}
+			if l.to >= n {
+				n = l.to + 1
+			}
+		}
+		half := make([]int, n/2)
+		for i := range half {
+			half[i] = i
+		}
+		partition(links, [][]int{half})
+		return fmt.Sprintf("partitioned nodes %v from the others", half), nil
+	default:
+		return "", badRequest("invalid fault %q, valid faults are %s, %s and %s", kind,
+			faultKill, faultPartition, faultHeal)
+	}
+}
+
+func (c *controller) shutdown(r *http.Request) (string, error) {
+	c.com.Exit()
+	return "shutting down", nil
+}
+
+// serveControl serves the control API on the listener until the
+// simulation exits
+func (com *Communication) serveControl(l net.Listener, c *controller) {
+	defer com.wg.Done()
+
+	go func() {
+		<-com.exit
+		l.Close()
+	}()
+	log.Infof("Control API listening on %s", l.Addr())
+	err := http.Serve(l, c.handler())
+	select {
+	case <-com.exit:
+	default:
+		log.Infof("Control API stopped: %v", err)
+	}
+}
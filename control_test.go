@@ -0,0 +1,168 @@
+package btcsim
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// fakeActor returns an actor which only has a name
+func fakeActor(name string) *Actor {
+	n, _ := NewNodeFromArgs(DefaultConfig(), &fakeArgs{name: name}, nil, nil)
+	return &Actor{
+		Node: n,
+		quit: make(chan struct{}),
+	}
+}
+
+func TestControlPause(t *testing.T) {
+	com := NewCommunication(DefaultConfig())
+	a, b := fakeActor("a"), fakeActor("b")
+	com.actors = []*Actor{a, b}
+	c := newController(com, nil)
+
+	tests := []struct {
+		method, url string
+		status      int
+		a, b        bool
+	}{
+		{"GET", "/pause", http.StatusMethodNotAllowed, false, false},
+		{"POST", "/pause?actor=fake-a", http.StatusOK, true, false},
+		{"POST", "/pause?actor=fake-c", http.StatusBadRequest, true, false},
+		{"POST", "/pause", http.StatusOK, true, true},
+		{"POST", "/resume?actor=fake-b", http.StatusOK, true, false},
+		{"POST", "/resume", http.StatusOK, false, false},
+	}
+	for _, test := range tests {
+		r, err := http.NewRequest(test.method, test.url, nil)
+		if err != nil {
+			t.Fatalf("NewRequest error: %v", err)
+		}
+		w := httptest.NewRecorder()
+		c.handler().ServeHTTP(w, r)
+		if w.Code != test.status {
+			t.Errorf("%s %s: got status %d, want %d", test.method, test.url,
+				w.Code, test.status)
+		}
+		if a.Paused() != test.a || b.Paused() != test.b {
+			t.Errorf("%s %s: got paused %v %v, want %v %v", test.method,
+				test.url, a.Paused(), b.Paused(), test.a, test.b)
+		}
+	}
+}
+
+func TestControlTxRate(t *testing.T) {
+	com := NewCommunication(DefaultConfig())
+	c := newController(com, nil)
+
+	for url, status := range map[string]int{
+		"/txrate?rate=100": http.StatusOK,
+		"/txrate?rate=-1":  http.StatusBadRequest,
+		"/txrate?rate=x":   http.StatusBadRequest,
+		"/txrate?rate=0":   http.StatusOK,
+	} {
+		r, _ := http.NewRequest("POST", url, nil)
+		w := httptest.NewRecorder()
+		c.handler().ServeHTTP(w, r)
+		if w.Code != status {
+			t.Errorf("%s: got status %d, want %d", url, w.Code, status)
+		}
+	}
+}
+
+func TestControlFault(t *testing.T) {
+	com := NewCommunication(DefaultConfig())
+	c := newController(com, nil)
+
+	for _, url := range []string{
+		"/fault?kind=kill",
+		"/fault?kind=partition",
+		"/fault?kind=heal",
+		"/fault?kind=flood",
+	} {
+		r, _ := http.NewRequest("POST", url, nil)
+		w := httptest.NewRecorder()
+		c.handler().ServeHTTP(w, r)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: got status %d, want %d", url, w.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestControlOrigin(t *testing.T) {
+	com := NewCommunication(DefaultConfig())
+	com.actors = []*Actor{fakeActor("a")}
+	c := newController(com, nil)
+
+	for origin, status := range map[string]int{
+		"":                       http.StatusOK,
+		"http://localhost:18600": http.StatusOK,
+		"http://example.com":     http.StatusForbidden,
+		"null":                   http.StatusForbidden,
+	} {
+		r, _ := http.NewRequest("POST", "http://localhost:18600/pause", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		w := httptest.NewRecorder()
+		c.handler().ServeHTTP(w, r)
+		if w.Code != status {
+			t.Errorf("origin %q: got status %d, want %d", origin, w.Code, status)
+		}
+	}
+}
+
+func TestListenAddr(t *testing.T) {
+	for addr, want := range map[string]string{
+		":18600":          "localhost:18600",
+		"localhost:18600": "localhost:18600",
+		"0.0.0.0:18600":   "0.0.0.0:18600",
+		"[::1]:18600":     "[::1]:18600",
+		"18600":           "18600",
+	} {
+		if got := listenAddr(addr); got != want {
+			t.Errorf("listenAddr(%q) = %q, want %q", addr, got, want)
+		}
+	}
+}
+
+func TestControlRemoveLastActor(t *testing.T) {
+	com := NewCommunication(DefaultConfig())
+	com.actors = []*Actor{fakeActor("a")}
+	c := newController(com, nil)
+
+	r, _ := http.NewRequest("POST", "/actors/remove?actor=fake-a", nil)
+	w := httptest.NewRecorder()
+	c.handler().ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if len(com.Actors()) != 1 {
+		t.Errorf("last actor was removed")
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	exit := make(chan struct{})
+	th := newThrottle(0)
+	if !th.wait(exit) {
+		t.Errorf("disabled throttle blocked")
+	}
+
+	th.setRate(1e-3)
+	ticker := th.ticker
+	th.setRate(1e-3)
+	if th.ticker != ticker {
+		t.Errorf("setting the same rate restarted the ticker")
+	}
+	done := make(chan bool)
+	go func() {
+		done <- th.wait(exit)
+	}()
+	// raising the rate wakes up the waiter with the new ticker
+	th.setRate(1e6)
+	if !<-done {
+		t.Errorf("wait returned false")
+	}
+	th.stop()
+}
@@ -0,0 +1,87 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+// markSpent records that an output of the actor was spent before being
+// mined, so that it is not queued once it is
+func (a *Actor) markSpent(op wire.OutPoint) {
+	a.spentMtx.Lock()
+	a.spent[op] = true
+	a.spentMtx.Unlock()
+}
+
+// unmarkSpent forgets an output marked as spent, it reports whether it was
+func (a *Actor) unmarkSpent(op wire.OutPoint) bool {
+	a.spentMtx.Lock()
+	defer a.spentMtx.Unlock()
+	ok := a.spent[op]
+	delete(a.spent, op)
+	return ok
+}
+
+// payForParent spends the change of a parent transaction paying payee
+// back to the actor, with a fee such that the parent and the child
+// together pay the given fee rate, so that the child pays for its parent
+// which paid parentFee. The child is recorded with its parent.
+func (a *Actor) payForParent(parent *wire.MsgTx, payee btcutil.Address,
+	parentFee btcutil.Amount, rate float64, txSent chan<- *TxRecord) {
+
+	index, err := changeIndex(parent, []btcutil.Address{payee})
+	if err != nil {
+		log.Errorf("%s: Cannot find change output: %v", a, err)
+		return
+	}
+	if index < 0 {
+		// the parent has no change to spend
+		return
+	}
+	change := btcutil.Amount(parent.TxOut[index].Value)
+
+	childSize := estimateTxSize(1, 1)
+	fee := btcutil.Amount(rate*float64(parent.SerializeSize()+childSize)) - parentFee
+	if min := btcutil.Amount(rate * float64(childSize)); fee < min {
+		fee = min
+	}
+	if change-fee < minFee {
+		return
+	}
+
+	hash := parent.TxSha()
+	op := wire.NewOutPoint(&hash, uint32(index))
+	inputs := []btcjson.TransactionInput{{
+		Txid: hash.String(),
+		Vout: op.Index,
+	}}
+	to := a.ownedAddresses[a.rand.Int()%len(a.ownedAddresses)]
+	amounts := map[btcutil.Address]btcutil.Amount{to: change - fee}
+	msgTx, err := a.createRawTransaction(inputs, amounts)
+	if err != nil {
+		log.Errorf("%s: Cannot create child transaction: %v", a, err)
+		return
+	}
+
+	// the change must not be queued once the parent is mined, and the
+	// child is not a requested transaction
+	a.markSpent(*op)
+	a.untracked.add(msgTx.TxSha())
+	if _, err := a.client.SendRawTransaction(msgTx, false); err != nil {
+		a.unmarkSpent(*op)
+		a.untracked.take(msgTx.TxSha())
+		log.Debugf("%s: Child transaction rejected: %v", a, err)
+		return
+	}
+	r := a.newTxRecord(msgTx, change)
+	r.Parent = hash.String()
+	select {
+	case txSent <- r:
+	case <-a.quit:
+	}
+}
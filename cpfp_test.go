@@ -0,0 +1,94 @@
+package btcsim
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+func TestActorSpent(t *testing.T) {
+	a := &Actor{spent: make(map[wire.OutPoint]bool)}
+	op := wire.OutPoint{Hash: wire.ShaHash{1}, Index: 1}
+	a.markSpent(op)
+	if !a.unmarkSpent(op) {
+		t.Errorf("unmarkSpent got false want true for a spent output")
+	}
+	if a.unmarkSpent(op) {
+		t.Errorf("unmarkSpent got true want false for an unmarked output")
+	}
+}
+
+func TestPayForParent(t *testing.T) {
+	const rate = 50
+	childSize := estimateTxSize(1, 1)
+	tests := []struct {
+		name string
+		// parentFee is the fee the parent is said to pay, -1 for its
+		// actual fee
+		parentFee btcutil.Amount
+		// toSelf pays the actor itself, so that the parent has no change
+		toSelf bool
+		child  bool
+	}{
+		{name: "package rate", parentFee: -1, child: true},
+		{name: "minimum child rate", parentFee: 1e6, child: true},
+		{name: "no change", parentFee: -1, toSelf: true},
+	}
+	for _, test := range tests {
+		chain := NewMockChain(DefaultConfig())
+		a, b := chain.NewActor("a"), chain.NewActor("b")
+		mineMock(t, a, a, b)
+
+		txSent := make(chan *TxRecord, 2)
+		a.txSent = txSent
+
+		payee := b.Address()
+		if test.toSelf {
+			// the change goes to the same address as the payment
+			a.ownedAddresses = a.ownedAddresses[:1]
+			payee = a.Address()
+		}
+		parent, err := a.Pay(map[btcutil.Address]btcutil.Amount{payee: 1e8})
+		if err != nil {
+			t.Fatalf("%s: Pay error: %v", test.name, err)
+		}
+		parentFee := test.parentFee
+		if r := <-txSent; parentFee < 0 {
+			parentFee = btcutil.Amount(r.Fee)
+		}
+
+		a.payForParent(parent, payee, parentFee, rate, txSent)
+		mempool, _ := a.client.GetRawMempool()
+		if !test.child {
+			if len(mempool) != 1 || len(txSent) != 0 {
+				t.Errorf("%s: child sent for a parent without change", test.name)
+			}
+			StopMockActors(a, b)
+			continue
+		}
+		if len(mempool) != 2 || len(txSent) != 1 {
+			t.Fatalf("%s: %d transactions in the mempool, %d recorded, want the child",
+				test.name, len(mempool), len(txSent))
+		}
+		r := <-txSent
+		if r.Parent != parent.TxSha().String() {
+			t.Errorf("%s: child recorded with parent %q, want %v", test.name, r.Parent, parent.TxSha())
+		}
+		// the child pays for the parent so that both pay the rate, but
+		// never less than the rate for itself
+		want := btcutil.Amount(rate*float64(parent.SerializeSize()+childSize)) - parentFee
+		if min := btcutil.Amount(rate * float64(childSize)); want < min {
+			want = min
+		}
+		if btcutil.Amount(r.Fee) != want {
+			t.Errorf("%s: child fee %d, want %d", test.name, r.Fee, want)
+		}
+		// the change of the parent is not queued once it is mined
+		index, _ := changeIndex(parent, []btcutil.Address{payee})
+		if !a.unmarkSpent(wire.OutPoint{Hash: parent.TxSha(), Index: uint32(index)}) {
+			t.Errorf("%s: change of the parent not marked spent", test.name)
+		}
+		StopMockActors(a, b)
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// crashStage is a point of the send pipeline of an actor at which its
+// wallet process can be killed
+type crashStage int
+
+// Stages of the send pipeline, in order
+const (
+	// crashBeforeCreate kills the wallet before the raw transaction is
+	// created
+	crashBeforeCreate crashStage = iota
+
+	// crashBeforeSign kills the wallet once the transaction is created,
+	// before it is signed
+	crashBeforeSign
+
+	// crashBeforeSend kills the wallet once the transaction is signed,
+	// before it is sent
+	crashBeforeSend
+
+	// crashAfterSend kills the wallet right after the transaction is
+	// sent, before it has been notified of it
+	crashAfterSend
+
+	numCrashStages
+)
+
+// crashStageNames are the names of the crash stages
+var crashStageNames = []string{
+	crashBeforeCreate: "before-create",
+	crashBeforeSign:   "before-sign",
+	crashBeforeSend:   "before-send",
+	crashAfterSend:    "after-send",
+}
+
+// String returns the name of the stage
+func (s crashStage) String() string {
+	return crashStageNames[s]
+}
+
+// crashTest kills wallet processes at armed points of the send pipeline of
+// actors, to check that they neither lose nor double spend funds once
+// restarted
+type crashTest struct {
+	mtx    sync.Mutex
+	armed  map[*Actor]crashStage
+	killed [numCrashStages]int
+}
+
+// newCrashTest returns a crash test with no armed actor
+func newCrashTest() *crashTest {
+	return &crashTest{armed: make(map[*Actor]crashStage)}
+}
+
+// checkCrashTest returns an error if the crash test is enabled for actors
+// without a wallet process to kill
+func checkCrashTest(interval time.Duration, inProcess bool) error {
+	if interval > 0 && inProcess {
+		return errors.New("the crash test kills wallet processes, in-process wallets have none")
+	}
+	return nil
+}
+
+// restartWallets reports whether wallet processes are killed during the
+// run configured by c and must be restarted
+func (c *Config) restartWallets() bool {
+	return c.ChaosInterval > 0 || c.CrashInterval > 0
+}
+
+// arm makes the next transaction of the actor kill its wallet at the
+// given stage
+func (c *crashTest) arm(a *Actor, stage crashStage) {
+	c.mtx.Lock()
+	c.armed[a] = stage
+	c.mtx.Unlock()
+}
+
+// point kills the wallet of the actor if it is armed for the stage, it
+// does nothing on a nil crash test
+func (c *crashTest) point(a *Actor, stage crashStage) {
+	if c == nil {
+		return
+	}
+	c.mtx.Lock()
+	armed, ok := c.armed[a]
+	if !ok || armed != stage {
+		c.mtx.Unlock()
+		return
+	}
+	delete(c.armed, a)
+	c.killed[stage]++
+	c.mtx.Unlock()
+
+	log.Infof("%s: Killing wallet process %s", a, stage)
+	if err := a.Kill(); err != nil {
+		log.Errorf("%s: Cannot kill wallet process: %v", a, err)
+	}
+}
+
+// Killed returns the number of wallets killed at every stage by name
+func (c *crashTest) Killed() map[string]int {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	killed := make(map[string]int)
+	for stage, n := range c.killed {
+		if n > 0 {
+			killed[crashStage(stage).String()] = n
+		}
+	}
+	return killed
+}
+
+// crash runs as a goroutine and arms a random actor to kill its wallet at
+// a random stage of its next transaction, on average every interval, until
+// the simulation exits
+func (com *Communication) crash(interval time.Duration) {
+	defer com.wg.Done()
+
+	r := com.cfg.newRand(crashStream)
+	for {
+		select {
+		case <-time.After(time.Duration(r.ExpFloat64() * float64(interval))):
+		case <-com.exit:
+			return
+		}
+
+		var running []*Actor
+		for _, a := range com.Actors() {
+			if a.running() {
+				running = append(running, a)
+			}
+		}
+		if len(running) == 0 {
+			continue
+		}
+		a := running[r.Intn(len(running))]
+		stage := crashStage(r.Intn(int(numCrashStages)))
+		log.Debugf("%s: Arming wallet crash %s", a, stage)
+		com.crashTest.arm(a, stage)
+	}
+}
@@ -0,0 +1,41 @@
+package btcsim
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCrashTestPoint(t *testing.T) {
+	c := newCrashTest()
+	a, b := fakeActor("a"), fakeActor("b")
+	c.arm(a, crashBeforeSend)
+
+	// only the armed actor is killed, once, at the armed stage
+	c.point(b, crashBeforeSend)
+	c.point(a, crashBeforeSign)
+	if killed := c.Killed(); len(killed) != 0 {
+		t.Fatalf("killed %v before the armed stage", killed)
+	}
+	c.point(a, crashBeforeSend)
+	c.point(a, crashBeforeSend)
+	killed := c.Killed()
+	if len(killed) != 1 || killed["before-send"] != 1 {
+		t.Errorf("Killed got %v want map[before-send:1]", killed)
+	}
+
+	// a nil crash test never kills
+	var nilTest *crashTest
+	nilTest.point(a, crashBeforeCreate)
+}
+
+func TestCheckCrashTest(t *testing.T) {
+	if err := checkCrashTest(time.Minute, false); err != nil {
+		t.Errorf("checkCrashTest error: %v", err)
+	}
+	if err := checkCrashTest(0, true); err != nil {
+		t.Errorf("checkCrashTest error: %v", err)
+	}
+	if err := checkCrashTest(time.Minute, true); err == nil {
+		t.Errorf("checkCrashTest expected error with in-process wallets")
+	}
+}
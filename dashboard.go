@@ -0,0 +1,354 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/websocket"
+)
+
+const (
+	// dashboardInterval is the interval at which the dashboard pushes
+	// the state of the simulation to its clients
+	dashboardInterval = time.Second
+
+	// dashboardEvents is the number of last events kept for the event
+	// feed of the dashboard
+	dashboardEvents = 200
+)
+
+// feedEvent is an event of the feed of the dashboard, numbered so that
+// clients only receive the events they have not seen
+type feedEvent struct {
+	Seq  uint64    `json:"seq"`
+	Time time.Time `json:"time"`
+	Kind string    `json:"kind"`
+	Text string    `json:"text"`
+}
+
+// eventFeed keeps the last dashboardEvents events of the simulation
+type eventFeed struct {
+	mtx    sync.Mutex
+	seq    uint64
+	events []feedEvent
+}
+
+// add adds an event to the feed, dropping the oldest one if it is full
+func (f *eventFeed) add(e *Event) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	f.seq++
+	f.events = append(f.events, feedEvent{
+		Seq:  f.seq,
+		Time: e.Time,
+		Kind: e.Kind.String(),
+		Text: e.String(),
+	})
+	if len(f.events) > dashboardEvents {
+		f.events = f.events[len(f.events)-dashboardEvents:]
+	}
+}
+
+// since returns the events of the feed after the given sequence number
+// and the number of the last one
+func (f *eventFeed) since(seq uint64) ([]feedEvent, uint64) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	var events []feedEvent
+	for _, e := range f.events {
+		if e.Seq > seq {
+			events = append(events, e)
+		}
+	}
+	return events, f.seq
+}
+
+// actorStatus is the status of an actor shown by the dashboard
+type actorStatus struct {
+	Name     string `json:"name"`
+	Profile  string `json:"profile"`
+	Behavior string `json:"behavior"`
+	Running  bool   `json:"running"`
+	Paused   bool   `json:"paused"`
+	Utxos    int    `json:"utxos"`
+}
+
+// nodeStatus is the status of a node shown by the dashboard, Error is set
+// if it could not be queried
+type nodeStatus struct {
+	Name    string `json:"name"`
+	Height  int64  `json:"height"`
+	Mempool int    `json:"mempool"`
+	Error   string `json:"error,omitempty"`
+}
+
+// dashboardState is the state of the simulation pushed to the clients of
+// the dashboard, with the events since the previous push
+type dashboardState struct {
+	Time   time.Time     `json:"time"`
+	Txs    uint64        `json:"txs"`
+	Actors []actorStatus `json:"actors"`
+	Nodes  []nodeStatus  `json:"nodes"`
+	Events []feedEvent   `json:"events"`
+}
+
+// dashboard is the live web UI of a running simulation: it serves a page
+// receiving the state of the simulation over a websocket every
+// dashboardInterval, whose buttons drive the simulation through the
+// control API served alongside
+type dashboard struct {
+	com     *Communication
+	nodes   []*Node
+	feed    *eventFeed
+	control http.Handler
+}
+
+// newDashboard returns a dashboard of the simulation, which follows its
+// events from now on
+func newDashboard(com *Communication, nodes []*Node, c *controller) *dashboard {
+	d := &dashboard{
+		com:     com,
+		nodes:   nodes,
+		feed:    &eventFeed{},
+		control: c.handler(),
+	}
+	com.events.subscribe(d.feed.add, eventKinds...)
+	return d
+}
+
+// actorStatuses returns the status of the actors of the simulation
+func actorStatuses(actors []*Actor) []actorStatus {
+	statuses := make([]actorStatus, len(actors))
+	for i, a := range actors {
+		statuses[i] = actorStatus{
+			Name:     a.String(),
+			Behavior: a.behaviorName,
+			Running:  a.running(),
+			Paused:   a.Paused(),
+		}
+		if a.profile != nil {
+			statuses[i].Profile = a.profile.Name
+		}
+		if a.utxoQueue != nil {
+			statuses[i].Utxos = a.utxoQueue.len()
+		}
+	}
+	return statuses
+}
+
+// nodeStatuses queries the height and mempool size of the nodes
+func nodeStatuses(nodes []*Node) []nodeStatus {
+	statuses := make([]nodeStatus, len(nodes))
+	for i, n := range nodes {
+		statuses[i].Name = n.String()
+		if height, err := n.client.GetBlockCount(); err != nil {
+			statuses[i].Error = err.Error()
+		} else if txs, err := n.client.GetRawMempool(); err != nil {
+			statuses[i].Height = height
+			statuses[i].Error = err.Error()
+		} else {
+			statuses[i].Height = height
+			statuses[i].Mempool = len(txs)
+		}
+	}
+	return statuses
+}
+
+// state returns the state of the simulation with the events after the
+// given sequence number, and the number of the last event
+func (d *dashboard) state(seq uint64) (*dashboardState, uint64) {
+	s := &dashboardState{
+		Time:   time.Now(),
+		Txs:    d.com.txStats.Count(),
+		Actors: actorStatuses(d.com.Actors()),
+		Nodes:  nodeStatuses(d.nodes),
+	}
+	s.Events, seq = d.feed.since(seq)
+	return s, seq
+}
+
+// handler returns the HTTP handler of the dashboard, serving the page,
+// the websocket, the state as JSON and the control API
+func (d *dashboard) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", d.page)
+	mux.HandleFunc("/ws", d.websocket)
+	mux.HandleFunc("/state", d.stateJSON)
+	return mux
+}
+
+// page serves the page of the dashboard, other paths are handed to the
+// control API
+func (d *dashboard) page(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		d.control.ServeHTTP(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(dashboardPage))
+}
+
+// stateJSON serves the state of the simulation as JSON, with the events
+// after the sequence number given by the since parameter
+func (d *dashboard) stateJSON(w http.ResponseWriter, r *http.Request) {
+	seq, _ := strconv.ParseUint(r.FormValue("since"), 10, 64)
+	s, _ := d.state(seq)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s); err != nil {
+		log.Debugf("Dashboard: Cannot send state: %v", err)
+	}
+}
+
+// websocket pushes the state of the simulation to a client every
+// dashboardInterval until it disconnects or the simulation exits
+func (d *dashboard) websocket(w http.ResponseWriter, r *http.Request) {
+	if !sameOrigin(r) {
+		http.Error(w, "cross-origin request", http.StatusForbidden)
+		return
+	}
+	conn, err := websocket.Upgrade(w, r, nil, 0, 0)
+	if err != nil {
+		if _, ok := err.(websocket.HandshakeError); ok {
+			http.Error(w, "not a websocket handshake", http.StatusBadRequest)
+		}
+		return
+	}
+	defer conn.Close()
+
+	// the client sends nothing, reading only notices it disconnected
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(dashboardInterval)
+	defer ticker.Stop()
+	var seq uint64
+	for {
+		var s *dashboardState
+		s, seq = d.state(seq)
+		if err := conn.WriteJSON(s); err != nil {
+			log.Debugf("Dashboard: Cannot send state: %v", err)
+			return
+		}
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
+		case <-d.com.exit:
+			return
+		}
+	}
+}
+
+// serveDashboard serves the dashboard on the listener until the
+// simulation exits
+func (com *Communication) serveDashboard(l net.Listener, d *dashboard) {
+	defer com.wg.Done()
+
+	go func() {
+		<-com.exit
+		l.Close()
+	}()
+	log.Infof("Dashboard listening on http://%s/", l.Addr())
+	err := http.Serve(l, d.handler())
+	select {
+	case <-com.exit:
+	default:
+		log.Infof("Dashboard stopped: %v", err)
+	}
+}
+
+// dashboardPage is the page of the dashboard, which renders the states
+// received over the websocket and posts the actions of its buttons to the
+// control API
+const dashboardPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>btcsim dashboard</title>
+<style>
+body { font-family: sans-serif; margin: 1em 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+td, th { padding: 2px 10px; border-bottom: 1px solid #ddd; text-align: left; }
+.down { color: #b00; }
+.paused { color: #a60; }
+#feed { height: 20em; overflow-y: scroll; background: #f4f4f4; font: 12px monospace; padding: 0.5em; }
+button { margin-right: 0.5em; }
+</style>
+</head>
+<body>
+<h1>btcsim dashboard</h1>
+<p>
+<button onclick="post('/block')">Mine a block</button>
+<button onclick="post('/pause')">Pause traffic</button>
+<button onclick="post('/resume')">Resume traffic</button>
+<button onclick="post('/fault?kind=kill')">Kill a wallet</button>
+<button onclick="post('/fault?kind=partition')">Partition nodes</button>
+<button onclick="post('/fault?kind=heal')">Heal nodes</button>
+<span id="result"></span>
+</p>
+<p id="status">Connecting...</p>
+<h2>Nodes</h2>
+<table id="nodes"></table>
+<h2>Actors</h2>
+<table id="actors"></table>
+<h2>Events</h2>
+<div id="feed"></div>
+<script>
+function esc(s) {
+	var d = document.createElement('div');
+	d.textContent = String(s);
+	return d.innerHTML;
+}
+function post(path) {
+	fetch(path, {method: 'POST'}).then(function(r) { return r.text(); }).then(function(t) {
+		document.getElementById('result').textContent = t;
+	});
+}
+function render(s) {
+	document.getElementById('status').textContent = s.txs + ' transactions sent at ' + new Date(s.time).toLocaleTimeString();
+	var rows = '<tr><th>Node</th><th>Height</th><th>Mempool</th></tr>';
+	(s.nodes || []).forEach(function(n) {
+		rows += '<tr' + (n.error ? ' class="down"' : '') + '><td>' + esc(n.name) + '</td><td>' + n.height +
+			'</td><td>' + (n.error ? esc(n.error) : n.mempool) + '</td></tr>';
+	});
+	document.getElementById('nodes').innerHTML = rows;
+	rows = '<tr><th>Actor</th><th>Profile</th><th>Behavior</th><th>Utxos</th><th>State</th></tr>';
+	(s.actors || []).forEach(function(a) {
+		var state = !a.running ? 'down' : a.paused ? 'paused' : 'running';
+		rows += '<tr class="' + state + '"><td>' + esc(a.name) + '</td><td>' + esc(a.profile) + '</td><td>' +
+			esc(a.behavior) + '</td><td>' + a.utxos + '</td><td>' + state + '</td></tr>';
+	});
+	document.getElementById('actors').innerHTML = rows;
+	var feed = document.getElementById('feed');
+	(s.events || []).forEach(function(e) {
+		var line = document.createElement('div');
+		line.textContent = new Date(e.time).toLocaleTimeString() + ' ' + e.text;
+		feed.appendChild(line);
+	});
+	while (feed.childNodes.length > 200) {
+		feed.removeChild(feed.firstChild);
+	}
+	feed.scrollTop = feed.scrollHeight;
+}
+var ws = new WebSocket((location.protocol === 'https:' ? 'wss://' : 'ws://') + location.host + '/ws');
+ws.onmessage = function(m) { render(JSON.parse(m.data)); };
+ws.onclose = function() { document.getElementById('status').textContent = 'Simulation ended'; };
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,115 @@
+package btcsim
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/wire"
+)
+
+func TestEventFeed(t *testing.T) {
+	f := &eventFeed{}
+	for i := 0; i < dashboardEvents+10; i++ {
+		f.add(&Event{Kind: eventActorStarted, Time: time.Now(), Actor: fakeActor("a")})
+	}
+	events, seq := f.since(0)
+	if len(events) != dashboardEvents {
+		t.Fatalf("got %d events, want %d", len(events), dashboardEvents)
+	}
+	if seq != dashboardEvents+10 || events[len(events)-1].Seq != seq {
+		t.Errorf("got last event %d of %d, want %d", events[len(events)-1].Seq,
+			seq, dashboardEvents+10)
+	}
+	if events[0].Text != "actor-started fake-a" {
+		t.Errorf("got event %q", events[0].Text)
+	}
+	if events, _ := f.since(seq - 3); len(events) != 3 {
+		t.Errorf("got %d new events, want 3", len(events))
+	}
+}
+
+func TestDashboardHandler(t *testing.T) {
+	com := NewCommunication(DefaultConfig())
+	a, b := fakeActor("a"), fakeActor("b")
+	com.actors = []*Actor{a, b}
+	d := newDashboard(com, nil, newController(com, nil))
+	a.Pause()
+	close(b.quit)
+	com.events.publish(&Event{Kind: eventActorStarted, Time: time.Now(), Actor: a})
+
+	r, _ := http.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	d.handler().ServeHTTP(w, r)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "/ws") {
+		t.Errorf("page: got status %d", w.Code)
+	}
+
+	r, _ = http.NewRequest("GET", "/state", nil)
+	w = httptest.NewRecorder()
+	d.handler().ServeHTTP(w, r)
+	var s dashboardState
+	if err := json.Unmarshal(w.Body.Bytes(), &s); err != nil {
+		t.Fatalf("state: %v", err)
+	}
+	if len(s.Actors) != 2 || !s.Actors[0].Paused || !s.Actors[0].Running || s.Actors[1].Running {
+		t.Errorf("state: got actors %+v", s.Actors)
+	}
+	if len(s.Events) != 1 {
+		t.Errorf("state: got %d events, want 1", len(s.Events))
+	}
+
+	// the control API is served alongside the page
+	r, _ = http.NewRequest("GET", "/resume", nil)
+	w = httptest.NewRecorder()
+	d.handler().ServeHTTP(w, r)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("resume: got status %d, want %d", w.Code, http.StatusMethodNotAllowed)
+	}
+
+	// pages of other sites cannot open the websocket
+	r, _ = http.NewRequest("GET", "http://localhost:18601/ws", nil)
+	r.Header.Set("Origin", "http://example.com")
+	w = httptest.NewRecorder()
+	d.handler().ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("websocket: got status %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
+
+// TestActorStatusesUtxos checks that the utxos of the actors are counted
+// while their queues change
+func TestActorStatusesUtxos(t *testing.T) {
+	a := fakeActor("a")
+	a.utxoQueue = &utxoQueue{
+		enqueue: make(chan *TxOut),
+		dequeue: make(chan *TxOut),
+	}
+	a.wg.Add(1)
+	go a.queueUtxos()
+
+	const utxos = 100
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < utxos; i++ {
+			a.utxoQueue.enqueue <- &TxOut{OutPoint: &wire.OutPoint{Index: uint32(i)}}
+		}
+	}()
+	for {
+		actorStatuses([]*Actor{a})
+		select {
+		case <-done:
+			if s := actorStatuses([]*Actor{a}); s[0].Utxos != utxos {
+				t.Errorf("got %d utxos, want %d", s[0].Utxos, utxos)
+			}
+			close(a.quit)
+			a.wg.Wait()
+			return
+		default:
+		}
+	}
+}
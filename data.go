@@ -0,0 +1,57 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"fmt"
+	"math/rand"
+
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// checkDataCarrier returns an error if the fraction of transactions
+// embedding data or the size of the data are out of range
+func checkDataCarrier(fraction float64, size int) error {
+	if fraction < 0 || fraction > 1 {
+		return fmt.Errorf("data carrier fraction must be in [0, 1]")
+	}
+	if size < 1 || size > txscript.MaxDataCarrierSize {
+		return fmt.Errorf("data carrier size must be in [1, %d]", txscript.MaxDataCarrierSize)
+	}
+	return nil
+}
+
+// dataOutput returns an OP_RETURN output embedding size random bytes
+func dataOutput(r *rand.Rand, size int) (*wire.TxOut, error) {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(r.Intn(256))
+	}
+	script, err := txscript.NullDataScript(data)
+	if err != nil {
+		return nil, err
+	}
+	return wire.NewTxOut(0, script), nil
+}
+
+// dataOutputSize returns the approximate size in bytes of an OP_RETURN
+// output embedding size bytes: the value, the script length, OP_RETURN
+// and the push of the data
+func dataOutputSize(size int) int {
+	return 8 + 1 + 1 + 2 + size
+}
+
+// dataSize returns the size of the scripts of the OP_RETURN outputs of a
+// transaction
+func dataSize(msgTx *wire.MsgTx) int {
+	var n int
+	for _, txOut := range msgTx.TxOut {
+		if txscript.GetScriptClass(txOut.PkScript) == txscript.NullDataTy {
+			n += len(txOut.PkScript)
+		}
+	}
+	return n
+}
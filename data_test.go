@@ -0,0 +1,51 @@
+package btcsim
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheckDataCarrier(t *testing.T) {
+	if err := checkDataCarrier(0.5, 80); err != nil {
+		t.Errorf("checkDataCarrier error: %v", err)
+	}
+	invalid := []struct {
+		fraction float64
+		size     int
+	}{
+		{-0.1, 40},
+		{1.1, 40},
+		{0.5, 0},
+		{0.5, 81},
+	}
+	for _, test := range invalid {
+		if err := checkDataCarrier(test.fraction, test.size); err == nil {
+			t.Errorf("checkDataCarrier(%v, %d) expected error", test.fraction, test.size)
+		}
+	}
+}
+
+func TestNewSummaryData(t *testing.T) {
+	stats := NewTxStats(DefaultConfig())
+	stats.records = []*TxRecord{
+		{TxID: "a", DataSize: 42},
+		{TxID: "b"},
+		{TxID: "c", DataSize: 82},
+	}
+	s := NewSummary(DefaultConfig(), stats, time.Minute)
+	if s.DataTxs != 2 || s.DataBytes != 124 {
+		t.Errorf("data got %d, %d want 2, 124", s.DataTxs, s.DataBytes)
+	}
+}
+
+func TestGetActorNullData(t *testing.T) {
+	out, err := dataOutput(DefaultConfig().newRand(0), 40)
+	if err != nil {
+		t.Fatalf("dataOutput error: %v", err)
+	}
+	com := NewCommunication(DefaultConfig())
+	a, b := fakeActor("a"), fakeActor("b")
+	if _, err := com.getActor([]*Actor{a, b}, out); err != errNoAddress {
+		t.Errorf("getActor of an OP_RETURN output error %v want %v", err, errNoAddress)
+	}
+}
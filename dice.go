@@ -0,0 +1,205 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/btcsuite/btcutil"
+)
+
+const (
+	// behaviorDice is the name of the dice site behavior and
+	// behaviorGambler the name of its players
+	behaviorDice    = "dice"
+	behaviorGambler = "gambler"
+
+	// diceMeanBet is the mean amount bet by a gambler on every tick
+	diceMeanBet btcutil.Amount = 1e5 // 0.001 BTC
+
+	// diceWinProb is the probability that a bet wins twice its amount,
+	// the rest being the edge of the house
+	diceWinProb = 0.49
+)
+
+// bet is a bet placed with a dice site, paid out to addr if it wins
+type bet struct {
+	player string
+	addr   btcutil.Address
+	amount btcutil.Amount
+}
+
+// dice is a dice site: the gamblers send it their bets and it rolls them
+// on every tick, paying every winning bet back in its own transaction.
+// Together they reproduce the rapid back-and-forth of micro transactions
+// between a house and its players.
+type dice struct {
+	NopBehavior
+
+	// bets are the bets not rolled yet and owed the winning bets the
+	// house could not pay yet
+	mtx     sync.Mutex
+	bets    []bet
+	owed    []bet
+	players map[string]struct{}
+	stats   DiceStats
+}
+
+// newDice returns the dice site behavior
+func newDice() *dice {
+	return &dice{players: make(map[string]struct{})}
+}
+
+// place records a bet sent to the house
+func (d *dice) place(b bet) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	d.bets = append(d.bets, b)
+	d.players[b.player] = struct{}{}
+	d.stats.Bets++
+	d.stats.Wagered += int64(b.amount)
+}
+
+// roll rolls the bets placed since the last call and returns the winning
+// ones along with those still owed
+func (d *dice) roll(a *Actor) []bet {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	won := d.owed
+	for _, b := range d.bets {
+		if a.rand.Float64() < diceWinProb {
+			d.stats.Wins++
+			won = append(won, b)
+		}
+	}
+	d.bets, d.owed = nil, nil
+	return won
+}
+
+// OnTick rolls the bets placed since the last tick and pays the winnings,
+// those the house cannot pay yet stay owed until the next tick
+func (d *dice) OnTick(a *Actor) {
+	won := d.roll(a)
+	for i, b := range won {
+		amt := 2 * b.amount
+		_, err := a.Pay(map[btcutil.Address]btcutil.Amount{b.addr: amt})
+		switch err {
+		case nil:
+			d.mtx.Lock()
+			d.stats.Payouts++
+			d.stats.PaidOut += int64(amt)
+			d.mtx.Unlock()
+			continue
+		case ErrInsufficientFunds:
+			log.Debugf("%s: Cannot pay winnings of %s: %v", a, b.player, err)
+		case ErrActorShutdown:
+			return
+		default:
+			log.Errorf("%s: Cannot pay winnings of %s: %v", a, b.player, err)
+		}
+		d.mtx.Lock()
+		d.owed = append(d.owed, won[i:]...)
+		d.mtx.Unlock()
+		return
+	}
+}
+
+// Stats returns the bets and payouts of the dice site
+func (d *dice) Stats() DiceStats {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	s := d.stats
+	s.Players = len(d.players)
+	for _, b := range d.owed {
+		s.Owed += int64(2 * b.amount)
+	}
+	return s
+}
+
+// gambler bets at a random dice site on every tick
+type gambler struct {
+	NopBehavior
+}
+
+// OnTick sends a bet exponentially distributed around diceMeanBet to a
+// dice site and lets the house know where to pay the winnings
+func (gambler) OnTick(a *Actor) {
+	house, d := a.diceSite()
+	if d == nil {
+		return
+	}
+	amt := btcutil.Amount(float64(diceMeanBet) * a.rand.ExpFloat64())
+	if amt < minFee {
+		amt = minFee
+	}
+	_, err := a.Pay(map[btcutil.Address]btcutil.Amount{house.Address(): amt})
+	switch err {
+	case nil:
+		d.place(bet{player: a.String(), addr: a.Address(), amount: amt})
+	case ErrInsufficientFunds:
+		log.Debugf("%s: Cannot bet: %v", a, err)
+	case ErrActorShutdown:
+	default:
+		log.Errorf("%s: Cannot bet: %v", a, err)
+	}
+}
+
+// diceSite returns a running dice site picked at random among the other
+// actors and its behavior, nil if there is none
+func (a *Actor) diceSite() (*Actor, *dice) {
+	if a.peers == nil {
+		return nil, nil
+	}
+	var houses []*Actor
+	for _, o := range a.peers() {
+		if _, ok := o.behavior.(*dice); ok && o != a {
+			houses = append(houses, o)
+		}
+	}
+	if len(houses) == 0 {
+		return nil, nil
+	}
+	house := houses[a.rand.Intn(len(houses))]
+	return house, house.behavior.(*dice)
+}
+
+// DiceStats are the bets received by a dice site and the winnings it paid
+type DiceStats struct {
+	Name    string `json:"name"`
+	Players int    `json:"players"`
+
+	// Bets is the number of bets placed and Wagered their amount, Wins
+	// the number of winning bets, Payouts the number of those paid,
+	// PaidOut their winnings and Owed the winnings not paid yet
+	Bets    int   `json:"bets"`
+	Wagered int64 `json:"wagered"`
+	Wins    int   `json:"wins"`
+	Payouts int   `json:"payouts"`
+	PaidOut int64 `json:"paidout"`
+	Owed    int64 `json:"owed"`
+}
+
+// String returns the stats in a line of the summary
+func (s *DiceStats) String() string {
+	return fmt.Sprintf("%d players, %d bets (%v), %d wins, %d payouts (%v), %v owed, house profit %v",
+		s.Players, s.Bets, btcutil.Amount(s.Wagered), s.Wins, s.Payouts,
+		btcutil.Amount(s.PaidOut), btcutil.Amount(s.Owed), btcutil.Amount(s.Wagered-s.PaidOut))
+}
+
+// diceStats returns the stats of the dice sites among the actors
+func diceStats(actors []*Actor) []*DiceStats {
+	var stats []*DiceStats
+	for _, a := range actors {
+		d, ok := a.behavior.(*dice)
+		if !ok {
+			continue
+		}
+		s := d.Stats()
+		s.Name = a.String()
+		stats = append(stats, &s)
+	}
+	return stats
+}
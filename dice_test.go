@@ -0,0 +1,51 @@
+package btcsim
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDiceRoll(t *testing.T) {
+	house := fakeActor("house")
+	house.rand = DefaultConfig().newRand(0)
+
+	d := newDice()
+	for i := 0; i < 1000; i++ {
+		d.place(bet{player: []string{"a", "b"}[i%2], addr: fakeAddress("a"), amount: 1e5})
+	}
+	won := d.roll(house)
+	if len(won) < 400 || len(won) > 580 {
+		t.Errorf("%d bets out of 1000 won", len(won))
+	}
+	if len(d.bets) != 0 {
+		t.Errorf("%d bets left after rolling", len(d.bets))
+	}
+
+	// winnings owed are paid rather than rolled again
+	d.owed = won[:1]
+	d.place(bet{player: "c", addr: fakeAddress("c"), amount: 1e5})
+	s := d.Stats()
+	if s.Players != 3 || s.Bets != 1001 || s.Owed != 2e5 {
+		t.Errorf("got stats %+v", s)
+	}
+	if again := d.roll(house); len(again) == 0 || again[0] != won[0] {
+		t.Errorf("owed winnings were not returned first: %v", again)
+	}
+	if !strings.Contains(s.String(), "3 players, 1001 bets") {
+		t.Errorf("got %q", s.String())
+	}
+}
+
+func TestDiceSite(t *testing.T) {
+	house, player := fakeActor("house"), fakeActor("player")
+	player.rand = DefaultConfig().newRand(0)
+	if _, d := player.diceSite(); d != nil {
+		t.Errorf("found a dice site without peers")
+	}
+	house.behavior = newDice()
+	player.behavior = gambler{}
+	player.peers = func() []*Actor { return []*Actor{house, player} }
+	if a, d := player.diceSite(); a != house || d != house.behavior {
+		t.Errorf("got dice site %v", a)
+	}
+}
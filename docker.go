@@ -0,0 +1,322 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// dockerDefaultNetwork is the network containers join unless configured
+// otherwise
+const dockerDefaultNetwork = "sim"
+
+// dockerSettings are the settings of the containers accepted by
+// -dockerconfig, globally or per container
+var dockerSettings = map[string]bool{
+	"image":   true,
+	"cpus":    true,
+	"memory":  true,
+	"network": true,
+}
+
+// dockerProvisioner launches nodes and wallets in docker containers. Each
+// container joins a docker network of the run, where the other containers
+// reach it by its name, e.g. node-1 or actor-18557, and publishes its
+// ports on the loopback interface so that the simulator reaches it as it
+// would a local process. Files are shared by mounting the app data dir at
+// the same path.
+type dockerProvisioner struct {
+	// images are the images running each executable, e.g. btcd
+	images map[string]string
+
+	// settings are the container settings by key, global ones under
+	// the empty name and per container ones under its name
+	settings map[string]map[string]string
+
+	// run names the containers and networks of the run
+	run string
+
+	// networks are the networks created for the run
+	networks []string
+
+	// addrs are the addresses in their networks of the containers
+	// listening on host addresses
+	mtx   sync.Mutex
+	addrs map[string]string
+}
+
+// newDockerProvisioner returns a provisioner using the images of the
+// executables given as e.g. "btcd=btcsuite/btcd,btcwallet=btcsuite/btcwallet"
+// and the container settings, e.g. "cpus=1,memory=512m,node-1.cpus=2", for
+// the run in runDir
+func newDockerProvisioner(runDir, images, config string) (*dockerProvisioner, error) {
+	p := &dockerProvisioner{
+		images:   make(map[string]string),
+		settings: map[string]map[string]string{"": {"network": dockerDefaultNetwork}},
+		run:      "btcsim-" + filepath.Base(runDir),
+		addrs:    make(map[string]string),
+	}
+	pairs, err := parseAssignments(images)
+	if err != nil {
+		return nil, err
+	}
+	for _, kv := range pairs {
+		p.images[kv[0]] = kv[1]
+	}
+	if pairs, err = parseAssignments(config); err != nil {
+		return nil, err
+	}
+	for _, kv := range pairs {
+		name, key := "", kv[0]
+		if i := strings.LastIndex(key, "."); i >= 0 {
+			name, key = key[:i], key[i+1:]
+		}
+		if !dockerSettings[key] {
+			return nil, fmt.Errorf("unknown docker setting %q, valid settings are: "+
+				"image, cpus, memory and network", key)
+		}
+		if p.settings[name] == nil {
+			p.settings[name] = make(map[string]string)
+		}
+		p.settings[name][key] = kv[1]
+	}
+	return p, nil
+}
+
+// setting returns the value of a setting of the named container
+func (p *dockerProvisioner) setting(name, key string) string {
+	if v, ok := p.settings[name][key]; ok {
+		return v
+	}
+	return p.settings[""][key]
+}
+
+// networkName returns the docker name of a network of the run
+func (p *dockerProvisioner) networkName(network string) string {
+	return p.run + "-" + network
+}
+
+// Start creates the docker networks of the run
+func (p *dockerProvisioner) Start() error {
+	seen := make(map[string]bool)
+	for _, s := range p.settings {
+		if network, ok := s["network"]; ok && !seen[network] {
+			seen[network] = true
+			p.networks = append(p.networks, network)
+		}
+	}
+	sort.Strings(p.networks)
+	for i, network := range p.networks {
+		log.Infof("Creating docker network %s", p.networkName(network))
+		out, err := exec.Command("docker", "network", "create", p.networkName(network)).CombinedOutput()
+		if err != nil {
+			p.networks = p.networks[:i]
+			p.Close()
+			return fmt.Errorf("cannot create docker network %s: %v: %s",
+				network, err, strings.TrimSpace(string(out)))
+		}
+	}
+	return nil
+}
+
+// Close removes the docker networks of the run
+func (p *dockerProvisioner) Close() {
+	for _, network := range p.networks {
+		out, err := exec.Command("docker", "network", "rm", p.networkName(network)).CombinedOutput()
+		if err != nil {
+			log.Warnf("Cannot remove docker network %s: %v: %s",
+				network, err, strings.TrimSpace(string(out)))
+		}
+	}
+	p.networks = nil
+}
+
+// wrap returns args launching the node in a container, or args itself
+// when the provisioner is nil
+func (p *dockerProvisioner) wrap(args Args) Args {
+	if p == nil {
+		return args
+	}
+	return &dockerArgs{Args: args, p: p}
+}
+
+// wrapServer is wrap for the args of a chain server
+func (p *dockerProvisioner) wrapServer(args ChainServer) ChainServer {
+	if p == nil {
+		return args
+	}
+	return &dockerArgs{Args: args, p: p}
+}
+
+// register records the address in the networks of the container with the
+// given name listening on the host address
+func (p *dockerProvisioner) register(host, name string) {
+	_, port, err := net.SplitHostPort(host)
+	if err != nil {
+		return
+	}
+	p.mtx.Lock()
+	p.addrs[host] = net.JoinHostPort(name, port)
+	p.mtx.Unlock()
+}
+
+// containerArg returns an argument of a node for it to run in a container:
+// the host addresses of other containers are replaced by their addresses
+// in the network and the node listens on every interface of its container
+func (p *dockerProvisioner) containerArg(arg, name string) string {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	for host, addr := range p.addrs {
+		if strings.HasSuffix(arg, "="+host) && !strings.HasPrefix(addr, name+":") {
+			return strings.TrimSuffix(arg, host) + addr
+		}
+	}
+	return strings.Replace(arg, "127.0.0.1:", "0.0.0.0:", -1)
+}
+
+// dockerArgs launches the node of the wrapped args in a container
+type dockerArgs struct {
+	Args
+	p *dockerProvisioner
+}
+
+// container returns the name of the container
+func (d *dockerArgs) container() string {
+	return d.p.run + "-" + d.String()
+}
+
+// Command returns the command running the node in its container, which
+// publishes the listening ports of the node on the loopback interface.
+// The containers started later reach the node by its name.
+func (d *dockerArgs) Command() *exec.Cmd {
+	local := d.Args.Command()
+	exe := filepath.Base(local.Args[0])
+	name := d.String()
+	image := d.p.setting(name, "image")
+	if image == "" {
+		image = d.p.images[exe]
+	}
+
+	args := []string{"run", "--rm",
+		"--name", d.container(),
+		"--network", d.p.networkName(d.p.setting(name, "network")),
+		"--network-alias", name,
+		"--user", fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid()),
+		"--volume", AppDataDir + ":" + AppDataDir,
+	}
+	if cpus := d.p.setting(name, "cpus"); cpus != "" {
+		args = append(args, "--cpus", cpus)
+	}
+	if memory := d.p.setting(name, "memory"); memory != "" {
+		args = append(args, "--memory", memory)
+	}
+	for _, host := range d.listenAddrs() {
+		d.p.register(host, name)
+		args = append(args, "--publish", host+":"+portOf(host))
+	}
+	args = append(args, image, exe)
+	for _, arg := range local.Args[1:] {
+		args = append(args, d.p.containerArg(arg, name))
+	}
+	return exec.Command("docker", args...)
+}
+
+// listenAddrs returns the host addresses the node listens on
+func (d *dockerArgs) listenAddrs() []string {
+	addrs := []string{d.RPCConnConfig().Host}
+	if cs, ok := d.Args.(ChainServer); ok {
+		addrs = append(addrs, cs.ListenAddr())
+	}
+	return addrs
+}
+
+// portOf returns the port of a host address
+func portOf(host string) string {
+	_, port, _ := net.SplitHostPort(host)
+	return port
+}
+
+// SetListen sets the listen addresses of the chain server
+func (d *dockerArgs) SetListen(listen, rpcListen string) {
+	d.Args.(ChainServer).SetListen(listen, rpcListen)
+}
+
+// ListenAddr returns the p2p address of the chain server in the network,
+// which its peers connect to
+func (d *dockerArgs) ListenAddr() string {
+	return net.JoinHostPort(d.String(), portOf(d.Args.(ChainServer).ListenAddr()))
+}
+
+// AddPeer adds a peer the chain server connects to
+func (d *dockerArgs) AddPeer(addr string) {
+	d.Args.(ChainServer).AddPeer(addr)
+}
+
+// SetRelayPolicy sets the mempool size limit and minimum relay fee of the
+// chain server
+func (d *dockerArgs) SetRelayPolicy(maxMempool int, minRelayFee float64) error {
+	return d.Args.(ChainServer).SetRelayPolicy(maxMempool, minRelayFee)
+}
+
+// Cleanup removes the container in case it outlived the docker client,
+// then the directories of the node
+func (d *dockerArgs) Cleanup() error {
+	exec.Command("docker", "rm", "--force", d.container()).Run()
+	return d.Args.Cleanup()
+}
+
+// unwrapArgs returns the args of a node launched in a container, or args
+// itself for a local process
+func unwrapArgs(args Args) Args {
+	if d, ok := args.(*dockerArgs); ok {
+		return d.Args
+	}
+	return args
+}
+
+// dockerHosts returns the names of the containers which serve rpc over
+// TLS, and must be covered by the certificate
+func dockerHosts(nodes int) []string {
+	hosts := []string{"miner", "node"}
+	for i := 1; i < nodes; i++ {
+		hosts = append(hosts, fmt.Sprintf("node-%d", i))
+	}
+	return hosts
+}
+
+// checkDocker returns an error if the simulation cannot run its nodes in
+// containers, nodes are then linked directly within their networks
+func checkDocker(docker, shaped bool, attack string, orphans, reorgs bool) error {
+	if !docker {
+		return nil
+	}
+	if shaped {
+		return errors.New("links cannot be shaped or partitioned by the scenario " +
+			"with docker, nodes connect within their docker networks")
+	}
+	if attack != "" {
+		return errors.New("the attacker cannot run with docker")
+	}
+	if orphans {
+		return errors.New("orphan transactions cannot be sent to the nodes " +
+			"with docker, their p2p ports are not reachable")
+	}
+	if reorgs {
+		return errors.New("the scenario cannot force reorgs with docker, " +
+			"the fork miner cannot reach the p2p ports of the nodes")
+	}
+	if _, err := exec.LookPath("docker"); err != nil {
+		return fmt.Errorf("cannot find docker: %v", err)
+	}
+	return nil
+}
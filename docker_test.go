@@ -0,0 +1,107 @@
+package btcsim
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewDockerProvisioner(t *testing.T) {
+	p, err := newDockerProvisioner("", "btcd=btcd:latest",
+		"cpus=1,memory=512m,node-1.cpus=2,node-1.image=btcd:old,node-2.network=isolated")
+	if err != nil {
+		t.Fatalf("newDockerProvisioner error: %v", err)
+	}
+	tests := []struct {
+		name, key, want string
+	}{
+		{"node", "cpus", "1"},
+		{"node-1", "cpus", "2"},
+		{"node-1", "memory", "512m"},
+		{"node-1", "image", "btcd:old"},
+		{"node", "image", ""},
+		{"node", "network", dockerDefaultNetwork},
+		{"node-2", "network", "isolated"},
+	}
+	for _, test := range tests {
+		if got := p.setting(test.name, test.key); got != test.want {
+			t.Errorf("%s %s got %q want %q", test.name, test.key, got, test.want)
+		}
+	}
+
+	for _, config := range []string{"disk=1g", "node-1.disk=1g", "cpus"} {
+		if _, err := newDockerProvisioner("", "", config); err == nil {
+			t.Errorf("newDockerProvisioner(%q) expected error", config)
+		}
+	}
+}
+
+func TestDockerArgs(t *testing.T) {
+	p, err := newDockerProvisioner("", "btcd=btcd:latest,btcwallet=btcwallet:latest", "node.cpus=2")
+	if err != nil {
+		t.Fatalf("newDockerProvisioner error: %v", err)
+	}
+	node := p.wrapServer(&btcdArgs{prefix: "node", exe: "btcd", endpoint: "ws", cfg: DefaultConfig()})
+	node.SetListen("127.0.0.1:18555", "127.0.0.1:18556")
+	if addr := node.ListenAddr(); addr != "node:18555" {
+		t.Errorf("ListenAddr got %s want node:18555", addr)
+	}
+	cmd := strings.Join(node.Command().Args, " ")
+	for _, want := range []string{
+		"--network " + p.networkName(dockerDefaultNetwork),
+		"--network-alias node",
+		"--cpus 2",
+		"--publish 127.0.0.1:18556:18556",
+		"--publish 127.0.0.1:18555:18555",
+		"btcd:latest btcd",
+		"--listen=0.0.0.0:18555",
+		"--rpclisten=0.0.0.0:18556",
+	} {
+		if !strings.Contains(cmd, want) {
+			t.Errorf("node command %q does not contain %q", cmd, want)
+		}
+	}
+
+	// the wallet connects to the node by its name
+	wallet := p.wrap(&btcwalletArgs{
+		RPCListen:  "127.0.0.1:18557",
+		RPCConnect: "127.0.0.1:18556",
+		prefix:     "actor-18557",
+		exe:        "btcwallet",
+	})
+	cmd = strings.Join(wallet.Command().Args, " ")
+	for _, want := range []string{
+		"--publish 127.0.0.1:18557:18557",
+		"btcwallet:latest btcwallet",
+		"--rpclisten=0.0.0.0:18557",
+		"--rpcconnect=node:18556",
+	} {
+		if !strings.Contains(cmd, want) {
+			t.Errorf("wallet command %q does not contain %q", cmd, want)
+		}
+	}
+	if strings.Contains(cmd, "--cpus") {
+		t.Errorf("wallet command %q has the cpus of the node", cmd)
+	}
+
+	if _, ok := unwrapArgs(wallet).(*btcwalletArgs); !ok {
+		t.Errorf("unwrapArgs got %T want *btcwalletArgs", unwrapArgs(wallet))
+	}
+}
+
+func TestCheckDocker(t *testing.T) {
+	if err := checkDocker(false, true, "selfish", true, true); err != nil {
+		t.Errorf("checkDocker error without docker: %v", err)
+	}
+	if err := checkDocker(true, true, "", false, false); err == nil {
+		t.Errorf("checkDocker expected error with shaped links")
+	}
+	if err := checkDocker(true, false, "selfish", false, false); err == nil {
+		t.Errorf("checkDocker expected error with an attacker")
+	}
+	if err := checkDocker(true, false, "", true, false); err == nil {
+		t.Errorf("checkDocker expected error with orphan transactions")
+	}
+	if err := checkDocker(true, false, "", false, true); err == nil {
+		t.Errorf("checkDocker expected error with scenario reorgs")
+	}
+}
@@ -0,0 +1,224 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+const (
+	// dustThreshold is the smallest pay-to-pubkey-hash output which is
+	// not dust as per the default relay policy
+	dustThreshold btcutil.Amount = 546
+
+	// minRelayFeeRate is the default minimum relay fee rate in satoshis
+	// per byte, which large dust transactions must pay
+	minRelayFeeRate = 1
+
+	// maxConsolidateInputs is the maximum number of dust outputs swept by
+	// a consolidation transaction
+	maxConsolidateInputs = 200
+)
+
+// ErrInsufficientFunds is returned when an utxo is too small to create
+// dust outputs from, or the utxos of an actor too few to send a payment
+var ErrInsufficientFunds = errors.New("insufficient funds")
+
+// checkDust returns an error if the number or the worth of the dust
+// outputs are out of range. Dust outputs are worth less than minFee so
+// that they are never queued as utxos of actors.
+func checkDust(n int, min btcutil.Amount) error {
+	if n < 0 {
+		return fmt.Errorf("number of dust outputs must not be negative")
+	}
+	if min < 1 || min > minFee/2 {
+		return fmt.Errorf("dust output amount must be in [1, %d]", int64(minFee/2))
+	}
+	return nil
+}
+
+// DustStats are the numbers of dust outputs created and swept
+type DustStats struct {
+	Txs          int `json:"txs"`
+	Outputs      int `json:"outputs"`
+	Rejected     int `json:"rejected"`
+	Swept        int `json:"swept"`
+	Uneconomical int `json:"uneconomical"`
+}
+
+// dust runs as a goroutine and, on average every interval, picks a
+// running actor which either creates n outputs worth between min and
+// twice min to its own addresses, or once it owns at least n such
+// outputs, consolidates them into one, until the simulation exits
+func (com *Communication) dust(n int, min btcutil.Amount, interval time.Duration) {
+	defer com.wg.Done()
+
+	r := com.cfg.newRand(dustStream)
+	owned := make(map[*Actor][]*TxOut)
+	for {
+		select {
+		case <-time.After(time.Duration(r.ExpFloat64() * float64(interval))):
+		case <-com.exit:
+			return
+		}
+
+		var running []*Actor
+		for _, a := range com.Actors() {
+			if a.running() && a.floodTarget == 0 && !a.Paused() {
+				running = append(running, a)
+			}
+		}
+		if len(running) == 0 {
+			continue
+		}
+		a := running[r.Intn(len(running))]
+
+		if len(owned[a]) >= n {
+			outs := owned[a]
+			if len(outs) > maxConsolidateInputs {
+				outs = outs[:maxConsolidateInputs]
+			}
+			owned[a] = owned[a][len(outs):]
+			com.consolidateDust(a, outs)
+			continue
+		}
+		outs, err := com.createDust(r, a, n, min)
+		if err != nil {
+			log.Debugf("%s: Dust transaction rejected: %v", a, err)
+			com.dustStats.Rejected++
+			continue
+		}
+		owned[a] = append(owned[a], outs...)
+	}
+}
+
+// dustFee returns the fee of a dust transaction of the given size, no
+// less than the minimum relay fee
+func dustFee(a *Actor, size int) btcutil.Amount {
+	fee := a.feeForSize(size)
+	if relay := btcutil.Amount(size * minRelayFeeRate); fee < relay {
+		fee = relay
+	}
+	return fee
+}
+
+// createDust sends a transaction spending an utxo of the actor to n dust
+// outputs worth between min and twice min, and the change, to its own
+// addresses and returns the dust outputs
+func (com *Communication) createDust(r *rand.Rand, a *Actor, n int, min btcutil.Amount) ([]*TxOut, error) {
+	var utxo *TxOut
+	select {
+	case u, ok := <-a.utxoQueue.dequeue:
+		if !ok {
+			return nil, nil
+		}
+		utxo = u
+	default:
+		log.Debugf("%s: No utxo to create dust from", a)
+		return nil, nil
+	}
+
+	amounts := make(map[btcutil.Address]btcutil.Amount, n+1)
+	var total btcutil.Amount
+	// keep an address for the change
+	for len(amounts) < n && len(amounts) < len(a.ownedAddresses)-1 {
+		addr := a.ownedAddresses[r.Intn(len(a.ownedAddresses))]
+		if _, ok := amounts[addr]; ok {
+			continue
+		}
+		amt := min + btcutil.Amount(r.Int63n(int64(min)+1))
+		amounts[addr] = amt
+		total += amt
+	}
+	change := utxo.Amount - total - dustFee(a, estimateTxSize(1, len(amounts)+1))
+	if change < minFee {
+		return nil, ErrInsufficientFunds
+	}
+	for _, addr := range a.ownedAddresses {
+		if _, ok := amounts[addr]; !ok {
+			amounts[addr] = change
+			break
+		}
+	}
+
+	inputs := []btcjson.TransactionInput{{
+		Txid: utxo.OutPoint.Hash.String(),
+		Vout: utxo.OutPoint.Index,
+	}}
+	msgTx, err := a.createRawTransaction(inputs, amounts)
+	if err != nil {
+		return nil, err
+	}
+	if err := sendUntracked(a, msgTx, com.untracked); err != nil {
+		return nil, err
+	}
+	com.recordTx(a, msgTx, utxo.Amount)
+
+	hash := msgTx.TxSha()
+	var outs []*TxOut
+	for i, txOut := range msgTx.TxOut {
+		// the change is the only output worth more than minFee
+		if btcutil.Amount(txOut.Value) == change {
+			continue
+		}
+		outs = append(outs, &TxOut{
+			OutPoint: wire.NewOutPoint(&hash, uint32(i)),
+			Amount:   btcutil.Amount(txOut.Value),
+		})
+	}
+	com.dustStats.Txs++
+	com.dustStats.Outputs += len(outs)
+	return outs, nil
+}
+
+// consolidateDust sweeps the given dust outputs of the actor into one of
+// its addresses, unless the fee exceeds their worth
+func (com *Communication) consolidateDust(a *Actor, outs []*TxOut) {
+	var in btcutil.Amount
+	inputs := make([]btcjson.TransactionInput, len(outs))
+	for i, out := range outs {
+		in += out.Amount
+		inputs[i] = btcjson.TransactionInput{
+			Txid: out.OutPoint.Hash.String(),
+			Vout: out.OutPoint.Index,
+		}
+	}
+	amt := in - dustFee(a, estimateTxSize(len(inputs), 1))
+	if amt < dustThreshold {
+		log.Debugf("%s: Consolidating %d dust outputs worth %v is uneconomical", a, len(outs), in)
+		com.dustStats.Uneconomical += len(outs)
+		return
+	}
+
+	to := a.ownedAddresses[a.rand.Intn(len(a.ownedAddresses))]
+	msgTx, err := a.createRawTransaction(inputs, map[btcutil.Address]btcutil.Amount{to: amt})
+	if err != nil {
+		log.Errorf("%s: Cannot create consolidation transaction: %v", a, err)
+		return
+	}
+	if err := sendUntracked(a, msgTx, com.untracked); err != nil {
+		log.Debugf("%s: Consolidation transaction rejected: %v", a, err)
+		com.dustStats.Rejected++
+		return
+	}
+	com.recordTx(a, msgTx, in)
+	com.dustStats.Swept += len(outs)
+}
+
+// recordTx records a transaction sent by an actor on behalf of the
+// simulation rather than in answer to a request
+func (com *Communication) recordTx(a *Actor, msgTx *wire.MsgTx, in btcutil.Amount) {
+	select {
+	case com.txStats.sent <- a.newTxRecord(msgTx, in):
+	case <-com.exit:
+	}
+}
@@ -0,0 +1,32 @@
+package btcsim
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcutil"
+)
+
+func TestCheckDust(t *testing.T) {
+	if err := checkDust(50, dustThreshold); err != nil {
+		t.Errorf("checkDust error: %v", err)
+	}
+	if err := checkDust(-1, dustThreshold); err == nil {
+		t.Errorf("checkDust expected error for a negative number of outputs")
+	}
+	if err := checkDust(50, 0); err == nil {
+		t.Errorf("checkDust expected error for a zero amount")
+	}
+	if err := checkDust(50, minFee); err == nil {
+		t.Errorf("checkDust expected error for an amount which would be queued")
+	}
+}
+
+func TestDustFee(t *testing.T) {
+	a := &Actor{Node: &Node{cfg: DefaultConfig()}, profile: defaultProfile}
+	if fee := dustFee(a, 1000); fee != minFee {
+		t.Errorf("dustFee got %v want %v for a small transaction", fee, minFee)
+	}
+	if fee := dustFee(a, 30000); fee != btcutil.Amount(30000*minRelayFeeRate) {
+		t.Errorf("dustFee got %v want the minimum relay fee for a large transaction", fee)
+	}
+}
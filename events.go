@@ -0,0 +1,165 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/wire"
+)
+
+// eventKind is the kind of a simulation event
+type eventKind int
+
+// Kinds of simulation events
+const (
+	// eventTxSent is published when a transaction sent by an actor is
+	// recorded, with the record
+	eventTxSent eventKind = iota
+
+	// eventTxConfirmed is published when a recorded transaction is
+	// mined, with the record
+	eventTxConfirmed
+
+	// eventBlockMined is published when the node connects a block, with
+	// the transactions it contains
+	eventBlockMined
+
+	// eventActorStarted and eventActorFailed are published when an
+	// actor is started or fails, with the actor
+	eventActorStarted
+	eventActorFailed
+
+	// eventReorg is published when the node disconnects a block, with
+	// its hash
+	eventReorg
+
+	// eventBlockConnected and eventTxAccepted are published when any
+	// node notifies a block connected or a transaction accepted to its
+	// mempool, with the hash and the index of the node
+	eventBlockConnected
+	eventTxAccepted
+)
+
+// eventKinds are the kinds of events in order
+var eventKinds = []eventKind{
+	eventTxSent,
+	eventTxConfirmed,
+	eventBlockMined,
+	eventActorStarted,
+	eventActorFailed,
+	eventReorg,
+	eventBlockConnected,
+	eventTxAccepted,
+}
+
+// eventNames are the names of the kinds of events
+var eventNames = map[eventKind]string{
+	eventTxSent:         "tx-sent",
+	eventTxConfirmed:    "tx-confirmed",
+	eventBlockMined:     "block-mined",
+	eventActorStarted:   "actor-started",
+	eventActorFailed:    "actor-failed",
+	eventReorg:          "reorg-detected",
+	eventBlockConnected: "block-connected",
+	eventTxAccepted:     "tx-accepted",
+}
+
+// String returns the name of the kind of event
+func (k eventKind) String() string {
+	if name, ok := eventNames[k]; ok {
+		return name
+	}
+	return fmt.Sprintf("event-%d", int(k))
+}
+
+// Event is an event of the simulation, only the fields relevant to its
+// kind are set
+type Event struct {
+	Kind   eventKind
+	Time   time.Time
+	Height int32
+	Tx     *TxRecord
+	Block  *blockTxs
+	Actor  *Actor
+	Hash   *wire.ShaHash
+	Node   int
+}
+
+// String returns a human readable form of the event
+func (e *Event) String() string {
+	switch e.Kind {
+	case eventTxSent:
+		return fmt.Sprintf("%s %s by %s", e.Kind, e.Tx.TxID, e.Tx.Actor)
+	case eventTxConfirmed:
+		return fmt.Sprintf("%s %s at height %d", e.Kind, e.Tx.TxID, e.Height)
+	case eventBlockMined:
+		return fmt.Sprintf("%s at height %d with %d transactions", e.Kind, e.Height, len(e.Block.txids))
+	case eventActorStarted, eventActorFailed:
+		return fmt.Sprintf("%s %s", e.Kind, e.Actor)
+	case eventReorg:
+		return fmt.Sprintf("%s: block %s disconnected at height %d", e.Kind, e.Hash, e.Height)
+	case eventBlockConnected:
+		return fmt.Sprintf("%s %s at height %d by node %d", e.Kind, e.Hash, e.Height, e.Node)
+	case eventTxAccepted:
+		return fmt.Sprintf("%s %s by node %d", e.Kind, e.Hash, e.Node)
+	}
+	return e.Kind.String()
+}
+
+// eventBus delivers the events published by the components of the
+// simulation to the handlers subscribed to their kind. Handlers are
+// called in the order they subscribed by the goroutine publishing the
+// event, so they must return once the simulation exits.
+type eventBus struct {
+	mtx      sync.RWMutex
+	handlers map[eventKind][]func(*Event)
+}
+
+// newEventBus returns an event bus without subscribers
+func newEventBus() *eventBus {
+	return &eventBus{handlers: make(map[eventKind][]func(*Event))}
+}
+
+// subscribe calls h for every event of the given kinds published from now
+func (b *eventBus) subscribe(h func(*Event), kinds ...eventKind) {
+	b.mtx.Lock()
+	for _, kind := range kinds {
+		b.handlers[kind] = append(b.handlers[kind], h)
+	}
+	b.mtx.Unlock()
+}
+
+// publish delivers an event to the handlers subscribed to its kind, its
+// time is set if missing. Publishing to a nil bus does nothing.
+func (b *eventBus) publish(e *Event) {
+	if b == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	b.mtx.RLock()
+	handlers := b.handlers[e.Kind]
+	b.mtx.RUnlock()
+	for _, h := range handlers {
+		h(e)
+	}
+}
+
+// logEvent logs an event, transaction events at the trace level as there
+// are many of them
+func logEvent(e *Event) {
+	switch e.Kind {
+	case eventTxSent, eventTxConfirmed, eventTxAccepted:
+		log.Tracef("Event: %v", e)
+	case eventActorFailed, eventReorg:
+		log.Infof("Event: %v", e)
+	default:
+		log.Debugf("Event: %v", e)
+	}
+}
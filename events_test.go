@@ -0,0 +1,53 @@
+package btcsim
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/wire"
+)
+
+func TestEventBus(t *testing.T) {
+	bus := newEventBus()
+	var got []string
+	bus.subscribe(func(e *Event) { got = append(got, "first "+e.Kind.String()) }, eventKinds...)
+	bus.subscribe(func(e *Event) { got = append(got, "second "+e.Kind.String()) }, eventBlockMined)
+
+	bus.publish(&Event{Kind: eventBlockMined, Height: 2, Block: &blockTxs{height: 2}})
+	bus.publish(&Event{Kind: eventReorg, Hash: &wire.ShaHash{}, Height: 2})
+	want := []string{"first block-mined", "second block-mined", "first reorg-detected"}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("handler %d got %q, want %q", i, got[i], want[i])
+		}
+	}
+
+	// events without subscribers are dropped and their time is set
+	e := &Event{Kind: eventActorStarted}
+	bus.publish(e)
+	if e.Time.IsZero() {
+		t.Errorf("event time not set")
+	}
+
+	// a nil bus drops every event
+	var nilBus *eventBus
+	nilBus.publish(&Event{Kind: eventTxSent})
+}
+
+func TestEventString(t *testing.T) {
+	tests := []struct {
+		e    *Event
+		want string
+	}{
+		{&Event{Kind: eventTxConfirmed, Tx: &TxRecord{TxID: "ab"}, Height: 7}, "tx-confirmed ab at height 7"},
+		{&Event{Kind: eventBlockMined, Height: 3, Block: &blockTxs{txids: []string{"a", "b"}}}, "block-mined at height 3 with 2 transactions"},
+		{&Event{Kind: eventKind(42)}, "event-42"},
+	}
+	for _, test := range tests {
+		if got := test.e.String(); got != test.want {
+			t.Errorf("got %q, want %q", got, test.want)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// eventJSON is an event of the simulation as streamed with -events-out,
+// only the fields relevant to its kind are set. Run is the ID of the run
+// of a campaign which published it.
+type eventJSON struct {
+	Kind   string    `json:"kind"`
+	Time   time.Time `json:"time"`
+	Run    int       `json:"run,omitempty"`
+	Height int32     `json:"height,omitempty"`
+
+	// TxID, Actor, Size and Fee describe the transaction of the tx-sent
+	// and tx-confirmed events, Actor also names the actor of the
+	// actor-started and actor-failed events
+	TxID  string `json:"txid,omitempty"`
+	Actor string `json:"actor,omitempty"`
+	Size  int    `json:"size,omitempty"`
+	Fee   int64  `json:"fee,omitempty"`
+
+	// TxIDs are the transactions mined in the block of a block-mined
+	// event
+	TxIDs []string `json:"txids,omitempty"`
+
+	// Hash is the hash of the block or transaction notified by a node and
+	// Node the index of the node
+	Hash string `json:"hash,omitempty"`
+	Node *int   `json:"node,omitempty"`
+}
+
+// newEventJSON returns the streamed form of the event. The fields are
+// copied rather than referenced since the event is encoded while the
+// simulation goes on.
+func newEventJSON(e *Event, run int) *eventJSON {
+	j := &eventJSON{
+		Kind:   e.Kind.String(),
+		Time:   e.Time,
+		Run:    run,
+		Height: e.Height,
+	}
+	if e.Tx != nil {
+		j.TxID = e.Tx.TxID
+		j.Actor = e.Tx.Actor
+		j.Size = e.Tx.Size
+		j.Fee = e.Tx.Fee
+	}
+	if e.Actor != nil {
+		j.Actor = e.Actor.String()
+	}
+	if e.Block != nil {
+		j.TxIDs = append([]string(nil), e.Block.txids...)
+	}
+	if e.Hash != nil {
+		j.Hash = e.Hash.String()
+	}
+	switch e.Kind {
+	case eventBlockConnected, eventTxAccepted:
+		node := e.Node
+		j.Node = &node
+	}
+	return j
+}
+
+// eventStream writes every event of the simulation to a file or pipe as
+// newline-delimited JSON. Every event is written at once, unbuffered, so
+// that the consumers of a pipe follow the simulation live.
+type eventStream struct {
+	mtx    sync.Mutex
+	enc    *json.Encoder
+	closer io.Closer
+	run    int
+	err    error
+}
+
+// errStreamClosed is the error of writes to a closed stream
+var errStreamClosed = errors.New("event stream closed")
+
+// newEventStream returns a stream writing to w, which is closed with the
+// stream if it is an io.Closer
+func newEventStream(w io.Writer, run int) *eventStream {
+	s := &eventStream{
+		enc: json.NewEncoder(w),
+		run: run,
+	}
+	if c, ok := w.(io.Closer); ok {
+		s.closer = c
+	}
+	return s
+}
+
+// openEventStream opens the file or pipe at path to stream the events to,
+// "-" streams them to the standard output
+func openEventStream(path string, run int) (*eventStream, error) {
+	if path == "-" {
+		// hide the Close method of the standard output, which is
+		// left open with the stream
+		return newEventStream(struct{ io.Writer }{os.Stdout}, run), nil
+	}
+	// pipes cannot be truncated, so the file is only truncated once
+	// opened if it turns out to be a regular one
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := file.Stat(); err == nil && info.Mode().IsRegular() {
+		if err := file.Truncate(0); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	return newEventStream(file, run), nil
+}
+
+// write writes an event to the stream. The stream stops at the first
+// error, which is logged, so that a consumer going away does not fail the
+// simulation.
+func (s *eventStream) write(e *Event) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.err != nil {
+		return
+	}
+	if s.err = s.enc.Encode(newEventJSON(e, s.run)); s.err != nil {
+		log.Errorf("Cannot stream events: %v", s.err)
+	}
+}
+
+// Close stops the stream and closes the file or pipe it writes to
+func (s *eventStream) Close() error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.err == errStreamClosed {
+		return nil
+	}
+	s.err = errStreamClosed
+	if s.closer != nil {
+		return s.closer.Close()
+	}
+	return nil
+}
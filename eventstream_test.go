@@ -0,0 +1,145 @@
+package btcsim
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/wire"
+)
+
+func TestEventStream(t *testing.T) {
+	var b bytes.Buffer
+	s := newEventStream(&b, 2)
+	hash := &wire.ShaHash{1}
+	events := []*Event{
+		{Kind: eventTxSent, Time: time.Now(), Tx: &TxRecord{TxID: "a", Actor: "actor-1", Size: 226, Fee: 1000}},
+		{Kind: eventBlockMined, Time: time.Now(), Height: 5, Block: &blockTxs{txids: []string{"c", "a"}}},
+		{Kind: eventTxAccepted, Time: time.Now(), Hash: hash},
+		{Kind: eventActorStarted, Time: time.Now(), Actor: fakeActor("b")},
+	}
+	for _, e := range events {
+		s.write(e)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close error: %v", err)
+	}
+	s.write(events[0])
+
+	var got []eventJSON
+	scanner := bufio.NewScanner(&b)
+	for scanner.Scan() {
+		var j eventJSON
+		if err := json.Unmarshal(scanner.Bytes(), &j); err != nil {
+			t.Fatalf("line %q: %v", scanner.Text(), err)
+		}
+		got = append(got, j)
+	}
+	if len(got) != len(events) {
+		t.Fatalf("got %d events, want %d", len(got), len(events))
+	}
+	if j := got[0]; j.Kind != "tx-sent" || j.Run != 2 || j.TxID != "a" || j.Actor != "actor-1" ||
+		j.Fee != 1000 || j.Node != nil {
+		t.Errorf("tx sent: got %+v", j)
+	}
+	if j := got[1]; j.Height != 5 || len(j.TxIDs) != 2 {
+		t.Errorf("block mined: got %+v", j)
+	}
+	if j := got[2]; j.Hash != hash.String() || j.Node == nil || *j.Node != 0 {
+		t.Errorf("tx accepted: got %+v", j)
+	}
+	if j := got[3]; j.Actor != "fake-b" {
+		t.Errorf("actor started: got %+v", j)
+	}
+}
+
+func TestOpenEventStream(t *testing.T) {
+	dir, err := ioutil.TempDir("", "btcsim")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "events.json")
+	if err := ioutil.WriteFile(path, []byte("stale content of a previous run\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s, err := openEventStream(path, 0)
+	if err != nil {
+		t.Fatalf("openEventStream error: %v", err)
+	}
+	s.write(&Event{Kind: eventReorg, Time: time.Now(), Height: 3, Hash: &wire.ShaHash{}})
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close error: %v", err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var j eventJSON
+	if err := json.Unmarshal(data, &j); err != nil || j.Kind != "reorg-detected" || j.Height != 3 {
+		t.Errorf("got %q (%v)", data, err)
+	}
+}
+
+// TestEventStreamProgress checks that the progress printed while the
+// events are streamed to the standard output stays off the stream
+func TestEventStreamProgress(t *testing.T) {
+	stdout, stderr := os.Stdout, os.Stderr
+	defer func() {
+		os.Stdout, os.Stderr = stdout, stderr
+	}()
+	outR, outW, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	errR, errW, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout, os.Stderr = outW, errW
+
+	cfg := DefaultConfig()
+	cfg.EventsOutPath = "-"
+	s, err := openEventStream(cfg.EventsOutPath, 0)
+	if err != nil {
+		t.Fatalf("openEventStream error: %v", err)
+	}
+	bus := newEventBus()
+	bus.subscribe(s.write, eventKinds...)
+	const blocks = 3
+	for h := 1; h <= blocks; h++ {
+		cfg.printProgress(h, blocks)
+		bus.publish(&Event{Kind: eventBlockMined, Height: int32(h), Block: &blockTxs{}})
+	}
+	cfg.endProgress()
+	s.Close()
+	outW.Close()
+	errW.Close()
+	os.Stdout, os.Stderr = stdout, stderr
+
+	var n int
+	scanner := bufio.NewScanner(outR)
+	for scanner.Scan() {
+		var j eventJSON
+		if err := json.Unmarshal(scanner.Bytes(), &j); err != nil {
+			t.Fatalf("line %q of the standard output: %v", scanner.Text(), err)
+		}
+		n++
+	}
+	if n != blocks {
+		t.Errorf("got %d events, want %d", n, blocks)
+	}
+	progress, err := ioutil.ReadAll(errR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "\r1/3\r2/3\r3/3\n"; string(progress) != want {
+		t.Errorf("got progress %q, want %q", progress, want)
+	}
+}
@@ -0,0 +1,273 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/btcsuite/btcd/wire"
+)
+
+// maxRebroadcasts is the number of times an evicted transaction of an actor
+// is rebroadcast before it is given up
+const maxRebroadcasts = 6
+
+// EvictionStats counts the transactions evicted from the mempool of the
+// node, which left it without being mined, and how the ones of the actors
+// fared when rebroadcast
+type EvictionStats struct {
+	Evicted    int `json:"evicted"`
+	ActorTxs   int `json:"actortxs"`
+	Resent     int `json:"resent"`
+	Reaccepted int `json:"reaccepted"`
+
+	// Conflicted is the number of double spends of actors which left the
+	// mempool because the transaction they conflict with was accepted
+	// first, they are not counted as evicted
+	Conflicted int `json:"conflicted"`
+
+	// Mined is the number of evicted transactions of actors mined
+	// eventually
+	Mined int `json:"mined"`
+}
+
+// String returns the statistics as a single line
+func (s *EvictionStats) String() string {
+	return fmt.Sprintf("%d transactions, %d of actors, %d rebroadcasts (%d accepted), %d mined after eviction, "+
+		"%d lost double spends", s.Evicted, s.ActorTxs, s.Resent, s.Reaccepted, s.Mined, s.Conflicted)
+}
+
+// evictionTracker finds the transactions evicted from the mempool of the
+// node by comparing the mempool tracked from its notifications with its
+// actual mempool after every block. A transaction is evicted once it is
+// missing from two mempools in a row without being mined, so that the
+// notifications of the transactions accepted and mined in between are
+// received first. Transactions replaced by a fee bump are not evicted, nor
+// are double spends which lost the race to the transaction they conflict
+// with.
+type evictionTracker struct {
+	mempool *mempoolTracker
+	check   chan struct{}
+
+	mtx sync.Mutex
+	// owners maps the unconfirmed transactions of the actors to their
+	// names, replaced are the ones replaced by a fee bump
+	owners   map[string]string
+	replaced map[string]bool
+	// rivals maps the double spends of the actors and the transactions
+	// they conflict with to each other
+	rivals map[string]string
+	// suspects are the transactions missing at the last check
+	suspects map[string]bool
+	// evicted are the evicted transactions of the actors not mined yet,
+	// with the number of times they were rebroadcast
+	evicted map[string]int
+	stats   EvictionStats
+}
+
+// newEvictionTracker returns a tracker of the evictions from the mempool
+func newEvictionTracker(mempool *mempoolTracker) *evictionTracker {
+	return &evictionTracker{
+		mempool:  mempool,
+		check:    make(chan struct{}, 1),
+		owners:   make(map[string]string),
+		replaced: make(map[string]bool),
+		rivals:   make(map[string]string),
+		suspects: make(map[string]bool),
+		evicted:  make(map[string]int),
+	}
+}
+
+// subscribe subscribes the tracker to the transactions sent and confirmed
+// and to the blocks mined, which request a check
+func (t *evictionTracker) subscribe(events *eventBus) {
+	events.subscribe(t.sent, eventTxSent)
+	events.subscribe(t.confirmed, eventTxConfirmed)
+	events.subscribe(func(e *Event) {
+		select {
+		case t.check <- struct{}{}:
+		default:
+		}
+	}, eventBlockMined)
+}
+
+// sent records a transaction sent by an actor
+func (t *evictionTracker) sent(e *Event) {
+	t.mtx.Lock()
+	t.owners[e.Tx.TxID] = e.Tx.Actor
+	if e.Tx.Replaces != "" {
+		t.replaced[e.Tx.Replaces] = true
+	}
+	if e.Tx.DoubleSpend != "" {
+		t.rivals[e.Tx.TxID] = e.Tx.DoubleSpend
+		t.rivals[e.Tx.DoubleSpend] = e.Tx.TxID
+	}
+	t.mtx.Unlock()
+}
+
+// confirmed forgets a transaction of an actor mined
+func (t *evictionTracker) confirmed(e *Event) {
+	t.mtx.Lock()
+	delete(t.owners, e.Tx.TxID)
+	if _, ok := t.evicted[e.Tx.TxID]; ok {
+		t.stats.Mined++
+		delete(t.evicted, e.Tx.TxID)
+	}
+	t.mtx.Unlock()
+}
+
+// evict compares the tracked mempool with the mempool of the node, counts
+// the transactions evicted and removes them from the tracked mempool
+func (t *evictionTracker) evict(mempool []*wire.ShaHash) {
+	missing := t.mempool.missing(mempool)
+
+	absent := make(map[string]bool, len(missing))
+	for _, txid := range missing {
+		absent[txid] = true
+	}
+
+	t.mtx.Lock()
+	suspects := make(map[string]bool, len(missing))
+	var gone []string
+	for _, txid := range missing {
+		if !t.suspects[txid] {
+			suspects[txid] = true
+			continue
+		}
+		gone = append(gone, txid)
+		if t.replaced[txid] {
+			continue
+		}
+		// a double spend whose rival is still in the mempool or was
+		// mined lost the race rather than being evicted
+		if rival, ok := t.rivals[txid]; ok && !absent[rival] {
+			t.stats.Conflicted++
+			delete(t.owners, txid)
+			continue
+		}
+		t.stats.Evicted++
+		if _, ok := t.owners[txid]; !ok {
+			continue
+		}
+		t.stats.ActorTxs++
+		if _, ok := t.evicted[txid]; !ok {
+			t.evicted[txid] = 0
+		}
+	}
+	t.suspects = suspects
+	t.mtx.Unlock()
+
+	t.mempool.remove(gone)
+}
+
+// resendable returns the evicted transactions of the actors to rebroadcast,
+// those not back in the mempool and not given up, by actor name
+func (t *evictionTracker) resendable() map[string]string {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	txs := make(map[string]string)
+	for txid, n := range t.evicted {
+		if n < maxRebroadcasts && !t.mempool.has(txid) {
+			txs[txid] = t.owners[txid]
+		}
+	}
+	return txs
+}
+
+// resent records an attempt to rebroadcast an evicted transaction, which
+// counts as a rebroadcast only if the transaction was sent
+func (t *evictionTracker) resent(txid string, sent, accepted bool) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	if _, ok := t.evicted[txid]; !ok {
+		return
+	}
+	t.evicted[txid]++
+	if !sent {
+		return
+	}
+	t.stats.Resent++
+	if accepted {
+		t.stats.Reaccepted++
+	}
+}
+
+// Stats returns the statistics of the evictions so far
+func (t *evictionTracker) Stats() *EvictionStats {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	stats := t.stats
+	return &stats
+}
+
+// trackEvictions runs as a goroutine and, after every block, looks for the
+// transactions evicted from the mempool of the node and rebroadcasts the
+// ones of actors with -rebroadcast, until the simulation exits
+func (com *Communication) trackEvictions(t *evictionTracker, client rpcCaller) {
+	defer com.wg.Done()
+
+	for {
+		select {
+		case <-t.check:
+		case <-com.exit:
+			return
+		}
+		mempool, err := client.GetRawMempool()
+		if err != nil {
+			log.Errorf("Cannot get mempool: %v", err)
+			continue
+		}
+		t.evict(mempool)
+		if !com.cfg.Rebroadcast {
+			continue
+		}
+
+		actors := make(map[string]*Actor)
+		for _, a := range com.Actors() {
+			actors[a.String()] = a
+		}
+		for txid, name := range t.resendable() {
+			a, ok := actors[name]
+			if !ok {
+				continue
+			}
+			sent, err := a.rebroadcast(txid)
+			if err != nil {
+				log.Debugf("%s: Cannot rebroadcast %s: %v", a, txid, err)
+			}
+			t.resent(txid, sent, err == nil)
+		}
+	}
+}
+
+// rebroadcast sends a transaction of the actor again, as stored by its
+// wallet, and reports whether it could be sent
+func (a *Actor) rebroadcast(txid string) (bool, error) {
+	if a.wallet != nil {
+		return false, errors.New("in-process wallets do not keep their transactions")
+	}
+	hash, err := wire.NewShaHashFromStr(txid)
+	if err != nil {
+		return false, err
+	}
+	res, err := a.client.GetTransaction(hash)
+	if err != nil {
+		return false, err
+	}
+	serialized, err := hex.DecodeString(res.Hex)
+	if err != nil {
+		return false, err
+	}
+	tx := new(wire.MsgTx)
+	if err := tx.Deserialize(bytes.NewReader(serialized)); err != nil {
+		return false, err
+	}
+	_, err = a.client.SendRawTransaction(tx, false)
+	return true, err
+}
@@ -0,0 +1,121 @@
+package btcsim
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// shaHash returns a hash whose first byte is b
+func shaHash(b byte) *wire.ShaHash {
+	var hash wire.ShaHash
+	hash[0] = b
+	return &hash
+}
+
+func TestEvictionTracker(t *testing.T) {
+	events := newEventBus()
+	mempool := newMempoolTracker()
+	tr := newEvictionTracker(mempool)
+	tr.subscribe(events)
+
+	actorTx, otherTx, bumped, bump := shaHash(1), shaHash(2), shaHash(3), shaHash(4)
+	victim, doubleSpend := shaHash(5), shaHash(6)
+	mempool.seed(10, []*wire.ShaHash{actorTx, otherTx, bumped, bump, victim, doubleSpend})
+	for _, r := range []*TxRecord{
+		{TxID: actorTx.String(), Actor: "a"},
+		{TxID: bumped.String(), Actor: "a"},
+		{TxID: bump.String(), Actor: "a", Replaces: bumped.String()},
+		{TxID: victim.String(), Actor: "b"},
+		{TxID: doubleSpend.String(), Actor: "b", DoubleSpend: victim.String()},
+	} {
+		events.publish(&Event{Kind: eventTxSent, Tx: r})
+	}
+
+	// transactions missing once may just have been mined, the double
+	// spend lost the race to its victim
+	tr.evict([]*wire.ShaHash{bump, victim})
+	if s := tr.Stats(); s.Evicted != 0 || mempool.Size() != 6 {
+		t.Errorf("got %d evicted and %d in mempool after one check want 0, 6", s.Evicted, mempool.Size())
+	}
+	tr.evict([]*wire.ShaHash{bump, victim})
+	if s := tr.Stats(); s.Evicted != 2 || s.ActorTxs != 1 || s.Conflicted != 1 || mempool.Size() != 2 {
+		t.Errorf("got %+v and %d in mempool want 2 evicted, 1 of actors, 1 conflicted and 2 in mempool",
+			s, mempool.Size())
+	}
+
+	resend := tr.resendable()
+	if len(resend) != 1 || resend[actorTx.String()] != "a" {
+		t.Fatalf("resendable got %v want the evicted transaction of a", resend)
+	}
+	// a rebroadcast which could not be sent is not counted
+	tr.resent(actorTx.String(), false, false)
+	for i := 1; i < maxRebroadcasts; i++ {
+		tr.resent(actorTx.String(), true, i == 1)
+	}
+	if len(tr.resendable()) != 0 {
+		t.Errorf("evicted transaction rebroadcast more than %d times", maxRebroadcasts)
+	}
+
+	events.publish(&Event{Kind: eventTxConfirmed, Tx: &TxRecord{TxID: actorTx.String()}})
+	s := tr.Stats()
+	if s.Resent != maxRebroadcasts-1 || s.Reaccepted != 1 || s.Mined != 1 {
+		t.Errorf("got %+v want %d rebroadcasts, 1 accepted and 1 mined", s, maxRebroadcasts-1)
+	}
+	want := "2 transactions, 1 of actors, 5 rebroadcasts (1 accepted), 1 mined after eviction, " +
+		"1 lost double spends"
+	if s.String() != want {
+		t.Errorf("String got %q want %q", s.String(), want)
+	}
+}
+
+// resendWallet returns a stored transaction and records the transactions
+// sent
+type resendWallet struct {
+	rpcCaller
+	tx   *wire.MsgTx
+	sent []*wire.MsgTx
+}
+
+func (w *resendWallet) GetTransaction(txHash *wire.ShaHash) (*btcjson.GetTransactionResult, error) {
+	if *txHash != w.tx.TxSha() {
+		return nil, errors.New("unknown transaction")
+	}
+	var b bytes.Buffer
+	w.tx.Serialize(&b)
+	return &btcjson.GetTransactionResult{TxID: txHash.String(), Hex: hex.EncodeToString(b.Bytes())}, nil
+}
+
+func (w *resendWallet) SendRawTransaction(tx *wire.MsgTx, allowHighFees bool) (*wire.ShaHash, error) {
+	w.sent = append(w.sent, tx)
+	hash := tx.TxSha()
+	return &hash, nil
+}
+
+func TestActorRebroadcast(t *testing.T) {
+	tx := wire.NewMsgTx()
+	tx.AddTxOut(wire.NewTxOut(1000, []byte{0x51}))
+	w := &resendWallet{tx: tx}
+	a := fakeActor("a")
+	a.client = newRPCClient(DefaultConfig(), nil, "resend")
+	a.client.calls = w
+
+	hash := tx.TxSha()
+	if sent, err := a.rebroadcast(hash.String()); !sent || err != nil {
+		t.Fatalf("rebroadcast got %v, %v want sent", sent, err)
+	}
+	if len(w.sent) != 1 || w.sent[0].TxSha() != hash {
+		t.Errorf("rebroadcast sent %v want the stored transaction", w.sent)
+	}
+	if sent, err := a.rebroadcast(shaHash(1).String()); sent || err == nil {
+		t.Errorf("rebroadcast of an unknown transaction succeeded")
+	}
+	a.wallet = &memWallet{}
+	if sent, err := a.rebroadcast(hash.String()); sent || err == nil {
+		t.Errorf("rebroadcast from an in-process wallet succeeded")
+	}
+}
@@ -0,0 +1,343 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+// behaviorExchange is the name of the exchange behavior, optionally
+// followed by a colon and the number of blocks between its sweeps to cold
+// storage, e.g. exchange:12
+const behaviorExchange = "exchange"
+
+const (
+	// defaultSweepInterval is the number of blocks between the sweeps of
+	// an exchange unless given
+	defaultSweepInterval = 6
+
+	// exchangeHotRatio is the fraction of the funds swept at least kept
+	// in the hot wallet to pay withdrawals
+	exchangeHotRatio = 0.2
+
+	// exchangeWithdrawProb is the probability that a deposit is followed
+	// by a withdrawal request of another customer
+	exchangeWithdrawProb = 0.5
+
+	// exchangeMaxBatch is the most withdrawals an exchange pays in a
+	// single transaction, further requests wait for the next block
+	exchangeMaxBatch = 50
+)
+
+// exchange receives many small deposits, sweeps them every interval blocks
+// to a cold address, and pays the withdrawals requested since the last
+// block from its hot wallet in a single batched transaction. The cold
+// address pays to a 1-of-1 multisig script, whose outputs are not queued
+// to any actor, so the swept funds leave the hot wallet for good.
+type exchange struct {
+	NopBehavior
+	interval int32
+
+	// own are the transactions sent by the exchange, whose change is not
+	// a deposit, pending the withdrawals requested and not yet paid
+	mtx     sync.Mutex
+	cold    btcutil.Address
+	own     map[wire.ShaHash]struct{}
+	pending map[btcutil.Address]btcutil.Amount
+	last    int32
+	stats   ExchangeStats
+}
+
+// newExchange returns the exchange behavior with the given name, which sets
+// the number of blocks between its sweeps
+func newExchange(name string) (*exchange, error) {
+	interval := int64(defaultSweepInterval)
+	if name != behaviorExchange {
+		var err error
+		s := strings.TrimPrefix(name, behaviorExchange+":")
+		if interval, err = strconv.ParseInt(s, 10, 32); err != nil || interval < 1 {
+			return nil, fmt.Errorf("invalid sweep interval %q of exchange", s)
+		}
+	}
+	return &exchange{
+		interval: int32(interval),
+		own:      make(map[wire.ShaHash]struct{}),
+		pending:  make(map[btcutil.Address]btcutil.Amount),
+	}, nil
+}
+
+// isExchange reports whether the behavior name is an exchange
+func isExchange(name string) bool {
+	return name == behaviorExchange || strings.HasPrefix(name, behaviorExchange+":")
+}
+
+// OnPaymentReceived counts the deposits to the exchange and requests a
+// withdrawal of another customer after some of them
+func (e *exchange) OnPaymentReceived(a *Actor, out *TxOut) {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	if _, ok := e.own[out.OutPoint.Hash]; ok || out.Coinbase {
+		return
+	}
+	e.stats.Deposits++
+	e.stats.Deposited += int64(out.Amount)
+	if a.rand.Float64() < exchangeWithdrawProb {
+		e.request(a, out.Amount)
+	}
+}
+
+// request queues a withdrawal to a random other actor of an amount
+// exponentially distributed around the given mean, the lock must be held
+// by the caller
+func (e *exchange) request(a *Actor, mean btcutil.Amount) {
+	if a.peers == nil || len(e.pending) >= exchangeMaxBatch {
+		return
+	}
+	peers := a.peers()
+	o := peers[a.rand.Intn(len(peers))]
+	if o == a {
+		return
+	}
+	amt := btcutil.Amount(float64(mean) * a.rand.ExpFloat64())
+	if amt < minFee {
+		amt = minFee
+	}
+	e.pending[o.Address()] += amt
+}
+
+// OnBlock pays the pending withdrawals and sweeps the deposits to cold
+// storage every interval blocks
+func (e *exchange) OnBlock(a *Actor, height int32) {
+	e.withdraw(a)
+
+	e.mtx.Lock()
+	if height-e.last < e.interval {
+		e.mtx.Unlock()
+		return
+	}
+	e.last = height
+	e.mtx.Unlock()
+	e.sweep(a)
+}
+
+// withdraw pays the pending withdrawals in a single transaction, they stay
+// pending if the hot wallet cannot cover them
+func (e *exchange) withdraw(a *Actor) {
+	e.mtx.Lock()
+	amounts := make(map[btcutil.Address]btcutil.Amount, len(e.pending))
+	for addr, amt := range e.pending {
+		amounts[addr] = amt
+	}
+	e.mtx.Unlock()
+	if len(amounts) == 0 {
+		return
+	}
+
+	msgTx, err := a.Pay(amounts)
+	switch err {
+	case nil:
+	case ErrInsufficientFunds:
+		log.Debugf("%s: Cannot pay %d withdrawals: %v", a, len(amounts), err)
+		e.mtx.Lock()
+		e.stats.Delayed++
+		e.mtx.Unlock()
+		return
+	case ErrActorShutdown:
+		return
+	default:
+		log.Errorf("%s: Cannot pay %d withdrawals: %v", a, len(amounts), err)
+		return
+	}
+
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	e.own[msgTx.TxSha()] = struct{}{}
+	e.stats.Batches++
+	for addr, amt := range amounts {
+		// requests made while paying stay pending
+		if e.pending[addr] -= amt; e.pending[addr] <= 0 {
+			delete(e.pending, addr)
+		}
+		e.stats.Withdrawals++
+		e.stats.Withdrawn += int64(amt)
+	}
+}
+
+// sweep sends the smallest utxos of the hot wallet to the cold address,
+// keeping the largest ones worth at least exchangeHotRatio of the funds
+// in the hot wallet
+func (e *exchange) sweep(a *Actor) {
+	if !a.waitWhilePaused() {
+		return
+	}
+	e.mtx.Lock()
+	cold := e.cold
+	e.mtx.Unlock()
+	if cold == nil {
+		var err error
+		if cold, err = a.coldAddress(); err != nil {
+			log.Errorf("%s: Cannot create cold address: %v", a, err)
+			return
+		}
+		e.mtx.Lock()
+		e.cold = cold
+		e.mtx.Unlock()
+	}
+
+	var utxos []*TxOut
+	var in btcutil.Amount
+dequeue:
+	for len(utxos) < maxConsolidateInputs {
+		select {
+		case utxo, ok := <-a.utxoQueue.dequeue:
+			if !ok {
+				return
+			}
+			utxos = append(utxos, utxo)
+			in += utxo.Amount
+		default:
+			break dequeue
+		}
+	}
+	hot, swept := splitHot(utxos, in)
+	a.requeue(hot)
+	if len(swept) < 2 {
+		a.requeue(swept)
+		return
+	}
+
+	var amt btcutil.Amount
+	inputs := make([]btcjson.TransactionInput, len(swept))
+	for i, utxo := range swept {
+		inputs[i] = btcjson.TransactionInput{
+			Txid: utxo.OutPoint.Hash.String(),
+			Vout: utxo.OutPoint.Index,
+		}
+		amt += utxo.Amount
+	}
+	out := amt - a.feeForSize(estimateTxSize(len(inputs), 1))
+	msgTx, err := a.createRawTransaction(inputs, map[btcutil.Address]btcutil.Amount{cold: out})
+	if err == nil {
+		err = sendUntracked(a, msgTx, a.untracked)
+	}
+	if err != nil {
+		log.Errorf("%s: Cannot sweep %d utxos to cold storage: %v", a, len(swept), err)
+		a.requeue(swept)
+		return
+	}
+	log.Debugf("%s: Swept %d utxos worth %v to cold storage", a, len(swept), amt)
+	a.recordTx(msgTx, amt, a.txSent)
+
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	e.stats.Sweeps++
+	e.stats.Swept += len(swept)
+	e.stats.Cold += int64(out)
+}
+
+// splitHot splits utxos worth in into the largest ones, worth at least
+// exchangeHotRatio of in, which stay hot and the others, which are swept
+func splitHot(utxos []*TxOut, in btcutil.Amount) (hot, swept []*TxOut) {
+	sorted := make([]*TxOut, len(utxos))
+	copy(sorted, utxos)
+	sort.Sort(utxosByAmount(sorted))
+	reserve := btcutil.Amount(float64(in) * exchangeHotRatio)
+	var kept btcutil.Amount
+	for i, utxo := range sorted {
+		if kept >= reserve {
+			return sorted[:i], sorted[i:]
+		}
+		kept += utxo.Amount
+	}
+	return sorted, nil
+}
+
+// utxosByAmount sorts utxos by decreasing amount
+type utxosByAmount []*TxOut
+
+func (u utxosByAmount) Len() int           { return len(u) }
+func (u utxosByAmount) Less(i, j int) bool { return u[i].Amount > u[j].Amount }
+func (u utxosByAmount) Swap(i, j int)      { u[i], u[j] = u[j], u[i] }
+
+// coldAddress returns a new address of the actor paying to a 1-of-1
+// multisig script
+func (a *Actor) coldAddress() (btcutil.Address, error) {
+	if a.wallet != nil {
+		return a.wallet.newAddress(addrP2SH)
+	}
+	if a.client == nil {
+		return nil, ErrActorShutdown
+	}
+	return a.newWalletAddress(addrP2SH)
+}
+
+// Stats returns the deposits, sweeps and withdrawals of the exchange
+func (e *exchange) Stats() ExchangeStats {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	s := e.stats
+	s.Interval = e.interval
+	s.Pending = len(e.pending)
+	return s
+}
+
+// ExchangeStats are the deposits received by an exchange, its sweeps to
+// cold storage and the withdrawals it paid
+type ExchangeStats struct {
+	Name     string `json:"name"`
+	Interval int32  `json:"interval"`
+
+	// Deposits is the number of deposits received and Deposited their
+	// amount
+	Deposits  int   `json:"deposits"`
+	Deposited int64 `json:"deposited"`
+
+	// Sweeps is the number of sweep transactions, Swept the number of
+	// utxos they spent and Cold the amount they sent to cold storage
+	Sweeps int   `json:"sweeps"`
+	Swept  int   `json:"swept"`
+	Cold   int64 `json:"cold"`
+
+	// Batches is the number of withdrawal transactions, Withdrawals the
+	// number of withdrawals they paid and Withdrawn their amount,
+	// Delayed the number of blocks the hot wallet could not pay the
+	// pending withdrawals and Pending those still unpaid
+	Batches     int   `json:"batches"`
+	Withdrawals int   `json:"withdrawals"`
+	Withdrawn   int64 `json:"withdrawn"`
+	Delayed     int   `json:"delayed"`
+	Pending     int   `json:"pending"`
+}
+
+// String returns the stats in a line of the summary
+func (s *ExchangeStats) String() string {
+	return fmt.Sprintf("%d deposits (%v), %d sweeps every %d blocks of %d utxos (%v) to cold storage, %d withdrawals (%v) in %d batches, delayed %d times, %d pending",
+		s.Deposits, btcutil.Amount(s.Deposited), s.Sweeps, s.Interval, s.Swept,
+		btcutil.Amount(s.Cold), s.Withdrawals, btcutil.Amount(s.Withdrawn), s.Batches,
+		s.Delayed, s.Pending)
+}
+
+// exchangeStats returns the stats of the exchanges among the actors
+func exchangeStats(actors []*Actor) []*ExchangeStats {
+	var stats []*ExchangeStats
+	for _, a := range actors {
+		e, ok := a.behavior.(*exchange)
+		if !ok {
+			continue
+		}
+		s := e.Stats()
+		s.Name = a.String()
+		stats = append(stats, &s)
+	}
+	return stats
+}
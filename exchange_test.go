@@ -0,0 +1,90 @@
+package btcsim
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+func TestNewExchange(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval int32
+		ok       bool
+	}{
+		{"exchange", defaultSweepInterval, true},
+		{"exchange:12", 12, true},
+		{"exchange:0", 0, false},
+		{"exchange:x", 0, false},
+	}
+	for _, test := range tests {
+		b, err := newBehavior(test.name)
+		if (err == nil) != test.ok {
+			t.Errorf("newBehavior(%q) error: %v", test.name, err)
+			continue
+		}
+		if test.ok && b.(*exchange).interval != test.interval {
+			t.Errorf("newBehavior(%q) got interval %d want %d", test.name, b.(*exchange).interval, test.interval)
+		}
+	}
+	if err := RegisterBehavior("exchange:3", nil); err == nil {
+		t.Errorf("registered a behavior named like an exchange")
+	}
+}
+
+func TestExchangeDeposits(t *testing.T) {
+	a, b := fakeActor("exchange"), fakeActor("b")
+	for i, o := range []*Actor{a, b} {
+		o.rand = DefaultConfig().newRand(int64(i))
+		o.ownedAddresses = []btcutil.Address{fakeAddress(o.String())}
+	}
+	a.peers = func() []*Actor { return []*Actor{b} }
+
+	e, _ := newExchange("exchange")
+	var own wire.ShaHash
+	own[0] = 1
+	e.own[own] = struct{}{}
+	e.OnPaymentReceived(a, &TxOut{OutPoint: &wire.OutPoint{Hash: own}, Amount: 1e8})
+	e.OnPaymentReceived(a, &TxOut{OutPoint: &wire.OutPoint{}, Amount: 5e9, Coinbase: true})
+	if e.stats.Deposits != 0 {
+		t.Errorf("exchange counted its change or a block reward as deposits")
+	}
+	for i := 0; i < 20; i++ {
+		e.OnPaymentReceived(a, &TxOut{OutPoint: &wire.OutPoint{Index: uint32(i)}, Amount: 1e6})
+	}
+	s := e.Stats()
+	if s.Deposits != 20 || s.Deposited != 2e7 {
+		t.Errorf("got %d deposits of %v", s.Deposits, btcutil.Amount(s.Deposited))
+	}
+	// every withdrawal goes to the only customer
+	if s.Pending != 1 || e.pending[b.Address()] < minFee {
+		t.Errorf("got pending withdrawals %v", e.pending)
+	}
+	if !strings.Contains(s.String(), "20 deposits") {
+		t.Errorf("got %q", s.String())
+	}
+}
+
+func TestSplitHot(t *testing.T) {
+	amounts := []btcutil.Amount{1e6, 5e7, 2e6, 3e6, 1e7}
+	var utxos []*TxOut
+	var in btcutil.Amount
+	for _, amt := range amounts {
+		utxos = append(utxos, &TxOut{Amount: amt})
+		in += amt
+	}
+	hot, swept := splitHot(utxos, in)
+	if len(hot) != 1 || hot[0].Amount != 5e7 || len(swept) != 4 {
+		t.Fatalf("got %d hot and %d swept utxos", len(hot), len(swept))
+	}
+	for _, utxo := range swept {
+		if utxo.Amount > 1e7 {
+			t.Errorf("swept a utxo of %v", utxo.Amount)
+		}
+	}
+	if hot, swept := splitHot(nil, 0); len(hot) != 0 || len(swept) != 0 {
+		t.Errorf("split no utxos into %v and %v", hot, swept)
+	}
+}
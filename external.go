@@ -0,0 +1,259 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/btcsuite/btcutil"
+)
+
+// behaviorExec is the prefix of the behaviors implemented by an external
+// program, followed by its command line, e.g. "exec:python3 actor.py"
+const behaviorExec = "exec:"
+
+// externalReplyTimeout is the time an external program has to reply to an
+// event before it is considered hung and killed
+const externalReplyTimeout = 30 * time.Second
+
+// externalExitTimeout is the time an external program has to exit once
+// its standard input is closed
+const externalExitTimeout = time.Second
+
+// peerAddress is the recipient of a payment requested by an external
+// program standing for the address of a random other actor
+const peerAddress = "peer"
+
+// ErrExternalExited is returned when the program of an external behavior
+// has exited or was killed
+var ErrExternalExited = errors.New("external behavior exited")
+
+// externalEvent is an event sent to an external program as a line of JSON.
+// The numbers are always present, as output 0 and height 0 are valid.
+type externalEvent struct {
+	Event   string  `json:"event"`
+	Actor   string  `json:"actor,omitempty"`
+	Address string  `json:"address,omitempty"`
+	Height  int32   `json:"height"`
+	TxID    string  `json:"txid,omitempty"`
+	Vout    uint32  `json:"vout"`
+	Amount  float64 `json:"amount"`
+}
+
+// externalReply is the reply of an external program to an event, as a
+// line of JSON
+type externalReply struct {
+	// Pay are the payments to send in a single transaction
+	Pay []externalPayment `json:"pay"`
+}
+
+// externalPayment is a payment requested by an external program
+type externalPayment struct {
+	// To is the address paid, or peerAddress for a random other actor
+	To string `json:"to"`
+
+	// Amount is the amount paid in BTC
+	Amount float64 `json:"amount"`
+}
+
+// external is an actor behavior implemented by an external program. Each
+// event is written to its standard input as a line of JSON and it replies
+// with a line of JSON on its standard output, listing the payments the
+// actor sends in response. The standard error goes to the log of the
+// actor behavior in the run directory.
+type external struct {
+	command []string
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	lines   chan []byte
+	exited  chan struct{}
+	done    chan struct{}
+}
+
+// newExternal returns a behavior running the given command line
+func newExternal(command string) (*external, error) {
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return nil, errors.New("missing external behavior command")
+	}
+	return &external{command: fields}, nil
+}
+
+// OnStart starts the program and sends it the start event with the name
+// and an address of the actor
+func (e *external) OnStart(a *Actor) error {
+	e.cmd = exec.Command(e.command[0], e.command[1:]...)
+	logFile, err := a.cfg.getLogFile(fmt.Sprintf("%s-behavior", a))
+	if err != nil {
+		log.Warnf("%s: Cannot get behavior log file, logging disabled: %v", a, err)
+	} else {
+		e.cmd.Stderr = logFile
+	}
+	stdout, err := e.cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if e.stdin, err = e.cmd.StdinPipe(); err != nil {
+		return err
+	}
+	if err := e.cmd.Start(); err != nil {
+		return err
+	}
+
+	// read the replies in the background so that a hung program can be
+	// timed out
+	e.lines = make(chan []byte)
+	e.exited = make(chan struct{})
+	e.done = make(chan struct{})
+	go func() {
+		defer close(e.exited)
+		scanner := bufio.NewScanner(stdout)
+	scan:
+		for scanner.Scan() {
+			select {
+			case e.lines <- append([]byte(nil), scanner.Bytes()...):
+			case <-e.done:
+				break scan
+			}
+		}
+		e.cmd.Wait()
+		if logFile != nil {
+			logFile.Close()
+		}
+	}()
+	return e.handle(a, &externalEvent{
+		Event:   "start",
+		Actor:   a.String(),
+		Address: a.Address().String(),
+	})
+}
+
+// OnBlock sends the block event with its height
+func (e *external) OnBlock(a *Actor, height int32) {
+	e.handleLogged(a, &externalEvent{Event: "block", Height: height})
+}
+
+// OnTick sends the tick event
+func (e *external) OnTick(a *Actor) {
+	e.handleLogged(a, &externalEvent{Event: "tick"})
+}
+
+// OnPaymentReceived sends the payment event with the output received
+func (e *external) OnPaymentReceived(a *Actor, out *TxOut) {
+	e.handleLogged(a, &externalEvent{
+		Event:  "payment",
+		TxID:   out.OutPoint.Hash.String(),
+		Vout:   out.OutPoint.Index,
+		Amount: out.Amount.ToBTC(),
+	})
+}
+
+// Close closes the standard input of the program once the actor is shut
+// down and kills it unless it exits within externalExitTimeout
+func (e *external) Close() error {
+	if e.done == nil {
+		return nil
+	}
+	close(e.done)
+	e.stdin.Close()
+	select {
+	case <-e.exited:
+		return nil
+	case <-time.After(externalExitTimeout):
+	}
+	return e.cmd.Process.Kill()
+}
+
+// handleLogged handles an event, logging errors
+func (e *external) handleLogged(a *Actor, event *externalEvent) {
+	switch err := e.handle(a, event); err {
+	case nil, ErrExternalExited, ErrActorShutdown:
+	case ErrInsufficientFunds:
+		log.Debugf("%s: Cannot pay as per external behavior: %v", a, err)
+	default:
+		log.Errorf("%s: External behavior: %v", a, err)
+	}
+}
+
+// handle sends an event to the program and sends the payments of its
+// reply. The program is killed if it does not reply in time.
+func (e *external) handle(a *Actor, event *externalEvent) error {
+	select {
+	case <-e.exited:
+		return ErrExternalExited
+	default:
+	}
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if _, err := e.stdin.Write(append(line, '\n')); err != nil {
+		return err
+	}
+
+	var reply externalReply
+	select {
+	case line := <-e.lines:
+		if err := json.Unmarshal(line, &reply); err != nil {
+			return fmt.Errorf("invalid reply %q: %v", line, err)
+		}
+	case <-e.exited:
+		log.Errorf("%s: External behavior exited", a)
+		return ErrExternalExited
+	case <-time.After(externalReplyTimeout):
+		log.Errorf("%s: External behavior did not reply to %s event "+
+			"within %v, killing it", a, event.Event, externalReplyTimeout)
+		e.cmd.Process.Kill()
+		return ErrExternalExited
+	case <-a.quit:
+		return ErrActorShutdown
+	}
+	if len(reply.Pay) == 0 {
+		return nil
+	}
+	amounts, err := externalAmounts(a, reply.Pay)
+	if err != nil {
+		return err
+	}
+	_, err = a.Pay(amounts)
+	return err
+}
+
+// externalAmounts returns the amounts to pay by address given the payments
+// requested by an external program
+func externalAmounts(a *Actor, pay []externalPayment) (map[btcutil.Address]btcutil.Amount, error) {
+	amounts := make(map[btcutil.Address]btcutil.Amount, len(pay))
+	byAddr := make(map[string]btcutil.Address, len(pay))
+	for _, p := range pay {
+		amt, err := btcutil.NewAmount(p.Amount)
+		if err != nil || amt < minFee {
+			return nil, fmt.Errorf("invalid amount %v, at least %v are required",
+				p.Amount, minFee)
+		}
+		var addr btcutil.Address
+		if p.To == peerAddress {
+			if addr = a.Peer(); addr == nil {
+				return nil, errors.New("no other actor to pay")
+			}
+		} else if addr, err = btcutil.DecodeAddress(p.To, a.cfg.netParams()); err != nil {
+			return nil, fmt.Errorf("invalid address %q: %v", p.To, err)
+		}
+		// pay the same address once
+		if prev, ok := byAddr[addr.String()]; ok {
+			addr = prev
+		} else {
+			byAddr[addr.String()] = addr
+		}
+		amounts[addr] += amt
+	}
+	return amounts, nil
+}
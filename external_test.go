@@ -0,0 +1,124 @@
+package btcsim
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/btcsuite/btcutil"
+)
+
+// externalActor returns an actor with an address whose files go to a
+// temporary run dir, removed by the returned function
+func externalActor(t *testing.T) (*Actor, func()) {
+	dir, err := ioutil.TempDir("", "btcsim-external")
+	if err != nil {
+		t.Fatalf("TempDir error: %v", err)
+	}
+	a := fakeActor("a")
+	a.cfg.runDir = dir
+	a.rand = a.cfg.newRand(0)
+	a.ownedAddresses = []btcutil.Address{fakeAddress("a")}
+	return a, func() {
+		os.RemoveAll(dir)
+	}
+}
+
+func TestNewExternal(t *testing.T) {
+	b, err := newBehavior("exec:python3 actor.py --verbose")
+	if err != nil {
+		t.Fatalf("newBehavior error: %v", err)
+	}
+	e, ok := b.(*external)
+	if !ok {
+		t.Fatalf("newBehavior got %T want *external", b)
+	}
+	if len(e.command) != 3 || e.command[0] != "python3" {
+		t.Errorf("got command %q", e.command)
+	}
+	if _, err := newBehavior("exec: "); err == nil {
+		t.Errorf("newBehavior expected error without a command")
+	}
+}
+
+func TestExternal(t *testing.T) {
+	a, cleanup := externalActor(t)
+	defer cleanup()
+
+	// the program replies to every event without paying
+	script := filepath.Join(a.cfg.runDir, "actor.sh")
+	err := ioutil.WriteFile(script, []byte("while read line; do echo '{\"pay\":[]}'; done\n"), 0600)
+	if err != nil {
+		t.Fatalf("WriteFile error: %v", err)
+	}
+	e, err := newExternal("sh " + script)
+	if err != nil {
+		t.Fatalf("newExternal error: %v", err)
+	}
+	if err := e.OnStart(a); err != nil {
+		t.Fatalf("OnStart error: %v", err)
+	}
+	for _, event := range []*externalEvent{{Event: "block", Height: 1}, {Event: "tick"}} {
+		if err := e.handle(a, event); err != nil {
+			t.Errorf("handle %s error: %v", event.Event, err)
+		}
+	}
+	if err := e.Close(); err != nil {
+		t.Errorf("Close error: %v", err)
+	}
+	if err := e.handle(a, &externalEvent{Event: "tick"}); err != ErrExternalExited {
+		t.Errorf("handle after Close got error %v want %v", err, ErrExternalExited)
+	}
+}
+
+func TestExternalExited(t *testing.T) {
+	a, cleanup := externalActor(t)
+	defer cleanup()
+
+	// a program which exits without replying fails the actor
+	e, _ := newExternal("true")
+	if err := e.OnStart(a); err == nil {
+		t.Errorf("OnStart expected error")
+	}
+	e.Close()
+}
+
+func TestExternalAmounts(t *testing.T) {
+	a := fakeActor("a")
+	a.rand = DefaultConfig().newRand(0)
+	if _, err := externalAmounts(a, []externalPayment{{To: peerAddress, Amount: 1}}); err == nil {
+		t.Errorf("externalAmounts expected error without peers")
+	}
+
+	b := fakeActor("b")
+	b.rand = DefaultConfig().newRand(1)
+	b.ownedAddresses = []btcutil.Address{fakeAddress("b")}
+	a.peers = func() []*Actor { return []*Actor{a, b} }
+	amounts, err := externalAmounts(a, []externalPayment{
+		{To: peerAddress, Amount: 1},
+		{To: peerAddress, Amount: 0.5},
+	})
+	if err != nil {
+		t.Fatalf("externalAmounts error: %v", err)
+	}
+	if len(amounts) != 1 || amounts[b.ownedAddresses[0]] != 1.5e8 {
+		t.Errorf("got amounts %v want 1.5 BTC to b", amounts)
+	}
+	if _, err := externalAmounts(a, []externalPayment{{To: peerAddress, Amount: 0.00001}}); err == nil {
+		t.Errorf("externalAmounts expected error for an amount below the minimum fee")
+	}
+}
+
+func TestExternalEventJSON(t *testing.T) {
+	// the first output of a transaction is sent as such
+	b, err := json.Marshal(&externalEvent{Event: "payment", TxID: "4a5e", Amount: 12.5})
+	if err != nil {
+		t.Fatalf("Marshal error: %v", err)
+	}
+	want := `{"event":"payment","height":0,"txid":"4a5e","vout":0,"amount":12.5}`
+	if string(b) != want {
+		t.Errorf("got %s want %s", b, want)
+	}
+}
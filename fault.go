@@ -0,0 +1,66 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"fmt"
+)
+
+// link is a connection between two nodes through a proxy, from is the
+// index of the node connecting to the node with index to
+type link struct {
+	from, to int
+	proxy    *proxy
+}
+
+// partition cuts the links between nodes which are not in the same group,
+// the nodes which are not part of any group form a group of their own.
+// The links within a group are restored. The miner only reaches the group
+// of node 0, so the other groups mine nothing and the chain never splits:
+// partitions test the relay of transactions and the resync of the nodes,
+// while reorgs are forced by the reorg action of scenarios.
+func partition(links []*link, groups [][]int) {
+	group := make(map[int]int)
+	for i, nodes := range groups {
+		for _, n := range nodes {
+			group[n] = i + 1
+		}
+	}
+	var cut int
+	for _, l := range links {
+		split := group[l.from] != group[l.to]
+		if split {
+			cut++
+		}
+		l.proxy.SetCut(split)
+	}
+	log.Infof("Partitioned nodes %v, cut %d of %d links", groups, cut, len(links))
+}
+
+// heal restores every link between nodes
+func heal(links []*link) {
+	for _, l := range links {
+		l.proxy.SetCut(false)
+	}
+	log.Infof("Restored %d links between nodes", len(links))
+}
+
+// checkGroups returns an error if a node of the groups does not exist or
+// is in several groups
+func checkGroups(groups [][]int, numNodes int) error {
+	seen := make(map[int]bool)
+	for _, nodes := range groups {
+		for _, n := range nodes {
+			if n < 0 || n >= numNodes {
+				return fmt.Errorf("no node with index %d", n)
+			}
+			if seen[n] {
+				return fmt.Errorf("node %d is in several groups", n)
+			}
+			seen[n] = true
+		}
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package btcsim
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestPartition(t *testing.T) {
+	l := echoServer(t)
+	defer l.Close()
+
+	// a ring of 4 nodes
+	var links []*link
+	for _, edge := range ringTopology(4, nil) {
+		p, err := newProxy(l.Addr().String(), linkShape{}, rand.New(rand.NewSource(1)))
+		if err != nil {
+			t.Fatalf("newProxy error: %v", err)
+		}
+		defer p.Close()
+		links = append(links, &link{from: edge[1], to: edge[0], proxy: p})
+	}
+
+	cut := func(l *link) bool {
+		l.proxy.mtx.Lock()
+		defer l.proxy.mtx.Unlock()
+		return l.proxy.cut
+	}
+
+	partition(links, [][]int{{0, 1}})
+	for _, l := range links {
+		want := (l.from < 2) != (l.to < 2)
+		if cut(l) != want {
+			t.Errorf("link %d-%d: got cut %v, want %v", l.from, l.to, cut(l), want)
+		}
+	}
+
+	heal(links)
+	for _, l := range links {
+		if cut(l) {
+			t.Errorf("link %d-%d still cut after heal", l.from, l.to)
+		}
+	}
+
+	if err := checkGroups([][]int{{0, 1}, {4}}, 4); err == nil {
+		t.Errorf("checkGroups expected error for unknown node")
+	}
+}
@@ -0,0 +1,271 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// parseFeeTargets parses a comma separated list of confirmation targets in
+// blocks, e.g. "1,2,3,6", and returns them sorted without duplicates
+func parseFeeTargets(s string) ([]int, error) {
+	seen := make(map[int]bool)
+	var targets []int
+	for _, f := range strings.Split(s, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(f))
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid confirmation target %q", f)
+		}
+		if !seen[n] {
+			seen[n] = true
+			targets = append(targets, n)
+		}
+	}
+	sort.Ints(targets)
+	return targets, nil
+}
+
+// feeOracle keeps the fee rates the node estimates for confirmation within
+// every target, refreshed on every block, so that the estimates can be
+// stamped on the transactions as they are sent
+type feeOracle struct {
+	targets []int
+	refresh chan struct{}
+
+	mtx sync.Mutex
+	// rates are the estimates by target in satoshis per byte, targets
+	// the node has no estimate for are left out
+	rates map[int]float64
+	// failed is set once an estimate failed, so that it is logged once
+	failed bool
+}
+
+// newFeeOracle returns an oracle estimating the fee rates of the targets
+func newFeeOracle(targets []int) *feeOracle {
+	return &feeOracle{
+		targets: targets,
+		refresh: make(chan struct{}, 1),
+		rates:   make(map[int]float64),
+	}
+}
+
+// mined requests a refresh of the estimates, it is subscribed to the
+// blocks mined and does not block
+func (o *feeOracle) mined(e *Event) {
+	select {
+	case o.refresh <- struct{}{}:
+	default:
+	}
+}
+
+// update asks the node for the estimate of every target. estimatefee
+// returns BTC per kilobyte, and a negative rate when the node does not have
+// enough data yet.
+func (o *feeOracle) update(client rpcCaller) {
+	rates := make(map[int]float64, len(o.targets))
+	for _, target := range o.targets {
+		rate, err := client.EstimateFee(int64(target))
+		if err != nil {
+			o.mtx.Lock()
+			if !o.failed {
+				log.Errorf("Cannot estimate fee for %d blocks: %v", target, err)
+				o.failed = true
+			}
+			o.mtx.Unlock()
+			continue
+		}
+		if rate < 0 {
+			continue
+		}
+		rates[target] = rate * 1e8 / 1000
+	}
+	o.mtx.Lock()
+	o.rates = rates
+	o.mtx.Unlock()
+}
+
+// current returns a copy of the latest estimates, nil if there are none or
+// o is nil
+func (o *feeOracle) current() map[int]float64 {
+	if o == nil {
+		return nil
+	}
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+	if len(o.rates) == 0 {
+		return nil
+	}
+	rates := make(map[int]float64, len(o.rates))
+	for target, rate := range o.rates {
+		rates[target] = rate
+	}
+	return rates
+}
+
+// estimateFees runs as a goroutine and refreshes the estimates of the
+// oracle from the node after every block until the simulation exits
+func (com *Communication) estimateFees(o *feeOracle, client rpcCaller) {
+	defer com.wg.Done()
+
+	o.update(client)
+	for {
+		select {
+		case <-o.refresh:
+			o.update(client)
+		case <-com.exit:
+			return
+		}
+	}
+}
+
+// FeeTargetAccuracy compares the fee rates estimated for confirmation
+// within a target with the rates which were actually required. The rate
+// required for a transaction sent at height h is the lowest fee rate mined
+// in blocks h+1 to h+target, so that an estimate at least as high would
+// have been confirmed in time.
+type FeeTargetAccuracy struct {
+	Target  int `json:"target"`
+	Samples int `json:"samples"`
+
+	// Hits is the number of estimates at least as high as required
+	Hits    int     `json:"hits"`
+	HitRate float64 `json:"hitrate"`
+
+	// errors of the estimates in satoshis per byte, positive when they
+	// overestimate the rate required
+	MeanError    float64 `json:"meanerror"`
+	MeanAbsError float64 `json:"meanabserror"`
+
+	// MedianRatio is the median of the estimates divided by the rates
+	// required
+	MedianRatio float64 `json:"medianratio"`
+}
+
+// requiredRate returns the lowest fee rate mined in the target blocks after
+// height, and false if the simulation ended before the last of them or none
+// of them mined a transaction of the actors
+func requiredRate(byHeight map[int32]*BlockFees, tip, height int32, target int) (float64, bool) {
+	if height+int32(target) > tip {
+		return 0, false
+	}
+	var rate float64
+	found := false
+	for h := height + 1; h <= height+int32(target); h++ {
+		b, ok := byHeight[h]
+		if !ok || b.Txs == 0 {
+			continue
+		}
+		if !found || b.MinRate < rate {
+			rate, found = b.MinRate, true
+		}
+	}
+	return rate, found
+}
+
+// newFeeAccuracy returns the accuracy of the fee estimates stamped on the
+// records by target, nil if none were
+func newFeeAccuracy(records []*TxRecord, blocks []*BlockFees) []*FeeTargetAccuracy {
+	byHeight := make(map[int32]*BlockFees, len(blocks))
+	var tip int32
+	for _, b := range blocks {
+		byHeight[b.Height] = b
+		if b.Height > tip {
+			tip = b.Height
+		}
+	}
+
+	errs := make(map[int][]float64)
+	ratios := make(map[int][]float64)
+	for _, r := range records {
+		for target, estimate := range r.Estimates {
+			required, ok := requiredRate(byHeight, tip, r.SentHeight, target)
+			if !ok {
+				continue
+			}
+			errs[target] = append(errs[target], estimate-required)
+			if required > 0 {
+				ratios[target] = append(ratios[target], estimate/required)
+			}
+		}
+	}
+
+	var targets []int
+	for target := range errs {
+		targets = append(targets, target)
+	}
+	sort.Ints(targets)
+	var stats []*FeeTargetAccuracy
+	for _, target := range targets {
+		a := &FeeTargetAccuracy{Target: target, Samples: len(errs[target])}
+		var total, abs float64
+		for _, e := range errs[target] {
+			if e >= 0 {
+				a.Hits++
+			}
+			total += e
+			if e < 0 {
+				e = -e
+			}
+			abs += e
+		}
+		a.HitRate = float64(a.Hits) / float64(a.Samples)
+		a.MeanError = total / float64(a.Samples)
+		a.MeanAbsError = abs / float64(a.Samples)
+		if rs := ratios[target]; len(rs) > 0 {
+			sort.Float64s(rs)
+			a.MedianRatio = rs[len(rs)/2]
+		}
+		stats = append(stats, a)
+	}
+	return stats
+}
+
+// writeFeeAccuracyCSV writes the accuracy of the fee estimates of every
+// target as CSV with a header row, errors are in satoshis per byte
+func writeFeeAccuracyCSV(w io.Writer, stats []*FeeTargetAccuracy) error {
+	writer := csv.NewWriter(w)
+	header := []string{"target", "samples", "hits", "hitrate",
+		"meanerror", "meanabserror", "medianratio"}
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+	for _, a := range stats {
+		row := []string{
+			strconv.Itoa(a.Target),
+			strconv.Itoa(a.Samples),
+			strconv.Itoa(a.Hits),
+			strconv.FormatFloat(a.HitRate, 'f', 4, 64),
+			strconv.FormatFloat(a.MeanError, 'f', 2, 64),
+			strconv.FormatFloat(a.MeanAbsError, 'f', 2, 64),
+			strconv.FormatFloat(a.MedianRatio, 'f', 2, 64),
+		}
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// writeFeeAccuracy writes the accuracy of the fee estimates of every target
+// to the given path as CSV
+func writeFeeAccuracy(path string, stats []*FeeTargetAccuracy) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := writeFeeAccuracyCSV(file, stats); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,119 @@
+package btcsim
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseFeeTargets(t *testing.T) {
+	targets, err := parseFeeTargets("6, 1,3,1")
+	if err != nil {
+		t.Fatalf("parseFeeTargets error: %v", err)
+	}
+	if want := []int{1, 3, 6}; !reflect.DeepEqual(targets, want) {
+		t.Errorf("parseFeeTargets got %v want %v", targets, want)
+	}
+	for _, s := range []string{"", "0", "1,x", "-2"} {
+		if _, err := parseFeeTargets(s); err == nil {
+			t.Errorf("parseFeeTargets(%q) succeeded", s)
+		}
+	}
+}
+
+// estimateWallet returns a fixed fee estimate in BTC/kB for every target
+type estimateWallet struct {
+	rpcCaller
+	rates map[int64]float64
+}
+
+func (w *estimateWallet) EstimateFee(numBlocks int64) (float64, error) {
+	rate, ok := w.rates[numBlocks]
+	if !ok {
+		return 0, errors.New("unknown target")
+	}
+	return rate, nil
+}
+
+func TestFeeOracle(t *testing.T) {
+	var o *feeOracle
+	if rates := o.current(); rates != nil {
+		t.Errorf("nil oracle got estimates %v", rates)
+	}
+
+	o = newFeeOracle([]int{1, 2, 6})
+	if rates := o.current(); rates != nil {
+		t.Errorf("oracle got estimates %v before the first update", rates)
+	}
+	// the node has no estimate for 2 blocks and fails for 6
+	o.update(&estimateWallet{rates: map[int64]float64{1: 0.0002, 2: -1}})
+	rates := o.current()
+	if len(rates) != 1 || rates[1] != 20 {
+		t.Errorf("oracle got estimates %v want 20 sat/B for 1 block", rates)
+	}
+	rates[1] = 0
+	if o.current()[1] != 20 {
+		t.Errorf("estimates returned by the oracle are not a copy")
+	}
+
+	// blocks request a refresh without blocking
+	o.mined(&Event{})
+	o.mined(&Event{})
+	if len(o.refresh) != 1 {
+		t.Errorf("oracle got %d refreshes pending want 1", len(o.refresh))
+	}
+}
+
+func TestNewFeeAccuracy(t *testing.T) {
+	blocks := []*BlockFees{
+		newBlockFees(11, []float64{5, 8}, 0),
+		newBlockFees(12, nil, 0),
+		newBlockFees(13, []float64{2, 3}, 0),
+		newBlockFees(14, []float64{10}, 0),
+	}
+	records := []*TxRecord{
+		// requires 5 sat/B within 1 block and 2 within 3
+		{SentHeight: 10, Estimates: map[int]float64{1: 10, 3: 1}},
+		{SentHeight: 10, Estimates: map[int]float64{1: 4}},
+		// the block after is empty
+		{SentHeight: 11, Estimates: map[int]float64{1: 4}},
+		// the target is past the end of the simulation
+		{SentHeight: 13, Estimates: map[int]float64{3: 4}},
+		{SentHeight: 10},
+	}
+	stats := newFeeAccuracy(records, blocks)
+	if len(stats) != 2 {
+		t.Fatalf("newFeeAccuracy got %d targets want 2", len(stats))
+	}
+
+	one := stats[0]
+	if one.Target != 1 || one.Samples != 2 || one.Hits != 1 || one.HitRate != 0.5 {
+		t.Errorf("target 1 got %+v want 1 hit of 2", one)
+	}
+	if one.MeanError != 2 || one.MeanAbsError != 3 || one.MedianRatio != 2 {
+		t.Errorf("target 1 got mean error %v, abs %v, median ratio %v want 2, 3, 2",
+			one.MeanError, one.MeanAbsError, one.MedianRatio)
+	}
+	three := stats[1]
+	if three.Target != 3 || three.Samples != 1 || three.Hits != 0 || three.MeanError != -1 {
+		t.Errorf("target 3 got %+v want 1 miss by 1 sat/B", three)
+	}
+
+	if stats := newFeeAccuracy([]*TxRecord{{SentHeight: 10}}, blocks); stats != nil {
+		t.Errorf("newFeeAccuracy without estimates got %v", stats)
+	}
+
+	var buf bytes.Buffer
+	if err := writeFeeAccuracyCSV(&buf, stats); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 || rows[1][0] != "1" || rows[1][3] != "0.5000" || rows[2][4] != "-1.00" {
+		t.Errorf("writeFeeAccuracyCSV got %v", rows)
+	}
+}
@@ -0,0 +1,443 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+// Fee policies accepted by -feepolicy, rates are in satoshis per byte
+const (
+	// feeFixed pays the given rate
+	feeFixed = "fixed"
+
+	// feeRandom pays a rate picked uniformly between min and max
+	feeRandom = "random"
+
+	// feeEstimate pays the median rate of the transactions mined in the
+	// given number of last blocks, as btcd has no estimatefee
+	feeEstimate = "estimate"
+
+	// feeRBF pays the given rate and replaces transactions which are not
+	// mined in the next block by one paying bump times the fee
+	feeRBF = "rbf"
+
+	// feeCPFP pays the parent rate and spends the change right away with
+	// a child transaction, such that both pay the child rate together
+	feeCPFP = "cpfp"
+)
+
+// feePolicyParams are the names of the parameters of each fee policy
+var feePolicyParams = map[string][]string{
+	feeFixed:    {"rate"},
+	feeRandom:   {"min", "max"},
+	feeEstimate: {"blocks"},
+	feeRBF:      {"rate", "bump"},
+	feeCPFP:     {"parent", "child"},
+}
+
+// rbfSequence is the sequence number of the inputs of the transactions
+// of actors with the rbf fee policy, which signals that they can be
+// replaced as per BIP 125
+const rbfSequence = wire.MaxTxInSequenceNum - 2
+
+// maxEstimateBlocks is the number of blocks the fee estimator remembers
+const maxEstimateBlocks = 100
+
+// feeRateBuckets are the upper bounds in satoshis per byte of the
+// buckets of the fee rate histogram of every block, the last bucket
+// counts higher rates
+var feeRateBuckets = []float64{1, 2, 5, 10, 20, 50, 100, 200}
+
+// feePolicy is a fee policy with its parameters
+type feePolicy struct {
+	name   string
+	params []float64
+}
+
+// parseFeePolicy parses a fee policy given as its name followed by its
+// parameters, separated by colons, e.g. "random:1:20". The empty string
+// returns a nil policy.
+func parseFeePolicy(s string) (*feePolicy, error) {
+	if s == "" {
+		return nil, nil
+	}
+	fields := strings.Split(s, ":")
+	params, ok := feePolicyParams[fields[0]]
+	if !ok {
+		names := make([]string, 0, len(feePolicyParams))
+		for name := range feePolicyParams {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown fee policy %q, valid policies are: %s",
+			fields[0], strings.Join(names, ", "))
+	}
+	if len(fields)-1 != len(params) {
+		return nil, fmt.Errorf("fee policy %s: expected parameters %s",
+			fields[0], strings.Join(params, ":"))
+	}
+	p := &feePolicy{
+		name:   fields[0],
+		params: make([]float64, len(params)),
+	}
+	for i, f := range fields[1:] {
+		v, err := strconv.ParseFloat(f, 64)
+		if err != nil || math.IsNaN(v) || math.IsInf(v, 0) || v < 0 {
+			return nil, fmt.Errorf("fee policy %s: invalid %s %q", fields[0], params[i], f)
+		}
+		p.params[i] = v
+	}
+	switch {
+	case p.name == feeRandom && p.params[0] > p.params[1]:
+		return nil, fmt.Errorf("fee policy %s: min must not exceed max", p.name)
+	case p.name == feeEstimate && (p.params[0] < 1 || p.params[0] > maxEstimateBlocks):
+		return nil, fmt.Errorf("fee policy %s: blocks must be in [1, %d]", p.name, maxEstimateBlocks)
+	case p.name == feeRBF && p.params[1] <= 1:
+		return nil, fmt.Errorf("fee policy %s: bump must be greater than 1", p.name)
+	}
+	return p, nil
+}
+
+// rate returns the fee rate in satoshis per byte to pay
+func (p *feePolicy) rate(r *rand.Rand, est *feeEstimator) float64 {
+	switch p.name {
+	case feeRandom:
+		return p.params[0] + r.Float64()*(p.params[1]-p.params[0])
+	case feeEstimate:
+		if rate, ok := est.estimate(int(p.params[0])); ok {
+			return rate
+		}
+		// no transaction was mined yet, so pay the minimum fee
+		return float64(minFee) / float64(estimateTxSize(1, 2))
+	}
+	return p.params[0]
+}
+
+// String returns the policy as it is parsed
+func (p *feePolicy) String() string {
+	s := p.name
+	for _, v := range p.params {
+		s += ":" + strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	return s
+}
+
+// estimateTxSize returns the approximate size in bytes of a transaction
+// spending inputs pay-to-pubkey-hash outputs to the given number of outputs
+func estimateTxSize(inputs, outputs int) int {
+	return 10 + 148*inputs + 34*outputs
+}
+
+// feeEstimator keeps the fee rates of the transactions of actors mined in
+// the last blocks, it is safe for concurrent use
+type feeEstimator struct {
+	mtx    sync.Mutex
+	blocks [][]float64
+}
+
+// add records the fee rates of the transactions mined in a block
+func (e *feeEstimator) add(rates []float64) {
+	e.mtx.Lock()
+	e.blocks = append(e.blocks, rates)
+	if len(e.blocks) > maxEstimateBlocks {
+		e.blocks = e.blocks[1:]
+	}
+	e.mtx.Unlock()
+}
+
+// estimate returns the median fee rate of the transactions mined in the
+// last n blocks, it returns false if there is none
+func (e *feeEstimator) estimate(n int) (float64, bool) {
+	e.mtx.Lock()
+	var rates []float64
+	start := len(e.blocks) - n
+	if start < 0 {
+		start = 0
+	}
+	for _, b := range e.blocks[start:] {
+		rates = append(rates, b...)
+	}
+	e.mtx.Unlock()
+
+	if len(rates) == 0 {
+		return 0, false
+	}
+	sort.Float64s(rates)
+	return rates[len(rates)/2], true
+}
+
+// BlockFees holds the fees of the transactions of actors mined in a block
+type BlockFees struct {
+	Height     int32
+	Txs        int
+	Fees       int64
+	MinRate    float64
+	MedianRate float64
+	MaxRate    float64
+
+	// Histogram counts the transactions by fee rate as per
+	// feeRateBuckets
+	Histogram []int
+}
+
+// newBlockFees returns the fees of a block given the fee rates of its
+// transactions and their total fee
+func newBlockFees(height int32, rates []float64, fees int64) *BlockFees {
+	b := &BlockFees{
+		Height:    height,
+		Txs:       len(rates),
+		Fees:      fees,
+		Histogram: make([]int, len(feeRateBuckets)+1),
+	}
+	if len(rates) == 0 {
+		return b
+	}
+	sorted := append([]float64(nil), rates...)
+	sort.Float64s(sorted)
+	b.MinRate = sorted[0]
+	b.MedianRate = sorted[len(sorted)/2]
+	b.MaxRate = sorted[len(sorted)-1]
+	for _, rate := range sorted {
+		b.Histogram[sort.SearchFloat64s(feeRateBuckets, rate)]++
+	}
+	return b
+}
+
+// writeFeeStatsCSV writes the fees of every block as CSV with a header row
+func writeFeeStatsCSV(w io.Writer, blocks []*BlockFees) error {
+	header := []string{"height", "txs", "fees", "minrate", "medianrate", "maxrate"}
+	for _, bound := range feeRateBuckets {
+		header = append(header, fmt.Sprintf("rate<=%g", bound))
+	}
+	header = append(header, fmt.Sprintf("rate>%g", feeRateBuckets[len(feeRateBuckets)-1]))
+
+	writer := csv.NewWriter(w)
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+	for _, b := range blocks {
+		row := []string{
+			strconv.Itoa(int(b.Height)),
+			strconv.Itoa(b.Txs),
+			strconv.FormatInt(b.Fees, 10),
+			strconv.FormatFloat(b.MinRate, 'f', 2, 64),
+			strconv.FormatFloat(b.MedianRate, 'f', 2, 64),
+			strconv.FormatFloat(b.MaxRate, 'f', 2, 64),
+		}
+		for _, n := range b.Histogram {
+			row = append(row, strconv.Itoa(n))
+		}
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// writeFeeStats writes the fees of every block to the given path as CSV
+func writeFeeStats(path string, blocks []*BlockFees) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := writeFeeStatsCSV(file, blocks); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// bumpable is an unconfirmed transaction of an actor with the rbf fee
+// policy which is replaced if it is not mined in the next block
+type bumpable struct {
+	txid    string
+	inputs  []btcjson.TransactionInput
+	amounts map[btcutil.Address]btcutil.Amount
+	extra   []*wire.TxOut
+	paid    []btcutil.Address
+	in      btcutil.Amount
+	fee     btcutil.Amount
+}
+
+// feePolicy returns the fee policy of the actor, nil if minFee is paid
+func (a *Actor) feePolicy() *feePolicy {
+	if a.profile.Fees != nil {
+		return a.profile.Fees
+	}
+	return a.cfg.defaultFees
+}
+
+// replaceable reports whether the transactions of the actor signal
+// that they can be replaced, as per the rbf fee policy
+func (a *Actor) replaceable() bool {
+	p := a.feePolicy()
+	return p != nil && p.name == feeRBF
+}
+
+// fee returns the fee of a transaction with the given number of inputs
+// and outputs as per the fee policy of the actor
+func (a *Actor) fee(inputs, outputs int) btcutil.Amount {
+	return a.feeForSize(estimateTxSize(inputs, outputs))
+}
+
+// feeForSize returns the fee of a transaction of the given size in bytes
+// as per the fee policy of the actor
+func (a *Actor) feeForSize(size int) btcutil.Amount {
+	p := a.feePolicy()
+	if p == nil {
+		return minFee
+	}
+	return btcutil.Amount(p.rate(a.rand, a.fees) * float64(size))
+}
+
+// addBumpable adds a transaction to replace if it is not mined in the
+// next block
+func (a *Actor) addBumpable(b *bumpable) {
+	a.bumpMtx.Lock()
+	a.bumpables = append(a.bumpables, b)
+	a.bumpMtx.Unlock()
+}
+
+// blockMined forgets the bumpable and flood transactions mined in a block
+// with the given transactions and signals bumpFees to replace the others
+// and flood and consolidate to send new ones
+func (a *Actor) blockMined(txids []string) {
+	mined := make(map[string]bool, len(txids))
+	for _, txid := range txids {
+		mined[txid] = true
+	}
+	a.bumpMtx.Lock()
+	pending := a.bumpables[:0]
+	for _, b := range a.bumpables {
+		if !mined[b.txid] {
+			pending = append(pending, b)
+		}
+	}
+	a.bumpables = pending
+	a.bumpMtx.Unlock()
+
+	a.floodMtx.Lock()
+	for _, txid := range txids {
+		delete(a.flooded, txid)
+	}
+	a.floodMtx.Unlock()
+
+	select {
+	case a.bump <- struct{}{}:
+	default:
+	}
+	select {
+	case a.floodBlock <- struct{}{}:
+	default:
+	}
+	select {
+	case a.consolidateBlock <- struct{}{}:
+	default:
+	}
+}
+
+// bumpFees runs as a goroutine and replaces the transactions which were
+// not mined in the last block by transactions spending the same inputs
+// with bump times the fee, taken from the change, or the payment if there
+// is no change. Nodes without replace-by-fee reject replacements, in which
+// case the transaction is not bumped again.
+func (a *Actor) bumpFees(bump float64, txSent chan<- *TxRecord) {
+	defer a.wg.Done()
+
+	for {
+		select {
+		case <-a.bump:
+		case <-a.quit:
+			return
+		}
+
+		a.bumpMtx.Lock()
+		bumpables := a.bumpables
+		a.bumpables = nil
+		a.bumpMtx.Unlock()
+
+		var pending []*bumpable
+		for _, b := range bumpables {
+			select {
+			case <-a.quit:
+				return
+			default:
+			}
+			if a.bumpFee(b, bump, txSent) {
+				pending = append(pending, b)
+			}
+		}
+
+		a.bumpMtx.Lock()
+		a.bumpables = append(a.bumpables, pending...)
+		a.bumpMtx.Unlock()
+	}
+}
+
+// bumpFee replaces a transaction by one paying bump times its fee and
+// reports whether the replacement was accepted, in which case b is
+// updated to the replacement
+func (a *Actor) bumpFee(b *bumpable, bump float64, txSent chan<- *TxRecord) bool {
+	extra := btcutil.Amount(float64(b.fee)*bump) - b.fee
+	// the extra fee comes out of the change, or the first payment
+	// if there is none
+	paid := make(map[btcutil.Address]bool, len(b.paid))
+	for _, addr := range b.paid {
+		paid[addr] = true
+	}
+	from := b.paid[0]
+	for addr := range b.amounts {
+		if !paid[addr] {
+			from = addr
+		}
+	}
+	if b.amounts[from]-extra < minFee {
+		return false
+	}
+	amounts := make(map[btcutil.Address]btcutil.Amount, len(b.amounts))
+	for addr, amt := range b.amounts {
+		amounts[addr] = amt
+	}
+	amounts[from] -= extra
+
+	msgTx, err := a.createRawTransaction(b.inputs, amounts, b.extra...)
+	if err != nil {
+		log.Errorf("%s: Cannot create fee bump of %s: %v", a, b.txid, err)
+		return false
+	}
+	// the replacement is not a requested transaction
+	a.untracked.add(msgTx.TxSha())
+	if _, err := a.client.SendRawTransaction(msgTx, false); err != nil {
+		a.untracked.take(msgTx.TxSha())
+		log.Debugf("%s: Fee bump of %s rejected: %v", a, b.txid, err)
+		return false
+	}
+	r := a.newTxRecord(msgTx, b.in)
+	r.Replaces = b.txid
+	select {
+	case txSent <- r:
+	case <-a.quit:
+		return false
+	}
+	b.txid = msgTx.TxSha().String()
+	b.amounts = amounts
+	b.fee += extra
+	return true
+}
@@ -0,0 +1,119 @@
+package btcsim
+
+import (
+	"bytes"
+	"encoding/csv"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseFeePolicy(t *testing.T) {
+	p, err := parseFeePolicy("rbf:5:1.5")
+	if err != nil {
+		t.Fatalf("parseFeePolicy error: %v", err)
+	}
+	if p.String() != "rbf:5:1.5" {
+		t.Errorf("parseFeePolicy got %v want rbf:5:1.5", p)
+	}
+	if p, err := parseFeePolicy(""); p != nil || err != nil {
+		t.Errorf("parseFeePolicy got %v, %v want nil, nil", p, err)
+	}
+	invalid := []string{
+		"cheap:1",
+		"fixed",
+		"fixed:-1",
+		"random:10:1",
+		"estimate:0",
+		"rbf:5:1",
+	}
+	for _, s := range invalid {
+		if _, err := parseFeePolicy(s); err == nil {
+			t.Errorf("parseFeePolicy(%q) expected error", s)
+		}
+	}
+}
+
+func TestFeePolicyRate(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	est := &feeEstimator{}
+
+	p, _ := parseFeePolicy("random:2:4")
+	for i := 0; i < 100; i++ {
+		if rate := p.rate(r, est); rate < 2 || rate > 4 {
+			t.Fatalf("random rate %v out of range", rate)
+		}
+	}
+
+	p, _ = parseFeePolicy("estimate:2")
+	if rate := p.rate(r, est); rate <= 0 {
+		t.Errorf("estimate rate without blocks got %v want the minimum fee rate", rate)
+	}
+	est.add([]float64{1, 2, 3})
+	est.add([]float64{10, 20})
+	est.add([]float64{30})
+	if rate := p.rate(r, est); rate != 20 {
+		t.Errorf("estimate rate got %v want 20", rate)
+	}
+}
+
+func TestBlockFees(t *testing.T) {
+	b := newBlockFees(10, []float64{150, 0.5, 3, 300}, 1000)
+	if b.Txs != 4 || b.MinRate != 0.5 || b.MedianRate != 150 || b.MaxRate != 300 {
+		t.Errorf("unexpected block fees: %+v", b)
+	}
+	expected := []int{1, 0, 1, 0, 0, 0, 0, 1, 1}
+	for i, n := range expected {
+		if b.Histogram[i] != n {
+			t.Fatalf("histogram got %v want %v", b.Histogram, expected)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := writeFeeStatsCSV(&buf, []*BlockFees{b, newBlockFees(11, nil, 0)}); err != nil {
+		t.Fatalf("writeFeeStatsCSV error: %v", err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("cannot read CSV: %v", err)
+	}
+	if len(rows) != 3 || len(rows[0]) != 6+len(expected) || rows[0][len(rows[0])-1] != "rate>200" {
+		t.Errorf("unexpected CSV rows: %v", rows)
+	}
+}
+
+func TestTxStatsBlockFees(t *testing.T) {
+	s := NewTxStats(DefaultConfig())
+	s.sent = make(chan *TxRecord)
+	exit := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		s.collect(exit)
+		close(done)
+	}()
+
+	s.sent <- &TxRecord{TxID: "a", Size: 200, Fee: 2000, SentTime: time.Now()}
+	s.sent <- &TxRecord{TxID: "b", Size: 250, Fee: 500, SentTime: time.Now()}
+	s.blocks <- &blockTxs{height: 1, txids: []string{"coinbase", "a", "b"}}
+	close(exit)
+	<-done
+
+	blocks := s.BlockFees()
+	if len(blocks) != 1 || blocks[0].Txs != 2 || blocks[0].Fees != 2500 {
+		t.Fatalf("unexpected block fees: %+v", blocks)
+	}
+	if rate, ok := s.fees.estimate(1); !ok || rate != 10 {
+		t.Errorf("estimate got %v, %v want 10, true", rate, ok)
+	}
+}
+
+func TestReadProfilesFees(t *testing.T) {
+	ps, err := readProfiles(strings.NewReader("bumper,1,1,1,random,,,rbf:2:2"))
+	if err != nil {
+		t.Fatalf("readProfiles error: %v", err)
+	}
+	if ps[0].Amounts != nil || ps[0].Fees == nil || ps[0].Fees.name != feeRBF {
+		t.Errorf("readProfiles got amounts %v and fees %v", ps[0].Amounts, ps[0].Fees)
+	}
+}
@@ -0,0 +1,190 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+// maxChainDepth is the maximum number of unconfirmed ancestors of a
+// transaction sent by the flooder, as per the default chain limit of
+// bitcoind
+const maxChainDepth = 25
+
+// totalActors returns the number of actors launched, including the
+// flooder which comes last, in the run configured by c
+func (c *Config) totalActors() int {
+	if c.FloodTarget > 0 {
+		return c.NumActors + 1
+	}
+	return c.NumActors
+}
+
+// txSet is a set of transaction hashes, it is safe for concurrent use
+type txSet struct {
+	mtx    sync.Mutex
+	hashes map[wire.ShaHash]struct{}
+}
+
+// newTxSet returns an empty txSet
+func newTxSet() *txSet {
+	return &txSet{hashes: make(map[wire.ShaHash]struct{})}
+}
+
+// add adds a hash to the set
+func (s *txSet) add(hash wire.ShaHash) {
+	s.mtx.Lock()
+	s.hashes[hash] = struct{}{}
+	s.mtx.Unlock()
+}
+
+// take removes a hash from the set and reports whether it was in it, a
+// nil set is empty
+func (s *txSet) take(hash wire.ShaHash) bool {
+	if s == nil {
+		return false
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	_, ok := s.hashes[hash]
+	delete(s.hashes, hash)
+	return ok
+}
+
+// floodOutput is an output the flooder can spend with the number of
+// unconfirmed transactions it descends from
+type floodOutput struct {
+	*TxOut
+	depth int
+}
+
+// floodPending returns the number of transactions of the flooder which
+// are not mined yet
+func (a *Actor) floodPending() int {
+	a.floodMtx.Lock()
+	defer a.floodMtx.Unlock()
+	return len(a.flooded)
+}
+
+// flood runs as a goroutine and keeps target transactions of the actor
+// unconfirmed, instead of answering transaction requests. Every
+// transaction splits an output in two and its outputs are spent right
+// away by the next ones, in chains no longer than maxChainDepth, so that
+// the flooder is not limited by its confirmed utxos. The transactions are
+// added to untracked before they are sent, so that they are not mistaken
+// for the transactions requested by Communicate.
+func (a *Actor) flood(target int, untracked *txSet, txSent chan<- *TxRecord) {
+	defer a.wg.Done()
+
+	var outputs []*floodOutput
+	// own is the set of outputs created by the flooder, which are queued
+	// once mined but may have been spent already. The outputs ending a
+	// chain are not in it, they are spent once mined and queued.
+	own := make(map[wire.OutPoint]bool)
+	// release gives back an output which could not be spent, an output
+	// of the flooder is spent once mined and queued and an utxo is queued
+	// again
+	release := func(out *floodOutput) {
+		if own[*out.OutPoint] {
+			delete(own, *out.OutPoint)
+			return
+		}
+		a.requeue([]*TxOut{out.TxOut})
+	}
+	for {
+		for a.floodPending() >= target {
+			select {
+			case <-a.floodBlock:
+			case <-a.quit:
+				return
+			}
+		}
+		if !a.waitWhilePaused() {
+			return
+		}
+
+		var out *floodOutput
+		if n := len(outputs); n > 0 {
+			out = outputs[n-1]
+			outputs = outputs[:n-1]
+		} else {
+			select {
+			case utxo := <-a.utxoQueue.dequeue:
+				if own[*utxo.OutPoint] {
+					delete(own, *utxo.OutPoint)
+					continue
+				}
+				out = &floodOutput{TxOut: utxo}
+			case <-a.quit:
+				return
+			}
+		}
+
+		fee := a.fee(1, 2)
+		amt := out.Amount - fee
+		if amt < 2*minFee {
+			continue
+		}
+		inputs := []btcjson.TransactionInput{{
+			Txid: out.OutPoint.Hash.String(),
+			Vout: out.OutPoint.Index,
+		}}
+		half := amt / 2
+		amounts := map[btcutil.Address]btcutil.Amount{
+			a.ownedAddresses[a.rand.Int()%len(a.ownedAddresses)]: half,
+		}
+		for {
+			addr := a.ownedAddresses[a.rand.Int()%len(a.ownedAddresses)]
+			if _, ok := amounts[addr]; !ok || len(a.ownedAddresses) == 1 {
+				amounts[addr] += amt - half
+				break
+			}
+		}
+
+		msgTx, err := a.createRawTransaction(inputs, amounts)
+		if err != nil {
+			log.Errorf("%s: Cannot create flood transaction: %v", a, err)
+			release(out)
+			continue
+		}
+		hash := msgTx.TxSha()
+		untracked.add(hash)
+		if _, err := a.client.SendRawTransaction(msgTx, false); err != nil {
+			untracked.take(hash)
+			log.Debugf("%s: Flood transaction rejected: %v", a, err)
+			release(out)
+			select {
+			case <-time.After(idleDelay):
+			case <-a.quit:
+				return
+			}
+			continue
+		}
+		a.floodMtx.Lock()
+		a.flooded[hash.String()] = true
+		a.floodMtx.Unlock()
+		a.recordTx(msgTx, out.Amount, txSent)
+
+		if out.depth+1 >= maxChainDepth {
+			continue
+		}
+		for i, txOut := range msgTx.TxOut {
+			op := wire.NewOutPoint(&hash, uint32(i))
+			own[*op] = true
+			outputs = append(outputs, &floodOutput{
+				TxOut: &TxOut{
+					OutPoint: op,
+					Amount:   btcutil.Amount(txOut.Value),
+				},
+				depth: out.depth + 1,
+			})
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package btcsim
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/wire"
+)
+
+func TestTxSet(t *testing.T) {
+	s := newTxSet()
+	hash := wire.ShaHash{1}
+	s.add(hash)
+	if !s.take(hash) {
+		t.Errorf("take got false want true for an added hash")
+	}
+	if s.take(hash) {
+		t.Errorf("take got true want false for a taken hash")
+	}
+	var nilSet *txSet
+	if nilSet.take(hash) {
+		t.Errorf("take got true want false for a nil set")
+	}
+}
+
+func TestTotalActors(t *testing.T) {
+	cfg := &Config{NumActors: 5}
+	if n := cfg.totalActors(); n != 5 {
+		t.Errorf("totalActors got %d want 5", n)
+	}
+	cfg.FloodTarget = 1000
+	if n := cfg.totalActors(); n != 6 {
+		t.Errorf("totalActors got %d want 6 with the flooder", n)
+	}
+}
+
+func TestBlockMinedFlooded(t *testing.T) {
+	a := &Actor{
+		bump:       make(chan struct{}, 1),
+		flooded:    map[string]bool{"a": true, "b": true},
+		floodBlock: make(chan struct{}, 1),
+	}
+	a.blockMined([]string{"coinbase", "a"})
+	if n := a.floodPending(); n != 1 {
+		t.Errorf("floodPending got %d want 1", n)
+	}
+	select {
+	case <-a.floodBlock:
+	default:
+		t.Errorf("blockMined did not signal the flooder")
+	}
+	// signals are merged rather than blocking
+	a.blockMined(nil)
+	a.blockMined(nil)
+}
@@ -0,0 +1,100 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"time"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcutil"
+)
+
+// fundingTimeout is the time to wait for the utxos of the faucet to be
+// dequeued when collecting the inputs of the funding transaction
+const fundingTimeout = time.Second
+
+// fundActors sends amount to every actor without any utxo to spend, using
+// the utxos of the actor which has the most of them as a faucet, so that
+// every actor can take part in the simulation. It returns whether a funding
+// transaction was sent, the utxos of the faucet are queued back otherwise.
+func (com *Communication) fundActors(actors []*Actor, amount btcutil.Amount) bool {
+	var faucet *Actor
+	var needy []*Actor
+	for _, a := range actors {
+		n := a.utxoQueue.len()
+		if n == 0 {
+			needy = append(needy, a)
+			continue
+		}
+		if faucet == nil || n > faucet.utxoQueue.len() {
+			faucet = a
+		}
+	}
+	if len(needy) == 0 {
+		return false
+	}
+	if faucet == nil {
+		log.Errorf("Cannot fund actors: no actor has any utxo to spend")
+		return false
+	}
+
+	// collect enough utxos of the faucet to fund every needy actor
+	required := amount*btcutil.Amount(len(needy)) + minFee
+	var utxos []*TxOut
+	var inputs []btcjson.TransactionInput
+	var total btcutil.Amount
+collect:
+	for total < required {
+		select {
+		case utxo, ok := <-faucet.utxoQueue.dequeue:
+			if !ok {
+				break collect
+			}
+			utxos = append(utxos, utxo)
+			inputs = append(inputs, btcjson.TransactionInput{
+				Txid: utxo.OutPoint.Hash.String(),
+				Vout: utxo.OutPoint.Index,
+			})
+			total += utxo.Amount
+		case <-time.After(fundingTimeout):
+			break collect
+		case <-com.exit:
+			return false
+		}
+	}
+
+	// fund as many actors as the collected utxos allow
+	amounts := make(map[btcutil.Address]btcutil.Amount)
+	change := total - minFee
+	var funded int
+	for _, a := range needy {
+		if change < amount {
+			break
+		}
+		addr := a.ownedAddresses[com.rand.Int()%len(a.ownedAddresses)]
+		amounts[addr] = amount
+		change -= amount
+		funded++
+	}
+	if funded == 0 {
+		log.Errorf("%s: Cannot fund actors: not enough funds", faucet)
+		faucet.requeue(utxos)
+		return false
+	}
+	if change >= minFee {
+		addr := faucet.ownedAddresses[faucet.rand.Int()%len(faucet.ownedAddresses)]
+		amounts[addr] += change
+	}
+
+	msgTx, err := faucet.sendRawTransaction(inputs, amounts)
+	if err != nil {
+		log.Errorf("%s: Cannot send funding transaction: %v", faucet, err)
+		faucet.requeue(utxos)
+		return false
+	}
+	faucet.recordTx(msgTx, total, com.txStats.sent)
+	log.Infof("%s: Funded %d of %d actor(s) with %v each", faucet, funded, len(needy), amount)
+	return true
+}
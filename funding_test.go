@@ -0,0 +1,64 @@
+package btcsim
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+// queued returns the utxos queued to the actor
+func queued(a *Actor) []*TxOut {
+	var utxos []*TxOut
+	for {
+		select {
+		case u := <-a.utxoQueue.dequeue:
+			utxos = append(utxos, u)
+		default:
+			return utxos
+		}
+	}
+}
+
+func TestFundActors(t *testing.T) {
+	chain := NewMockChain(DefaultConfig())
+	faucet, needy := chain.NewActor("faucet"), chain.NewActor("needy")
+	defer StopMockActors(faucet, needy)
+	mineMock(t, faucet, faucet)
+	utxo := queued(faucet)[0]
+	faucet.utxoQueue.utxos = []*TxOut{utxo}
+	com := NewCommunication(DefaultConfig())
+	com.txStats.sent = make(chan *TxRecord, 1)
+	actors := []*Actor{faucet, needy}
+
+	// not enough funds for a single actor
+	faucet.utxoQueue.enqueue <- utxo
+	if com.fundActors(actors, utxo.Amount) {
+		t.Errorf("fundActors sent a transaction beyond the funds of the faucet")
+	}
+	if utxos := queued(faucet); len(utxos) != 1 || utxos[0] != utxo {
+		t.Errorf("faucet utxos %v not requeued after funding nobody", utxos)
+	}
+
+	// the funding transaction cannot be sent
+	unknown := &TxOut{OutPoint: wire.NewOutPoint(shaHash(1), 0), Amount: utxo.Amount}
+	faucet.utxoQueue.enqueue <- unknown
+	if com.fundActors(actors, btcutil.Amount(1e8)) {
+		t.Errorf("fundActors spent an unknown output")
+	}
+	if utxos := queued(faucet); len(utxos) != 1 || utxos[0] != unknown {
+		t.Errorf("faucet utxos %v not requeued after a failed send", utxos)
+	}
+
+	faucet.utxoQueue.enqueue <- utxo
+	if !com.fundActors(actors, btcutil.Amount(1e8)) {
+		t.Fatalf("fundActors sent no transaction")
+	}
+	if utxos := queued(faucet); len(utxos) != 0 {
+		t.Errorf("faucet utxos %v requeued after they were spent", utxos)
+	}
+	mineMock(t, faucet, faucet, needy)
+	if utxos := queued(needy); len(utxos) != 1 || utxos[0].Amount != 1e8 {
+		t.Errorf("needy actor got %v want one utxo of 1 BTC", utxos)
+	}
+}
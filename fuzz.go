@@ -0,0 +1,461 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+const (
+	// maxStandardTxSize is the largest transaction relayed by the nodes
+	// and maxStandardSigOps the most signature operations it may count
+	maxStandardTxSize = 100000
+	maxStandardSigOps = 4000
+
+	// multisigSigOps is the number of signature operations counted for a
+	// bare multisig output, whatever its number of keys
+	multisigSigOps = 20
+
+	// maxPadAttempts is the number of times a transaction is signed
+	// again while its padding is adjusted to the size of its case, as
+	// the size of the signature varies
+	maxPadAttempts = 4
+)
+
+// burnKey is a compressed public key whose x coordinate is zero, which is
+// not on the curve, so that the outputs paying it can never be spent
+var burnKey = append([]byte{0x02}, make([]byte, 32)...)
+
+// fuzzCase is an edge case of the relay policy of the nodes, applied to a
+// transaction paying an utxo back to its actor, at the given height
+type fuzzCase struct {
+	name  string
+	apply func(tx *wire.MsgTx, height int32)
+
+	// size is the size the signed transaction is padded to and sigOps
+	// the number of signature operations it is filled to, 0 to leave
+	// them as they are
+	size   int
+	sigOps int
+}
+
+// fuzzCases are the edge cases sent in turn, most of them on either side
+// of a limit. The transactions are standard but for the limit crossed by
+// the cases going over it.
+var fuzzCases = []*fuzzCase{
+	{name: "maxsigops", sigOps: maxStandardSigOps},
+	{name: "oversigops", sigOps: maxStandardSigOps + 1},
+	{name: "maxdata", apply: dataCase(txscript.MaxDataCarrierSize)},
+	{name: "overdata", apply: dataCase(txscript.MaxDataCarrierSize + 1)},
+	{name: "zerosequence", apply: func(tx *wire.MsgTx, height int32) {
+		setSequence(tx, 0)
+	}},
+	{name: "maxlocktime", apply: func(tx *wire.MsgTx, height int32) {
+		// ignored as every input is final
+		tx.LockTime = ^uint32(0)
+	}},
+	{name: "tiplocktime", apply: func(tx *wire.MsgTx, height int32) {
+		setSequence(tx, 0)
+		tx.LockTime = uint32(height)
+	}},
+	{name: "nextlocktime", apply: func(tx *wire.MsgTx, height int32) {
+		setSequence(tx, 0)
+		tx.LockTime = uint32(height + 1)
+	}},
+	{name: "maxsize", size: maxStandardTxSize},
+	{name: "oversize", size: maxStandardTxSize + 1},
+}
+
+// dataCase returns a case adding an OP_RETURN output embedding n bytes
+func dataCase(n int) func(*wire.MsgTx, int32) {
+	return func(tx *wire.MsgTx, height int32) {
+		script := []byte{txscript.OP_RETURN, txscript.OP_PUSHDATA1, byte(n)}
+		script = append(script, make([]byte, n)...)
+		tx.AddTxOut(wire.NewTxOut(0, script))
+	}
+}
+
+// relayFeeRate returns the minimum relay fee rate in satoshis per byte of
+// the nodes of the run configured by c
+func (c *Config) relayFeeRate() float64 {
+	if c.MinRelayFee >= 0 {
+		return c.MinRelayFee
+	}
+	return minRelayFeeRate
+}
+
+// dustLimit returns the smallest value of the output the nodes relay as
+// per their minimum relay fee rate
+func dustLimit(txOut *wire.TxOut, rate float64) int64 {
+	return int64(math.Ceil(3 * float64(txOut.SerializeSize()+148) * rate))
+}
+
+// addOutput adds an output worth the dust limit at the given relay fee
+// rate with the given script
+func addOutput(tx *wire.MsgTx, script []byte, rate float64) {
+	txOut := wire.NewTxOut(0, script)
+	txOut.Value = dustLimit(txOut, rate)
+	tx.AddTxOut(txOut)
+}
+
+// addSigOps adds n signature operations to the transaction with bare 1-of-1
+// multisig outputs, and pay-to-pubkey outputs for the rest, which pay
+// burnKey the dust limit at the given relay fee rate
+func addSigOps(tx *wire.MsgTx, n int, rate float64) {
+	multisig := []byte{txscript.OP_1, byte(len(burnKey))}
+	multisig = append(multisig, burnKey...)
+	multisig = append(multisig, txscript.OP_1, txscript.OP_CHECKMULTISIG)
+	for ; n >= multisigSigOps; n -= multisigSigOps {
+		addOutput(tx, multisig, rate)
+	}
+	pubKey := append([]byte{byte(len(burnKey))}, burnKey...)
+	pubKey = append(pubKey, txscript.OP_CHECKSIG)
+	for ; n > 0; n-- {
+		addOutput(tx, pubKey, rate)
+	}
+}
+
+// addPadding adds outputs serializing to n bytes, at least nullDataPad, to
+// the transaction: outputs paying the given script the dust limit at the
+// given relay fee rate and an OP_RETURN output for the bytes left
+func addPadding(tx *wire.MsgTx, n int, script []byte, rate float64) {
+	if n < nullDataPad {
+		n = nullDataPad
+	}
+	size := wire.NewTxOut(0, script).SerializeSize()
+	rest := nullDataPad + (n-nullDataPad)%size
+	for i := 0; i < (n-rest)/size; i++ {
+		addOutput(tx, script, rate)
+	}
+	// the value, the length of the script and the script
+	data := make([]byte, rest-9)
+	data[0] = txscript.OP_RETURN
+	if len(data) > 1 {
+		data[1] = byte(len(data) - 2)
+	}
+	tx.AddTxOut(wire.NewTxOut(0, data))
+}
+
+// nullDataPad is the size of the smallest OP_RETURN output
+const nullDataPad = 10
+
+// outputSigOps returns the signature operations counted for the outputs of
+// the transaction
+func outputSigOps(tx *wire.MsgTx) int {
+	var n int
+	for _, txOut := range tx.TxOut {
+		n += txscript.GetSigOpCount(txOut.PkScript)
+	}
+	return n
+}
+
+// inputSigOps returns the signature operations counted for the inputs of
+// the transaction, which spend outputs with the given script
+func inputSigOps(tx *wire.MsgTx, prevScript []byte) int {
+	var n int
+	for _, txIn := range tx.TxIn {
+		n += txscript.GetPreciseSigOpCount(txIn.SignatureScript, prevScript, true)
+	}
+	return n
+}
+
+// setSequence sets the sequence number of every input of the transaction
+func setSequence(tx *wire.MsgTx, seq uint32) {
+	for _, txIn := range tx.TxIn {
+		txIn.Sequence = seq
+	}
+}
+
+// FuzzStats counts the transactions of an edge case sent to the node and
+// how they fared
+type FuzzStats struct {
+	Case string `json:"case"`
+
+	// Sent is the number of transactions sent, Accepted and Rejected the
+	// number the node accepted and rejected, with the last reason given
+	Sent      int    `json:"sent"`
+	Accepted  int    `json:"accepted"`
+	Rejected  int    `json:"rejected"`
+	Rejection string `json:"rejection,omitempty"`
+
+	// Relayed is the number of transactions accepted by another node than
+	// the first, which requires several nodes, and Mined the number mined
+	Relayed int `json:"relayed"`
+	Mined   int `json:"mined"`
+}
+
+// String returns the statistics as a single line
+func (s *FuzzStats) String() string {
+	line := fmt.Sprintf("%d sent, %d accepted, %d relayed, %d mined, %d rejected",
+		s.Sent, s.Accepted, s.Relayed, s.Mined, s.Rejected)
+	if s.Rejection != "" {
+		line += fmt.Sprintf(" (%s)", s.Rejection)
+	}
+	return line
+}
+
+// fuzzTx is a transaction of an edge case not mined yet
+type fuzzTx struct {
+	fuzzCase string
+	nodes    map[int]bool
+}
+
+// fuzzTracker follows the transactions of the edge cases from the nodes
+// accepting them to the blocks they are mined in
+type fuzzTracker struct {
+	mtx   sync.Mutex
+	txs   map[string]*fuzzTx
+	stats map[string]*FuzzStats
+}
+
+// newFuzzTracker returns a tracker of the edge cases
+func newFuzzTracker() *fuzzTracker {
+	stats := make(map[string]*FuzzStats, len(fuzzCases))
+	for _, c := range fuzzCases {
+		stats[c.name] = &FuzzStats{Case: c.name}
+	}
+	return &fuzzTracker{
+		txs:   make(map[string]*fuzzTx),
+		stats: stats,
+	}
+}
+
+// subscribe subscribes the tracker to the transactions accepted by the
+// nodes and to the blocks mined
+func (t *fuzzTracker) subscribe(events *eventBus) {
+	events.subscribe(t.accepted, eventTxAccepted)
+	events.subscribe(t.mined, eventBlockMined)
+}
+
+// sending records a transaction of a case about to be sent, before the
+// nodes may notify it
+func (t *fuzzTracker) sending(txid, name string) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	t.txs[txid] = &fuzzTx{fuzzCase: name, nodes: make(map[int]bool)}
+	t.stats[name].Sent++
+}
+
+// sent records whether the node accepted a transaction sent
+func (t *fuzzTracker) sent(txid string, err error) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	tx, ok := t.txs[txid]
+	if !ok {
+		return
+	}
+	s := t.stats[tx.fuzzCase]
+	if err != nil {
+		s.Rejected++
+		s.Rejection = err.Error()
+		delete(t.txs, txid)
+		return
+	}
+	s.Accepted++
+}
+
+// accepted records a node accepting a transaction of a case, which is
+// relayed once a second node accepts it
+func (t *fuzzTracker) accepted(e *Event) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	tx, ok := t.txs[e.Hash.String()]
+	if !ok || tx.nodes[e.Node] {
+		return
+	}
+	tx.nodes[e.Node] = true
+	if len(tx.nodes) == 2 {
+		t.stats[tx.fuzzCase].Relayed++
+	}
+}
+
+// mined records the transactions of the cases mined in a block
+func (t *fuzzTracker) mined(e *Event) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	for _, txid := range e.Block.txids {
+		if tx, ok := t.txs[txid]; ok {
+			t.stats[tx.fuzzCase].Mined++
+			delete(t.txs, txid)
+		}
+	}
+}
+
+// Stats returns the statistics of the cases sent so far in the order of
+// fuzzCases
+func (t *fuzzTracker) Stats() []*FuzzStats {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	var stats []*FuzzStats
+	for _, c := range fuzzCases {
+		if s := *t.stats[c.name]; s.Sent > 0 {
+			stats = append(stats, &s)
+		}
+	}
+	return stats
+}
+
+// fuzzPolicy runs as a goroutine and, on average every interval, picks a running
+// actor which sends a transaction of the next edge case, until the
+// simulation exits
+func (com *Communication) fuzzPolicy(t *fuzzTracker, interval time.Duration) {
+	defer com.wg.Done()
+
+	r := com.cfg.newRand(fuzzStream)
+	next := 0
+	for {
+		select {
+		case <-time.After(time.Duration(r.ExpFloat64() * float64(interval))):
+		case <-com.exit:
+			return
+		}
+
+		var running []*Actor
+		for _, a := range com.Actors() {
+			if a.running() && a.floodTarget == 0 && !a.Paused() {
+				running = append(running, a)
+			}
+		}
+		if len(running) == 0 {
+			continue
+		}
+		c := fuzzCases[next%len(fuzzCases)]
+		sent, err := com.sendFuzzTx(t, running[r.Intn(len(running))], c)
+		if err != nil {
+			log.Debugf("Cannot create transaction of fuzz case %s: %v", c.name, err)
+		}
+		if sent {
+			next++
+		}
+	}
+}
+
+// sendFuzzTx sends a transaction of the case spending an utxo of the actor
+// and records the outcome, it returns whether it was sent
+func (com *Communication) sendFuzzTx(t *fuzzTracker, a *Actor, c *fuzzCase) (bool, error) {
+	var utxo *TxOut
+	select {
+	case u, ok := <-a.utxoQueue.dequeue:
+		if !ok {
+			return false, nil
+		}
+		utxo = u
+	default:
+		log.Debugf("%s: No utxo to create a fuzz transaction from", a)
+		return false, nil
+	}
+
+	tx, err := a.createFuzzTx(utxo, c, com.mempool.Height())
+	if err != nil {
+		a.requeue([]*TxOut{utxo})
+		return false, err
+	}
+	hash := tx.TxSha()
+	com.untracked.add(hash)
+	t.sending(hash.String(), c.name)
+	_, err = a.client.SendRawTransaction(tx, false)
+	t.sent(hash.String(), err)
+	if err != nil {
+		log.Debugf("%s: Fuzz case %s rejected: %v", a, c.name, err)
+		com.untracked.take(hash)
+		a.requeue([]*TxOut{utxo})
+		return true, nil
+	}
+	com.recordTx(a, tx, utxo.Amount)
+	return true, nil
+}
+
+// createFuzzTx creates and signs a transaction of the case paying the utxo
+// back to the actor, less the fee and the outputs the case adds. The
+// outputs padding a transaction to a size pay the actor too, while the
+// outputs filling it with signature operations are burnt. A transaction is
+// signed again until it has the size and signature operations of its case,
+// or maxPadAttempts times.
+func (a *Actor) createFuzzTx(utxo *TxOut, c *fuzzCase, height int32) (*wire.MsgTx, error) {
+	// a p2sh input counts the signature operations of its redeem script
+	var prevScript []byte
+	if c.sigOps > 0 {
+		res, err := a.client.GetTxOut(&utxo.OutPoint.Hash, utxo.OutPoint.Index, true)
+		if err != nil {
+			return nil, err
+		}
+		if res == nil {
+			return nil, fmt.Errorf("output %v is spent", utxo.OutPoint)
+		}
+		if prevScript, err = hex.DecodeString(res.ScriptPubKey.Hex); err != nil {
+			return nil, err
+		}
+	}
+
+	inputs := []btcjson.TransactionInput{{
+		Txid: utxo.OutPoint.Hash.String(),
+		Vout: utxo.OutPoint.Index,
+	}}
+	tx, err := a.client.CreateRawTransaction(inputs,
+		map[btcutil.Address]btcutil.Amount{a.Address(): utxo.Amount})
+	if err != nil {
+		return nil, err
+	}
+	if c.apply != nil {
+		c.apply(tx, height)
+	}
+	script := tx.TxOut[0].PkScript
+	outs := tx.TxOut
+
+	pad := c.size - estimateTxSize(1, 1)
+	for _, txOut := range outs[1:] {
+		pad -= txOut.SerializeSize()
+	}
+	in := 0
+	for i := 0; ; i++ {
+		tx.TxOut = append([]*wire.TxOut(nil), outs...)
+		if c.sigOps > 0 {
+			addSigOps(tx, c.sigOps-in-outputSigOps(tx), a.cfg.relayFeeRate())
+		}
+		if c.size > 0 {
+			addPadding(tx, pad, script, a.cfg.relayFeeRate())
+		}
+		var value int64
+		for _, txOut := range tx.TxOut[1:] {
+			value += txOut.Value
+		}
+		size := c.size
+		if size == 0 {
+			size = estimateTxSize(1, 1)
+			for _, txOut := range tx.TxOut[1:] {
+				size += txOut.SerializeSize()
+			}
+		}
+		change := utxo.Amount - btcutil.Amount(value) - a.feeForSize(size)
+		if change < minFee {
+			return nil, ErrInsufficientFunds
+		}
+		tx.TxOut[0].Value = int64(change)
+
+		signed, ok, err := a.signRawTransaction(tx.Copy(), nil)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, ErrIncompleteSignature
+		}
+		diff := c.size - signed.SerializeSize()
+		signedIn := inputSigOps(signed, prevScript)
+		if (c.size == 0 || diff == 0) && signedIn == in || i == maxPadAttempts-1 {
+			return signed, nil
+		}
+		pad += diff
+		in = signedIn
+	}
+}
@@ -0,0 +1,166 @@
+package btcsim
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// relayError returns why the nodes would not relay the transaction as per
+// the limits of their policy the fuzz cases exercise, nil if they would
+func relayError(tx *wire.MsgTx, prevScript []byte) error {
+	if size := tx.SerializeSize(); size > maxStandardTxSize {
+		return fmt.Errorf("size %d", size)
+	}
+	if n := inputSigOps(tx, prevScript) + outputSigOps(tx); n > maxStandardSigOps {
+		return fmt.Errorf("sigops %d", n)
+	}
+	var nullData int
+	for i, txOut := range tx.TxOut {
+		switch txscript.GetScriptClass(txOut.PkScript) {
+		case txscript.NonStandardTy:
+			return fmt.Errorf("output %d non-standard", i)
+		case txscript.NullDataTy:
+			if nullData++; nullData > 1 {
+				return errors.New("several data outputs")
+			}
+			if len(txOut.PkScript) > txscript.MaxDataCarrierSize+3 {
+				return fmt.Errorf("data %d", len(txOut.PkScript))
+			}
+			continue
+		}
+		if txOut.Value < dustLimit(txOut, minRelayFeeRate) {
+			return fmt.Errorf("output %d dust", i)
+		}
+	}
+	return nil
+}
+
+func TestCreateFuzzTx(t *testing.T) {
+	chain := NewMockChain(DefaultConfig())
+	a := chain.NewActor("a")
+	defer StopMockActors(a)
+	mineMock(t, a, a)
+	utxo := <-a.utxoQueue.dequeue
+
+	for _, c := range fuzzCases {
+		tx, err := a.createFuzzTx(utxo, c, 10)
+		if err != nil {
+			t.Errorf("%s: createFuzzTx error: %v", c.name, err)
+			continue
+		}
+		if len(tx.TxIn) != 1 || tx.TxIn[0].PreviousOutPoint != *utxo.OutPoint ||
+			len(tx.TxIn[0].SignatureScript) == 0 {
+			t.Errorf("%s: transaction does not spend the utxo", c.name)
+		}
+		var out int64
+		for _, txOut := range tx.TxOut {
+			out += txOut.Value
+		}
+		if fee := int64(utxo.Amount) - out; fee <= 0 || fee > int64(a.feeForSize(maxStandardTxSize+1)) {
+			t.Errorf("%s: fee of %d", c.name, fee)
+		}
+
+		// only the cases going over a limit cross it, and only that one
+		err = relayError(tx, nil)
+		var want string
+		switch c.name {
+		case "oversigops":
+			want = "sigops 4001"
+		case "overdata":
+			want = "data 84"
+		case "oversize":
+			want = "size 100001"
+		}
+		if (err == nil) != (want == "") || err != nil && err.Error() != want {
+			t.Errorf("%s: relay error %v want %q", c.name, err, want)
+		}
+		switch c.name {
+		case "maxsigops":
+			if n := outputSigOps(tx); n != maxStandardSigOps {
+				t.Errorf("%s: %d sigops want %d", c.name, n, maxStandardSigOps)
+			}
+		case "maxsize":
+			if n := tx.SerializeSize(); n != maxStandardTxSize {
+				t.Errorf("%s: size %d want %d", c.name, n, maxStandardTxSize)
+			}
+		case "tiplocktime", "nextlocktime":
+			want := uint32(10)
+			if c.name == "nextlocktime" {
+				want++
+			}
+			if tx.LockTime != want || tx.TxIn[0].Sequence != 0 {
+				t.Errorf("%s: lock time %d and sequence %d want %d and 0",
+					c.name, tx.LockTime, tx.TxIn[0].Sequence, want)
+			}
+		case "maxlocktime":
+			if tx.LockTime != ^uint32(0) || tx.TxIn[0].Sequence != wire.MaxTxInSequenceNum {
+				t.Errorf("%s: lock time %d and sequence %d want both final",
+					c.name, tx.LockTime, tx.TxIn[0].Sequence)
+			}
+		}
+	}
+
+	utxo.Amount = minFee
+	if _, err := a.createFuzzTx(utxo, fuzzCases[0], 10); err != ErrInsufficientFunds {
+		t.Errorf("createFuzzTx of a tiny utxo error %v want %v", err, ErrInsufficientFunds)
+	}
+}
+
+func TestInputSigOps(t *testing.T) {
+	// a p2sh input spending a 1-of-1 multisig counts one
+	redeem := []byte{txscript.OP_1, byte(len(burnKey))}
+	redeem = append(redeem, burnKey...)
+	redeem = append(redeem, txscript.OP_1, txscript.OP_CHECKMULTISIG)
+	sigScript := append([]byte{txscript.OP_0, 1, 0x30, byte(len(redeem))}, redeem...)
+	tx := wire.NewMsgTx()
+	tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(shaHash(1), 0), sigScript))
+
+	p2sh := append([]byte{txscript.OP_HASH160, 20}, make([]byte, 20)...)
+	p2sh = append(p2sh, txscript.OP_EQUAL)
+	if n := inputSigOps(tx, p2sh); n != 1 {
+		t.Errorf("p2sh input got %d sigops want 1", n)
+	}
+	if n := inputSigOps(tx, nil); n != 0 {
+		t.Errorf("push-only input got %d sigops want 0", n)
+	}
+}
+
+func TestFuzzTracker(t *testing.T) {
+	events := newEventBus()
+	tr := newFuzzTracker()
+	tr.subscribe(events)
+
+	relayed, rejected, pending := shaHash(1), shaHash(2), shaHash(3)
+	tr.sending(relayed.String(), "maxsize")
+	events.publish(&Event{Kind: eventTxAccepted, Hash: relayed, Node: 0})
+	tr.sent(relayed.String(), nil)
+	events.publish(&Event{Kind: eventTxAccepted, Hash: relayed, Node: 0})
+	events.publish(&Event{Kind: eventTxAccepted, Hash: relayed, Node: 2})
+	tr.sending(rejected.String(), "oversize")
+	tr.sent(rejected.String(), errors.New("too large"))
+	tr.sending(pending.String(), "maxsize")
+	tr.sent(pending.String(), nil)
+	events.publish(&Event{Kind: eventBlockMined, Block: &blockTxs{
+		txids: []string{relayed.String(), rejected.String()},
+	}})
+
+	stats := tr.Stats()
+	if len(stats) != 2 || stats[0].Case != "maxsize" || stats[1].Case != "oversize" {
+		t.Fatalf("Stats got %v want maxsize and oversize", stats)
+	}
+	want := FuzzStats{Case: "maxsize", Sent: 2, Accepted: 2, Relayed: 1, Mined: 1}
+	if *stats[0] != want {
+		t.Errorf("maxsize got %+v want %+v", *stats[0], want)
+	}
+	want = FuzzStats{Case: "oversize", Sent: 1, Rejected: 1, Rejection: "too large"}
+	if *stats[1] != want {
+		t.Errorf("oversize got %+v want %+v", *stats[1], want)
+	}
+	if s := stats[1].String(); s != "1 sent, 0 accepted, 0 relayed, 0 mined, 1 rejected (too large)" {
+		t.Errorf("String got %q", s)
+	}
+}
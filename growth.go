@@ -0,0 +1,158 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// minGrowthInterval is the shortest interval between two adjustments of the
+// number of actors to a growth schedule
+const minGrowthInterval = time.Second
+
+// actorGrowth is a schedule growing or shrinking the number of actors
+// linearly from the number started to a target over a duration, modeling
+// the adoption of a system by its users
+type actorGrowth struct {
+	from, to int
+	over     time.Duration
+}
+
+// parseGrowth parses a growth schedule given as the target number of actors
+// and the duration to reach it over, e.g. "200:30m", from the number of
+// actors started
+func parseGrowth(s string, from int) (*actorGrowth, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid growth %q, expected actors:duration", s)
+	}
+	to, err := strconv.Atoi(parts[0])
+	if err != nil || to < 1 {
+		return nil, fmt.Errorf("invalid growth target %q, at least one actor is required", parts[0])
+	}
+	over, err := time.ParseDuration(parts[1])
+	if err != nil || over <= 0 {
+		return nil, fmt.Errorf("invalid growth duration %q", parts[1])
+	}
+	return &actorGrowth{from: from, to: to, over: over}, nil
+}
+
+// target returns the number of actors scheduled after elapsed
+func (g *actorGrowth) target(elapsed time.Duration) int {
+	if elapsed >= g.over {
+		return g.to
+	}
+	delta := float64(g.to-g.from) * float64(elapsed) / float64(g.over)
+	return g.from + int(delta)
+}
+
+// interval returns the interval between the adjustments of the number of
+// actors, the time it takes the schedule to add or remove one
+func (g *actorGrowth) interval() time.Duration {
+	steps := g.to - g.from
+	if steps < 0 {
+		steps = -steps
+	}
+	if steps == 0 {
+		return g.over
+	}
+	interval := g.over / time.Duration(steps)
+	if interval < minGrowthInterval {
+		interval = minGrowthInterval
+	}
+	return interval
+}
+
+// autoscale runs as a goroutine and adjusts the number of actors to the
+// growth schedule, the duration of which is simulated time, until it
+// reaches its target
+func (com *Communication) autoscale(g *actorGrowth, miner *Miner) {
+	defer com.wg.Done()
+
+	log.Infof("Scaling from %d to %d actors over %v", g.from, g.to, g.over)
+	start := time.Now()
+	over := com.cfg.realDuration(g.over)
+	ticker := time.NewTicker(com.cfg.realDuration(g.interval()))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			// the schedule runs in simulated time
+			elapsed := time.Duration(float64(time.Since(start)) / float64(over) * float64(g.over))
+			if _, err := com.scaleActors(g.target(elapsed), miner); err != nil {
+				log.Errorf("Cannot scale actors: %v", err)
+			}
+			if elapsed >= g.over {
+				log.Infof("Scaled to %d actors", len(com.Actors()))
+				return
+			}
+		case <-com.exit:
+			return
+		}
+	}
+}
+
+// scaleActors starts or removes actors until n run, and returns the number
+// of actors added, negative when some were removed. New actors are passive
+// and take the profile of an actor drawn at random. The most recently added
+// actors are removed first, except flooders and pools.
+func (com *Communication) scaleActors(n int, miner *Miner) (int, error) {
+	if n < 1 {
+		return 0, errors.New("at least one actor is required")
+	}
+	com.scaleMtx.Lock()
+	defer com.scaleMtx.Unlock()
+
+	actors := com.Actors()
+	if len(actors) == 0 {
+		return 0, errors.New("no actor left to scale from")
+	}
+	if n < len(actors) {
+		removed := 0
+		for i := len(actors) - 1; i >= 0 && len(actors)-removed > n; i-- {
+			a := actors[i]
+			if a.floodTarget > 0 || isPool(a.behaviorName) {
+				continue
+			}
+			if com.removeActor(a) {
+				a.Shutdown()
+				removed++
+			}
+		}
+		return -removed, nil
+	}
+
+	var wg sync.WaitGroup
+	added := make(chan *Actor, n-len(actors))
+	errs := make(chan error, n-len(actors))
+	for i := len(actors); i < n; i++ {
+		profile := actors[com.rand.Intn(len(actors))].profile
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a, err := com.startActor(profile, behaviorPassive, "", miner.client)
+			if err != nil {
+				errs <- err
+				return
+			}
+			com.addActor(a)
+			com.wg.Add(1)
+			go com.watchActor(a)
+			added <- a
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	var err error
+	for e := range errs {
+		err = e
+	}
+	return len(added), err
+}
@@ -0,0 +1,93 @@
+package btcsim
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseGrowth(t *testing.T) {
+	g, err := parseGrowth("200:30m", 10)
+	if err != nil {
+		t.Fatalf("parseGrowth error: %v", err)
+	}
+	if g.from != 10 || g.to != 200 || g.over != 30*time.Minute {
+		t.Errorf("parseGrowth = %+v", g)
+	}
+	for _, s := range []string{"200", "0:30m", "x:30m", "200:soon", "200:-5m"} {
+		if _, err := parseGrowth(s, 10); err == nil {
+			t.Errorf("parseGrowth(%q) succeeded", s)
+		}
+	}
+}
+
+func TestGrowthTarget(t *testing.T) {
+	grow := &actorGrowth{from: 10, to: 200, over: 30 * time.Minute}
+	shrink := &actorGrowth{from: 50, to: 10, over: 10 * time.Minute}
+	tests := []struct {
+		g       *actorGrowth
+		elapsed time.Duration
+		want    int
+	}{
+		{grow, 0, 10},
+		{grow, 15 * time.Minute, 105},
+		{grow, 30 * time.Minute, 200},
+		{grow, time.Hour, 200},
+		{shrink, 5 * time.Minute, 30},
+		{shrink, 10 * time.Minute, 10},
+	}
+	for _, test := range tests {
+		if got := test.g.target(test.elapsed); got != test.want {
+			t.Errorf("target of %+v after %v = %d, want %d", test.g, test.elapsed, got, test.want)
+		}
+	}
+	if got := grow.interval(); got != 30*time.Minute/190 {
+		t.Errorf("interval = %v, want %v", got, 30*time.Minute/190)
+	}
+	fast := &actorGrowth{from: 1, to: 1000, over: time.Minute}
+	if got := fast.interval(); got != minGrowthInterval {
+		t.Errorf("interval of a fast growth = %v, want %v", got, minGrowthInterval)
+	}
+}
+
+func TestScaleActorsDown(t *testing.T) {
+	com := NewCommunication(DefaultConfig())
+	a, b, c, d := fakeActor("a"), fakeActor("b"), fakeActor("c"), fakeActor("d")
+	c.behaviorName = behaviorPool
+	com.actors = []*Actor{a, b, c, d}
+
+	delta, err := com.scaleActors(2, nil)
+	if err != nil || delta != -2 {
+		t.Fatalf("scaleActors = %d, %v, want 2 actors removed", delta, err)
+	}
+	// the most recent actors are removed first, except the pool
+	if actors := com.Actors(); len(actors) != 2 || actors[0] != a || actors[1] != c {
+		t.Errorf("actors left %v, want a and the pool", actors)
+	}
+	for _, removed := range []*Actor{b, d} {
+		select {
+		case <-removed.quit:
+		default:
+			t.Errorf("%s not shut down", removed)
+		}
+	}
+	if _, err := com.scaleActors(0, nil); err == nil {
+		t.Errorf("scaleActors to no actor succeeded")
+	}
+
+	ctl := newController(com, nil)
+	r, _ := http.NewRequest("POST", "/actors/scale?count=1", nil)
+	w := httptest.NewRecorder()
+	ctl.handler().ServeHTTP(w, r)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "scaled by -1 to 1 actors") {
+		t.Errorf("/actors/scale returned %d %q", w.Code, w.Body.String())
+	}
+	r, _ = http.NewRequest("POST", "/actors/scale?count=none", nil)
+	w = httptest.NewRecorder()
+	ctl.handler().ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("/actors/scale with an invalid count returned %d", w.Code)
+	}
+}
@@ -0,0 +1,232 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"errors"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcutil"
+	"github.com/btcsuite/btcutil/hdkeychain"
+)
+
+// checkHDWallets returns an error if the actors cannot derive their
+// addresses from extended keys. btcwallet cannot import extended keys yet
+// so only in-process wallets can until it does.
+func checkHDWallets(hd bool, gap uint32, inProcess bool) error {
+	if !hd {
+		return nil
+	}
+	if !inProcess {
+		return errors.New("HD wallet actors need -inprocess until btcwallet " +
+			"can import extended keys")
+	}
+	if gap == 0 {
+		return errors.New("the gap limit of HD wallets must be positive")
+	}
+	return nil
+}
+
+// hdAccount derives the keys of an in-process wallet from a BIP32
+// extended key, along the external branch of the first account, m/0'/0/i.
+// It keeps a lookahead of gap keys derived beyond the next unused index,
+// which are watched for payments but not handed out yet.
+type hdAccount struct {
+	branch *hdkeychain.ExtendedKey
+	gap    uint32
+
+	// keys are the keys derived so far by index, nil for the indexes
+	// without a valid child, next is the index of the next key handed out
+	keys []*btcec.PrivateKey
+	next uint32
+}
+
+// newHDAccount returns the account of the master key of seed on the
+// network of params with the lookahead of the given gap limit derived
+func newHDAccount(seed []byte, gap uint32, params *chaincfg.Params) (*hdAccount, error) {
+	master, err := hdkeychain.NewMaster(seed, params)
+	if err != nil {
+		return nil, err
+	}
+	account, err := master.Child(hdkeychain.HardenedKeyStart)
+	if err != nil {
+		return nil, err
+	}
+	branch, err := account.Child(0)
+	if err != nil {
+		return nil, err
+	}
+	h := &hdAccount{branch: branch, gap: gap}
+	if err := h.extend(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// extend derives the keys up to the gap limit beyond the next index
+func (h *hdAccount) extend() error {
+	for uint32(len(h.keys)) < h.next+h.gap {
+		child, err := h.branch.Child(uint32(len(h.keys)))
+		if err == hdkeychain.ErrInvalidChild {
+			// BIP32 skips the indexes without a valid child
+			h.keys = append(h.keys, nil)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		key, err := child.ECPrivKey()
+		if err != nil {
+			return err
+		}
+		h.keys = append(h.keys, key)
+	}
+	return nil
+}
+
+// use marks the key of index i as used, which moves the next index past
+// it and extends the lookahead, and reports whether the key was ahead of
+// the next index
+func (h *hdAccount) use(i uint32) (bool, error) {
+	if i < h.next {
+		return false, nil
+	}
+	h.next = i + 1
+	return true, h.extend()
+}
+
+// nextKey returns the key of the next unused index and marks it used
+func (h *hdAccount) nextKey() (uint32, *btcec.PrivateKey, error) {
+	for {
+		i := h.next
+		if _, err := h.use(i); err != nil {
+			return 0, nil, err
+		}
+		if key := h.keys[i]; key != nil {
+			return i, key, nil
+		}
+	}
+}
+
+// newHDWallet returns an in-process wallet on the network of params deriving
+// its keys from the master key of seed, it watches the pay-to-pubkey-hash
+// addresses of the lookahead keys
+func newHDWallet(seed []byte, gap uint32, params *chaincfg.Params) (*memWallet, error) {
+	hd, err := newHDAccount(seed, gap, params)
+	if err != nil {
+		return nil, err
+	}
+	w := newMemWallet(params)
+	w.hd = hd
+	w.indexes = make(map[string]uint32)
+	if err := w.watchLookahead(0); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// watchLookahead adds the keys derived from index from on to the keys of
+// the wallet under their pay-to-pubkey-hash addresses, the lock must be
+// held by the caller once the wallet is in use
+func (w *memWallet) watchLookahead(from int) error {
+	for i := from; i < len(w.hd.keys); i++ {
+		key := w.hd.keys[i]
+		if key == nil {
+			continue
+		}
+		pkHash := btcutil.Hash160(key.PubKey().SerializeCompressed())
+		addr, err := btcutil.NewAddressPubKeyHash(pkHash, w.params)
+		if err != nil {
+			return err
+		}
+		w.keys[addr.EncodeAddress()] = &memKey{priv: key, compressed: true}
+		w.indexes[addr.EncodeAddress()] = uint32(i)
+	}
+	return nil
+}
+
+// rescan looks for the outputs paying the derived addresses of the wallet
+// in the blocks of the chain up to the given height. A payment to a key of
+// the lookahead marks it used and extends the lookahead, so addresses
+// further away than the gap limit are only found if the ones in between
+// are used. It returns the number of outputs found.
+func (w *memWallet) rescan(client *rpcClient, height int64) (int, error) {
+	found := 0
+	for h := int64(1); h <= height; h++ {
+		hash, err := client.GetBlockHash(h)
+		if err != nil {
+			return found, err
+		}
+		block, err := client.GetBlock(hash)
+		if err != nil {
+			return found, err
+		}
+		for _, tx := range block.Transactions() {
+			for _, txOut := range tx.MsgTx().TxOut {
+				_, addrs, _, err := txscript.ExtractPkScriptAddrs(txOut.PkScript,
+					w.params)
+				if err != nil {
+					continue
+				}
+				for _, addr := range addrs {
+					ok, err := w.markUsed(addr)
+					if err != nil {
+						return found, err
+					}
+					if ok {
+						found++
+					}
+				}
+			}
+		}
+	}
+	return found, nil
+}
+
+// markUsed marks the derived key of addr used and reports whether addr is
+// one of the derived addresses of the wallet
+func (w *memWallet) markUsed(addr btcutil.Address) (bool, error) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	i, ok := w.indexes[addr.EncodeAddress()]
+	if !ok {
+		return false, nil
+	}
+	derived := len(w.hd.keys)
+	if _, err := w.hd.use(i); err != nil {
+		return true, err
+	}
+	return true, w.watchLookahead(derived)
+}
+
+// startHDWallet replaces the in-process wallet of the actor by an HD
+// wallet with a seed of the random source of the actor, and rescans the
+// chain for payments to its derived addresses as a restored wallet would,
+// so that the addresses it creates skip the ones already used
+func (a *Actor) startHDWallet(gap uint32) error {
+	seed := make([]byte, hdkeychain.RecommendedSeedLen)
+	for i := range seed {
+		seed[i] = byte(a.rand.Intn(256))
+	}
+	w, err := newHDWallet(seed, gap, a.cfg.netParams())
+	if err != nil {
+		return err
+	}
+	a.wallet = w
+
+	height, err := a.client.GetBlockCount()
+	if err != nil {
+		return err
+	}
+	found, err := w.rescan(a.client, height)
+	if err != nil {
+		return err
+	}
+	log.Debugf("%s: Rescanned %d blocks, found %d outputs to derived addresses",
+		a, height, found)
+	return nil
+}
@@ -0,0 +1,71 @@
+package btcsim
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+)
+
+func TestCheckHDWallets(t *testing.T) {
+	if err := checkHDWallets(false, 0, false); err != nil {
+		t.Errorf("checkHDWallets error without HD wallets: %v", err)
+	}
+	if err := checkHDWallets(true, 20, true); err != nil {
+		t.Errorf("checkHDWallets error: %v", err)
+	}
+	if err := checkHDWallets(true, 20, false); err == nil {
+		t.Errorf("checkHDWallets expected error with btcwallet actors")
+	}
+	if err := checkHDWallets(true, 0, true); err == nil {
+		t.Errorf("checkHDWallets expected error without gap limit")
+	}
+}
+
+func TestHDAccountGapLimit(t *testing.T) {
+	h, err := newHDAccount(make([]byte, 32), 5, &chaincfg.SimNetParams)
+	if err != nil {
+		t.Fatalf("newHDAccount error: %v", err)
+	}
+	if len(h.keys) != 5 || h.next != 0 {
+		t.Fatalf("got %d keys and next index %d, want 5 and 0", len(h.keys), h.next)
+	}
+
+	i, key, err := h.nextKey()
+	if err != nil || i != 0 || key == nil {
+		t.Fatalf("nextKey got %d, %v, %v", i, key, err)
+	}
+	if len(h.keys) != 6 {
+		t.Errorf("got %d keys after the first one is used, want 6", len(h.keys))
+	}
+
+	// a payment to the end of the lookahead extends it by the gap limit
+	ahead, err := h.use(5)
+	if err != nil || !ahead {
+		t.Fatalf("use(5) got %v, %v", ahead, err)
+	}
+	if h.next != 6 || len(h.keys) != 11 {
+		t.Errorf("got next index %d and %d keys, want 6 and 11", h.next, len(h.keys))
+	}
+	if ahead, _ := h.use(2); ahead {
+		t.Errorf("use(2) of a key before the next index reported ahead")
+	}
+	if h.next != 6 {
+		t.Errorf("got next index %d after using an older key, want 6", h.next)
+	}
+}
+
+func TestHDWallet(t *testing.T) {
+	w, err := newHDWallet(make([]byte, 32), 3, &chaincfg.SimNetParams)
+	if err != nil {
+		t.Fatalf("newHDWallet error: %v", err)
+	}
+	if _, err := w.newAddress(addrP2PKH); err != nil {
+		t.Fatalf("newAddress error: %v", err)
+	}
+	if w.hd.next != 1 || len(w.hd.keys) != 4 {
+		t.Errorf("got next index %d and %d keys, want 1 and 4", w.hd.next, len(w.hd.keys))
+	}
+	if ok, err := w.markUsed(fakeAddress("unknown")); ok || err != nil {
+		t.Errorf("markUsed of an unknown address got %v, %v", ok, err)
+	}
+}
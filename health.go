@@ -0,0 +1,200 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// txStallTimeout is the time after which Communicate stops waiting for
+// actors to take or send the requested transactions when none makes
+// progress, because the actors holding them may have died
+const txStallTimeout = time.Minute
+
+// watchActors runs as a goroutine and removes failed actors from the
+// simulation, replacing them when replaceActors is set. The simulation
+// exits once no actor is left.
+func (com *Communication) watchActors() {
+	defer com.wg.Done()
+
+	var replacing int
+	replaced := make(chan struct{})
+	for {
+		select {
+		case a := <-com.errChan:
+			if !com.removeActor(a) {
+				// already removed and shut down
+				continue
+			}
+			a.Shutdown()
+			log.Errorf("%s: Removed failed actor", a)
+			com.failed++
+
+			// replacements are only started once the miner is running
+			com.actorsMtx.RLock()
+			miner := com.miner
+			com.actorsMtx.RUnlock()
+			if com.cfg.ReplaceActors && miner != nil {
+				replacing++
+				com.wg.Add(1)
+				go func(a *Actor) {
+					defer com.wg.Done()
+					com.replaceActor(a, miner)
+					select {
+					case replaced <- struct{}{}:
+					case <-com.exit:
+					}
+				}(a)
+			}
+		case <-replaced:
+			replacing--
+		case <-com.exit:
+			return
+		}
+
+		if replacing == 0 && len(com.Actors()) == 0 {
+			log.Errorf("All actors have failed")
+			com.Exit()
+			return
+		}
+	}
+}
+
+// replaceActor starts an actor with the profile, behavior and wallet of the
+// failed actor a and adds it to the simulation. A replacement which fails
+// to start is not replaced in turn.
+func (com *Communication) replaceActor(a *Actor, miner *Miner) {
+	log.Infof("%s: Starting replacement actor", a)
+	r, err := com.startActor(a.profile, a.behaviorName, a.walletVersion, miner.client)
+	if err != nil {
+		log.Errorf("%s: Cannot start replacement actor: %v", a, err)
+		return
+	}
+	com.addActor(r)
+	com.wg.Add(1)
+	go com.watchActor(r)
+	log.Infof("%s: Replaced by %s", a, r)
+}
+
+// startActor creates and starts an actor with the given profile, behavior
+// and btcwallet version, sending double spends to rival, on the node of the
+// next actor index. The actor
+// is not mining so its mining address is discarded. It is not added to
+// the simulation.
+func (com *Communication) startActor(profile *Profile, behavior, wallet string, rival *rpcClient) (*Actor, error) {
+	com.actorsMtx.Lock()
+	i := com.nextActor
+	com.nextActor++
+	nodes := com.nodes
+	com.actorsMtx.Unlock()
+
+	a, err := newIndexedActor(nodes[i%len(nodes)], i)
+	if err != nil {
+		return nil, err
+	}
+	a.profile = profile
+	if err := a.setBehavior(behavior); err != nil {
+		a.Cleanup()
+		return nil, err
+	}
+	a.setWallet(wallet, com.wallets)
+	a.index = i
+	a.rand = com.cfg.newRand(actorStream + int64(i))
+	a.SetRival(rival)
+	if com.cfg.restartWallets() {
+		a.restart = true
+		a.restartAfter = com.cfg.realDuration(com.cfg.ChaosDelay)
+	}
+	a.crashTest = com.crashTest
+
+	started := make(chan error, 1)
+	com.wg.Add(1)
+	go func() {
+		defer com.wg.Done()
+		started <- a.Start(com)
+	}()
+
+	select {
+	case <-a.miningAddr:
+	case err := <-started:
+		a.Shutdown()
+		return nil, err
+	case <-com.exit:
+		a.Shutdown()
+		return nil, errors.New("simulation is shutting down")
+	}
+	if err := <-started; err != nil {
+		a.Shutdown()
+		return nil, err
+	}
+	com.events.publish(&Event{Kind: eventActorStarted, Actor: a})
+	return a, nil
+}
+
+// watchActor runs as a goroutine and reports the actor as failed if its
+// wallet process dies and is not restarted
+func (com *Communication) watchActor(a *Actor) {
+	defer com.wg.Done()
+
+	select {
+	case <-a.Dead():
+		log.Infof("%s: Wallet process died", a)
+		com.actorFailed(a)
+	case <-a.quit:
+	case <-com.exit:
+	}
+}
+
+// actorFailed publishes that the actor failed, the actor watcher removes
+// it from the simulation
+func (com *Communication) actorFailed(a *Actor) {
+	com.events.publish(&Event{Kind: eventActorFailed, Actor: a})
+}
+
+// waitTxPool waits for the receivers of the transactions requested from
+// actors to be done. When no transaction is accepted for txStallTimeout
+// while no actor is paused, it gives up and cancels the receivers left.
+// It returns false if the simulation exits in the meantime.
+func (com *Communication) waitTxPool(wg *sync.WaitGroup, cancel chan struct{}) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	last := atomic.LoadUint64(&com.accepted)
+	for {
+		select {
+		case <-done:
+			return true
+		case <-time.After(txStallTimeout):
+			accepted := atomic.LoadUint64(&com.accepted)
+			if accepted != last || com.anyPaused() {
+				last = accepted
+				continue
+			}
+			log.Warnf("No transaction accepted for %v, "+
+				"mining without the transactions left", txStallTimeout)
+			close(cancel)
+			<-done
+			return true
+		case <-com.exit:
+			return false
+		}
+	}
+}
+
+// anyPaused reports whether any actor of the simulation is paused
+func (com *Communication) anyPaused() bool {
+	for _, a := range com.Actors() {
+		if a.Paused() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,44 @@
+package btcsim
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWatchActors(t *testing.T) {
+	com := NewCommunication(DefaultConfig())
+	a, b := fakeActor("a"), fakeActor("b")
+	com.actors = []*Actor{a, b}
+	com.wg.Add(1)
+	go com.watchActors()
+	defer func() {
+		com.Exit()
+		com.wg.Wait()
+	}()
+
+	com.actorFailed(a)
+	deadline := time.Now().Add(5 * time.Second)
+	for len(com.Actors()) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("failed actor was not removed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if a.running() {
+		t.Errorf("failed actor was not shut down")
+	}
+	if got := com.Actors()[0]; got != b {
+		t.Errorf("remaining actor got %v, want %v", got, b)
+	}
+
+	// the simulation exits once every actor has failed
+	com.actorFailed(b)
+	select {
+	case <-com.exit:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("simulation did not exit after every actor failed")
+	}
+	if com.failed != 2 {
+		t.Errorf("failed actors got %d, want 2", com.failed)
+	}
+}
@@ -0,0 +1,211 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil"
+)
+
+// invariantSettleDelay is how long actors are paused before balances are
+// reconciled, so that the transactions in flight reach the wallets
+const invariantSettleDelay = 2 * time.Second
+
+// balanceSnapshot is the accounting of every coin issued up to a height
+type balanceSnapshot struct {
+	height int32
+
+	// issued is the sum of the block subsidies up to the height
+	issued btcutil.Amount
+
+	// balances is the sum of the balances of the actors including
+	// unconfirmed outputs and those of the actors removed, immature the value of the coinbase outputs not
+	// spendable yet and fees the fees of the transactions in the mempool
+	balances btcutil.Amount
+	immature btcutil.Amount
+	fees     btcutil.Amount
+}
+
+// discrepancy returns the amount issued but not accounted for, negative if
+// more coins are accounted for than were issued
+func (s *balanceSnapshot) discrepancy() btcutil.Amount {
+	return s.issued - s.balances - s.immature - s.fees
+}
+
+// String returns the accounting of the snapshot
+func (s *balanceSnapshot) String() string {
+	return fmt.Sprintf("at height %d issued %v, balances %v, immature coinbase %v, mempool fees %v",
+		s.height, s.issued, s.balances, s.immature, s.fees)
+}
+
+// checkInvariants returns an error if the balance invariant is checked
+// while coins are held outside the wallets of btcwallet actors or burnt
+func checkInvariants(every int, inProcess bool, multisig string, fuzz bool) error {
+	switch {
+	case every <= 0:
+		return nil
+	case inProcess:
+		return errors.New("in-process wallets have no balance to check invariants with")
+	case multisig != "":
+		return errors.New("multisig outputs are not part of wallet balances, invariants cannot be checked")
+	case fuzz:
+		return errors.New("fuzz cases burn coins, invariants cannot be checked")
+	}
+	return nil
+}
+
+// issuedCoins returns the sum of the block subsidies up to height
+func issuedCoins(height int32) btcutil.Amount {
+	var issued int64
+	for h := int32(1); h <= height; h++ {
+		issued += blockchain.CalcBlockSubsidy(h, &chaincfg.SimNetParams)
+	}
+	return btcutil.Amount(issued)
+}
+
+// snapshotBalances returns the accounting of the coins issued as seen by
+// the node and the actors, or nil if a block was connected in the meantime
+func (com *Communication) snapshotBalances(node *Node) (*balanceSnapshot, error) {
+	height, err := node.client.GetBlockCount()
+	if err != nil {
+		return nil, err
+	}
+	s := &balanceSnapshot{
+		height: int32(height),
+		issued: issuedCoins(int32(height)),
+	}
+
+	// coinbase outputs are spendable after CoinbaseMaturity confirmations
+	for h := height - blockchain.CoinbaseMaturity + 2; h <= height; h++ {
+		if h < 1 {
+			continue
+		}
+		hash, err := node.client.GetBlockHash(h)
+		if err != nil {
+			return nil, err
+		}
+		block, err := node.client.GetBlock(hash)
+		if err != nil {
+			return nil, err
+		}
+		for _, out := range block.Transactions()[0].MsgTx().TxOut {
+			s.immature += btcutil.Amount(out.Value)
+		}
+	}
+
+	mempool, err := node.client.GetRawMempoolVerbose()
+	if err != nil {
+		return nil, err
+	}
+	for _, tx := range mempool {
+		fee, err := btcutil.NewAmount(tx.Fee)
+		if err != nil {
+			return nil, err
+		}
+		s.fees += fee
+	}
+
+	// the actors removed hold the balances they had when removed
+	com.actorsMtx.RLock()
+	actors := append([]*Actor(nil), com.actors...)
+	s.balances = com.removedBalances
+	com.actorsMtx.RUnlock()
+	for _, a := range actors {
+		// an actor whose wallet is gone holds coins which cannot be
+		// counted, so the invariant cannot be checked
+		if a.client == nil {
+			return nil, fmt.Errorf("%s: no wallet to get the balance of", a)
+		}
+		balance, err := a.client.GetBalanceMinConf("", 0)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", a, err)
+		}
+		s.balances += balance
+	}
+
+	if after, err := node.client.GetBlockCount(); err != nil || after != height {
+		return nil, err
+	}
+	return s, nil
+}
+
+// startBalanceChecks starts a goroutine reconciling the balances of the
+// actors with the coins issued every given number of blocks
+func (com *Communication) startBalanceChecks(node *Node, every int) {
+	heights := make(chan int32, 1)
+	com.events.subscribe(func(e *Event) {
+		if e.Height < int32(com.cfg.StartBlock) || int(e.Height)%every != 0 {
+			return
+		}
+		select {
+		case heights <- e.Height:
+		default:
+		}
+	}, eventBlockMined)
+	com.wg.Add(1)
+	go com.checkBalances(node, heights)
+}
+
+// pauseActors pauses the actors and returns a function resuming those which
+// were not paused already, e.g. over the control API
+func pauseActors(actors []*Actor) func() {
+	paused := make([]bool, len(actors))
+	for i, a := range actors {
+		paused[i] = a.Paused()
+		a.Pause()
+	}
+	return func() {
+		for i, a := range actors {
+			if !paused[i] {
+				a.Resume()
+			}
+		}
+	}
+}
+
+// checkBalances runs as a goroutine and reconciles the balances of the
+// actors with the coins issued after the blocks received over heights,
+// logging any discrepancy as a potential accounting bug of the wallets or
+// the node
+func (com *Communication) checkBalances(node *Node, heights <-chan int32) {
+	defer com.wg.Done()
+
+	for {
+		select {
+		case <-heights:
+		case <-com.exit:
+			return
+		}
+
+		// actors are paused so that no transaction is in flight while
+		// the balances are collected
+		resume := pauseActors(com.Actors())
+		select {
+		case <-time.After(invariantSettleDelay):
+		case <-com.exit:
+			return
+		}
+		s, err := com.snapshotBalances(node)
+		resume()
+		switch {
+		case err != nil:
+			log.Errorf("Cannot check balance invariant: %v", err)
+		case s == nil:
+			log.Debugf("Skipping balance invariant check, the chain moved")
+		case s.discrepancy() != 0:
+			com.invariantChecks++
+			com.invariantViolations++
+			log.Errorf("Balance invariant violated by %v %v", s.discrepancy(), s)
+		default:
+			com.invariantChecks++
+			log.Debugf("Balance invariant holds %v", s)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package btcsim
+
+import (
+	"testing"
+)
+
+func TestBalanceSnapshotDiscrepancy(t *testing.T) {
+	s := &balanceSnapshot{
+		height:   200,
+		issued:   200 * 50e8,
+		balances: 100*50e8 - 3000,
+		immature: 99 * 50e8,
+		fees:     3000,
+	}
+	if d := s.discrepancy(); d != 50e8 {
+		t.Errorf("discrepancy got %v want 50 BTC", d)
+	}
+	s.balances += 50e8
+	if d := s.discrepancy(); d != 0 {
+		t.Errorf("discrepancy got %v want 0", d)
+	}
+	s.fees += 100
+	if d := s.discrepancy(); d != -100 {
+		t.Errorf("discrepancy got %v want -100 satoshis", d)
+	}
+}
+
+func TestCheckInvariants(t *testing.T) {
+	tests := []struct {
+		every     int
+		inProcess bool
+		multisig  string
+		fuzz      bool
+		valid     bool
+	}{
+		{0, true, "2-of-3", true, true},
+		{10, false, "", false, true},
+		{10, true, "", false, false},
+		{10, false, "2-of-3", false, false},
+		{10, false, "", true, false},
+	}
+	for _, test := range tests {
+		err := checkInvariants(test.every, test.inProcess, test.multisig, test.fuzz)
+		if (err == nil) != test.valid {
+			t.Errorf("checkInvariants(%d, %v, %q, %v) got %v", test.every, test.inProcess, test.multisig,
+				test.fuzz, err)
+		}
+	}
+}
+
+func TestRemovedActorBalance(t *testing.T) {
+	chain := NewMockChain(DefaultConfig())
+	chain.cfg.InvariantBlocks = 10
+	a, b := chain.NewActor("a"), chain.NewActor("b")
+	defer StopMockActors(a, b)
+	mineMock(t, a)
+	balance, _ := a.client.GetBalanceMinConf("", 0)
+	if balance == 0 {
+		t.Fatalf("mined no coins")
+	}
+
+	com := NewCommunication(chain.cfg)
+	com.actors = []*Actor{a, b}
+	if !com.removeActor(a) || com.removeActor(a) {
+		t.Fatalf("removeActor did not remove a once")
+	}
+	if com.removedBalances != balance {
+		t.Errorf("removed balances %v want %v", com.removedBalances, balance)
+	}
+}
+
+func TestPauseActors(t *testing.T) {
+	a, b := fakeActor("a"), fakeActor("b")
+	b.Pause()
+	resume := pauseActors([]*Actor{a, b})
+	if !a.Paused() || !b.Paused() {
+		t.Fatalf("actors not paused")
+	}
+	resume()
+	if a.Paused() || !b.Paused() {
+		t.Errorf("got paused %v and %v want only b paused", a.Paused(), b.Paused())
+	}
+}
@@ -0,0 +1,289 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"encoding/csv"
+	"io"
+	"math/rand"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcutil"
+)
+
+// The states of an invoice, it is issued by the payee, paid when the payer
+// sends the transaction and confirmed once the payee sees it mined. An
+// invoice not paid in time expires.
+const (
+	invoiceIssued    = "issued"
+	invoicePaid      = "paid"
+	invoiceConfirmed = "confirmed"
+	invoiceExpired   = "expired"
+)
+
+// invoice is a payment request of a payee to a payer, for an amount to a
+// fresh address of the payee
+type invoice struct {
+	id     int
+	payer  *Actor
+	payee  *Actor
+	addr   btcutil.Address
+	amount btcutil.Amount
+	state  string
+	txid   string
+
+	issued    time.Time
+	paid      time.Time
+	confirmed time.Time
+}
+
+// invoiceBook issues the invoices of the simulation and follows them
+// through their lifecycle
+type invoiceBook struct {
+	mtx      sync.Mutex
+	cfg      *Config
+	rand     *rand.Rand
+	expiry   time.Duration
+	index    func(a *Actor, addr btcutil.Address)
+	invoices []*invoice
+}
+
+// newInvoiceBook returns a book of invoices expiring after the given real
+// duration unless they are paid, the fresh addresses of the invoices are
+// passed to index so that the payments to them are found. The book belongs
+// to the run configured by cfg.
+func newInvoiceBook(cfg *Config, expiry time.Duration, index func(a *Actor, addr btcutil.Address)) *invoiceBook {
+	return &invoiceBook{
+		cfg:    cfg,
+		rand:   cfg.newRand(invoiceStream),
+		expiry: expiry,
+		index:  index,
+	}
+}
+
+// issue makes payee issue an invoice to payer, which can spend up to
+// budget, for an amount drawn from the amount distribution of the profile
+// of the payee if any, or else as per its spend fraction
+func (b *invoiceBook) issue(payer, payee *Actor, budget btcutil.Amount) (*invoice, error) {
+	addr, err := payee.freshAddress()
+	if err != nil {
+		return nil, err
+	}
+
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	b.expire(time.Now())
+	inv := &invoice{
+		id:     len(b.invoices),
+		payer:  payer,
+		payee:  payee,
+		addr:   addr,
+		amount: b.amount(payee.profile, budget),
+		state:  invoiceIssued,
+		issued: time.Now(),
+	}
+	b.invoices = append(b.invoices, inv)
+	if b.index != nil {
+		b.index(payee, addr)
+	}
+	return inv, nil
+}
+
+// amount returns the amount of an invoice of a payee with the given
+// profile, leaving the payer at least minFee of change out of budget
+func (b *invoiceBook) amount(p *Profile, budget btcutil.Amount) btcutil.Amount {
+	if budget < 2*minFee {
+		return budget
+	}
+	max := budget - minFee
+	var amt btcutil.Amount
+	dist := p.Amounts
+	if dist == nil {
+		dist = b.cfg.defaultAmounts
+	}
+	if dist != nil {
+		amt = dist.sample(b.rand, max)
+	} else {
+		frac := p.MinSpend + b.rand.Float64()*(p.MaxSpend-p.MinSpend)
+		amt = btcutil.Amount(float64(max) * frac)
+	}
+	if amt < minFee {
+		amt = minFee
+	}
+	return amt
+}
+
+// pay marks the invoice paid by the transaction txid
+func (b *invoiceBook) pay(inv *invoice, txid string) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if inv.state != invoiceIssued {
+		return
+	}
+	inv.state, inv.txid, inv.paid = invoicePaid, txid, time.Now()
+}
+
+// confirm marks the paid invoice confirmed by its payee
+func (b *invoiceBook) confirm(inv *invoice) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if inv.state != invoicePaid {
+		return
+	}
+	inv.state, inv.confirmed = invoiceConfirmed, time.Now()
+}
+
+// expire marks the invoices issued before the expiry and still not paid
+// at now expired, the lock must be held by the caller
+func (b *invoiceBook) expire(now time.Time) {
+	for _, inv := range b.invoices {
+		if inv.state == invoiceIssued && now.Sub(inv.issued) > b.expiry {
+			inv.state = invoiceExpired
+		}
+	}
+}
+
+// Stats returns the lifecycle statistics of the invoices
+func (b *invoiceBook) Stats() *InvoiceStats {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	b.expire(time.Now())
+	s := &InvoiceStats{Issued: len(b.invoices)}
+	var payTime, confirmTime time.Duration
+	for _, inv := range b.invoices {
+		switch inv.state {
+		case invoiceExpired:
+			s.Expired++
+			continue
+		case invoiceIssued:
+			continue
+		case invoiceConfirmed:
+			s.Confirmed++
+			confirmTime += inv.confirmed.Sub(inv.issued)
+		}
+		s.Paid++
+		payTime += inv.paid.Sub(inv.issued)
+	}
+	if s.Paid > 0 {
+		s.MeanPayTime = payTime / time.Duration(s.Paid)
+	}
+	if s.Confirmed > 0 {
+		s.MeanConfirmTime = confirmTime / time.Duration(s.Confirmed)
+	}
+	return s
+}
+
+// Invoices returns a copy of the invoices issued
+func (b *invoiceBook) Invoices() []invoice {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	invoices := make([]invoice, len(b.invoices))
+	for i, inv := range b.invoices {
+		invoices[i] = *inv
+	}
+	return invoices
+}
+
+// InvoiceStats are the numbers of invoices at each step of their
+// lifecycle, the paid invoices include the confirmed ones, and the mean
+// time from their issue until they were paid and confirmed
+type InvoiceStats struct {
+	Issued          int           `json:"issued"`
+	Paid            int           `json:"paid"`
+	Confirmed       int           `json:"confirmed"`
+	Expired         int           `json:"expired"`
+	MeanPayTime     time.Duration `json:"meanpaytime"`
+	MeanConfirmTime time.Duration `json:"meanconfirmtime"`
+}
+
+// invoicePayment has the payee of p issue an invoice to the actor, which
+// can spend amt, and returns the amounts fulfilling it with the change
+// back to the actor. It returns nil if the invoice cannot be issued.
+func (a *Actor) invoicePayment(b *invoiceBook, p *payment, amt btcutil.Amount) map[btcutil.Address]btcutil.Amount {
+	inv, err := b.issue(a, p.payee, amt)
+	if err != nil {
+		log.Debugf("%s: Cannot get an invoice from %s: %v", a, p.payee, err)
+		return nil
+	}
+	p.invoice = inv
+	amounts := map[btcutil.Address]btcutil.Amount{inv.addr: inv.amount}
+	if change := amt - inv.amount; change > 0 {
+		amounts[a.ownedAddresses[a.rand.Int()%len(a.ownedAddresses)]] = change
+	}
+	return amounts
+}
+
+// freshAddress returns a new address of the actor which is not one of
+// its owned addresses, to be paid by a single invoice
+func (a *Actor) freshAddress() (btcutil.Address, error) {
+	if a.wallet != nil {
+		return a.wallet.newAddress(addrP2PKH)
+	}
+	if a.client == nil {
+		return nil, ErrActorShutdown
+	}
+	return a.client.GetNewAddress()
+}
+
+// indexAddress indexes an address of the actor besides its owned
+// addresses, so that the outputs paying it are queued to the actor
+func (com *Communication) indexAddress(a *Actor, addr btcutil.Address) {
+	com.ownersMtx.Lock()
+	com.owners[addr.String()] = a
+	com.ownersMtx.Unlock()
+}
+
+// writeInvoiceStatsCSV writes the lifecycle of the invoices as CSV, the
+// times of the steps not reached are empty
+func writeInvoiceStatsCSV(w io.Writer, invoices []invoice) error {
+	writer := csv.NewWriter(w)
+	header := []string{"id", "payer", "payee", "address", "amount", "state", "txid",
+		"issued", "paid", "confirmed"}
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+	formatTime := func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.Format(time.RFC3339Nano)
+	}
+	for _, inv := range invoices {
+		row := []string{
+			strconv.Itoa(inv.id),
+			inv.payer.String(),
+			inv.payee.String(),
+			inv.addr.String(),
+			strconv.FormatInt(int64(inv.amount), 10),
+			inv.state,
+			inv.txid,
+			formatTime(inv.issued),
+			formatTime(inv.paid),
+			formatTime(inv.confirmed),
+		}
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// writeInvoiceStats writes the lifecycle of the invoices to the given path
+// as CSV
+func writeInvoiceStats(path string, invoices []invoice) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := writeInvoiceStatsCSV(file, invoices); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
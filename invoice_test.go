@@ -0,0 +1,118 @@
+package btcsim
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil"
+)
+
+// invoiceActor returns a fake actor with an in-process wallet to issue
+// invoices from
+func invoiceActor(name string) *Actor {
+	a := fakeActor(name)
+	a.wallet = newMemWallet(&chaincfg.SimNetParams)
+	a.profile = defaultProfile
+	return a
+}
+
+func TestInvoiceLifecycle(t *testing.T) {
+	payer, payee := invoiceActor("payer"), invoiceActor("payee")
+	indexed := 0
+	b := newInvoiceBook(DefaultConfig(), time.Hour, func(a *Actor, addr btcutil.Address) {
+		if a != payee {
+			t.Errorf("indexed an address of %s", a)
+		}
+		indexed++
+	})
+
+	inv, err := b.issue(payer, payee, 1e6)
+	if err != nil {
+		t.Fatalf("issue error: %v", err)
+	}
+	if inv.state != invoiceIssued || inv.amount < minFee || inv.amount > 1e6-minFee || indexed != 1 {
+		t.Fatalf("got invoice %+v, %d addresses indexed", inv, indexed)
+	}
+
+	// the invoice is confirmed once paid only
+	b.confirm(inv)
+	if inv.state != invoiceIssued {
+		t.Errorf("unpaid invoice got state %s", inv.state)
+	}
+	b.pay(inv, "tx")
+	b.confirm(inv)
+	if inv.state != invoiceConfirmed || inv.txid != "tx" {
+		t.Errorf("got state %s and txid %q", inv.state, inv.txid)
+	}
+
+	unpaid, err := b.issue(payer, payee, 1e6)
+	if err != nil {
+		t.Fatalf("issue error: %v", err)
+	}
+	unpaid.issued = unpaid.issued.Add(-2 * time.Hour)
+	s := b.Stats()
+	if s.Issued != 2 || s.Paid != 1 || s.Confirmed != 1 || s.Expired != 1 {
+		t.Errorf("got stats %+v", s)
+	}
+	// an expired invoice cannot be paid anymore
+	b.pay(unpaid, "late")
+	if unpaid.state != invoiceExpired {
+		t.Errorf("expired invoice got state %s", unpaid.state)
+	}
+}
+
+func TestInvoiceAmount(t *testing.T) {
+	b := newInvoiceBook(DefaultConfig(), time.Hour, nil)
+	if amt := b.amount(defaultProfile, minFee); amt != minFee {
+		t.Errorf("got amount %v of a budget of %v", amt, minFee)
+	}
+	for i := 0; i < 100; i++ {
+		if amt := b.amount(defaultProfile, 1e7); amt < minFee || amt > 1e7-minFee {
+			t.Fatalf("got amount %v of a budget of %v", amt, btcutil.Amount(1e7))
+		}
+	}
+}
+
+func TestMatchmakerInvoices(t *testing.T) {
+	payer, payee := invoiceActor("payer"), invoiceActor("payee")
+	m := newMatchmaker(DefaultConfig(), matchRandom, func() []*Actor { return []*Actor{payer, payee} })
+	m.invoices = newInvoiceBook(DefaultConfig(), time.Hour, nil)
+	inv, err := m.invoices.issue(payer, payee, 1e6)
+	if err != nil {
+		t.Fatalf("issue error: %v", err)
+	}
+	m.sent("tx", &payment{payer: payer, payee: payee, amount: inv.amount, invoice: inv})
+	if inv.state != invoicePaid {
+		t.Errorf("got state %s once sent", inv.state)
+	}
+	m.confirm(1, []string{"tx"})
+	if inv.state != invoiceConfirmed {
+		t.Errorf("got state %s once confirmed", inv.state)
+	}
+}
+
+func TestWriteInvoiceStatsCSV(t *testing.T) {
+	payer, payee := invoiceActor("payer"), invoiceActor("payee")
+	invoices := []invoice{{
+		id:     0,
+		payer:  payer,
+		payee:  payee,
+		addr:   fakeAddress("addr"),
+		amount: 1000,
+		state:  invoicePaid,
+		txid:   "tx",
+		issued: time.Unix(0, 0).UTC(),
+		paid:   time.Unix(1, 0).UTC(),
+	}}
+	var buf bytes.Buffer
+	if err := writeInvoiceStatsCSV(&buf, invoices); err != nil {
+		t.Fatalf("writeInvoiceStatsCSV error: %v", err)
+	}
+	want := "id,payer,payee,address,amount,state,txid,issued,paid,confirmed\n" +
+		"0,fake-payer,fake-payee,addr,1000,paid,tx,1970-01-01T00:00:00Z,1970-01-01T00:00:01Z,\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got %q want %q", got, want)
+	}
+}
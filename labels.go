@@ -0,0 +1,121 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sort"
+)
+
+// actorLabel is the ground truth about the actor owning an address or a
+// transaction
+type actorLabel struct {
+	Actor    string `json:"actor"`
+	Profile  string `json:"profile"`
+	Behavior string `json:"behavior"`
+}
+
+// addressLabel labels an address with the actor owning it
+type addressLabel struct {
+	Address string `json:"address"`
+	actorLabel
+}
+
+// txLabel labels a mined transaction with the actor who owned its first
+// input owned by an actor, coinbase transactions have no actor
+type txLabel struct {
+	TxID     string `json:"txid"`
+	Height   int32  `json:"height"`
+	Coinbase bool   `json:"coinbase"`
+	actorLabel
+}
+
+// labels map every address of the actors and every transaction of the
+// chain to the actor owning it, as ground truth for address clustering
+// heuristics
+type labels struct {
+	Addresses []addressLabel `json:"addresses"`
+	Txs       []txLabel      `json:"txs"`
+}
+
+// labelActor returns the label of the actor, empty if nil
+func labelActor(a *Actor) actorLabel {
+	if a == nil {
+		return actorLabel{}
+	}
+	l := actorLabel{Actor: a.String(), Behavior: a.behaviorName}
+	if a.profile != nil {
+		l.Profile = a.profile.Name
+	}
+	return l
+}
+
+// buildLabels labels the addresses owned by the actors, including those
+// indexed besides their receiving addresses, and the transactions of the
+// graph of the chain
+func (com *Communication) buildLabels(g *txGraph) *labels {
+	actors := com.Actors()
+	owners := make(map[string]*Actor)
+	byName := make(map[string]*Actor, len(actors))
+	for _, a := range actors {
+		byName[a.String()] = a
+		for _, addr := range a.ownedAddresses {
+			if addr != nil {
+				owners[addr.String()] = a
+			}
+		}
+	}
+	com.ownersMtx.RLock()
+	for addr, a := range com.owners {
+		owners[addr] = a
+	}
+	com.ownersMtx.RUnlock()
+
+	l := &labels{
+		Addresses: make([]addressLabel, 0, len(owners)),
+		Txs:       make([]txLabel, 0, len(g.txs)),
+	}
+	for addr, a := range owners {
+		l.Addresses = append(l.Addresses, addressLabel{Address: addr, actorLabel: labelActor(a)})
+	}
+	sort.Sort(addressLabels(l.Addresses))
+	for _, tx := range g.txs {
+		l.Txs = append(l.Txs, txLabel{
+			TxID:       tx.txid,
+			Height:     tx.height,
+			Coinbase:   tx.coinbase,
+			actorLabel: labelActor(byName[tx.actor]),
+		})
+	}
+	return l
+}
+
+// addressLabels sorts address labels by address
+type addressLabels []addressLabel
+
+func (l addressLabels) Len() int           { return len(l) }
+func (l addressLabels) Less(i, j int) bool { return l[i].Address < l[j].Address }
+func (l addressLabels) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+
+// writeLabelsJSON writes the labels as JSON
+func writeLabelsJSON(w io.Writer, l *labels) error {
+	enc := json.NewEncoder(w)
+	return enc.Encode(l)
+}
+
+// writeLabels writes the labels to the given path as JSON
+func writeLabels(path string, l *labels) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := writeLabelsJSON(file, l); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,59 @@
+package btcsim
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/btcsuite/btcutil"
+)
+
+func TestBuildLabels(t *testing.T) {
+	com := NewCommunication(DefaultConfig())
+	a, b := fakeActor("a"), fakeActor("b")
+	a.ownedAddresses = []btcutil.Address{fakeAddress("a1"), fakeAddress("a2")}
+	a.profile = &Profile{Name: "spender"}
+	a.behaviorName = "merchant:1"
+	b.ownedAddresses = []btcutil.Address{fakeAddress("b1")}
+	com.actors = []*Actor{a, b}
+	// an invoice address indexed besides the receiving addresses
+	com.indexAddress(b, fakeAddress("b2"))
+
+	g := testTxGraph()
+	g.txs[1].actor = a.String()
+	l := com.buildLabels(g)
+
+	if len(l.Addresses) != 4 {
+		t.Fatalf("got %d address labels", len(l.Addresses))
+	}
+	for _, label := range l.Addresses {
+		want := a.String()
+		if label.Address[0] == 'b' {
+			want = b.String()
+		}
+		if label.Actor != want {
+			t.Errorf("address %s labeled %s want %s", label.Address, label.Actor, want)
+		}
+	}
+	if l.Addresses[0].Address != "a1" || l.Addresses[0].Profile != "spender" ||
+		l.Addresses[0].Behavior != "merchant:1" {
+		t.Errorf("got first label %+v", l.Addresses[0])
+	}
+	if len(l.Txs) != 2 || l.Txs[0].Actor != "" || !l.Txs[0].Coinbase || l.Txs[1].Actor != a.String() {
+		t.Errorf("got tx labels %+v", l.Txs)
+	}
+
+	var buf bytes.Buffer
+	if err := writeLabelsJSON(&buf, l); err != nil {
+		t.Fatalf("writeLabelsJSON error: %v", err)
+	}
+	var decoded struct {
+		Addresses []map[string]interface{} `json:"addresses"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if decoded.Addresses[0]["actor"] != a.String() {
+		t.Errorf("actor label not flattened: %v", decoded.Addresses[0])
+	}
+}
@@ -0,0 +1,163 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// latencyBlockBuckets are the upper bounds of the histogram buckets of
+// the number of blocks transactions take to confirm
+var latencyBlockBuckets = []int32{1, 2, 3, 6, 12}
+
+// FeeBandLatency is the distribution of the confirmation latency of the
+// transactions paying a fee rate in one of the bands of feeRateBuckets
+type FeeBandLatency struct {
+	Band      string `json:"band"`
+	Txs       int    `json:"txs"`
+	Confirmed int    `json:"confirmed"`
+
+	// blocks to confirm
+	MedianBlocks int32 `json:"medianblocks"`
+	P95Blocks    int32 `json:"p95blocks"`
+	MaxBlocks    int32 `json:"maxblocks"`
+
+	// time to confirm
+	MeanTime   time.Duration `json:"meantime"`
+	MedianTime time.Duration `json:"mediantime"`
+	P95Time    time.Duration `json:"p95time"`
+	MaxTime    time.Duration `json:"maxtime"`
+
+	// Histogram counts the confirmed transactions by blocks to confirm as
+	// per latencyBlockBuckets
+	Histogram []int `json:"histogram"`
+}
+
+// feeBand returns the name of the band of feeRateBuckets with the given
+// index, as in the fee statistics CSV header
+func feeBand(i int) string {
+	if i == len(feeRateBuckets) {
+		return fmt.Sprintf("rate>%g", feeRateBuckets[i-1])
+	}
+	return fmt.Sprintf("rate<=%g", feeRateBuckets[i])
+}
+
+// newLatencyStats returns the confirmation latency of the transactions by
+// fee band, bands without transactions are left out
+func newLatencyStats(records []*TxRecord) []*FeeBandLatency {
+	bands := make([][]*TxRecord, len(feeRateBuckets)+1)
+	for _, r := range records {
+		i := sort.SearchFloat64s(feeRateBuckets, r.FeeRate())
+		bands[i] = append(bands[i], r)
+	}
+
+	var stats []*FeeBandLatency
+	for i, rs := range bands {
+		if len(rs) == 0 {
+			continue
+		}
+		l := &FeeBandLatency{
+			Band:      feeBand(i),
+			Txs:       len(rs),
+			Histogram: make([]int, len(latencyBlockBuckets)+1),
+		}
+		var blocks []int32
+		var times []time.Duration
+		var total time.Duration
+		for _, r := range rs {
+			if !r.Confirmed() {
+				continue
+			}
+			blocks = append(blocks, r.LatencyBlocks())
+			times = append(times, r.Latency())
+			total += r.Latency()
+		}
+		l.Confirmed = len(times)
+		if l.Confirmed > 0 {
+			sort.Sort(blockCounts(blocks))
+			sort.Sort(durations(times))
+			l.MedianBlocks = blocks[rank(len(blocks), 50)]
+			l.P95Blocks = blocks[rank(len(blocks), 95)]
+			l.MaxBlocks = blocks[len(blocks)-1]
+			l.MeanTime = total / time.Duration(l.Confirmed)
+			l.MedianTime = percentile(times, 50)
+			l.P95Time = percentile(times, 95)
+			l.MaxTime = times[len(times)-1]
+			for _, n := range blocks {
+				j := sort.Search(len(latencyBlockBuckets), func(j int) bool {
+					return latencyBlockBuckets[j] >= n
+				})
+				l.Histogram[j]++
+			}
+		}
+		stats = append(stats, l)
+	}
+	return stats
+}
+
+// blockCounts implements sort.Interface for a slice of block counts
+type blockCounts []int32
+
+func (b blockCounts) Len() int           { return len(b) }
+func (b blockCounts) Less(i, j int) bool { return b[i] < b[j] }
+func (b blockCounts) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+
+// writeLatencyStatsCSV writes the confirmation latency of every fee band
+// as CSV with a header row, times are in seconds
+func writeLatencyStatsCSV(w io.Writer, stats []*FeeBandLatency) error {
+	header := []string{"band", "txs", "confirmed",
+		"medianblocks", "p95blocks", "maxblocks",
+		"meantime", "mediantime", "p95time", "maxtime"}
+	for _, bound := range latencyBlockBuckets {
+		header = append(header, fmt.Sprintf("blocks<=%d", bound))
+	}
+	header = append(header, fmt.Sprintf("blocks>%d", latencyBlockBuckets[len(latencyBlockBuckets)-1]))
+
+	writer := csv.NewWriter(w)
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+	for _, l := range stats {
+		row := []string{
+			l.Band,
+			strconv.Itoa(l.Txs),
+			strconv.Itoa(l.Confirmed),
+			strconv.Itoa(int(l.MedianBlocks)),
+			strconv.Itoa(int(l.P95Blocks)),
+			strconv.Itoa(int(l.MaxBlocks)),
+		}
+		for _, d := range []time.Duration{l.MeanTime, l.MedianTime, l.P95Time, l.MaxTime} {
+			row = append(row, strconv.FormatFloat(d.Seconds(), 'f', -1, 64))
+		}
+		for _, n := range l.Histogram {
+			row = append(row, strconv.Itoa(n))
+		}
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// writeLatencyStats writes the confirmation latency of every fee band to
+// the given path as CSV
+func writeLatencyStats(path string, stats []*FeeBandLatency) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := writeLatencyStatsCSV(file, stats); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
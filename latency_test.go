@@ -0,0 +1,60 @@
+package btcsim
+
+import (
+	"bytes"
+	"encoding/csv"
+	"testing"
+	"time"
+)
+
+func TestFeeBand(t *testing.T) {
+	if band := feeBand(0); band != "rate<=1" {
+		t.Errorf("feeBand(0) got %q want rate<=1", band)
+	}
+	if band := feeBand(len(feeRateBuckets)); band != "rate>200" {
+		t.Errorf("feeBand(%d) got %q want rate>200", len(feeRateBuckets), band)
+	}
+}
+
+func TestNewLatencyStats(t *testing.T) {
+	sent := time.Now()
+	records := []*TxRecord{
+		{Size: 100, Fee: 100, SentTime: sent, SentHeight: 10, Height: 11, ConfirmedTime: sent.Add(time.Minute)},
+		{Size: 100, Fee: 100, SentTime: sent, SentHeight: 10, Height: 13, ConfirmedTime: sent.Add(3 * time.Minute)},
+		{Size: 100, Fee: 100, SentTime: sent, SentHeight: 10},
+		{Size: 100, Fee: 5000, SentTime: sent, SentHeight: 10, Height: 11, ConfirmedTime: sent.Add(time.Minute)},
+	}
+	stats := newLatencyStats(records)
+	if len(stats) != 2 {
+		t.Fatalf("newLatencyStats got %d bands want 2", len(stats))
+	}
+
+	low := stats[0]
+	if low.Band != "rate<=1" || low.Txs != 3 || low.Confirmed != 2 {
+		t.Errorf("low band got %s with %d txs, %d confirmed, want rate<=1 with 3, 2",
+			low.Band, low.Txs, low.Confirmed)
+	}
+	if low.MedianBlocks != 1 || low.MaxBlocks != 3 || low.MeanTime != 2*time.Minute {
+		t.Errorf("low band got median %d, max %d blocks, mean %v, want 1, 3, 2m",
+			low.MedianBlocks, low.MaxBlocks, low.MeanTime)
+	}
+	if low.Histogram[0] != 1 || low.Histogram[2] != 1 {
+		t.Errorf("low band got histogram %v", low.Histogram)
+	}
+	if high := stats[1]; high.Band != "rate<=50" || high.Confirmed != 1 {
+		t.Errorf("high band got %s with %d confirmed, want rate<=50 with 1",
+			high.Band, high.Confirmed)
+	}
+
+	var buf bytes.Buffer
+	if err := writeLatencyStatsCSV(&buf, stats); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 || rows[1][0] != "rate<=1" || rows[1][8] != "180" {
+		t.Errorf("writeLatencyStatsCSV got %v", rows)
+	}
+}
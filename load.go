@@ -0,0 +1,250 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Load profiles accepted by -load, rates are in transactions per second
+// and durations are counted from the first block transactions are
+// generated for
+const (
+	// loadLinear ramps the rate from one rate to another over the given
+	// duration, then keeps it
+	loadLinear = "linear"
+
+	// loadStep switches from one rate to another after the given time
+	loadStep = "step"
+
+	// loadSine varies the rate around the mean by up to the amplitude
+	// with the given period
+	loadSine = "sine"
+
+	// loadBurst raises the rate from the base to the peak for the given
+	// length at the start of every period
+	loadBurst = "burst"
+)
+
+// loadProfileParams are the names of the parameters of each load profile
+var loadProfileParams = map[string][]string{
+	loadLinear: {"from", "to", "duration"},
+	loadStep:   {"from", "to", "after"},
+	loadSine:   {"mean", "amplitude", "period"},
+	loadBurst:  {"base", "peak", "every", "length"},
+}
+
+// loadDurationParams are the parameters given as durations, e.g. 10m
+var loadDurationParams = map[string]bool{
+	"duration": true,
+	"after":    true,
+	"period":   true,
+	"every":    true,
+	"length":   true,
+}
+
+const (
+	// minLoadRate is the lowest rate set by a load profile, as a rate of
+	// zero disables the throttle
+	minLoadRate = 0.01
+
+	// loadUpdateInterval is the interval at which the rate of the load
+	// profile is applied, unless the rate is lower
+	loadUpdateInterval = time.Second
+)
+
+// loadProfile varies the aggregate transaction rate over the lifetime of
+// the simulation
+type loadProfile struct {
+	name   string
+	fields []string
+
+	// params are the parameters of the profile, durations in seconds
+	params []float64
+}
+
+// parseLoadProfile parses a load profile given as its name followed by its
+// parameters, separated by colons, e.g. "linear:1:50:10m". The empty
+// string returns a nil profile.
+func parseLoadProfile(s string) (*loadProfile, error) {
+	if s == "" {
+		return nil, nil
+	}
+	fields := strings.Split(s, ":")
+	params, ok := loadProfileParams[fields[0]]
+	if !ok {
+		names := make([]string, 0, len(loadProfileParams))
+		for name := range loadProfileParams {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown load profile %q, valid profiles are: %s",
+			fields[0], strings.Join(names, ", "))
+	}
+	if len(fields)-1 != len(params) {
+		return nil, fmt.Errorf("load profile %s: expected parameters %s",
+			fields[0], strings.Join(params, ":"))
+	}
+	p := &loadProfile{
+		name:   fields[0],
+		fields: fields[1:],
+		params: make([]float64, len(params)),
+	}
+	for i, f := range fields[1:] {
+		if loadDurationParams[params[i]] {
+			d, err := time.ParseDuration(f)
+			if err != nil || d < 0 {
+				return nil, fmt.Errorf("load profile %s: invalid %s %q", p.name, params[i], f)
+			}
+			p.params[i] = d.Seconds()
+			continue
+		}
+		v, err := strconv.ParseFloat(f, 64)
+		if err != nil || math.IsNaN(v) || math.IsInf(v, 0) || v < 0 {
+			return nil, fmt.Errorf("load profile %s: invalid %s %q", p.name, params[i], f)
+		}
+		p.params[i] = v
+	}
+	switch {
+	case (p.name == loadLinear || p.name == loadSine) && p.params[2] == 0:
+		return nil, fmt.Errorf("load profile %s: %s must be positive", p.name, params[2])
+	case p.name == loadSine && p.params[1] > p.params[0]:
+		return nil, fmt.Errorf("load profile %s: amplitude must not exceed mean", p.name)
+	case p.name == loadBurst && (p.params[2] == 0 || p.params[3] > p.params[2]):
+		return nil, fmt.Errorf("load profile %s: length must not exceed a positive period", p.name)
+	}
+	return p, nil
+}
+
+// rate returns the transaction rate the given time after the start
+func (p *loadProfile) rate(elapsed time.Duration) float64 {
+	t := elapsed.Seconds()
+	var rate float64
+	switch p.name {
+	case loadLinear:
+		rate = p.params[1]
+		if t < p.params[2] {
+			rate = p.params[0] + (p.params[1]-p.params[0])*t/p.params[2]
+		}
+	case loadStep:
+		rate = p.params[1]
+		if t < p.params[2] {
+			rate = p.params[0]
+		}
+	case loadSine:
+		rate = p.params[0] + p.params[1]*math.Sin(2*math.Pi*t/p.params[2])
+	case loadBurst:
+		rate = p.params[0]
+		if math.Mod(t, p.params[2]) < p.params[3] {
+			rate = p.params[1]
+		}
+	}
+	if rate < minLoadRate {
+		rate = minLoadRate
+	}
+	return rate
+}
+
+// String returns the profile as it is parsed
+func (p *loadProfile) String() string {
+	return strings.Join(append([]string{p.name}, p.fields...), ":")
+}
+
+// generationStarted returns a channel receiving the time of the first
+// block transactions are generated for, the block before startBlock
+func (com *Communication) generationStarted() <-chan time.Time {
+	started := make(chan time.Time, 1)
+	var once sync.Once
+	com.events.subscribe(func(e *Event) {
+		if e.Height >= int32(com.cfg.StartBlock)-1 {
+			once.Do(func() {
+				started <- e.Time
+			})
+		}
+	}, eventBlockMined)
+	return started
+}
+
+// startRateControl starts a goroutine setting the transaction rate as per
+// the load profile, or -txrate, scaled by the seasonality plus the burst
+// after the last block
+func (com *Communication) startRateControl() {
+	started := com.generationStarted()
+	blocks := make(chan time.Time, 1)
+	if com.burst != nil {
+		com.events.subscribe(func(e *Event) {
+			if e.Height < int32(com.cfg.StartBlock)-1 {
+				return
+			}
+			if com.burst.decay == 0 {
+				com.throttle.grant(com.burst.size)
+			}
+			// keep the time of the last block only
+			select {
+			case <-blocks:
+			default:
+			}
+			blocks <- e.Time
+		}, eventBlockMined)
+	}
+	com.wg.Add(1)
+	go com.controlRate(started, blocks)
+}
+
+// controlRate runs as a goroutine and sets the transaction rate every
+// loadUpdateInterval and after every block received over blocks, from the
+// time received over started until the simulation exits
+func (com *Communication) controlRate(started, blocks <-chan time.Time) {
+	defer com.wg.Done()
+
+	var start, lastBlock time.Time
+	select {
+	case start = <-started:
+	case <-com.exit:
+		return
+	}
+	if com.load != nil {
+		log.Infof("Applying load profile %v", com.load)
+	}
+	if com.season != nil {
+		log.Infof("Applying seasonality %v", com.season)
+	}
+	for {
+		now := time.Now()
+		elapsed := com.cfg.simDuration(now.Sub(start))
+		rate := com.cfg.TxRate
+		if com.load != nil {
+			rate = com.load.rate(elapsed)
+		}
+		rate *= com.season.factor(elapsed)
+		if !lastBlock.IsZero() {
+			rate += com.burst.rate(com.cfg.simDuration(now.Sub(lastBlock)))
+		}
+		if rate < minLoadRate {
+			rate = minLoadRate
+		}
+		rate = com.cfg.realRate(rate)
+		com.throttle.setRate(rate)
+
+		// changing the rate restarts the throttle, so low rates are kept
+		// long enough to let a transaction through
+		wait := loadUpdateInterval
+		if d := time.Duration(float64(time.Second) / rate); d >= wait {
+			wait += d
+		}
+		select {
+		case <-time.After(wait):
+		case lastBlock = <-blocks:
+		case <-com.exit:
+			return
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package btcsim
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestParseLoadProfile(t *testing.T) {
+	p, err := parseLoadProfile("burst:5:100:1m:10s")
+	if err != nil {
+		t.Fatalf("parseLoadProfile error: %v", err)
+	}
+	if p.String() != "burst:5:100:1m:10s" {
+		t.Errorf("parseLoadProfile got %v want burst:5:100:1m:10s", p)
+	}
+	if p, err := parseLoadProfile(""); p != nil || err != nil {
+		t.Errorf("parseLoadProfile got %v, %v want nil, nil", p, err)
+	}
+	invalid := []string{
+		"flat:1",
+		"linear:1:50",
+		"linear:1:50:0s",
+		"linear:-1:50:10m",
+		"step:1:50:ten",
+		"sine:10:20:10m",
+		"burst:5:100:10s:1m",
+	}
+	for _, s := range invalid {
+		if _, err := parseLoadProfile(s); err == nil {
+			t.Errorf("parseLoadProfile(%q) expected error", s)
+		}
+	}
+}
+
+func TestLoadProfileRate(t *testing.T) {
+	tests := []struct {
+		profile string
+		elapsed time.Duration
+		want    float64
+	}{
+		{"linear:10:50:100s", 0, 10},
+		{"linear:10:50:100s", 25 * time.Second, 20},
+		{"linear:10:50:100s", time.Hour, 50},
+		{"linear:50:0:100s", time.Hour, minLoadRate},
+		{"step:5:50:2m", time.Minute, 5},
+		{"step:5:50:2m", 2 * time.Minute, 50},
+		{"sine:25:20:100s", 25 * time.Second, 45},
+		{"sine:25:20:100s", 75 * time.Second, 5},
+		{"burst:5:100:1m:10s", 5 * time.Second, 100},
+		{"burst:5:100:1m:10s", 30 * time.Second, 5},
+		{"burst:5:100:1m:10s", 65 * time.Second, 100},
+	}
+	for _, test := range tests {
+		p, err := parseLoadProfile(test.profile)
+		if err != nil {
+			t.Fatalf("parseLoadProfile(%q) error: %v", test.profile, err)
+		}
+		if got := p.rate(test.elapsed); math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("%s after %v got rate %v want %v", test.profile, test.elapsed, got, test.want)
+		}
+	}
+}
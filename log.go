@@ -0,0 +1,233 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"fmt"
+	"io"
+	stdlog "log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// logLevel is the severity of a log message
+type logLevel int
+
+// Log levels, messages below the level set with -loglevel are discarded
+const (
+	levelTrace logLevel = iota
+	levelDebug
+	levelInfo
+	levelWarn
+	levelError
+	levelCritical
+	levelOff
+)
+
+// logLevels maps the names accepted by the -loglevel flag to the levels
+var logLevels = map[string]logLevel{
+	"trace":    levelTrace,
+	"debug":    levelDebug,
+	"info":     levelInfo,
+	"warn":     levelWarn,
+	"error":    levelError,
+	"critical": levelCritical,
+	"off":      levelOff,
+}
+
+// levelTags are the tags prefixing the messages of each level
+var levelTags = map[logLevel]string{
+	levelTrace:    "TRC",
+	levelDebug:    "DBG",
+	levelInfo:     "INF",
+	levelWarn:     "WRN",
+	levelError:    "ERR",
+	levelCritical: "CRT",
+}
+
+// logger is a leveled logger writing the messages of the simulator
+type logger struct {
+	// warnings and errors are the number of messages logged at the warn
+	// and error levels or above, whether they are written or not. They
+	// are updated atomically and come first to be 64-bit aligned
+	warnings uint64
+	errors   uint64
+
+	level logLevel
+	std   *stdlog.Logger
+}
+
+// log is the logger of the simulator
+var log = newLogger(os.Stderr)
+
+// newLogger returns a logger writing messages of level info and above
+// to w
+func newLogger(w io.Writer) *logger {
+	return &logger{
+		level: levelInfo,
+		std:   stdlog.New(w, "", stdlog.LstdFlags),
+	}
+}
+
+// SetLevel sets the level of the logger by name, it must be called before
+// the logger is used concurrently
+func (l *logger) SetLevel(name string) error {
+	level, ok := logLevels[name]
+	if !ok {
+		names := make([]string, 0, len(logLevels))
+		for name := range logLevels {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("unknown log level %q, valid levels are: %s",
+			name, strings.Join(names, ", "))
+	}
+	l.level = level
+	return nil
+}
+
+// SetPrefix sets the prefix of every message
+func (l *logger) SetPrefix(prefix string) {
+	l.std.SetPrefix(prefix)
+}
+
+// SetOutput sets the writer the messages are written to
+func (l *logger) SetOutput(w io.Writer) {
+	l.std.SetOutput(w)
+}
+
+// printf writes the message if its level is enabled
+func (l *logger) printf(level logLevel, format string, args ...interface{}) {
+	switch {
+	case level >= levelError:
+		atomic.AddUint64(&l.errors, 1)
+	case level == levelWarn:
+		atomic.AddUint64(&l.warnings, 1)
+	}
+	if level < l.level {
+		return
+	}
+	l.std.Printf("[%s] %s", levelTags[level], fmt.Sprintf(format, args...))
+}
+
+// Tracef logs a message at the trace level
+func (l *logger) Tracef(format string, args ...interface{}) {
+	l.printf(levelTrace, format, args...)
+}
+
+// Debugf logs a message at the debug level
+func (l *logger) Debugf(format string, args ...interface{}) {
+	l.printf(levelDebug, format, args...)
+}
+
+// Infof logs a message at the info level
+func (l *logger) Infof(format string, args ...interface{}) {
+	l.printf(levelInfo, format, args...)
+}
+
+// Warnf logs a message at the warn level
+func (l *logger) Warnf(format string, args ...interface{}) {
+	l.printf(levelWarn, format, args...)
+}
+
+// Errorf logs a message at the error level
+func (l *logger) Errorf(format string, args ...interface{}) {
+	l.printf(levelError, format, args...)
+}
+
+// Criticalf logs a message at the critical level
+func (l *logger) Criticalf(format string, args ...interface{}) {
+	l.printf(levelCritical, format, args...)
+}
+
+// Counts returns the number of warnings and errors logged so far
+func (l *logger) Counts() (warnings, errors uint64) {
+	return atomic.LoadUint64(&l.warnings), atomic.LoadUint64(&l.errors)
+}
+
+// maxLogRotations is the number of rotated log files kept besides the
+// current one
+const maxLogRotations = 3
+
+// rotatingFile is a log file which is rotated once it grows over maxSize
+// bytes, the previous files are kept with the suffixes .1 (the most recent)
+// to .maxLogRotations. A maxSize of zero disables rotation.
+type rotatingFile struct {
+	mtx     sync.Mutex
+	path    string
+	maxSize int64
+	file    *os.File
+	size    int64
+}
+
+// newRotatingFile creates the log file at path
+func newRotatingFile(path string, maxSize int64) (*rotatingFile, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &rotatingFile{
+		path:    path,
+		maxSize: maxSize,
+		file:    file,
+	}, nil
+}
+
+// Write writes p to the log file, rotating it first if p would make it
+// grow over maxSize
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// rotate shifts the rotated files, renames the current file with the .1
+// suffix and creates a new one. It must be called with the mutex held.
+func (f *rotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	f.file = nil
+	for i := maxLogRotations - 1; i > 0; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", f.path, i), fmt.Sprintf("%s.%d", f.path, i+1))
+	}
+	if err := os.Rename(f.path, f.path+".1"); err != nil {
+		return err
+	}
+	file, err := os.Create(f.path)
+	if err != nil {
+		return err
+	}
+	f.file = file
+	f.size = 0
+	return nil
+}
+
+// Close closes the log file
+func (f *rotatingFile) Close() error {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
@@ -0,0 +1,95 @@
+package btcsim
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoggerLevel(t *testing.T) {
+	var buf bytes.Buffer
+	l := newLogger(&buf)
+	if err := l.SetLevel("warn"); err != nil {
+		t.Fatalf("SetLevel error: %v", err)
+	}
+	l.Infof("hidden %d", 1)
+	l.Warnf("shown %d", 2)
+	l.Errorf("shown %d", 3)
+	out := buf.String()
+	if strings.Contains(out, "hidden") {
+		t.Errorf("info message logged at warn level:\n%s", out)
+	}
+	for _, want := range []string{"[WRN] shown 2", "[ERR] shown 3"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q:\n%s", want, out)
+		}
+	}
+	if err := l.SetLevel("verbose"); err == nil {
+		t.Errorf("SetLevel accepted an unknown level")
+	}
+}
+
+func TestLoggerCounts(t *testing.T) {
+	var buf bytes.Buffer
+	l := newLogger(&buf)
+	if err := l.SetLevel("off"); err != nil {
+		t.Fatalf("SetLevel error: %v", err)
+	}
+	l.Infof("info")
+	l.Warnf("warning")
+	l.Errorf("error")
+	l.Criticalf("critical")
+	if warnings, errors := l.Counts(); warnings != 1 || errors != 2 {
+		t.Errorf("Counts got %d warnings, %d errors, want 1 and 2", warnings, errors)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("messages logged while off:\n%s", buf.String())
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "btcsim-log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "node.log")
+
+	f, err := newRotatingFile(path, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every line fills the file so each write after the first rotates it
+	lines := []string{"line one\n", "line two\n", "line 333\n", "line 444\n", "line 555\n"}
+	for _, line := range lines {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatalf("Write error: %v", err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close error: %v", err)
+	}
+
+	want := map[string]string{
+		path:        lines[4],
+		path + ".1": lines[3],
+		path + ".2": lines[2],
+		path + ".3": lines[1],
+	}
+	for p, w := range want {
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			t.Errorf("cannot read %s: %v", p, err)
+			continue
+		}
+		if string(b) != w {
+			t.Errorf("%s got %q, want %q", p, b, w)
+		}
+	}
+	if _, err := os.Stat(path + ".4"); !os.IsNotExist(err) {
+		t.Errorf("more than %d rotated files kept", maxLogRotations)
+	}
+}
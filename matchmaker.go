@@ -0,0 +1,258 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/btcsuite/btcutil"
+)
+
+// matchPolicy picks the payee of the next payment of payer among the
+// given actors, it is called with the matchmaker mutex held
+type matchPolicy func(m *matchmaker, payer *Actor, actors []*Actor) *Actor
+
+// matchPolicies maps the names accepted by the -matching flag to the
+// policies they select
+var matchPolicies = map[string]matchPolicy{
+	"random":       matchRandom,
+	"preferential": matchPreferential,
+	"fixed":        matchFixed,
+}
+
+// getMatchPolicy returns the match policy with the given name
+func getMatchPolicy(name string) (matchPolicy, error) {
+	p, ok := matchPolicies[name]
+	if !ok {
+		names := make([]string, 0, len(matchPolicies))
+		for name := range matchPolicies {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown matching policy %q, valid policies are: %s",
+			name, strings.Join(names, ", "))
+	}
+	return p, nil
+}
+
+// matchRandom pays any actor, the payer included, with equal probability
+func matchRandom(m *matchmaker, payer *Actor, actors []*Actor) *Actor {
+	return actors[m.rand.Intn(len(actors))]
+}
+
+// matchPreferential pays another actor with a probability proportional
+// to one plus the number of payments it received, so that a few actors
+// end up receiving most payments as in a preferential attachment network
+func matchPreferential(m *matchmaker, payer *Actor, actors []*Actor) *Actor {
+	var total int
+	for _, a := range actors {
+		if a != payer {
+			total += 1 + m.received[a]
+		}
+	}
+	if total == 0 {
+		return payer
+	}
+	n := m.rand.Intn(total)
+	for _, a := range actors {
+		if a == payer {
+			continue
+		}
+		n -= 1 + m.received[a]
+		if n < 0 {
+			return a
+		}
+	}
+	return payer
+}
+
+// matchFixed always pays the same counterparty, picked at random among
+// the other actors on the first payment and again if it leaves
+func matchFixed(m *matchmaker, payer *Actor, actors []*Actor) *Actor {
+	if c, ok := m.counterparties[payer]; ok {
+		for _, a := range actors {
+			if a == c {
+				return c
+			}
+		}
+	}
+	var others []*Actor
+	for _, a := range actors {
+		if a != payer {
+			others = append(others, a)
+		}
+	}
+	if len(others) == 0 {
+		return payer
+	}
+	c := others[m.rand.Intn(len(others))]
+	m.counterparties[payer] = c
+	return c
+}
+
+// payment is a payment between two actors waiting to be confirmed, it
+// fulfills an invoice of the payee with invoices
+type payment struct {
+	payer   *Actor
+	payee   *Actor
+	amount  btcutil.Amount
+	invoice *invoice
+}
+
+// matchmaker pairs actors sending a transaction with the actors they
+// pay according to its policy, and tracks the payments until they are
+// confirmed to let both actors know
+type matchmaker struct {
+	mtx    sync.Mutex
+	policy matchPolicy
+	rand   *rand.Rand
+	actors func() []*Actor
+
+	// received is the number of payments received by each actor and
+	// counterparties are the actors paid by the fixed policy
+	received       map[*Actor]int
+	counterparties map[*Actor]*Actor
+
+	// outstanding are the payments sent but not confirmed yet by txid,
+	// batch payouts pay several actors in the same transaction. Fee
+	// bumps map to the txid of the transaction they replace in original
+	// and bumps lists them by that txid.
+	outstanding map[string][]*payment
+	original    map[string]string
+	bumps       map[string][]string
+	payments    int
+	confirmed   int
+
+	// invoices are the invoices of the payees, nil unless payments are
+	// requested with invoices
+	invoices *invoiceBook
+}
+
+// newMatchmaker returns a matchmaker using the given policy to pair the
+// actors returned by actors in the run configured by cfg
+func newMatchmaker(cfg *Config, policy matchPolicy, actors func() []*Actor) *matchmaker {
+	return &matchmaker{
+		policy:         policy,
+		rand:           cfg.newRand(matchStream),
+		actors:         actors,
+		received:       make(map[*Actor]int),
+		counterparties: make(map[*Actor]*Actor),
+		outstanding:    make(map[string][]*payment),
+		original:       make(map[string]string),
+		bumps:          make(map[string][]string),
+	}
+}
+
+// payee returns the actor paid by payer and the address to pay, it
+// returns the payer itself if it is the only actor
+func (m *matchmaker) payee(payer *Actor) (*Actor, btcutil.Address) {
+	actors := m.actors()
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	payee := payer
+	if len(actors) > 0 {
+		payee = m.policy(m, payer, actors)
+	}
+	return payee, payee.ownedAddresses[m.rand.Intn(len(payee.ownedAddresses))]
+}
+
+// sent records the payments sent in the given transaction
+func (m *matchmaker) sent(txid string, ps ...*payment) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.outstanding[txid] = append(m.outstanding[txid], ps...)
+	for _, p := range ps {
+		m.received[p.payee]++
+		m.payments++
+		if p.invoice != nil {
+			m.invoices.pay(p.invoice, txid)
+		}
+		if o := p.payee.observePayment(); o != nil {
+			o.onPaymentSent(p.payee, p, txid)
+		}
+	}
+}
+
+// replaced records the fee bump of the transaction replaced, so that its
+// payments are confirmed when either of them is mined
+func (m *matchmaker) replaced(e *Event) {
+	if e.Tx.Replaces == "" {
+		return
+	}
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	txid := e.Tx.Replaces
+	if original, ok := m.original[txid]; ok {
+		txid = original
+	}
+	if _, ok := m.outstanding[txid]; !ok {
+		return
+	}
+	m.original[e.Tx.TxID] = txid
+	m.bumps[txid] = append(m.bumps[txid], e.Tx.TxID)
+}
+
+// confirm marks the payments sent in the given transactions, or in the
+// transactions they replace, mined in the block at the given height, as
+// confirmed and lets their payer and payee know
+func (m *matchmaker) confirm(height int32, txids []string) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	for _, txid := range txids {
+		if original, ok := m.original[txid]; ok {
+			txid = original
+		}
+		ps, ok := m.outstanding[txid]
+		if !ok {
+			continue
+		}
+		delete(m.outstanding, txid)
+		for _, bump := range m.bumps[txid] {
+			delete(m.original, bump)
+		}
+		delete(m.bumps, txid)
+		for _, p := range ps {
+			m.confirmed++
+			p.payer.paymentConfirmed(p)
+			p.payee.paymentConfirmed(p)
+			if p.invoice != nil {
+				m.invoices.confirm(p.invoice)
+			}
+			if o := p.payee.observePayment(); o != nil {
+				o.onPaymentMined(p.payee, p, height)
+			}
+		}
+	}
+}
+
+// counts returns the number of payments sent and confirmed
+func (m *matchmaker) counts() (payments, confirmed int) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	return m.payments, m.confirmed
+}
+
+// paymentConfirmed counts a confirmed payment sent or received by the
+// actor
+func (a *Actor) paymentConfirmed(p *payment) {
+	if p.payer == a {
+		atomic.AddUint64(&a.paid, 1)
+	}
+	if p.payee == a {
+		atomic.AddUint64(&a.paidBy, 1)
+	}
+}
+
+// Payments returns the number of confirmed payments sent and received
+// by the actor
+func (a *Actor) Payments() (sent, received uint64) {
+	return atomic.LoadUint64(&a.paid), atomic.LoadUint64(&a.paidBy)
+}
@@ -0,0 +1,103 @@
+package btcsim
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcutil"
+)
+
+// matchActors returns n fake actors owning one address each
+func matchActors(n int) []*Actor {
+	actors := make([]*Actor, n)
+	for i := range actors {
+		actors[i] = fakeActor(string(rune('a' + i)))
+		actors[i].ownedAddresses = []btcutil.Address{&btcutil.AddressPubKeyHash{}}
+	}
+	return actors
+}
+
+func TestMatchPolicies(t *testing.T) {
+	actors := matchActors(4)
+	list := func() []*Actor { return actors }
+
+	for name := range matchPolicies {
+		policy, err := getMatchPolicy(name)
+		if err != nil {
+			t.Fatalf("getMatchPolicy(%q) error: %v", name, err)
+		}
+		m := newMatchmaker(DefaultConfig(), policy, list)
+		first, _ := m.payee(actors[0])
+		for i := 0; i < 100; i++ {
+			payee, addr := m.payee(actors[0])
+			if addr != payee.ownedAddresses[0] {
+				t.Errorf("%s: address not owned by the payee", name)
+			}
+			if name != "random" && payee == actors[0] {
+				t.Errorf("%s: payer paid itself", name)
+			}
+			if name == "fixed" && payee != first {
+				t.Errorf("fixed: payee changed from %v to %v", first, payee)
+			}
+		}
+	}
+
+	if _, err := getMatchPolicy("bogus"); err == nil {
+		t.Errorf("getMatchPolicy accepted an unknown policy")
+	}
+}
+
+func TestMatchPreferential(t *testing.T) {
+	actors := matchActors(3)
+	m := newMatchmaker(DefaultConfig(), matchPreferential, func() []*Actor { return actors })
+	// actor b received many payments so it is paid far more often
+	m.received[actors[1]] = 98
+	var paid int
+	for i := 0; i < 1000; i++ {
+		if payee, _ := m.payee(actors[0]); payee == actors[1] {
+			paid++
+		}
+	}
+	if paid < 900 {
+		t.Errorf("popular actor paid %d times out of 1000, want at least 900", paid)
+	}
+}
+
+func TestMatchConfirm(t *testing.T) {
+	actors := matchActors(2)
+	m := newMatchmaker(DefaultConfig(), matchRandom, func() []*Actor { return actors })
+	m.sent("tx1", &payment{payer: actors[0], payee: actors[1], amount: 1})
+	m.sent("tx2", &payment{payer: actors[1], payee: actors[0], amount: 1})
+
+	m.confirm(1, []string{"tx1", "unknown"})
+	if payments, confirmed := m.counts(); payments != 2 || confirmed != 1 {
+		t.Errorf("got %d payments and %d confirmed, want 2 and 1", payments, confirmed)
+	}
+	if sent, received := actors[0].Payments(); sent != 1 || received != 0 {
+		t.Errorf("payer got %d sent and %d received, want 1 and 0", sent, received)
+	}
+	if sent, received := actors[1].Payments(); sent != 0 || received != 1 {
+		t.Errorf("payee got %d sent and %d received, want 0 and 1", sent, received)
+	}
+
+	// a payment is only confirmed once
+	m.confirm(1, []string{"tx1"})
+	if _, confirmed := m.counts(); confirmed != 1 {
+		t.Errorf("got %d confirmed after confirming twice, want 1", confirmed)
+	}
+}
+
+func TestMatchConfirmBatch(t *testing.T) {
+	actors := matchActors(3)
+	m := newMatchmaker(DefaultConfig(), matchRandom, func() []*Actor { return actors })
+	m.sent("tx1",
+		&payment{payer: actors[0], payee: actors[1], amount: 1},
+		&payment{payer: actors[0], payee: actors[2], amount: 1})
+
+	m.confirm(1, []string{"tx1"})
+	if payments, confirmed := m.counts(); payments != 2 || confirmed != 2 {
+		t.Errorf("got %d payments and %d confirmed, want 2 and 2", payments, confirmed)
+	}
+	if sent, _ := actors[0].Payments(); sent != 2 {
+		t.Errorf("payer got %d sent, want 2", sent)
+	}
+}
@@ -0,0 +1,189 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/btcsuite/btcd/wire"
+)
+
+// mempoolSample is a snapshot of how much the mempools of the nodes differ
+type mempoolSample struct {
+	time time.Time
+
+	// union is the number of transactions in any mempool, common the
+	// number in all of them and divergent the number missing from some
+	union     int
+	common    int
+	divergent int
+
+	// maxPair is the largest symmetric difference between the mempools
+	// of two nodes, the ones with the given indices
+	maxPair      int
+	pairA, pairB int
+}
+
+// String returns the sample as a single line given the node names
+func (s *mempoolSample) String(names []string) string {
+	line := fmt.Sprintf("%d tx in all %d mempools, %d missing from some",
+		s.common, len(names), s.divergent)
+	if s.maxPair > 0 {
+		line += fmt.Sprintf(", largest difference %d tx between %s and %s",
+			s.maxPair, names[s.pairA], names[s.pairB])
+	}
+	return line
+}
+
+// newMempoolSample returns how much the given mempools of the nodes
+// differ at time t
+func newMempoolSample(t time.Time, mempools [][]*wire.ShaHash) *mempoolSample {
+	s := &mempoolSample{time: t}
+	sets := make([]map[wire.ShaHash]bool, len(mempools))
+	count := make(map[wire.ShaHash]int)
+	for i, mempool := range mempools {
+		sets[i] = make(map[wire.ShaHash]bool, len(mempool))
+		for _, hash := range mempool {
+			if !sets[i][*hash] {
+				sets[i][*hash] = true
+				count[*hash]++
+			}
+		}
+	}
+	s.union = len(count)
+	for _, n := range count {
+		if n == len(mempools) {
+			s.common++
+		}
+	}
+	s.divergent = s.union - s.common
+
+	for i := range sets {
+		for j := i + 1; j < len(sets); j++ {
+			diff := 0
+			for hash := range sets[i] {
+				if !sets[j][hash] {
+					diff++
+				}
+			}
+			for hash := range sets[j] {
+				if !sets[i][hash] {
+					diff++
+				}
+			}
+			if diff > s.maxPair {
+				s.maxPair, s.pairA, s.pairB = diff, i, j
+			}
+		}
+	}
+	return s
+}
+
+// MempoolDivergence summarizes how much the mempools of the nodes
+// differed while the simulation ran
+type MempoolDivergence struct {
+	Samples       int     `json:"samples"`
+	MeanDivergent float64 `json:"meandivergent"`
+	MaxDivergent  int     `json:"maxdivergent"`
+	MaxPair       int     `json:"maxpair"`
+}
+
+// newMempoolDivergence returns the summary of the given samples
+func newMempoolDivergence(samples []*mempoolSample) *MempoolDivergence {
+	d := &MempoolDivergence{Samples: len(samples)}
+	if len(samples) == 0 {
+		return d
+	}
+	var total int
+	for _, s := range samples {
+		total += s.divergent
+		if s.divergent > d.MaxDivergent {
+			d.MaxDivergent = s.divergent
+		}
+		if s.maxPair > d.MaxPair {
+			d.MaxPair = s.maxPair
+		}
+	}
+	d.MeanDivergent = float64(total) / float64(len(samples))
+	return d
+}
+
+// monitorMempools runs as a goroutine and, every interval, compares the
+// mempools of the given nodes and logs how much they differ until the
+// simulation exits
+func (com *Communication) monitorMempools(nodes []*Node, interval time.Duration) {
+	defer com.wg.Done()
+
+	names := make([]string, len(nodes))
+	for i, node := range nodes {
+		names[i] = node.String()
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			mempools := make([][]*wire.ShaHash, len(nodes))
+			var err error
+			for i, node := range nodes {
+				if mempools[i], err = node.client.GetRawMempool(); err != nil {
+					log.Errorf("%s: Cannot get mempool: %v", node, err)
+					break
+				}
+			}
+			if err != nil {
+				continue
+			}
+			s := newMempoolSample(now, mempools)
+			com.mempoolSamples = append(com.mempoolSamples, s)
+			log.Infof("Mempool divergence: %s", s.String(names))
+		case <-com.exit:
+			return
+		}
+	}
+}
+
+// writeMempoolStatsCSV writes the mempool divergence samples as CSV with
+// a header row
+func writeMempoolStatsCSV(w io.Writer, samples []*mempoolSample) error {
+	writer := csv.NewWriter(w)
+	header := []string{"time", "union", "common", "divergent", "maxpair"}
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+	for _, s := range samples {
+		row := []string{
+			s.time.Format(time.RFC3339Nano),
+			strconv.Itoa(s.union),
+			strconv.Itoa(s.common),
+			strconv.Itoa(s.divergent),
+			strconv.Itoa(s.maxPair),
+		}
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// writeMempoolStats writes the mempool divergence samples to the given
+// path as CSV
+func writeMempoolStats(path string, samples []*mempoolSample) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := writeMempoolStatsCSV(file, samples); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,57 @@
+package btcsim
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/wire"
+)
+
+func TestNewMempoolSample(t *testing.T) {
+	a, b, c, d := &wire.ShaHash{1}, &wire.ShaHash{2}, &wire.ShaHash{3}, &wire.ShaHash{4}
+	mempools := [][]*wire.ShaHash{
+		{a, b, c},
+		{a, b},
+		{a, d},
+	}
+	s := newMempoolSample(time.Now(), mempools)
+	if s.union != 4 || s.common != 1 || s.divergent != 3 {
+		t.Errorf("got union %d, common %d, divergent %d, want 4, 1, 3",
+			s.union, s.common, s.divergent)
+	}
+	if s.maxPair != 3 || s.pairA != 0 || s.pairB != 2 {
+		t.Errorf("got largest difference %d between %d and %d, want 3 between 0 and 2",
+			s.maxPair, s.pairA, s.pairB)
+	}
+	line := s.String([]string{"node", "node-1", "node-2"})
+	if !strings.Contains(line, "between node and node-2") {
+		t.Errorf("sample got %q", line)
+	}
+
+	// identical mempools do not diverge
+	s = newMempoolSample(time.Now(), [][]*wire.ShaHash{{a, b}, {b, a}})
+	if s.divergent != 0 || s.maxPair != 0 {
+		t.Errorf("got divergent %d, largest difference %d, want 0, 0", s.divergent, s.maxPair)
+	}
+}
+
+func TestMempoolDivergence(t *testing.T) {
+	samples := []*mempoolSample{
+		{divergent: 2, maxPair: 2},
+		{divergent: 4, maxPair: 3},
+	}
+	d := newMempoolDivergence(samples)
+	if d.Samples != 2 || d.MeanDivergent != 3 || d.MaxDivergent != 4 || d.MaxPair != 3 {
+		t.Errorf("newMempoolDivergence got %+v", d)
+	}
+
+	var buf bytes.Buffer
+	if err := writeMempoolStatsCSV(&buf, samples); err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(buf.String(), "\n"); n != 3 {
+		t.Errorf("writeMempoolStatsCSV got %d lines want 3", n)
+	}
+}
@@ -0,0 +1,277 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	rpc "github.com/btcsuite/btcrpcclient"
+	"github.com/btcsuite/btcutil"
+)
+
+// memWallet is the in-process wallet of an actor, it holds the keys of
+// the addresses of the actor and signs its transactions itself so that
+// no btcwallet process is needed. Its addresses are those of the network
+// of params.
+type memWallet struct {
+	mtx     sync.RWMutex
+	params  *chaincfg.Params
+	keys    map[string]*memKey
+	scripts map[string][]byte
+
+	// hd derives the keys of an HD wallet, indexes holds the derivation
+	// index of its watched addresses
+	hd      *hdAccount
+	indexes map[string]uint32
+}
+
+// memKey is a key of an in-process wallet, its address hashes either the
+// compressed or uncompressed public key
+type memKey struct {
+	priv       *btcec.PrivateKey
+	compressed bool
+}
+
+// newMemWallet returns an in-process wallet without keys on the network of
+// params
+func newMemWallet(params *chaincfg.Params) *memWallet {
+	return &memWallet{
+		params:  params,
+		keys:    make(map[string]*memKey),
+		scripts: make(map[string][]byte),
+	}
+}
+
+// newKey creates a random key, or derives the next key of an HD wallet
+func (w *memWallet) newKey() (*btcec.PrivateKey, error) {
+	if w.hd == nil {
+		return btcec.NewPrivateKey(btcec.S256())
+	}
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	derived := len(w.hd.keys)
+	_, key, err := w.hd.nextKey()
+	if err != nil {
+		return nil, err
+	}
+	return key, w.watchLookahead(derived)
+}
+
+// newAddress creates a key and returns its address of the given type, a
+// p2sh address pays to a 1-of-1 multisig script of the key
+func (w *memWallet) newAddress(kind string) (btcutil.Address, error) {
+	priv, err := w.newKey()
+	if err != nil {
+		return nil, err
+	}
+	key := &memKey{priv: priv, compressed: kind != addrUncompressed}
+	pubKey := priv.PubKey().SerializeCompressed()
+	if !key.compressed {
+		pubKey = priv.PubKey().SerializeUncompressed()
+	}
+	pkAddr, err := btcutil.NewAddressPubKeyHash(btcutil.Hash160(pubKey), w.params)
+	if err != nil {
+		return nil, err
+	}
+
+	var addr btcutil.Address = pkAddr
+	var script []byte
+	if kind == addrP2SH {
+		pub, err := btcutil.NewAddressPubKey(pubKey, w.params)
+		if err != nil {
+			return nil, err
+		}
+		script, err = txscript.MultiSigScript([]*btcutil.AddressPubKey{pub}, 1)
+		if err != nil {
+			return nil, err
+		}
+		if addr, err = btcutil.NewAddressScriptHash(script, w.params); err != nil {
+			return nil, err
+		}
+	}
+
+	w.mtx.Lock()
+	w.keys[pkAddr.EncodeAddress()] = key
+	if script != nil {
+		w.scripts[addr.EncodeAddress()] = script
+	}
+	w.mtx.Unlock()
+	return addr, nil
+}
+
+// key returns the key of the given address, it is the key lookup of the
+// signatures and reports whether the key is compressed
+func (w *memWallet) key(addr btcutil.Address) (*btcec.PrivateKey, bool, error) {
+	w.mtx.RLock()
+	key, ok := w.keys[addr.EncodeAddress()]
+	w.mtx.RUnlock()
+	if !ok {
+		return nil, false, fmt.Errorf("no key for address %s", addr)
+	}
+	return key.priv, key.compressed, nil
+}
+
+// script returns the redeem script of the given p2sh address, it is the
+// script lookup of the signatures
+func (w *memWallet) script(addr btcutil.Address) ([]byte, error) {
+	w.mtx.RLock()
+	script, ok := w.scripts[addr.EncodeAddress()]
+	w.mtx.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("no script for address %s", addr)
+	}
+	return script, nil
+}
+
+// owns reports whether the wallet holds the key or redeem script of one
+// of the addresses paid by the given script
+func (w *memWallet) owns(pkScript []byte) bool {
+	_, addrs, _, err := txscript.ExtractPkScriptAddrs(pkScript, w.params)
+	if err != nil {
+		return false
+	}
+	w.mtx.RLock()
+	defer w.mtx.RUnlock()
+	for _, addr := range addrs {
+		if _, ok := w.keys[addr.EncodeAddress()]; ok {
+			return true
+		}
+		if _, ok := w.scripts[addr.EncodeAddress()]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// sign signs the inputs of the transaction spending outputs of the wallet
+// and reports whether all the inputs are signed. The scripts of the
+// outputs spent are taken from prevOuts or else from the chain server,
+// mempool included.
+func (w *memWallet) sign(client *rpcClient, msgTx *wire.MsgTx,
+	prevOuts []btcjson.RawTxInput) (*wire.MsgTx, bool, error) {
+
+	scripts := make(map[wire.OutPoint]string, len(prevOuts))
+	for _, prevOut := range prevOuts {
+		hash, err := wire.NewShaHashFromStr(prevOut.Txid)
+		if err != nil {
+			return nil, false, err
+		}
+		scripts[wire.OutPoint{Hash: *hash, Index: prevOut.Vout}] = prevOut.ScriptPubKey
+	}
+
+	// the other scripts are all requested before waiting for the replies
+	futures := make(map[int]futureGetTxOut)
+	for i, txIn := range msgTx.TxIn {
+		op := txIn.PreviousOutPoint
+		if _, ok := scripts[op]; !ok {
+			futures[i] = client.GetTxOutAsync(&op.Hash, op.Index, true)
+		}
+	}
+	for i, future := range futures {
+		txOut, err := future.Receive()
+		if err != nil {
+			return nil, false, err
+		}
+		if txOut == nil {
+			return nil, false, errors.New("output spent is unknown")
+		}
+		scripts[msgTx.TxIn[i].PreviousOutPoint] = txOut.ScriptPubKey.Hex
+	}
+
+	complete := true
+	for i, txIn := range msgTx.TxIn {
+		pkScript, err := hex.DecodeString(scripts[txIn.PreviousOutPoint])
+		if err != nil {
+			return nil, false, err
+		}
+		if !w.owns(pkScript) {
+			// inputs of other wallets may have been signed already
+			if len(txIn.SignatureScript) == 0 {
+				complete = false
+			}
+			continue
+		}
+		sigScript, err := txscript.SignTxOutput(w.params, msgTx, i,
+			pkScript, txscript.SigHashAll, txscript.KeyClosure(w.key), txscript.ScriptClosure(w.script), nil)
+		if err != nil {
+			return nil, false, err
+		}
+		txIn.SignatureScript = sigScript
+	}
+	return msgTx, complete, nil
+}
+
+// memWalletArgs are the args of the node of an actor with an in-process
+// wallet, which has no process and connects to the chain server directly
+// or takes a client from the pool of the chain server if any
+type memWalletArgs struct {
+	prefix string
+	server Args
+	pool   *clientPool
+}
+
+// newMemWalletArgs returns the args of an in-process wallet named after
+// the given port connecting to the given chain server
+func newMemWalletArgs(port uint16, server Args, pool *clientPool) *memWalletArgs {
+	return &memWalletArgs{
+		prefix: fmt.Sprintf("actor-%d", port),
+		server: server,
+		pool:   pool,
+	}
+}
+
+// String returns the prefix of the actor
+func (a *memWalletArgs) String() string {
+	return a.prefix
+}
+
+// Arguments returns no arguments as there is no process
+func (a *memWalletArgs) Arguments() []string {
+	return nil
+}
+
+// Command returns nil as there is no process
+func (a *memWalletArgs) Command() *exec.Cmd {
+	return nil
+}
+
+// RPCConnConfig returns the websocket connection to the chain server
+func (a *memWalletArgs) RPCConnConfig() rpc.ConnConfig {
+	return a.server.RPCConnConfig()
+}
+
+// Cleanup does nothing as there are no files
+func (a *memWalletArgs) Cleanup() error {
+	return nil
+}
+
+// checkInProcess returns an error if the actors run in-process with a
+// feature requiring wallet processes
+func checkInProcess(inProcess bool, multisig, saveState, loadState string,
+	chaos time.Duration) error {
+
+	if !inProcess {
+		return nil
+	}
+	switch {
+	case chaos > 0:
+		return errors.New("chaos kills wallet processes, in-process wallets have none")
+	case multisig != "":
+		return errors.New("multisig payments need btcwallet actors")
+	case saveState != "" || loadState != "":
+		return errors.New("in-process wallets cannot be saved or restored")
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package btcsim
+
+import (
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+)
+
+func TestMemWalletNode(t *testing.T) {
+	args := newMemWalletArgs(18557, &fakeArgs{name: "btcd"}, nil)
+	if args.String() != "actor-18557" {
+		t.Errorf("got name %q, want actor-18557", args)
+	}
+	n, err := NewNodeFromArgs(DefaultConfig(), args, nil, nil)
+	if err != nil {
+		t.Fatalf("NewNodeFromArgs error: %v", err)
+	}
+	// the node has no process to kill or stop
+	if err := n.Kill(); err == nil {
+		t.Errorf("Kill of a node without process succeeded")
+	}
+	if err := n.Stop(); err != nil {
+		t.Errorf("Stop error: %v", err)
+	}
+}
+
+func TestMemWalletKey(t *testing.T) {
+	w := newMemWallet(&chaincfg.SimNetParams)
+	if _, _, err := w.key(fakeAddress("unknown")); err == nil {
+		t.Errorf("got the key of an unknown address")
+	}
+	if _, err := w.script(fakeAddress("unknown")); err == nil {
+		t.Errorf("got the script of an unknown address")
+	}
+}
+
+func TestCheckInProcess(t *testing.T) {
+	tests := []struct {
+		inProcess bool
+		multisig  string
+		saveState string
+		chaos     time.Duration
+		ok        bool
+	}{
+		{false, "2-of-3", "state", time.Second, true},
+		{true, "", "", 0, true},
+		{true, "2-of-3", "", 0, false},
+		{true, "", "state", 0, false},
+		{true, "", "", time.Second, false},
+	}
+	for i, test := range tests {
+		err := checkInProcess(test.inProcess, test.multisig, test.saveState, "",
+			test.chaos)
+		if (err == nil) != test.ok {
+			t.Errorf("test %d: got error %v, want ok %v", i, err, test.ok)
+		}
+	}
+}
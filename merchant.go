@@ -0,0 +1,239 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/btcsuite/btcutil"
+)
+
+// behaviorMerchant is the name of the merchant behavior, optionally
+// followed by a colon and the number of confirmations it waits for, e.g.
+// merchant:0 ships on unconfirmed payments
+const behaviorMerchant = "merchant"
+
+// defaultMerchantConfs is the number of confirmations a merchant waits for
+// unless given
+const defaultMerchantConfs = 1
+
+// paymentObserver is implemented by the behaviors which follow the
+// payments to their actor from the moment they are sent, rather than once
+// their outputs are queued to be spent. The methods are called from the
+// goroutines of the payer and of the matchmaker.
+type paymentObserver interface {
+	// onPaymentSent is called when a payment to the actor is sent in
+	// the transaction txid
+	onPaymentSent(a *Actor, p *payment, txid string)
+
+	// onPaymentMined is called when the transaction paying the actor,
+	// or a fee bump of it, is mined in the block at the given height
+	onPaymentMined(a *Actor, p *payment, height int32)
+}
+
+// order is a payment to a merchant, which ships the goods once the payment
+// has enough confirmations. Orders are told apart by their payment, as fee
+// bumps replace the transaction txid sent first.
+type order struct {
+	payment *payment
+	txid    string
+	amount  btcutil.Amount
+	mined   int32
+	shipped bool
+}
+
+// merchant ships the goods of every payment it receives once the payment
+// has the confirmations of its policy, so that a payer double spending a
+// payment shipped with too few confirmations causes a loss
+type merchant struct {
+	NopBehavior
+	confs int32
+
+	// orders are all the orders taken and pending those not shipped yet
+	mtx     sync.Mutex
+	height  int32
+	orders  []*order
+	pending []*order
+}
+
+// newMerchant returns the merchant behavior with the given name, which
+// sets the number of confirmations it waits for
+func newMerchant(name string) (*merchant, error) {
+	confs := int64(defaultMerchantConfs)
+	if name != behaviorMerchant {
+		var err error
+		s := strings.TrimPrefix(name, behaviorMerchant+":")
+		if confs, err = strconv.ParseInt(s, 10, 32); err != nil || confs < 0 {
+			return nil, fmt.Errorf("invalid number of confirmations %q of merchant", s)
+		}
+	}
+	return &merchant{confs: int32(confs)}, nil
+}
+
+// isMerchant reports whether the behavior name is a merchant
+func isMerchant(name string) bool {
+	return name == behaviorMerchant || strings.HasPrefix(name, behaviorMerchant+":")
+}
+
+// onPaymentSent takes an order, which ships at once without confirmations
+func (m *merchant) onPaymentSent(a *Actor, p *payment, txid string) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	o := &order{payment: p, txid: txid, amount: p.amount}
+	m.orders = append(m.orders, o)
+	m.pending = append(m.pending, o)
+	m.ship(a)
+}
+
+// onPaymentMined records the height the payment of an order was mined at
+func (m *merchant) onPaymentMined(a *Actor, p *payment, height int32) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	for _, o := range m.pending {
+		if o.payment == p {
+			o.mined = height
+		}
+	}
+	if height > m.height {
+		m.height = height
+	}
+	m.ship(a)
+}
+
+// OnBlock ships the orders whose payment has enough confirmations
+func (m *merchant) OnBlock(a *Actor, height int32) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if height > m.height {
+		m.height = height
+	}
+	m.ship(a)
+}
+
+// ship ships the pending orders whose payment has enough confirmations,
+// the lock must be held by the caller
+func (m *merchant) ship(a *Actor) {
+	pending := m.pending[:0]
+	for _, o := range m.pending {
+		if m.confs > 0 && (o.mined == 0 || m.height-o.mined+1 < m.confs) {
+			pending = append(pending, o)
+			continue
+		}
+		o.shipped = true
+		log.Debugf("%s: Shipped order paid %v by %s", a, o.amount, o.txid)
+	}
+	m.pending = pending
+}
+
+// Orders returns a copy of the orders taken by the merchant
+func (m *merchant) Orders() []order {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	orders := make([]order, len(m.orders))
+	for i, o := range m.orders {
+		orders[i] = *o
+	}
+	return orders
+}
+
+// observePayment returns the observer of the payments to the actor, nil
+// if its behavior does not follow them
+func (a *Actor) observePayment() paymentObserver {
+	o, _ := a.behavior.(paymentObserver)
+	return o
+}
+
+// MerchantStats are the orders of a merchant and the losses it suffered
+// from payments double spent after their goods were shipped
+type MerchantStats struct {
+	Name  string `json:"name"`
+	Confs int32  `json:"confs"`
+
+	// Orders is the number of payments received, Shipped the number of
+	// orders shipped, Lost the number of orders shipped whose payment was
+	// double spent and Pending the number of orders shipped whose payment
+	// is neither mined nor double spent
+	Orders  int `json:"orders"`
+	Shipped int `json:"shipped"`
+	Lost    int `json:"lost"`
+	Pending int `json:"pending"`
+
+	// ShippedAmount is the amount of the orders shipped and LostAmount
+	// the amount of those which were lost
+	ShippedAmount int64 `json:"shippedamount"`
+	LostAmount    int64 `json:"lostamount"`
+}
+
+// newMerchantStats returns the stats of the orders of a merchant given the
+// records of the transactions of the simulation. A payment is paid when
+// its transaction or a fee bump of it is mined, and double spent when a
+// transaction conflicting with it is.
+func newMerchantStats(name string, confs int32, orders []order, records []*TxRecord) *MerchantStats {
+	replaces := make(map[string]string)
+	for _, r := range records {
+		if r.Replaces != "" {
+			replaces[r.TxID] = r.Replaces
+		}
+	}
+	paid := make(map[string]bool)
+	doubleSpent := make(map[string]bool)
+	for _, r := range records {
+		if !r.Confirmed() {
+			continue
+		}
+		// a bump of a bump pays the transaction sent first as well
+		for txid := r.TxID; txid != "" && !paid[txid]; txid = replaces[txid] {
+			paid[txid] = true
+		}
+		if r.DoubleSpend != "" {
+			doubleSpent[r.DoubleSpend] = true
+		}
+	}
+
+	s := &MerchantStats{Name: name, Confs: confs, Orders: len(orders)}
+	for _, o := range orders {
+		if !o.shipped {
+			continue
+		}
+		s.Shipped++
+		s.ShippedAmount += int64(o.amount)
+		switch {
+		case paid[o.txid]:
+		case doubleSpent[o.txid]:
+			s.Lost++
+			s.LostAmount += int64(o.amount)
+		default:
+			s.Pending++
+		}
+	}
+	return s
+}
+
+// String returns the stats in a line of the summary
+func (s *MerchantStats) String() string {
+	var rate float64
+	if s.ShippedAmount > 0 {
+		rate = float64(s.LostAmount) / float64(s.ShippedAmount) * 100
+	}
+	return fmt.Sprintf("%d confirmations, %d orders, %d shipped (%v), %d lost to double spends (%v, %.2f%%), %d pending",
+		s.Confs, s.Orders, s.Shipped, btcutil.Amount(s.ShippedAmount), s.Lost,
+		btcutil.Amount(s.LostAmount), rate, s.Pending)
+}
+
+// merchantStats returns the stats of the merchants among the actors
+func merchantStats(actors []*Actor, records []*TxRecord) []*MerchantStats {
+	var stats []*MerchantStats
+	for _, a := range actors {
+		m, ok := a.behavior.(*merchant)
+		if !ok {
+			continue
+		}
+		stats = append(stats, newMerchantStats(a.String(), m.confs, m.Orders(), records))
+	}
+	return stats
+}
@@ -0,0 +1,109 @@
+package btcsim
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewMerchant(t *testing.T) {
+	tests := []struct {
+		name  string
+		confs int32
+		ok    bool
+	}{
+		{"merchant", defaultMerchantConfs, true},
+		{"merchant:0", 0, true},
+		{"merchant:6", 6, true},
+		{"merchant:-1", 0, false},
+		{"merchant:x", 0, false},
+	}
+	for _, test := range tests {
+		b, err := newBehavior(test.name)
+		if (err == nil) != test.ok {
+			t.Errorf("newBehavior(%q) error: %v", test.name, err)
+			continue
+		}
+		if test.ok && b.(*merchant).confs != test.confs {
+			t.Errorf("newBehavior(%q) got %d confirmations want %d", test.name, b.(*merchant).confs, test.confs)
+		}
+	}
+	if err := RegisterBehavior("merchant:2", func() ActorBehavior { return NopBehavior{} }); err == nil {
+		t.Errorf("registered a merchant behavior")
+	}
+}
+
+func TestMerchantShipping(t *testing.T) {
+	a := fakeActor("merchant")
+	p := &payment{payee: a, amount: 1000}
+	zero, _ := newMerchant("merchant:0")
+	two, _ := newMerchant("merchant:2")
+	for _, m := range []*merchant{zero, two} {
+		m.OnBlock(a, 10)
+		m.onPaymentSent(a, p, "tx")
+	}
+	if !zero.Orders()[0].shipped {
+		t.Errorf("0-conf merchant did not ship an unconfirmed payment")
+	}
+	if two.Orders()[0].shipped {
+		t.Errorf("merchant shipped an unconfirmed payment")
+	}
+	two.onPaymentMined(a, p, 11)
+	if two.Orders()[0].shipped {
+		t.Errorf("merchant shipped a payment with 1 confirmation")
+	}
+	two.OnBlock(a, 12)
+	if !two.Orders()[0].shipped {
+		t.Errorf("merchant did not ship a payment with 2 confirmations")
+	}
+}
+
+func TestMerchantFeeBump(t *testing.T) {
+	a := fakeActor("merchant")
+	m, _ := newMerchant("merchant:1")
+	a.behavior = m
+	mm := newMatchmaker(DefaultConfig(), nil, nil)
+	mm.sent("tx", &payment{payer: fakeActor("payer"), payee: a, amount: 1000})
+	mm.replaced(&Event{Tx: &TxRecord{TxID: "bump", Replaces: "tx"}})
+	mm.replaced(&Event{Tx: &TxRecord{TxID: "bump2", Replaces: "bump"}})
+	mm.confirm(11, []string{"bump2"})
+	m.OnBlock(a, 11)
+	if !m.Orders()[0].shipped {
+		t.Errorf("merchant did not ship a payment mined in a fee bump")
+	}
+	if payments, confirmed := mm.counts(); payments != 1 || confirmed != 1 {
+		t.Errorf("%d payments, %d confirmed, want 1", payments, confirmed)
+	}
+	if len(mm.original) != 0 || len(mm.bumps) != 0 {
+		t.Errorf("fee bumps still tracked after being mined")
+	}
+}
+
+func TestMerchantStats(t *testing.T) {
+	orders := []order{
+		{txid: "paid", amount: 100, shipped: true},
+		{txid: "bumped", amount: 200, shipped: true},
+		{txid: "bumped twice", amount: 200, shipped: true},
+		{txid: "victim", amount: 300, shipped: true},
+		{txid: "unconfirmed", amount: 400, shipped: true},
+		{txid: "waiting", amount: 500},
+	}
+	records := []*TxRecord{
+		{TxID: "paid", Height: 1},
+		{TxID: "bump", Replaces: "bumped", Height: 2},
+		{TxID: "bump once", Replaces: "bumped twice"},
+		{TxID: "bump twice", Replaces: "bump once", Height: 2},
+		{TxID: "victim"},
+		{TxID: "conflict", DoubleSpend: "victim", Height: 2},
+		{TxID: "unconfirmed"},
+	}
+	s := newMerchantStats("merchant", 0, orders, records)
+	if s.Orders != 6 || s.Shipped != 5 || s.Lost != 1 || s.Pending != 1 {
+		t.Errorf("got stats %+v", s)
+	}
+	if s.ShippedAmount != 1200 || s.LostAmount != 300 {
+		t.Errorf("got shipped %d and lost %d", s.ShippedAmount, s.LostAmount)
+	}
+	if !strings.Contains(s.String(), "1 lost to double spends") {
+		t.Errorf("got %q", s)
+	}
+}
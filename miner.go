@@ -2,46 +2,117 @@
 // Use of this source code is governed by an ISC
 // license that can be found in the LICENSE file.
 
-package main
+package btcsim
 
 import (
 	"fmt"
-	"log"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/btcsuite/btcd/wire"
 	rpc "github.com/btcsuite/btcrpcclient"
 	"github.com/btcsuite/btcutil"
 )
 
+// Mining schedules accepted by the -miningschedule flag
+const (
+	// scheduleCurve mines a block as soon as the transactions required by
+	// the tx curve are in the mempool, no earlier than blockInterval after
+	// the previous block
+	scheduleCurve = "curve"
+
+	// scheduleInterval mines a block every blockInterval
+	scheduleInterval = "interval"
+
+	// schedulePoisson mines blocks as a poisson process, i.e. with
+	// exponentially distributed intervals averaging blockInterval
+	schedulePoisson = "poisson"
+
+	// scheduleOnDemand only mines a block when requested using RequestBlock
+	scheduleOnDemand = "ondemand"
+)
+
+// miningSchedules is the list of valid mining schedules
+var miningSchedules = []string{
+	scheduleCurve,
+	scheduleInterval,
+	schedulePoisson,
+	scheduleOnDemand,
+}
+
+// checkMiningSchedule returns an error if the schedule is unknown or
+// requires a block interval which has not been set
+func checkMiningSchedule(schedule string, interval time.Duration) error {
+	switch schedule {
+	case scheduleCurve, scheduleOnDemand:
+		return nil
+	case scheduleInterval, schedulePoisson:
+		if interval <= 0 {
+			return fmt.Errorf("mining schedule %q requires a block interval", schedule)
+		}
+		return nil
+	}
+	valid := append([]string(nil), miningSchedules...)
+	sort.Strings(valid)
+	return fmt.Errorf("unknown mining schedule %q, valid schedules are: %s",
+		schedule, strings.Join(valid, ", "))
+}
+
 // Miner holds all the core features required to register, run, control,
 // and kill a cpu-mining btcd instance.
 type Miner struct {
 	*Node
+	schedule string
+	demand   chan struct{}
+	rand     *rand.Rand
+
+	// interval is the block interval of the schedule, it is changed by
+	// the phases of a scenario so it is protected by intervalMtx
+	intervalMtx sync.Mutex
+	interval    time.Duration
+
+	// attacker competes with the miner for blocks when set
+	attacker *attacker
 }
 
-// NewMiner starts a cpu-mining enabled btcd instane and returns an rpc client
-// to control it.
-func NewMiner(miningAddrs []btcutil.Address, exit chan struct{},
-	height chan<- int32, txpool chan<- struct{}) (*Miner, error) {
+// NewMiner starts a cpu-mining enabled btcd instane of the run configured by
+// cfg and returns an rpc client to control it.
+func NewMiner(cfg *Config, miningAddrs []btcutil.Address, exit chan struct{},
+	height chan<- int32, txpool chan<- struct{}, untracked *txSet) (*Miner, error) {
+
+	// heights are queued so that the notification handler never blocks
+	// the rpc client while the receiver is waiting for it to mine
+	var queued chan int32
+	if height != nil {
+		queued = make(chan int32)
+		go queueHeights(queued, height, exit)
+	}
 
 	ntfnHandlers := &rpc.NotificationHandlers{
 		// When a block higher than stopBlock connects to the chain,
 		// send a signal to stop actors. This is used so main can break from
 		// select and call actor.Stop to stop actors.
 		OnBlockConnected: func(hash *wire.ShaHash, h int32) {
-			if h >= int32(*startBlock)-1 {
-				if height != nil {
-					height <- h
+			if h >= int32(cfg.StartBlock)-1 {
+				if queued != nil {
+					select {
+					case queued <- h:
+					case <-exit:
+					}
 				}
 			} else {
-				fmt.Printf("\r%d/%d", h, *startBlock)
+				cfg.printProgress(int(h), cfg.StartBlock)
 			}
 		},
 		// Send a signal that a tx has been accepted into the mempool. Based on
 		// the tx curve, the receiver will need to wait until required no of tx
-		// are filled up in the mempool
+		// are filled up in the mempool. Untracked transactions, which
+		// were not requested, are ignored
 		OnTxAccepted: func(hash *wire.ShaHash, amount btcutil.Amount) {
-			if txpool != nil {
+			if txpool != nil && !untracked.take(*hash) {
 				// this will not be blocked because we're creating only
 				// required no of tx and receiving all of them
 				txpool <- struct{}{}
@@ -49,20 +120,37 @@ func NewMiner(miningAddrs []btcutil.Address, exit chan struct{},
 		},
 	}
 
-	log.Println("Starting miner on simnet...")
-	args, err := newBtcdArgs("miner")
+	log.Infof("Starting miner on %s...", cfg.netParams().Name)
+	args, err := newBtcdArgs(cfg, "miner")
 	if err != nil {
 		return nil, err
 	}
 
 	// set miner args - it listens on a different port
 	// because a node is already running on the default port
-	args.Listen = "127.0.0.1:18550"
-	args.RPCListen = "127.0.0.1:18551"
+	if args.Listen, err = cfg.localAddr(18550); err != nil {
+		args.Cleanup()
+		return nil, err
+	}
+	if args.RPCListen, err = cfg.localAddr(18551); err != nil {
+		args.Cleanup()
+		return nil, err
+	}
+	if err := restoreDataDir(cfg, args, "miner"); err != nil {
+		args.Cleanup()
+		return nil, err
+	}
 	// need to log mining details, so set debuglevel
 	args.DebugLevel = "MINR=trace"
-	// if passed, set blockmaxsize to allow mining large blocks
-	args.Extra = []string{fmt.Sprintf("--blockmaxsize=%d", *maxBlockSize)}
+	// if passed, set the block template size limits to allow mining
+	// large blocks
+	args.Extra = blockTemplateArgs(cfg)
+	// the miner relays with the fee rate of the nodes, its mempool is
+	// not limited
+	if err := args.SetRelayPolicy(0, cfg.MinRelayFee); err != nil {
+		args.Cleanup()
+		return nil, err
+	}
 	// set the actors' mining addresses
 	for _, addr := range miningAddrs {
 		// make sure addr was initialized
@@ -71,49 +159,82 @@ func NewMiner(miningAddrs []btcutil.Address, exit chan struct{},
 		}
 	}
 
-	logFile, err := getLogFile(args.prefix)
+	logFile, err := cfg.getLogFile(args.prefix)
+	if err != nil {
+		log.Warnf("Cannot get log file, logging disabled: %v", err)
+	}
+	node, err := NewNodeFromArgs(cfg, cfg.provisioner.wrap(args), ntfnHandlers, logFile)
 	if err != nil {
-		log.Printf("Cannot get log file, logging disabled: %v", err)
+		return nil, err
 	}
-	node, err := NewNodeFromArgs(args, ntfnHandlers, logFile)
+	node.restart = cfg.RestartNodes
 
 	miner := &Miner{
-		Node: node,
+		Node:     node,
+		schedule: cfg.MiningSchedule,
+		interval: cfg.realDuration(cfg.BlockInterval),
+		demand:   make(chan struct{}, 1),
+		rand:     cfg.newRand(minerStream),
 	}
 	if err := node.Start(); err != nil {
-		log.Printf("%s: Cannot start mining node: %v", miner, err)
+		log.Errorf("%s: Cannot start mining node: %v", miner, err)
 		return nil, err
 	}
 	if err := node.Connect(); err != nil {
-		log.Printf("%s: Cannot connect to node: %v", miner, err)
-		return nil, err
+		log.Errorf("%s: Cannot connect to node: %v", miner, err)
+		return miner, err
 	}
 
 	// Register for transaction notifications
 	if err := miner.client.NotifyNewTransactions(false); err != nil {
-		log.Printf("%s: Cannot register for transactions notifications: %v", miner, err)
+		log.Errorf("%s: Cannot register for transactions notifications: %v", miner, err)
 		return miner, err
 	}
 
-	// Use just one core for mining.
-	if err := miner.StartMining(); err != nil {
-		return miner, err
+	// Use just one core for mining, bootstrap blocks are generated
+	// one at a time instead
+	if !cfg.Bootstrap {
+		if err := miner.StartMining(); err != nil {
+			return miner, err
+		}
 	}
 
 	// Register for block notifications.
 	if err := miner.client.NotifyBlocks(); err != nil {
-		log.Printf("%s: Cannot register for block notifications: %v", miner, err)
+		log.Errorf("%s: Cannot register for block notifications: %v", miner, err)
 		return miner, err
 	}
 
-	log.Printf("%s: Generating %v blocks...", miner, *startBlock)
+	log.Infof("%s: Generating %v blocks...", miner, cfg.StartBlock)
 	return miner, nil
 }
 
+// queueHeights forwards the heights received on in to out in the same
+// order, always ready to receive the next one, until exit is closed
+func queueHeights(in <-chan int32, out chan<- int32, exit <-chan struct{}) {
+	var heights []int32
+	for {
+		var send chan<- int32
+		var next int32
+		if len(heights) > 0 {
+			send = out
+			next = heights[0]
+		}
+		select {
+		case h := <-in:
+			heights = append(heights, h)
+		case send <- next:
+			heights = heights[1:]
+		case <-exit:
+			return
+		}
+	}
+}
+
 // StartMining sets the cpu miner to mine coins
 func (m *Miner) StartMining() error {
 	if err := m.client.SetGenerate(true, 1); err != nil {
-		log.Printf("%s: Cannot start mining: %v", m, err)
+		log.Errorf("%s: Cannot start mining: %v", m, err)
 		return err
 	}
 	return nil
@@ -122,8 +243,80 @@ func (m *Miner) StartMining() error {
 // StopMining stops the cpu miner from mining coins
 func (m *Miner) StopMining() error {
 	if err := m.client.SetGenerate(false, 0); err != nil {
-		log.Printf("%s: Cannot stop mining: %v", m, err)
+		log.Errorf("%s: Cannot stop mining: %v", m, err)
 		return err
 	}
 	return nil
 }
+
+// Generate mines the given number of blocks right away
+func (m *Miner) Generate(n uint32) error {
+	if _, err := m.client.Generate(n); err != nil {
+		log.Errorf("%s: Cannot generate %d block(s): %v", m, n, err)
+		return err
+	}
+	return nil
+}
+
+// RequestBlock requests a block to be mined when the mining schedule is
+// ondemand. Requests made while one is pending are merged.
+func (m *Miner) RequestBlock() {
+	select {
+	case m.demand <- struct{}{}:
+	default:
+	}
+}
+
+// nextBlockDelay returns the time between the previous block and the next
+// one according to the mining schedule
+func (m *Miner) nextBlockDelay() time.Duration {
+	m.intervalMtx.Lock()
+	interval := m.interval
+	m.intervalMtx.Unlock()
+	if m.schedule == schedulePoisson {
+		return time.Duration(m.rand.ExpFloat64() * float64(interval))
+	}
+	return interval
+}
+
+// setInterval sets the block interval of the mining schedule, which applies
+// from the next block
+func (m *Miner) setInterval(interval time.Duration) {
+	m.intervalMtx.Lock()
+	defer m.intervalMtx.Unlock()
+	m.interval = interval
+}
+
+// MineNext waits until the next block is due according to the mining
+// schedule, given the time the previous block was connected, and mines it.
+// It returns without mining if exit is closed in the meantime.
+func (m *Miner) MineNext(last time.Time, exit <-chan struct{}) error {
+	if m.schedule == scheduleOnDemand {
+		log.Debugf("%s: Waiting for a block request...", m)
+		select {
+		case <-m.demand:
+		case <-exit:
+			return nil
+		}
+		if m.attacker != nil {
+			return m.attacker.Mine(exit)
+		}
+		return m.Generate(1)
+	}
+
+	if wait := m.nextBlockDelay() - time.Since(last); wait > 0 {
+		log.Debugf("%s: Waiting %v for the next block...", m, wait)
+		select {
+		case <-time.After(wait):
+		case <-exit:
+			return nil
+		}
+	}
+	if m.attacker != nil {
+		return m.attacker.Mine(exit)
+	}
+	if m.schedule == scheduleCurve {
+		return m.StartMining()
+	}
+	return m.Generate(1)
+}
@@ -0,0 +1,66 @@
+package btcsim
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheckMiningSchedule(t *testing.T) {
+	tests := []struct {
+		schedule string
+		interval time.Duration
+		valid    bool
+	}{
+		{scheduleCurve, 0, true},
+		{scheduleOnDemand, 0, true},
+		{scheduleInterval, time.Second, true},
+		{scheduleInterval, 0, false},
+		{schedulePoisson, time.Minute, true},
+		{schedulePoisson, 0, false},
+		{"hourly", time.Second, false},
+	}
+	for _, test := range tests {
+		err := checkMiningSchedule(test.schedule, test.interval)
+		if (err == nil) != test.valid {
+			t.Errorf("checkMiningSchedule(%s, %v) got error %v, want valid %v",
+				test.schedule, test.interval, err, test.valid)
+		}
+	}
+}
+
+func TestNextBlockDelay(t *testing.T) {
+	m := &Miner{schedule: scheduleInterval, interval: time.Minute, rand: DefaultConfig().newRand(minerStream)}
+	if d := m.nextBlockDelay(); d != time.Minute {
+		t.Errorf("interval delay got %v, want %v", d, time.Minute)
+	}
+
+	// the mean of the poisson delays should be close to the interval
+	m.schedule = schedulePoisson
+	const samples = 10000
+	var total time.Duration
+	for i := 0; i < samples; i++ {
+		total += m.nextBlockDelay()
+	}
+	mean := total / samples
+	if mean < 50*time.Second || mean > 70*time.Second {
+		t.Errorf("poisson mean delay got %v, want about %v", mean, time.Minute)
+	}
+}
+
+func TestQueueHeights(t *testing.T) {
+	in := make(chan int32)
+	out := make(chan int32)
+	exit := make(chan struct{})
+	defer close(exit)
+	go queueHeights(in, out, exit)
+
+	// the sender is never blocked by the receiver
+	for h := int32(1); h <= 3; h++ {
+		in <- h
+	}
+	for want := int32(1); want <= 3; want++ {
+		if got := <-out; got != want {
+			t.Errorf("got height %d, want %d", got, want)
+		}
+	}
+}
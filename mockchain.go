@@ -0,0 +1,504 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	rpc "github.com/btcsuite/btcrpcclient"
+	"github.com/btcsuite/btcutil"
+)
+
+// MockSubsidy is the reward of the blocks mined on a MockChain
+const MockSubsidy btcutil.Amount = 50e8
+
+// mockOut is an unspent output of a MockChain, height is -1 until it is
+// mined
+type mockOut struct {
+	txOut    *wire.TxOut
+	height   int32
+	coinbase bool
+}
+
+// mockTx is a transaction of the mempool of a MockChain with its fee
+type mockTx struct {
+	msgTx *wire.MsgTx
+	fee   btcutil.Amount
+}
+
+// MockChain is an in-memory chain standing in for btcd and btcwallet, with
+// just enough of their rpc surface for actors to receive, send and mine
+// payments, so that actors and their behaviors are unit-tested without
+// starting any process. Signatures are not checked, inputs only have to be
+// unspent and owned by the wallet signing them, and coinbases mature at
+// once.
+type MockChain struct {
+	mtx     sync.Mutex
+	cfg     *Config
+	blocks  []*btcutil.Block
+	mempool []*mockTx
+	outs    map[wire.OutPoint]*mockOut
+
+	// owners are the wallets owning the addresses by encoded address
+	owners  map[string]*MockWallet
+	wallets int
+}
+
+// NewMockChain returns a chain with an empty genesis block, whose actors
+// run with the given configuration
+func NewMockChain(cfg *Config) *MockChain {
+	genesis := btcutil.NewBlock(&wire.MsgBlock{
+		Header: wire.BlockHeader{Timestamp: time.Unix(0, 0)},
+	})
+	genesis.SetHeight(0)
+	return &MockChain{
+		cfg:    cfg,
+		blocks: []*btcutil.Block{genesis},
+		outs:   make(map[wire.OutPoint]*mockOut),
+		owners: make(map[string]*MockWallet),
+	}
+}
+
+// NewWallet returns a new wallet of the chain
+func (c *MockChain) NewWallet() *MockWallet {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.wallets++
+	return &MockWallet{chain: c, id: c.wallets}
+}
+
+// tip returns the height of the last block, the chain must be locked
+func (c *MockChain) tip() int32 {
+	return int32(len(c.blocks) - 1)
+}
+
+// owner returns the wallet owning the output, nil if none
+func (c *MockChain) owner(txOut *wire.TxOut) *MockWallet {
+	_, addrs, _, err := txscript.ExtractPkScriptAddrs(txOut.PkScript, &chaincfg.SimNetParams)
+	if err != nil || len(addrs) != 1 {
+		return nil
+	}
+	return c.owners[addrs[0].EncodeAddress()]
+}
+
+// confirmations returns the number of confirmations of the output, the
+// chain must be locked
+func (c *MockChain) confirmations(out *mockOut) int64 {
+	if out.height < 0 {
+		return 0
+	}
+	return int64(c.tip() - out.height + 1)
+}
+
+// accept adds a transaction to the mempool if it only spends unspent
+// outputs and no more than their value, the chain must be locked
+func (c *MockChain) accept(msgTx *wire.MsgTx) (*wire.ShaHash, error) {
+	var in, out btcutil.Amount
+	for _, txIn := range msgTx.TxIn {
+		prev, ok := c.outs[txIn.PreviousOutPoint]
+		if !ok {
+			return nil, fmt.Errorf("output %v already spent or unknown", txIn.PreviousOutPoint)
+		}
+		if len(txIn.SignatureScript) == 0 {
+			return nil, fmt.Errorf("input spending %v not signed", txIn.PreviousOutPoint)
+		}
+		in += btcutil.Amount(prev.txOut.Value)
+	}
+	for _, txOut := range msgTx.TxOut {
+		out += btcutil.Amount(txOut.Value)
+	}
+	if out > in {
+		return nil, errors.New("outputs exceed the inputs")
+	}
+
+	hash := msgTx.TxSha()
+	for _, txIn := range msgTx.TxIn {
+		delete(c.outs, txIn.PreviousOutPoint)
+	}
+	for i, txOut := range msgTx.TxOut {
+		c.outs[*wire.NewOutPoint(&hash, uint32(i))] = &mockOut{txOut: txOut, height: -1}
+	}
+	c.mempool = append(c.mempool, &mockTx{msgTx: msgTx, fee: in - out})
+	return &hash, nil
+}
+
+// mine mines a block of the mempool whose reward pays to addr, the chain
+// must be locked
+func (c *MockChain) mine(addr btcutil.Address) (*wire.ShaHash, error) {
+	height := c.tip() + 1
+	reward := MockSubsidy
+	for _, tx := range c.mempool {
+		reward += tx.fee
+	}
+	pkScript, err := txscript.PayToAddrScript(addr)
+	if err != nil {
+		return nil, err
+	}
+	// the height in the coinbase script makes every coinbase unique
+	coinbase := wire.NewMsgTx()
+	coinbase.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&wire.ShaHash{}, ^uint32(0)),
+		[]byte{byte(height), byte(height >> 8), byte(height >> 16), byte(height >> 24)}))
+	coinbase.AddTxOut(wire.NewTxOut(int64(reward), pkScript))
+
+	prev, _ := c.blocks[height-1].Sha()
+	msgBlock := &wire.MsgBlock{
+		Header: wire.BlockHeader{
+			PrevBlock: *prev,
+			Timestamp: time.Unix(int64(height), 0),
+		},
+	}
+	msgBlock.AddTransaction(coinbase)
+	for _, tx := range c.mempool {
+		msgBlock.AddTransaction(tx.msgTx)
+	}
+	for i, msgTx := range msgBlock.Transactions {
+		hash := msgTx.TxSha()
+		for n, txOut := range msgTx.TxOut {
+			op := *wire.NewOutPoint(&hash, uint32(n))
+			if i == 0 {
+				c.outs[op] = &mockOut{txOut: txOut, height: height, coinbase: true}
+			} else if out, ok := c.outs[op]; ok {
+				out.height = height
+			}
+		}
+	}
+	c.mempool = nil
+
+	block := btcutil.NewBlock(msgBlock)
+	block.SetHeight(height)
+	c.blocks = append(c.blocks, block)
+	return block.Sha()
+}
+
+// MockWallet is a wallet of a MockChain, serving the calls of the rpc
+// client of an actor. The methods of rpcCaller it does not implement
+// panic as the embedded rpcCaller is nil.
+type MockWallet struct {
+	rpcCaller
+	chain *MockChain
+	id    int
+	addrs int
+}
+
+// client returns an rpc client whose calls go to the wallet
+func (w *MockWallet) client() *rpcClient {
+	c := newRPCClient(w.chain.cfg, nil, fmt.Sprintf("mock-%d", w.id))
+	c.calls = w
+	return c
+}
+
+// newAddress returns a new address of the wallet, the chain must be
+// locked
+func (w *MockWallet) newAddress() btcutil.Address {
+	w.addrs++
+	hash := btcutil.Hash160([]byte(fmt.Sprintf("%d/%d", w.id, w.addrs)))
+	addr, _ := btcutil.NewAddressPubKeyHash(hash, &chaincfg.SimNetParams)
+	w.chain.owners[addr.EncodeAddress()] = w
+	return addr
+}
+
+// unspent returns the unspent outputs of the wallet with at least minConf
+// confirmations, the chain must be locked
+func (w *MockWallet) unspent(minConf int) map[wire.OutPoint]*mockOut {
+	outs := make(map[wire.OutPoint]*mockOut)
+	for op, out := range w.chain.outs {
+		if w.chain.owner(out.txOut) == w && w.chain.confirmations(out) >= int64(minConf) {
+			outs[op] = out
+		}
+	}
+	return outs
+}
+
+func (w *MockWallet) GetNewAddress() (btcutil.Address, error) {
+	w.chain.mtx.Lock()
+	defer w.chain.mtx.Unlock()
+	return w.newAddress(), nil
+}
+
+func (w *MockWallet) GetBalance(account string) (btcutil.Amount, error) {
+	return w.GetBalanceMinConf(account, 1)
+}
+
+func (w *MockWallet) GetBalanceMinConf(account string, minConfirms int) (btcutil.Amount, error) {
+	w.chain.mtx.Lock()
+	defer w.chain.mtx.Unlock()
+	var balance btcutil.Amount
+	for _, out := range w.unspent(minConfirms) {
+		balance += btcutil.Amount(out.txOut.Value)
+	}
+	return balance, nil
+}
+
+func (w *MockWallet) ListUnspent() ([]btcjson.ListUnspentResult, error) {
+	w.chain.mtx.Lock()
+	defer w.chain.mtx.Unlock()
+	var unspent []btcjson.ListUnspentResult
+	for op, out := range w.unspent(1) {
+		unspent = append(unspent, btcjson.ListUnspentResult{
+			TxID:          op.Hash.String(),
+			Vout:          op.Index,
+			Amount:        btcutil.Amount(out.txOut.Value).ToBTC(),
+			Confirmations: w.chain.confirmations(out),
+		})
+	}
+	return unspent, nil
+}
+
+func (w *MockWallet) CreateRawTransaction(inputs []btcjson.TransactionInput,
+	amounts map[btcutil.Address]btcutil.Amount) (*wire.MsgTx, error) {
+
+	msgTx := wire.NewMsgTx()
+	for _, input := range inputs {
+		hash, err := wire.NewShaHashFromStr(input.Txid)
+		if err != nil {
+			return nil, err
+		}
+		msgTx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(hash, input.Vout), nil))
+	}
+	for addr, amt := range amounts {
+		pkScript, err := txscript.PayToAddrScript(addr)
+		if err != nil {
+			return nil, err
+		}
+		msgTx.AddTxOut(wire.NewTxOut(int64(amt), pkScript))
+	}
+	return msgTx, nil
+}
+
+func (w *MockWallet) SignRawTransaction(tx *wire.MsgTx) (*wire.MsgTx, bool, error) {
+	return w.SignRawTransaction2(tx, nil)
+}
+
+func (w *MockWallet) SignRawTransaction2(tx *wire.MsgTx, inputs []btcjson.RawTxInput) (*wire.MsgTx, bool, error) {
+	w.chain.mtx.Lock()
+	defer w.chain.mtx.Unlock()
+	signed := tx.Copy()
+	complete := true
+	for _, txIn := range signed.TxIn {
+		if len(txIn.SignatureScript) > 0 {
+			continue
+		}
+		out, ok := w.chain.outs[txIn.PreviousOutPoint]
+		if !ok || w.chain.owner(out.txOut) != w {
+			complete = false
+			continue
+		}
+		txIn.SignatureScript = []byte{byte(w.id)}
+	}
+	return signed, complete, nil
+}
+
+func (w *MockWallet) SendRawTransaction(tx *wire.MsgTx, allowHighFees bool) (*wire.ShaHash, error) {
+	w.chain.mtx.Lock()
+	defer w.chain.mtx.Unlock()
+	return w.chain.accept(tx)
+}
+
+func (w *MockWallet) SendToAddress(address btcutil.Address, amount btcutil.Amount) (*wire.ShaHash, error) {
+	w.chain.mtx.Lock()
+	defer w.chain.mtx.Unlock()
+	pkScript, err := txscript.PayToAddrScript(address)
+	if err != nil {
+		return nil, err
+	}
+	msgTx := wire.NewMsgTx()
+	msgTx.AddTxOut(wire.NewTxOut(int64(amount), pkScript))
+	var in btcutil.Amount
+	for op, out := range w.unspent(1) {
+		if in >= amount+minFee {
+			break
+		}
+		msgTx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&op.Hash, op.Index), []byte{byte(w.id)}))
+		in += btcutil.Amount(out.txOut.Value)
+	}
+	if in < amount+minFee {
+		return nil, errors.New("insufficient funds")
+	}
+	if change := in - amount - minFee; change > 0 {
+		pkScript, _ := txscript.PayToAddrScript(w.newAddress())
+		msgTx.AddTxOut(wire.NewTxOut(int64(change), pkScript))
+	}
+	return w.chain.accept(msgTx)
+}
+
+func (w *MockWallet) Generate(numBlocks uint32) ([]*wire.ShaHash, error) {
+	w.chain.mtx.Lock()
+	defer w.chain.mtx.Unlock()
+	hashes := make([]*wire.ShaHash, numBlocks)
+	for i := range hashes {
+		var err error
+		if hashes[i], err = w.chain.mine(w.newAddress()); err != nil {
+			return nil, err
+		}
+	}
+	return hashes, nil
+}
+
+func (w *MockWallet) GetBlockCount() (int64, error) {
+	w.chain.mtx.Lock()
+	defer w.chain.mtx.Unlock()
+	return int64(w.chain.tip()), nil
+}
+
+func (w *MockWallet) GetBlockHash(blockHeight int64) (*wire.ShaHash, error) {
+	w.chain.mtx.Lock()
+	defer w.chain.mtx.Unlock()
+	if blockHeight < 0 || blockHeight > int64(w.chain.tip()) {
+		return nil, fmt.Errorf("block height %d out of range", blockHeight)
+	}
+	return w.chain.blocks[blockHeight].Sha()
+}
+
+func (w *MockWallet) GetBlock(blockHash *wire.ShaHash) (*btcutil.Block, error) {
+	w.chain.mtx.Lock()
+	defer w.chain.mtx.Unlock()
+	for _, block := range w.chain.blocks {
+		if hash, _ := block.Sha(); *hash == *blockHash {
+			return block, nil
+		}
+	}
+	return nil, fmt.Errorf("block %v not found", blockHash)
+}
+
+func (w *MockWallet) GetRawMempool() ([]*wire.ShaHash, error) {
+	w.chain.mtx.Lock()
+	defer w.chain.mtx.Unlock()
+	hashes := make([]*wire.ShaHash, len(w.chain.mempool))
+	for i, tx := range w.chain.mempool {
+		hash := tx.msgTx.TxSha()
+		hashes[i] = &hash
+	}
+	return hashes, nil
+}
+
+func (w *MockWallet) GetTxOut(txHash *wire.ShaHash, index uint32, mempool bool) (*btcjson.GetTxOutResult, error) {
+	w.chain.mtx.Lock()
+	defer w.chain.mtx.Unlock()
+	out, ok := w.chain.outs[*wire.NewOutPoint(txHash, index)]
+	if !ok || (!mempool && out.height < 0) {
+		return nil, nil
+	}
+	best, _ := w.chain.blocks[w.chain.tip()].Sha()
+	return &btcjson.GetTxOutResult{
+		BestBlock:     best.String(),
+		Confirmations: w.chain.confirmations(out),
+		Value:         btcutil.Amount(out.txOut.Value).ToBTC(),
+		Coinbase:      out.coinbase,
+	}, nil
+}
+
+// NewActor returns an actor with two addresses whose wallet is a new
+// wallet of the chain. Its utxos are queued to a buffered channel read
+// directly rather than through queueUtxos, so that they can be spent as
+// soon as they are queued.
+func (c *MockChain) NewActor(name string) *Actor {
+	w := c.NewWallet()
+	n, _ := NewNodeFromArgs(c.cfg, &mockArgs{name: name}, nil, nil)
+	n.client = w.client()
+	a := &Actor{
+		Node:             n,
+		quit:             make(chan struct{}),
+		profile:          defaultProfile,
+		rand:             c.cfg.newRand(int64(w.id)),
+		spent:            make(map[wire.OutPoint]bool),
+		untracked:        newTxSet(),
+		behavior:         NopBehavior{},
+		behaviorName:     behaviorPassive,
+		behaviorBlocks:   make(chan int32, behaviorQueueSize),
+		behaviorPayments: make(chan *TxOut, behaviorQueueSize),
+		txSent:           make(chan *TxRecord, 100),
+	}
+	queue := make(chan *TxOut, 100)
+	a.utxoQueue = &utxoQueue{enqueue: queue, dequeue: queue}
+	for i := 0; i < 2; i++ {
+		addr, _ := a.client.GetNewAddress()
+		a.ownedAddresses = append(a.ownedAddresses, addr)
+	}
+	return a
+}
+
+// StopMockActors shuts the actors down without shutting their nodes down,
+// which have no process
+func StopMockActors(actors ...*Actor) {
+	for _, a := range actors {
+		close(a.quit)
+		a.wg.Wait()
+	}
+}
+
+// Mine mines a block with the wallet of the miner and queues its outputs
+// to the actors owning them, as poolUtxos does, returning the txids of the
+// block
+func (c *MockChain) Mine(miner *Actor, actors ...*Actor) ([]string, error) {
+	hashes, err := miner.client.Generate(1)
+	if err != nil {
+		return nil, err
+	}
+	block, err := miner.client.GetBlock(hashes[0])
+	if err != nil {
+		return nil, err
+	}
+	var txids []string
+	for i, tx := range block.Transactions() {
+		txids = append(txids, tx.Sha().String())
+		for n, txOut := range tx.MsgTx().TxOut {
+			c.mtx.Lock()
+			w := c.owner(txOut)
+			c.mtx.Unlock()
+			for _, a := range actors {
+				if a.client.calls != w {
+					continue
+				}
+				out := &TxOut{
+					OutPoint: wire.NewOutPoint(tx.Sha(), uint32(n)),
+					Amount:   btcutil.Amount(txOut.Value),
+					Coinbase: i == 0,
+				}
+				a.utxoQueue.enqueue <- out
+				a.notifyPayment(out)
+			}
+		}
+	}
+	return txids, nil
+}
+
+// mockArgs are the args of the node of an actor of a MockChain, which has
+// no process
+type mockArgs struct {
+	name string
+}
+
+// String returns the name of the actor
+func (a *mockArgs) String() string {
+	return "mock-" + a.name
+}
+
+// Arguments returns no arguments as there is no process
+func (a *mockArgs) Arguments() []string {
+	return nil
+}
+
+// Command returns nil as there is no process
+func (a *mockArgs) Command() *exec.Cmd {
+	return nil
+}
+
+// RPCConnConfig returns no connection, the calls go to the wallet
+func (a *mockArgs) RPCConnConfig() rpc.ConnConfig {
+	return rpc.ConnConfig{}
+}
+
+// Cleanup does nothing as there are no files
+func (a *mockArgs) Cleanup() error {
+	return nil
+}
@@ -0,0 +1,167 @@
+package btcsim
+
+import (
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcutil"
+)
+
+// mineMock mines a block of the chain of the miner, failing the test if it
+// cannot
+func mineMock(t *testing.T, miner *Actor, actors ...*Actor) []string {
+	txids, err := miner.client.calls.(*MockWallet).chain.Mine(miner, actors...)
+	if err != nil {
+		t.Fatalf("Mine error: %v", err)
+	}
+	return txids
+}
+
+func TestMockChainPay(t *testing.T) {
+	chain := NewMockChain(DefaultConfig())
+	a, b := chain.NewActor("a"), chain.NewActor("b")
+	defer StopMockActors(a, b)
+	txSent := make(chan *TxRecord, 1)
+	a.txSent = txSent
+
+	if _, err := a.Pay(map[btcutil.Address]btcutil.Amount{b.Address(): 1e8}); err != ErrInsufficientFunds {
+		t.Fatalf("Pay without funds error: %v, want %v", err, ErrInsufficientFunds)
+	}
+
+	mineMock(t, a, a, b)
+	if balance, _ := a.client.GetBalance(""); balance != MockSubsidy {
+		t.Fatalf("balance after mining %v, want %v", balance, MockSubsidy)
+	}
+
+	msgTx, err := a.Pay(map[btcutil.Address]btcutil.Amount{b.Address(): 1e8})
+	if err != nil {
+		t.Fatalf("Pay error: %v", err)
+	}
+	mempool, _ := a.client.GetRawMempool()
+	if len(mempool) != 1 || *mempool[0] != msgTx.TxSha() {
+		t.Fatalf("mempool %v, want the payment %v", mempool, msgTx.TxSha())
+	}
+	if balance, _ := b.client.GetBalance(""); balance != 0 {
+		t.Errorf("balance of the payee before the payment is mined %v, want 0", balance)
+	}
+	if _, err := a.client.SendRawTransaction(msgTx, false); err == nil {
+		t.Errorf("double spend accepted")
+	}
+
+	mineMock(t, b, a, b)
+	if height, _ := b.client.GetBlockCount(); height != 2 {
+		t.Errorf("height %d, want 2", height)
+	}
+	if balance, _ := b.client.GetBalance(""); balance <= 1e8 {
+		t.Errorf("balance of the payee %v, want the payment and a reward", balance)
+	}
+	record := <-txSent
+	if record.TxID != msgTx.TxSha().String() || record.Fee <= 0 {
+		t.Errorf("record %+v of the payment", record)
+	}
+	want := MockSubsidy - 1e8 - btcutil.Amount(record.Fee)
+	if balance, _ := a.client.GetBalance(""); balance != want {
+		t.Errorf("balance of the payer %v, want %v", balance, want)
+	}
+}
+
+func TestMockChainBehavior(t *testing.T) {
+	chain := NewMockChain(DefaultConfig())
+	a, b := chain.NewActor("a"), chain.NewActor("b")
+	defer StopMockActors(a, b)
+	actors := []*Actor{a, b}
+	a.peers = func() []*Actor { return actors }
+	b.peers = a.peers
+	if err := b.setBehavior("forwarder"); err != nil {
+		t.Fatalf("setBehavior error: %v", err)
+	}
+
+	mineMock(t, a, a, b)
+	if _, err := a.Pay(map[btcutil.Address]btcutil.Amount{b.Address(): 1e8}); err != nil {
+		t.Fatalf("Pay error: %v", err)
+	}
+	mineMock(t, a, a, b)
+
+	// b forwards a tenth of the payment it received to a
+	out := <-b.behaviorPayments
+	if out.Amount != 1e8 {
+		t.Fatalf("payment received %v, want 1 BTC", out.Amount)
+	}
+	b.behavior.OnPaymentReceived(b, out)
+	mempool, _ := b.client.GetRawMempool()
+	if len(mempool) != 1 {
+		t.Fatalf("%d transactions forwarded, want 1", len(mempool))
+	}
+	mineMock(t, a, a, b)
+	if balance, _ := b.client.GetBalance(""); balance >= 9e7 {
+		t.Errorf("balance of the forwarder %v, want less than 0.9 BTC", balance)
+	}
+	if got := len(b.txSent); got != 1 {
+		t.Errorf("%d transactions recorded by the forwarder, want 1", got)
+	}
+}
+
+func TestMockChainMatchmaker(t *testing.T) {
+	chain := NewMockChain(DefaultConfig())
+	actors := []*Actor{chain.NewActor("a"), chain.NewActor("b"), chain.NewActor("c")}
+	defer StopMockActors(actors...)
+	for _, a := range actors {
+		mineMock(t, a, actors...)
+	}
+
+	m := newMatchmaker(DefaultConfig(), matchFixed, func() []*Actor { return actors })
+	downstream := make(chan struct{})
+	txpool := make(chan struct{}, len(actors))
+	txSent := make(chan *TxRecord, len(actors))
+	for _, a := range actors {
+		a.wg.Add(1)
+		go a.simulateTx(downstream, m, txpool, txSent)
+	}
+	txids := make(map[string]bool)
+	for range actors {
+		downstream <- struct{}{}
+		select {
+		case r := <-txSent:
+			txids[r.TxID] = true
+		case <-txpool:
+			t.Fatalf("payment failed")
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no payment sent")
+		}
+	}
+
+	mined := mineMock(t, actors[0], actors...)
+	var confirmed []string
+	for _, txid := range mined {
+		if txids[txid] {
+			confirmed = append(confirmed, txid)
+		}
+	}
+	if len(confirmed) != len(actors) {
+		t.Fatalf("%d payments mined, want %d", len(confirmed), len(actors))
+	}
+	// the payments are recorded with the matchmaker once sent, which may
+	// lag behind their records a little
+	for i := 0; i < 100; i++ {
+		if payments, _ := m.counts(); payments == len(actors) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	height, _ := actors[0].client.GetBlockCount()
+	m.confirm(int32(height), mined)
+	if payments, confirmed := m.counts(); payments != len(actors) || confirmed != len(actors) {
+		t.Errorf("%d payments, %d confirmed, want %d", payments, confirmed, len(actors))
+	}
+	var received uint64
+	for _, a := range actors {
+		paid, paidBy := a.Payments()
+		if paid != 1 {
+			t.Errorf("%v paid %d payments, want 1", a, paid)
+		}
+		received += paidBy
+	}
+	if received != uint64(len(actors)) {
+		t.Errorf("%d payments received, want %d", received, len(actors))
+	}
+}
@@ -0,0 +1,244 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+// parseMultisig parses a multisig scheme given as "m-of-n", where m
+// signatures of n cosigners are required to spend
+func parseMultisig(s string) (m, n int, err error) {
+	if _, err := fmt.Sscanf(s, "%d-of-%d", &m, &n); err != nil || fmt.Sprintf("%d-of-%d", m, n) != s {
+		return 0, 0, fmt.Errorf("invalid multisig scheme %q, expected m-of-n", s)
+	}
+	if m < 1 || n < 2 || m > n {
+		return 0, 0, fmt.Errorf("invalid multisig scheme %q, expected 1 <= m <= n and n >= 2", s)
+	}
+	return m, n, nil
+}
+
+// multisigGroup is a group of actors sharing a pay-to-script-hash
+// multisig address
+type multisigGroup struct {
+	rand         *rand.Rand
+	cosigners    []*Actor
+	addr         btcutil.Address
+	redeemScript string
+}
+
+// newMultisigGroup creates an address requiring m signatures of the
+// given cosigners, using one of the addresses of each, and adds it to
+// the wallet of every cosigner so that they can sign for it. Addresses
+// are picked using r.
+func newMultisigGroup(r *rand.Rand, m int, cosigners []*Actor) (*multisigGroup, error) {
+	pubKeys := make([]btcutil.Address, len(cosigners))
+	for i, a := range cosigners {
+		addr := a.ownedAddresses[r.Intn(len(a.ownedAddresses))]
+		res, err := a.client.ValidateAddress(addr)
+		if err != nil {
+			return nil, err
+		}
+		serialized, err := hex.DecodeString(res.PubKey)
+		if err != nil {
+			return nil, err
+		}
+		if pubKeys[i], err = btcutil.NewAddressPubKey(serialized, &chaincfg.SimNetParams); err != nil {
+			return nil, err
+		}
+	}
+
+	res, err := cosigners[0].client.CreateMultisig(m, pubKeys)
+	if err != nil {
+		return nil, err
+	}
+	addr, err := btcutil.DecodeAddress(res.Address, &chaincfg.SimNetParams)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range cosigners {
+		if _, err := a.client.AddMultisigAddress(m, pubKeys, ""); err != nil {
+			return nil, err
+		}
+	}
+	return &multisigGroup{
+		rand:         r,
+		cosigners:    cosigners,
+		addr:         addr,
+		redeemScript: res.RedeemScript,
+	}, nil
+}
+
+// fund sends the given utxo of the first cosigner to the multisig address
+// and returns the funding transaction
+func (g *multisigGroup) fund(utxo *TxOut, untracked *txSet) (*wire.MsgTx, error) {
+	funder := g.cosigners[0]
+	inputs := []btcjson.TransactionInput{{
+		Txid: utxo.OutPoint.Hash.String(),
+		Vout: utxo.OutPoint.Index,
+	}}
+	amounts := map[btcutil.Address]btcutil.Amount{g.addr: utxo.Amount - minFee}
+	msgTx, err := funder.createRawTransaction(inputs, amounts)
+	if err != nil {
+		return nil, err
+	}
+	if err := sendUntracked(funder, msgTx, untracked); err != nil {
+		return nil, err
+	}
+	return msgTx, nil
+}
+
+// spend spends the multisig output of the funding transaction back to
+// one of the cosigners. The cosigners sign in turn until the transaction
+// is complete, it returns the spending transaction and the number of
+// cosigners who signed it.
+func (g *multisigGroup) spend(funding *wire.MsgTx, untracked *txSet) (*wire.MsgTx, int, error) {
+	hash := funding.TxSha()
+	txOut := funding.TxOut[0]
+	inputs := []btcjson.TransactionInput{{
+		Txid: hash.String(),
+		Vout: 0,
+	}}
+	payee := g.cosigners[len(g.cosigners)-1]
+	to := payee.ownedAddresses[g.rand.Intn(len(payee.ownedAddresses))]
+	amounts := map[btcutil.Address]btcutil.Amount{
+		to: btcutil.Amount(txOut.Value) - minFee,
+	}
+	msgTx, err := payee.client.CreateRawTransaction(inputs, amounts)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	prevOut := []btcjson.RawTxInput{{
+		Txid:         hash.String(),
+		Vout:         0,
+		ScriptPubKey: hex.EncodeToString(txOut.PkScript),
+		RedeemScript: g.redeemScript,
+	}}
+	for i, a := range g.cosigners {
+		signed, complete, err := a.client.SignRawTransaction2(msgTx, prevOut)
+		if err != nil {
+			return nil, i, err
+		}
+		msgTx = signed
+		if complete {
+			return msgTx, i + 1, sendUntracked(a, msgTx, untracked)
+		}
+	}
+	return nil, len(g.cosigners), ErrIncompleteSignature
+}
+
+// sendUntracked sends a transaction which was not requested by
+// Communicate through the wallet of the given actor
+func sendUntracked(a *Actor, msgTx *wire.MsgTx, untracked *txSet) error {
+	hash := msgTx.TxSha()
+	untracked.add(hash)
+	if _, err := a.client.SendRawTransaction(msgTx, false); err != nil {
+		untracked.take(hash)
+		return err
+	}
+	return nil
+}
+
+// multisig runs as a goroutine and, on average every interval, picks n
+// running actors as cosigners of an m-of-n multisig address, funds it
+// with an utxo of the first one and spends it back to the last one once
+// enough of them signed, until the simulation exits
+func (com *Communication) multisig(m, n int, interval time.Duration) {
+	defer com.wg.Done()
+
+	r := com.cfg.newRand(multisigStream)
+	for {
+		select {
+		case <-time.After(time.Duration(r.ExpFloat64() * float64(interval))):
+		case <-com.exit:
+			return
+		}
+
+		var running []*Actor
+		for _, a := range com.Actors() {
+			if a.running() && a.floodTarget == 0 && !a.Paused() {
+				running = append(running, a)
+			}
+		}
+		if len(running) < n {
+			log.Debugf("Not enough running actors for %d-of-%d multisig", m, n)
+			continue
+		}
+		perm := r.Perm(len(running))
+		cosigners := make([]*Actor, n)
+		for i := range cosigners {
+			cosigners[i] = running[perm[i]]
+		}
+		if err := com.multisigPayment(r, m, cosigners); err != nil {
+			log.Errorf("Cannot make %d-of-%d multisig payment: %v", m, n, err)
+		}
+	}
+}
+
+// multisigPayment makes a multisig payment between the given cosigners
+// and records its transactions
+func (com *Communication) multisigPayment(r *rand.Rand, m int, cosigners []*Actor) error {
+	g, err := newMultisigGroup(r, m, cosigners)
+	if err != nil {
+		return err
+	}
+
+	funder := cosigners[0]
+	var utxo *TxOut
+	select {
+	case u, ok := <-funder.utxoQueue.dequeue:
+		if !ok {
+			return errors.New("funder has quit")
+		}
+		utxo = u
+	default:
+		log.Debugf("%s: No utxo to fund a multisig address", funder)
+		return nil
+	}
+	funding, err := g.fund(utxo, com.untracked)
+	if err != nil {
+		return err
+	}
+	com.recordMultisigTx(funder, funding, utxo.Amount, 0)
+
+	spend, signers, err := g.spend(funding, com.untracked)
+	if err != nil {
+		return err
+	}
+	com.recordMultisigTx(cosigners[signers-1], spend,
+		btcutil.Amount(funding.TxOut[0].Value), signers)
+	log.Debugf("Multisig payment %s signed by %d of %d cosigners",
+		spend.TxSha(), signers, len(cosigners))
+	return nil
+}
+
+// recordMultisigTx records a transaction funding or spending a multisig
+// address, signed by the given number of cosigners when it is a spend
+func (com *Communication) recordMultisigTx(a *Actor, msgTx *wire.MsgTx, in btcutil.Amount, signers int) {
+	r := a.newTxRecord(msgTx, in)
+	r.Multisig = true
+	r.Signers = signers
+	select {
+	case com.txStats.sent <- r:
+	case <-com.exit:
+	}
+}
+
+// isScriptHash reports whether an output pays to a script hash, such
+// as a multisig address, which is owned by no single actor
+func isScriptHash(pkScript []byte) bool {
+	return txscript.GetScriptClass(pkScript) == txscript.ScriptHashTy
+}
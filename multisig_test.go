@@ -0,0 +1,34 @@
+package btcsim
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseMultisig(t *testing.T) {
+	m, n, err := parseMultisig("2-of-3")
+	if err != nil || m != 2 || n != 3 {
+		t.Errorf("parseMultisig got %d, %d, %v want 2, 3, nil", m, n, err)
+	}
+	invalid := []string{"", "2of3", "2-of-3x", "0-of-2", "1-of-1", "3-of-2"}
+	for _, s := range invalid {
+		if _, _, err := parseMultisig(s); err == nil {
+			t.Errorf("parseMultisig(%q) expected error", s)
+		}
+	}
+}
+
+func TestNewSummaryMultisig(t *testing.T) {
+	stats := NewTxStats(DefaultConfig())
+	stats.records = []*TxRecord{
+		// funding transactions are not counted as spends
+		{TxID: "a", Multisig: true, Height: 10},
+		{TxID: "b", Multisig: true, Signers: 2, Height: 10},
+		{TxID: "c", Multisig: true},
+		{TxID: "d", Multisig: true, Signers: 2},
+	}
+	s := NewSummary(DefaultConfig(), stats, time.Minute)
+	if s.MultisigSpends != 2 || s.MultisigConfirmed != 1 {
+		t.Errorf("multisig spends got %d, %d want 2, 1", s.MultisigSpends, s.MultisigConfirmed)
+	}
+}
@@ -0,0 +1,323 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/wire"
+	rpc "github.com/btcsuite/btcrpcclient"
+	"github.com/btcsuite/btcutil"
+)
+
+// nodeHandlers returns the notification handlers of the i-th node, which
+// publish the blocks it connects and the transactions it accepts in
+// addition to calling the given handlers if any
+func (com *Communication) nodeHandlers(i int, h *rpc.NotificationHandlers) *rpc.NotificationHandlers {
+	wrapped := &rpc.NotificationHandlers{}
+	if h != nil {
+		*wrapped = *h
+	}
+	onConnected := wrapped.OnBlockConnected
+	wrapped.OnBlockConnected = func(hash *wire.ShaHash, height int32) {
+		com.events.publish(&Event{Kind: eventBlockConnected, Hash: hash, Height: height, Node: i})
+		if onConnected != nil {
+			onConnected(hash, height)
+		}
+	}
+	onAccepted := wrapped.OnTxAccepted
+	wrapped.OnTxAccepted = func(hash *wire.ShaHash, amount btcutil.Amount) {
+		com.events.publish(&Event{Kind: eventTxAccepted, Hash: hash, Node: i})
+		if onAccepted != nil {
+			onAccepted(hash, amount)
+		}
+	}
+	return wrapped
+}
+
+// pollInterval is the interval at which a node serving rpc over HTTP POST,
+// which cannot send notifications, is polled for its blocks and mempool
+const pollInterval = 250 * time.Millisecond
+
+// notify registers for the block and transaction notifications of the node,
+// a node serving rpc over HTTP POST only is polled for them instead until
+// it is stopped
+func (n *Node) notify() error {
+	if n.RPCConnConfig().HTTPPostMode {
+		go n.pollNotifications()
+		return nil
+	}
+	if err := n.client.NotifyBlocks(); err != nil {
+		return err
+	}
+	return n.client.NotifyNewTransactions(false)
+}
+
+// pollNotifications runs as a goroutine and calls the notification handlers
+// of the node for the changes of its chain and mempool every pollInterval,
+// until the node is stopped. The polls are not recorded as rpc calls of
+// the simulation.
+func (n *Node) pollNotifications() {
+	p := &notifyPoller{handlers: n.handlers}
+	for {
+		if err := p.poll(n.client.Client); err != nil {
+			log.Warnf("%s: Cannot poll notifications: %v", n, err)
+		}
+		select {
+		case <-time.After(pollInterval):
+		case <-n.quit:
+			return
+		}
+	}
+}
+
+// notifyPoller emulates the notifications of a node from polls of its chain
+// and mempool. The first poll records them, the next ones notify the blocks
+// disconnected and connected and the transactions accepted since the
+// previous poll. Transactions accepted and mined between two polls are not
+// notified, and the amounts of the accepted ones are unknown.
+type notifyPoller struct {
+	handlers *rpc.NotificationHandlers
+
+	// hashes are the hashes of the chain polled by height from base on,
+	// mempool is the mempool polled
+	base    int64
+	hashes  []wire.ShaHash
+	mempool map[wire.ShaHash]bool
+}
+
+// poll polls the chain and mempool of the node with client and calls the
+// handlers for what changed since the previous poll
+func (p *notifyPoller) poll(client rpcCaller) error {
+	mempool, err := client.GetRawMempool()
+	if err != nil {
+		return err
+	}
+	count, err := client.GetBlockCount()
+	if err != nil {
+		return err
+	}
+	h := p.handlers
+	if h == nil {
+		h = &rpc.NotificationHandlers{}
+	}
+
+	first := p.hashes == nil
+	if first {
+		hash, err := client.GetBlockHash(count)
+		if err != nil {
+			return err
+		}
+		p.base, p.hashes = count, []wire.ShaHash{*hash}
+	}
+
+	// transactions are accepted before they are mined
+	polled := make(map[wire.ShaHash]bool, len(mempool))
+	for _, hash := range mempool {
+		polled[*hash] = true
+		if !first && !p.mempool[*hash] && h.OnTxAccepted != nil {
+			h.OnTxAccepted(hash, 0)
+		}
+	}
+	p.mempool = polled
+
+	// the blocks replaced by a reorg are disconnected from the tip down,
+	// the first block polled is kept
+	for len(p.hashes) > 1 {
+		tip := p.base + int64(len(p.hashes)) - 1
+		last := p.hashes[len(p.hashes)-1]
+		if tip <= count {
+			hash, err := client.GetBlockHash(tip)
+			if err != nil {
+				return err
+			}
+			if *hash == last {
+				break
+			}
+		}
+		p.hashes = p.hashes[:len(p.hashes)-1]
+		if h.OnBlockDisconnected != nil {
+			h.OnBlockDisconnected(&last, int32(tip))
+		}
+	}
+	for height := p.base + int64(len(p.hashes)); height <= count; height++ {
+		hash, err := client.GetBlockHash(height)
+		if err != nil {
+			return err
+		}
+		p.hashes = append(p.hashes, *hash)
+		if h.OnBlockConnected != nil {
+			h.OnBlockConnected(hash, int32(height))
+		}
+	}
+	return nil
+}
+
+// mempoolReconcileInterval is the interval at which the tracked mempool is
+// reconciled with the mempool of the node
+const mempoolReconcileInterval = 10 * time.Second
+
+// mempoolTracker follows the mempool of the node from its notifications,
+// adding the transactions it accepts and removing the ones mined, so its
+// size is known without polling the node. Transactions leaving the mempool
+// otherwise are removed when it is reconciled with the node.
+type mempoolTracker struct {
+	mtx    sync.Mutex
+	txs    map[string]struct{}
+	max    int
+	height int32
+
+	// suspects are the transactions missing from the mempool of the
+	// node when last reconciled
+	suspects map[string]bool
+}
+
+// newMempoolTracker returns a tracker of an empty mempool
+func newMempoolTracker() *mempoolTracker {
+	return &mempoolTracker{txs: make(map[string]struct{})}
+}
+
+// seed sets the height of the node and adds the transactions in its
+// mempool when the notifications were registered
+func (m *mempoolTracker) seed(height int32, mempool []*wire.ShaHash) {
+	m.mtx.Lock()
+	m.height = height
+	for _, hash := range mempool {
+		m.txs[hash.String()] = struct{}{}
+	}
+	m.update()
+	m.mtx.Unlock()
+}
+
+// accepted adds a transaction accepted to the mempool of the node
+func (m *mempoolTracker) accepted(e *Event) {
+	if e.Node != 0 {
+		return
+	}
+	m.mtx.Lock()
+	m.txs[e.Hash.String()] = struct{}{}
+	m.update()
+	m.mtx.Unlock()
+}
+
+// mined removes the transactions of a block mined from the mempool
+func (m *mempoolTracker) mined(e *Event) {
+	m.mtx.Lock()
+	for _, txid := range e.Block.txids {
+		delete(m.txs, txid)
+	}
+	m.height = e.Height
+	m.mtx.Unlock()
+}
+
+// update records the maximum size of the mempool, the lock must be held
+func (m *mempoolTracker) update() {
+	if len(m.txs) > m.max {
+		m.max = len(m.txs)
+	}
+}
+
+// Size returns the number of transactions in the mempool
+func (m *mempoolTracker) Size() int {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	return len(m.txs)
+}
+
+// has reports whether the transaction is in the mempool
+func (m *mempoolTracker) has(txid string) bool {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	_, ok := m.txs[txid]
+	return ok
+}
+
+// missing returns the transactions in the mempool which are not in the
+// given mempool of the node
+func (m *mempoolTracker) missing(mempool []*wire.ShaHash) []string {
+	in := make(map[string]bool, len(mempool))
+	for _, hash := range mempool {
+		in[hash.String()] = true
+	}
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	var txids []string
+	for txid := range m.txs {
+		if !in[txid] {
+			txids = append(txids, txid)
+		}
+	}
+	return txids
+}
+
+// remove removes transactions which left the mempool without being mined
+func (m *mempoolTracker) remove(txids []string) {
+	m.mtx.Lock()
+	for _, txid := range txids {
+		delete(m.txs, txid)
+	}
+	m.mtx.Unlock()
+}
+
+// reconcile adds the transactions in the given mempool of the node and
+// removes the ones which left it without being mined, such as replaced
+// transactions and lost double spends. A transaction is removed once it is
+// missing from two mempools in a row, so that the notifications of the
+// transactions accepted and mined in between are received first.
+func (m *mempoolTracker) reconcile(mempool []*wire.ShaHash) {
+	missing := m.missing(mempool)
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	suspects := make(map[string]bool, len(missing))
+	for _, txid := range missing {
+		if m.suspects[txid] {
+			delete(m.txs, txid)
+			continue
+		}
+		suspects[txid] = true
+	}
+	m.suspects = suspects
+	for _, hash := range mempool {
+		m.txs[hash.String()] = struct{}{}
+	}
+	m.update()
+}
+
+// reconcileMempool runs as a goroutine and reconciles the tracked mempool
+// with the mempool of the node every mempoolReconcileInterval, until the
+// simulation exits
+func (com *Communication) reconcileMempool(client rpcCaller) {
+	defer com.wg.Done()
+
+	for {
+		select {
+		case <-time.After(mempoolReconcileInterval):
+		case <-com.exit:
+			return
+		}
+		mempool, err := client.GetRawMempool()
+		if err != nil {
+			log.Errorf("Cannot get mempool: %v", err)
+			continue
+		}
+		com.mempool.reconcile(mempool)
+	}
+}
+
+// Max returns the maximum number of transactions in the mempool so far
+func (m *mempoolTracker) Max() int {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	return m.max
+}
+
+// Height returns the height of the last block mined
+func (m *mempoolTracker) Height() int32 {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	return m.height
+}
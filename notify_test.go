@@ -0,0 +1,132 @@
+package btcsim
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/btcsuite/btcd/wire"
+	rpc "github.com/btcsuite/btcrpcclient"
+	"github.com/btcsuite/btcutil"
+)
+
+func TestNodeHandlers(t *testing.T) {
+	com := &Communication{events: newEventBus()}
+	var events []*Event
+	com.events.subscribe(func(e *Event) { events = append(events, e) },
+		eventBlockConnected, eventTxAccepted)
+
+	var connected int
+	h := com.nodeHandlers(2, &rpc.NotificationHandlers{
+		OnBlockConnected: func(hash *wire.ShaHash, height int32) { connected++ },
+	})
+	hash := &wire.ShaHash{1}
+	h.OnBlockConnected(hash, 5)
+	h.OnTxAccepted(hash, btcutil.Amount(1))
+	if connected != 1 {
+		t.Errorf("wrapped handler called %d times, want 1", connected)
+	}
+	if len(events) != 2 {
+		t.Fatalf("got %d events, want 2", len(events))
+	}
+	if e := events[0]; e.Kind != eventBlockConnected || e.Height != 5 || e.Node != 2 {
+		t.Errorf("got %v from node %d", e, e.Node)
+	}
+	if e := events[1]; e.Kind != eventTxAccepted || e.Hash != hash || e.Node != 2 {
+		t.Errorf("got %v from node %d", e, e.Node)
+	}
+}
+
+func TestMempoolTracker(t *testing.T) {
+	m := newMempoolTracker()
+	a := &wire.ShaHash{1}
+	m.seed(10, nil)
+	m.accepted(&Event{Kind: eventTxAccepted, Hash: a})
+	// transactions accepted by other nodes are ignored
+	m.accepted(&Event{Kind: eventTxAccepted, Hash: &wire.ShaHash{2}, Node: 1})
+	if m.Size() != 1 || m.Max() != 1 || m.Height() != 10 {
+		t.Errorf("got size %d, max %d, height %d, want 1, 1, 10", m.Size(), m.Max(), m.Height())
+	}
+
+	m.mined(&Event{Kind: eventBlockMined, Height: 11,
+		Block: &blockTxs{txids: []string{a.String()}}})
+	if m.Size() != 0 || m.Max() != 1 || m.Height() != 11 {
+		t.Errorf("got size %d, max %d, height %d, want 0, 1, 11", m.Size(), m.Max(), m.Height())
+	}
+}
+
+func TestMempoolReconcile(t *testing.T) {
+	m := newMempoolTracker()
+	replaced, kept, unseen := &wire.ShaHash{1}, &wire.ShaHash{2}, &wire.ShaHash{3}
+	m.seed(10, []*wire.ShaHash{replaced, kept})
+
+	// a transaction missing once may have been mined in the meantime
+	m.reconcile([]*wire.ShaHash{kept, unseen})
+	if m.Size() != 3 || !m.has(unseen.String()) {
+		t.Errorf("got size %d after one reconcile, want 3 with the unnotified transaction", m.Size())
+	}
+	m.reconcile([]*wire.ShaHash{kept, unseen})
+	if m.Size() != 2 || m.has(replaced.String()) || m.Max() != 3 {
+		t.Errorf("got size %d and max %d, want the replaced transaction removed", m.Size(), m.Max())
+	}
+}
+
+// pollChain is a chain and mempool polled for notifications
+type pollChain struct {
+	rpcCaller
+	hashes  []*wire.ShaHash
+	mempool []*wire.ShaHash
+}
+
+func (c *pollChain) GetBlockCount() (int64, error) {
+	return int64(len(c.hashes) - 1), nil
+}
+
+func (c *pollChain) GetBlockHash(height int64) (*wire.ShaHash, error) {
+	return c.hashes[height], nil
+}
+
+func (c *pollChain) GetRawMempool() ([]*wire.ShaHash, error) {
+	return c.mempool, nil
+}
+
+func TestNotifyPoller(t *testing.T) {
+	c := &pollChain{
+		hashes:  []*wire.ShaHash{{0}, {1}},
+		mempool: []*wire.ShaHash{{10}},
+	}
+	var got []string
+	p := &notifyPoller{handlers: &rpc.NotificationHandlers{
+		OnBlockConnected: func(hash *wire.ShaHash, height int32) {
+			got = append(got, fmt.Sprintf("connected %d %d", hash[0], height))
+		},
+		OnBlockDisconnected: func(hash *wire.ShaHash, height int32) {
+			got = append(got, fmt.Sprintf("disconnected %d %d", hash[0], height))
+		},
+		OnTxAccepted: func(hash *wire.ShaHash, amount btcutil.Amount) {
+			got = append(got, fmt.Sprintf("accepted %d", hash[0]))
+		},
+	}}
+	poll := func(want ...string) {
+		got = nil
+		if err := p.poll(c); err != nil {
+			t.Fatalf("poll error: %v", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("poll notified %q, want %q", got, want)
+		}
+	}
+
+	// the first poll records the chain and the mempool
+	poll()
+
+	c.mempool = append(c.mempool, &wire.ShaHash{11})
+	c.hashes = append(c.hashes, &wire.ShaHash{2}, &wire.ShaHash{3})
+	poll("accepted 11", "connected 2 2", "connected 3 3")
+	poll()
+
+	// a shorter chain replacing the last two blocks
+	c.hashes = append(c.hashes[:2], &wire.ShaHash{4})
+	c.mempool = nil
+	poll("disconnected 3 3", "disconnected 2 2", "connected 4 2")
+}
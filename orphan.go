@@ -0,0 +1,291 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+const (
+	// p2pTimeout is the time a node is given to complete the handshake
+	// and process a transaction sent over the p2p network
+	p2pTimeout = 10 * time.Second
+
+	// orphanTimeout is the time a node is given to accept an orphan
+	// transaction once its parent is broadcast
+	orphanTimeout = 30 * time.Second
+
+	// orphanPollInterval is the interval at which the node holding an
+	// orphan transaction is asked whether it accepted it
+	orphanPollInterval = 100 * time.Millisecond
+)
+
+// OrphanStats counts the transactions broadcast before their parents and
+// how the nodes handled them
+type OrphanStats struct {
+	// Injected is the number of children broadcast before their parents
+	// and Failed the number which could not be created or broadcast
+	Injected int `json:"injected"`
+	Failed   int `json:"failed"`
+
+	// Accepted is the number of children accepted to the mempool of the
+	// node once their parents were broadcast, Dropped the number which
+	// were not within orphanTimeout
+	Accepted int `json:"accepted"`
+	Dropped  int `json:"dropped"`
+
+	// time from the broadcast of the parents to the acceptance of the
+	// children
+	MeanDelay time.Duration `json:"meandelay"`
+	MaxDelay  time.Duration `json:"maxdelay"`
+
+	total time.Duration
+}
+
+// accepted records a child accepted delay after its parent was broadcast
+func (s *OrphanStats) accepted(delay time.Duration) {
+	s.Accepted++
+	s.total += delay
+	s.MeanDelay = s.total / time.Duration(s.Accepted)
+	if delay > s.MaxDelay {
+		s.MaxDelay = delay
+	}
+}
+
+// String returns the statistics as a single line
+func (s *OrphanStats) String() string {
+	return fmt.Sprintf("%d broadcast before their parents, %d accepted once the parents arrived "+
+		"(mean %v, max %v after), %d dropped, %d failed",
+		s.Injected, s.Accepted, s.MeanDelay, s.MaxDelay, s.Dropped, s.Failed)
+}
+
+// sendP2PTx connects to the node listening on addr as a peer and sends it
+// the transaction unannounced. The node processes it as a transaction
+// relayed by a peer and keeps it as an orphan if its parent is missing,
+// unlike sendrawtransaction which rejects orphans. A ping is sent after the
+// transaction, so that it is processed once the pong is received. The
+// messages are those of the network btcnet.
+func sendP2PTx(addr string, btcnet wire.BitcoinNet, tx *wire.MsgTx) error {
+	conn, err := net.DialTimeout("tcp", addr, p2pTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(p2pTimeout))
+
+	nonce, err := wire.RandomUint64()
+	if err != nil {
+		return err
+	}
+	you, err := wire.NewNetAddress(conn.RemoteAddr(), wire.SFNodeNetwork)
+	if err != nil {
+		return err
+	}
+	me := wire.NewNetAddressIPPort(net.IPv4(127, 0, 0, 1), 0, 0)
+	write := func(msg wire.Message) error {
+		return wire.WriteMessage(conn, msg, wire.ProtocolVersion, btcnet)
+	}
+	if err := write(wire.NewMsgVersion(me, you, nonce, 0)); err != nil {
+		return err
+	}
+
+	sent := false
+	for {
+		msg, _, err := wire.ReadMessage(conn, wire.ProtocolVersion, btcnet)
+		if err != nil {
+			return err
+		}
+		switch m := msg.(type) {
+		case *wire.MsgVersion:
+			if err := write(wire.NewMsgVerAck()); err != nil {
+				return err
+			}
+		case *wire.MsgVerAck:
+			if sent {
+				continue
+			}
+			if err := write(tx); err != nil {
+				return err
+			}
+			if err := write(wire.NewMsgPing(nonce)); err != nil {
+				return err
+			}
+			sent = true
+		case *wire.MsgPong:
+			if sent && m.Nonce == nonce {
+				return nil
+			}
+		}
+	}
+}
+
+// orphans runs as a goroutine and, on average every interval, picks a
+// running actor which sends a child transaction to a node over the p2p
+// network before sending its parent, to another node if there are several,
+// after delay, until the simulation exits
+func (com *Communication) orphans(interval, delay time.Duration) {
+	defer com.wg.Done()
+
+	r := com.cfg.newRand(orphanStream)
+	for {
+		select {
+		case <-time.After(time.Duration(r.ExpFloat64() * float64(interval))):
+		case <-com.exit:
+			return
+		}
+
+		var running []*Actor
+		for _, a := range com.Actors() {
+			if a.running() && a.floodTarget == 0 && !a.Paused() {
+				running = append(running, a)
+			}
+		}
+		if len(running) == 0 {
+			continue
+		}
+		if err := com.injectOrphan(r, running[r.Intn(len(running))], delay); err != nil {
+			log.Debugf("Cannot inject orphan transaction: %v", err)
+			com.orphanStats.Failed++
+		}
+	}
+}
+
+// injectOrphan creates a transaction spending an utxo of the actor and a
+// child spending its output, both paying the actor, broadcasts the child
+// to a node, the parent after delay, and waits for the node to accept the
+// child. A child not accepted in time is sent again to the node which got
+// its parent.
+func (com *Communication) injectOrphan(r *rand.Rand, a *Actor, delay time.Duration) error {
+	var utxo *TxOut
+	select {
+	case u, ok := <-a.utxoQueue.dequeue:
+		if !ok {
+			return nil
+		}
+		utxo = u
+	default:
+		log.Debugf("%s: No utxo to create an orphan transaction from", a)
+		return nil
+	}
+
+	size := estimateTxSize(1, 1)
+	amount := utxo.Amount - a.feeForSize(size)
+	childAmount := amount - a.feeForSize(size)
+	if childAmount < minFee {
+		a.requeue([]*TxOut{utxo})
+		return ErrInsufficientFunds
+	}
+	inputs := []btcjson.TransactionInput{{
+		Txid: utxo.OutPoint.Hash.String(),
+		Vout: utxo.OutPoint.Index,
+	}}
+	to := a.ownedAddresses[r.Intn(len(a.ownedAddresses))]
+	parent, err := a.createRawTransaction(inputs, map[btcutil.Address]btcutil.Amount{to: amount})
+	if err != nil {
+		a.requeue([]*TxOut{utxo})
+		return err
+	}
+
+	// the wallet does not know the parent yet, its output is passed
+	// to sign the child
+	parentHash := parent.TxSha()
+	op := wire.NewOutPoint(&parentHash, 0)
+	to = a.ownedAddresses[r.Intn(len(a.ownedAddresses))]
+	child, err := a.client.CreateRawTransaction([]btcjson.TransactionInput{{
+		Txid: parentHash.String(),
+		Vout: op.Index,
+	}}, map[btcutil.Address]btcutil.Amount{to: childAmount})
+	if err != nil {
+		a.requeue([]*TxOut{utxo})
+		return err
+	}
+	child, ok, err := a.signRawTransaction(child, []btcjson.RawTxInput{{
+		Txid:         parentHash.String(),
+		Vout:         op.Index,
+		ScriptPubKey: hex.EncodeToString(parent.TxOut[0].PkScript),
+	}})
+	if err != nil {
+		a.requeue([]*TxOut{utxo})
+		return err
+	}
+	if !ok {
+		a.requeue([]*TxOut{utxo})
+		return ErrIncompleteSignature
+	}
+	childHash := child.TxSha()
+
+	nodes := com.nodes
+	childNode := nodes[r.Intn(len(nodes))]
+	parentNode := childNode
+	for len(nodes) > 1 && parentNode == childNode {
+		parentNode = nodes[r.Intn(len(nodes))]
+	}
+
+	// the output of the parent must not be queued once it is mined, and
+	// neither transaction was requested
+	a.markSpent(*op)
+	com.untracked.add(parentHash)
+	com.untracked.add(childHash)
+	if err := sendP2PTx(childNode.Args.(ChainServer).ListenAddr(), com.cfg.netParams().Net, child); err != nil {
+		a.unmarkSpent(*op)
+		com.untracked.take(parentHash)
+		com.untracked.take(childHash)
+		a.requeue([]*TxOut{utxo})
+		return err
+	}
+	com.orphanStats.Injected++
+	log.Debugf("%s: Sent orphan transaction %s to %s", a, childHash, childNode)
+
+	select {
+	case <-time.After(delay):
+	case <-com.exit:
+		return nil
+	}
+	if _, err := parentNode.client.SendRawTransaction(parent, false); err != nil {
+		a.unmarkSpent(*op)
+		com.untracked.take(parentHash)
+		com.untracked.take(childHash)
+		a.requeue([]*TxOut{utxo})
+		return fmt.Errorf("parent rejected: %v", err)
+	}
+	sent := time.Now()
+	com.recordTx(a, parent, utxo.Amount)
+
+	// the child is accepted once it can be looked up in the mempool
+	ticker := time.NewTicker(orphanPollInterval)
+	defer ticker.Stop()
+	timeout := time.After(orphanTimeout)
+	for {
+		select {
+		case <-ticker.C:
+			txOut, err := childNode.client.GetTxOut(&childHash, 0, true)
+			if err != nil || txOut == nil {
+				continue
+			}
+			com.orphanStats.accepted(time.Since(sent))
+		case <-timeout:
+			com.orphanStats.Dropped++
+			log.Debugf("%s: Orphan transaction %s not accepted by %s after %v",
+				a, childHash, childNode, orphanTimeout)
+			if _, err := parentNode.client.SendRawTransaction(child, false); err != nil {
+				a.unmarkSpent(*op)
+				com.untracked.take(childHash)
+				return nil
+			}
+		case <-com.exit:
+			return nil
+		}
+		com.recordTx(a, child, amount)
+		return nil
+	}
+}
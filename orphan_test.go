@@ -0,0 +1,138 @@
+package btcsim
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/wire"
+)
+
+// fakePeer accepts a connection on l and completes the handshake, then
+// sends the transactions it receives to txs and answers pings
+func fakePeer(t *testing.T, l net.Listener, txs chan<- *wire.MsgTx) {
+	conn, err := l.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	write := func(msg wire.Message) {
+		if err := wire.WriteMessage(conn, msg, wire.ProtocolVersion, wire.SimNet); err != nil {
+			t.Errorf("WriteMessage error: %v", err)
+		}
+	}
+	for {
+		msg, _, err := wire.ReadMessage(conn, wire.ProtocolVersion, wire.SimNet)
+		if err != nil {
+			return
+		}
+		switch m := msg.(type) {
+		case *wire.MsgVersion:
+			me := wire.NewNetAddressIPPort(net.IPv4(127, 0, 0, 1), 0, 0)
+			write(wire.NewMsgVersion(me, me, 1, 0))
+			write(wire.NewMsgVerAck())
+		case *wire.MsgTx:
+			txs <- m
+		case *wire.MsgPing:
+			write(wire.NewMsgPong(m.Nonce))
+		}
+	}
+}
+
+func TestSendP2PTx(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen error: %v", err)
+	}
+	defer l.Close()
+	txs := make(chan *wire.MsgTx, 1)
+	go fakePeer(t, l, txs)
+
+	tx := wire.NewMsgTx()
+	tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(shaHash(1), 0), nil))
+	tx.AddTxOut(wire.NewTxOut(1000, []byte{0x51}))
+	if err := sendP2PTx(l.Addr().String(), wire.SimNet, tx); err != nil {
+		t.Fatalf("sendP2PTx error: %v", err)
+	}
+	select {
+	case got := <-txs:
+		if got.TxSha() != tx.TxSha() {
+			t.Errorf("peer got transaction %v want %v", got.TxSha(), tx.TxSha())
+		}
+	default:
+		t.Errorf("transaction not received before the pong")
+	}
+
+	// nothing listens on the address once closed
+	addr := l.Addr().String()
+	l.Close()
+	if err := sendP2PTx(addr, wire.SimNet, tx); err == nil {
+		t.Errorf("sendP2PTx to a closed port succeeded")
+	}
+}
+
+func TestInjectOrphanRequeues(t *testing.T) {
+	chain := NewMockChain(DefaultConfig())
+	a := chain.NewActor("a")
+	defer StopMockActors(a)
+	mineMock(t, a, a)
+	utxo := <-a.utxoQueue.dequeue
+
+	// nothing listens on the address of the node
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen error: %v", err)
+	}
+	l.Close()
+	args, err := newBtcdArgs(DefaultConfig(), "node")
+	if err != nil {
+		t.Fatalf("newBtcdArgs error: %v", err)
+	}
+	defer args.Cleanup()
+	args.SetListen(l.Addr().String(), "127.0.0.1:0")
+	n, err := NewNodeFromArgs(DefaultConfig(), args, nil, nil)
+	if err != nil {
+		t.Fatalf("NewNodeFromArgs error: %v", err)
+	}
+	com := NewCommunication(DefaultConfig())
+	com.nodes = []*Node{n}
+
+	requeued := func() *TxOut {
+		select {
+		case u := <-a.utxoQueue.dequeue:
+			return u
+		default:
+			return nil
+		}
+	}
+	a.utxoQueue.enqueue <- utxo
+	if err := com.injectOrphan(DefaultConfig().newRand(1), a, 0); err == nil {
+		t.Fatalf("injectOrphan to an unreachable node succeeded")
+	}
+	if u := requeued(); u != utxo {
+		t.Errorf("utxo not requeued after the child failed to send, got %v", u)
+	}
+
+	a.utxoQueue.enqueue <- &TxOut{OutPoint: utxo.OutPoint, Amount: minFee}
+	if err := com.injectOrphan(DefaultConfig().newRand(1), a, 0); err != ErrInsufficientFunds {
+		t.Errorf("injectOrphan of a tiny utxo error %v want %v", err, ErrInsufficientFunds)
+	}
+	if u := requeued(); u == nil || u.Amount != minFee {
+		t.Errorf("tiny utxo not requeued, got %v", u)
+	}
+}
+
+func TestOrphanStats(t *testing.T) {
+	s := &OrphanStats{Injected: 4, Dropped: 1, Failed: 1}
+	s.accepted(time.Second)
+	s.accepted(3 * time.Second)
+	if s.Accepted != 2 || s.MeanDelay != 2*time.Second || s.MaxDelay != 3*time.Second {
+		t.Errorf("got %d accepted, mean %v, max %v want 2, 2s, 3s",
+			s.Accepted, s.MeanDelay, s.MaxDelay)
+	}
+	want := "4 broadcast before their parents, 2 accepted once the parents arrived " +
+		"(mean 2s, max 3s after), 1 dropped, 1 failed"
+	if s.String() != want {
+		t.Errorf("String got %q want %q", s.String(), want)
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Phases split a run into stages, e.g. warm-up, steady load, stress spike
+// and cool-down, each changing the number of actors, the transaction rate
+// and the block interval when it is entered:
+//
+//   at 0s phase warmup actors 10 txrate 2 blockinterval 1m
+//   at 10m phase steady actors 50 txrate 10
+//   at 30m phase stress txrate 50 blockinterval 20s
+//   at 40m phase cooldown actors 10 txrate 1 blockinterval 1m
+//
+// The parameters a phase does not set keep their value. The actors are
+// started or removed as with the /actors/scale endpoint of the control API,
+// a rate of 0 removes the transaction rate limit, and the block interval
+// applies from the next block with the interval and poisson mining
+// schedules, and is the minimum one with the curve schedule.
+
+// phaseAction enters a phase of the simulation
+type phaseAction struct {
+	name string
+	// actors is the number of actors of the phase, zero to keep it
+	actors int
+	// txRate is the transaction rate of the phase, negative to keep it
+	txRate float64
+	// blockInterval is the block interval of the phase, zero to keep it
+	blockInterval time.Duration
+}
+
+// parsePhaseAction parses 'phase <name> [actors <n>] [txrate <tx/s>]
+// [blockinterval <duration>]'
+func parsePhaseAction(args []string) (scenarioAction, error) {
+	if len(args) < 3 || len(args)%2 != 1 {
+		return nil, errors.New("expected 'phase <name> [actors <n>] [txrate <tx/s>] [blockinterval <duration>]'")
+	}
+	a := &phaseAction{name: args[0], txRate: -1}
+	seen := make(map[string]bool)
+	for i := 1; i < len(args); i += 2 {
+		key, value := args[i], args[i+1]
+		if seen[key] {
+			return nil, fmt.Errorf("duplicate phase parameter %q", key)
+		}
+		seen[key] = true
+		switch key {
+		case "actors":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid number of actors %q", value)
+			}
+			a.actors = n
+		case "txrate":
+			rate, err := strconv.ParseFloat(value, 64)
+			if err != nil || rate < 0 {
+				return nil, fmt.Errorf("invalid tx rate %q", value)
+			}
+			a.txRate = rate
+		case "blockinterval":
+			d, err := time.ParseDuration(value)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("invalid block interval %q", value)
+			}
+			a.blockInterval = d
+		default:
+			return nil, fmt.Errorf("unknown phase parameter %q", key)
+		}
+	}
+	return a, nil
+}
+
+func (a *phaseAction) String() string {
+	params := []string{"phase", a.name}
+	if a.actors > 0 {
+		params = append(params, "actors", strconv.Itoa(a.actors))
+	}
+	if a.txRate >= 0 {
+		params = append(params, "txrate", strconv.FormatFloat(a.txRate, 'g', -1, 64))
+	}
+	if a.blockInterval > 0 {
+		params = append(params, "blockinterval", a.blockInterval.String())
+	}
+	return strings.Join(params, " ")
+}
+
+func (a *phaseAction) run(sc *Scenario) error {
+	log.Infof("Entering phase %s", a.name)
+	if a.txRate >= 0 {
+		sc.com.throttle.setRate(sc.com.cfg.realRate(a.txRate))
+	}
+	if a.blockInterval > 0 {
+		sc.miner.setInterval(sc.com.cfg.realDuration(a.blockInterval))
+	}
+	if a.actors > 0 {
+		if _, err := sc.com.scaleActors(a.actors, sc.miner); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// phaseRates reports whether a phase of the scenario sets the transaction
+// rate
+func (sc *Scenario) phaseRates() bool {
+	for _, a := range sc.actions() {
+		if p, ok := a.(*phaseAction); ok && p.txRate >= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// phaseIntervals reports whether a phase of the scenario sets the block
+// interval
+func (sc *Scenario) phaseIntervals() bool {
+	for _, a := range sc.actions() {
+		if p, ok := a.(*phaseAction); ok && p.blockInterval > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPhases returns an error if the phases of the scenario set a
+// parameter which the simulation controls otherwise: the transaction rate
+// following a load profile or seasonality, or the block interval of the
+// ondemand mining schedule
+func checkPhases(sc *Scenario, rateControlled bool, schedule string) error {
+	if sc.phaseRates() && rateControlled {
+		return errors.New("scenario phases cannot set the tx rate " +
+			"with -load or -seasonality")
+	}
+	if sc.phaseIntervals() && schedule == scheduleOnDemand {
+		return fmt.Errorf("scenario phases cannot set the block interval "+
+			"of the %s mining schedule", scheduleOnDemand)
+	}
+	return nil
+}
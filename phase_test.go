@@ -0,0 +1,97 @@
+package btcsim
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParsePhaseAction(t *testing.T) {
+	tests := []struct {
+		line string
+		want phaseAction
+	}{
+		{"stress actors 50 txrate 20 blockinterval 30s",
+			phaseAction{name: "stress", actors: 50, txRate: 20, blockInterval: 30 * time.Second}},
+		{"cooldown txrate 0", phaseAction{name: "cooldown", txRate: 0}},
+		{"steady actors 10", phaseAction{name: "steady", actors: 10, txRate: -1}},
+	}
+	for _, test := range tests {
+		a, err := parsePhaseAction(strings.Fields(test.line))
+		if err != nil {
+			t.Errorf("parsePhaseAction(%q) error: %v", test.line, err)
+			continue
+		}
+		if got := *a.(*phaseAction); got != test.want {
+			t.Errorf("parsePhaseAction(%q) = %+v, want %+v", test.line, got, test.want)
+		}
+		if got := a.String(); got != "phase "+test.line {
+			t.Errorf("String() = %q, want %q", got, "phase "+test.line)
+		}
+	}
+
+	for _, line := range []string{
+		"warmup",
+		"warmup actors",
+		"warmup actors 0",
+		"warmup txrate -1",
+		"warmup blockinterval soon",
+		"warmup actors 5 actors 6",
+		"warmup miners 5",
+	} {
+		if _, err := parsePhaseAction(strings.Fields(line)); err == nil {
+			t.Errorf("parsePhaseAction(%q) succeeded", line)
+		}
+	}
+}
+
+func TestCheckPhases(t *testing.T) {
+	sc, err := readScenario(strings.NewReader(
+		"at 0s phase warmup txrate 2\nat 10m phase stress blockinterval 20s\n"))
+	if err != nil {
+		t.Fatalf("readScenario error: %v", err)
+	}
+	if err := checkPhases(sc, false, scheduleInterval); err != nil {
+		t.Errorf("checkPhases error: %v", err)
+	}
+	if err := checkPhases(sc, true, scheduleInterval); err == nil {
+		t.Errorf("checkPhases of a controlled rate succeeded")
+	}
+	if err := checkPhases(sc, false, scheduleOnDemand); err == nil {
+		t.Errorf("checkPhases of the ondemand schedule succeeded")
+	}
+	if err := checkPhases(&Scenario{}, true, scheduleOnDemand); err != nil {
+		t.Errorf("checkPhases of a scenario without phases error: %v", err)
+	}
+}
+
+func TestPhaseRun(t *testing.T) {
+	cfg := DefaultConfig()
+	com := NewCommunication(cfg)
+	a, b, c := fakeActor("a"), fakeActor("b"), fakeActor("c")
+	com.actors = []*Actor{a, b, c}
+	miner := &Miner{schedule: scheduleInterval, interval: time.Minute, rand: cfg.newRand(minerStream)}
+	sc := &Scenario{com: com, miner: miner}
+
+	p := &phaseAction{name: "cooldown", actors: 1, txRate: 5, blockInterval: 2 * time.Minute}
+	if err := p.run(sc); err != nil {
+		t.Fatalf("run error: %v", err)
+	}
+	if n := len(com.Actors()); n != 1 {
+		t.Errorf("%d actors after the phase, want 1", n)
+	}
+	if com.throttle.ticker == nil {
+		t.Errorf("tx rate not limited by the phase")
+	}
+	if got := miner.nextBlockDelay(); got != cfg.realDuration(2*time.Minute) {
+		t.Errorf("block interval %v after the phase, want 2m", got)
+	}
+
+	// the parameters the phase does not set are kept
+	if err := (&phaseAction{name: "steady", txRate: -1}).run(sc); err != nil {
+		t.Fatalf("run error: %v", err)
+	}
+	if com.throttle.ticker == nil || miner.nextBlockDelay() != cfg.realDuration(2*time.Minute) {
+		t.Errorf("phase without parameters changed the rate or the block interval")
+	}
+}
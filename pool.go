@@ -0,0 +1,235 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/btcsuite/btcutil"
+)
+
+// behaviorPool is the name of the mining pool behavior, optionally
+// followed by a colon and the number of blocks between its payouts, e.g.
+// pool:20
+const behaviorPool = "pool"
+
+const (
+	// defaultPoolInterval is the number of blocks between the payouts
+	// of a pool unless given
+	defaultPoolInterval = 10
+
+	// poolFee is the fraction of the rewards kept by a pool
+	poolFee = 0.02
+
+	// poolMinPayout is the least amount a pool pays a hasher, smaller
+	// amounts owed are carried over to the next payout
+	poolMinPayout btcutil.Amount = 1e6 // 0.01 BTC
+)
+
+// pool is a mining pool: the simulation mines every block to the pools,
+// which owe the rewards, minus their fee, to their hashers in proportion to
+// their hash rate and pay them in a single batched transaction every
+// interval blocks
+type pool struct {
+	NopBehavior
+	interval int32
+
+	// hashers are the addresses of the hashers paid by the pool, picked
+	// among the other actors on the first reward, shares their shares of
+	// the hash rate and owed the amounts owed to them
+	mtx     sync.Mutex
+	hashers []btcutil.Address
+	names   []string
+	shares  []float64
+	owed    []btcutil.Amount
+	last    int32
+	stats   PoolStats
+}
+
+// newPool returns the pool behavior with the given name, which sets the
+// number of blocks between its payouts
+func newPool(name string) (*pool, error) {
+	interval := int64(defaultPoolInterval)
+	if name != behaviorPool {
+		var err error
+		s := strings.TrimPrefix(name, behaviorPool+":")
+		if interval, err = strconv.ParseInt(s, 10, 32); err != nil || interval < 1 {
+			return nil, fmt.Errorf("invalid payout interval %q of pool", s)
+		}
+	}
+	return &pool{interval: int32(interval)}, nil
+}
+
+// isPool reports whether the behavior name is a mining pool
+func isPool(name string) bool {
+	return name == behaviorPool || strings.HasPrefix(name, behaviorPool+":")
+}
+
+// OnPaymentReceived credits the hashers with their share of a block
+// reward, the other payments to the pool are its own
+func (p *pool) OnPaymentReceived(a *Actor, out *TxOut) {
+	if !out.Coinbase {
+		return
+	}
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	if p.hashers == nil {
+		p.pickHashers(a)
+	}
+	p.credit(out.Amount)
+}
+
+// pickHashers picks the other running actors as the hashers of the pool,
+// with exponentially distributed hash rates, the lock must be held by the
+// caller
+func (p *pool) pickHashers(a *Actor) {
+	if a.peers == nil {
+		return
+	}
+	for _, o := range a.peers() {
+		if o == a {
+			continue
+		}
+		p.hashers = append(p.hashers, o.Address())
+		p.names = append(p.names, o.String())
+		p.shares = append(p.shares, a.rand.ExpFloat64())
+	}
+	var total float64
+	for _, s := range p.shares {
+		total += s
+	}
+	for i := range p.shares {
+		p.shares[i] /= total
+	}
+	p.owed = make([]btcutil.Amount, len(p.hashers))
+}
+
+// credit owes a reward minus the pool fee to the hashers, the lock must be
+// held by the caller
+func (p *pool) credit(reward btcutil.Amount) {
+	p.stats.Blocks++
+	p.stats.Rewards += int64(reward)
+	if len(p.hashers) == 0 {
+		return
+	}
+	net := float64(reward) * (1 - poolFee)
+	for i, s := range p.shares {
+		p.owed[i] += btcutil.Amount(net * s)
+	}
+}
+
+// OnBlock pays out the hashers owed at least poolMinPayout every interval
+// blocks
+func (p *pool) OnBlock(a *Actor, height int32) {
+	p.mtx.Lock()
+	if height-p.last < p.interval {
+		p.mtx.Unlock()
+		return
+	}
+	p.last = height
+	amounts := p.payouts()
+	p.mtx.Unlock()
+	if len(amounts) == 0 {
+		return
+	}
+
+	_, err := a.Pay(amounts)
+	switch err {
+	case nil:
+	case ErrInsufficientFunds:
+		// the amounts stay owed until the next payout
+		log.Debugf("%s: Cannot pay out %d hashers: %v", a, len(amounts), err)
+		return
+	case ErrActorShutdown:
+		return
+	default:
+		log.Errorf("%s: Cannot pay out %d hashers: %v", a, len(amounts), err)
+		return
+	}
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	var total btcutil.Amount
+	for i, addr := range p.hashers {
+		if amt, ok := amounts[addr]; ok {
+			p.owed[i] -= amt
+			total += amt
+		}
+	}
+	p.stats.Payouts++
+	p.stats.Paid += len(amounts)
+	p.stats.PaidOut += int64(total)
+}
+
+// payouts returns the amounts owed to the hashers of at least
+// poolMinPayout, the lock must be held by the caller
+func (p *pool) payouts() map[btcutil.Address]btcutil.Amount {
+	amounts := make(map[btcutil.Address]btcutil.Amount)
+	for i, addr := range p.hashers {
+		if p.owed[i] >= poolMinPayout {
+			amounts[addr] = p.owed[i]
+		}
+	}
+	return amounts
+}
+
+// Stats returns the rewards and payouts of the pool
+func (p *pool) Stats() PoolStats {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	s := p.stats
+	s.Interval = p.interval
+	s.Hashers = len(p.hashers)
+	for _, amt := range p.owed {
+		s.Owed += int64(amt)
+	}
+	return s
+}
+
+// PoolStats are the block rewards received by a mining pool and the
+// payouts to its hashers
+type PoolStats struct {
+	Name     string `json:"name"`
+	Interval int32  `json:"interval"`
+	Hashers  int    `json:"hashers"`
+
+	// Blocks is the number of block rewards received and Rewards their
+	// amount
+	Blocks  int   `json:"blocks"`
+	Rewards int64 `json:"rewards"`
+
+	// Payouts is the number of payout transactions, Paid the number of
+	// payments to hashers they made, PaidOut their amount and Owed the
+	// amount still owed to the hashers
+	Payouts int   `json:"payouts"`
+	Paid    int   `json:"paid"`
+	PaidOut int64 `json:"paidout"`
+	Owed    int64 `json:"owed"`
+}
+
+// String returns the stats in a line of the summary
+func (s *PoolStats) String() string {
+	return fmt.Sprintf("%d hashers, %d block rewards (%v), %d payouts every %d blocks paying %d hashers %v, %v owed",
+		s.Hashers, s.Blocks, btcutil.Amount(s.Rewards), s.Payouts, s.Interval, s.Paid,
+		btcutil.Amount(s.PaidOut), btcutil.Amount(s.Owed))
+}
+
+// poolStats returns the stats of the mining pools among the actors
+func poolStats(actors []*Actor) []*PoolStats {
+	var stats []*PoolStats
+	for _, a := range actors {
+		p, ok := a.behavior.(*pool)
+		if !ok {
+			continue
+		}
+		s := p.Stats()
+		s.Name = a.String()
+		stats = append(stats, &s)
+	}
+	return stats
+}
@@ -0,0 +1,77 @@
+package btcsim
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcutil"
+)
+
+func TestNewPool(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval int32
+		ok       bool
+	}{
+		{"pool", defaultPoolInterval, true},
+		{"pool:1", 1, true},
+		{"pool:0", 0, false},
+		{"pool:x", 0, false},
+	}
+	for _, test := range tests {
+		b, err := newBehavior(test.name)
+		if (err == nil) != test.ok {
+			t.Errorf("newBehavior(%q) error: %v", test.name, err)
+			continue
+		}
+		if test.ok && b.(*pool).interval != test.interval {
+			t.Errorf("newBehavior(%q) got interval %d want %d", test.name, b.(*pool).interval, test.interval)
+		}
+	}
+}
+
+func TestPoolHashers(t *testing.T) {
+	a, b, c := fakeActor("pool"), fakeActor("b"), fakeActor("c")
+	for i, o := range []*Actor{a, b, c} {
+		o.rand = DefaultConfig().newRand(int64(i))
+		o.ownedAddresses = []btcutil.Address{fakeAddress(o.String())}
+	}
+	a.peers = func() []*Actor { return []*Actor{a, b, c} }
+
+	p, _ := newPool("pool")
+	p.OnPaymentReceived(a, &TxOut{Amount: 5e9})
+	if p.hashers != nil || p.stats.Blocks != 0 {
+		t.Errorf("pool credited a payment which is not a block reward")
+	}
+	p.OnPaymentReceived(a, &TxOut{Amount: 5e9, Coinbase: true})
+	if len(p.hashers) != 2 || p.names[0] != b.String() || p.names[1] != c.String() {
+		t.Fatalf("got hashers %v", p.names)
+	}
+	var owed btcutil.Amount
+	for _, amt := range p.owed {
+		owed += amt
+	}
+	if want := btcutil.Amount(5e9 * (1 - poolFee)); owed < want-2 || owed > want {
+		t.Errorf("got %v owed want %v", owed, want)
+	}
+}
+
+func TestPoolPayouts(t *testing.T) {
+	p, _ := newPool("pool:5")
+	p.hashers = []btcutil.Address{fakeAddress("big"), fakeAddress("small")}
+	p.shares = []float64{0.999, 0.001}
+	p.owed = make([]btcutil.Amount, 2)
+	p.credit(1e8)
+
+	amounts := p.payouts()
+	if len(amounts) != 1 || amounts[fakeAddress("big")] != p.owed[0] {
+		t.Errorf("got payouts %v of owed %v", amounts, p.owed)
+	}
+	s := p.Stats()
+	if s.Blocks != 1 || s.Rewards != 1e8 || s.Hashers != 2 || s.Interval != 5 {
+		t.Errorf("got stats %+v", s)
+	}
+	if !strings.Contains(s.String(), "2 hashers, 1 block rewards") {
+		t.Errorf("got %q", s.String())
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"sync"
+)
+
+// portAllocator hands out local ports which are free when allocated and
+// have not been handed out before, so that the processes of a simulation
+// neither collide with each other nor with other local services
+type portAllocator struct {
+	mtx  sync.Mutex
+	used map[uint16]bool
+}
+
+// ports is the port allocator of the simulations of the process, which
+// never hands out a port to two of them
+var ports = &portAllocator{used: make(map[uint16]bool)}
+
+// alloc returns the preferred port if it is free, or a free port chosen
+// by the system otherwise. Preferred ports are computed from the number
+// of nodes and actors and the run ID, so those past the last port are
+// ignored rather than wrapped around.
+func (p *portAllocator) alloc(preferred int) (uint16, error) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if preferred > 0 && preferred <= math.MaxUint16 {
+		port := uint16(preferred)
+		if !p.used[port] && portFree(port) {
+			p.used[port] = true
+			return port, nil
+		}
+	}
+	for {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return 0, err
+		}
+		port := uint16(l.Addr().(*net.TCPAddr).Port)
+		l.Close()
+		if !p.used[port] {
+			p.used[port] = true
+			return port, nil
+		}
+	}
+}
+
+// portFree reports whether the local port can be listened on
+func portFree(port uint16) bool {
+	l, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		return false
+	}
+	l.Close()
+	return true
+}
+
+// allocPort returns a port allocated by ports, preferring the given one
+// shifted by the run ID so that concurrent runs prefer different ports
+func (c *Config) allocPort(preferred int) (uint16, error) {
+	if preferred != 0 {
+		preferred += c.RunID * runPortSpacing
+	}
+	return ports.alloc(preferred)
+}
+
+// localAddr returns a local address on a port allocated by allocPort
+func (c *Config) localAddr(preferred int) (string, error) {
+	port, err := c.allocPort(preferred)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("127.0.0.1:%d", port), nil
+}
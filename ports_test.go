@@ -0,0 +1,66 @@
+package btcsim
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"testing"
+)
+
+func TestPortAllocator(t *testing.T) {
+	p := &portAllocator{used: make(map[uint16]bool)}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	busy := uint16(l.Addr().(*net.TCPAddr).Port)
+
+	// a busy port is not handed out
+	port, err := p.alloc(int(busy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if port == busy {
+		t.Errorf("alloc handed out busy port %d", busy)
+	}
+
+	// a free port is handed out once
+	free, err := p.alloc(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	delete(p.used, free)
+	got, err := p.alloc(int(free))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != free {
+		t.Errorf("alloc(%d) = %d, want the preferred port", free, got)
+	}
+	again, err := p.alloc(int(free))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again == free {
+		t.Errorf("alloc handed out port %d twice", free)
+	}
+
+	// preferred ports past the last port do not wrap around
+	delete(p.used, free)
+	wrapped, err := p.alloc(int(free) + math.MaxUint16 + 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wrapped == free {
+		t.Errorf("alloc(%d) wrapped around to %d", int(free)+math.MaxUint16+1, free)
+	}
+
+	// allocated ports can be listened on
+	ln, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", again))
+	if err != nil {
+		t.Fatalf("cannot listen on allocated port: %v", err)
+	}
+	ln.Close()
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+const (
+	// probeInterval is the interval between the first two readiness
+	// probes of a node, it doubles after every failed probe up to
+	// probeMaxInterval
+	probeInterval    = 50 * time.Millisecond
+	probeMaxInterval = time.Second
+
+	// probeDialTimeout is the time a probe waits for the rpc server of a
+	// node to accept its connection
+	probeDialTimeout = time.Second
+)
+
+// errProcessExited is returned by the probes of a node whose process exited
+// before it was ready
+var errProcessExited = errors.New("process exited before it was ready")
+
+// processExited returns a channel which is closed when the current process
+// of the node exits, nil for a node without process
+func (n *Node) processExited() <-chan struct{} {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	return n.exited
+}
+
+// probe calls f until it succeeds, the process of the node exits or timeout
+// elapses, backing off between the calls. The error of the last call is
+// returned on timeout.
+func (n *Node) probe(what string, timeout time.Duration, f func() error) error {
+	exited := n.processExited()
+	deadline := time.After(timeout)
+	interval := probeInterval
+	for attempt := 1; ; attempt++ {
+		err := f()
+		if err == nil {
+			if attempt > 1 {
+				log.Debugf("%s: Rpc server %s after %d probes", n, what, attempt)
+			}
+			return nil
+		}
+		log.Tracef("%s: Rpc server not %s yet: %v", n, what, err)
+		select {
+		case <-time.After(interval):
+		case <-exited:
+			return errProcessExited
+		case <-deadline:
+			return fmt.Errorf("not %s after %v: %v", what, timeout, err)
+		}
+		if interval *= 2; interval > probeMaxInterval {
+			interval = probeMaxInterval
+		}
+	}
+}
+
+// dialProbe returns a probe succeeding once the rpc server at host accepts
+// connections, so that the rpc client is only created once it can connect
+func dialProbe(host string) func() error {
+	return func() error {
+		conn, err := net.DialTimeout("tcp", host, probeDialTimeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+}
+
+// readyProbe returns a probe succeeding once the node serves rpc calls:
+// getinfo for btcd, and getblockcount otherwise, which btcwallet answers
+// once connected to its chain server and bitcoind once its chain is loaded
+func (n *Node) readyProbe() func() error {
+	if _, ok := unwrapArgs(n.Args).(*btcdArgs); ok {
+		return func() error {
+			_, err := n.client.GetInfo()
+			return err
+		}
+	}
+	return func() error {
+		_, err := n.client.GetBlockCount()
+		return err
+	}
+}
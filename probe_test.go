@@ -0,0 +1,57 @@
+package btcsim
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProbe(t *testing.T) {
+	n, _ := NewNodeFromArgs(DefaultConfig(), &fakeArgs{name: "node"}, nil, nil)
+
+	var calls int
+	err := n.probe("ready", time.Minute, func() error {
+		if calls++; calls < 3 {
+			return errors.New("connection refused")
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Errorf("probe returned %v after %d calls, want success after 3", err, calls)
+	}
+
+	err = n.probe("ready", 10*time.Millisecond, func() error {
+		return errors.New("connection refused")
+	})
+	if err == nil || !strings.Contains(err.Error(), "connection refused") {
+		t.Errorf("probe timed out with %v, want the last error", err)
+	}
+
+	// the probe gives up as soon as the process exits
+	n.exited = make(chan struct{})
+	close(n.exited)
+	start := time.Now()
+	err = n.probe("ready", time.Minute, func() error {
+		return errors.New("connection refused")
+	})
+	if err != errProcessExited || time.Since(start) > time.Second {
+		t.Errorf("probe of an exited process returned %v after %v", err, time.Since(start))
+	}
+}
+
+func TestDialProbe(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen error: %v", err)
+	}
+	host := l.Addr().String()
+	if err := dialProbe(host)(); err != nil {
+		t.Errorf("dialProbe of a listening server error: %v", err)
+	}
+	l.Close()
+	if err := dialProbe(host)(); err == nil {
+		t.Errorf("dialProbe of a closed server succeeded")
+	}
+}
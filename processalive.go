@@ -0,0 +1,26 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package btcsim
+
+import (
+	"os"
+	"syscall"
+)
+
+func init() {
+	processAlive = func(pid int) bool {
+		p, err := os.FindProcess(pid)
+		if err != nil {
+			return false
+		}
+		// signal 0 only checks the process exists, a process of
+		// another user is alive too
+		err = p.Signal(syscall.Signal(0))
+		return err == nil || err == syscall.EPERM
+	}
+}
@@ -0,0 +1,20 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import "os"
+
+func init() {
+	processAlive = func(pid int) bool {
+		// finding a process on windows opens it, which fails once it
+		// exited
+		p, err := os.FindProcess(pid)
+		if err != nil {
+			return false
+		}
+		p.Release()
+		return true
+	}
+}
@@ -0,0 +1,391 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcutil"
+)
+
+// Recipient selection policies of a profile
+const (
+	// recipientRandom pays the address picked by the matchmaker
+	recipientRandom = "random"
+
+	// recipientSelf pays one of the actor's own addresses
+	recipientSelf = "self"
+
+	// recipientFixed always pays the first address picked by the
+	// matchmaker for this actor
+	recipientFixed = "fixed"
+)
+
+// Profile describes how an actor behaves when it is asked to send a
+// transaction
+type Profile struct {
+	Name string
+
+	// Activity is the probability in (0, 1] that the actor competes for
+	// the next transaction request, so less active actors send less often
+	Activity float64
+
+	// MinSpend and MaxSpend bound the fraction of an utxo that is sent
+	// to the recipient, the rest is sent back to the actor as change
+	MinSpend float64
+	MaxSpend float64
+
+	// Recipient is the recipient selection policy
+	Recipient string
+
+	// DoubleSpend is the probability in [0, 1] that the actor attempts to
+	// double spend a payment by sending a conflicting transaction paying
+	// itself directly to the miner
+	DoubleSpend float64
+
+	// Amounts is the distribution of the amounts paid, which overrides
+	// the spend fractions and -amountdist when set
+	Amounts *amountDist
+
+	// Fees is the fee policy, which overrides -feepolicy when set
+	Fees *feePolicy
+
+	// Consolidate is the number of queued utxos from which the actor
+	// sweeps them into one when fees are low, zero disables it
+	Consolidate int
+
+	// Batch is the number of recipients paid by each transaction, as
+	// with exchange or mining pool payouts, zero or one pays only one
+	Batch int
+
+	// Chain is the number of unconfirmed transactions the actor chains
+	// after each payment, each spending the output of the previous one
+	// starting with the change, zero disables it
+	Chain int
+}
+
+// defaultProfile sends whole utxos to random actors whenever asked to,
+// it is used for actors without a profile
+var defaultProfile = &Profile{
+	Name:      "default",
+	Activity:  1,
+	MinSpend:  1,
+	MaxSpend:  1,
+	Recipient: recipientRandom,
+}
+
+// profiles are the built-in profiles by name, custom profiles
+// read with -profilefile are added to it
+var profiles = map[string]*Profile{
+	defaultProfile.Name: defaultProfile,
+	"spender": {
+		Name:      "spender",
+		Activity:  1,
+		MinSpend:  0.1,
+		MaxSpend:  0.9,
+		Recipient: recipientRandom,
+	},
+	"hoarder": {
+		Name:      "hoarder",
+		Activity:  0.1,
+		MinSpend:  1,
+		MaxSpend:  1,
+		Recipient: recipientSelf,
+	},
+	"exchange": {
+		Name:      "exchange",
+		Activity:  1,
+		MinSpend:  0.01,
+		MaxSpend:  0.2,
+		Recipient: recipientRandom,
+	},
+	"faucet": {
+		Name:      "faucet",
+		Activity:  1,
+		MinSpend:  0.001,
+		MaxSpend:  0.01,
+		Recipient: recipientRandom,
+	},
+	"consolidator": {
+		Name:        "consolidator",
+		Activity:    1,
+		MinSpend:    0.01,
+		MaxSpend:    0.2,
+		Recipient:   recipientRandom,
+		Consolidate: 20,
+	},
+	"payout": {
+		Name:      "payout",
+		Activity:  1,
+		MinSpend:  0.5,
+		MaxSpend:  0.9,
+		Recipient: recipientRandom,
+		Batch:     100,
+	},
+	"chainer": {
+		Name:      "chainer",
+		Activity:  1,
+		MinSpend:  0.1,
+		MaxSpend:  0.5,
+		Recipient: recipientRandom,
+		Chain:     maxChainDepth,
+	},
+	"attacker": {
+		Name:        "attacker",
+		Activity:    1,
+		MinSpend:    1,
+		MaxSpend:    1,
+		Recipient:   recipientRandom,
+		DoubleSpend: 1,
+	},
+}
+
+// validate returns an error if the profile parameters are out of range
+func (p *Profile) validate() error {
+	if p.Activity <= 0 || p.Activity > 1 {
+		return fmt.Errorf("profile %s: activity must be in (0, 1]", p.Name)
+	}
+	if p.MinSpend <= 0 || p.MaxSpend > 1 || p.MinSpend > p.MaxSpend {
+		return fmt.Errorf("profile %s: spend fractions must satisfy 0 < min <= max <= 1", p.Name)
+	}
+	if p.DoubleSpend < 0 || p.DoubleSpend > 1 {
+		return fmt.Errorf("profile %s: double spend probability must be in [0, 1]", p.Name)
+	}
+	if p.Consolidate < 0 || p.Consolidate == 1 {
+		return fmt.Errorf("profile %s: consolidation threshold must be 0 or at least 2", p.Name)
+	}
+	if p.Batch < 0 {
+		return fmt.Errorf("profile %s: batch size must not be negative", p.Name)
+	}
+	if p.Chain < 0 {
+		return fmt.Errorf("profile %s: chain depth must not be negative", p.Name)
+	}
+	switch p.Recipient {
+	case recipientRandom, recipientSelf, recipientFixed:
+	default:
+		return fmt.Errorf("profile %s: unknown recipient policy %q", p.Name, p.Recipient)
+	}
+	return nil
+}
+
+// recipient returns the address to pay according to the recipient policy
+// given the address picked by the matchmaker
+func (p *Profile) recipient(a *Actor, addr btcutil.Address) btcutil.Address {
+	switch p.Recipient {
+	case recipientSelf:
+		return a.ownedAddresses[a.rand.Int()%len(a.ownedAddresses)]
+	case recipientFixed:
+		if a.counterparty == nil {
+			a.counterparty = addr
+		}
+		return a.counterparty
+	}
+	return addr
+}
+
+// payment splits amt between the recipient and a change address of the
+// actor, paying an amount drawn from the amount distribution if any, or
+// else as per the spend fraction of the profile
+func (p *Profile) payment(a *Actor, to btcutil.Address, amt btcutil.Amount) map[btcutil.Address]btcutil.Amount {
+	var pay btcutil.Amount
+	dist := p.Amounts
+	if dist == nil {
+		dist = a.cfg.defaultAmounts
+	}
+	if dist != nil {
+		pay = dist.sample(a.rand, amt)
+	} else {
+		frac := p.MinSpend + a.rand.Float64()*(p.MaxSpend-p.MinSpend)
+		pay = btcutil.Amount(float64(amt) * frac)
+	}
+	change := amt - pay
+	changeAddr := a.ownedAddresses[a.rand.Int()%len(a.ownedAddresses)]
+	// send everything to the recipient if one of the outputs would be
+	// too small or both outputs would go to the same address
+	if pay < minFee || change < minFee || changeAddr.String() == to.String() {
+		return map[btcutil.Address]btcutil.Amount{to: amt}
+	}
+	return map[btcutil.Address]btcutil.Amount{
+		to:         pay,
+		changeAddr: change,
+	}
+}
+
+// readProfiles reads custom profiles from a CSV with the following fields:
+// name, activity, min spend, max spend, recipient policy and optionally
+// double spend probability, amount distribution, fee policy,
+// consolidation threshold, batch size and chain depth
+func readProfiles(r io.Reader) ([]*Profile, error) {
+	var ps []*Profile
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if len(row) < 5 || len(row) > 11 {
+			return nil, fmt.Errorf("profile %s: expected 5 to 11 fields, got %d", row[0], len(row))
+		}
+		p := &Profile{
+			Name:      row[0],
+			Recipient: row[4],
+		}
+		floats := []*float64{&p.Activity, &p.MinSpend, &p.MaxSpend}
+		for i, f := range floats {
+			if *f, err = strconv.ParseFloat(row[i+1], 64); err != nil {
+				return nil, err
+			}
+		}
+		if len(row) >= 6 && row[5] != "" {
+			if p.DoubleSpend, err = strconv.ParseFloat(row[5], 64); err != nil {
+				return nil, err
+			}
+		}
+		if len(row) >= 7 {
+			if p.Amounts, err = parseAmountDist(row[6]); err != nil {
+				return nil, fmt.Errorf("profile %s: %v", p.Name, err)
+			}
+		}
+		if len(row) >= 8 {
+			if p.Fees, err = parseFeePolicy(row[7]); err != nil {
+				return nil, fmt.Errorf("profile %s: %v", p.Name, err)
+			}
+		}
+		if len(row) >= 9 && row[8] != "" {
+			if p.Consolidate, err = strconv.Atoi(row[8]); err != nil {
+				return nil, err
+			}
+		}
+		if len(row) >= 10 && row[9] != "" {
+			if p.Batch, err = strconv.Atoi(row[9]); err != nil {
+				return nil, err
+			}
+		}
+		if len(row) == 11 && row[10] != "" {
+			if p.Chain, err = strconv.Atoi(row[10]); err != nil {
+				return nil, err
+			}
+		}
+		if err := p.validate(); err != nil {
+			return nil, err
+		}
+		ps = append(ps, p)
+	}
+	return ps, nil
+}
+
+// loadProfiles adds the custom profiles from the given CSV file to the
+// built-in profiles, overriding them in case of a name clash
+func loadProfiles(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	ps, err := readProfiles(file)
+	if err != nil {
+		return err
+	}
+	for _, p := range ps {
+		profiles[p.Name] = p
+	}
+	return nil
+}
+
+// parseAssignments parses a comma separated list of key=value pairs
+func parseAssignments(s string) ([][2]string, error) {
+	var pairs [][2]string
+	if s == "" {
+		return pairs, nil
+	}
+	for _, field := range strings.Split(s, ",") {
+		kv := strings.SplitN(strings.TrimSpace(field), "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid assignment %q, expected key=value", field)
+		}
+		pairs = append(pairs, [2]string{kv[0], kv[1]})
+	}
+	return pairs, nil
+}
+
+// getProfile returns the profile with the given name
+func getProfile(name string) (*Profile, error) {
+	p, ok := profiles[name]
+	if !ok {
+		names := make([]string, 0, len(profiles))
+		for name := range profiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown profile %q, valid profiles are: %s",
+			name, strings.Join(names, ", "))
+	}
+	return p, nil
+}
+
+// assignProfiles returns the profiles of n actors given a mix of profiles
+// by weight, e.g. "spender=60,hoarder=40", and per actor assignments
+// which take precedence over the mix, e.g. "0=faucet,3=exchange"
+func assignProfiles(n int, mix, perActor string) ([]*Profile, error) {
+	assigned := make([]*Profile, n)
+	for i := range assigned {
+		assigned[i] = defaultProfile
+	}
+
+	weights, err := parseAssignments(mix)
+	if err != nil {
+		return nil, err
+	}
+	var total float64
+	ws := make([]float64, len(weights))
+	ps := make([]*Profile, len(weights))
+	for i, w := range weights {
+		if ps[i], err = getProfile(w[0]); err != nil {
+			return nil, err
+		}
+		if ws[i], err = strconv.ParseFloat(w[1], 64); err != nil || ws[i] < 0 {
+			return nil, fmt.Errorf("invalid weight %q for profile %s", w[1], w[0])
+		}
+		total += ws[i]
+	}
+	if total > 0 {
+		// give each profile its share of actors, rounding the
+		// cumulative share so that all actors are assigned
+		var cumulative float64
+		start := 0
+		for i, p := range ps {
+			cumulative += ws[i]
+			end := int(cumulative/total*float64(n) + 0.5)
+			for j := start; j < end; j++ {
+				assigned[j] = p
+			}
+			start = end
+		}
+	}
+
+	overrides, err := parseAssignments(perActor)
+	if err != nil {
+		return nil, err
+	}
+	for _, o := range overrides {
+		i, err := strconv.Atoi(o[0])
+		if err != nil || i < 0 || i >= n {
+			return nil, fmt.Errorf("invalid actor index %q", o[0])
+		}
+		if assigned[i], err = getProfile(o[1]); err != nil {
+			return nil, err
+		}
+	}
+	return assigned, nil
+}
@@ -0,0 +1,105 @@
+package btcsim
+
+import (
+	"strings"
+	"testing"
+)
+
+var fakeProfiles = `
+whale,0.5,0.5,1,fixed
+crook,1,1,1,random,0.5
+`
+
+var fakeInvalidProfiles = `
+whale,2,0.5,1,fixed
+`
+
+func TestReadProfiles(t *testing.T) {
+	ps, err := readProfiles(strings.NewReader(fakeProfiles))
+	if err != nil {
+		t.Fatalf("readProfiles error: %v", err)
+	}
+	expected := Profile{
+		Name:      "whale",
+		Activity:  0.5,
+		MinSpend:  0.5,
+		MaxSpend:  1,
+		Recipient: recipientFixed,
+	}
+	if len(ps) != 2 || *ps[0] != expected {
+		t.Fatalf("readProfiles got: %v want: %v", ps, expected)
+	}
+	if ps[1].DoubleSpend != 0.5 {
+		t.Errorf("readProfiles got double spend %v want 0.5", ps[1].DoubleSpend)
+	}
+}
+
+func TestReadProfilesErrors(t *testing.T) {
+	if _, err := readProfiles(strings.NewReader(fakeInvalidProfiles)); err == nil {
+		t.Errorf("readProfiles expected error, got %v", err)
+	}
+	if _, err := readProfiles(strings.NewReader("whale,1,1,1")); err == nil {
+		t.Errorf("readProfiles expected error, got %v", err)
+	}
+	if _, err := readProfiles(strings.NewReader("crook,1,1,1,random,2")); err == nil {
+		t.Errorf("readProfiles expected error, got %v", err)
+	}
+}
+
+func TestAssignProfiles(t *testing.T) {
+	assigned, err := assignProfiles(10, "spender=60,hoarder=30,faucet=10", "9=exchange")
+	if err != nil {
+		t.Fatalf("assignProfiles error: %v", err)
+	}
+	count := make(map[string]int)
+	for _, p := range assigned {
+		count[p.Name]++
+	}
+	expected := map[string]int{
+		"spender":  6,
+		"hoarder":  3,
+		"exchange": 1,
+	}
+	for name, n := range expected {
+		if count[name] != n {
+			t.Errorf("assignProfiles got %d %s actors, want %d", count[name], name, n)
+		}
+	}
+
+	// without a mix every actor uses the default profile
+	assigned, err = assignProfiles(3, "", "")
+	if err != nil {
+		t.Fatalf("assignProfiles error: %v", err)
+	}
+	for i, p := range assigned {
+		if p != defaultProfile {
+			t.Errorf("actor %d got profile %s, want %s", i, p.Name, defaultProfile.Name)
+		}
+	}
+}
+
+func TestAssignProfilesErrors(t *testing.T) {
+	tests := []struct {
+		mix      string
+		perActor string
+	}{
+		{"gambler=100", ""},
+		{"spender", ""},
+		{"spender=-1", ""},
+		{"", "10=spender"},
+		{"", "x=spender"},
+	}
+	for _, test := range tests {
+		if _, err := assignProfiles(10, test.mix, test.perActor); err == nil {
+			t.Errorf("assignProfiles(%q, %q) expected error", test.mix, test.perActor)
+		}
+	}
+}
+
+func TestBuiltinProfiles(t *testing.T) {
+	for name, p := range profiles {
+		if err := p.validate(); err != nil {
+			t.Errorf("profile %s is invalid: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"encoding/csv"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/wire"
+	rpc "github.com/btcsuite/btcrpcclient"
+)
+
+// blockSeen holds the time every node first connected a block
+type blockSeen struct {
+	hash   wire.ShaHash
+	height int32
+	first  time.Time
+
+	// seen are the first seen times by node, zero if the node never
+	// connected the block
+	seen []time.Time
+}
+
+// propagation records when every node connects each block to measure how
+// long blocks take to propagate across nodes
+type propagation struct {
+	mtx    sync.Mutex
+	start  int32
+	names  []string
+	blocks []*blockSeen
+	byHash map[wire.ShaHash]*blockSeen
+
+	// disconnected is the number of blocks disconnected by reorgs on
+	// any node
+	disconnected int
+}
+
+// newPropagation returns a propagation recorder for n nodes of the blocks
+// from the start height on
+func newPropagation(n int, start int32) *propagation {
+	return &propagation{
+		start:  start,
+		names:  make([]string, n),
+		byHash: make(map[wire.ShaHash]*blockSeen),
+	}
+}
+
+// handlers returns the notification handlers of the i-th node, named
+// name, which record the blocks it connects and disconnects in addition
+// to calling the given handlers if any. A nil propagation returns the
+// given handlers.
+func (p *propagation) handlers(i int, name string, h *rpc.NotificationHandlers) *rpc.NotificationHandlers {
+	if p == nil {
+		return h
+	}
+	p.names[i] = name
+	wrapped := &rpc.NotificationHandlers{}
+	if h != nil {
+		*wrapped = *h
+	}
+	onConnected := wrapped.OnBlockConnected
+	wrapped.OnBlockConnected = func(hash *wire.ShaHash, height int32) {
+		p.connected(i, *hash, height, time.Now())
+		if onConnected != nil {
+			onConnected(hash, height)
+		}
+	}
+	onDisconnected := wrapped.OnBlockDisconnected
+	wrapped.OnBlockDisconnected = func(hash *wire.ShaHash, height int32) {
+		p.mtx.Lock()
+		p.disconnected++
+		p.mtx.Unlock()
+		if onDisconnected != nil {
+			onDisconnected(hash, height)
+		}
+	}
+	return wrapped
+}
+
+// connected records that the i-th node connected a block at the given
+// time. Blocks below the start height, mined before the simulation starts,
+// are ignored.
+func (p *propagation) connected(i int, hash wire.ShaHash, height int32, t time.Time) {
+	if height < p.start {
+		return
+	}
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	b, ok := p.byHash[hash]
+	if !ok {
+		b = &blockSeen{
+			hash:   hash,
+			height: height,
+			first:  t,
+			seen:   make([]time.Time, len(p.names)),
+		}
+		p.byHash[hash] = b
+		p.blocks = append(p.blocks, b)
+	}
+	if b.seen[i].IsZero() {
+		b.seen[i] = t
+	}
+}
+
+// PropagationStats are the block propagation delays across nodes, that
+// is the time between the first node connecting a block and the others
+type PropagationStats struct {
+	Nodes      int `json:"nodes"`
+	Blocks     int `json:"blocks"`
+	Propagated int `json:"propagated"`
+
+	// delays until the last node connected the blocks seen by all
+	MeanDelay   time.Duration `json:"meandelay"`
+	MedianDelay time.Duration `json:"mediandelay"`
+	P95Delay    time.Duration `json:"p95delay"`
+	MaxDelay    time.Duration `json:"maxdelay"`
+
+	// NodeDelays are the mean delays of every node to connect a block
+	NodeDelays map[string]time.Duration `json:"nodedelays"`
+
+	// Stale is the number of blocks competing with another one at the
+	// same height and Disconnected the number of blocks disconnected by
+	// reorgs on any node
+	Stale        int `json:"stale"`
+	Disconnected int `json:"disconnected"`
+}
+
+// Stats returns the propagation statistics of the blocks recorded so far
+func (p *propagation) Stats() *PropagationStats {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	s := &PropagationStats{
+		Nodes:        len(p.names),
+		Blocks:       len(p.blocks),
+		NodeDelays:   make(map[string]time.Duration),
+		Disconnected: p.disconnected,
+	}
+	var delays []time.Duration
+	var total time.Duration
+	nodeTotals := make([]time.Duration, len(p.names))
+	nodeBlocks := make([]int, len(p.names))
+	heights := make(map[int32]int)
+	for _, b := range p.blocks {
+		heights[b.height]++
+		var last time.Duration
+		all := true
+		for i, t := range b.seen {
+			if t.IsZero() {
+				all = false
+				continue
+			}
+			d := t.Sub(b.first)
+			nodeTotals[i] += d
+			nodeBlocks[i]++
+			if d > last {
+				last = d
+			}
+		}
+		if all {
+			delays = append(delays, last)
+			total += last
+		}
+	}
+	for _, n := range heights {
+		s.Stale += n - 1
+	}
+	for i, name := range p.names {
+		if nodeBlocks[i] > 0 {
+			s.NodeDelays[name] = nodeTotals[i] / time.Duration(nodeBlocks[i])
+		}
+	}
+	s.Propagated = len(delays)
+	if s.Propagated > 0 {
+		sort.Sort(durations(delays))
+		s.MeanDelay = total / time.Duration(s.Propagated)
+		s.MedianDelay = percentile(delays, 50)
+		s.P95Delay = percentile(delays, 95)
+		s.MaxDelay = delays[len(delays)-1]
+	}
+	return s
+}
+
+// writePropagationStatsCSV writes the delay of every node to connect
+// every block, in seconds, as CSV with a header row. The delay is empty
+// if the node never connected the block.
+func (p *propagation) writePropagationStatsCSV(w io.Writer) error {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	header := append([]string{"height", "hash", "firstseen"}, p.names...)
+	writer := csv.NewWriter(w)
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+	for _, b := range p.blocks {
+		row := []string{
+			strconv.Itoa(int(b.height)),
+			b.hash.String(),
+			b.first.Format(time.RFC3339Nano),
+		}
+		for _, t := range b.seen {
+			var delay string
+			if !t.IsZero() {
+				delay = strconv.FormatFloat(t.Sub(b.first).Seconds(), 'f', -1, 64)
+			}
+			row = append(row, delay)
+		}
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// writePropagationStats writes the propagation delays of every block to
+// the given path as CSV
+func (p *propagation) writePropagationStats(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := p.writePropagationStatsCSV(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,71 @@
+package btcsim
+
+import (
+	"bytes"
+	"encoding/csv"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/wire"
+	rpc "github.com/btcsuite/btcrpcclient"
+)
+
+func TestPropagationHandlers(t *testing.T) {
+	var called bool
+	h := &rpc.NotificationHandlers{
+		OnBlockConnected: func(hash *wire.ShaHash, height int32) {
+			called = true
+		},
+	}
+	var p *propagation
+	if p.handlers(0, "node", h) != h {
+		t.Errorf("handlers of a nil propagation got new handlers")
+	}
+
+	p = newPropagation(2, 100)
+	p.handlers(0, "node", h).OnBlockConnected(&wire.ShaHash{1}, 100)
+	p.handlers(1, "node-1", nil).OnBlockDisconnected(&wire.ShaHash{1}, 100)
+	if !called {
+		t.Errorf("handlers did not call the wrapped handler")
+	}
+	if s := p.Stats(); s.Blocks != 1 || s.Disconnected != 1 {
+		t.Errorf("got %d blocks, %d disconnected, want 1, 1", s.Blocks, s.Disconnected)
+	}
+}
+
+func TestPropagationStats(t *testing.T) {
+	h := int32(100)
+	p := newPropagation(3, h)
+	p.names = []string{"node", "node-1", "node-2"}
+	first := time.Now()
+	p.connected(0, wire.ShaHash{1}, h-1, first)
+	p.connected(0, wire.ShaHash{2}, h, first)
+	p.connected(1, wire.ShaHash{2}, h, first.Add(time.Second))
+	p.connected(2, wire.ShaHash{2}, h, first.Add(3*time.Second))
+	p.connected(1, wire.ShaHash{2}, h, first.Add(4*time.Second))
+	p.connected(1, wire.ShaHash{3}, h, first.Add(time.Second))
+
+	s := p.Stats()
+	if s.Blocks != 2 || s.Propagated != 1 || s.Stale != 1 {
+		t.Errorf("got %d blocks, %d propagated, %d stale, want 2, 1, 1",
+			s.Blocks, s.Propagated, s.Stale)
+	}
+	if s.MaxDelay != 3*time.Second || s.MeanDelay != 3*time.Second {
+		t.Errorf("got mean delay %v, max %v, want 3s", s.MeanDelay, s.MaxDelay)
+	}
+	if d := s.NodeDelays["node-1"]; d != 500*time.Millisecond {
+		t.Errorf("got mean delay of node-1 %v want 500ms", d)
+	}
+
+	var buf bytes.Buffer
+	if err := p.writePropagationStatsCSV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 || rows[0][4] != "node-1" || rows[1][5] != "3" || rows[2][3] != "" {
+		t.Errorf("writePropagationStatsCSV got %v", rows)
+	}
+}
@@ -0,0 +1,234 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// proxyBufferSize is the size of the chunks read from a proxied connection
+const proxyBufferSize = 32 * 1024
+
+// proxyQueueSize is the number of chunks in flight on each direction of
+// a proxied connection
+const proxyQueueSize = 1024
+
+// linkShape describes the conditions of a link between two nodes
+type linkShape struct {
+	// latency is the delay added to every chunk of data and jitter the
+	// maximum random delay added on top of it
+	latency time.Duration
+	jitter  time.Duration
+
+	// bandwidth is the maximum number of bytes per second sent in each
+	// direction, zero means unlimited
+	bandwidth int
+}
+
+// enabled reports whether the shape alters the link at all
+func (s *linkShape) enabled() bool {
+	return s.latency > 0 || s.jitter > 0 || s.bandwidth > 0
+}
+
+// proxy forwards the connections it accepts to a node, shaping the
+// traffic in both directions. A proxy can be cut to drop every connection
+// and refuse new ones until it is restored.
+type proxy struct {
+	listener net.Listener
+	target   string
+	shape    linkShape
+	rand     *rand.Rand
+	quit     chan struct{}
+	wg       sync.WaitGroup
+
+	mtx   sync.Mutex
+	conns map[net.Conn]struct{}
+	cut   bool
+}
+
+// newProxy starts a proxy to the target address listening on a random
+// local port
+func newProxy(target string, shape linkShape, r *rand.Rand) (*proxy, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	p := &proxy{
+		listener: l,
+		target:   target,
+		shape:    shape,
+		rand:     r,
+		quit:     make(chan struct{}),
+		conns:    make(map[net.Conn]struct{}),
+	}
+	p.wg.Add(1)
+	go p.accept()
+	return p, nil
+}
+
+// Addr returns the address the proxy listens on
+func (p *proxy) Addr() string {
+	return p.listener.Addr().String()
+}
+
+// Close stops the proxy and closes every proxied connection
+func (p *proxy) Close() {
+	close(p.quit)
+	p.listener.Close()
+	p.mtx.Lock()
+	for conn := range p.conns {
+		conn.Close()
+	}
+	p.mtx.Unlock()
+	p.wg.Wait()
+}
+
+// SetCut cuts the proxy, closing every connection and refusing new ones,
+// or restores it
+func (p *proxy) SetCut(cut bool) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.cut = cut
+	if cut {
+		for conn := range p.conns {
+			conn.Close()
+		}
+	}
+}
+
+// track adds a connection to close with the proxy, it returns false if
+// the proxy is closed or cut
+func (p *proxy) track(conn net.Conn) bool {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	select {
+	case <-p.quit:
+		return false
+	default:
+	}
+	if p.cut {
+		return false
+	}
+	p.conns[conn] = struct{}{}
+	return true
+}
+
+// untrack closes a connection and stops tracking it
+func (p *proxy) untrack(conn net.Conn) {
+	p.mtx.Lock()
+	delete(p.conns, conn)
+	p.mtx.Unlock()
+	conn.Close()
+}
+
+// accept runs as a goroutine and proxies connections until the proxy
+// is closed
+func (p *proxy) accept() {
+	defer p.wg.Done()
+
+	for {
+		conn, err := p.listener.Accept()
+		if err != nil {
+			select {
+			case <-p.quit:
+			default:
+				log.Warnf("Proxy to %s: Cannot accept connection: %v", p.target, err)
+			}
+			return
+		}
+		target, err := net.Dial("tcp", p.target)
+		if err != nil {
+			log.Warnf("Proxy to %s: Cannot connect: %v", p.target, err)
+			conn.Close()
+			continue
+		}
+		if !p.track(conn) {
+			conn.Close()
+			target.Close()
+			continue
+		}
+		if !p.track(target) {
+			p.untrack(conn)
+			target.Close()
+			continue
+		}
+		p.wg.Add(2)
+		go p.pipe(target, conn)
+		go p.pipe(conn, target)
+	}
+}
+
+// chunk is a piece of data read from a connection and the time it must
+// be written to the other end
+type chunk struct {
+	data []byte
+	at   time.Time
+}
+
+// pipe runs as a goroutine and copies the data read from src to dst,
+// delaying and rate limiting it according to the shape of the proxy
+func (p *proxy) pipe(dst, src net.Conn) {
+	defer p.wg.Done()
+
+	chunks := make(chan chunk, proxyQueueSize)
+	go func() {
+		defer close(chunks)
+		// chunks are never delivered earlier than the previous one so
+		// that jitter does not reorder the stream
+		var last time.Time
+		for {
+			buf := make([]byte, proxyBufferSize)
+			n, err := src.Read(buf)
+			if n > 0 {
+				at := time.Now().Add(p.delay())
+				if at.Before(last) {
+					at = last
+				}
+				last = at
+				chunks <- chunk{data: buf[:n], at: at}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	// free is the time the link has finished transmitting the previous
+	// chunk when the bandwidth is limited
+	var free time.Time
+	for c := range chunks {
+		at := c.at
+		if p.shape.bandwidth > 0 {
+			if at.Before(free) {
+				at = free
+			}
+			at = at.Add(time.Duration(len(c.data)) * time.Second /
+				time.Duration(p.shape.bandwidth))
+			free = at
+		}
+		time.Sleep(at.Sub(time.Now()))
+		if _, err := dst.Write(c.data); err != nil {
+			break
+		}
+	}
+	// closing both ends stops the other direction as well
+	p.untrack(dst)
+	p.untrack(src)
+	// drain the reader so it does not block
+	for range chunks {
+	}
+}
+
+// delay returns the delay of the next chunk
+func (p *proxy) delay() time.Duration {
+	d := p.shape.latency
+	if p.shape.jitter > 0 {
+		d += time.Duration(p.rand.Int63n(int64(p.shape.jitter)))
+	}
+	return d
+}
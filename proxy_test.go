@@ -0,0 +1,75 @@
+package btcsim
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"net"
+	"testing"
+	"time"
+)
+
+// echoServer accepts connections and echoes back what it receives
+func echoServer(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen error: %v", err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+	return l
+}
+
+func TestProxy(t *testing.T) {
+	l := echoServer(t)
+	defer l.Close()
+
+	tests := []struct {
+		shape linkShape
+		size  int
+		min   time.Duration
+	}{
+		// a round trip crosses the link twice
+		{linkShape{latency: 50 * time.Millisecond}, 10, 100 * time.Millisecond},
+		{linkShape{latency: 10 * time.Millisecond, jitter: 10 * time.Millisecond}, 1e5, 20 * time.Millisecond},
+		{linkShape{bandwidth: 1e5}, 2e4, 200 * time.Millisecond},
+	}
+	for i, test := range tests {
+		p, err := newProxy(l.Addr().String(), test.shape, rand.New(rand.NewSource(1)))
+		if err != nil {
+			t.Fatalf("#%d: newProxy error: %v", i, err)
+		}
+		conn, err := net.Dial("tcp", p.Addr())
+		if err != nil {
+			t.Fatalf("#%d: Dial error: %v", i, err)
+		}
+
+		data := make([]byte, test.size)
+		rand.Read(data)
+		start := time.Now()
+		go conn.Write(data)
+		got := make([]byte, len(data))
+		if _, err := io.ReadFull(conn, got); err != nil {
+			t.Fatalf("#%d: Read error: %v", i, err)
+		}
+		elapsed := time.Since(start)
+		if !bytes.Equal(got, data) {
+			t.Errorf("#%d: data altered by the proxy", i)
+		}
+		if elapsed < test.min {
+			t.Errorf("#%d: round trip took %v, want at least %v", i, elapsed, test.min)
+		}
+		conn.Close()
+		p.Close()
+	}
+}
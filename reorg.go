@@ -0,0 +1,144 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"errors"
+	"time"
+
+	"github.com/btcsuite/btcutil"
+)
+
+const (
+	// forkSyncTimeout is the maximum time to wait for the fork miner to
+	// sync with the chain of the simulation or the other way around
+	forkSyncTimeout = time.Minute
+
+	// forkPollInterval is the interval at which chain heights are compared
+	// while syncing
+	forkPollInterval = 100 * time.Millisecond
+)
+
+// forkMiner is a btcd node following the chain of the simulation through
+// a proxy, which can be cut to mine a competing chain in isolation and
+// force a reorg once it is restored
+type forkMiner struct {
+	*Node
+	link *proxy
+}
+
+// newForkMiner starts a fork miner with the given prefix, preferably
+// listening on the given ports, connected to the given node and mining to
+// the given addresses, in the run configured by cfg
+func newForkMiner(cfg *Config, prefix string, listen, rpcListen int, node ChainServer,
+	miningAddrs []btcutil.Address) (*forkMiner, error) {
+
+	log.Infof("Starting %s on %s...", prefix, cfg.netParams().Name)
+	args, err := newBtcdArgs(cfg, prefix)
+	if err != nil {
+		return nil, err
+	}
+	if args.Listen, err = cfg.localAddr(listen); err != nil {
+		args.Cleanup()
+		return nil, err
+	}
+	if args.RPCListen, err = cfg.localAddr(rpcListen); err != nil {
+		args.Cleanup()
+		return nil, err
+	}
+	args.Extra = blockTemplateArgs(cfg)
+	for _, addr := range miningAddrs {
+		if addr != nil {
+			args.Extra = append(args.Extra, "--miningaddr="+addr.EncodeAddress())
+		}
+	}
+
+	link, err := newProxy(node.ListenAddr(), linkShape{}, nil)
+	if err != nil {
+		args.Cleanup()
+		return nil, err
+	}
+	args.AddPeer(link.Addr())
+
+	logFile, err := cfg.getLogFile(args.prefix)
+	if err != nil {
+		log.Warnf("Cannot get log file, logging disabled: %v", err)
+	}
+	n, err := NewNodeFromArgs(cfg, args, nil, logFile)
+	if err != nil {
+		link.Close()
+		return nil, err
+	}
+	f := &forkMiner{Node: n, link: link}
+	if err := n.Start(); err != nil {
+		log.Errorf("%s: Cannot start fork miner: %v", f, err)
+		f.Shutdown()
+		return nil, err
+	}
+	if err := n.Connect(); err != nil {
+		log.Errorf("%s: Cannot connect to fork miner: %v", f, err)
+		f.Shutdown()
+		return nil, err
+	}
+	return f, nil
+}
+
+// waitSync waits until the best block of both clients is the same
+func waitSync(a, b *rpcClient, exit <-chan struct{}) error {
+	timeout := time.After(forkSyncTimeout)
+	for {
+		hashA, err := a.GetBestBlockHash()
+		if err != nil {
+			return err
+		}
+		hashB, err := b.GetBestBlockHash()
+		if err != nil {
+			return err
+		}
+		if hashA.IsEqual(hashB) {
+			return nil
+		}
+		select {
+		case <-time.After(forkPollInterval):
+		case <-timeout:
+			return errors.New("timeout waiting for the chains to sync")
+		case <-exit:
+			return errors.New("simulation is shutting down")
+		}
+	}
+}
+
+// Reorg forces a reorg of the given depth of the chain of node. The fork
+// miner is isolated once synced with node, the miner then extends the
+// chain by depth blocks while the fork miner mines depth+1 blocks, which
+// replace them once it reconnects.
+func (f *forkMiner) Reorg(miner *Miner, node *rpcClient, depth uint32, exit <-chan struct{}) error {
+	if err := waitSync(f.client, node, exit); err != nil {
+		return err
+	}
+	f.link.SetCut(true)
+	defer f.link.SetCut(false)
+
+	if err := miner.Generate(depth); err != nil {
+		return err
+	}
+	if _, err := f.client.Generate(depth + 1); err != nil {
+		log.Errorf("%s: Cannot generate %d block(s): %v", f, depth+1, err)
+		return err
+	}
+
+	f.link.SetCut(false)
+	if err := waitSync(f.client, node, exit); err != nil {
+		return err
+	}
+	log.Infof("%s: Forced a reorg of %d block(s)", f, depth)
+	return nil
+}
+
+// Shutdown stops the fork miner and its link to the simulation
+func (f *forkMiner) Shutdown() {
+	f.Node.Shutdown()
+	f.link.Close()
+}
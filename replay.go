@@ -0,0 +1,427 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+// recordingHeader is the first line of a recording, the seed of the random
+// streams of the recorded run and the flags it was started with, which
+// together reproduce its random decisions
+type recordingHeader struct {
+	Seed int64    `json:"seed"`
+	Args []string `json:"args"`
+}
+
+// recordedCall is the result of an rpc call to a node with its params, as
+// it was returned by the node. Params and results which do not encode to
+// JSON as they are, such as addresses, transactions and blocks, are
+// recorded in their string or serialized hex form.
+type recordedCall struct {
+	Node   string          `json:"node"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// replayKey identifies the recorded calls which answer a call: the calls
+// of the same method with the same params to the same node
+type replayKey struct {
+	node, method, params string
+}
+
+// replayPassThrough are the methods which change the state of the nodes or
+// wallets, which always go to the node when replaying so that blocks are
+// mined, transactions broadcast and wallets set up as in the recorded run,
+// and the notifications which follow are sent by the nodes
+var replayPassThrough = map[string]bool{
+	"addmultisigaddress":    true,
+	"addnode":               true,
+	"createencryptedwallet": true,
+	"generate":              true,
+	"getnewaddress":         true,
+	"importprivkey":         true,
+	"sendrawtransaction":    true,
+	"sendtoaddress":         true,
+	"setgenerate":           true,
+	"submitblock":           true,
+	"walletpassphrase":      true,
+}
+
+// recordedParams returns the params of a call in the form they are sent to
+// the node, encoded to JSON
+func recordedParams(params []interface{}) (json.RawMessage, error) {
+	if len(params) == 0 {
+		return nil, nil
+	}
+	sent := make([]interface{}, len(params))
+	for i, p := range params {
+		sent[i] = traceParam(p)
+	}
+	return json.Marshal(sent)
+}
+
+// rpcRecording is a recording of the rpc results of a run, one JSON
+// recordedCall per line after the header. It either writes the calls of
+// the current run or hands out those of a recorded one.
+type rpcRecording struct {
+	mtx sync.Mutex
+
+	// enc writes the calls of the current run to file
+	enc  *json.Encoder
+	file io.Closer
+
+	// calls are the recorded calls not replayed yet by node, method and
+	// params, replayed the number of calls replayed and diverged the
+	// number of calls which found no recorded call left and went to the
+	// node
+	calls    map[replayKey][]*recordedCall
+	replayed int
+	diverged int
+}
+
+// recordedFlags are the flags of a run which are not recorded with the
+// others, the seed being recorded apart
+var recordedFlags = map[string]bool{
+	"record": true,
+	"replay": true,
+	"seed":   true,
+}
+
+// recordedArgs returns the flags of the run configured by c, except those
+// recording or replaying a run and the seed which is recorded apart
+func (c *Config) recordedArgs() []string {
+	return c.args(recordedFlags)
+}
+
+// newRecording returns a recording writing the header and then every call
+// to w, which is closed with the recording if it is an io.Closer
+func newRecording(w io.Writer, header *recordingHeader) (*rpcRecording, error) {
+	r := &rpcRecording{enc: json.NewEncoder(w)}
+	if c, ok := w.(io.Closer); ok {
+		r.file = c
+	}
+	if err := r.enc.Encode(header); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// createRecording creates the file at path to record the run to
+func createRecording(path string, header *recordingHeader) (*rpcRecording, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := newRecording(file, header)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// readRecording reads a recording to replay
+func readRecording(rd io.Reader) (*recordingHeader, *rpcRecording, error) {
+	scanner := bufio.NewScanner(rd)
+	// blocks are recorded in hex on a single line
+	scanner.Buffer(nil, 2*wire.MaxBlockPayload+1024)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return nil, nil, err
+		}
+		return nil, nil, errors.New("empty recording")
+	}
+	var header recordingHeader
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil {
+		return nil, nil, fmt.Errorf("invalid recording header: %v", err)
+	}
+	r := &rpcRecording{calls: make(map[replayKey][]*recordedCall)}
+	for line := 2; scanner.Scan(); line++ {
+		c := new(recordedCall)
+		if err := json.Unmarshal(scanner.Bytes(), c); err != nil {
+			return nil, nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		key := replayKey{c.Node, c.Method, string(c.Params)}
+		r.calls[key] = append(r.calls[key], c)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	return &header, r, nil
+}
+
+// loadRecording reads the recording at path to replay
+func loadRecording(path string) (*recordingHeader, *rpcRecording, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+	return readRecording(file)
+}
+
+// applyRecording loads the recording to replay, sets the seed and flags of
+// the recorded run with fs, the flags of cfg, and then the flags of the
+// command line again so that they override the recorded ones
+func applyRecording(cfg *Config, fs *flag.FlagSet, args []string) error {
+	if cfg.RecordPath != "" {
+		return errors.New("a run cannot be recorded while replaying another")
+	}
+	header, r, err := loadRecording(cfg.ReplayPath)
+	if err != nil {
+		return fmt.Errorf("cannot load recording: %v", err)
+	}
+	if err := fs.Parse(append(header.Args, args...)); err != nil {
+		return err
+	}
+	cfg.Seed = header.Seed
+	cfg.recording = r
+	log.Infof("Replaying %s", cfg.ReplayPath)
+	return nil
+}
+
+// record records the result of a call of the method with the given params
+// to the node. It does nothing if r is nil or replays a run.
+func (r *rpcRecording) record(node, method string, params []interface{}, result interface{}, err error) {
+	if r == nil || r.replaying() {
+		return
+	}
+	c := &recordedCall{Node: node, Method: method}
+	var merr error
+	if c.Params, merr = recordedParams(params); merr != nil {
+		log.Errorf("Cannot record the params of %s to %s: %v", method, node, merr)
+		return
+	}
+	if err != nil {
+		c.Error = err.Error()
+	} else if result != nil {
+		if c.Result, merr = json.Marshal(result); merr != nil {
+			log.Errorf("Cannot record the result of %s to %s: %v", method, node, merr)
+			return
+		}
+	}
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if r.enc == nil {
+		return
+	}
+	if err := r.enc.Encode(c); err != nil {
+		log.Errorf("Cannot record the rpc calls: %v", err)
+		r.enc = nil
+	}
+}
+
+// replay decodes the next recorded result of a call of the method with the
+// same params to the node into result and returns the recorded error. Calls
+// with the same params are answered in the order they were recorded. ok is
+// false if r is nil, the method changes the state of the node or no
+// recorded call is left, in which case the call goes to the node.
+func (r *rpcRecording) replay(node, method string, params []interface{}, result interface{}) (ok bool, err error) {
+	if !r.replaying() || replayPassThrough[method] {
+		return false, nil
+	}
+	p, err := recordedParams(params)
+	if err != nil {
+		return false, nil
+	}
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	key := replayKey{node, method, string(p)}
+	calls := r.calls[key]
+	if len(calls) == 0 {
+		if r.diverged == 0 {
+			log.Warnf("Replay: Diverged at %s to %s, which has no recorded call left", method, node)
+		}
+		r.diverged++
+		return false, nil
+	}
+	c := calls[0]
+	r.calls[key] = calls[1:]
+	r.replayed++
+	log.Tracef("Replay: %s to %s", method, node)
+	if c.Error != "" {
+		return true, errors.New(c.Error)
+	}
+	if result != nil && len(c.Result) > 0 {
+		if err := json.Unmarshal(c.Result, result); err != nil {
+			return true, fmt.Errorf("cannot replay %s to %s: %v", method, node, err)
+		}
+	}
+	return true, nil
+}
+
+// replaying returns whether r replays a recorded run
+func (r *rpcRecording) replaying() bool {
+	return r != nil && r.calls != nil
+}
+
+// Counts returns the number of calls replayed and of those which went to
+// the nodes as the recording had none left for them
+func (r *rpcRecording) Counts() (replayed, diverged int) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return r.replayed, r.diverged
+}
+
+// Close stops recording and closes the file of the recording
+func (r *rpcRecording) Close() error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.enc = nil
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
+
+// The recorded forms of the results which do not encode to JSON as they
+// are, and their decoding when replayed. Decoding takes the recorded error
+// along so that replaying wrappers can return it directly.
+
+// recordedAddress returns the encoded address, empty if nil
+func recordedAddress(addr btcutil.Address) string {
+	if addr == nil {
+		return ""
+	}
+	return addr.EncodeAddress()
+}
+
+// replayedAddress decodes a recorded address
+func replayedAddress(s string, err error) (btcutil.Address, error) {
+	if err != nil {
+		return nil, err
+	}
+	return btcutil.DecodeAddress(s, &chaincfg.SimNetParams)
+}
+
+// recordedHashes returns the hashes as strings
+func recordedHashes(hashes []*wire.ShaHash) []string {
+	s := make([]string, len(hashes))
+	for i, hash := range hashes {
+		s[i] = hash.String()
+	}
+	return s
+}
+
+// replayedHashes decodes recorded hashes
+func replayedHashes(s []string, err error) ([]*wire.ShaHash, error) {
+	if err != nil {
+		return nil, err
+	}
+	hashes := make([]*wire.ShaHash, len(s))
+	for i := range s {
+		if hashes[i], err = wire.NewShaHashFromStr(s[i]); err != nil {
+			return nil, err
+		}
+	}
+	return hashes, nil
+}
+
+// recordedHash returns the hash as a string, empty if nil
+func recordedHash(hash *wire.ShaHash) string {
+	if hash == nil {
+		return ""
+	}
+	return hash.String()
+}
+
+// replayedHash decodes a recorded hash
+func replayedHash(s string, err error) (*wire.ShaHash, error) {
+	if err != nil {
+		return nil, err
+	}
+	return wire.NewShaHashFromStr(s)
+}
+
+// recordedTx returns the serialized transaction in hex, empty if nil
+func recordedTx(tx *wire.MsgTx) string {
+	if tx == nil {
+		return ""
+	}
+	var b bytes.Buffer
+	tx.Serialize(&b)
+	return hex.EncodeToString(b.Bytes())
+}
+
+// replayedTx decodes a recorded transaction
+func replayedTx(s string, err error) (*wire.MsgTx, error) {
+	if err != nil {
+		return nil, err
+	}
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	tx := new(wire.MsgTx)
+	if err := tx.Deserialize(bytes.NewReader(b)); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// signedTx is the recorded result of signrawtransaction
+type signedTx struct {
+	Tx       string `json:"tx"`
+	Complete bool   `json:"complete"`
+}
+
+// recordedBlock returns the serialized block in hex, empty if nil
+func recordedBlock(block *btcutil.Block) string {
+	if block == nil {
+		return ""
+	}
+	b, err := block.Bytes()
+	if err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// replayedBlock decodes a recorded block
+func replayedBlock(s string, err error) (*btcutil.Block, error) {
+	if err != nil {
+		return nil, err
+	}
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return btcutil.NewBlockFromBytes(b)
+}
+
+// recordedWIF returns the private key in wallet import format, empty if
+// nil
+func recordedWIF(wif *btcutil.WIF) string {
+	if wif == nil {
+		return ""
+	}
+	return wif.String()
+}
+
+// replayedWIF decodes a recorded private key
+func replayedWIF(s string, err error) (*btcutil.WIF, error) {
+	if err != nil {
+		return nil, err
+	}
+	return btcutil.DecodeWIF(s)
+}
@@ -0,0 +1,98 @@
+package btcsim
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcutil"
+)
+
+func TestRecording(t *testing.T) {
+	var b bytes.Buffer
+	header := &recordingHeader{Seed: 42, Args: []string{"-actors=2"}}
+	r, err := newRecording(&b, header)
+	if err != nil {
+		t.Fatalf("newRecording error: %v", err)
+	}
+	r.record("node", "getblockcount", nil, int64(5), nil)
+	r.record("actor", "getbalance", []interface{}{"", 1}, btcutil.Amount(1e8), nil)
+	r.record("actor", "getbalance", []interface{}{"", 6}, btcutil.Amount(2e8), nil)
+	r.record("node", "getblockcount", nil, nil, errors.New("connection refused"))
+	r.record("node", "getblockcount", nil, int64(7), nil)
+	r.record("node", "generate", []interface{}{1}, []string{}, nil)
+	if err := r.Close(); err != nil {
+		t.Fatalf("Close error: %v", err)
+	}
+	r.record("node", "getblockcount", nil, int64(8), nil)
+
+	got, replay, err := readRecording(&b)
+	if err != nil {
+		t.Fatalf("readRecording error: %v", err)
+	}
+	if got.Seed != 42 || len(got.Args) != 1 || got.Args[0] != "-actors=2" {
+		t.Errorf("got header %+v", got)
+	}
+
+	// calls are replayed in order by node, method and params, whatever
+	// the order of the other calls
+	var balance btcutil.Amount
+	if ok, err := replay.replay("actor", "getbalance", []interface{}{"", 6}, &balance); !ok || err != nil || balance != 2e8 {
+		t.Errorf("getbalance: got %v %v %v", balance, ok, err)
+	}
+	if ok, err := replay.replay("actor", "getbalance", []interface{}{"", 1}, &balance); !ok || err != nil || balance != 1e8 {
+		t.Errorf("getbalance: got %v %v %v", balance, ok, err)
+	}
+	var height int64
+	if ok, err := replay.replay("node", "getblockcount", nil, &height); !ok || err != nil || height != 5 {
+		t.Errorf("getblockcount: got %v %v %v", height, ok, err)
+	}
+	if ok, err := replay.replay("node", "getblockcount", nil, &height); !ok || err == nil ||
+		!strings.Contains(err.Error(), "connection refused") {
+		t.Errorf("getblockcount: got %v %v, want the recorded error", ok, err)
+	}
+	if ok, err := replay.replay("node", "getblockcount", nil, &height); !ok || err != nil || height != 7 {
+		t.Errorf("getblockcount: got %v %v %v", height, ok, err)
+	}
+	// calls changing the state of the node always go to it
+	if ok, _ := replay.replay("node", "generate", []interface{}{1}, nil); ok {
+		t.Errorf("generate: replayed a call changing the state of the node")
+	}
+	// the recording has no call left, the next one goes to the node
+	if ok, _ := replay.replay("node", "getblockcount", nil, &height); ok {
+		t.Errorf("getblockcount: replayed a call which was not recorded")
+	}
+	if ok, _ := replay.replay("actor", "getbalance", []interface{}{"", 3}, &balance); ok {
+		t.Errorf("getbalance: replayed a call with other params")
+	}
+	if replayed, diverged := replay.Counts(); replayed != 5 || diverged != 2 {
+		t.Errorf("got %d calls replayed and %d diverged, want 5 and 2", replayed, diverged)
+	}
+
+	// calls which went to the node are not recorded while replaying
+	replay.record("node", "getblockcount", nil, int64(9), nil)
+	if ok, _ := replay.replay("node", "getblockcount", nil, &height); ok {
+		t.Errorf("getblockcount: recorded a call while replaying")
+	}
+}
+
+func TestNilRecording(t *testing.T) {
+	var r *rpcRecording
+	r.record("node", "getblockcount", nil, int64(5), nil)
+	if ok, err := r.replay("node", "getblockcount", nil, nil); ok || err != nil {
+		t.Errorf("got %v %v, want no replay", ok, err)
+	}
+}
+
+func TestReadRecordingErrors(t *testing.T) {
+	for _, data := range []string{
+		"",
+		"not json\n",
+		"{\"seed\":1}\n{\"node\":\n",
+	} {
+		if _, _, err := readRecording(strings.NewReader(data)); err == nil {
+			t.Errorf("%q: read an invalid recording", data)
+		}
+	}
+}
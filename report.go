@@ -0,0 +1,248 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	// reportTPSBuckets is the number of intervals the run is split in to
+	// chart the transactions sent per second
+	reportTPSBuckets = 60
+
+	// chartWidth and chartHeight are the dimensions of the charts of the
+	// report in pixels, chartMargin the room left for the axis labels
+	chartWidth  = 640
+	chartHeight = 240
+	chartMargin = 40
+)
+
+// series is a series of points charted in the report
+type series struct {
+	xs, ys []float64
+}
+
+// add adds a point to the series
+func (s *series) add(x, y float64) {
+	s.xs = append(s.xs, x)
+	s.ys = append(s.ys, y)
+}
+
+// bounds returns the range of x and the largest y of the series, wide
+// enough that empty and flat series can be charted
+func (s *series) bounds() (minX, maxX, maxY float64) {
+	maxX, maxY = 1, 1
+	for i, x := range s.xs {
+		if i == 0 || x < minX {
+			minX = x
+		}
+		if x > maxX {
+			maxX = x
+		}
+		if s.ys[i] > maxY {
+			maxY = s.ys[i]
+		}
+	}
+	if maxX == minX {
+		maxX = minX + 1
+	}
+	return minX, maxX, maxY
+}
+
+// chart is a chart of the report, drawn as inline SVG
+type chart struct {
+	Title  string
+	XLabel string
+	YLabel string
+	bars   bool
+	data   series
+}
+
+// SVG returns the chart as an SVG element, a polyline or bars in a frame
+// with the ranges of the axes
+func (c *chart) SVG() template.HTML {
+	var b bytes.Buffer
+	minX, maxX, maxY := c.data.bounds()
+	plotW := float64(chartWidth - 2*chartMargin)
+	plotH := float64(chartHeight - 2*chartMargin)
+	px := func(x float64) float64 {
+		return chartMargin + (x-minX)/(maxX-minX)*plotW
+	}
+	py := func(y float64) float64 {
+		return chartHeight - chartMargin - y/maxY*plotH
+	}
+
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d">`,
+		chartWidth, chartHeight)
+	fmt.Fprintf(&b, `<rect x="%d" y="%d" width="%.0f" height="%.0f" fill="none" stroke="#999"/>`,
+		chartMargin, chartMargin, plotW, plotH)
+	if c.bars {
+		w := plotW / float64(len(c.data.xs)+1)
+		for i, x := range c.data.xs {
+			y := c.data.ys[i]
+			fmt.Fprintf(&b, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="#4a7ab5"/>`,
+				px(x)-w/2, py(y), w*0.8, chartHeight-chartMargin-py(y))
+		}
+	} else if len(c.data.xs) > 0 {
+		b.WriteString(`<polyline fill="none" stroke="#4a7ab5" stroke-width="1.5" points="`)
+		for i, x := range c.data.xs {
+			fmt.Fprintf(&b, "%.1f,%.1f ", px(x), py(c.data.ys[i]))
+		}
+		b.WriteString(`"/>`)
+	}
+	label := func(x, y int, anchor, text string) {
+		fmt.Fprintf(&b, `<text x="%d" y="%d" font-size="11" text-anchor="%s">`, x, y, anchor)
+		template.HTMLEscape(&b, []byte(text))
+		b.WriteString(`</text>`)
+	}
+	label(chartMargin, chartHeight-chartMargin+14, "start", formatTick(minX))
+	label(chartWidth-chartMargin, chartHeight-chartMargin+14, "end", formatTick(maxX))
+	label(chartMargin-4, chartMargin+4, "end", formatTick(maxY))
+	label(chartMargin-4, chartHeight-chartMargin, "end", "0")
+	label(chartWidth/2, chartHeight-8, "middle", c.XLabel)
+	label(chartMargin, chartMargin-8, "start", c.YLabel)
+	b.WriteString(`</svg>`)
+	return template.HTML(b.String())
+}
+
+// formatTick formats the value of an axis bound
+func formatTick(v float64) string {
+	return strconv.FormatFloat(v, 'g', 4, 64)
+}
+
+// tpsChart charts the transactions sent per second over the run
+func tpsChart(records []*TxRecord) *chart {
+	c := &chart{Title: "Transactions per second", XLabel: "seconds", YLabel: "tx/s"}
+	if len(records) == 0 {
+		return c
+	}
+	start, end := records[0].SentTime, records[0].SentTime
+	for _, r := range records {
+		if r.SentTime.Before(start) {
+			start = r.SentTime
+		}
+		if r.SentTime.After(end) {
+			end = r.SentTime
+		}
+	}
+	width := end.Sub(start) / reportTPSBuckets
+	if width < time.Second {
+		width = time.Second
+	}
+	counts := make([]int, int(end.Sub(start)/width)+1)
+	for _, r := range records {
+		counts[int(r.SentTime.Sub(start)/width)]++
+	}
+	for i, n := range counts {
+		c.data.add((time.Duration(i) * width).Seconds(), float64(n)/width.Seconds())
+	}
+	return c
+}
+
+// mempoolChart charts the transactions of actors left in the mempool by
+// every block
+func mempoolChart(blocks []*BlockComposition) *chart {
+	c := &chart{Title: "Mempool depth", XLabel: "height", YLabel: "transactions"}
+	for _, b := range blocks {
+		c.data.add(float64(b.Height), float64(b.Mempool))
+	}
+	return c
+}
+
+// latencyChart charts the number of transactions confirmed after every
+// number of blocks. Transactions mined below the tip they were sent at,
+// after a reorg, are left out.
+func latencyChart(records []*TxRecord) *chart {
+	c := &chart{Title: "Confirmation latency", XLabel: "blocks", YLabel: "transactions", bars: true}
+	var counts []int
+	for _, r := range records {
+		if !r.Confirmed() || r.LatencyBlocks() < 0 {
+			continue
+		}
+		n := int(r.LatencyBlocks())
+		for len(counts) <= n {
+			counts = append(counts, 0)
+		}
+		counts[n]++
+	}
+	for n, count := range counts {
+		c.data.add(float64(n), float64(count))
+	}
+	return c
+}
+
+// feesChart charts the fees of the transactions of actors in every block
+func feesChart(blocks []*BlockFees) *chart {
+	c := &chart{Title: "Fees per block", XLabel: "height", YLabel: "satoshis"}
+	for _, b := range blocks {
+		c.data.add(float64(b.Height), float64(b.Fees))
+	}
+	return c
+}
+
+// reportTemplate is the template of the HTML report, which embeds its
+// charts and style so that it can be shared as a single file
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>btcsim report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+pre { background: #f4f4f4; padding: 1em; overflow-x: auto; }
+figure { display: inline-block; margin: 1em 1em 0 0; }
+figcaption { font-weight: bold; }
+</style>
+</head>
+<body>
+<h1>btcsim report</h1>
+{{range .Charts}}<figure><figcaption>{{.Title}}</figcaption>{{.SVG}}</figure>
+{{end}}<h2>Summary</h2>
+<pre>{{.Summary}}</pre>
+</body>
+</html>
+`))
+
+// writeReportHTML writes the report of the run, the charts of its records
+// and blocks followed by its summary
+func writeReportHTML(w io.Writer, summary *Summary, stats *TxStats) error {
+	var text bytes.Buffer
+	if err := summary.Write(&text); err != nil {
+		return err
+	}
+	records := stats.Records()
+	return reportTemplate.Execute(w, struct {
+		Charts  []*chart
+		Summary string
+	}{
+		Charts: []*chart{
+			tpsChart(records),
+			mempoolChart(stats.BlockCompositions()),
+			latencyChart(records),
+			feesChart(stats.BlockFees()),
+		},
+		Summary: text.String(),
+	})
+}
+
+// writeReport writes the HTML report of the run to the given path
+func writeReport(path string, summary *Summary, stats *TxStats) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := writeReportHTML(file, summary, stats); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,67 @@
+package btcsim
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTPSChart(t *testing.T) {
+	start := time.Now()
+	var records []*TxRecord
+	for i := 0; i < 10; i++ {
+		records = append(records, &TxRecord{SentTime: start.Add(time.Duration(i) * 100 * time.Millisecond)})
+	}
+	c := tpsChart(records)
+	// all sent within the first second
+	if len(c.data.ys) != 1 || c.data.ys[0] != 10 {
+		t.Errorf("got tps %v", c.data.ys)
+	}
+	if len(tpsChart(nil).data.xs) != 0 {
+		t.Errorf("charted transactions of an empty run")
+	}
+}
+
+func TestLatencyChart(t *testing.T) {
+	now := time.Now()
+	records := []*TxRecord{
+		{SentHeight: 1, Height: 2, ConfirmedTime: now},
+		{SentHeight: 1, Height: 2, ConfirmedTime: now},
+		{SentHeight: 1, Height: 4, ConfirmedTime: now},
+		{SentHeight: 1},
+		{SentHeight: 5, Height: 3, ConfirmedTime: now},
+	}
+	c := latencyChart(records)
+	if len(c.data.ys) != 4 || c.data.ys[1] != 2 || c.data.ys[3] != 1 || c.data.ys[0] != 0 {
+		t.Errorf("got latency histogram %v", c.data.ys)
+	}
+	if svg := string(c.SVG()); strings.Count(svg, `fill="#4a7ab5"`) != 4 {
+		t.Errorf("got %d bars", strings.Count(svg, `fill="#4a7ab5"`))
+	}
+}
+
+func TestWriteReportHTML(t *testing.T) {
+	stats := NewTxStats(DefaultConfig())
+	stats.records = []*TxRecord{{TxID: "a", SentTime: time.Now()}}
+	stats.compositions = []*BlockComposition{{Height: 1, Mempool: 3}, {Height: 2}}
+	stats.blockFees = []*BlockFees{{Height: 1, Fees: 1000}}
+	summary := &Summary{Height: 2, Blocks: 2, Transactions: 1}
+
+	var b bytes.Buffer
+	if err := writeReportHTML(&b, summary, stats); err != nil {
+		t.Fatalf("writeReportHTML error: %v", err)
+	}
+	html := b.String()
+	if n := strings.Count(html, "<svg"); n != 4 {
+		t.Errorf("got %d charts", n)
+	}
+	for _, want := range []string{"Mempool depth", "Fees per block", "Final block height: 2", "<polyline"} {
+		if !strings.Contains(html, want) {
+			t.Errorf("report lacks %q", want)
+		}
+	}
+	if strings.Contains(html, "&lt;svg") {
+		t.Errorf("charts were escaped")
+	}
+}
@@ -0,0 +1,322 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"time"
+)
+
+// clockTicks is the number of clock ticks per second in which procfs
+// reports cpu times, USER_HZ is 100 on every linux platform
+const clockTicks = 100
+
+// procRoot is the mount point of procfs
+const procRoot = "/proc"
+
+// checkResources returns an error if the resources of the processes
+// cannot be monitored at the given interval, which requires procfs and
+// local processes
+func checkResources(interval time.Duration, docker bool) error {
+	if interval <= 0 {
+		return nil
+	}
+	if runtime.GOOS != "linux" {
+		return fmt.Errorf("resource monitoring requires procfs, which %s does not have", runtime.GOOS)
+	}
+	if docker {
+		return errors.New("cannot monitor the resources of processes in docker containers")
+	}
+	return nil
+}
+
+// resourceSample is the resource usage of a process at a point in time
+type resourceSample struct {
+	time    time.Time
+	process string
+	exe     string
+	pid     int
+
+	// cpu is the user and system time used by the process since it
+	// started, rss its resident set size and disk the size of its data
+	// directory in bytes, fds its number of open file descriptors
+	cpu  time.Duration
+	rss  int64
+	disk int64
+	fds  int
+}
+
+// parseProcStat returns the user and system time used by a process given
+// the content of its /proc/<pid>/stat file
+func parseProcStat(stat []byte) (time.Duration, error) {
+	// the command name in parentheses may contain spaces, the fields
+	// after it start with the state, the third one
+	i := bytes.LastIndexByte(stat, ')')
+	if i < 0 {
+		return 0, errors.New("malformed stat")
+	}
+	fields := bytes.Fields(stat[i+1:])
+	if len(fields) < 13 {
+		return 0, errors.New("malformed stat")
+	}
+	var ticks int64
+	// utime and stime are the 14th and 15th fields
+	for _, field := range fields[11:13] {
+		n, err := strconv.ParseInt(string(field), 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		ticks += n
+	}
+	return time.Duration(ticks) * time.Second / clockTicks, nil
+}
+
+// parseProcStatus returns the resident set size in bytes of a process
+// given the content of its /proc/<pid>/status file
+func parseProcStatus(status []byte) (int64, error) {
+	for _, line := range bytes.Split(status, []byte("\n")) {
+		fields := bytes.Fields(line)
+		if len(fields) == 3 && string(fields[0]) == "VmRSS:" && string(fields[2]) == "kB" {
+			kb, err := strconv.ParseInt(string(fields[1]), 10, 64)
+			if err != nil {
+				return 0, err
+			}
+			return kb * 1024, nil
+		}
+	}
+	return 0, errors.New("no VmRSS in status")
+}
+
+// readProcUsage reads the cpu time, resident set size and number of open
+// file descriptors of a process from procfs mounted at root
+func readProcUsage(root string, pid int) (cpu time.Duration, rss int64, fds int, err error) {
+	dir := filepath.Join(root, strconv.Itoa(pid))
+	stat, err := ioutil.ReadFile(filepath.Join(dir, "stat"))
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	if cpu, err = parseProcStat(stat); err != nil {
+		return 0, 0, 0, err
+	}
+	status, err := ioutil.ReadFile(filepath.Join(dir, "status"))
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	if rss, err = parseProcStatus(status); err != nil {
+		return 0, 0, 0, err
+	}
+	entries, err := ioutil.ReadDir(filepath.Join(dir, "fd"))
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	return cpu, rss, len(entries), nil
+}
+
+// dirSize returns the total size of the files in a directory, files
+// removed while it is walked are skipped
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+// pid returns the id of the node process, zero if it is not running
+func (n *Node) pid() int {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	if n.cmd == nil || n.cmd.Process == nil {
+		return 0
+	}
+	select {
+	case <-n.exited:
+		return 0
+	default:
+	}
+	return n.cmd.Process.Pid
+}
+
+// executable returns the name of the program the node runs, e.g. btcd
+func executable(args Args) string {
+	switch unwrapArgs(args).(type) {
+	case *btcdArgs:
+		return "btcd"
+	case *btcwalletArgs:
+		return "btcwallet"
+	case *bitcoindArgs:
+		return "bitcoind"
+	}
+	return ""
+}
+
+// sampleResources returns the resource usage of the process of the node
+// at time t, nil if it has no running process
+func sampleResources(n *Node, t time.Time) *resourceSample {
+	pid := n.pid()
+	if pid == 0 {
+		return nil
+	}
+	cpu, rss, fds, err := readProcUsage(procRoot, pid)
+	if err != nil {
+		log.Debugf("%s: Cannot read resource usage: %v", n, err)
+		return nil
+	}
+	s := &resourceSample{
+		time:    t,
+		process: n.String(),
+		exe:     executable(n.Args),
+		pid:     pid,
+		cpu:     cpu,
+		rss:     rss,
+		fds:     fds,
+	}
+	if dir, err := dataDir(n.Args); err == nil {
+		s.disk = dirSize(dir)
+	}
+	return s
+}
+
+// monitorResources runs as a goroutine and, every interval, samples the
+// resource usage of the processes of the nodes, the miner and the wallets
+// until the simulation exits
+func (com *Communication) monitorResources(interval time.Duration) {
+	defer com.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			com.actorsMtx.RLock()
+			processes := append([]*Node(nil), com.nodes...)
+			if com.miner != nil {
+				processes = append(processes, com.miner.Node)
+			}
+			com.actorsMtx.RUnlock()
+			for _, a := range com.Actors() {
+				if a.wallet == nil {
+					processes = append(processes, a.Node)
+				}
+			}
+			for _, n := range processes {
+				if s := sampleResources(n, now); s != nil {
+					com.resourceSamples = append(com.resourceSamples, s)
+				}
+			}
+		case <-com.exit:
+			return
+		}
+	}
+}
+
+// ResourceUsage summarizes the resource usage of a process over the
+// simulation, across restarts
+type ResourceUsage struct {
+	Exe      string        `json:"exe"`
+	Samples  int           `json:"samples"`
+	CPUTime  time.Duration `json:"cputime"`
+	MeanCPU  float64       `json:"meancpu"`
+	PeakRSS  int64         `json:"peakrss"`
+	PeakFDs  int           `json:"peakfds"`
+	PeakDisk int64         `json:"peakdisk"`
+}
+
+// newResourceUsage returns the resource usage of each process given the
+// samples in time order. The cpu time is the time used between the first
+// and the last sample, and the mean cpu usage its percentage of a core.
+func newResourceUsage(samples []*resourceSample) map[string]*ResourceUsage {
+	usage := make(map[string]*ResourceUsage)
+	first := make(map[string]*resourceSample)
+	last := make(map[string]*resourceSample)
+	for _, s := range samples {
+		u, ok := usage[s.process]
+		if !ok {
+			u = &ResourceUsage{Exe: s.exe}
+			usage[s.process] = u
+			first[s.process] = s
+		} else if prev := last[s.process]; prev.pid != s.pid {
+			// the process restarted, counting the time used since
+			u.CPUTime += s.cpu
+		} else {
+			u.CPUTime += s.cpu - prev.cpu
+		}
+		last[s.process] = s
+		u.Samples++
+		if s.rss > u.PeakRSS {
+			u.PeakRSS = s.rss
+		}
+		if s.fds > u.PeakFDs {
+			u.PeakFDs = s.fds
+		}
+		if s.disk > u.PeakDisk {
+			u.PeakDisk = s.disk
+		}
+	}
+	for process, u := range usage {
+		if elapsed := last[process].time.Sub(first[process].time); elapsed > 0 {
+			u.MeanCPU = float64(u.CPUTime) / float64(elapsed) * 100
+		}
+	}
+	return usage
+}
+
+// String returns the resource usage as a single line
+func (u *ResourceUsage) String() string {
+	return fmt.Sprintf("cpu %v (%.1f%% of a core), peak rss %.1f MB, peak %d fds, peak disk %.1f MB",
+		u.CPUTime, u.MeanCPU, float64(u.PeakRSS)/1e6, u.PeakFDs, float64(u.PeakDisk)/1e6)
+}
+
+// writeResourceStatsCSV writes the resource usage samples as CSV with a
+// header row
+func writeResourceStatsCSV(w io.Writer, samples []*resourceSample) error {
+	writer := csv.NewWriter(w)
+	header := []string{"time", "process", "pid", "cpu", "rss", "fds", "disk"}
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+	for _, s := range samples {
+		row := []string{
+			s.time.Format(time.RFC3339Nano),
+			s.process,
+			strconv.Itoa(s.pid),
+			strconv.FormatFloat(s.cpu.Seconds(), 'f', 2, 64),
+			strconv.FormatInt(s.rss, 10),
+			strconv.Itoa(s.fds),
+			strconv.FormatInt(s.disk, 10),
+		}
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// writeResourceStats writes the resource usage samples to the given path
+// as CSV
+func writeResourceStats(path string, samples []*resourceSample) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := writeResourceStatsCSV(file, samples); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,108 @@
+package btcsim
+
+import (
+	"bytes"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseProcStat(t *testing.T) {
+	// the command name may contain spaces and parentheses
+	stat := "1234 (btc d) (x) S 1 1234 1234 0 -1 4194560 100 0 0 0 250 50 0 0 20 0 8 0 100"
+	cpu, err := parseProcStat([]byte(stat))
+	if err != nil {
+		t.Fatalf("parseProcStat error: %v", err)
+	}
+	if cpu != 3*time.Second {
+		t.Errorf("parseProcStat got %v want 3s", cpu)
+	}
+	for _, stat := range []string{"1234 btcd S 1", "1234 (btcd) S 1 2 3"} {
+		if _, err := parseProcStat([]byte(stat)); err == nil {
+			t.Errorf("parseProcStat(%q) expected error", stat)
+		}
+	}
+}
+
+func TestParseProcStatus(t *testing.T) {
+	status := "Name:\tbtcd\nVmPeak:\t  20000 kB\nVmRSS:\t   1536 kB\nThreads:\t8\n"
+	rss, err := parseProcStatus([]byte(status))
+	if err != nil {
+		t.Fatalf("parseProcStatus error: %v", err)
+	}
+	if rss != 1536*1024 {
+		t.Errorf("parseProcStatus got %d want %d", rss, 1536*1024)
+	}
+	if _, err := parseProcStatus([]byte("Name:\tbtcd\n")); err == nil {
+		t.Errorf("parseProcStatus expected error without VmRSS")
+	}
+}
+
+func TestReadProcUsage(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("procfs is linux only")
+	}
+	_, rss, fds, err := readProcUsage(procRoot, os.Getpid())
+	if err != nil {
+		t.Fatalf("readProcUsage error: %v", err)
+	}
+	if rss <= 0 || fds <= 0 {
+		t.Errorf("readProcUsage got rss %d and %d fds", rss, fds)
+	}
+}
+
+func TestNewResourceUsage(t *testing.T) {
+	start := time.Unix(0, 0)
+	samples := []*resourceSample{
+		{time: start, process: "node", pid: 1, cpu: 2 * time.Second, rss: 100, fds: 10, disk: 1000},
+		{time: start, process: "actor", pid: 2, cpu: time.Second, rss: 50, fds: 5},
+		{time: start.Add(10 * time.Second), process: "node", pid: 1, cpu: 4 * time.Second, rss: 300, fds: 12, disk: 2000},
+		// the node restarted
+		{time: start.Add(20 * time.Second), process: "node", pid: 3, cpu: time.Second, rss: 200, fds: 20, disk: 1500},
+	}
+	usage := newResourceUsage(samples)
+	node := usage["node"]
+	if node == nil || node.Samples != 3 {
+		t.Fatalf("got node usage %+v want 3 samples", node)
+	}
+	if node.CPUTime != 3*time.Second {
+		t.Errorf("got cpu time %v want 3s", node.CPUTime)
+	}
+	if node.MeanCPU != 15 {
+		t.Errorf("got mean cpu %.1f%% want 15%%", node.MeanCPU)
+	}
+	if node.PeakRSS != 300 || node.PeakFDs != 20 || node.PeakDisk != 2000 {
+		t.Errorf("got peaks %+v", node)
+	}
+	if actor := usage["actor"]; actor == nil || actor.MeanCPU != 0 {
+		t.Errorf("got actor usage %+v want a single sample", actor)
+	}
+}
+
+func TestWriteResourceStatsCSV(t *testing.T) {
+	samples := []*resourceSample{
+		{time: time.Unix(0, 0).UTC(), process: "node", pid: 1, cpu: 1500 * time.Millisecond, rss: 100, fds: 10, disk: 1000},
+	}
+	var buf bytes.Buffer
+	if err := writeResourceStatsCSV(&buf, samples); err != nil {
+		t.Fatalf("writeResourceStatsCSV error: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines want 2", len(lines))
+	}
+	if want := "1970-01-01T00:00:00Z,node,1,1.50,100,10,1000"; lines[1] != want {
+		t.Errorf("got row %q want %q", lines[1], want)
+	}
+}
+
+func TestCheckResources(t *testing.T) {
+	if err := checkResources(0, true); err != nil {
+		t.Errorf("checkResources error when disabled: %v", err)
+	}
+	if err := checkResources(time.Second, true); err == nil {
+		t.Errorf("checkResources expected error with docker")
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+// Exit codes of the simulator, so that harnesses running it can tell a
+// simulation which could not run from one which found a problem
+const (
+	// exitPass is returned when the simulation ran and every check passed
+	exitPass = 0
+
+	// exitError is returned when the simulation could not run
+	exitError = 1
+
+	// exitAssertion is returned when an assertion of the scenario failed
+	exitAssertion = 2
+
+	// exitCheck is returned when the balance invariant was violated or
+	// the audit found funds lost or double spent by a wallet
+	exitCheck = 3
+)
+
+// checkError is the error of a simulation which ran but failed a check,
+// with the exit code of the failure
+type checkError struct {
+	code int
+	msg  string
+}
+
+// Error returns the description of the failure
+func (e *checkError) Error() string {
+	return e.msg
+}
+
+// exitCode returns the exit code of a simulation which returned err
+func exitCode(err error) int {
+	switch err := err.(type) {
+	case nil:
+		return exitPass
+	case *checkError:
+		return err.code
+	}
+	return exitError
+}
+
+// Result is the machine readable outcome of a simulation
+type Result struct {
+	Pass     bool   `json:"pass"`
+	ExitCode int    `json:"exitcode"`
+	Error    string `json:"error,omitempty"`
+
+	Assertions          int      `json:"assertions"`
+	FailedAssertions    []string `json:"failedassertions"`
+	InvariantViolations int      `json:"invariantviolations"`
+	AuditIssues         int      `json:"auditissues"`
+	LostFunds           int      `json:"lostfunds"`
+	FailedActors        int      `json:"failedactors"`
+	Crashes             int      `json:"crashes"`
+	Errors              uint64   `json:"errors"`
+	Warnings            uint64   `json:"warnings"`
+}
+
+// newResult returns the result of a simulation which returned err, with
+// the summary and failed assertions if it ran
+func newResult(err error, summary *Summary, failures []string) *Result {
+	r := &Result{
+		Pass:             err == nil,
+		ExitCode:         exitCode(err),
+		FailedAssertions: failures,
+	}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	if r.FailedAssertions == nil {
+		r.FailedAssertions = []string{}
+	}
+	if summary != nil {
+		r.Assertions = summary.Assertions
+		r.InvariantViolations = summary.InvariantViolations
+		r.AuditIssues = summary.AuditIssues
+		r.LostFunds = summary.LostFunds
+		r.FailedActors = summary.FailedActors
+		r.Crashes = summary.Crashes
+	}
+	r.Warnings, r.Errors = log.Counts()
+	return r
+}
+
+// lostFunds returns the number of differences found by the audit which
+// mean that a wallet lost or double spent funds, as opposed to unconfirmed
+// transactions which lost a double spend race
+func lostFunds(issues []*auditIssue) int {
+	var n int
+	for _, issue := range issues {
+		if issue.kind != auditUnconfirmed {
+			n++
+		}
+	}
+	return n
+}
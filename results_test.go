@@ -0,0 +1,52 @@
+package btcsim
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{nil, exitPass},
+		{errors.New("cannot start nodes"), exitError},
+		{&checkError{exitAssertion, "1 of 2 scenario assertions failed"}, exitAssertion},
+		{&checkError{exitCheck, "1 balance invariant violations"}, exitCheck},
+	}
+	for _, test := range tests {
+		if got := exitCode(test.err); got != test.want {
+			t.Errorf("exitCode(%v) got %d want %d", test.err, got, test.want)
+		}
+	}
+}
+
+func TestNewResult(t *testing.T) {
+	r := newResult(nil, nil, nil)
+	if !r.Pass || r.ExitCode != exitPass || r.Error != "" || r.FailedAssertions == nil {
+		t.Errorf("unexpected result of a passing run %+v", r)
+	}
+
+	summary := &Summary{Assertions: 2, AssertionsFailed: 1, AuditIssues: 3, LostFunds: 1}
+	failures := []string{"assert txs > 0: got 0"}
+	err := &checkError{exitAssertion, "1 of 2 scenario assertions failed"}
+	r = newResult(err, summary, failures)
+	if r.Pass || r.ExitCode != exitAssertion || r.Error != err.msg {
+		t.Errorf("unexpected result of a failed run %+v", r)
+	}
+	if r.Assertions != 2 || len(r.FailedAssertions) != 1 || r.AuditIssues != 3 || r.LostFunds != 1 {
+		t.Errorf("unexpected counts %+v", r)
+	}
+}
+
+func TestLostFunds(t *testing.T) {
+	issues := []*auditIssue{
+		{kind: auditSpent},
+		{kind: auditUnconfirmed},
+		{kind: auditStaleBlock},
+	}
+	if n := lostFunds(issues); n != 2 {
+		t.Errorf("lostFunds got %d want 2", n)
+	}
+}
@@ -0,0 +1,729 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/wire"
+	rpc "github.com/btcsuite/btcrpcclient"
+	"github.com/btcsuite/btcutil"
+)
+
+// rpcLatencyBuckets are the upper bounds of the histogram buckets of the
+// latency of rpc calls
+var rpcLatencyBuckets = []time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	5 * time.Second,
+}
+
+// rpcAllNodes is the node of the latency of a method across every node
+const rpcAllNodes = "*"
+
+// rpcCall identifies the calls of a method to a node
+type rpcCall struct {
+	node, method string
+}
+
+// rpcCalls implements sort.Interface for a slice of calls, sorted by node
+// and method
+type rpcCalls []rpcCall
+
+func (c rpcCalls) Len() int      { return len(c) }
+func (c rpcCalls) Swap(i, j int) { c[i], c[j] = c[j], c[i] }
+func (c rpcCalls) Less(i, j int) bool {
+	if c[i].node != c[j].node {
+		return c[i].node < c[j].node
+	}
+	return c[i].method < c[j].method
+}
+
+// rpcRecorder records the latency of the rpc calls by node and method
+type rpcRecorder struct {
+	mtx       sync.Mutex
+	latencies map[rpcCall][]time.Duration
+	errors    map[rpcCall]int
+}
+
+// newRPCRecorder returns an empty rpc latency recorder
+func newRPCRecorder() *rpcRecorder {
+	return &rpcRecorder{
+		latencies: make(map[rpcCall][]time.Duration),
+		errors:    make(map[rpcCall]int),
+	}
+}
+
+// record records a call of the method to the node which took the given
+// time and failed if err is set. It does nothing if r is nil.
+func (r *rpcRecorder) record(node, method string, latency time.Duration, err error) {
+	if r == nil {
+		return
+	}
+	call := rpcCall{node, method}
+	r.mtx.Lock()
+	r.latencies[call] = append(r.latencies[call], latency)
+	if err != nil {
+		r.errors[call]++
+	}
+	r.mtx.Unlock()
+}
+
+// RPCLatency is the distribution of the latency of the calls of a method
+// to a node, or to every node if Node is "*"
+type RPCLatency struct {
+	Node   string        `json:"node"`
+	Method string        `json:"method"`
+	Calls  int           `json:"calls"`
+	Errors int           `json:"errors"`
+	Mean   time.Duration `json:"mean"`
+	Median time.Duration `json:"median"`
+	P95    time.Duration `json:"p95"`
+	Max    time.Duration `json:"max"`
+
+	// Histogram counts the calls by latency as per rpcLatencyBuckets
+	Histogram []int `json:"histogram"`
+}
+
+// newRPCLatency returns the distribution of the given latencies
+func newRPCLatency(node, method string, latencies []time.Duration, failed int) *RPCLatency {
+	sorted := append([]time.Duration(nil), latencies...)
+	sort.Sort(durations(sorted))
+	l := &RPCLatency{
+		Node:      node,
+		Method:    method,
+		Calls:     len(sorted),
+		Errors:    failed,
+		Median:    percentile(sorted, 50),
+		P95:       percentile(sorted, 95),
+		Histogram: make([]int, len(rpcLatencyBuckets)+1),
+	}
+	var total time.Duration
+	for _, d := range sorted {
+		total += d
+		i := sort.Search(len(rpcLatencyBuckets), func(i int) bool {
+			return rpcLatencyBuckets[i] >= d
+		})
+		l.Histogram[i]++
+	}
+	if l.Calls > 0 {
+		l.Mean = total / time.Duration(l.Calls)
+		l.Max = sorted[l.Calls-1]
+	}
+	return l
+}
+
+// Stats returns the latency of every method across every node, followed
+// by the latency of every method to every node, sorted by node and method
+func (r *rpcRecorder) Stats() []*RPCLatency {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	all := make(map[string][]time.Duration)
+	allErrors := make(map[string]int)
+	calls := make([]rpcCall, 0, len(r.latencies))
+	for call, latencies := range r.latencies {
+		calls = append(calls, call)
+		all[call.method] = append(all[call.method], latencies...)
+		allErrors[call.method] += r.errors[call]
+	}
+	methods := make([]string, 0, len(all))
+	for method := range all {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	sort.Sort(rpcCalls(calls))
+
+	stats := make([]*RPCLatency, 0, len(methods)+len(calls))
+	for _, method := range methods {
+		stats = append(stats, newRPCLatency(rpcAllNodes, method, all[method], allErrors[method]))
+	}
+	for _, call := range calls {
+		stats = append(stats, newRPCLatency(call.node, call.method, r.latencies[call], r.errors[call]))
+	}
+	return stats
+}
+
+// group returns the latency of every method across the given nodes, named
+// after the group, sorted by method
+func (r *rpcRecorder) group(name string, nodes map[string]bool) []*RPCLatency {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	latencies := make(map[string][]time.Duration)
+	failed := make(map[string]int)
+	for call, l := range r.latencies {
+		if nodes[call.node] {
+			latencies[call.method] = append(latencies[call.method], l...)
+			failed[call.method] += r.errors[call]
+		}
+	}
+	methods := make([]string, 0, len(latencies))
+	for method := range latencies {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	stats := make([]*RPCLatency, 0, len(methods))
+	for _, method := range methods {
+		stats = append(stats, newRPCLatency(name, method, latencies[method], failed[method]))
+	}
+	return stats
+}
+
+// writeRPCStatsCSV writes the latency of the rpc calls as CSV with a
+// header row, times are in seconds
+func writeRPCStatsCSV(w io.Writer, stats []*RPCLatency) error {
+	header := []string{"node", "method", "calls", "errors", "mean", "median", "p95", "max"}
+	for _, bound := range rpcLatencyBuckets {
+		header = append(header, fmt.Sprintf("latency<=%v", bound))
+	}
+	header = append(header, fmt.Sprintf("latency>%v", rpcLatencyBuckets[len(rpcLatencyBuckets)-1]))
+
+	writer := csv.NewWriter(w)
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+	for _, l := range stats {
+		row := []string{l.Node, l.Method, strconv.Itoa(l.Calls), strconv.Itoa(l.Errors)}
+		for _, d := range []time.Duration{l.Mean, l.Median, l.P95, l.Max} {
+			row = append(row, strconv.FormatFloat(d.Seconds(), 'f', -1, 64))
+		}
+		for _, n := range l.Histogram {
+			row = append(row, strconv.Itoa(n))
+		}
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// writeRPCStats writes the latency of the rpc calls to the given path as
+// CSV
+func writeRPCStats(path string, stats []*RPCLatency) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := writeRPCStatsCSV(file, stats); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// rpcCaller is the rpc surface wrapped by rpcClient. It is implemented by
+// rpc.Client, and by in-memory chains so that actors can be tested without
+// running btcd and btcwallet processes.
+type rpcCaller interface {
+	AddNode(host string, command rpc.AddNodeCommand) error
+	AddMultisigAddress(requiredSigs int, addresses []btcutil.Address, account string) (btcutil.Address, error)
+	CreateEncryptedWallet(passphrase string) error
+	CreateMultisig(requiredSigs int, addresses []btcutil.Address) (*btcjson.CreateMultiSigResult, error)
+	CreateRawTransaction(inputs []btcjson.TransactionInput,
+		amounts map[btcutil.Address]btcutil.Amount) (*wire.MsgTx, error)
+	DumpPrivKey(address btcutil.Address) (*btcutil.WIF, error)
+	EstimateFee(numBlocks int64) (float64, error)
+	Generate(numBlocks uint32) ([]*wire.ShaHash, error)
+	GetBalance(account string) (btcutil.Amount, error)
+	GetBalanceMinConf(account string, minConfirms int) (btcutil.Amount, error)
+	GetBlock(blockHash *wire.ShaHash) (*btcutil.Block, error)
+	GetBlockCount() (int64, error)
+	GetBlockHash(blockHeight int64) (*wire.ShaHash, error)
+	GetInfo() (*btcjson.InfoWalletResult, error)
+	GetNewAddress() (btcutil.Address, error)
+	GetRawMempool() ([]*wire.ShaHash, error)
+	GetRawMempoolVerbose() (map[string]btcjson.GetRawMempoolVerboseResult, error)
+	GetTransaction(txHash *wire.ShaHash) (*btcjson.GetTransactionResult, error)
+	GetTxOut(txHash *wire.ShaHash, index uint32, mempool bool) (*btcjson.GetTxOutResult, error)
+	ImportPrivKeyRescan(privKeyWIF *btcutil.WIF, label string, rescan bool) error
+	ListTransactionsCount(account string, count int) ([]btcjson.ListTransactionsResult, error)
+	ListUnspent() ([]btcjson.ListUnspentResult, error)
+	ListUnspentMinMax(minConf, maxConf int) ([]btcjson.ListUnspentResult, error)
+	SendRawTransaction(tx *wire.MsgTx, allowHighFees bool) (*wire.ShaHash, error)
+	SendToAddress(address btcutil.Address, amount btcutil.Amount) (*wire.ShaHash, error)
+	SetGenerate(enable bool, numCPUs int) error
+	SignRawTransaction(tx *wire.MsgTx) (*wire.MsgTx, bool, error)
+	SignRawTransaction2(tx *wire.MsgTx, inputs []btcjson.RawTxInput) (*wire.MsgTx, bool, error)
+	SubmitBlock(block *btcutil.Block, options *btcjson.SubmitBlockOptions) error
+	ValidateAddress(address btcutil.Address) (*btcjson.ValidateAddressWalletResult, error)
+	WalletPassphrase(passphrase string, timeoutSecs int64) error
+}
+
+// rpcClient is an rpc client which records the latency of its calls to
+// the rpc recorder set when it was created, and records their results to
+// or replays them from the recording set then, and traces them to the rpc
+// trace set then. The wrapped calls go to calls, the rpc.Client unless the
+// client is backed by an in-memory chain.
+type rpcClient struct {
+	*rpc.Client
+	calls rpcCaller
+	node  string
+	stats *rpcRecorder
+	rec   *rpcRecording
+	trace *rpcTrace
+}
+
+// newRPCClient returns the client connected to the named node, recording
+// the latency of its calls, their results and tracing them as configured
+// by cfg for its run
+func newRPCClient(cfg *Config, client *rpc.Client, node string) *rpcClient {
+	return &rpcClient{
+		Client: client,
+		calls:  client,
+		node:   node,
+		stats:  cfg.rpcStats,
+		rec:    cfg.recording,
+		trace:  cfg.rpcTracer,
+	}
+}
+
+// record records a call of the method with the given params started at the
+// given time, with its result in its recorded form
+func (c *rpcClient) record(method string, start time.Time, result interface{}, err error,
+	params ...interface{}) {
+
+	c.stats.record(c.node, method, time.Since(start), err)
+	c.rec.record(c.node, method, params, result, err)
+	c.trace.add(c.node, method, start, result, err, params...)
+}
+
+// replay decodes the next recorded result of the method with the given
+// params into result, ok is false if the call is not replayed and must go
+// to the node
+func (c *rpcClient) replay(method string, result interface{}, params ...interface{}) (ok bool, err error) {
+	return c.rec.replay(c.node, method, params, result)
+}
+
+// AddNode wraps the addnode rpc
+func (c *rpcClient) AddNode(host string, command rpc.AddNodeCommand) error {
+	if ok, err := c.replay("addnode", nil, host, command); ok {
+		return err
+	}
+	start := time.Now()
+	err := c.calls.AddNode(host, command)
+	c.record("addnode", start, nil, err, host, command)
+	return err
+}
+
+// AddMultisigAddress wraps the addmultisigaddress rpc
+func (c *rpcClient) AddMultisigAddress(requiredSigs int, addresses []btcutil.Address, account string) (btcutil.Address, error) {
+	var s string
+	if ok, err := c.replay("addmultisigaddress", &s, requiredSigs, addresses, account); ok {
+		return replayedAddress(s, err)
+	}
+	start := time.Now()
+	addr, err := c.calls.AddMultisigAddress(requiredSigs, addresses, account)
+	c.record("addmultisigaddress", start, recordedAddress(addr), err, requiredSigs, addresses, account)
+	return addr, err
+}
+
+// CreateEncryptedWallet wraps the createencryptedwallet rpc
+func (c *rpcClient) CreateEncryptedWallet(passphrase string) error {
+	if ok, err := c.replay("createencryptedwallet", nil, passphrase); ok {
+		return err
+	}
+	start := time.Now()
+	err := c.calls.CreateEncryptedWallet(passphrase)
+	c.record("createencryptedwallet", start, nil, err, passphrase)
+	return err
+}
+
+// CreateMultisig wraps the createmultisig rpc
+func (c *rpcClient) CreateMultisig(requiredSigs int, addresses []btcutil.Address) (*btcjson.CreateMultiSigResult, error) {
+	res := new(btcjson.CreateMultiSigResult)
+	if ok, err := c.replay("createmultisig", res, requiredSigs, addresses); ok {
+		return res, err
+	}
+	start := time.Now()
+	res, err := c.calls.CreateMultisig(requiredSigs, addresses)
+	c.record("createmultisig", start, res, err, requiredSigs, addresses)
+	return res, err
+}
+
+// CreateRawTransaction wraps the createrawtransaction rpc
+func (c *rpcClient) CreateRawTransaction(inputs []btcjson.TransactionInput,
+	amounts map[btcutil.Address]btcutil.Amount) (*wire.MsgTx, error) {
+
+	var s string
+	if ok, err := c.replay("createrawtransaction", &s, inputs, amounts); ok {
+		return replayedTx(s, err)
+	}
+	start := time.Now()
+	msgTx, err := c.calls.CreateRawTransaction(inputs, amounts)
+	c.record("createrawtransaction", start, recordedTx(msgTx), err, inputs, amounts)
+	return msgTx, err
+}
+
+// DumpPrivKey wraps the dumpprivkey rpc
+func (c *rpcClient) DumpPrivKey(address btcutil.Address) (*btcutil.WIF, error) {
+	var s string
+	if ok, err := c.replay("dumpprivkey", &s, address); ok {
+		return replayedWIF(s, err)
+	}
+	start := time.Now()
+	wif, err := c.calls.DumpPrivKey(address)
+	c.record("dumpprivkey", start, recordedWIF(wif), err, address)
+	return wif, err
+}
+
+// EstimateFee wraps the estimatefee rpc
+func (c *rpcClient) EstimateFee(numBlocks int64) (float64, error) {
+	var rate float64
+	if ok, err := c.replay("estimatefee", &rate, numBlocks); ok {
+		return rate, err
+	}
+	start := time.Now()
+	rate, err := c.calls.EstimateFee(numBlocks)
+	c.record("estimatefee", start, rate, err, numBlocks)
+	return rate, err
+}
+
+// Generate wraps the generate rpc
+func (c *rpcClient) Generate(numBlocks uint32) ([]*wire.ShaHash, error) {
+	var s []string
+	if ok, err := c.replay("generate", &s, numBlocks); ok {
+		return replayedHashes(s, err)
+	}
+	start := time.Now()
+	hashes, err := c.calls.Generate(numBlocks)
+	c.record("generate", start, recordedHashes(hashes), err, numBlocks)
+	return hashes, err
+}
+
+// GetBalance wraps the getbalance rpc
+func (c *rpcClient) GetBalance(account string) (btcutil.Amount, error) {
+	var balance btcutil.Amount
+	if ok, err := c.replay("getbalance", &balance, account); ok {
+		return balance, err
+	}
+	start := time.Now()
+	balance, err := c.calls.GetBalance(account)
+	c.record("getbalance", start, balance, err, account)
+	return balance, err
+}
+
+// GetBalanceMinConf wraps the getbalance rpc with a number of
+// confirmations
+func (c *rpcClient) GetBalanceMinConf(account string, minConfirms int) (btcutil.Amount, error) {
+	var balance btcutil.Amount
+	if ok, err := c.replay("getbalance", &balance, account, minConfirms); ok {
+		return balance, err
+	}
+	start := time.Now()
+	balance, err := c.calls.GetBalanceMinConf(account, minConfirms)
+	c.record("getbalance", start, balance, err, account, minConfirms)
+	return balance, err
+}
+
+// GetBlock wraps the getblock rpc
+func (c *rpcClient) GetBlock(blockHash *wire.ShaHash) (*btcutil.Block, error) {
+	var s string
+	if ok, err := c.replay("getblock", &s, blockHash); ok {
+		return replayedBlock(s, err)
+	}
+	start := time.Now()
+	block, err := c.calls.GetBlock(blockHash)
+	c.record("getblock", start, recordedBlock(block), err, blockHash)
+	return block, err
+}
+
+// GetBlockCount wraps the getblockcount rpc
+func (c *rpcClient) GetBlockCount() (int64, error) {
+	var height int64
+	if ok, err := c.replay("getblockcount", &height); ok {
+		return height, err
+	}
+	start := time.Now()
+	height, err := c.calls.GetBlockCount()
+	c.record("getblockcount", start, height, err)
+	return height, err
+}
+
+// GetBlockHash wraps the getblockhash rpc
+func (c *rpcClient) GetBlockHash(blockHeight int64) (*wire.ShaHash, error) {
+	var s string
+	if ok, err := c.replay("getblockhash", &s, blockHeight); ok {
+		return replayedHash(s, err)
+	}
+	start := time.Now()
+	hash, err := c.calls.GetBlockHash(blockHeight)
+	c.record("getblockhash", start, recordedHash(hash), err, blockHeight)
+	return hash, err
+}
+
+// GetInfo wraps the getinfo rpc
+func (c *rpcClient) GetInfo() (*btcjson.InfoWalletResult, error) {
+	info := new(btcjson.InfoWalletResult)
+	if ok, err := c.replay("getinfo", info); ok {
+		return info, err
+	}
+	start := time.Now()
+	info, err := c.calls.GetInfo()
+	c.record("getinfo", start, info, err)
+	return info, err
+}
+
+// GetNewAddress wraps the getnewaddress rpc
+func (c *rpcClient) GetNewAddress() (btcutil.Address, error) {
+	var s string
+	if ok, err := c.replay("getnewaddress", &s); ok {
+		return replayedAddress(s, err)
+	}
+	start := time.Now()
+	addr, err := c.calls.GetNewAddress()
+	c.record("getnewaddress", start, recordedAddress(addr), err)
+	return addr, err
+}
+
+// GetRawMempool wraps the getrawmempool rpc
+func (c *rpcClient) GetRawMempool() ([]*wire.ShaHash, error) {
+	var s []string
+	if ok, err := c.replay("getrawmempool", &s); ok {
+		return replayedHashes(s, err)
+	}
+	start := time.Now()
+	hashes, err := c.calls.GetRawMempool()
+	c.record("getrawmempool", start, recordedHashes(hashes), err)
+	return hashes, err
+}
+
+// GetRawMempoolVerbose wraps the verbose getrawmempool rpc, recorded
+// apart from the plain one as their results differ
+func (c *rpcClient) GetRawMempoolVerbose() (map[string]btcjson.GetRawMempoolVerboseResult, error) {
+	var mempool map[string]btcjson.GetRawMempoolVerboseResult
+	if ok, err := c.replay("getrawmempoolverbose", &mempool, true); ok {
+		return mempool, err
+	}
+	start := time.Now()
+	mempool, err := c.calls.GetRawMempoolVerbose()
+	c.stats.record(c.node, "getrawmempool", time.Since(start), err)
+	c.rec.record(c.node, "getrawmempoolverbose", []interface{}{true}, mempool, err)
+	c.trace.add(c.node, "getrawmempool", start, mempool, err, true)
+	return mempool, err
+}
+
+// GetTransaction wraps the gettransaction rpc
+func (c *rpcClient) GetTransaction(txHash *wire.ShaHash) (*btcjson.GetTransactionResult, error) {
+	var tx *btcjson.GetTransactionResult
+	if ok, err := c.replay("gettransaction", &tx, txHash); ok {
+		return tx, err
+	}
+	start := time.Now()
+	tx, err := c.calls.GetTransaction(txHash)
+	c.record("gettransaction", start, tx, err, txHash)
+	return tx, err
+}
+
+// GetTxOut wraps the gettxout rpc
+func (c *rpcClient) GetTxOut(txHash *wire.ShaHash, index uint32, mempool bool) (*btcjson.GetTxOutResult, error) {
+	var txOut *btcjson.GetTxOutResult
+	if ok, err := c.replay("gettxout", &txOut, txHash, index, mempool); ok {
+		return txOut, err
+	}
+	start := time.Now()
+	txOut, err := c.calls.GetTxOut(txHash, index, mempool)
+	c.record("gettxout", start, txOut, err, txHash, index, mempool)
+	return txOut, err
+}
+
+// futureGetTxOut is the result of an asynchronous gettxout call, whose
+// latency and result are recorded when it is received. When replaying, or
+// when the client has no rpc.Client to call asynchronously, the call is
+// only made when the result is received, so that it is replayed in order
+// with the other calls.
+type futureGetTxOut struct {
+	rpc.FutureGetTxOutResult
+	c     *rpcClient
+	start time.Time
+
+	// txHash, index and mempool are the arguments of the call
+	txHash  *wire.ShaHash
+	index   uint32
+	mempool bool
+}
+
+// Receive waits for the result of the call
+func (f futureGetTxOut) Receive() (*btcjson.GetTxOutResult, error) {
+	if f.FutureGetTxOutResult == nil {
+		return f.c.GetTxOut(f.txHash, f.index, f.mempool)
+	}
+	txOut, err := f.FutureGetTxOutResult.Receive()
+	f.c.record("gettxout", f.start, txOut, err, f.txHash, f.index, f.mempool)
+	return txOut, err
+}
+
+// GetTxOutAsync wraps the asynchronous gettxout rpc
+func (c *rpcClient) GetTxOutAsync(txHash *wire.ShaHash, index uint32, mempool bool) futureGetTxOut {
+	if c.rec.replaying() || c.Client == nil {
+		return futureGetTxOut{c: c, txHash: txHash, index: index, mempool: mempool}
+	}
+	return futureGetTxOut{
+		FutureGetTxOutResult: c.Client.GetTxOutAsync(txHash, index, mempool),
+		c:                    c,
+		start:                time.Now(),
+		txHash:               txHash,
+		index:                index,
+		mempool:              mempool,
+	}
+}
+
+// ImportPrivKeyRescan wraps the importprivkey rpc
+func (c *rpcClient) ImportPrivKeyRescan(privKeyWIF *btcutil.WIF, label string, rescan bool) error {
+	if ok, err := c.replay("importprivkey", nil, privKeyWIF, label, rescan); ok {
+		return err
+	}
+	start := time.Now()
+	err := c.calls.ImportPrivKeyRescan(privKeyWIF, label, rescan)
+	c.record("importprivkey", start, nil, err, privKeyWIF, label, rescan)
+	return err
+}
+
+// ListTransactionsCount wraps the listtransactions rpc
+func (c *rpcClient) ListTransactionsCount(account string, count int) ([]btcjson.ListTransactionsResult, error) {
+	var txs []btcjson.ListTransactionsResult
+	if ok, err := c.replay("listtransactions", &txs, account, count); ok {
+		return txs, err
+	}
+	start := time.Now()
+	txs, err := c.calls.ListTransactionsCount(account, count)
+	c.record("listtransactions", start, txs, err, account, count)
+	return txs, err
+}
+
+// ListUnspent wraps the listunspent rpc
+func (c *rpcClient) ListUnspent() ([]btcjson.ListUnspentResult, error) {
+	var unspent []btcjson.ListUnspentResult
+	if ok, err := c.replay("listunspent", &unspent); ok {
+		return unspent, err
+	}
+	start := time.Now()
+	unspent, err := c.calls.ListUnspent()
+	c.record("listunspent", start, unspent, err)
+	return unspent, err
+}
+
+// ListUnspentMinMax wraps the listunspent rpc with a range of
+// confirmations
+func (c *rpcClient) ListUnspentMinMax(minConf, maxConf int) ([]btcjson.ListUnspentResult, error) {
+	var unspent []btcjson.ListUnspentResult
+	if ok, err := c.replay("listunspent", &unspent, minConf, maxConf); ok {
+		return unspent, err
+	}
+	start := time.Now()
+	unspent, err := c.calls.ListUnspentMinMax(minConf, maxConf)
+	c.record("listunspent", start, unspent, err, minConf, maxConf)
+	return unspent, err
+}
+
+// SendRawTransaction wraps the sendrawtransaction rpc
+func (c *rpcClient) SendRawTransaction(tx *wire.MsgTx, allowHighFees bool) (*wire.ShaHash, error) {
+	var s string
+	if ok, err := c.replay("sendrawtransaction", &s, tx, allowHighFees); ok {
+		return replayedHash(s, err)
+	}
+	start := time.Now()
+	hash, err := c.calls.SendRawTransaction(tx, allowHighFees)
+	c.record("sendrawtransaction", start, recordedHash(hash), err, tx, allowHighFees)
+	return hash, err
+}
+
+// SendToAddress wraps the sendtoaddress rpc
+func (c *rpcClient) SendToAddress(address btcutil.Address, amount btcutil.Amount) (*wire.ShaHash, error) {
+	var s string
+	if ok, err := c.replay("sendtoaddress", &s, address, amount); ok {
+		return replayedHash(s, err)
+	}
+	start := time.Now()
+	hash, err := c.calls.SendToAddress(address, amount)
+	c.record("sendtoaddress", start, recordedHash(hash), err, address, amount)
+	return hash, err
+}
+
+// SetGenerate wraps the setgenerate rpc
+func (c *rpcClient) SetGenerate(enable bool, numCPUs int) error {
+	if ok, err := c.replay("setgenerate", nil, enable, numCPUs); ok {
+		return err
+	}
+	start := time.Now()
+	err := c.calls.SetGenerate(enable, numCPUs)
+	c.record("setgenerate", start, nil, err, enable, numCPUs)
+	return err
+}
+
+// SignRawTransaction wraps the signrawtransaction rpc
+func (c *rpcClient) SignRawTransaction(tx *wire.MsgTx) (*wire.MsgTx, bool, error) {
+	var s signedTx
+	if ok, err := c.replay("signrawtransaction", &s, tx); ok {
+		signed, err := replayedTx(s.Tx, err)
+		return signed, s.Complete, err
+	}
+	start := time.Now()
+	signed, complete, err := c.calls.SignRawTransaction(tx)
+	c.record("signrawtransaction", start, &signedTx{recordedTx(signed), complete}, err, tx)
+	return signed, complete, err
+}
+
+// SignRawTransaction2 wraps the signrawtransaction rpc with the outputs
+// spent
+func (c *rpcClient) SignRawTransaction2(tx *wire.MsgTx, inputs []btcjson.RawTxInput) (*wire.MsgTx, bool, error) {
+	var s signedTx
+	if ok, err := c.replay("signrawtransaction", &s, tx, inputs); ok {
+		signed, err := replayedTx(s.Tx, err)
+		return signed, s.Complete, err
+	}
+	start := time.Now()
+	signed, complete, err := c.calls.SignRawTransaction2(tx, inputs)
+	c.record("signrawtransaction", start, &signedTx{recordedTx(signed), complete}, err, tx, inputs)
+	return signed, complete, err
+}
+
+// SubmitBlock wraps the submitblock rpc
+func (c *rpcClient) SubmitBlock(block *btcutil.Block, options *btcjson.SubmitBlockOptions) error {
+	if ok, err := c.replay("submitblock", nil, block, options); ok {
+		return err
+	}
+	start := time.Now()
+	err := c.calls.SubmitBlock(block, options)
+	c.record("submitblock", start, nil, err, block, options)
+	return err
+}
+
+// ValidateAddress wraps the validateaddress rpc
+func (c *rpcClient) ValidateAddress(address btcutil.Address) (*btcjson.ValidateAddressWalletResult, error) {
+	res := new(btcjson.ValidateAddressWalletResult)
+	if ok, err := c.replay("validateaddress", res, address); ok {
+		return res, err
+	}
+	start := time.Now()
+	res, err := c.calls.ValidateAddress(address)
+	c.record("validateaddress", start, res, err, address)
+	return res, err
+}
+
+// WalletPassphrase wraps the walletpassphrase rpc
+func (c *rpcClient) WalletPassphrase(passphrase string, timeoutSecs int64) error {
+	if ok, err := c.replay("walletpassphrase", nil, passphrase, timeoutSecs); ok {
+		return err
+	}
+	start := time.Now()
+	err := c.calls.WalletPassphrase(passphrase, timeoutSecs)
+	c.record("walletpassphrase", start, nil, err, passphrase, timeoutSecs)
+	return err
+}
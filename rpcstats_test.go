@@ -0,0 +1,75 @@
+package btcsim
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRPCRecorder(t *testing.T) {
+	r := newRPCRecorder()
+	r.record("node", "getblockcount", 2*time.Millisecond, nil)
+	r.record("node", "getblockcount", 20*time.Millisecond, nil)
+	r.record("actor", "getblockcount", 10*time.Second, errors.New("timeout"))
+	r.record("actor", "sendtoaddress", 700*time.Microsecond, nil)
+
+	// a nil recorder records nothing
+	var disabled *rpcRecorder
+	disabled.record("node", "getblockcount", time.Millisecond, nil)
+
+	stats := r.Stats()
+	var got []string
+	for _, l := range stats {
+		got = append(got, l.Node+" "+l.Method)
+	}
+	want := []string{
+		"* getblockcount",
+		"* sendtoaddress",
+		"actor getblockcount",
+		"actor sendtoaddress",
+		"node getblockcount",
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("got stats %q want %q", got, want)
+	}
+
+	all := stats[0]
+	if all.Calls != 3 || all.Errors != 1 {
+		t.Errorf("got %d calls and %d errors want 3 and 1", all.Calls, all.Errors)
+	}
+	if all.Median != 20*time.Millisecond || all.Max != 10*time.Second {
+		t.Errorf("got median %v and max %v", all.Median, all.Max)
+	}
+	// 2ms, 20ms and 10s fall in the <=5ms, <=50ms and >5s buckets
+	histogram := []int{0, 1, 0, 1, 0, 0, 0, 0, 1}
+	for i, n := range histogram {
+		if all.Histogram[i] != n {
+			t.Errorf("got histogram %v want %v", all.Histogram, histogram)
+			break
+		}
+	}
+	if node := stats[4]; node.Mean != 11*time.Millisecond {
+		t.Errorf("got mean %v want 11ms", node.Mean)
+	}
+}
+
+func TestWriteRPCStatsCSV(t *testing.T) {
+	r := newRPCRecorder()
+	r.record("node", "getblock", 1500*time.Microsecond, nil)
+	var buf bytes.Buffer
+	if err := writeRPCStatsCSV(&buf, r.Stats()); err != nil {
+		t.Fatalf("writeRPCStatsCSV error: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("got %d lines want 3", len(lines))
+	}
+	if !strings.HasSuffix(lines[0], "latency<=5s,latency>5s") {
+		t.Errorf("got header %q", lines[0])
+	}
+	if want := "node,getblock,1,0,0.0015,0.0015,0.0015,0.0015,0,1,0,0,0,0,0,0,0"; lines[2] != want {
+		t.Errorf("got row %q want %q", lines[2], want)
+	}
+}
@@ -0,0 +1,198 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+// traceRequest is the request of a traced call, the rpc method with its
+// params as they are sent to the node
+type traceRequest struct {
+	Method string        `json:"method"`
+	Params []interface{} `json:"params"`
+}
+
+// traceResponse is the response to a traced call, its result in the form
+// it is recorded with -record or its error
+type traceResponse struct {
+	Result interface{} `json:"result,omitempty"`
+	Error  string      `json:"error,omitempty"`
+}
+
+// traceEntry is a traced call, shaped after the entries of an HTTP
+// Archive: the time it started and its duration in milliseconds, the node
+// it went to, the request and the response
+type traceEntry struct {
+	StartedDateTime time.Time     `json:"startedDateTime"`
+	Time            float64       `json:"time"`
+	Node            string        `json:"node"`
+	Request         traceRequest  `json:"request"`
+	Response        traceResponse `json:"response"`
+}
+
+const (
+	// traceHeader and traceFooter enclose the entries of a trace, which
+	// are written as the calls complete
+	traceHeader = `{"log":{"version":"1.2","creator":{"name":"btcsim"},"entries":[`
+	traceFooter = "\n]}}\n"
+)
+
+// errTraceClosed is the error of calls traced to a closed trace
+var errTraceClosed = errors.New("rpc trace closed")
+
+// rpcTrace writes the rpc calls of the run to a HAR-like JSON document,
+// whose log lists an entry per call
+type rpcTrace struct {
+	mtx     sync.Mutex
+	w       io.Writer
+	closer  io.Closer
+	entries int
+	err     error
+}
+
+// newRPCTrace returns a trace writing to w, which is closed with the trace
+// if it is an io.Closer
+func newRPCTrace(w io.Writer) (*rpcTrace, error) {
+	t := &rpcTrace{w: w}
+	if c, ok := w.(io.Closer); ok {
+		t.closer = c
+	}
+	if _, err := io.WriteString(w, traceHeader); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// createRPCTrace creates the file at path to trace the calls to
+func createRPCTrace(path string) (*rpcTrace, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	t, err := newRPCTrace(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return t, nil
+}
+
+// traceParam returns the param of an rpc call in the form it is sent to
+// the node
+func traceParam(param interface{}) interface{} {
+	switch p := param.(type) {
+	case btcutil.Address:
+		return recordedAddress(p)
+	case []btcutil.Address:
+		addrs := make([]string, len(p))
+		for i, addr := range p {
+			addrs[i] = recordedAddress(addr)
+		}
+		return addrs
+	case map[btcutil.Address]btcutil.Amount:
+		amounts := make(map[string]float64, len(p))
+		for addr, amt := range p {
+			amounts[recordedAddress(addr)] = amt.ToBTC()
+		}
+		return amounts
+	case btcutil.Amount:
+		return p.ToBTC()
+	case *wire.ShaHash:
+		return recordedHash(p)
+	case *wire.MsgTx:
+		return recordedTx(p)
+	case *btcutil.Block:
+		return recordedBlock(p)
+	case *btcutil.WIF:
+		return recordedWIF(p)
+	}
+	return param
+}
+
+// add traces a call of the method with the given params to the node,
+// started at the given time. It does nothing if t is nil. The trace stops
+// at the first write error, which is logged, so that it does not fail the
+// simulation.
+func (t *rpcTrace) add(node, method string, start time.Time, result interface{}, err error,
+	params ...interface{}) {
+
+	if t == nil {
+		return
+	}
+	e := &traceEntry{
+		StartedDateTime: start,
+		Time:            float64(time.Since(start)) / float64(time.Millisecond),
+		Node:            node,
+		Request: traceRequest{
+			Method: method,
+			Params: make([]interface{}, len(params)),
+		},
+	}
+	for i, p := range params {
+		e.Request.Params[i] = traceParam(p)
+	}
+	if err != nil {
+		e.Response.Error = err.Error()
+	} else {
+		e.Response.Result = result
+	}
+	b, merr := json.Marshal(e)
+	if merr != nil {
+		log.Errorf("Cannot trace %s to %s: %v", method, node, merr)
+		return
+	}
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	if t.err != nil {
+		return
+	}
+	sep := ",\n"
+	if t.entries == 0 {
+		sep = "\n"
+	}
+	if _, t.err = io.WriteString(t.w, sep+string(b)); t.err != nil {
+		log.Errorf("Cannot trace the rpc calls: %v", t.err)
+		return
+	}
+	t.entries++
+}
+
+// Entries returns the number of calls traced
+func (t *rpcTrace) Entries() int {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	return t.entries
+}
+
+// Close ends the document of the trace and closes the file it is written
+// to, the calls traced afterwards are dropped
+func (t *rpcTrace) Close() error {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	if t.err == errTraceClosed {
+		return nil
+	}
+	err := t.err
+	t.err = errTraceClosed
+	if err == nil {
+		_, err = io.WriteString(t.w, traceFooter)
+	}
+	if t.closer != nil {
+		if cerr := t.closer.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
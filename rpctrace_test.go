@@ -0,0 +1,101 @@
+package btcsim
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcutil"
+)
+
+func TestRPCTrace(t *testing.T) {
+	var b bytes.Buffer
+	trace, err := newRPCTrace(&b)
+	if err != nil {
+		t.Fatalf("newRPCTrace error: %v", err)
+	}
+	w := NewMockChain(DefaultConfig()).NewWallet()
+	client := w.client()
+	client.trace = trace
+
+	addr, _ := client.GetNewAddress()
+	hashes, _ := client.Generate(1)
+	if _, err := client.GetBlockHash(5); err == nil {
+		t.Fatalf("GetBlockHash of a missing block succeeded")
+	}
+	if _, err := client.SendToAddress(addr, 1e8); err != nil {
+		t.Fatalf("SendToAddress error: %v", err)
+	}
+	if err := trace.Close(); err != nil {
+		t.Fatalf("Close error: %v", err)
+	}
+	if err := trace.Close(); err != nil {
+		t.Errorf("second Close error: %v", err)
+	}
+	// calls after the trace is closed are dropped
+	client.GetBlockCount()
+
+	var har struct {
+		Log struct {
+			Entries []traceEntry `json:"entries"`
+		} `json:"log"`
+	}
+	if err := json.Unmarshal(b.Bytes(), &har); err != nil {
+		t.Fatalf("invalid trace %q: %v", b.String(), err)
+	}
+	entries := har.Log.Entries
+	if len(entries) != 4 || trace.Entries() != 4 {
+		t.Fatalf("%d entries traced (%d counted), want 4", len(entries), trace.Entries())
+	}
+	methods := []string{"getnewaddress", "generate", "getblockhash", "sendtoaddress"}
+	for i, e := range entries {
+		if e.Request.Method != methods[i] || e.Node != "mock-1" || e.StartedDateTime.IsZero() {
+			t.Errorf("entry %d: %+v, want a call of %s to mock-1", i, e, methods[i])
+		}
+	}
+	if got := entries[0].Response.Result; got != addr.EncodeAddress() {
+		t.Errorf("getnewaddress result %v, want %v", got, addr)
+	}
+	if got := entries[1].Response.Result.([]interface{}); len(got) != 1 || got[0] != hashes[0].String() {
+		t.Errorf("generate result %v, want %v", got, hashes)
+	}
+	if e := entries[2]; e.Response.Error == "" || e.Response.Result != nil {
+		t.Errorf("getblockhash response %+v, want an error", e.Response)
+	}
+	if got := entries[3].Request.Params; len(got) != 2 || got[0] != addr.EncodeAddress() || got[1] != 1.0 {
+		t.Errorf("sendtoaddress params %v, want the address and 1 BTC", got)
+	}
+}
+
+func TestTraceParam(t *testing.T) {
+	w := NewMockChain(DefaultConfig()).NewWallet()
+	addr, _ := w.GetNewAddress()
+	tests := []struct {
+		param, want interface{}
+	}{
+		{addr, addr.EncodeAddress()},
+		{btcutil.Amount(5e7), 0.5},
+		{7, 7},
+		{"account", "account"},
+	}
+	for _, test := range tests {
+		if got := traceParam(test.param); got != test.want {
+			t.Errorf("traceParam(%v) = %v, want %v", test.param, got, test.want)
+		}
+	}
+	amounts := traceParam(map[btcutil.Address]btcutil.Amount{addr: 1e8}).(map[string]float64)
+	if len(amounts) != 1 || amounts[addr.EncodeAddress()] != 1 {
+		t.Errorf("traceParam of amounts = %v", amounts)
+	}
+	addrs := traceParam([]btcutil.Address{addr}).([]string)
+	if len(addrs) != 1 || addrs[0] != addr.EncodeAddress() {
+		t.Errorf("traceParam of addresses = %v", addrs)
+	}
+}
+
+func TestNilRPCTrace(t *testing.T) {
+	var trace *rpcTrace
+	// a nil trace traces nothing
+	trace.add("node", "getblockcount", time.Now(), int64(1), nil)
+}
@@ -0,0 +1,387 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// runPortSpacing is the offset between the preferred ports of consecutive
+// runs, so that concurrent runs don't race for the same ports
+const runPortSpacing = 100
+
+// runFlags are the flags set by runSims for every run instead of being
+// passed through from the command line
+var runFlags = map[string]bool{
+	"runs":                true,
+	"parallel":            true,
+	"runid":               true,
+	"seed":                true,
+	"summary":             true,
+	"report":              true,
+	"txstats":             true,
+	"feestats":            true,
+	"blockstats":          true,
+	"latencystats":        true,
+	"feeaccuracy":         true,
+	"propagationstats":    true,
+	"mempoolstats":        true,
+	"resourcestats":       true,
+	"rpcstats":            true,
+	"invoicestats":        true,
+	"txgraph":             true,
+	"labels":              true,
+	"utxostats":           true,
+	"save-state":          true,
+	"events-out":          true,
+	"record":              true,
+	"rpc-trace":           true,
+	"profile":             true,
+	"blocksizes":          true,
+	"btcdversions":        true,
+	"sweepactors":         true,
+	"sweeptxrates":        true,
+	"sweepblockintervals": true,
+
+	// the flags implied by -scale are passed through instead
+	"scale": true,
+}
+
+// runFile returns path with the run ID inserted before its extension,
+// e.g. txs-2.csv for run 2 of txs.csv
+func runFile(path string, id int) string {
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s-%d%s", strings.TrimSuffix(path, ext), id, ext)
+}
+
+// runArgs returns the command line of the run with the given ID and seed,
+// passing through the flags of cfg except runFlags, followed by the extra
+// flags which override them
+func runArgs(cfg *Config, id int, seed int64, summary string, extra ...string) []string {
+	args := append(cfg.args(runFlags),
+		fmt.Sprintf("-runid=%d", id),
+		fmt.Sprintf("-seed=%d", seed),
+		fmt.Sprintf("-summary=%s", summary),
+		"-profile=")
+	if cfg.ReportPath != "" {
+		args = append(args, fmt.Sprintf("-report=%s", runFile(cfg.ReportPath, id)))
+	}
+	if cfg.TxStatsPath != "" {
+		args = append(args, fmt.Sprintf("-txstats=%s", runFile(cfg.TxStatsPath, id)))
+	}
+	if cfg.FeeStatsPath != "" {
+		args = append(args, fmt.Sprintf("-feestats=%s", runFile(cfg.FeeStatsPath, id)))
+	}
+	if cfg.BlockStatsPath != "" {
+		args = append(args, fmt.Sprintf("-blockstats=%s", runFile(cfg.BlockStatsPath, id)))
+	}
+	if cfg.LatencyStatsPath != "" {
+		args = append(args, fmt.Sprintf("-latencystats=%s", runFile(cfg.LatencyStatsPath, id)))
+	}
+	if cfg.FeeAccuracyPath != "" {
+		args = append(args, fmt.Sprintf("-feeaccuracy=%s", runFile(cfg.FeeAccuracyPath, id)))
+	}
+	if cfg.PropagationStatsPath != "" {
+		args = append(args, fmt.Sprintf("-propagationstats=%s", runFile(cfg.PropagationStatsPath, id)))
+	}
+	if cfg.MempoolStatsPath != "" {
+		args = append(args, fmt.Sprintf("-mempoolstats=%s", runFile(cfg.MempoolStatsPath, id)))
+	}
+	if cfg.ResourceStatsPath != "" {
+		args = append(args, fmt.Sprintf("-resourcestats=%s", runFile(cfg.ResourceStatsPath, id)))
+	}
+	if cfg.RPCStatsPath != "" {
+		args = append(args, fmt.Sprintf("-rpcstats=%s", runFile(cfg.RPCStatsPath, id)))
+	}
+	if cfg.InvoiceStatsPath != "" {
+		args = append(args, fmt.Sprintf("-invoicestats=%s", runFile(cfg.InvoiceStatsPath, id)))
+	}
+	if cfg.TxGraphPath != "" {
+		args = append(args, fmt.Sprintf("-txgraph=%s", runFile(cfg.TxGraphPath, id)))
+	}
+	if cfg.LabelsPath != "" {
+		args = append(args, fmt.Sprintf("-labels=%s", runFile(cfg.LabelsPath, id)))
+	}
+	if cfg.UtxoStatsPath != "" {
+		args = append(args, fmt.Sprintf("-utxostats=%s", runFile(cfg.UtxoStatsPath, id)))
+	}
+	if cfg.AuditPath != "" {
+		args = append(args, fmt.Sprintf("-audit=%s", runFile(cfg.AuditPath, id)))
+	}
+	if cfg.ResultsPath != "" {
+		args = append(args, fmt.Sprintf("-results=%s", runFile(cfg.ResultsPath, id)))
+	}
+	if cfg.SaveStatePath != "" {
+		args = append(args, fmt.Sprintf("-save-state=%s", runFile(cfg.SaveStatePath, id)))
+	}
+	if cfg.RecordPath != "" {
+		args = append(args, fmt.Sprintf("-record=%s", runFile(cfg.RecordPath, id)))
+	}
+	if cfg.RPCTracePath != "" {
+		args = append(args, fmt.Sprintf("-rpc-trace=%s", runFile(cfg.RPCTracePath, id)))
+	}
+	switch cfg.EventsOutPath {
+	case "":
+	case "-":
+		// the runs share the standard output, their events are told
+		// apart by their run ID
+		args = append(args, "-events-out=-")
+	default:
+		args = append(args, fmt.Sprintf("-events-out=%s", runFile(cfg.EventsOutPath, id)))
+	}
+	return append(args, extra...)
+}
+
+// checkRuns returns an error if n runs configured by cfg cannot be started
+func checkRuns(cfg *Config, n int, parallel bool) error {
+	if n < 1 {
+		return errors.New("at least one run is required")
+	}
+	if parallel && cfg.ControlAddr != "" {
+		return errors.New("the control API cannot be used with parallel runs")
+	}
+	if parallel && cfg.DashboardAddr != "" {
+		return errors.New("the dashboard cannot be used with parallel runs")
+	}
+	if n > 1 && cfg.ReplayPath != "" {
+		return errors.New("a recorded run can only be replayed alone")
+	}
+	if parallel && cfg.TUI {
+		return errors.New("the terminal UI cannot be used with parallel runs")
+	}
+	return nil
+}
+
+// runSims runs n independent simulations, one after the other or all at
+// once if parallel is set, as child processes of this one. Run i uses the
+// seed plus i, and the summaries of the runs are aggregated once they
+// have all finished
+func runSims(cfg *Config, n int, parallel bool) error {
+	if err := checkRuns(cfg, n, parallel); err != nil {
+		return err
+	}
+
+	summaries, code := runBatch(cfg, 1, n, parallel)
+	agg := NewAggregate(summaries)
+	log.Infof("Aggregate summary:")
+	agg.Write(os.Stdout)
+	if cfg.SummaryPath != "" {
+		if err := writeJSON(cfg.SummaryPath, agg); err != nil {
+			log.Errorf("Cannot write aggregate summary: %v", err)
+			return err
+		}
+	}
+	if agg.Failed == n {
+		return errors.New("all runs failed")
+	}
+	return batchError(code)
+}
+
+// runExitCode returns the exit code of a run which returned err
+func runExitCode(err error) int {
+	if err == nil {
+		return exitPass
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() > 0 {
+		return exitErr.ExitCode()
+	}
+	return exitError
+}
+
+// batchError returns the error of runs the highest exit code of which is
+// code, nil if they all passed
+func batchError(code int) error {
+	if code == exitPass {
+		return nil
+	}
+	return &checkError{code, fmt.Sprintf("runs failed with exit code up to %d", code)}
+}
+
+// runBatch runs n simulations configured by cfg with IDs starting at first,
+// where the i-th one uses the seed plus i, passing them the extra flags. It
+// returns
+// their summaries once they have all finished, nil for runs which could
+// not run, and the highest exit code of the runs.
+func runBatch(cfg *Config, first, n int, parallel bool, extra ...string) ([]*Summary, int) {
+	summaries := make([]*Summary, n)
+	codes := make([]int, n)
+	run := func(i int) {
+		id := first + i - 1
+		path := cfg.runPath(fmt.Sprintf("summary-%d.json", id))
+		log.Infof("Starting run %d of %d...", id, first+n-1)
+		cmd := exec.Command(os.Args[0], runArgs(cfg, id, cfg.Seed+int64(i), path, extra...)...)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		err := cmd.Run()
+		code := runExitCode(err)
+		codes[i-1] = code
+		switch code {
+		case exitPass:
+		case exitAssertion, exitCheck:
+			// the run failed a check after writing its summary
+			log.Errorf("Run %d failed its checks: %v", id, err)
+		default:
+			log.Errorf("Run %d failed: %v", id, err)
+			return
+		}
+		s, err := readSummary(path)
+		if err != nil {
+			log.Errorf("Cannot read summary of run %d: %v", id, err)
+			if code == exitPass {
+				codes[i-1] = exitError
+			}
+			return
+		}
+		summaries[i-1] = s
+	}
+
+	var wg sync.WaitGroup
+	for i := 1; i <= n; i++ {
+		if !parallel {
+			run(i)
+			continue
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			run(i)
+		}(i)
+	}
+	wg.Wait()
+	code := exitPass
+	for _, c := range codes {
+		if c > code {
+			code = c
+		}
+	}
+	return summaries, code
+}
+
+// readSummary reads a JSON summary written by writeSummary
+func readSummary(path string) (*Summary, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	s := &Summary{}
+	if err := json.NewDecoder(file).Decode(s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Metric is the mean, minimum and maximum of a value across runs
+type Metric struct {
+	Mean float64 `json:"mean"`
+	Min  float64 `json:"min"`
+	Max  float64 `json:"max"`
+}
+
+// newMetric returns the metric of the given values
+func newMetric(values []float64) Metric {
+	if len(values) == 0 {
+		return Metric{}
+	}
+	m := Metric{Min: math.Inf(1), Max: math.Inf(-1)}
+	for _, v := range values {
+		m.Mean += v
+		m.Min = math.Min(m.Min, v)
+		m.Max = math.Max(m.Max, v)
+	}
+	m.Mean /= float64(len(values))
+	return m
+}
+
+// Aggregate aggregates the summaries of several simulation runs
+type Aggregate struct {
+	Runs         int        `json:"runs"`
+	Failed       int        `json:"failed"`
+	Blocks       Metric     `json:"blocks"`
+	Transactions Metric     `json:"transactions"`
+	Confirmed    Metric     `json:"confirmed"`
+	MeanConfTime Metric     `json:"meanconftime"`
+	P95ConfTime  Metric     `json:"p95conftime"`
+	MaxMempool   Metric     `json:"maxmempool"`
+	TPS          Metric     `json:"tps"`
+	FeeRate      Metric     `json:"feerate"`
+	Summaries    []*Summary `json:"summaries"`
+}
+
+// NewAggregate returns the aggregate of the given summaries, nil
+// summaries are counted as failed runs. Confirmation times are in seconds
+func NewAggregate(summaries []*Summary) *Aggregate {
+	a := &Aggregate{Runs: len(summaries)}
+	var blocks, txs, confirmed, meanConf, p95Conf, mempool, tps, feeRate []float64
+	for _, s := range summaries {
+		if s == nil {
+			a.Failed++
+			continue
+		}
+		a.Summaries = append(a.Summaries, s)
+		blocks = append(blocks, float64(s.Blocks))
+		txs = append(txs, float64(s.Transactions))
+		confirmed = append(confirmed, float64(s.Confirmed))
+		meanConf = append(meanConf, s.MeanConfTime.Seconds())
+		p95Conf = append(p95Conf, s.P95ConfTime.Seconds())
+		mempool = append(mempool, float64(s.MaxMempool))
+		tps = append(tps, s.TPS)
+		feeRate = append(feeRate, s.MedianFeeRate)
+	}
+	a.Blocks = newMetric(blocks)
+	a.Transactions = newMetric(txs)
+	a.Confirmed = newMetric(confirmed)
+	a.MeanConfTime = newMetric(meanConf)
+	a.P95ConfTime = newMetric(p95Conf)
+	a.MaxMempool = newMetric(mempool)
+	a.TPS = newMetric(tps)
+	a.FeeRate = newMetric(feeRate)
+	return a
+}
+
+// Write writes a human readable form of the aggregate to w
+func (a *Aggregate) Write(w io.Writer) error {
+	lines := []string{
+		fmt.Sprintf("Runs: %d (%d failed)", a.Runs, a.Failed),
+	}
+	metrics := []struct {
+		name string
+		m    Metric
+		unit string
+	}{
+		{"Blocks mined", a.Blocks, ""},
+		{"Transactions sent", a.Transactions, ""},
+		{"Transactions confirmed", a.Confirmed, ""},
+		{"Mean confirmation time", a.MeanConfTime, "s"},
+		{"95th percentile confirmation time", a.P95ConfTime, "s"},
+		{"Mempool high-water mark", a.MaxMempool, ""},
+		{"Average transactions per sec", a.TPS, ""},
+		{"Median fee rate", a.FeeRate, " sat/B"},
+	}
+	for _, m := range metrics {
+		lines = append(lines, fmt.Sprintf("%s: mean %.2f%s, min %.2f%s, max %.2f%s",
+			m.name, m.m.Mean, m.unit, m.m.Min, m.unit, m.m.Max, m.unit))
+	}
+	for _, s := range a.Summaries {
+		lines = append(lines, fmt.Sprintf("Run %d: seed %d, %d transactions, %.2f tx/s, wall time %v",
+			s.RunID, s.Seed, s.Transactions, s.TPS, s.WallTime))
+	}
+
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
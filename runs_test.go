@@ -0,0 +1,83 @@
+package btcsim
+
+import (
+	"bytes"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunFile(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"txs.csv", "txs-2.csv"},
+		{"out/stats.json", "out/stats-2.json"},
+		{"stats", "stats-2"},
+	}
+	for _, test := range tests {
+		if got := runFile(test.path, 2); got != test.want {
+			t.Errorf("runFile(%q) got %q, want %q", test.path, got, test.want)
+		}
+	}
+}
+
+func TestNewAggregate(t *testing.T) {
+	summaries := []*Summary{
+		{RunID: 1, Transactions: 10, TPS: 1, MeanConfTime: 2 * time.Second},
+		nil,
+		{RunID: 3, Transactions: 30, TPS: 3, MeanConfTime: 4 * time.Second},
+	}
+	a := NewAggregate(summaries)
+	if a.Runs != 3 || a.Failed != 1 || len(a.Summaries) != 2 {
+		t.Fatalf("got %d runs, %d failed, %d summaries, want 3, 1, 2",
+			a.Runs, a.Failed, len(a.Summaries))
+	}
+	if a.Transactions != (Metric{Mean: 20, Min: 10, Max: 30}) {
+		t.Errorf("transactions got %+v", a.Transactions)
+	}
+	if a.TPS != (Metric{Mean: 2, Min: 1, Max: 3}) {
+		t.Errorf("tps got %+v", a.TPS)
+	}
+	if a.MeanConfTime != (Metric{Mean: 3, Min: 2, Max: 4}) {
+		t.Errorf("mean confirmation time got %+v", a.MeanConfTime)
+	}
+
+	var buf bytes.Buffer
+	if err := a.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Runs: 3 (1 failed)", "Transactions sent: mean 20.00", "Run 3: seed 0"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("aggregate output missing %q:\n%s", want, buf.String())
+		}
+	}
+
+	if a := NewAggregate([]*Summary{nil}); a.TPS != (Metric{}) {
+		t.Errorf("tps of failed runs got %+v, want zero", a.TPS)
+	}
+}
+
+func TestRunExitCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{nil, exitPass},
+		{exec.Command("sh", "-c", "exit 3").Run(), exitCheck},
+		{exec.Command("btcsim-missing").Run(), exitError},
+	}
+	for _, test := range tests {
+		if got := runExitCode(test.err); got != test.want {
+			t.Errorf("runExitCode(%v) got %d want %d", test.err, got, test.want)
+		}
+	}
+	if err := batchError(exitPass); err != nil {
+		t.Errorf("batchError of passing runs got %v", err)
+	}
+	if code := exitCode(batchError(exitAssertion)); code != exitAssertion {
+		t.Errorf("exit code of runs failing an assertion got %d want %d", code, exitAssertion)
+	}
+}
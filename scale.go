@@ -0,0 +1,135 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"flag"
+	"sync"
+	"time"
+
+	rpc "github.com/btcsuite/btcrpcclient"
+)
+
+// scaleDefaults are the flags set by -scale unless they are given
+// explicitly, so that thousands of actors fit on a single host
+var scaleDefaults = map[string]string{
+	"inprocess":    "true",
+	"rpcconns":     "8",
+	"startrate":    "50",
+	"maxaddresses": "10",
+}
+
+// applyScaleMode sets the flags of the high-scale mode which were not set
+// on the command line with fs
+func applyScaleMode(fs *flag.FlagSet) error {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	for name, value := range scaleFlags(set) {
+		if err := fs.Set(name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scaleFlags returns the values of the flags of the high-scale mode
+// except those already set
+func scaleFlags(set map[string]bool) map[string]string {
+	values := make(map[string]string)
+	for name, value := range scaleDefaults {
+		if !set[name] {
+			values[name] = value
+		}
+	}
+	return values
+}
+
+// startInterval returns the interval between the start of two actors to
+// start rate actors per second, 0 if the rate is not limited
+func startInterval(rate float64) time.Duration {
+	if rate <= 0 {
+		return 0
+	}
+	return time.Duration(float64(time.Second) / rate)
+}
+
+// clientPool is a pool of rpc clients connected to a chain server, shared
+// by the in-process wallets of the actors connected to it instead of a
+// connection each
+type clientPool struct {
+	mtx     sync.Mutex
+	cfg     *Config
+	node    string
+	conf    rpc.ConnConfig
+	size    int
+	clients []*rpcClient
+	next    int
+}
+
+// newClientPool returns a pool of up to size clients connecting with conf
+// to the named node of the run configured by cfg
+func newClientPool(cfg *Config, node string, conf rpc.ConnConfig, size int) *clientPool {
+	return &clientPool{cfg: cfg, node: node, conf: conf, size: size}
+}
+
+// get returns a client of the pool, new clients are connected until the
+// pool is full, the existing ones are then handed out in turn
+func (p *clientPool) get() (*rpcClient, error) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if len(p.clients) < p.size {
+		var client *rpc.Client
+		var err error
+		for i := 0; i < p.cfg.MaxConnRetries; i++ {
+			if client, err = rpc.New(&p.conf, nil); err != nil {
+				time.Sleep(time.Duration(i) * 50 * time.Millisecond)
+				continue
+			}
+			break
+		}
+		if client == nil {
+			return nil, ErrConnectionTimeOut
+		}
+		c := newRPCClient(p.cfg, client, p.node)
+		p.clients = append(p.clients, c)
+		return c, nil
+	}
+	client := p.clients[p.next]
+	p.next = (p.next + 1) % len(p.clients)
+	return client, nil
+}
+
+// Shutdown disconnects the clients of the pool
+func (p *clientPool) Shutdown() {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	for _, client := range p.clients {
+		client.Shutdown()
+	}
+	p.clients = nil
+}
+
+// indexActor indexes the addresses of the actor so that the owners of the
+// outputs of blocks are found without going through every actor
+func (com *Communication) indexActor(a *Actor) {
+	com.ownersMtx.Lock()
+	for _, addr := range a.ownedAddresses {
+		if addr != nil {
+			com.owners[addr.String()] = a
+		}
+	}
+	com.ownersMtx.Unlock()
+}
+
+// owner returns the indexed actor owning the address, if any
+func (com *Communication) owner(addr string) (*Actor, bool) {
+	com.ownersMtx.RLock()
+	a, ok := com.owners[addr]
+	com.ownersMtx.RUnlock()
+	return a, ok
+}
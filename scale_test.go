@@ -0,0 +1,90 @@
+package btcsim
+
+import (
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcutil"
+)
+
+func TestScaleFlags(t *testing.T) {
+	values := scaleFlags(map[string]bool{"startrate": true})
+	if len(values) != len(scaleDefaults)-1 {
+		t.Errorf("got %d flags, want %d", len(values), len(scaleDefaults)-1)
+	}
+	if _, ok := values["startrate"]; ok {
+		t.Errorf("flag set on the command line is overridden")
+	}
+	if values["inprocess"] != "true" {
+		t.Errorf("got inprocess %q, want true", values["inprocess"])
+	}
+	// every default is a known flag
+	fs := DefaultConfig().flagSet()
+	for name := range scaleDefaults {
+		if fs.Lookup(name) == nil {
+			t.Errorf("unknown flag %s", name)
+		}
+	}
+}
+
+func TestStartInterval(t *testing.T) {
+	tests := []struct {
+		rate float64
+		want time.Duration
+	}{
+		{0, 0},
+		{-1, 0},
+		{1, time.Second},
+		{50, 20 * time.Millisecond},
+	}
+	for _, test := range tests {
+		if got := startInterval(test.rate); got != test.want {
+			t.Errorf("startInterval(%v) got %v, want %v", test.rate, got, test.want)
+		}
+	}
+}
+
+func TestIndexActor(t *testing.T) {
+	com := &Communication{owners: make(map[string]*Actor)}
+	a := &Actor{ownedAddresses: []btcutil.Address{fakeAddress("a"), fakeAddress("b"), nil}}
+	com.indexActor(a)
+	for _, addr := range []string{"a", "b"} {
+		if owner, ok := com.owner(addr); !ok || owner != a {
+			t.Errorf("address %s got owner %v", addr, owner)
+		}
+	}
+	if _, ok := com.owner("c"); ok {
+		t.Errorf("unknown address has an owner")
+	}
+}
+
+func TestScaleActorPorts(t *testing.T) {
+	cfg, err := parseArgs([]string{"-scale", "-actors=1000"})
+	if err != nil {
+		t.Fatalf("parseArgs error: %v", err)
+	}
+	node, err := NewNodeFromArgs(cfg, &fakeArgs{name: "node"}, nil, nil)
+	if err != nil {
+		t.Fatalf("NewNodeFromArgs error: %v", err)
+	}
+
+	ports.mtx.Lock()
+	used := len(ports.used)
+	ports.mtx.Unlock()
+	names := make(map[string]bool)
+	for i := 0; i < cfg.totalActors(); i++ {
+		a, err := newIndexedActor(node, i)
+		if err != nil {
+			t.Fatalf("actor %d: %v", i, err)
+		}
+		names[a.String()] = true
+	}
+	if len(names) != 1000 {
+		t.Errorf("got %d actor names, want 1000", len(names))
+	}
+	ports.mtx.Lock()
+	defer ports.mtx.Unlock()
+	if len(ports.used) != used {
+		t.Errorf("allocated %d ports for the actors", len(ports.used)-used)
+	}
+}
@@ -0,0 +1,495 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcutil"
+)
+
+// A scenario is a list of timed events, one per line, triggered either at a
+// block height, after a duration since the start of the simulation or at
+// its end:
+//
+//   # comments and blank lines are ignored
+//   at block 100 actor 3 sends 50 to actor 7
+//   at 5m mine 6 blocks
+//   at block 150 partition 0 1 | 2 3
+//   at block 180 heal
+//   at block 190 reorg 3
+//   at block 200 stop
+//   at 30m phase stress actors 50 txrate 20 blockinterval 30s
+//   at end assert reorg depth == 3
+//
+// Amounts are in BTC, actors and nodes are referred to by their index,
+// starting at 0. A partition cuts the links between the groups of nodes
+// separated by '|', the nodes not listed forming a group of their own,
+// until the network is healed. A reorg replaces the given number of blocks
+// at the tip of the chain with a longer chain mined in isolation. The
+// phases changing the parameters of the simulation are described in
+// phase.go and the assertions checking it in assert.go.
+
+// scenarioAction is an action run when a scenario event is triggered
+type scenarioAction interface {
+	run(sc *Scenario) error
+	fmt.Stringer
+}
+
+// scenarioEvent is an action triggered at a block height or time
+type scenarioEvent struct {
+	line    int
+	atBlock bool
+	atEnd   bool
+	height  int32
+	after   time.Duration
+	action  scenarioAction
+
+	// triggered is set once the event ran, guarded by the assertMtx of
+	// the scenario
+	triggered bool
+}
+
+// String returns a printable description of the event
+func (e *scenarioEvent) String() string {
+	if e.atBlock {
+		return fmt.Sprintf("line %d: at block %d %s", e.line, e.height, e.action)
+	}
+	if e.atEnd {
+		return fmt.Sprintf("line %d: at end %s", e.line, e.action)
+	}
+	return fmt.Sprintf("line %d: at %v %s", e.line, e.after, e.action)
+}
+
+// Scenario holds the events of a scenario and the simulation components
+// they act on
+type Scenario struct {
+	blockEvents []*scenarioEvent
+	timeEvents  []*scenarioEvent
+	endEvents   []*scenarioEvent
+
+	com    *Communication
+	actors []*Actor
+	miner  *Miner
+
+	// links are the links between the numNodes nodes of the simulation
+	links    []*link
+	numNodes int
+
+	// fork mines the competing chains of reorgs on node
+	fork *forkMiner
+	node *Node
+
+	// assertions is the number of assertions checked and failures the
+	// description of those which failed, depth is the number of blocks
+	// disconnected by the ongoing reorg and maxDepth by the deepest one
+	assertMtx  sync.Mutex
+	assertions int
+	failures   []string
+	depth      int
+	maxDepth   int
+}
+
+// actionParsers maps the first word of an action to the function parsing it
+var actionParsers = map[string]func(args []string) (scenarioAction, error){
+	"actor": parseSendAction,
+	"mine":  parseMineAction,
+	"stop":  parseStopAction,
+
+	"partition": parsePartitionAction,
+	"heal":      parseHealAction,
+	"reorg":     parseReorgAction,
+
+	"phase": parsePhaseAction,
+
+	"assert": parseAssertAction,
+}
+
+// readScenario reads a scenario from r
+func readScenario(r io.Reader) (*Scenario, error) {
+	sc := &Scenario{}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if fields[0] == "assert" {
+			fields = assertLine(fields)
+		}
+		e, err := parseScenarioEvent(fields)
+		if err != nil {
+			return nil, fmt.Errorf("scenario line %d: %v", line, err)
+		}
+		e.line = line
+		switch {
+		case e.atBlock:
+			sc.blockEvents = append(sc.blockEvents, e)
+		case e.atEnd:
+			sc.endEvents = append(sc.endEvents, e)
+		default:
+			sc.timeEvents = append(sc.timeEvents, e)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	// sort events by trigger, keeping the file order of simultaneous events
+	sort.Stable(eventsByHeight(sc.blockEvents))
+	sort.Stable(eventsByTime(sc.timeEvents))
+	return sc, nil
+}
+
+// loadScenario reads a scenario from the file at path
+func loadScenario(path string) (*Scenario, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return readScenario(file)
+}
+
+// parseScenarioEvent parses the fields of an event line
+func parseScenarioEvent(fields []string) (*scenarioEvent, error) {
+	if len(fields) < 3 || fields[0] != "at" {
+		return nil, fmt.Errorf("expected 'at block <height> <action>', 'at <duration> <action>' or 'at end <action>'")
+	}
+	e := &scenarioEvent{}
+	fields = fields[1:]
+	if fields[0] == "end" {
+		e.atEnd = true
+		fields = fields[1:]
+	} else if fields[0] == "block" {
+		height, err := strconv.ParseInt(fields[1], 10, 32)
+		if err != nil || height < 0 {
+			return nil, fmt.Errorf("invalid block height %q", fields[1])
+		}
+		e.atBlock = true
+		e.height = int32(height)
+		fields = fields[2:]
+	} else {
+		after, err := time.ParseDuration(fields[0])
+		if err != nil || after < 0 {
+			return nil, fmt.Errorf("invalid duration %q", fields[0])
+		}
+		e.after = after
+		fields = fields[1:]
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("missing action")
+	}
+	parse, ok := actionParsers[fields[0]]
+	if !ok {
+		return nil, fmt.Errorf("unknown action %q", fields[0])
+	}
+	action, err := parse(fields[1:])
+	if err != nil {
+		return nil, err
+	}
+	e.action = action
+	return e, nil
+}
+
+// eventsByHeight sorts block events by height
+type eventsByHeight []*scenarioEvent
+
+func (e eventsByHeight) Len() int           { return len(e) }
+func (e eventsByHeight) Less(i, j int) bool { return e[i].height < e[j].height }
+func (e eventsByHeight) Swap(i, j int)      { e[i], e[j] = e[j], e[i] }
+
+// eventsByTime sorts time events by duration
+type eventsByTime []*scenarioEvent
+
+func (e eventsByTime) Len() int           { return len(e) }
+func (e eventsByTime) Less(i, j int) bool { return e[i].after < e[j].after }
+func (e eventsByTime) Swap(i, j int)      { e[i], e[j] = e[j], e[i] }
+
+// run runs as a goroutine and triggers the scenario events as blocks are
+// connected and simulated time passes, until exit is closed
+func (sc *Scenario) run(heights <-chan int32, exit <-chan struct{}) {
+	start := time.Now()
+	blockEvents, timeEvents := sc.blockEvents, sc.timeEvents
+	for {
+		var timer <-chan time.Time
+		if len(timeEvents) > 0 {
+			timer = time.After(sc.com.cfg.realDuration(timeEvents[0].after) - time.Since(start))
+		}
+		select {
+		case h := <-heights:
+			for len(blockEvents) > 0 && blockEvents[0].height <= h {
+				sc.trigger(blockEvents[0])
+				blockEvents = blockEvents[1:]
+			}
+		case <-timer:
+			for len(timeEvents) > 0 && timeEvents[0].after <= sc.com.cfg.simDuration(time.Since(start)) {
+				sc.trigger(timeEvents[0])
+				timeEvents = timeEvents[1:]
+			}
+		case <-exit:
+			return
+		}
+	}
+}
+
+// trigger runs the action of an event
+func (sc *Scenario) trigger(e *scenarioEvent) {
+	sc.assertMtx.Lock()
+	e.triggered = true
+	sc.assertMtx.Unlock()
+	log.Infof("Scenario %s", e)
+	if err := e.action.run(sc); err != nil {
+		log.Errorf("Scenario %s failed: %v", e, err)
+	}
+}
+
+// actor returns the actor with the given index
+func (sc *Scenario) actor(i int) (*Actor, error) {
+	if i < 0 || i >= len(sc.actors) {
+		return nil, fmt.Errorf("no actor with index %d", i)
+	}
+	return sc.actors[i], nil
+}
+
+// sendAction sends an amount from an actor to another
+type sendAction struct {
+	from, to int
+	amount   btcutil.Amount
+}
+
+// parseSendAction parses 'actor <i> sends <amount> to actor <j>'
+func parseSendAction(args []string) (scenarioAction, error) {
+	if len(args) != 6 || args[1] != "sends" || args[3] != "to" || args[4] != "actor" {
+		return nil, fmt.Errorf("expected 'actor <i> sends <amount> to actor <j>'")
+	}
+	from, err := strconv.Atoi(args[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid actor index %q", args[0])
+	}
+	to, err := strconv.Atoi(args[5])
+	if err != nil {
+		return nil, fmt.Errorf("invalid actor index %q", args[5])
+	}
+	btc, err := strconv.ParseFloat(args[2], 64)
+	if err != nil || btc <= 0 {
+		return nil, fmt.Errorf("invalid amount %q", args[2])
+	}
+	amount, err := btcutil.NewAmount(btc)
+	if err != nil {
+		return nil, err
+	}
+	return &sendAction{from: from, to: to, amount: amount}, nil
+}
+
+func (a *sendAction) String() string {
+	return fmt.Sprintf("actor %d sends %v to actor %d", a.from, a.amount, a.to)
+}
+
+func (a *sendAction) run(sc *Scenario) error {
+	from, err := sc.actor(a.from)
+	if err != nil {
+		return err
+	}
+	to, err := sc.actor(a.to)
+	if err != nil {
+		return err
+	}
+	if from.wallet != nil {
+		return errors.New("sending needs a btcwallet actor")
+	}
+	addr := to.ownedAddresses[from.rand.Int()%len(to.ownedAddresses)]
+	hash, err := from.client.SendToAddress(addr, a.amount)
+	if err != nil {
+		return err
+	}
+	log.Infof("%s: Sent %v to %s in %v", from, a.amount, to, hash)
+	return nil
+}
+
+// mineAction mines a number of blocks
+type mineAction struct {
+	blocks uint32
+}
+
+// parseMineAction parses 'mine <n> blocks'
+func parseMineAction(args []string) (scenarioAction, error) {
+	if len(args) != 2 || (args[1] != "blocks" && args[1] != "block") {
+		return nil, fmt.Errorf("expected 'mine <n> blocks'")
+	}
+	n, err := strconv.ParseUint(args[0], 10, 32)
+	if err != nil || n == 0 {
+		return nil, fmt.Errorf("invalid number of blocks %q", args[0])
+	}
+	return &mineAction{blocks: uint32(n)}, nil
+}
+
+func (a *mineAction) String() string {
+	return fmt.Sprintf("mine %d blocks", a.blocks)
+}
+
+func (a *mineAction) run(sc *Scenario) error {
+	return sc.miner.Generate(a.blocks)
+}
+
+// stopAction stops the simulation
+type stopAction struct{}
+
+// parseStopAction parses 'stop'
+func parseStopAction(args []string) (scenarioAction, error) {
+	if len(args) != 0 {
+		return nil, fmt.Errorf("expected 'stop'")
+	}
+	return &stopAction{}, nil
+}
+
+func (a *stopAction) String() string {
+	return "stop"
+}
+
+func (a *stopAction) run(sc *Scenario) error {
+	sc.com.Exit()
+	return nil
+}
+
+// actions returns the actions of every event of the scenario
+func (sc *Scenario) actions() []scenarioAction {
+	var actions []scenarioAction
+	for _, events := range [][]*scenarioEvent{sc.blockEvents, sc.timeEvents, sc.endEvents} {
+		for _, e := range events {
+			actions = append(actions, e.action)
+		}
+	}
+	return actions
+}
+
+// partitions reports whether the scenario partitions the network
+func (sc *Scenario) partitions() bool {
+	for _, a := range sc.actions() {
+		if _, ok := a.(*partitionAction); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// reorgs reports whether the scenario forces reorgs
+func (sc *Scenario) reorgs() bool {
+	for _, a := range sc.actions() {
+		if _, ok := a.(*reorgAction); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// partitionAction cuts the links between groups of nodes
+type partitionAction struct {
+	groups [][]int
+}
+
+// parsePartitionAction parses 'partition <node>... [| <node>...]...'
+func parsePartitionAction(args []string) (scenarioAction, error) {
+	a := &partitionAction{}
+	var group []int
+	for _, arg := range append(args, "|") {
+		if arg == "|" {
+			if len(group) == 0 {
+				return nil, fmt.Errorf("expected 'partition <node>... [| <node>...]...'")
+			}
+			a.groups = append(a.groups, group)
+			group = nil
+			continue
+		}
+		n, err := strconv.Atoi(arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid node %q", arg)
+		}
+		group = append(group, n)
+	}
+	if err := checkGroups(a.groups, math.MaxInt32); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *partitionAction) String() string {
+	groups := make([]string, len(a.groups))
+	for i, group := range a.groups {
+		nodes := make([]string, len(group))
+		for j, n := range group {
+			nodes[j] = strconv.Itoa(n)
+		}
+		groups[i] = strings.Join(nodes, " ")
+	}
+	return "partition " + strings.Join(groups, " | ")
+}
+
+func (a *partitionAction) run(sc *Scenario) error {
+	if err := checkGroups(a.groups, sc.numNodes); err != nil {
+		return err
+	}
+	partition(sc.links, a.groups)
+	return nil
+}
+
+// healAction restores every link between nodes
+type healAction struct{}
+
+// parseHealAction parses 'heal'
+func parseHealAction(args []string) (scenarioAction, error) {
+	if len(args) != 0 {
+		return nil, fmt.Errorf("expected 'heal'")
+	}
+	return &healAction{}, nil
+}
+
+func (a *healAction) String() string {
+	return "heal"
+}
+
+func (a *healAction) run(sc *Scenario) error {
+	heal(sc.links)
+	return nil
+}
+
+// reorgAction forces a reorg of the tip of the chain
+type reorgAction struct {
+	depth uint32
+}
+
+// parseReorgAction parses 'reorg <depth>'
+func parseReorgAction(args []string) (scenarioAction, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("expected 'reorg <depth>'")
+	}
+	depth, err := strconv.ParseUint(args[0], 10, 32)
+	if err != nil || depth == 0 {
+		return nil, fmt.Errorf("invalid reorg depth %q", args[0])
+	}
+	return &reorgAction{depth: uint32(depth)}, nil
+}
+
+func (a *reorgAction) String() string {
+	return fmt.Sprintf("reorg %d", a.depth)
+}
+
+func (a *reorgAction) run(sc *Scenario) error {
+	if sc.fork == nil {
+		return errors.New("no fork miner to mine the competing chain")
+	}
+	return sc.fork.Reorg(sc.miner, sc.node.client, a.depth, sc.com.exit)
+}
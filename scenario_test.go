@@ -0,0 +1,138 @@
+package btcsim
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+var fakeScenario = `
+# a comment
+at block 200 stop
+at block 100 actor 3 sends 50 to actor 7
+
+at 5m mine 6 blocks
+at 1s mine 1 block
+`
+
+func TestReadScenario(t *testing.T) {
+	sc, err := readScenario(strings.NewReader(fakeScenario))
+	if err != nil {
+		t.Fatalf("readScenario error: %v", err)
+	}
+	if len(sc.blockEvents) != 2 || len(sc.timeEvents) != 2 {
+		t.Fatalf("got %d block and %d time events, want 2 and 2",
+			len(sc.blockEvents), len(sc.timeEvents))
+	}
+	if sc.blockEvents[0].height != 100 || sc.blockEvents[1].height != 200 {
+		t.Errorf("block events not sorted: %v", sc.blockEvents)
+	}
+	send, ok := sc.blockEvents[0].action.(*sendAction)
+	if !ok || send.from != 3 || send.to != 7 || send.amount != 50e8 {
+		t.Errorf("unexpected send action: %v", sc.blockEvents[0].action)
+	}
+	if sc.timeEvents[0].after != time.Second || sc.timeEvents[1].after != 5*time.Minute {
+		t.Errorf("time events not sorted: %v", sc.timeEvents)
+	}
+	mine, ok := sc.timeEvents[1].action.(*mineAction)
+	if !ok || mine.blocks != 6 {
+		t.Errorf("unexpected mine action: %v", sc.timeEvents[1].action)
+	}
+}
+
+func TestReadScenarioErrors(t *testing.T) {
+	tests := []string{
+		"block 100 stop",
+		"at block x stop",
+		"at soon stop",
+		"at 5m",
+		"at 5m dance",
+		"at 5m stop now",
+		"at 5m mine 0 blocks",
+		"at 5m mine 6 coins",
+		"at 5m actor 1 sends -5 to actor 2",
+		"at 5m actor 1 sends 5 to 2",
+		"at 5m partition",
+		"at 5m partition 0 |",
+		"at 5m partition 0 1 | 1 2",
+		"at 5m partition a",
+		"at 5m heal all",
+		"at 5m reorg",
+		"at 5m reorg 0",
+	}
+	for _, test := range tests {
+		if _, err := readScenario(strings.NewReader(test)); err == nil {
+			t.Errorf("readScenario(%q) expected error", test)
+		}
+	}
+}
+
+func TestParsePartitionAction(t *testing.T) {
+	sc, err := readScenario(strings.NewReader("at block 5 partition 0 1 | 2 3"))
+	if err != nil {
+		t.Fatalf("readScenario error: %v", err)
+	}
+	a, ok := sc.blockEvents[0].action.(*partitionAction)
+	if !ok {
+		t.Fatalf("unexpected action %v", sc.blockEvents[0].action)
+	}
+	if got, want := a.String(), "partition 0 1 | 2 3"; got != want {
+		t.Errorf("String: got %q, want %q", got, want)
+	}
+	if !sc.partitions() {
+		t.Errorf("partitions: got false, want true")
+	}
+	if sc.reorgs() {
+		t.Errorf("reorgs: got true, want false")
+	}
+}
+
+// recordAction is a scenarioAction which records when it is run
+type recordAction struct {
+	name string
+	ran  chan string
+}
+
+func (a *recordAction) String() string { return a.name }
+
+func (a *recordAction) run(sc *Scenario) error {
+	a.ran <- a.name
+	return nil
+}
+
+func TestScenarioRun(t *testing.T) {
+	ran := make(chan string, 3)
+	sc := &Scenario{
+		com: &Communication{cfg: DefaultConfig()},
+		blockEvents: []*scenarioEvent{
+			{atBlock: true, height: 10, action: &recordAction{"block 10", ran}},
+			{atBlock: true, height: 12, action: &recordAction{"block 12", ran}},
+		},
+		timeEvents: []*scenarioEvent{
+			{after: 10 * time.Millisecond, action: &recordAction{"10ms", ran}},
+		},
+	}
+	heights := make(chan int32)
+	exit := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		sc.run(heights, exit)
+		close(done)
+	}()
+
+	if got := <-ran; got != "10ms" {
+		t.Errorf("first event got %s, want 10ms", got)
+	}
+	heights <- 9
+	heights <- 11
+	if got := <-ran; got != "block 10" {
+		t.Errorf("second event got %s, want block 10", got)
+	}
+	close(exit)
+	<-done
+	select {
+	case got := <-ran:
+		t.Errorf("unexpected event %s", got)
+	default:
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// seasonPeakHour is the hour of the simulated day the daily cycle
+	// peaks at, as mainnet traffic peaks in the afternoon UTC
+	seasonPeakHour = 15
+
+	// daysPerWeek is the number of simulated days of a week, the last two
+	// of which are the weekend
+	daysPerWeek = 7
+)
+
+// seasonality scales the transaction rate with daily and weekly cycles,
+// over simulated days lasting day
+type seasonality struct {
+	day time.Duration
+
+	// amplitude is the relative swing of the daily cycle, the rate ranges
+	// from 1-amplitude to 1+amplitude times the base rate
+	amplitude float64
+
+	// weekend is the factor applied to the rate on weekends
+	weekend float64
+}
+
+// parseSeasonality parses a seasonality given as the length of a simulated
+// day, the amplitude of the daily cycle and the weekend factor separated
+// by colons, e.g. "10m:0.5:0.7". The empty string returns a nil
+// seasonality.
+func parseSeasonality(s string) (*seasonality, error) {
+	if s == "" {
+		return nil, nil
+	}
+	fields := strings.Split(s, ":")
+	if len(fields) != 3 {
+		return nil, fmt.Errorf("seasonality %q: expected parameters day:amplitude:weekend", s)
+	}
+	day, err := time.ParseDuration(fields[0])
+	if err != nil || day <= 0 {
+		return nil, fmt.Errorf("seasonality %q: invalid day %q", s, fields[0])
+	}
+	amplitude, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil || !(amplitude >= 0 && amplitude < 1) {
+		return nil, fmt.Errorf("seasonality %q: amplitude must be in [0, 1)", s)
+	}
+	weekend, err := strconv.ParseFloat(fields[2], 64)
+	if err != nil || !(weekend > 0) || math.IsInf(weekend, 0) {
+		return nil, fmt.Errorf("seasonality %q: weekend factor must be positive", s)
+	}
+	return &seasonality{day: day, amplitude: amplitude, weekend: weekend}, nil
+}
+
+// checkSeasonality returns an error if the rate is scaled while it is not
+// limited, as it would make no difference
+func checkSeasonality(s *seasonality, rate float64, load *loadProfile) error {
+	if s != nil && rate <= 0 && load == nil {
+		return errors.New("seasonality needs a limited rate, set with -txrate or -load")
+	}
+	return nil
+}
+
+// factor returns the factor applied to the rate the given time after the
+// start, which is midnight of the first day of the week
+func (s *seasonality) factor(elapsed time.Duration) float64 {
+	if s == nil {
+		return 1
+	}
+	days := elapsed.Seconds() / s.day.Seconds()
+	hour := (days - math.Floor(days)) * 24
+	f := 1 + s.amplitude*math.Cos(2*math.Pi*(hour-seasonPeakHour)/24)
+	if int(days)%daysPerWeek >= daysPerWeek-2 {
+		f *= s.weekend
+	}
+	return f
+}
+
+// String returns the seasonality as it is parsed
+func (s *seasonality) String() string {
+	return fmt.Sprintf("%v:%g:%g", s.day, s.amplitude, s.weekend)
+}
@@ -0,0 +1,50 @@
+package btcsim
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestParseSeasonality(t *testing.T) {
+	s, err := parseSeasonality("10m:0.5:0.7")
+	if err != nil {
+		t.Fatalf("parseSeasonality error: %v", err)
+	}
+	if s.String() != "10m0s:0.5:0.7" {
+		t.Errorf("parseSeasonality got %v", s)
+	}
+	if s, err := parseSeasonality(""); s != nil || err != nil {
+		t.Errorf("parseSeasonality got %v, %v want nil, nil", s, err)
+	}
+	for _, spec := range []string{"10m:0.5", "0s:0.5:0.7", "10m:1:0.7", "10m:-0.1:0.7", "10m:0.5:0"} {
+		if _, err := parseSeasonality(spec); err == nil {
+			t.Errorf("parseSeasonality(%q) expected error", spec)
+		}
+	}
+}
+
+func TestSeasonalityFactor(t *testing.T) {
+	s := &seasonality{day: 24 * time.Second, amplitude: 0.5, weekend: 0.5}
+	tests := []struct {
+		elapsed time.Duration
+		want    float64
+	}{
+		// simulated hours last a second
+		{seasonPeakHour * time.Second, 1.5},
+		{(seasonPeakHour - 12) * time.Second, 0.5},
+		{(seasonPeakHour - 6) * time.Second, 1},
+		// the peak of the first day of the weekend and of the next week
+		{(5*24 + seasonPeakHour) * time.Second, 0.75},
+		{(7*24 + seasonPeakHour) * time.Second, 1.5},
+	}
+	for _, test := range tests {
+		if got := s.factor(test.elapsed); math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("factor after %v got %v want %v", test.elapsed, got, test.want)
+		}
+	}
+	var none *seasonality
+	if got := none.factor(time.Hour); got != 1 {
+		t.Errorf("factor without seasonality got %v want 1", got)
+	}
+}
@@ -14,10 +14,9 @@
  * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
  */
 
-package main
+package btcsim
 
 import (
-	"log"
 	"os"
 	"os/signal"
 )
@@ -25,6 +24,11 @@ import (
 // interruptChannel is used to receive SIGINT (Ctrl+C) signals.
 var interruptChannel chan os.Signal
 
+// interruptSignals defines the default signals to catch in order to do a
+// proper shutdown.  This may be modified during init depending on the
+// platform.
+var interruptSignals = []os.Signal{os.Interrupt}
+
 // addHandlerChannel is used to add an interrupt handler to the list of handlers
 // to be invoked on SIGINT (Ctrl+C) signals.
 var addHandlerChannel = make(chan func())
@@ -37,10 +41,24 @@ func mainInterruptHandler() {
 	// SIGINT (Ctrl+C) is received.
 	var interruptCallbacks []func()
 
+	// isShutdown is a flag which is used to indicate whether or not
+	// the shutdown signal has already been received and hence any future
+	// attempts to add a new interrupt handler should invoke them
+	// immediately.
+	var isShutdown bool
+
 	for {
 		select {
-		case <-interruptChannel:
-			log.Printf("Received SIGINT (Ctrl+C).  Shutting down...")
+		case sig := <-interruptChannel:
+			// Ignore more than one shutdown signal.
+			if isShutdown {
+				log.Infof("Received signal (%s).  Already "+
+					"shutting down...", sig)
+				continue
+			}
+
+			isShutdown = true
+			log.Infof("Received signal (%s).  Shutting down...", sig)
 			// run handlers in LIFO order.
 			for i := range interruptCallbacks {
 				idx := len(interruptCallbacks) - 1 - i
@@ -48,6 +66,12 @@ func mainInterruptHandler() {
 			}
 
 		case handler := <-addHandlerChannel:
+			// The shutdown signal has already been received, so
+			// just invoke any new handlers immediately.
+			if isShutdown {
+				handler()
+			}
+
 			interruptCallbacks = append(interruptCallbacks, handler)
 		}
 	}
@@ -60,7 +84,7 @@ func addInterruptHandler(handler func()) {
 	// all other callbacks and exits if not already done.
 	if interruptChannel == nil {
 		interruptChannel = make(chan os.Signal, 1)
-		signal.Notify(interruptChannel, os.Interrupt)
+		signal.Notify(interruptChannel, interruptSignals...)
 		go mainInterruptHandler()
 	}
 
@@ -0,0 +1,17 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package btcsim
+
+import (
+	"os"
+	"syscall"
+)
+
+func init() {
+	interruptSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+}
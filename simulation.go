@@ -1,8 +1,8 @@
-package main
+package btcsim
 
 import (
+	"errors"
 	"fmt"
-	"log"
 	"math"
 	"os"
 	"time"
@@ -12,13 +12,6 @@ import (
 	"github.com/btcsuite/btcutil"
 )
 
-// MissingCertPairFile is raised when one of the cert pair files is missing
-type MissingCertPairFile string
-
-func (m MissingCertPairFile) Error() string {
-	return fmt.Sprintf("could not find TLS certificate pair file: %v", string(m))
-}
-
 const (
 	// SimRows is the number of rows in the default curve
 	SimRows = 10
@@ -32,21 +25,49 @@ const (
 
 // Simulation contains the data required to run a simulation
 type Simulation struct {
+	cfg     *Config
 	txCurve map[int32]*Row
 	com     *Communication
 	actors  []*Actor
+	links   []*link
+
+	// propagation records the blocks connected by every node when
+	// there are several
+	propagation *propagation
+
+	// summary and failures are the summary of the simulation and the
+	// assertions which failed, set once it has run
+	summary  *Summary
+	failures []string
 }
 
-// NewSimulation returns a Simulation instance
-func NewSimulation() *Simulation {
+// NewSimulation returns a Simulation instance of the run configured by cfg
+func NewSimulation(cfg *Config) *Simulation {
 	s := &Simulation{
+		cfg:     cfg,
 		txCurve: make(map[int32]*Row),
-		actors:  make([]*Actor, 0, *numActors),
-		com:     NewCommunication(),
+		actors:  make([]*Actor, 0, cfg.NumActors),
+		com:     NewCommunication(cfg),
 	}
 	return s
 }
 
+// New returns a simulation configured by cfg of the tx curve read from
+// -txcurve, or of the default curve. The simulation runs with a copy of cfg
+// in a run dir of its own, so cfg can be changed and passed to New again.
+func New(cfg *Config) (*Simulation, error) {
+	c := *cfg
+	if err := c.initRun(); err != nil {
+		return nil, err
+	}
+	s := NewSimulation(&c)
+	if err := s.readTxCurve(c.TxCurvePath); err != nil {
+		return nil, err
+	}
+	s.updateFlags()
+	return s, nil
+}
+
 // readTxCurve reads and sets the txcurve to simulate
 // It defaults to a simple linears simulation
 func (s *Simulation) readTxCurve(txCurvePath string) error {
@@ -56,7 +77,7 @@ func (s *Simulation) readTxCurve(txCurvePath string) error {
 		// linear simulation curve as the default
 		txCurve = make(map[int32]*Row, SimRows)
 		for i := 1; i <= SimRows; i++ {
-			block := int32(*startBlock + i)
+			block := int32(s.cfg.StartBlock + i)
 			txCurve[block] = &Row{
 				utxoCount: i * SimUtxoCount,
 				txCount:   i * SimTxCount,
@@ -83,18 +104,18 @@ func (s *Simulation) updateFlags() {
 	// and max block height as stopBlock
 	for k := range s.txCurve {
 		block := int(k)
-		if block < *startBlock {
-			*startBlock = block
+		if block < s.cfg.StartBlock {
+			s.cfg.StartBlock = block
 		}
-		if block > *stopBlock {
-			*stopBlock = block
+		if block > s.cfg.StopBlock {
+			s.cfg.StopBlock = block
 		}
 	}
 
-	if *maxSplit > *maxAddresses {
+	if s.cfg.MaxSplit > s.cfg.MaxAddresses {
 		// cap max split at maxaddresses, becauase each split requires
 		// a unique return address
-		*maxSplit = *maxAddresses
+		s.cfg.MaxSplit = s.cfg.MaxAddresses
 	}
 }
 
@@ -102,102 +123,810 @@ func (s *Simulation) updateFlags() {
 // which communicates with the actors. It waits until the simulation
 // finishes or is interrupted
 func (s *Simulation) Start() error {
-
-	// re-use existing cert, key if both are present
-	// if only one of cert, key is missing, exit with err message
-	haveCert := fileExists(CertFile)
-	haveKey := fileExists(KeyFile)
-	switch {
-	case haveCert && !haveKey:
-		return MissingCertPairFile(KeyFile)
-	case !haveCert && haveKey:
-		return MissingCertPairFile(CertFile)
-	case !haveCert:
-		// generate new cert pair if both cert and key are missing
-		err := genCertPair(CertFile, KeyFile)
-		if err != nil {
-			return err
-		}
-	}
+	start := time.Now()
 
 	ntfnHandlers := &rpc.NotificationHandlers{
 		OnBlockConnected: func(hash *wire.ShaHash, height int32) {
 			block := &Block{
 				hash:   hash,
 				height: height,
+				time:   time.Now(),
 			}
 			select {
 			case s.com.blockQueue.enqueue <- block:
 			case <-s.com.exit:
 			}
 		},
-		OnTxAccepted: func(hash *wire.ShaHash, amount btcutil.Amount) {
-			s.com.timeReceived <- time.Now()
+		OnBlockDisconnected: func(hash *wire.ShaHash, height int32) {
+			s.com.events.publish(&Event{Kind: eventReorg, Hash: hash, Height: height})
 		},
 	}
 
-	log.Println("Starting node on simnet...")
-	args, err := newBtcdArgs("node")
+	// if we receive an interrupt, proceed to shutdown
+	addInterruptHandler(s.com.Exit)
+
+	if err := checkTimeScale(s.cfg.TimeScale); err != nil {
+		return err
+	}
+	if err := checkMiningSchedule(s.cfg.MiningSchedule, s.cfg.BlockInterval); err != nil {
+		return err
+	}
+	// blocks are only requested over the control API, which the
+	// dashboard serves as well
+	if s.cfg.MiningSchedule == scheduleOnDemand && s.cfg.ControlAddr == "" && s.cfg.DashboardAddr == "" {
+		return fmt.Errorf("the %s mining schedule requires -control or -dashboard to request blocks",
+			scheduleOnDemand)
+	}
+	if err := checkDataCarrier(s.cfg.DataFraction, s.cfg.DataCarrierSize); err != nil {
+		return err
+	}
+	if err := checkDust(s.cfg.DustOutputs, btcutil.Amount(s.cfg.DustAmount)); err != nil {
+		return err
+	}
+	if s.cfg.OrphanInterval < 0 || s.cfg.OrphanDelay < 0 {
+		return errors.New("orphan interval and delay cannot be negative")
+	}
+	if s.cfg.EventsOutPath == "-" && s.cfg.TUI {
+		return errors.New("the terminal UI and the event stream cannot both use the standard output")
+	}
+	if s.cfg.MultisigScheme != "" {
+		if _, _, err := parseMultisig(s.cfg.MultisigScheme); err != nil {
+			return err
+		}
+	}
+	if err := checkBlockSize(s.cfg.MinBlockSize, s.cfg.MaxBlockSize, s.cfg.PrioritySize); err != nil {
+		return err
+	}
+	if err := checkCoinjoin(s.cfg.CoinjoinParticipants); err != nil {
+		return err
+	}
+	if err := checkBackend(s.cfg.BackendName, s.cfg.InProcess, s.cfg.DockerMode, s.cfg.WatchOnlyActors); err != nil {
+		return err
+	}
+	if err := checkInProcess(s.cfg.InProcess, s.cfg.MultisigScheme, s.cfg.SaveStatePath, s.cfg.LoadStatePath,
+		s.cfg.ChaosInterval); err != nil {
+		return err
+	}
+	if err := checkWalletDBs(s.cfg.WalletDBDir, s.cfg.InProcess, s.cfg.LoadStatePath); err != nil {
+		return err
+	}
+	if err := checkHDWallets(s.cfg.HDWallets, uint32(s.cfg.HDGap), s.cfg.InProcess); err != nil {
+		return err
+	}
+
+	if err := checkInvariants(s.cfg.InvariantBlocks, s.cfg.InProcess, s.cfg.MultisigScheme, s.cfg.FuzzInterval > 0); err != nil {
+		return err
+	}
+
+	if err := checkCrashTest(s.cfg.CrashInterval, s.cfg.InProcess); err != nil {
+		return err
+	}
+
+	if s.cfg.ProfilePath != "" {
+		if err := loadProfiles(s.cfg.ProfilePath); err != nil {
+			return err
+		}
+	}
+	amounts, err := parseAmountDist(s.cfg.AmountDistName)
+	if err != nil {
+		return err
+	}
+	s.cfg.defaultAmounts = amounts
+	if s.cfg.defaultFees, err = parseFeePolicy(s.cfg.FeePolicyName); err != nil {
+		return err
+	}
+	if s.com.load, err = parseLoadProfile(s.cfg.LoadProfileName); err != nil {
+		return err
+	}
+	if s.com.burst, err = parseBlockBurst(s.cfg.BlockBurstSpec); err != nil {
+		return err
+	}
+	if err := checkBlockBurst(s.com.burst, s.cfg.TxRate, s.com.load); err != nil {
+		return err
+	}
+	if s.com.season, err = parseSeasonality(s.cfg.SeasonalitySpec); err != nil {
+		return err
+	}
+	if err := checkSeasonality(s.com.season, s.cfg.TxRate, s.com.load); err != nil {
+		return err
+	}
+	if _, err := assignAddressTypes(0, s.cfg.AddressTypeMix); err != nil {
+		return err
+	}
+	assigned, err := assignProfiles(s.cfg.NumActors, s.cfg.ProfileMix, s.cfg.ActorProfiles)
+	if err != nil {
+		return err
+	}
+	behaviorNames, err := assignBehaviors(s.cfg.NumActors, s.cfg.ActorBehaviors)
 	if err != nil {
-		log.Printf("Cannot create node args: %v", err)
 		return err
 	}
-	logFile, err := getLogFile(args.prefix)
+	if s.cfg.BehaviorTick <= 0 {
+		return fmt.Errorf("invalid behavior tick %v, it must be positive", s.cfg.BehaviorTick)
+	}
+	if s.cfg.BtcwalletVersions != "" {
+		if s.com.wallets, err = parseVersions("btcwallet", s.cfg.BtcwalletVersions); err != nil {
+			return err
+		}
+	}
+	if err := checkWalletVersions(s.com.wallets, s.cfg.InProcess, s.cfg.DockerMode); err != nil {
+		return err
+	}
+	walletNames, err := assignWallets(s.cfg.NumActors, s.com.wallets, s.cfg.ActorWallets)
 	if err != nil {
-		log.Printf("Cannot get log file, logging disabled: %v", err)
+		return err
+	}
+	if s.cfg.GrowthSpec != "" {
+		if s.com.growth, err = parseGrowth(s.cfg.GrowthSpec, s.cfg.NumActors); err != nil {
+			return err
+		}
+	}
+	if s.cfg.MaxMempool < 0 {
+		return errors.New("mempool size limit cannot be negative")
+	}
+	if s.cfg.MaxMempool > 0 && s.cfg.BackendName == backendBtcd {
+		return errors.New("btcd has no mempool size limit, -maxmempool needs the bitcoind backend")
+	}
+	s.com.evictions = newEvictionTracker(s.com.mempool)
+	s.com.evictions.subscribe(s.com.events)
+	if s.cfg.FuzzInterval < 0 {
+		return errors.New("fuzz interval cannot be negative")
+	}
+	if s.cfg.FuzzInterval > 0 {
+		s.com.fuzz = newFuzzTracker()
+		s.com.fuzz.subscribe(s.com.events)
+	}
+	if s.cfg.TimelockInterval < 0 || s.cfg.TimelockDelay < 0 {
+		return errors.New("timelock interval and delay cannot be negative")
+	}
+	if s.cfg.TimelockBlocks < 1 {
+		return errors.New("transactions must be locked at least one block ahead")
+	}
+	if s.cfg.TimelockInterval > 0 {
+		s.com.timelocks = newTimelockTracker()
+		s.com.timelocks.subscribe(s.com.events)
+	}
+	if s.cfg.FeeAccuracyPath != "" {
+		targets, err := parseFeeTargets(s.cfg.FeeTargets)
+		if err != nil {
+			return err
+		}
+		s.com.txStats.oracle = newFeeOracle(targets)
+	}
+	s.cfg.feeMarket = s.cfg.defaultFees != nil
+	for _, p := range assigned {
+		if p.Fees != nil {
+			s.cfg.feeMarket = true
+		}
 	}
-	node, err := NewNodeFromArgs(args, ntfnHandlers, logFile)
+
+	if s.cfg.LoadStatePath != "" {
+		state, err := readState(s.cfg.LoadStatePath)
+		if err != nil {
+			return err
+		}
+		if err := state.check(s.cfg.NumNodes, s.cfg.totalActors(), s.cfg.StopBlock); err != nil {
+			return err
+		}
+		log.Infof("Resuming from the state at height %d saved in %s",
+			state.Height, s.cfg.LoadStatePath)
+		s.cfg.loadedState = state
+	}
+
+	if s.cfg.ScenarioPath != "" {
+		sc, err := loadScenario(s.cfg.ScenarioPath)
+		if err != nil {
+			return err
+		}
+		rateControlled := s.com.load != nil || s.com.season != nil
+		if err := checkPhases(sc, rateControlled, s.cfg.MiningSchedule); err != nil {
+			return err
+		}
+		s.com.scenario = sc
+	}
+
+	policy, err := getMatchPolicy(s.cfg.MatchingName)
 	if err != nil {
-		log.Printf("%s: Cannot create node: %v", node, err)
 		return err
 	}
-	if err := node.Start(); err != nil {
-		log.Printf("%s: Cannot start node: %v", node, err)
+	s.com.matchmaker.policy = policy
+	if s.cfg.Invoices {
+		if s.cfg.InvoiceExpiry <= 0 {
+			return fmt.Errorf("invalid invoice expiry %v, it must be positive", s.cfg.InvoiceExpiry)
+		}
+		s.com.matchmaker.invoices = newInvoiceBook(s.cfg, s.cfg.realDuration(s.cfg.InvoiceExpiry), s.com.indexAddress)
+	}
+
+	if s.cfg.FundAmount > 0 {
+		amount, err := btcutil.NewAmount(s.cfg.FundAmount)
+		if err != nil {
+			return err
+		}
+		// funded utxos must be large enough to be split
+		if amount <= btcutil.Amount(s.cfg.MaxSplit)*minFee {
+			return fmt.Errorf("funding amount must be more than %v",
+				btcutil.Amount(s.cfg.MaxSplit)*minFee)
+		}
+		s.com.fundAmount = amount
+	}
+
+	if s.cfg.AttackName != "" {
+		if _, err := getAttackStrategy(s.cfg, s.cfg.AttackName); err != nil {
+			return err
+		}
+		if s.cfg.AttackPower <= 0 || s.cfg.AttackPower >= 1 {
+			return errors.New("attack power must be in (0, 1)")
+		}
+	}
+
+	if err := checkExecutables(s.cfg, s.com.wallets, s.cfg.InProcess, s.cfg.DockerMode); err != nil {
 		return err
 	}
-	if err := node.Connect(); err != nil {
-		log.Printf("%s: Cannot connect to node: %v", node, err)
+
+	topology, err := getTopology(s.cfg.TopologyName)
+	if err != nil {
 		return err
 	}
+	if s.cfg.NumNodes < 1 {
+		return errors.New("at least one node is required")
+	}
 
-	// Register for block notifications.
-	if err := node.client.NotifyBlocks(); err != nil {
-		log.Printf("%s: Cannot register for block notifications: %v", node, err)
+	shape := linkShape{
+		latency:   s.cfg.Latency,
+		jitter:    s.cfg.Jitter,
+		bandwidth: s.cfg.Bandwidth,
+	}
+	if shape.latency < 0 || shape.jitter < 0 || shape.bandwidth < 0 {
+		return errors.New("latency, jitter and bandwidth cannot be negative")
+	}
+	// nodes are linked through proxies if links are shaped or the
+	// scenario partitions the network
+	var shaped *linkShape
+	if shape.enabled() || (s.com.scenario != nil && s.com.scenario.partitions()) {
+		shaped = &shape
+	}
+	defer s.closeLinks()
+
+	reorgs := s.com.scenario != nil && s.com.scenario.reorgs()
+	if err := checkDocker(s.cfg.DockerMode, shaped != nil, s.cfg.AttackName, s.cfg.OrphanInterval > 0, reorgs); err != nil {
+		return err
+	}
+	if err := checkResources(s.cfg.ResourceInterval, s.cfg.DockerMode); err != nil {
 		return err
 	}
+	if s.cfg.RecordPath != "" {
+		rec, err := createRecording(s.cfg.RecordPath, &recordingHeader{Seed: s.cfg.Seed, Args: s.cfg.recordedArgs()})
+		if err != nil {
+			return fmt.Errorf("cannot create recording: %v", err)
+		}
+		s.cfg.recording = rec
+		defer func() {
+			s.cfg.recording = nil
+			rec.Close()
+		}()
+	}
+	if s.cfg.recording.replaying() {
+		defer func() {
+			replayed, diverged := s.cfg.recording.Counts()
+			log.Infof("Replayed %d rpc calls, %d went to the nodes after the recording diverged",
+				replayed, diverged)
+		}()
+	}
+	if s.cfg.RPCTracePath != "" {
+		trace, err := createRPCTrace(s.cfg.RPCTracePath)
+		if err != nil {
+			return fmt.Errorf("cannot create rpc trace: %v", err)
+		}
+		s.cfg.rpcTracer = trace
+		defer func() {
+			s.cfg.rpcTracer = nil
+			if err := trace.Close(); err != nil {
+				log.Errorf("Cannot write rpc trace: %v", err)
+				return
+			}
+			log.Infof("Traced %d rpc calls to %s", trace.Entries(), s.cfg.RPCTracePath)
+		}()
+	}
+	if s.cfg.EventsOutPath != "" {
+		stream, err := openEventStream(s.cfg.EventsOutPath, s.cfg.RunID)
+		if err != nil {
+			return fmt.Errorf("cannot open event stream: %v", err)
+		}
+		defer stream.Close()
+		s.com.events.subscribe(stream.write, eventKinds...)
+	}
+	if s.cfg.RPCStatsPath != "" {
+		s.cfg.rpcStats = newRPCRecorder()
+		defer func() {
+			s.cfg.rpcStats = nil
+		}()
+	}
+	if s.cfg.DockerMode {
+		p, err := newDockerProvisioner(s.cfg.runDir, s.cfg.DockerImages, s.cfg.DockerConfig)
+		if err != nil {
+			return err
+		}
+		if err := p.Start(); err != nil {
+			return err
+		}
+		s.cfg.provisioner = p
+		defer func() {
+			s.cfg.provisioner = nil
+			p.Close()
+		}()
+	}
 
-	// Register for transaction notifications
-	if err := node.client.NotifyNewTransactions(false); err != nil {
-		log.Printf("%s: Cannot register for transactions notifications: %v", node, err)
+	if s.cfg.NumNodes > 1 {
+		s.propagation = newPropagation(s.cfg.NumNodes, int32(s.cfg.StartBlock))
+	}
+	nodes, err := s.startNodes(s.cfg.NumNodes, topology, shaped, ntfnHandlers)
+	if err != nil {
 		return err
 	}
+	s.com.links = s.links
+	if s.com.scenario != nil {
+		s.com.scenario.links = s.links
+		s.com.scenario.numNodes = len(nodes)
+	}
+
+	// stop here if the simulation was interrupted while nodes were starting
+	select {
+	case <-s.com.exit:
+		shutdownNodes(nodes)
+		return nil
+	default:
+	}
+
+	// Register for block and transaction notifications from every node,
+	// they are published as events driving the actors and statistics
+	for _, n := range nodes {
+		if err := n.notify(); err != nil {
+			log.Errorf("%s: Cannot register for notifications: %v", n, err)
+			shutdownNodes(nodes)
+			return err
+		}
+	}
 
-	for i := 0; i < *numActors; i++ {
-		a, err := NewActor(node, uint16(18557+i))
+	// in-process wallets share a pool of clients of each node
+	if s.cfg.InProcess && s.cfg.RPCConns > 0 {
+		for _, n := range nodes {
+			n.pool = newClientPool(s.cfg, n.String(), n.RPCConnConfig(), s.cfg.RPCConns)
+		}
+	}
+
+	// distribute actors evenly across the nodes
+	for i := 0; i < s.cfg.totalActors(); i++ {
+		a, err := newIndexedActor(nodes[i%len(nodes)], i)
 		if err != nil {
-			log.Printf("%s: Cannot create actor: %v", a, err)
+			log.Errorf("Cannot create actor %d: %v", i, err)
 			continue
 		}
+		if i < len(assigned) {
+			a.profile = assigned[i]
+			if err := a.setBehavior(behaviorNames[i]); err != nil {
+				log.Errorf("%s: Cannot create behavior: %v", a, err)
+				a.Cleanup()
+				continue
+			}
+			a.setWallet(walletNames[i], s.com.wallets)
+		} else {
+			a.floodTarget = s.cfg.FloodTarget
+		}
+		if s.cfg.WalletDBDir != "" {
+			if err := importWalletDB(a, s.cfg.WalletDBDir, i); err != nil {
+				log.Errorf("%s: Cannot import wallet database: %v", a, err)
+				a.Cleanup()
+				continue
+			}
+		}
+		if s.cfg.loadedState != nil {
+			if err := restoreActor(a, i); err != nil {
+				log.Errorf("%s: Cannot restore actor: %v", a, err)
+				a.Cleanup()
+				continue
+			}
+		}
+		a.index = i
+		a.rand = s.cfg.newRand(actorStream + int64(i))
+		if s.cfg.restartWallets() {
+			a.restart = true
+			a.restartAfter = s.cfg.realDuration(s.cfg.ChaosDelay)
+		}
+		a.crashTest = s.com.crashTest
+		log.Debugf("%s: Using profile %s and behavior %s", a, a.profile.Name, a.behaviorName)
 		s.actors = append(s.actors, a)
 	}
 
-	// if we receive an interrupt, proceed to shutdown
-	addInterruptHandler(func() {
-		close(s.com.exit)
-	})
-
 	// Start simulation.
-	tpsChan, tpbChan := s.com.Start(s.actors, node, s.txCurve)
+	tpsChan, tpbChan := s.com.Start(s.actors, nodes, s.txCurve)
 	s.com.WaitForShutdown()
 
-	tps, ok := <-tpsChan
-	if ok && !math.IsNaN(tps) {
-		log.Printf("Average transactions per sec: %.2f", tps)
+	summary := NewSummary(s.cfg, s.com.txStats, time.Since(start))
+	summary.MaxMempool = s.com.mempool.Max()
+	summary.Crashes = s.com.crashes
+	if s.cfg.DustOutputs > 0 {
+		summary.Dust = &s.com.dustStats
+	}
+	if s.cfg.OrphanInterval > 0 {
+		summary.Orphans = &s.com.orphanStats
+	}
+	summary.FailedActors = s.com.failed
+	summary.StartFailures = s.com.startup.Failures()
+	summary.InvariantChecks = s.com.invariantChecks
+	summary.InvariantViolations = s.com.invariantViolations
+	summary.AuditIssues = len(s.com.auditIssues)
+	summary.LostFunds = lostFunds(s.com.auditIssues)
+	var failures []string
+	if s.com.scenario != nil {
+		summary.Assertions, failures = s.com.scenario.Failures()
+		summary.AssertionsFailed = len(failures)
+	}
+	s.summary, s.failures = summary, failures
+	if s.com.crashTest != nil {
+		summary.WalletKills = s.com.crashTest.Killed()
+	}
+	summary.Payments, summary.PaymentsConfirmed = s.com.matchmaker.counts()
+	if s.com.matchmaker.invoices != nil {
+		summary.Invoices = s.com.matchmaker.invoices.Stats()
+	}
+	summary.Merchants = merchantStats(s.com.Actors(), s.com.txStats.Records())
+	summary.Pools = poolStats(s.com.Actors())
+	summary.Exchanges = exchangeStats(s.com.Actors())
+	summary.Dice = diceStats(s.com.Actors())
+	for _, a := range s.com.Actors() {
+		sent, received := a.Payments()
+		summary.ActorPayments[a.String()] = PaymentCounts{
+			Sent:     int(sent),
+			Received: int(received),
+		}
+		txs, utxos := a.Consolidations()
+		summary.Consolidations += int(txs)
+		summary.Consolidated += int(utxos)
+		summary.ChainRejected += int(a.ChainRejected())
+	}
+	if s.com.attacker != nil {
+		summary.Attack = s.com.attacker.Stats()
+	}
+	if s.propagation != nil {
+		summary.Propagation = s.propagation.Stats()
+	}
+	if len(s.com.mempoolSamples) > 0 {
+		summary.Mempools = newMempoolDivergence(s.com.mempoolSamples)
+	}
+	if s.com.utxoSet != nil {
+		summary.UtxoSet = s.com.utxoSet.Snapshots()
+	}
+	if len(s.com.resourceSamples) > 0 {
+		summary.Resources = newResourceUsage(s.com.resourceSamples)
+	}
+	if s.cfg.rpcStats != nil {
+		summary.RPC = s.cfg.rpcStats.Stats()
+	}
+	if len(s.com.wallets) > 0 {
+		summary.Wallets = newWalletVersionStats(s.com.wallets, s.com.Actors(), summary.Resources, s.cfg.rpcStats)
+	}
+	summary.WatchOnly = s.com.watchStats
+	if s.com.evictions != nil {
+		summary.Evictions = s.com.evictions.Stats()
+	}
+	if s.com.fuzz != nil {
+		summary.Fuzz = s.com.fuzz.Stats()
+	}
+	if s.com.timelocks != nil {
+		summary.Timelocks = s.com.timelocks.Stats()
+	}
+	for actor, balance := range s.com.balances {
+		summary.ActorBalances[actor] = int64(balance)
+	}
+	if tps, ok := <-tpsChan; ok && !math.IsNaN(tps) && !math.IsInf(tps, 0) {
+		summary.TPS = tps
+	}
+	if tpb, ok := <-tpbChan; ok {
+		summary.MaxTPB = tpb
+	}
+	log.Infof("Simulation summary:")
+	summary.Write(s.cfg.output())
+	if s.cfg.SummaryPath != "" {
+		if err := writeSummary(s.cfg.SummaryPath, summary); err != nil {
+			log.Errorf("Cannot write summary: %v", err)
+			return err
+		}
+	}
+	if s.cfg.ReportPath != "" {
+		if err := writeReport(s.cfg.ReportPath, summary, s.com.txStats); err != nil {
+			log.Errorf("Cannot write report: %v", err)
+			return err
+		}
+		log.Infof("Wrote report to %s", s.cfg.ReportPath)
+	}
+
+	if s.cfg.TxStatsPath != "" {
+		records := s.com.txStats.Records()
+		if err := writeTxStats(s.cfg.TxStatsPath, records); err != nil {
+			log.Errorf("Cannot write transaction statistics: %v", err)
+			return err
+		}
+		log.Infof("Wrote statistics of %d transactions to %s", len(records), s.cfg.TxStatsPath)
+	}
+
+	if s.cfg.FeeStatsPath != "" {
+		blocks := s.com.txStats.BlockFees()
+		if err := writeFeeStats(s.cfg.FeeStatsPath, blocks); err != nil {
+			log.Errorf("Cannot write fee statistics: %v", err)
+			return err
+		}
+		log.Infof("Wrote fee statistics of %d blocks to %s", len(blocks), s.cfg.FeeStatsPath)
+	}
+
+	if s.cfg.BlockStatsPath != "" {
+		blocks := s.com.txStats.BlockCompositions()
+		if err := writeBlockStats(s.cfg.BlockStatsPath, blocks); err != nil {
+			log.Errorf("Cannot write block statistics: %v", err)
+			return err
+		}
+		log.Infof("Wrote composition of %d blocks to %s", len(blocks), s.cfg.BlockStatsPath)
+	}
+
+	if s.cfg.LatencyStatsPath != "" {
+		if err := writeLatencyStats(s.cfg.LatencyStatsPath, summary.Latency); err != nil {
+			log.Errorf("Cannot write latency statistics: %v", err)
+			return err
+		}
+		log.Infof("Wrote confirmation latency of %d fee bands to %s", len(summary.Latency), s.cfg.LatencyStatsPath)
+	}
+
+	if s.cfg.FeeAccuracyPath != "" {
+		if err := writeFeeAccuracy(s.cfg.FeeAccuracyPath, summary.FeeAccuracy); err != nil {
+			log.Errorf("Cannot write fee estimate accuracy: %v", err)
+			return err
+		}
+		log.Infof("Wrote fee estimate accuracy of %d targets to %s", len(summary.FeeAccuracy), s.cfg.FeeAccuracyPath)
+	}
+
+	if s.cfg.AuditPath != "" {
+		issues := s.com.auditIssues
+		if err := writeAudit(s.cfg.AuditPath, issues); err != nil {
+			log.Errorf("Cannot write audit report: %v", err)
+			return err
+		}
+		log.Infof("Wrote %d wallet audit differences to %s", len(issues), s.cfg.AuditPath)
+	}
+
+	if s.cfg.MempoolStatsPath != "" {
+		samples := s.com.mempoolSamples
+		if err := writeMempoolStats(s.cfg.MempoolStatsPath, samples); err != nil {
+			log.Errorf("Cannot write mempool statistics: %v", err)
+			return err
+		}
+		log.Infof("Wrote %d mempool divergence samples to %s", len(samples), s.cfg.MempoolStatsPath)
+	}
+
+	if s.cfg.ResourceStatsPath != "" {
+		samples := s.com.resourceSamples
+		if err := writeResourceStats(s.cfg.ResourceStatsPath, samples); err != nil {
+			log.Errorf("Cannot write resource statistics: %v", err)
+			return err
+		}
+		log.Infof("Wrote %d resource usage samples to %s", len(samples), s.cfg.ResourceStatsPath)
+	}
+
+	if s.cfg.RPCStatsPath != "" {
+		if err := writeRPCStats(s.cfg.RPCStatsPath, summary.RPC); err != nil {
+			log.Errorf("Cannot write rpc statistics: %v", err)
+			return err
+		}
+		log.Infof("Wrote latency of %d rpc methods and nodes to %s", len(summary.RPC), s.cfg.RPCStatsPath)
+	}
+
+	if s.cfg.InvoiceStatsPath != "" && s.com.matchmaker.invoices != nil {
+		invoices := s.com.matchmaker.invoices.Invoices()
+		if err := writeInvoiceStats(s.cfg.InvoiceStatsPath, invoices); err != nil {
+			log.Errorf("Cannot write invoice statistics: %v", err)
+			return err
+		}
+		log.Infof("Wrote the lifecycle of %d invoices to %s", len(invoices), s.cfg.InvoiceStatsPath)
+	}
+
+	if s.cfg.TxGraphPath != "" && s.com.txGraph != nil {
+		g := s.com.txGraph
+		if err := writeTxGraph(s.cfg.TxGraphPath, g); err != nil {
+			log.Errorf("Cannot write transaction graph: %v", err)
+			return err
+		}
+		log.Infof("Wrote graph of %d transactions and %d spends to %s", len(g.txs), len(g.spends), s.cfg.TxGraphPath)
+	}
+
+	if s.cfg.LabelsPath != "" && s.com.labels != nil {
+		l := s.com.labels
+		if err := writeLabels(s.cfg.LabelsPath, l); err != nil {
+			log.Errorf("Cannot write labels: %v", err)
+			return err
+		}
+		log.Infof("Wrote labels of %d addresses and %d transactions to %s", len(l.Addresses), len(l.Txs), s.cfg.LabelsPath)
+	}
+
+	if s.cfg.UtxoStatsPath != "" && s.com.utxoSet != nil {
+		snapshots := s.com.utxoSet.Snapshots()
+		if err := writeUtxoStats(s.cfg.UtxoStatsPath, snapshots); err != nil {
+			log.Errorf("Cannot write utxo set statistics: %v", err)
+			return err
+		}
+		log.Infof("Wrote %d utxo set snapshots to %s", len(snapshots), s.cfg.UtxoStatsPath)
+	}
+
+	if s.cfg.PropagationStatsPath != "" && s.propagation != nil {
+		if err := s.propagation.writePropagationStats(s.cfg.PropagationStatsPath); err != nil {
+			log.Errorf("Cannot write propagation statistics: %v", err)
+			return err
+		}
+		log.Infof("Wrote propagation statistics to %s", s.cfg.PropagationStatsPath)
 	}
 
-	tpb, ok := <-tpbChan
-	if ok && tpb > 0 {
-		log.Printf("Maximum transactions per block: %v", tpb)
+	if len(failures) > 0 {
+		for _, f := range failures {
+			log.Errorf("Assertion failed: %s", f)
+		}
+		return &checkError{exitAssertion, fmt.Sprintf("%d of %d scenario assertions failed",
+			len(failures), summary.Assertions)}
+	}
+	if summary.InvariantViolations > 0 || summary.LostFunds > 0 {
+		return &checkError{exitCheck, fmt.Sprintf("%d balance invariant violations, %d wallet differences losing funds",
+			summary.InvariantViolations, summary.LostFunds)}
 	}
 	return nil
 }
+
+// Summary returns the summary of the simulation, nil until it has run
+func (s *Simulation) Summary() *Summary {
+	return s.summary
+}
+
+// Result returns the result of the simulation whose Start returned err
+func (s *Simulation) Result(err error) *Result {
+	return newResult(err, s.summary, s.failures)
+}
+
+// writeResults writes the result of the simulation which returned err to
+// the given path as JSON
+func (s *Simulation) writeResults(path string, err error) error {
+	return writeJSON(path, s.Result(err))
+}
+
+// startNodes launches n btcd nodes and connects them to each other
+// according to the given topology, through proxies shaping the links
+// unless shape is nil. The first node receives the notifications passed
+// as handlers
+func (s *Simulation) startNodes(n int, topology topologyFunc,
+	shape *linkShape, handlers *rpc.NotificationHandlers) ([]*Node, error) {
+
+	newArgs, err := getBackend(s.cfg.BackendName)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("Starting %d %s node(s)...", n, s.cfg.BackendName)
+	args := make([]ChainServer, n)
+	for i := range args {
+		prefix := "node"
+		if i > 0 {
+			prefix = fmt.Sprintf("node-%d", i)
+		}
+		a, err := newArgs(s.cfg, prefix)
+		if err != nil {
+			log.Errorf("Cannot create node args: %v", err)
+			for _, a := range args[:i] {
+				a.Cleanup()
+			}
+			return nil, err
+		}
+		a = s.cfg.provisioner.wrapServer(a)
+		args[i] = a
+		preferred, rpcPreferred := nodePorts(i)
+		listen, err := s.cfg.localAddr(preferred)
+		if err != nil {
+			log.Errorf("Cannot allocate node port: %v", err)
+			for _, a := range args[:i+1] {
+				a.Cleanup()
+			}
+			return nil, err
+		}
+		rpcListen, err := s.cfg.localAddr(rpcPreferred)
+		if err != nil {
+			log.Errorf("Cannot allocate node port: %v", err)
+			for _, a := range args[:i+1] {
+				a.Cleanup()
+			}
+			return nil, err
+		}
+		a.SetListen(listen, rpcListen)
+		if err := a.SetRelayPolicy(s.cfg.MaxMempool, s.cfg.MinRelayFee); err != nil {
+			log.Errorf("%s: Cannot set relay policy: %v", a, err)
+			for _, a := range args[:i+1] {
+				a.Cleanup()
+			}
+			return nil, err
+		}
+		if err := restoreDataDir(s.cfg, a, a.String()); err != nil {
+			log.Errorf("%s: Cannot restore node: %v", a, err)
+			for _, a := range args[:i+1] {
+				a.Cleanup()
+			}
+			return nil, err
+		}
+	}
+
+	// the node with the higher index connects to the other one
+	for _, edge := range topology(n, s.cfg.newRand(topologyStream)) {
+		from, to := args[edge[1]], args[edge[0]]
+		addr := to.ListenAddr()
+		if shape != nil {
+			p, err := newProxy(addr, *shape, s.cfg.newRand(proxyStream+int64(len(s.links))))
+			if err != nil {
+				log.Errorf("Cannot start proxy to %s: %v", to, err)
+				for _, a := range args {
+					a.Cleanup()
+				}
+				return nil, err
+			}
+			s.links = append(s.links, &link{from: edge[1], to: edge[0], proxy: p})
+			addr = p.Addr()
+		}
+		from.AddPeer(addr)
+	}
+
+	nodes := make([]*Node, 0, n)
+	for i, a := range args {
+		var ntfnHandlers *rpc.NotificationHandlers
+		if i == 0 {
+			ntfnHandlers = handlers
+		}
+		ntfnHandlers = s.com.nodeHandlers(i, ntfnHandlers)
+		ntfnHandlers = s.propagation.handlers(i, a.String(), ntfnHandlers)
+		logFile, err := s.cfg.getLogFile(a.String())
+		if err != nil {
+			log.Warnf("Cannot get log file, logging disabled: %v", err)
+		}
+		node, err := NewNodeFromArgs(s.cfg, a, ntfnHandlers, logFile)
+		if err != nil {
+			log.Errorf("%s: Cannot create node: %v", a, err)
+			for _, a := range args[i:] {
+				a.Cleanup()
+			}
+			shutdownNodes(nodes)
+			return nil, err
+		}
+		node.restart = s.cfg.RestartNodes
+		nodes = append(nodes, node)
+		if err := node.Start(); err != nil {
+			log.Errorf("%s: Cannot start node: %v", node, err)
+			for _, a := range args[i+1:] {
+				a.Cleanup()
+			}
+			shutdownNodes(nodes)
+			return nil, err
+		}
+	}
+
+	for _, node := range nodes {
+		if err := node.Connect(); err != nil {
+			log.Errorf("%s: Cannot connect to node: %v", node, err)
+			shutdownNodes(nodes)
+			return nil, err
+		}
+	}
+	return nodes, nil
+}
+
+// closeLinks closes the proxies between nodes
+func (s *Simulation) closeLinks() {
+	for _, l := range s.links {
+		l.proxy.Close()
+	}
+	s.links = nil
+}
+
+// shutdownNodes shuts down all the given nodes
+func shutdownNodes(nodes []*Node) {
+	for _, node := range nodes {
+		node.Shutdown()
+	}
+}
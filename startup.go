@@ -0,0 +1,105 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// startController limits the number of actor wallets starting at once and
+// the time each is given to start, and records the actors which failed to
+// start along with the reason
+type startController struct {
+	// slots holds a value per wallet starting, it is nil without limit
+	slots   chan struct{}
+	timeout time.Duration
+
+	mtx      sync.Mutex
+	failures map[string]string
+}
+
+// newStartController returns a controller starting at most concurrency
+// wallets at once, without limit if it is not positive, and failing those
+// not started after timeout, which never fail if it is not positive
+func newStartController(concurrency int, timeout time.Duration) *startController {
+	c := &startController{
+		timeout:  timeout,
+		failures: make(map[string]string),
+	}
+	if concurrency > 0 {
+		c.slots = make(chan struct{}, concurrency)
+	}
+	return c
+}
+
+// run calls start, which starts the wallet of the actor, once fewer than
+// the limit of wallets are starting. It returns ErrActorShutdown if the
+// actor quits while it waits, and an error if start does not return within
+// the timeout, in which case the caller must shut the actor down, which
+// makes start return. The slot of the actor is only released then, so that
+// stuck wallets count against the limit until they are stopped. It runs
+// start directly if c is nil.
+func (c *startController) run(a *Actor, start func() error) error {
+	if c == nil {
+		return start()
+	}
+	if c.slots != nil {
+		select {
+		case c.slots <- struct{}{}:
+		case <-a.quit:
+			return ErrActorShutdown
+		}
+	}
+	began := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- start()
+		if c.slots != nil {
+			<-c.slots
+		}
+	}()
+
+	var timeout <-chan time.Time
+	if c.timeout > 0 {
+		timeout = time.After(c.timeout)
+	}
+	var err error
+	select {
+	case err = <-done:
+	case <-timeout:
+		err = fmt.Errorf("wallet not started after %v", c.timeout)
+	}
+	if err != nil && err != ErrActorShutdown {
+		c.fail(a, err)
+		return err
+	}
+	if err == nil {
+		log.Debugf("%s: Wallet started in %v", a, time.Since(began))
+	}
+	return err
+}
+
+// fail records that the actor failed to start
+func (c *startController) fail(a *Actor, err error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.failures[a.String()] = err.Error()
+}
+
+// Failures returns the reason each actor which failed to start failed for
+func (c *startController) Failures() map[string]string {
+	if c == nil {
+		return nil
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	failures := make(map[string]string, len(c.failures))
+	for name, reason := range c.failures {
+		failures[name] = reason
+	}
+	return failures
+}
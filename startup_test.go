@@ -0,0 +1,80 @@
+package btcsim
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStartControllerConcurrency(t *testing.T) {
+	c := newStartController(2, time.Minute)
+	var starting, peak int32
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func(a *Actor) {
+			defer wg.Done()
+			err := c.run(a, func() error {
+				n := atomic.AddInt32(&starting, 1)
+				for {
+					p := atomic.LoadInt32(&peak)
+					if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+						break
+					}
+				}
+				time.Sleep(10 * time.Millisecond)
+				atomic.AddInt32(&starting, -1)
+				return nil
+			})
+			if err != nil {
+				t.Errorf("run error: %v", err)
+			}
+		}(fakeActor("actor"))
+	}
+	wg.Wait()
+	if peak != 2 {
+		t.Errorf("%d wallets started at once, want 2", peak)
+	}
+	if f := c.Failures(); len(f) != 0 {
+		t.Errorf("failures %v, want none", f)
+	}
+}
+
+func TestStartControllerFailures(t *testing.T) {
+	c := newStartController(1, 20*time.Millisecond)
+	stuck, failed := fakeActor("stuck"), fakeActor("failed")
+
+	// a stuck wallet times out and keeps its slot until it returns
+	release := make(chan struct{})
+	err := c.run(stuck, func() error {
+		<-release
+		return ErrActorShutdown
+	})
+	if err == nil || !strings.Contains(err.Error(), "not started after 20ms") {
+		t.Errorf("run of a stuck wallet returned %v", err)
+	}
+	waiting := fakeActor("waiting")
+	close(waiting.quit)
+	if err := c.run(waiting, func() error { return nil }); err != ErrActorShutdown {
+		t.Errorf("run of an actor quitting while waiting returned %v", err)
+	}
+	close(release)
+
+	if err := c.run(failed, func() error { return errors.New("wallet exited") }); err == nil {
+		t.Errorf("run of a failing wallet succeeded")
+	}
+	failures := c.Failures()
+	if len(failures) != 2 || failures[failed.String()] != "wallet exited" ||
+		!strings.Contains(failures[stuck.String()], "not started") {
+		t.Errorf("failures %v, want the stuck and failed actors", failures)
+	}
+
+	// a nil controller only starts the wallet
+	var nilc *startController
+	if err := nilc.run(failed, func() error { return nil }); err != nil || nilc.Failures() != nil {
+		t.Errorf("run of a nil controller returned %v", err)
+	}
+}
@@ -0,0 +1,284 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+// stateFile is the name of the manifest of a saved simulation state
+const stateFile = "state.json"
+
+// simState is the manifest of a saved simulation state. The data
+// directories of the nodes, the miner and the actor wallets are saved
+// next to it, named after the node or miner and actor-i for the actor
+// started in slot i.
+type simState struct {
+	Height int32        `json:"height"`
+	Nodes  int          `json:"nodes"`
+	Actors []actorState `json:"actors"`
+}
+
+// actorState is the saved state of the actor started in slot Index
+type actorState struct {
+	Index     int      `json:"index"`
+	Profile   string   `json:"profile"`
+	Addresses []string `json:"addresses"`
+}
+
+// readState reads the manifest of the state saved in dir
+func readState(dir string) (*simState, error) {
+	file, err := os.Open(filepath.Join(dir, stateFile))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	s := &simState{}
+	if err := json.NewDecoder(file).Decode(s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// check returns an error if the state cannot be resumed with the given
+// number of nodes and actors up to stopBlock. The slots of the actors
+// which failed or were removed before the state was saved are not
+// restored.
+func (s *simState) check(nodes, actors, stopBlock int) error {
+	if s.Nodes != nodes {
+		return fmt.Errorf("saved state has %d node(s), not %d", s.Nodes, nodes)
+	}
+	for _, a := range s.Actors {
+		if a.Index >= actors {
+			return fmt.Errorf("saved state has actor %d, more than the %d actor(s) started",
+				a.Index, actors)
+		}
+	}
+	if int(s.Height) >= stopBlock {
+		return fmt.Errorf("saved state is at height %d, past the stop block %d",
+			s.Height, stopBlock)
+	}
+	return nil
+}
+
+// actor returns the saved state of the actor started in slot i
+func (s *simState) actor(i int) (*actorState, bool) {
+	for j := range s.Actors {
+		if s.Actors[j].Index == i {
+			return &s.Actors[j], true
+		}
+	}
+	return nil, false
+}
+
+// dataDir returns the data directory of the node with the given args
+func dataDir(args Args) (string, error) {
+	switch a := unwrapArgs(args).(type) {
+	case *btcdArgs:
+		return a.DataDir, nil
+	case *btcwalletArgs:
+		return a.DataDir, nil
+	}
+	return "", fmt.Errorf("%s: unknown data directory", args)
+}
+
+// restoreDataDir copies the data directory saved under name in the loaded
+// state to the data directory of the node with the given args, it does
+// nothing unless the run configured by cfg was resumed from a saved state
+func restoreDataDir(cfg *Config, args Args, name string) error {
+	if cfg.loadedState == nil {
+		return nil
+	}
+	dir, err := dataDir(args)
+	if err != nil {
+		return err
+	}
+	return copyDir(filepath.Join(cfg.LoadStatePath, name), dir)
+}
+
+// restoreActor restores the wallet, addresses and profile of the actor
+// started in slot i from the loaded state
+func restoreActor(a *Actor, i int) error {
+	saved, ok := a.cfg.loadedState.actor(i)
+	if !ok {
+		return fmt.Errorf("no saved state for actor %d", i)
+	}
+	if err := restoreDataDir(a.cfg, a.Args, fmt.Sprintf("actor-%d", i)); err != nil {
+		return err
+	}
+	addrs := make([]btcutil.Address, len(saved.Addresses))
+	for j, s := range saved.Addresses {
+		addr, err := btcutil.DecodeAddress(s, &chaincfg.SimNetParams)
+		if err != nil {
+			return err
+		}
+		addrs[j] = addr
+	}
+	a.ownedAddresses = addrs
+	if p, err := getProfile(saved.Profile); err == nil {
+		a.profile = p
+	}
+	a.restored = true
+	return nil
+}
+
+// saveState saves the state of the simulation to dir so that it can be
+// resumed with -load-state. The actors are paused and every process is
+// stopped first so that their data is consistent, the data directories
+// are only removed afterwards by the regular shutdown.
+func (com *Communication) saveState(dir string, miner *Miner, nodes []*Node, actors []*Actor) error {
+	height, err := nodes[0].client.GetBlockCount()
+	if err != nil {
+		return err
+	}
+	state := &simState{
+		Height: int32(height),
+		Nodes:  len(nodes),
+	}
+
+	for _, a := range actors {
+		a.Pause()
+	}
+	saved := make(map[string]Args)
+	if err := miner.Stop(); err != nil {
+		return err
+	}
+	saved["miner"] = miner.Args
+	// actors are keyed by the slot they were started in, as those which
+	// failed or were removed leave gaps in the list
+	for _, a := range actors {
+		if err := a.Node.Stop(); err != nil {
+			return err
+		}
+		saved[fmt.Sprintf("actor-%d", a.index)] = a.Args
+		s := actorState{Index: a.index, Profile: a.profile.Name}
+		for _, addr := range a.ownedAddresses {
+			s.Addresses = append(s.Addresses, addr.EncodeAddress())
+		}
+		state.Actors = append(state.Actors, s)
+	}
+	for _, n := range nodes {
+		if err := n.Stop(); err != nil {
+			return err
+		}
+		saved[n.String()] = n.Args
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	for name, args := range saved {
+		src, err := dataDir(args)
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(dir, name)
+		if err := os.RemoveAll(dst); err != nil {
+			return err
+		}
+		if err := copyDir(src, dst); err != nil {
+			return err
+		}
+	}
+	if err := writeJSON(filepath.Join(dir, stateFile), state); err != nil {
+		return err
+	}
+	log.Infof("Saved the state at height %d to %s", height, dir)
+	return nil
+}
+
+// queueWalletUtxos queues the spendable outputs of a restored or imported
+// wallet, as the blocks which created them were mined before the
+// simulation started. Outputs the chain does not have, such as those of an
+// imported wallet database built on another chain, are skipped.
+func (a *Actor) queueWalletUtxos() {
+	defer a.wg.Done()
+
+	unspent, err := a.client.ListUnspent()
+	if err != nil {
+		log.Errorf("%s: Cannot list unspent outputs: %v", a, err)
+		return
+	}
+	var stale int
+	for _, u := range unspent {
+		amount, err := btcutil.NewAmount(u.Amount)
+		if err != nil {
+			continue
+		}
+		// to be usable, the utxo amount should be split-able after
+		// deducting the fee
+		if amount <= btcutil.Amount(a.cfg.MaxSplit)*minFee {
+			continue
+		}
+		hash, err := wire.NewShaHashFromStr(u.TxID)
+		if err != nil {
+			continue
+		}
+		txOut, err := a.client.GetTxOut(hash, u.Vout, true)
+		if err != nil {
+			log.Errorf("%s: Cannot look up unspent output %s:%d: %v", a, u.TxID, u.Vout, err)
+			continue
+		}
+		if txOut == nil {
+			stale++
+			continue
+		}
+		select {
+		case a.utxoQueue.enqueue <- &TxOut{
+			OutPoint: wire.NewOutPoint(hash, u.Vout),
+			Amount:   amount,
+		}:
+		case <-a.quit:
+			return
+		}
+	}
+	if stale > 0 {
+		log.Warnf("%s: Skipped %d unspent outputs of the wallet missing from the chain", a, stale)
+	}
+}
+
+// copyDir copies the directory src and its content to dst
+func copyDir(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if info.IsDir() {
+			return os.MkdirAll(target, info.Mode().Perm()|0700)
+		}
+		return copyFile(path, target, info.Mode().Perm())
+	})
+}
+
+// copyFile copies the file src to dst with the given permissions
+func copyFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
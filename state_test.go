@@ -0,0 +1,132 @@
+package btcsim
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcjson"
+)
+
+func TestCopyDir(t *testing.T) {
+	src, err := ioutil.TempDir("", "btcsim-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(src)
+	if err := os.MkdirAll(filepath.Join(src, "simnet", "blocks"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"wallet.db":                            "wallet",
+		filepath.Join("simnet", "blocks", "1"): "block",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(src, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dst, err := ioutil.TempDir("", "btcsim-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dst)
+	if err := copyDir(src, dst); err != nil {
+		t.Fatalf("copyDir error: %v", err)
+	}
+	for name, content := range files {
+		b, err := ioutil.ReadFile(filepath.Join(dst, name))
+		if err != nil {
+			t.Errorf("cannot read copied %s: %v", name, err)
+			continue
+		}
+		if string(b) != content {
+			t.Errorf("copied %s got %q, want %q", name, b, content)
+		}
+	}
+}
+
+func TestReadState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "btcsim-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	saved := &simState{
+		Height: 15000,
+		Nodes:  2,
+		Actors: []actorState{{Index: 1, Profile: "spender", Addresses: []string{"addr"}}},
+	}
+	if err := writeJSON(filepath.Join(dir, stateFile), saved); err != nil {
+		t.Fatal(err)
+	}
+	s, err := readState(dir)
+	if err != nil {
+		t.Fatalf("readState error: %v", err)
+	}
+	if s.Height != 15000 || s.Nodes != 2 || len(s.Actors) != 1 || s.Actors[0].Index != 1 ||
+		s.Actors[0].Profile != "spender" || s.Actors[0].Addresses[0] != "addr" {
+		t.Errorf("readState got %+v, want %+v", s, saved)
+	}
+
+	tests := []struct {
+		nodes, actors, stopBlock int
+		ok                       bool
+	}{
+		{2, 2, 15010, true},
+		{2, 3, 15010, true},
+		{1, 2, 15010, false},
+		{2, 1, 15010, false},
+		{2, 2, 15000, false},
+	}
+	for _, test := range tests {
+		err := s.check(test.nodes, test.actors, test.stopBlock)
+		if (err == nil) != test.ok {
+			t.Errorf("check(%d, %d, %d) got error %v, want ok %v",
+				test.nodes, test.actors, test.stopBlock, err, test.ok)
+		}
+	}
+	// actors are found by the slot they were started in
+	if a, ok := s.actor(1); !ok || a.Profile != "spender" {
+		t.Errorf("actor(1) got %+v, %v", a, ok)
+	}
+	if _, ok := s.actor(0); ok {
+		t.Errorf("actor(0) found the state of actor 1")
+	}
+}
+
+// staleWallet is a mock wallet listing an output the chain does not have,
+// as a wallet database built on another chain does
+type staleWallet struct {
+	*MockWallet
+}
+
+func (w staleWallet) ListUnspent() ([]btcjson.ListUnspentResult, error) {
+	unspent, err := w.MockWallet.ListUnspent()
+	return append(unspent, btcjson.ListUnspentResult{
+		TxID:   shaHash(1).String(),
+		Amount: 50,
+	}), err
+}
+
+func TestQueueWalletUtxos(t *testing.T) {
+	chain := NewMockChain(DefaultConfig())
+	a := chain.NewActor("a")
+	defer StopMockActors(a)
+	mineMock(t, a, a)
+	mined := queued(a)
+	if len(mined) != 1 {
+		t.Fatalf("got %d mined utxos want 1", len(mined))
+	}
+
+	a.client.calls = staleWallet{a.client.calls.(*MockWallet)}
+	a.wg.Add(1)
+	a.queueWalletUtxos()
+	utxos := queued(a)
+	if len(utxos) != 1 || *utxos[0].OutPoint != *mined[0].OutPoint {
+		t.Errorf("queued %v want only %v", utxos, mined[0].OutPoint)
+	}
+}
@@ -0,0 +1,305 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/btcsuite/btcutil"
+)
+
+// TxRecord holds the statistics of a transaction sent by an actor
+type TxRecord struct {
+	TxID          string    `json:"txid"`
+	Actor         string    `json:"actor"`
+	Size          int       `json:"size"`
+	Fee           int64     `json:"fee"`
+	Inputs        int       `json:"inputs"`
+	Outputs       int       `json:"outputs"`
+	DataSize      int       `json:"datasize"`
+	SentTime      time.Time `json:"senttime"`
+	SentHeight    int32     `json:"sentheight"`
+	Height        int32     `json:"height"`
+	ConfirmedTime time.Time `json:"confirmedtime"`
+
+	// DoubleSpend is the id of the transaction this one conflicts with
+	// when it is a double spend attempt
+	DoubleSpend string `json:"doublespend,omitempty"`
+
+	// Replaces is the id of the transaction this one replaces with a
+	// higher fee when it is a fee bump
+	Replaces string `json:"replaces,omitempty"`
+
+	// Parent is the id of the transaction whose change this one spends
+	// when it is a child paying for its parent
+	Parent string `json:"parent,omitempty"`
+
+	// Multisig is set when the transaction funds or spends a multisig
+	// address, Signers is the number of cosigners who signed a spend
+	Multisig bool `json:"multisig,omitempty"`
+	Signers  int  `json:"signers,omitempty"`
+
+	// Participants is the number of actors who joined their inputs
+	// when the transaction is a coinjoin
+	Participants int `json:"participants,omitempty"`
+
+	// Depth is the number of unconfirmed ancestors of the transaction
+	// when it was sent as part of a chain
+	Depth int `json:"depth,omitempty"`
+
+	// Estimates are the fee rates in satoshis per byte the node estimated
+	// for confirmation within each target in blocks when the transaction
+	// was sent, with -feeaccuracy
+	Estimates map[int]float64 `json:"estimates,omitempty"`
+}
+
+// FeeRate returns the fee rate of the transaction in satoshis per byte
+func (r *TxRecord) FeeRate() float64 {
+	if r.Size == 0 {
+		return 0
+	}
+	return float64(r.Fee) / float64(r.Size)
+}
+
+// Confirmed reports whether the transaction was mined in a block
+func (r *TxRecord) Confirmed() bool {
+	return r.Height > 0
+}
+
+// Latency returns the time it took for the transaction to confirm
+func (r *TxRecord) Latency() time.Duration {
+	if !r.Confirmed() {
+		return 0
+	}
+	return r.ConfirmedTime.Sub(r.SentTime)
+}
+
+// LatencyBlocks returns the number of blocks it took for the transaction
+// to confirm, one if it was mined in the next block
+func (r *TxRecord) LatencyBlocks() int32 {
+	if !r.Confirmed() {
+		return 0
+	}
+	return r.Height - r.SentHeight
+}
+
+// blockTxs holds the transactions mined in a block, and the block itself
+// when it was fetched from the node
+type blockTxs struct {
+	height int32
+	time   time.Time
+	txids  []string
+	block  *btcutil.Block
+}
+
+// TxStats collects the statistics of every transaction sent by actors
+// and matches them with the blocks they are mined in
+type TxStats struct {
+	// count is the number of transactions recorded, it must only be
+	// accessed atomically and is kept first for 64-bit alignment
+	count uint64
+
+	sent    chan *TxRecord
+	blocks  chan *blockTxs
+	records []*TxRecord
+	pending map[string]*TxRecord
+
+	// blockFees are the fees of the transactions mined in every block,
+	// whose fee rates are also fed to the fee estimator
+	blockFees []*BlockFees
+	fees      *feeEstimator
+
+	// oracle has the fee rates estimated by the node, stamped on the
+	// transactions as they are sent, it is nil unless they are evaluated
+	oracle *feeOracle
+
+	// compositions are the compositions of every block compared with the
+	// transactions left in mempool, which follows the mempool of the node
+	compositions []*BlockComposition
+	mempool      *mempoolTracker
+
+	// events is where the transactions sent and confirmed are published
+	events *eventBus
+
+	// height is the height of the last block and blockCount the number
+	// of blocks connected while collecting
+	height     int32
+	blockCount int
+}
+
+// NewTxStats returns a new TxStats of the run configured by cfg
+func NewTxStats(cfg *Config) *TxStats {
+	return &TxStats{
+		sent:    make(chan *TxRecord, cfg.NumActors),
+		blocks:  make(chan *blockTxs),
+		pending: make(map[string]*TxRecord),
+		fees:    &feeEstimator{},
+	}
+}
+
+// collect runs as a goroutine and records sent transactions and
+// connected blocks until exit is closed
+func (s *TxStats) collect(exit <-chan struct{}) {
+	for {
+		select {
+		case r := <-s.sent:
+			r.SentHeight = s.height
+			r.Estimates = s.oracle.current()
+			s.records = append(s.records, r)
+			atomic.AddUint64(&s.count, 1)
+			s.pending[r.TxID] = r
+			s.events.publish(&Event{Kind: eventTxSent, Time: r.SentTime, Height: s.height, Tx: r})
+		case b := <-s.blocks:
+			s.height = b.height
+			s.blockCount++
+			var rates []float64
+			var fees int64
+			for _, txid := range b.txids {
+				r, ok := s.pending[txid]
+				if !ok {
+					continue
+				}
+				r.Height = b.height
+				r.ConfirmedTime = b.time
+				delete(s.pending, txid)
+				s.events.publish(&Event{Kind: eventTxConfirmed, Time: b.time, Height: b.height, Tx: r})
+				rates = append(rates, r.FeeRate())
+				fees += r.Fee
+			}
+			s.blockFees = append(s.blockFees, newBlockFees(b.height, rates, fees))
+			s.fees.add(rates)
+			var left []float64
+			for txid, r := range s.pending {
+				if s.mempool != nil && s.mempool.has(txid) {
+					left = append(left, r.FeeRate())
+				}
+			}
+			s.compositions = append(s.compositions, newBlockComposition(b, fees, rates, left))
+		case <-exit:
+			return
+		}
+	}
+}
+
+// Count returns the number of transactions recorded so far, it is safe
+// to call while collecting
+func (s *TxStats) Count() uint64 {
+	return atomic.LoadUint64(&s.count)
+}
+
+// Records returns the transactions recorded, it must only be called once
+// the collector has returned
+func (s *TxStats) Records() []*TxRecord {
+	return s.records
+}
+
+// BlockFees returns the fees of the transactions mined in every block, it
+// must only be called once the collector has returned
+func (s *TxStats) BlockFees() []*BlockFees {
+	return s.blockFees
+}
+
+// BlockCompositions returns the composition of every block, it must only
+// be called once the collector has returned
+func (s *TxStats) BlockCompositions() []*BlockComposition {
+	return s.compositions
+}
+
+// txStatsHeader is the header of the transaction statistics CSV
+var txStatsHeader = []string{
+	"txid", "actor", "size", "fee", "inputs", "outputs",
+	"senttime", "sentheight", "height", "confirmedtime",
+	"latency", "latencyblocks", "doublespend", "replaces", "parent",
+	"multisig", "signers", "datasize", "participants", "depth",
+}
+
+// writeTxStatsCSV writes the records as CSV with a header row
+func writeTxStatsCSV(w io.Writer, records []*TxRecord) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(txStatsHeader); err != nil {
+		return err
+	}
+	for _, r := range records {
+		var confirmedTime string
+		if r.Confirmed() {
+			confirmedTime = r.ConfirmedTime.Format(time.RFC3339Nano)
+		}
+		row := []string{
+			r.TxID,
+			r.Actor,
+			strconv.Itoa(r.Size),
+			strconv.FormatInt(r.Fee, 10),
+			strconv.Itoa(r.Inputs),
+			strconv.Itoa(r.Outputs),
+			r.SentTime.Format(time.RFC3339Nano),
+			strconv.Itoa(int(r.SentHeight)),
+			strconv.Itoa(int(r.Height)),
+			confirmedTime,
+			strconv.FormatFloat(r.Latency().Seconds(), 'f', -1, 64),
+			strconv.Itoa(int(r.LatencyBlocks())),
+			r.DoubleSpend,
+			r.Replaces,
+			r.Parent,
+			strconv.FormatBool(r.Multisig),
+			strconv.Itoa(r.Signers),
+			strconv.Itoa(r.DataSize),
+			strconv.Itoa(r.Participants),
+			strconv.Itoa(r.Depth),
+		}
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// txRecordJSON is the JSON representation of a TxRecord which includes
+// the latencies
+type txRecordJSON struct {
+	*TxRecord
+	Latency       float64 `json:"latency"`
+	LatencyBlocks int32   `json:"latencyblocks"`
+}
+
+// writeTxStatsJSON writes the records as a JSON array
+func writeTxStatsJSON(w io.Writer, records []*TxRecord) error {
+	rs := make([]txRecordJSON, len(records))
+	for i, r := range records {
+		rs[i] = txRecordJSON{
+			TxRecord:      r,
+			Latency:       r.Latency().Seconds(),
+			LatencyBlocks: r.LatencyBlocks(),
+		}
+	}
+	enc := json.NewEncoder(w)
+	return enc.Encode(rs)
+}
+
+// writeTxStats writes the records to the given path as JSON if the path
+// has a .json extension, CSV otherwise
+func writeTxStats(path string, records []*TxRecord) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if filepath.Ext(path) == ".json" {
+		err = writeTxStatsJSON(file, records)
+	} else {
+		err = writeTxStatsCSV(file, records)
+	}
+	if err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
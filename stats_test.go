@@ -0,0 +1,84 @@
+package btcsim
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestTxStatsCollect(t *testing.T) {
+	// use an unbuffered channel to keep the records and blocks in order
+	s := NewTxStats(DefaultConfig())
+	s.sent = make(chan *TxRecord)
+	exit := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		s.collect(exit)
+		close(done)
+	}()
+
+	sent := time.Now()
+	s.blocks <- &blockTxs{height: 10, time: sent}
+	s.sent <- &TxRecord{TxID: "a", SentTime: sent}
+	s.sent <- &TxRecord{TxID: "b", SentTime: sent}
+	s.blocks <- &blockTxs{height: 11, time: sent.Add(time.Minute), txids: []string{"coinbase", "a"}}
+	close(exit)
+	<-done
+
+	records := s.Records()
+	if len(records) != 2 {
+		t.Fatalf("got %d records, want 2", len(records))
+	}
+	a, b := records[0], records[1]
+	if !a.Confirmed() || a.Height != 11 || a.LatencyBlocks() != 1 || a.Latency() != time.Minute {
+		t.Errorf("unexpected confirmed record: %+v", a)
+	}
+	if b.Confirmed() || b.SentHeight != 10 || b.Latency() != 0 {
+		t.Errorf("unexpected unconfirmed record: %+v", b)
+	}
+}
+
+func TestWriteTxStats(t *testing.T) {
+	sent := time.Date(2015, 2, 19, 0, 0, 0, 0, time.UTC)
+	records := []*TxRecord{{
+		TxID:          "a",
+		Actor:         "actor-18557",
+		Size:          226,
+		Fee:           10000,
+		Inputs:        1,
+		Outputs:       2,
+		SentTime:      sent,
+		SentHeight:    10,
+		Height:        12,
+		ConfirmedTime: sent.Add(90 * time.Second),
+	}}
+
+	var buf bytes.Buffer
+	if err := writeTxStatsCSV(&buf, records); err != nil {
+		t.Fatalf("writeTxStatsCSV error: %v", err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("cannot read CSV: %v", err)
+	}
+	if len(rows) != 2 || len(rows[1]) != len(txStatsHeader) {
+		t.Fatalf("unexpected CSV rows: %v", rows)
+	}
+	if latency, blocks := rows[1][10], rows[1][11]; latency != "90" || blocks != "2" {
+		t.Errorf("CSV latency got %s, %s want 90, 2", latency, blocks)
+	}
+
+	buf.Reset()
+	if err := writeTxStatsJSON(&buf, records); err != nil {
+		t.Fatalf("writeTxStatsJSON error: %v", err)
+	}
+	var decoded []map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("cannot decode JSON: %v", err)
+	}
+	if len(decoded) != 1 || decoded[0]["txid"] != "a" || decoded[0]["latency"] != 90.0 {
+		t.Errorf("unexpected JSON: %s", buf.String())
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"fmt"
+	"time"
+)
+
+// status is a snapshot of the progress of a running simulation
+type status struct {
+	height  int64
+	mempool int
+	tps     float64
+	healthy int
+	actors  int
+}
+
+// String returns the status as a single line
+func (s *status) String() string {
+	return fmt.Sprintf("height %d, mempool %d tx, %.2f tx/s, %d/%d actors healthy",
+		s.height, s.mempool, s.tps, s.healthy, s.actors)
+}
+
+// running reports whether the actor has not been shut down
+func (a *Actor) running() bool {
+	select {
+	case <-a.quit:
+		return false
+	default:
+		return true
+	}
+}
+
+// reportStatus logs the status of the simulation every interval until
+// the simulation exits
+func (com *Communication) reportStatus(interval time.Duration) {
+	defer com.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	lastCount := com.txStats.Count()
+	lastTime := time.Now()
+	for {
+		select {
+		case now := <-ticker.C:
+			actors := com.Actors()
+			s := &status{
+				height:  int64(com.mempool.Height()),
+				mempool: com.mempool.Size(),
+				actors:  len(actors),
+			}
+
+			count := com.txStats.Count()
+			s.tps = float64(count-lastCount) / now.Sub(lastTime).Seconds()
+			lastCount, lastTime = count, now
+
+			for _, a := range actors {
+				if a.running() {
+					s.healthy++
+				}
+			}
+			log.Infof("Status: %v", s)
+		case <-com.exit:
+			return
+		}
+	}
+}
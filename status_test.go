@@ -0,0 +1,17 @@
+package btcsim
+
+import "testing"
+
+func TestStatusString(t *testing.T) {
+	s := &status{
+		height:  1234,
+		mempool: 56,
+		tps:     12.345,
+		healthy: 9,
+		actors:  10,
+	}
+	want := "height 1234, mempool 56 tx, 12.35 tx/s, 9/10 actors healthy"
+	if got := s.String(); got != want {
+		t.Errorf("String: got %q, want %q", got, want)
+	}
+}
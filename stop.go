@@ -0,0 +1,37 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"sync/atomic"
+)
+
+// stopAfter stops the simulation once the given number of blocks have
+// been mined since transactions started being generated, or the given
+// number of transactions have been sent, whichever comes first. Zero
+// disables a condition.
+func (com *Communication) stopAfter(blocks, txs int) {
+	if blocks > 0 {
+		var mined int64
+		com.events.subscribe(func(e *Event) {
+			if e.Height < int32(com.cfg.StartBlock) {
+				return
+			}
+			if atomic.AddInt64(&mined, 1) == int64(blocks) {
+				log.Infof("Stopping after %d blocks", blocks)
+				com.Exit()
+			}
+		}, eventBlockMined)
+	}
+	if txs > 0 {
+		var sent int64
+		com.events.subscribe(func(e *Event) {
+			if atomic.AddInt64(&sent, 1) == int64(txs) {
+				log.Infof("Stopping after %d transactions", txs)
+				com.Exit()
+			}
+		}, eventTxSent)
+	}
+}
@@ -0,0 +1,75 @@
+package btcsim
+
+import (
+	"testing"
+	"time"
+)
+
+// exited returns whether the simulation was told to exit
+func exited(com *Communication) bool {
+	select {
+	case <-com.exit:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestStopAfterBlocks(t *testing.T) {
+	com := &Communication{cfg: DefaultConfig(), events: newEventBus(), exit: make(chan struct{})}
+	com.stopAfter(2, 0)
+
+	// blocks before transactions start are not counted
+	com.events.publish(&Event{Kind: eventBlockMined, Height: int32(com.cfg.StartBlock) - 1})
+	com.events.publish(&Event{Kind: eventBlockMined, Height: int32(com.cfg.StartBlock)})
+	if exited(com) {
+		t.Fatalf("exited after a single block")
+	}
+	com.events.publish(&Event{Kind: eventBlockMined, Height: int32(com.cfg.StartBlock) + 1})
+	if !exited(com) {
+		t.Fatalf("not exited after two blocks")
+	}
+	// the simulation can only exit once
+	com.events.publish(&Event{Kind: eventBlockMined, Height: int32(com.cfg.StartBlock) + 2})
+}
+
+func TestStopAfterTxs(t *testing.T) {
+	com := &Communication{cfg: DefaultConfig(), events: newEventBus(), exit: make(chan struct{})}
+	com.stopAfter(0, 3)
+
+	for i := 0; i < 3; i++ {
+		if exited(com) {
+			t.Fatalf("exited after %d transactions", i)
+		}
+		com.events.publish(&Event{Kind: eventTxSent, Tx: &TxRecord{}})
+	}
+	if !exited(com) {
+		t.Fatalf("not exited after 3 transactions")
+	}
+	// blocks don't count when disabled
+	com = &Communication{cfg: DefaultConfig(), events: newEventBus(), exit: make(chan struct{})}
+	com.stopAfter(0, 3)
+	com.events.publish(&Event{Kind: eventBlockMined, Height: int32(com.cfg.StartBlock)})
+	if exited(com) {
+		t.Fatalf("exited after a block")
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	com := &Communication{exit: make(chan struct{})}
+	com.wg.Add(1)
+	go com.timeout(time.Millisecond)
+	com.wg.Wait()
+	if !exited(com) {
+		t.Fatalf("not exited after the duration")
+	}
+	// exiting again, as the interrupt handler does, must not panic
+	com.Exit()
+
+	// the timeout returns without exiting again once the simulation exited
+	com = &Communication{exit: make(chan struct{})}
+	com.wg.Add(1)
+	go com.timeout(time.Hour)
+	com.Exit()
+	com.wg.Wait()
+}
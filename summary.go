@@ -0,0 +1,478 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Summary is the summary of a simulation run
+type Summary struct {
+	RunID               int                       `json:"runid"`
+	Seed                int64                     `json:"seed"`
+	WallTime            time.Duration             `json:"walltime"`
+	Height              int32                     `json:"height"`
+	Blocks              int                       `json:"blocks"`
+	Transactions        int                       `json:"transactions"`
+	Confirmed           int                       `json:"confirmed"`
+	MeanConfTime        time.Duration             `json:"meanconftime"`
+	MedianConf          time.Duration             `json:"medianconftime"`
+	P95ConfTime         time.Duration             `json:"p95conftime"`
+	MaxMempool          int                       `json:"maxmempool"`
+	MaxBlockSize        int                       `json:"maxblocksize"`
+	MedianFeeRate       float64                   `json:"medianfeerate"`
+	BlockSelection      *BlockSelection           `json:"blockselection,omitempty"`
+	TPS                 float64                   `json:"tps"`
+	MaxTPB              int                       `json:"maxtpb"`
+	Crashes             int                       `json:"crashes"`
+	FailedActors        int                       `json:"failedactors"`
+	StartFailures       map[string]string         `json:"startfailures,omitempty"`
+	InvariantChecks     int                       `json:"invariantchecks"`
+	InvariantViolations int                       `json:"invariantviolations"`
+	AuditIssues         int                       `json:"auditissues"`
+	LostFunds           int                       `json:"lostfunds"`
+	Assertions          int                       `json:"assertions"`
+	AssertionsFailed    int                       `json:"assertionsfailed"`
+	WalletKills         map[string]int            `json:"walletkills,omitempty"`
+	DoubleSpends        int                       `json:"doublespends"`
+	DoubleSpent         int                       `json:"doublespent"`
+	FeeBumps            int                       `json:"feebumps"`
+	BumpsConfirmed      int                       `json:"bumpsconfirmed"`
+	BumpedConfirmed     int                       `json:"bumpedconfirmed"`
+	Children            int                       `json:"children"`
+	PackagesMined       int                       `json:"packagesmined"`
+	ParentsMinedFirst   int                       `json:"parentsminedfirst"`
+	ChainTxs            int                       `json:"chaintxs"`
+	ChainsMined         int                       `json:"chainsmined"`
+	ChainDepth          int                       `json:"chaindepth"`
+	ChainRejected       int                       `json:"chainrejected"`
+	MultisigSpends      int                       `json:"multisigspends"`
+	MultisigConfirmed   int                       `json:"multisigconfirmed"`
+	Coinjoins           int                       `json:"coinjoins"`
+	CoinjoinsConfirmed  int                       `json:"coinjoinsconfirmed"`
+	DataTxs             int                       `json:"datatxs"`
+	DataBytes           int                       `json:"databytes"`
+	Consolidations      int                       `json:"consolidations"`
+	Consolidated        int                       `json:"consolidated"`
+	Payments            int                       `json:"payments"`
+	PaymentsConfirmed   int                       `json:"paymentsconfirmed"`
+	Invoices            *InvoiceStats             `json:"invoices,omitempty"`
+	Merchants           []*MerchantStats          `json:"merchants,omitempty"`
+	Pools               []*PoolStats              `json:"pools,omitempty"`
+	Exchanges           []*ExchangeStats          `json:"exchanges,omitempty"`
+	Dice                []*DiceStats              `json:"dice,omitempty"`
+	ActorBalances       map[string]int64          `json:"actorbalances"`
+	ActorPayments       map[string]PaymentCounts  `json:"actorpayments"`
+	Latency             []*FeeBandLatency         `json:"latency,omitempty"`
+	FeeAccuracy         []*FeeTargetAccuracy      `json:"feeaccuracy,omitempty"`
+	Dust                *DustStats                `json:"dust,omitempty"`
+	Orphans             *OrphanStats              `json:"orphans,omitempty"`
+	Propagation         *PropagationStats         `json:"propagation,omitempty"`
+	Mempools            *MempoolDivergence        `json:"mempools,omitempty"`
+	Evictions           *EvictionStats            `json:"evictions,omitempty"`
+	Fuzz                []*FuzzStats              `json:"fuzz,omitempty"`
+	Timelocks           *TimelockStats            `json:"timelocks,omitempty"`
+	UtxoSet             []*UtxoSnapshot           `json:"utxoset,omitempty"`
+	Resources           map[string]*ResourceUsage `json:"resources,omitempty"`
+	RPC                 []*RPCLatency             `json:"rpc,omitempty"`
+	Wallets             []*WalletVersionStats     `json:"wallets,omitempty"`
+	WatchOnly           []*WatchOnlyStats         `json:"watchonly,omitempty"`
+	Attack              *AttackStats              `json:"attack,omitempty"`
+}
+
+// PaymentCounts are the numbers of confirmed payments sent and received
+// by an actor
+type PaymentCounts struct {
+	Sent     int `json:"sent"`
+	Received int `json:"received"`
+}
+
+// NewSummary returns the summary of the given transaction statistics of the
+// run configured by cfg
+func NewSummary(cfg *Config, stats *TxStats, wallTime time.Duration) *Summary {
+	s := &Summary{
+		RunID:         cfg.RunID,
+		Seed:          cfg.Seed,
+		WallTime:      wallTime,
+		Height:        stats.height,
+		Blocks:        stats.blockCount,
+		Transactions:  len(stats.records),
+		MaxBlockSize:  cfg.MaxBlockSize,
+		ActorBalances: make(map[string]int64),
+		ActorPayments: make(map[string]PaymentCounts),
+	}
+
+	// bumped are the transactions replaced by a fee bump
+	bumped := make(map[string]bool)
+	byID := make(map[string]*TxRecord, len(stats.records))
+	for _, r := range stats.records {
+		if r.Replaces != "" {
+			bumped[r.Replaces] = true
+		}
+		byID[r.TxID] = r
+	}
+
+	var latencies []time.Duration
+	var total time.Duration
+	var rates []float64
+	for _, r := range stats.records {
+		if r.Size > 0 {
+			rates = append(rates, r.FeeRate())
+		}
+		if r.DoubleSpend != "" {
+			s.DoubleSpends++
+			if r.Confirmed() {
+				s.DoubleSpent++
+			}
+		}
+		if r.Replaces != "" {
+			s.FeeBumps++
+			if r.Confirmed() {
+				s.BumpsConfirmed++
+			}
+		} else if bumped[r.TxID] && r.Confirmed() {
+			s.BumpedConfirmed++
+		}
+		if r.DataSize > 0 {
+			s.DataTxs++
+			s.DataBytes += r.DataSize
+		}
+		if r.Multisig && r.Signers > 0 {
+			s.MultisigSpends++
+			if r.Confirmed() {
+				s.MultisigConfirmed++
+			}
+		}
+		if r.Participants > 0 {
+			s.Coinjoins++
+			if r.Confirmed() {
+				s.CoinjoinsConfirmed++
+			}
+		}
+		if r.Depth > 0 {
+			s.ChainTxs++
+			if r.Depth > s.ChainDepth {
+				s.ChainDepth = r.Depth
+			}
+			if parent, ok := byID[r.Parent]; ok && parent.Confirmed() && r.Height == parent.Height {
+				s.ChainsMined++
+			}
+		} else if r.Parent != "" {
+			s.Children++
+			// a parent mined in the same block as its child was
+			// selected as a package, one mined before was not
+			// waiting for its child
+			if parent, ok := byID[r.Parent]; ok && parent.Confirmed() {
+				if r.Height == parent.Height {
+					s.PackagesMined++
+				} else if !r.Confirmed() || parent.Height < r.Height {
+					s.ParentsMinedFirst++
+				}
+			}
+		}
+		if r.Confirmed() {
+			latencies = append(latencies, r.Latency())
+			total += r.Latency()
+		}
+	}
+	s.Confirmed = len(latencies)
+	if s.Confirmed > 0 {
+		sort.Sort(durations(latencies))
+		s.MeanConfTime = total / time.Duration(s.Confirmed)
+		s.MedianConf = percentile(latencies, 50)
+		s.P95ConfTime = percentile(latencies, 95)
+	}
+	if len(rates) > 0 {
+		sort.Float64s(rates)
+		s.MedianFeeRate = rates[len(rates)/2]
+	}
+	s.Latency = newLatencyStats(stats.records)
+	s.FeeAccuracy = newFeeAccuracy(stats.records, stats.blockFees)
+	if len(stats.compositions) > 0 {
+		s.BlockSelection = newBlockSelection(stats.compositions)
+	}
+	return s
+}
+
+// durations implements sort.Interface for a slice of time.Duration
+type durations []time.Duration
+
+func (d durations) Len() int           { return len(d) }
+func (d durations) Less(i, j int) bool { return d[i] < d[j] }
+func (d durations) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+
+// percentile returns the p-th percentile of the sorted durations using
+// the nearest-rank method
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return sorted[rank(len(sorted), p)]
+}
+
+// rank returns the index of the p-th percentile of n sorted values using
+// the nearest-rank method, n must be positive
+func rank(n int, p float64) int {
+	r := int(math.Ceil(p / 100 * float64(n)))
+	if r < 1 {
+		r = 1
+	}
+	return r - 1
+}
+
+// Write writes a human readable form of the summary to w
+func (s *Summary) Write(w io.Writer) error {
+	var lines []string
+	if s.RunID != 0 {
+		lines = append(lines, fmt.Sprintf("Simulation run: %d", s.RunID))
+	}
+	lines = append(lines,
+		fmt.Sprintf("Simulation seed: %d", s.Seed),
+		fmt.Sprintf("Simulation wall time: %v", s.WallTime),
+		fmt.Sprintf("Final block height: %d", s.Height),
+		fmt.Sprintf("Blocks mined: %d", s.Blocks),
+		fmt.Sprintf("Transactions sent: %d (%d confirmed)", s.Transactions, s.Confirmed),
+		fmt.Sprintf("Confirmation time: mean %v, median %v, 95th percentile %v",
+			s.MeanConfTime, s.MedianConf, s.P95ConfTime),
+		fmt.Sprintf("Mempool high-water mark: %d transactions", s.MaxMempool),
+		fmt.Sprintf("Maximum block size: %d bytes", s.MaxBlockSize),
+		fmt.Sprintf("Median fee rate: %.2f sat/B", s.MedianFeeRate),
+		fmt.Sprintf("Average transactions per sec: %.2f", s.TPS),
+		fmt.Sprintf("Maximum transactions per block: %d", s.MaxTPB))
+	if b := s.BlockSelection; b != nil {
+		lines = append(lines, fmt.Sprintf("Block selection: %s", b))
+	}
+	for _, l := range s.Latency {
+		lines = append(lines, fmt.Sprintf("Confirmation latency at %s sat/B: %d of %d confirmed, median %d blocks or %v, 95th percentile %d blocks or %v",
+			l.Band, l.Confirmed, l.Txs, l.MedianBlocks, l.MedianTime, l.P95Blocks, l.P95Time))
+	}
+	for _, a := range s.FeeAccuracy {
+		lines = append(lines, fmt.Sprintf("Fee estimates for %d blocks: %d of %d high enough (%.1f%%), mean error %+.2f sat/B, mean absolute error %.2f sat/B, median ratio %.2f",
+			a.Target, a.Hits, a.Samples, 100*a.HitRate, a.MeanError, a.MeanAbsError, a.MedianRatio))
+	}
+	if s.Crashes > 0 {
+		lines = append(lines, fmt.Sprintf("Actor crashes: %d", s.Crashes))
+	}
+	if s.Payments > 0 {
+		lines = append(lines, fmt.Sprintf("Payments between actors: %d (%d confirmed, %d outstanding)",
+			s.Payments, s.PaymentsConfirmed, s.Payments-s.PaymentsConfirmed))
+	}
+	if i := s.Invoices; i != nil {
+		lines = append(lines, fmt.Sprintf("Invoices: %d issued, %d paid (%d confirmed), %d expired, mean time to pay %v and to confirm %v",
+			i.Issued, i.Paid, i.Confirmed, i.Expired, i.MeanPayTime, i.MeanConfirmTime))
+	}
+	for _, m := range s.Merchants {
+		lines = append(lines, fmt.Sprintf("Merchant %s: %s", m.Name, m))
+	}
+	for _, p := range s.Pools {
+		lines = append(lines, fmt.Sprintf("Pool %s: %s", p.Name, p))
+	}
+	for _, e := range s.Exchanges {
+		lines = append(lines, fmt.Sprintf("Exchange %s: %s", e.Name, e))
+	}
+	for _, d := range s.Dice {
+		lines = append(lines, fmt.Sprintf("Dice site %s: %s", d.Name, d))
+	}
+	if s.FailedActors > 0 {
+		lines = append(lines, fmt.Sprintf("Failed actors: %d", s.FailedActors))
+	}
+	if len(s.StartFailures) > 0 {
+		names := make([]string, 0, len(s.StartFailures))
+		for name := range s.StartFailures {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		var failures []string
+		for _, name := range names {
+			failures = append(failures, fmt.Sprintf("%s (%s)", name, s.StartFailures[name]))
+		}
+		lines = append(lines, fmt.Sprintf("Actors failed to start: %s", strings.Join(failures, ", ")))
+	}
+	if len(s.WalletKills) > 0 {
+		stages := make([]string, 0, len(s.WalletKills))
+		for stage := range s.WalletKills {
+			stages = append(stages, stage)
+		}
+		sort.Strings(stages)
+		var kills []string
+		for _, stage := range stages {
+			kills = append(kills, fmt.Sprintf("%d %s", s.WalletKills[stage], stage))
+		}
+		lines = append(lines, fmt.Sprintf("Wallets killed while sending: %s", strings.Join(kills, ", ")))
+	}
+	if s.Assertions > 0 {
+		lines = append(lines, fmt.Sprintf("Scenario assertions: %d (%d failed)",
+			s.Assertions, s.AssertionsFailed))
+	}
+	if s.AuditIssues > 0 {
+		lines = append(lines, fmt.Sprintf("Wallet audit differences: %d (%d losing funds)",
+			s.AuditIssues, s.LostFunds))
+	}
+	if s.InvariantChecks > 0 {
+		lines = append(lines, fmt.Sprintf("Balance invariant checks: %d (%d violated)",
+			s.InvariantChecks, s.InvariantViolations))
+	}
+	if s.FeeBumps > 0 {
+		lines = append(lines, fmt.Sprintf("Fee bumps accepted: %d, %d confirmed, %d original transactions confirmed instead",
+			s.FeeBumps, s.BumpsConfirmed, s.BumpedConfirmed))
+	}
+	if s.Children > 0 {
+		lines = append(lines, fmt.Sprintf("Children paying for their parent: %d, %d mined with their parent, %d parents mined first",
+			s.Children, s.PackagesMined, s.ParentsMinedFirst))
+	}
+	if s.DataTxs > 0 {
+		lines = append(lines, fmt.Sprintf("Transactions embedding data: %d (%d bytes of OP_RETURN scripts)",
+			s.DataTxs, s.DataBytes))
+	}
+	if s.Consolidations > 0 {
+		lines = append(lines, fmt.Sprintf("Consolidations: %d transactions swept %d utxos, reducing the utxo count by %d",
+			s.Consolidations, s.Consolidated, s.Consolidated-s.Consolidations))
+	}
+	if s.MultisigSpends > 0 {
+		lines = append(lines, fmt.Sprintf("Multisig spends: %d (%d confirmed)",
+			s.MultisigSpends, s.MultisigConfirmed))
+	}
+	if s.ChainTxs > 0 || s.ChainRejected > 0 {
+		lines = append(lines, fmt.Sprintf("Chained transactions: %d up to a depth of %d, %d mined with their parent, %d rejected",
+			s.ChainTxs, s.ChainDepth, s.ChainsMined, s.ChainRejected))
+	}
+	if s.Coinjoins > 0 {
+		lines = append(lines, fmt.Sprintf("Coinjoins: %d (%d confirmed)",
+			s.Coinjoins, s.CoinjoinsConfirmed))
+	}
+	if s.DoubleSpends > 0 {
+		lines = append(lines, fmt.Sprintf("Double spends: %d accepted by the miner, %d confirmed",
+			s.DoubleSpends, s.DoubleSpent))
+	}
+
+	if d := s.Dust; d != nil {
+		lines = append(lines,
+			fmt.Sprintf("Dust outputs: %d created by %d transactions, %d swept, %d uneconomical to sweep, %d transactions rejected",
+				d.Outputs, d.Txs, d.Swept, d.Uneconomical, d.Rejected))
+	}
+	if o := s.Orphans; o != nil {
+		lines = append(lines, fmt.Sprintf("Orphan transactions: %s", o))
+	}
+
+	if p := s.Propagation; p != nil {
+		lines = append(lines,
+			fmt.Sprintf("Block propagation: %d of %d blocks reached all %d nodes, delay mean %v, median %v, 95th percentile %v, max %v",
+				p.Propagated, p.Blocks, p.Nodes, p.MeanDelay, p.MedianDelay, p.P95Delay, p.MaxDelay),
+			fmt.Sprintf("Stale blocks: %d, %d blocks disconnected by reorgs", p.Stale, p.Disconnected))
+		nodes := make([]string, 0, len(p.NodeDelays))
+		for node := range p.NodeDelays {
+			nodes = append(nodes, node)
+		}
+		sort.Strings(nodes)
+		for _, node := range nodes {
+			lines = append(lines, fmt.Sprintf("Propagation delay of %s: mean %v", node, p.NodeDelays[node]))
+		}
+	}
+
+	if m := s.Mempools; m != nil {
+		lines = append(lines, fmt.Sprintf("Mempool divergence: %.1f tx missing from some mempool on average, at most %d, largest difference between two nodes %d tx over %d samples",
+			m.MeanDivergent, m.MaxDivergent, m.MaxPair, m.Samples))
+	}
+	if e := s.Evictions; e != nil {
+		lines = append(lines, fmt.Sprintf("Mempool evictions: %s", e))
+	}
+	for _, f := range s.Fuzz {
+		lines = append(lines, fmt.Sprintf("Fuzz case %s: %s", f.Case, f))
+	}
+	if l := s.Timelocks; l != nil {
+		lines = append(lines, fmt.Sprintf("Locked transactions: %s", l))
+	}
+	if n := len(s.UtxoSet); n > 0 {
+		first, last := s.UtxoSet[0], s.UtxoSet[n-1]
+		lines = append(lines, fmt.Sprintf("UTXO set: %s, from %d outputs at height %d",
+			last, first.Count, first.Height))
+	}
+
+	processes := make([]string, 0, len(s.Resources))
+	for process := range s.Resources {
+		processes = append(processes, process)
+	}
+	sort.Strings(processes)
+	for _, process := range processes {
+		lines = append(lines, fmt.Sprintf("Resources of %s: %s", process, s.Resources[process]))
+	}
+
+	for _, w := range s.Wallets {
+		lines = append(lines, fmt.Sprintf("Wallet %s (%s): %d actors, %d payments sent and %d received, cpu %v, peak rss %.1f MB",
+			w.Name, w.Exe, w.Actors, w.Sent, w.Received, w.CPUTime, float64(w.PeakRSS)/1e6))
+		for _, l := range w.RPC {
+			lines = append(lines, fmt.Sprintf("Wallet %s RPC latency of %s: %d calls (%d failed), mean %v, median %v, 95th percentile %v",
+				w.Name, l.Method, l.Calls, l.Errors, l.Mean, l.Median, l.P95))
+		}
+	}
+
+	for _, w := range s.WatchOnly {
+		lines = append(lines, fmt.Sprintf("Watch-only %s: %s", w.Name, w))
+	}
+
+	// the latency to every node is left to the rpc statistics file
+	for _, l := range s.RPC {
+		if l.Node == rpcAllNodes {
+			lines = append(lines, fmt.Sprintf("RPC latency of %s: %d calls (%d failed), mean %v, median %v, 95th percentile %v, max %v",
+				l.Method, l.Calls, l.Errors, l.Mean, l.Median, l.P95, l.Max))
+		}
+	}
+
+	if a := s.Attack; a != nil {
+		lines = append(lines,
+			fmt.Sprintf("Attack: %s strategy with %.0f%% of the blocks", a.Strategy, a.Power*100),
+			fmt.Sprintf("Attack blocks: %d by the attacker and %d by the miner in the chain, %d orphaned and %d reversed",
+				a.AttackerBlocks, a.HonestBlocks, a.Orphaned, a.Reversed),
+			fmt.Sprintf("Attack revenue share: %.1f%% of the blocks in the chain", a.RevenueShare*100),
+			fmt.Sprintf("Attack reorgs: %d, deepest %d", a.Reorgs, a.MaxReorgDepth),
+			fmt.Sprintf("Attack successes: %d of %d attempts reversed at least %d confirmations",
+				a.Successes, a.Attempts, a.Confs))
+	}
+
+	actors := make([]string, 0, len(s.ActorBalances))
+	for actor := range s.ActorBalances {
+		actors = append(actors, actor)
+	}
+	sort.Strings(actors)
+	for _, actor := range actors {
+		lines = append(lines, fmt.Sprintf("%s balance: %d satoshi", actor, s.ActorBalances[actor]))
+		if p, ok := s.ActorPayments[actor]; ok {
+			lines = append(lines, fmt.Sprintf("%s confirmed payments: %d sent, %d received",
+				actor, p.Sent, p.Received))
+		}
+	}
+
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeSummary writes the summary as JSON to the given path
+func writeSummary(path string, s *Summary) error {
+	return writeJSON(path, s)
+}
+
+// writeJSON writes v as JSON to the given path
+func writeJSON(path string, v interface{}) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(file).Encode(v); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,131 @@
+package btcsim
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPercentile(t *testing.T) {
+	sorted := []time.Duration{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	tests := []struct {
+		p    float64
+		want time.Duration
+	}{
+		{0, 1},
+		{50, 5},
+		{95, 10},
+		{100, 10},
+	}
+	for _, test := range tests {
+		if got := percentile(sorted, test.p); got != test.want {
+			t.Errorf("percentile(%v) got %v, want %v", test.p, got, test.want)
+		}
+	}
+	if got := percentile(nil, 50); got != 0 {
+		t.Errorf("percentile of empty slice got %v, want 0", got)
+	}
+}
+
+func TestNewSummary(t *testing.T) {
+	sent := time.Now()
+	stats := NewTxStats(DefaultConfig())
+	stats.height = 120
+	stats.blockCount = 20
+	for i := 1; i <= 4; i++ {
+		stats.records = append(stats.records, &TxRecord{
+			SentTime:      sent,
+			Height:        100 + int32(i),
+			ConfirmedTime: sent.Add(time.Duration(i) * time.Second),
+		})
+	}
+	// unconfirmed
+	stats.records = append(stats.records, &TxRecord{SentTime: sent})
+	// double spends, only the first one succeeded
+	stats.records[0].DoubleSpend = "a"
+	stats.records[4].DoubleSpend = "b"
+
+	s := NewSummary(DefaultConfig(), stats, time.Minute)
+	if s.Transactions != 5 || s.Confirmed != 4 || s.Height != 120 || s.Blocks != 20 {
+		t.Errorf("unexpected summary counts: %+v", s)
+	}
+	if s.DoubleSpends != 2 || s.DoubleSpent != 1 {
+		t.Errorf("double spends got %d, %d want 2, 1", s.DoubleSpends, s.DoubleSpent)
+	}
+	if s.MeanConfTime != 2500*time.Millisecond {
+		t.Errorf("mean confirmation time got %v, want 2.5s", s.MeanConfTime)
+	}
+	if s.MedianConf != 2*time.Second || s.P95ConfTime != 4*time.Second {
+		t.Errorf("confirmation percentiles got %v, %v want 2s, 4s", s.MedianConf, s.P95ConfTime)
+	}
+
+	s.ActorBalances["actor-18557"] = 5000
+	s.StartFailures = map[string]string{
+		"actor-18559": "wallet exited",
+		"actor-18558": "wallet not started after 5m0s",
+	}
+	var buf bytes.Buffer
+	if err := s.Write(&buf); err != nil {
+		t.Fatalf("Write error: %v", err)
+	}
+	if !strings.Contains(buf.String(), "actor-18557 balance: 5000 satoshi") {
+		t.Errorf("summary is missing actor balance:\n%s", buf.String())
+	}
+	if !strings.Contains(buf.String(), "Actors failed to start: actor-18558 (wallet not started after 5m0s), actor-18559 (wallet exited)") {
+		t.Errorf("summary is missing the startup failures:\n%s", buf.String())
+	}
+}
+
+func TestNewSummaryFeeBumps(t *testing.T) {
+	stats := NewTxStats(DefaultConfig())
+	stats.records = []*TxRecord{
+		// a was bumped twice and the last replacement confirmed
+		{TxID: "a"},
+		{TxID: "b", Replaces: "a"},
+		{TxID: "c", Replaces: "b", Height: 10},
+		// d confirmed before its replacement
+		{TxID: "d", Height: 10},
+		{TxID: "e", Replaces: "d"},
+	}
+	s := NewSummary(DefaultConfig(), stats, time.Minute)
+	if s.FeeBumps != 3 || s.BumpsConfirmed != 1 || s.BumpedConfirmed != 1 {
+		t.Errorf("fee bumps got %d, %d, %d want 3, 1, 1",
+			s.FeeBumps, s.BumpsConfirmed, s.BumpedConfirmed)
+	}
+}
+
+func TestNewSummaryChildren(t *testing.T) {
+	stats := NewTxStats(DefaultConfig())
+	stats.records = []*TxRecord{
+		{TxID: "a", Height: 10},
+		{TxID: "b", Parent: "a", Height: 10},
+		{TxID: "c", Height: 10},
+		{TxID: "d", Parent: "c", Height: 11},
+		{TxID: "e"},
+		{TxID: "f", Parent: "e"},
+	}
+	s := NewSummary(DefaultConfig(), stats, time.Minute)
+	if s.Children != 3 || s.PackagesMined != 1 || s.ParentsMinedFirst != 1 {
+		t.Errorf("children got %d, %d, %d want 3, 1, 1",
+			s.Children, s.PackagesMined, s.ParentsMinedFirst)
+	}
+}
+
+func TestNewSummaryChains(t *testing.T) {
+	stats := NewTxStats(DefaultConfig())
+	stats.records = []*TxRecord{
+		{TxID: "a", Height: 10},
+		{TxID: "b", Parent: "a", Depth: 1, Height: 10},
+		{TxID: "c", Parent: "b", Depth: 2, Height: 11},
+		{TxID: "d", Parent: "c", Depth: 3},
+	}
+	s := NewSummary(DefaultConfig(), stats, time.Minute)
+	if s.ChainTxs != 3 || s.ChainDepth != 3 || s.ChainsMined != 1 {
+		t.Errorf("chains got %d, %d, %d want 3, 3, 1",
+			s.ChainTxs, s.ChainDepth, s.ChainsMined)
+	}
+	if s.Children != 0 {
+		t.Errorf("chained transactions counted as %d children", s.Children)
+	}
+}
@@ -0,0 +1,200 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sweepPoint is a combination of the parameters swept by a campaign
+type sweepPoint struct {
+	Actors        int           `json:"actors"`
+	TxRate        float64       `json:"txrate"`
+	BlockInterval time.Duration `json:"blockinterval"`
+}
+
+// args returns the flags running a simulation at the point
+func (p sweepPoint) args() []string {
+	return []string{
+		fmt.Sprintf("-actors=%d", p.Actors),
+		fmt.Sprintf("-txrate=%v", p.TxRate),
+		fmt.Sprintf("-blockinterval=%v", p.BlockInterval),
+	}
+}
+
+// String returns a printable description of the point
+func (p sweepPoint) String() string {
+	rate := "unlimited"
+	if p.TxRate > 0 {
+		rate = fmt.Sprintf("%v tx/s", p.TxRate)
+	}
+	interval := "default"
+	if p.BlockInterval > 0 {
+		interval = p.BlockInterval.String()
+	}
+	return fmt.Sprintf("%d actors, tx rate %s, block interval %s", p.Actors, rate, interval)
+}
+
+// parseSweepList splits a comma separated list of the values of a swept
+// parameter, the value of its flag being swept alone if s is empty
+func parseSweepList(s, current string) []string {
+	if s == "" {
+		return []string{current}
+	}
+	fields := strings.Split(s, ",")
+	for i, f := range fields {
+		fields[i] = strings.TrimSpace(f)
+	}
+	return fields
+}
+
+// parseSweep returns the grid of the actor counts, tx rates and block
+// intervals given as comma separated lists, e.g. "10,50,100", "5,10" and
+// "30s,1m". A parameter without list keeps its value in cfg. The points are
+// ordered by actors, then tx rate, then block interval.
+func parseSweep(cfg *Config, actors, rates, intervals string) ([]sweepPoint, error) {
+	var ns []int
+	for _, f := range parseSweepList(actors, strconv.Itoa(cfg.NumActors)) {
+		n, err := strconv.Atoi(f)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid number of actors %q", f)
+		}
+		ns = append(ns, n)
+	}
+	var rs []float64
+	for _, f := range parseSweepList(rates, strconv.FormatFloat(cfg.TxRate, 'g', -1, 64)) {
+		r, err := strconv.ParseFloat(f, 64)
+		if err != nil || r < 0 {
+			return nil, fmt.Errorf("invalid tx rate %q", f)
+		}
+		rs = append(rs, r)
+	}
+	var ds []time.Duration
+	for _, f := range parseSweepList(intervals, cfg.BlockInterval.String()) {
+		d, err := time.ParseDuration(f)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid block interval %q", f)
+		}
+		if err := checkMiningSchedule(cfg.MiningSchedule, d); err != nil {
+			return nil, err
+		}
+		ds = append(ds, d)
+	}
+
+	var points []sweepPoint
+	for _, n := range ns {
+		for _, r := range rs {
+			for _, d := range ds {
+				points = append(points, sweepPoint{Actors: n, TxRate: r, BlockInterval: d})
+			}
+		}
+	}
+	if len(points) < 2 {
+		return nil, errors.New("at least two parameter combinations are required")
+	}
+	return points, nil
+}
+
+// SweepResult is the aggregate of the runs at a point of the grid
+type SweepResult struct {
+	sweepPoint
+	Aggregate *Aggregate `json:"aggregate"`
+}
+
+// SweepComparison compares the runs of a campaign across the points of a
+// grid of parameters
+type SweepComparison struct {
+	Results []SweepResult `json:"results"`
+}
+
+// sweepHeader is the header of the comparison table
+var sweepHeader = []string{"actors", "txrate", "interval", "runs", "failed",
+	"tx/s", "sent", "confirmed", "meanconf", "p95conf", "mempool", "feerate"}
+
+// Write writes the comparison to w as a table with a row per point, and
+// the means across the runs of their throughput, confirmations, mempool
+// backlog and fee level
+func (c *SweepComparison) Write(w io.Writer) error {
+	rows := [][]string{sweepHeader}
+	for _, r := range c.Results {
+		a := r.Aggregate
+		rows = append(rows, []string{
+			strconv.Itoa(r.Actors),
+			strconv.FormatFloat(r.TxRate, 'g', -1, 64),
+			r.BlockInterval.String(),
+			strconv.Itoa(a.Runs),
+			strconv.Itoa(a.Failed),
+			fmt.Sprintf("%.2f", a.TPS.Mean),
+			fmt.Sprintf("%.0f", a.Transactions.Mean),
+			fmt.Sprintf("%.0f", a.Confirmed.Mean),
+			fmt.Sprintf("%.1fs", a.MeanConfTime.Mean),
+			fmt.Sprintf("%.1fs", a.P95ConfTime.Mean),
+			fmt.Sprintf("%.0f", a.MaxMempool.Mean),
+			fmt.Sprintf("%.2f", a.FeeRate.Mean),
+		})
+	}
+
+	widths := make([]int, len(sweepHeader))
+	for _, row := range rows {
+		for i, cell := range row {
+			if len(cell) > widths[i] {
+				widths[i] = len(cell)
+			}
+		}
+	}
+	for _, row := range rows {
+		cells := make([]string, len(row))
+		for i, cell := range row {
+			cells[i] = fmt.Sprintf("%*s", widths[i], cell)
+		}
+		if _, err := fmt.Fprintln(w, strings.Join(cells, "  ")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runSweep runs a campaign of n simulations configured by cfg at every
+// point of the grid and compares them. Every run starts from a fresh chain
+// and wallets, and run i of every point uses the seed plus i.
+func runSweep(cfg *Config, points []sweepPoint, n int, parallel bool) error {
+	if err := checkRuns(cfg, n, parallel); err != nil {
+		return err
+	}
+
+	c := &SweepComparison{}
+	failed, code := true, exitPass
+	for i, p := range points {
+		log.Infof("Running %d simulation(s) with %s...", n, p)
+		summaries, batchCode := runBatch(cfg, i*n+1, n, parallel, p.args()...)
+		if batchCode > code {
+			code = batchCode
+		}
+		agg := NewAggregate(summaries)
+		c.Results = append(c.Results, SweepResult{sweepPoint: p, Aggregate: agg})
+		if agg.Failed < n {
+			failed = false
+		}
+	}
+
+	log.Infof("Parameter sweep comparison:")
+	c.Write(os.Stdout)
+	if cfg.SummaryPath != "" {
+		if err := writeJSON(cfg.SummaryPath, c); err != nil {
+			log.Errorf("Cannot write parameter sweep comparison: %v", err)
+			return err
+		}
+	}
+	if failed {
+		return errors.New("all runs failed")
+	}
+	return batchError(code)
+}
@@ -0,0 +1,79 @@
+package btcsim
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSweep(t *testing.T) {
+	cfg := DefaultConfig()
+	points, err := parseSweep(cfg, "10, 50", "5,10", "")
+	if err != nil {
+		t.Fatalf("parseSweep error: %v", err)
+	}
+	want := []sweepPoint{
+		{10, 5, cfg.BlockInterval},
+		{10, 10, cfg.BlockInterval},
+		{50, 5, cfg.BlockInterval},
+		{50, 10, cfg.BlockInterval},
+	}
+	if !reflect.DeepEqual(points, want) {
+		t.Errorf("parseSweep = %v, want %v", points, want)
+	}
+	args := points[3].args()
+	if want := []string{"-actors=50", "-txrate=10", "-blockinterval=" + cfg.BlockInterval.String()}; !reflect.DeepEqual(args, want) {
+		t.Errorf("args = %v, want %v", args, want)
+	}
+
+	for _, test := range [][3]string{
+		{"10", "", ""},
+		{"0,10", "", ""},
+		{"10,x", "", ""},
+		{"", "-1,5", ""},
+		{"", "", "30s,soon"},
+	} {
+		if _, err := parseSweep(cfg, test[0], test[1], test[2]); err == nil {
+			t.Errorf("parseSweep(%q) succeeded", test)
+		}
+	}
+}
+
+func TestSweepComparison(t *testing.T) {
+	c := &SweepComparison{Results: []SweepResult{
+		{sweepPoint{10, 5, 30 * time.Second}, NewAggregate([]*Summary{{TPS: 4.5, Transactions: 300}})},
+		{sweepPoint{100, 20, time.Minute}, NewAggregate([]*Summary{{TPS: 18, Transactions: 1200}, nil})},
+	}}
+	var buf bytes.Buffer
+	if err := c.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("comparison has %d lines, want a header and 2 rows:\n%s", len(lines), buf.String())
+	}
+	for i, want := range [][]string{
+		sweepHeader,
+		{"10", "5", "30s", "1", "0", "4.50", "300"},
+		{"100", "20", "1m0s", "2", "1", "18.00", "1200"},
+	} {
+		fields := strings.Fields(lines[i])
+		if len(fields) != len(sweepHeader) || !reflect.DeepEqual(fields[:len(want)], want) {
+			t.Errorf("line %d = %q, want it to start with %v", i, lines[i], want)
+		}
+	}
+	if len(lines[0]) != len(lines[1]) {
+		t.Errorf("table columns are not aligned:\n%s", buf.String())
+	}
+
+	b, err := json.Marshal(c)
+	if err != nil {
+		t.Fatalf("Marshal error: %v", err)
+	}
+	if !strings.Contains(string(b), `"actors":10,"txrate":5,"blockinterval":30000000000`) {
+		t.Errorf("JSON comparison missing the swept parameters: %s", b)
+	}
+}
@@ -0,0 +1,363 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+// timelockPollInterval is the interval at which the transactions locked to
+// a time are sent again until accepted
+const timelockPollInterval = time.Second
+
+// timelockMedianBlocks is the number of blocks mined after a transaction
+// locked to a time became valid by which the median time of the blocks is
+// past its lock time, so that the node must accept it
+const timelockMedianBlocks = 11
+
+// timelockSequence is the sequence number of the inputs of the locked
+// transactions, any but the final one enables the lock time
+const timelockSequence = wire.MaxTxInSequenceNum - 1
+
+// TimelockStats counts the transactions locked to a future height or time
+// and how the node handled them before and after they became valid
+type TimelockStats struct {
+	// HeightLocks and TimeLocks are the number of transactions locked to
+	// a height and to a time
+	HeightLocks int `json:"heightlocks"`
+	TimeLocks   int `json:"timelocks"`
+
+	// Held is the number of times a transaction was rejected before its
+	// lock time as expected, Premature the number of transactions accepted
+	// before it and MinedEarly the number mined in a block it locks out
+	Held       int `json:"held"`
+	Premature  int `json:"premature"`
+	MinedEarly int `json:"minedearly"`
+
+	// Accepted is the number of transactions accepted once valid, Late
+	// the number of transactions locked to a height rejected once valid.
+	// Transactions locked to a time may be rejected until the median time
+	// of the last blocks is past their lock time. Dropped is the number of
+	// transactions rejected once valid which are no longer sent, their
+	// utxo being spent again.
+	Accepted int `json:"accepted"`
+	Late     int `json:"late"`
+	Dropped  int `json:"dropped"`
+
+	// Mined is the number of transactions mined, and the confirmation
+	// delays the number of blocks from the tip they became valid at to
+	// the block they are mined in
+	Mined          int     `json:"mined"`
+	MeanConfBlocks float64 `json:"meanconfblocks"`
+	MaxConfBlocks  int32   `json:"maxconfblocks"`
+
+	confirmed  int
+	confBlocks int32
+}
+
+// String returns the statistics as a single line
+func (s *TimelockStats) String() string {
+	return fmt.Sprintf("%d locked to a height and %d to a time, rejected %d times before valid, "+
+		"%d accepted and %d mined early, %d accepted once valid (%d late rejections, %d dropped), "+
+		"%d mined %.1f blocks after valid on average (max %d)",
+		s.HeightLocks, s.TimeLocks, s.Held, s.Premature, s.MinedEarly, s.Accepted, s.Late,
+		s.Dropped, s.Mined, s.MeanConfBlocks, s.MaxConfBlocks)
+}
+
+// timelockTx is a locked transaction of an actor not mined yet
+type timelockTx struct {
+	actor *Actor
+	tx    *wire.MsgTx
+	utxo  *TxOut
+
+	// validHeight is the tip at which the transaction became valid, 0
+	// until it is, and sent is set once the node accepted it
+	validHeight int32
+	sent        bool
+}
+
+// byHeight reports whether the transaction is locked to a height rather
+// than to a time
+func (t *timelockTx) byHeight() bool {
+	return t.tx.LockTime < lockTimeThreshold
+}
+
+// valid reports whether the transaction can be mined in the block after
+// the tip, that is its lock time is below the height of that block or
+// before now
+func (t *timelockTx) valid(tip int32, now time.Time) bool {
+	if t.byHeight() {
+		return int64(t.tx.LockTime) < int64(tip)+1
+	}
+	return int64(t.tx.LockTime) < now.Unix()
+}
+
+// lockTimeThreshold is the lock time below which it is a block height and
+// above which a unix time
+const lockTimeThreshold = 5e8
+
+// timelockTracker follows the locked transactions of the actors from their
+// creation to the block they are mined in
+type timelockTracker struct {
+	check chan struct{}
+
+	mtx     sync.Mutex
+	pending map[string]*timelockTx
+	stats   TimelockStats
+}
+
+// newTimelockTracker returns a tracker of locked transactions
+func newTimelockTracker() *timelockTracker {
+	return &timelockTracker{
+		check:   make(chan struct{}, 1),
+		pending: make(map[string]*timelockTx),
+	}
+}
+
+// subscribe subscribes the tracker to the blocks mined, which confirm the
+// locked transactions and request them to be sent again
+func (t *timelockTracker) subscribe(events *eventBus) {
+	events.subscribe(t.mined, eventBlockMined)
+}
+
+// add records a locked transaction created
+func (t *timelockTracker) add(p *timelockTx) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	t.pending[p.tx.TxSha().String()] = p
+	if p.byHeight() {
+		t.stats.HeightLocks++
+	} else {
+		t.stats.TimeLocks++
+	}
+}
+
+// unsent returns the locked transactions the node did not accept yet and
+// records those which became valid at the tip
+func (t *timelockTracker) unsent(tip int32, now time.Time) []*timelockTx {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	var unsent []*timelockTx
+	for _, p := range t.pending {
+		if p.sent {
+			continue
+		}
+		if p.validHeight == 0 && p.valid(tip, now) {
+			p.validHeight = tip
+		}
+		unsent = append(unsent, p)
+	}
+	return unsent
+}
+
+// sent records whether the node accepted a locked transaction sent at the
+// given tip and time. It returns true when the transaction was rejected
+// for another reason than its lock time, it is then no longer tracked and
+// its utxo must be spent again.
+func (t *timelockTracker) sent(p *timelockTx, err error, tip int32, now time.Time) bool {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	valid := p.valid(tip, now)
+	if valid && p.validHeight == 0 {
+		p.validHeight = tip
+	}
+	var dropped bool
+	switch {
+	case err == nil && (valid || p.validHeight != 0):
+		t.stats.Accepted++
+	case err == nil:
+		t.stats.Premature++
+		log.Warnf("%s: Transaction %s accepted before its lock time %d",
+			p.actor, p.tx.TxSha(), p.tx.LockTime)
+	case !valid:
+		t.stats.Held++
+	case p.byHeight():
+		t.stats.Late++
+		dropped = true
+	case tip-p.validHeight >= timelockMedianBlocks:
+		dropped = true
+	}
+	p.sent = err == nil
+	if dropped {
+		t.stats.Dropped++
+		delete(t.pending, p.tx.TxSha().String())
+	}
+	return dropped
+}
+
+// mined records the locked transactions mined in a block
+func (t *timelockTracker) mined(e *Event) {
+	select {
+	case t.check <- struct{}{}:
+	default:
+	}
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	for _, txid := range e.Block.txids {
+		p, ok := t.pending[txid]
+		if !ok {
+			continue
+		}
+		delete(t.pending, txid)
+		t.stats.Mined++
+		if p.byHeight() && int64(p.tx.LockTime) >= int64(e.Height) {
+			t.stats.MinedEarly++
+			log.Warnf("%s: Transaction %s mined at height %d before its lock time %d",
+				p.actor, txid, e.Height, p.tx.LockTime)
+		}
+		if p.validHeight == 0 {
+			continue
+		}
+		blocks := e.Height - p.validHeight
+		t.stats.confirmed++
+		t.stats.confBlocks += blocks
+		if blocks > t.stats.MaxConfBlocks {
+			t.stats.MaxConfBlocks = blocks
+		}
+	}
+}
+
+// Stats returns the statistics of the locked transactions so far
+func (t *timelockTracker) Stats() *TimelockStats {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	stats := t.stats
+	if stats.confirmed > 0 {
+		stats.MeanConfBlocks = float64(stats.confBlocks) / float64(stats.confirmed)
+	}
+	return &stats
+}
+
+// sendTimelocks runs as a goroutine and, on average every interval, picks a
+// running actor which creates a transaction locked up to blocks ahead of
+// the tip or up to delay ahead of now. The locked transactions are sent
+// after every block and every timelockPollInterval until the node accepts
+// them, until the simulation exits.
+func (com *Communication) sendTimelocks(t *timelockTracker, interval time.Duration, blocks int, delay time.Duration) {
+	defer com.wg.Done()
+
+	r := com.cfg.newRand(timelockStream)
+	next := func() <-chan time.Time {
+		return time.After(time.Duration(r.ExpFloat64() * float64(interval)))
+	}
+	create := next()
+	ticker := time.NewTicker(timelockPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-create:
+			create = next()
+			var running []*Actor
+			for _, a := range com.Actors() {
+				if a.running() && a.floodTarget == 0 && !a.Paused() {
+					running = append(running, a)
+				}
+			}
+			if len(running) == 0 {
+				continue
+			}
+			a := running[r.Intn(len(running))]
+			if err := com.createTimelock(t, r, a, blocks, delay); err != nil {
+				log.Debugf("%s: Cannot create locked transaction: %v", a, err)
+			}
+			continue
+		case <-t.check:
+		case <-ticker.C:
+		case <-com.exit:
+			return
+		}
+
+		tip := com.mempool.Height()
+		for _, p := range t.unsent(tip, time.Now()) {
+			err := sendUntracked(p.actor, p.tx, com.untracked)
+			now, sentTip := time.Now(), tip
+			// an unexpected outcome may come from the node of the
+			// actor being at another tip, while transactions locked
+			// to a time are expected to be rejected for a while
+			// once valid
+			if (err == nil) != p.valid(tip, now) && (err == nil || p.byHeight()) {
+				if count, err := p.actor.client.GetBlockCount(); err == nil {
+					sentTip = int32(count)
+				}
+			}
+			if t.sent(p, err, sentTip, now) {
+				log.Debugf("%s: Dropping locked transaction %s rejected once valid: %v",
+					p.actor, p.tx.TxSha(), err)
+				p.actor.requeue([]*TxOut{p.utxo})
+			}
+			if err == nil {
+				com.recordTx(p.actor, p.tx, p.utxo.Amount)
+			}
+		}
+	}
+}
+
+// createTimelock creates a transaction paying an utxo of the actor back to
+// it, locked to a height or a time picked at random, and adds it to the
+// tracker
+func (com *Communication) createTimelock(t *timelockTracker, r *rand.Rand, a *Actor, blocks int, delay time.Duration) error {
+	var utxo *TxOut
+	select {
+	case u, ok := <-a.utxoQueue.dequeue:
+		if !ok {
+			return nil
+		}
+		utxo = u
+	default:
+		log.Debugf("%s: No utxo to create a locked transaction from", a)
+		return nil
+	}
+
+	var lockTime uint32
+	if r.Intn(2) == 0 {
+		lockTime = uint32(com.mempool.Height()) + 1 + uint32(r.Intn(blocks))
+	} else {
+		ahead := time.Duration(r.Float64() * float64(delay))
+		lockTime = uint32(time.Now().Add(ahead).Unix())
+	}
+	tx, err := a.createTimelockTx(utxo, lockTime)
+	if err != nil {
+		a.requeue([]*TxOut{utxo})
+		return err
+	}
+	t.add(&timelockTx{actor: a, tx: tx, utxo: utxo})
+	return nil
+}
+
+// createTimelockTx creates and signs a transaction paying the utxo back to
+// the actor, less the fee, locked to the given height or time
+func (a *Actor) createTimelockTx(utxo *TxOut, lockTime uint32) (*wire.MsgTx, error) {
+	amount := utxo.Amount - a.fee(1, 1)
+	if amount < minFee {
+		return nil, ErrInsufficientFunds
+	}
+	inputs := []btcjson.TransactionInput{{
+		Txid: utxo.OutPoint.Hash.String(),
+		Vout: utxo.OutPoint.Index,
+	}}
+	tx, err := a.client.CreateRawTransaction(inputs,
+		map[btcutil.Address]btcutil.Amount{a.Address(): amount})
+	if err != nil {
+		return nil, err
+	}
+	setSequence(tx, timelockSequence)
+	tx.LockTime = lockTime
+	tx, ok, err := a.signRawTransaction(tx, nil)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrIncompleteSignature
+	}
+	return tx, nil
+}
@@ -0,0 +1,130 @@
+package btcsim
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/wire"
+)
+
+func TestCreateTimelockTx(t *testing.T) {
+	chain := NewMockChain(DefaultConfig())
+	a := chain.NewActor("a")
+	defer StopMockActors(a)
+	mineMock(t, a, a)
+	utxo := <-a.utxoQueue.dequeue
+
+	tx, err := a.createTimelockTx(utxo, 12)
+	if err != nil {
+		t.Fatalf("createTimelockTx error: %v", err)
+	}
+	if tx.LockTime != 12 || len(tx.TxIn) != 1 || tx.TxIn[0].Sequence == wire.MaxTxInSequenceNum {
+		t.Errorf("lock time %d and sequence %d want 12 and a non-final sequence",
+			tx.LockTime, tx.TxIn[0].Sequence)
+	}
+	if len(tx.TxOut) != 1 || tx.TxOut[0].Value >= int64(utxo.Amount) {
+		t.Errorf("outputs %v want the utxo less a fee", tx.TxOut)
+	}
+
+	utxo.Amount = minFee
+	if _, err := a.createTimelockTx(utxo, 12); err != ErrInsufficientFunds {
+		t.Errorf("createTimelockTx of a tiny utxo error %v want %v", err, ErrInsufficientFunds)
+	}
+}
+
+func TestTimelockValid(t *testing.T) {
+	now := time.Unix(1.5e9, 0)
+	tests := []struct {
+		lockTime uint32
+		tip      int32
+		valid    bool
+	}{
+		{10, 9, false},
+		{10, 10, true},
+		{10, 11, true},
+		{1.5e9, 100, false},
+		{1.5e9 - 1, 100, true},
+	}
+	for _, test := range tests {
+		p := &timelockTx{tx: &wire.MsgTx{LockTime: test.lockTime}}
+		if valid := p.valid(test.tip, now); valid != test.valid {
+			t.Errorf("lock time %d at tip %d valid %v want %v",
+				test.lockTime, test.tip, valid, test.valid)
+		}
+	}
+}
+
+func TestTimelockTracker(t *testing.T) {
+	events := newEventBus()
+	tr := newTimelockTracker()
+	tr.subscribe(events)
+	now := time.Now()
+	rejected := errors.New("non-final")
+
+	// a transaction locked to height 10 is held, then accepted and mined
+	// two blocks after it became valid
+	held := &timelockTx{actor: fakeActor("a"), tx: &wire.MsgTx{LockTime: 10}}
+	// a transaction locked to height 20 is accepted and mined early
+	early := &timelockTx{actor: fakeActor("a"), tx: &wire.MsgTx{LockTime: 20, Version: 2}}
+	// a transaction locked to a time in the past is rejected
+	late := &timelockTx{actor: fakeActor("a"), tx: &wire.MsgTx{LockTime: uint32(now.Unix() - 10)}}
+	for _, p := range []*timelockTx{held, early, late} {
+		tr.add(p)
+	}
+
+	if n := len(tr.unsent(9, now)); n != 3 {
+		t.Fatalf("%d unsent want 3", n)
+	}
+	tr.sent(held, rejected, 9, now)
+	tr.sent(early, nil, 9, now)
+	tr.sent(late, rejected, 9, now)
+	if unsent := tr.unsent(10, now); len(unsent) != 2 || late.validHeight != 9 || held.validHeight != 10 {
+		t.Fatalf("got %d unsent valid at %d and %d want 2 valid at 10 and 9",
+			len(unsent), held.validHeight, late.validHeight)
+	}
+	tr.sent(held, nil, 10, now)
+
+	events.publish(&Event{Kind: eventBlockMined, Height: 11, Block: &blockTxs{
+		txids: []string{early.tx.TxSha().String()},
+	}})
+	events.publish(&Event{Kind: eventBlockMined, Height: 12, Block: &blockTxs{
+		txids: []string{held.tx.TxSha().String()},
+	}})
+	if n := len(tr.unsent(12, now)); n != 1 {
+		t.Errorf("%d unsent after the blocks want 1", n)
+	}
+	select {
+	case <-tr.check:
+	default:
+		t.Errorf("blocks mined did not request a check")
+	}
+
+	// a transaction locked to a height rejected once valid is dropped at
+	// once, one locked to a time once the median time is past its lock
+	dropped := &timelockTx{actor: fakeActor("a"), tx: &wire.MsgTx{LockTime: 5, Version: 3}}
+	tr.add(dropped)
+	if !tr.sent(dropped, rejected, 12, now) {
+		t.Errorf("transaction locked to a height rejected once valid not dropped")
+	}
+	if tr.sent(late, rejected, 19, now) {
+		t.Errorf("transaction locked to a time dropped before the median time is past")
+	}
+	if !tr.sent(late, rejected, 20, now) {
+		t.Errorf("transaction locked to a time rejected %d blocks after valid not dropped",
+			timelockMedianBlocks)
+	}
+	if n := len(tr.unsent(20, now)); n != 0 {
+		t.Errorf("%d unsent after the drops want 0", n)
+	}
+
+	want := TimelockStats{
+		HeightLocks: 3, TimeLocks: 1,
+		Held: 1, Premature: 1, MinedEarly: 1,
+		Accepted: 1, Late: 1, Dropped: 2, Mined: 2, MeanConfBlocks: 2, MaxConfBlocks: 2,
+		confirmed: 1, confBlocks: 2,
+	}
+	if s := tr.Stats(); *s != want {
+		t.Errorf("got %+v want %+v", *s, want)
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+)
+
+// randomEdgeProb is the probability of an extra edge between two nodes
+// in a random topology, in addition to the edges of a random spanning tree
+const randomEdgeProb = 0.2
+
+// topologyFunc returns the peer connections between n nodes as a list of
+// edges, each edge connecting the nodes at the given indexes
+type topologyFunc func(n int, r *rand.Rand) [][2]int
+
+// topologies maps the names accepted by the -topology flag to the
+// functions which generate them
+var topologies = map[string]topologyFunc{
+	"mesh":   meshTopology,
+	"ring":   ringTopology,
+	"star":   starTopology,
+	"random": randomTopology,
+}
+
+// getTopology returns the topology function with the given name
+func getTopology(name string) (topologyFunc, error) {
+	t, ok := topologies[name]
+	if !ok {
+		names := make([]string, 0, len(topologies))
+		for name := range topologies {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown topology %q, valid topologies are: %s",
+			name, strings.Join(names, ", "))
+	}
+	return t, nil
+}
+
+// meshTopology connects every node to every other node
+func meshTopology(n int, r *rand.Rand) [][2]int {
+	var edges [][2]int
+	for i := 0; i < n; i++ {
+		for j := i + 1; j < n; j++ {
+			edges = append(edges, [2]int{i, j})
+		}
+	}
+	return edges
+}
+
+// ringTopology connects every node to the next one, and the last node
+// to the first one
+func ringTopology(n int, r *rand.Rand) [][2]int {
+	var edges [][2]int
+	for i := 0; i < n-1; i++ {
+		edges = append(edges, [2]int{i, i + 1})
+	}
+	// two nodes are already connected, don't connect them twice
+	if n > 2 {
+		edges = append(edges, [2]int{n - 1, 0})
+	}
+	return edges
+}
+
+// starTopology connects every node to the first node
+func starTopology(n int, r *rand.Rand) [][2]int {
+	var edges [][2]int
+	for i := 1; i < n; i++ {
+		edges = append(edges, [2]int{0, i})
+	}
+	return edges
+}
+
+// randomTopology connects the nodes with a random spanning tree, so that
+// every node is reachable, and adds extra random edges with probability
+// randomEdgeProb
+func randomTopology(n int, r *rand.Rand) [][2]int {
+	var edges [][2]int
+	connected := make(map[[2]int]bool)
+	for i := 1; i < n; i++ {
+		edge := [2]int{r.Intn(i), i}
+		connected[edge] = true
+		edges = append(edges, edge)
+	}
+	for i := 0; i < n; i++ {
+		for j := i + 1; j < n; j++ {
+			edge := [2]int{i, j}
+			if !connected[edge] && r.Float64() < randomEdgeProb {
+				edges = append(edges, edge)
+			}
+		}
+	}
+	return edges
+}
+
+// nodePorts returns the preferred p2p and rpc listen ports of the i-th node
+// The first node uses the default simnet ports
+func nodePorts(i int) (listen, rpcListen int) {
+	if i == 0 {
+		return 18555, 18556
+	}
+	return 28555 + 2*(i-1), 28556 + 2*(i-1)
+}
@@ -0,0 +1,76 @@
+package btcsim
+
+import "testing"
+
+// connected reports whether all n nodes are reachable from the first
+// node using the given edges
+func connected(n int, edges [][2]int) bool {
+	peers := make(map[int][]int)
+	for _, e := range edges {
+		peers[e[0]] = append(peers[e[0]], e[1])
+		peers[e[1]] = append(peers[e[1]], e[0])
+	}
+	seen := map[int]bool{0: true}
+	queue := []int{0}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		for _, p := range peers[node] {
+			if !seen[p] {
+				seen[p] = true
+				queue = append(queue, p)
+			}
+		}
+	}
+	return len(seen) == n
+}
+
+func TestTopologies(t *testing.T) {
+	tests := []struct {
+		name  string
+		n     int
+		edges int
+	}{
+		{"mesh", 1, 0},
+		{"mesh", 5, 10},
+		{"ring", 2, 1},
+		{"ring", 5, 5},
+		{"star", 5, 4},
+	}
+	for _, test := range tests {
+		topology, err := getTopology(test.name)
+		if err != nil {
+			t.Errorf("getTopology(%s) error: %v", test.name, err)
+			continue
+		}
+		edges := topology(test.n, DefaultConfig().newRand(topologyStream))
+		if len(edges) != test.edges {
+			t.Errorf("%s(%d) got %d edges, want %d", test.name, test.n, len(edges), test.edges)
+		}
+		if !connected(test.n, edges) {
+			t.Errorf("%s(%d) is not connected: %v", test.name, test.n, edges)
+		}
+	}
+}
+
+func TestRandomTopology(t *testing.T) {
+	for n := 1; n < 20; n++ {
+		edges := randomTopology(n, DefaultConfig().newRand(topologyStream))
+		if !connected(n, edges) {
+			t.Errorf("random(%d) is not connected: %v", n, edges)
+		}
+		seen := make(map[[2]int]bool)
+		for _, e := range edges {
+			if seen[e] {
+				t.Errorf("random(%d) has duplicate edge %v", n, e)
+			}
+			seen[e] = true
+		}
+	}
+}
+
+func TestGetTopologyErrors(t *testing.T) {
+	if _, err := getTopology("torus"); err == nil {
+		t.Errorf("getTopology expected error, got %v", err)
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+const (
+	// tuiInterval is the interval at which the terminal UI is redrawn
+	tuiInterval = time.Second
+
+	// tuiEvents is the number of last events shown by the terminal UI
+	tuiEvents = 10
+
+	// tuiLogPrefix names the file of the run directory the log is
+	// written to while the terminal UI is shown
+	tuiLogPrefix = "btcsim"
+)
+
+// ANSI escape sequences used by the terminal UI
+const (
+	ansiClear  = "\x1b[H\x1b[2J"
+	ansiReset  = "\x1b[0m"
+	ansiBold   = "\x1b[1m"
+	ansiRed    = "\x1b[31m"
+	ansiGreen  = "\x1b[32m"
+	ansiYellow = "\x1b[33m"
+)
+
+// tuiFrame is what the terminal UI draws, the state of the simulation with
+// the transaction rate since the previous frame and the number of warnings
+// and errors logged so far
+type tuiFrame struct {
+	*dashboardState
+	tps      float64
+	warnings uint64
+	errors   uint64
+}
+
+// health returns the color and name of the health of an actor, green when
+// it runs, yellow when paused and red once shut down
+func (s *actorStatus) health() (string, string) {
+	switch {
+	case !s.Running:
+		return ansiRed, "down"
+	case s.Paused:
+		return ansiYellow, "paused"
+	default:
+		return ansiGreen, "running"
+	}
+}
+
+// truncate shortens s to n characters
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n-1] + "~"
+	}
+	return s
+}
+
+// renderTUI draws a frame of the terminal UI, a header with the progress
+// of the simulation followed by a row per node and per actor, colored by
+// their health, and the last events
+func renderTUI(w io.Writer, f *tuiFrame) error {
+	b := bufio.NewWriter(w)
+	fmt.Fprint(b, ansiClear)
+	fmt.Fprintf(b, "%sbtcsim%s  %s  %d txs  %.2f tx/s  ", ansiBold, ansiReset,
+		f.Time.Format("15:04:05"), f.Txs, f.tps)
+	logColor := ansiGreen
+	switch {
+	case f.errors > 0:
+		logColor = ansiRed
+	case f.warnings > 0:
+		logColor = ansiYellow
+	}
+	fmt.Fprintf(b, "%s%d warnings, %d errors%s\n\n", logColor, f.warnings, f.errors, ansiReset)
+
+	fmt.Fprintf(b, "%s%-20s %8s %8s  %s%s\n", ansiBold, "NODE", "HEIGHT", "MEMPOOL", "STATUS", ansiReset)
+	for _, n := range f.Nodes {
+		if n.Error != "" {
+			fmt.Fprintf(b, "%-20s %8d %8s  %s%s%s\n", truncate(n.Name, 20), n.Height, "-",
+				ansiRed, truncate(n.Error, 40), ansiReset)
+			continue
+		}
+		fmt.Fprintf(b, "%-20s %8d %8d  %sup%s\n", truncate(n.Name, 20), n.Height, n.Mempool,
+			ansiGreen, ansiReset)
+	}
+
+	fmt.Fprintf(b, "\n%s%-20s %-12s %-12s %6s  %s%s\n", ansiBold, "ACTOR", "PROFILE", "BEHAVIOR",
+		"UTXOS", "STATUS", ansiReset)
+	for i := range f.Actors {
+		a := &f.Actors[i]
+		color, health := a.health()
+		fmt.Fprintf(b, "%-20s %-12s %-12s %6d  %s%s%s\n", truncate(a.Name, 20),
+			truncate(a.Profile, 12), truncate(a.Behavior, 12), a.Utxos, color, health, ansiReset)
+	}
+
+	fmt.Fprintf(b, "\n%sEVENTS%s\n", ansiBold, ansiReset)
+	events := f.Events
+	if len(events) > tuiEvents {
+		events = events[len(events)-tuiEvents:]
+	}
+	for _, e := range events {
+		fmt.Fprintf(b, "%s %s\n", e.Time.Format("15:04:05"), e.Text)
+	}
+	return b.Flush()
+}
+
+// runTUI runs as a goroutine and redraws the terminal UI on w every
+// tuiInterval until the simulation exits. The log is written to a file of
+// the run directory meanwhile so that it does not garble the UI.
+func (com *Communication) runTUI(w io.Writer, nodes []*Node) {
+	defer com.wg.Done()
+
+	logFile, err := com.cfg.getLogFile(tuiLogPrefix)
+	if err != nil {
+		log.Errorf("Cannot create the log file of the terminal UI: %v", err)
+		return
+	}
+	log.Infof("Terminal UI running, logging to %s", com.cfg.runPath(tuiLogPrefix+".log"))
+	log.SetOutput(logFile)
+	defer func() {
+		log.SetOutput(os.Stderr)
+		logFile.Close()
+	}()
+
+	feed := &eventFeed{}
+	com.events.subscribe(feed.add, eventKinds...)
+
+	ticker := time.NewTicker(tuiInterval)
+	defer ticker.Stop()
+
+	lastCount := com.txStats.Count()
+	lastTime := time.Now()
+	for {
+		select {
+		case now := <-ticker.C:
+			f := &tuiFrame{
+				dashboardState: &dashboardState{
+					Time:   now,
+					Txs:    com.txStats.Count(),
+					Actors: actorStatuses(com.Actors()),
+					Nodes:  nodeStatuses(nodes),
+				},
+			}
+			f.Events, _ = feed.since(0)
+			f.tps = float64(f.Txs-lastCount) / now.Sub(lastTime).Seconds()
+			lastCount, lastTime = f.Txs, now
+			f.warnings, f.errors = log.Counts()
+			if err := renderTUI(w, f); err != nil {
+				log.Errorf("Cannot draw the terminal UI: %v", err)
+				return
+			}
+		case <-com.exit:
+			fmt.Fprint(w, ansiReset)
+			return
+		}
+	}
+}
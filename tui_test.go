@@ -0,0 +1,91 @@
+package btcsim
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRenderTUI(t *testing.T) {
+	f := &tuiFrame{
+		dashboardState: &dashboardState{
+			Time: time.Now(),
+			Txs:  42,
+			Actors: []actorStatus{
+				{Name: "actor-a", Profile: "spender", Running: true},
+				{Name: "actor-b", Profile: "hoarder", Running: true, Paused: true},
+				{Name: "actor-c", Profile: "spender"},
+			},
+			Nodes: []nodeStatus{
+				{Name: "node-0", Height: 120, Mempool: 7},
+				{Name: "node-1", Error: "connection refused"},
+			},
+		},
+		tps:    2.5,
+		errors: 1,
+	}
+	for i := 0; i < tuiEvents+5; i++ {
+		f.Events = append(f.Events, feedEvent{Seq: uint64(i + 1), Text: "event"})
+	}
+
+	var b bytes.Buffer
+	if err := renderTUI(&b, f); err != nil {
+		t.Fatalf("renderTUI error: %v", err)
+	}
+	out := b.String()
+	for _, want := range []string{
+		"42 txs  2.50 tx/s",
+		ansiRed + "0 warnings, 1 errors",
+		ansiGreen + "running",
+		ansiYellow + "paused",
+		ansiRed + "down",
+		ansiRed + "connection refused",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("frame does not contain %q:\n%s", want, out)
+		}
+	}
+	if n := strings.Count(out, "event\n"); n != tuiEvents {
+		t.Errorf("got %d events, want %d", n, tuiEvents)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	if s := truncate("actor-18557", 20); s != "actor-18557" {
+		t.Errorf("got %q", s)
+	}
+	if s := truncate("consolidator", 8); s != "consoli~" {
+		t.Errorf("got %q", s)
+	}
+}
+
+// TestTUIProgress checks that no progress is printed over the terminal UI
+func TestTUIProgress(t *testing.T) {
+	stdout := os.Stdout
+	defer func() {
+		os.Stdout = stdout
+	}()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout = w
+
+	cfg := DefaultConfig()
+	cfg.TUI = true
+	cfg.printProgress(1, 2)
+	cfg.endProgress()
+	w.Close()
+	os.Stdout = stdout
+
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 0 {
+		t.Errorf("got %q on the standard output", out)
+	}
+}
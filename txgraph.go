@@ -0,0 +1,217 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+// graphTx is a transaction of the chain, a node of the transaction graph
+type graphTx struct {
+	txid     string
+	height   int32
+	coinbase bool
+
+	// actor is the actor who owned the first input of the transaction
+	// owned by an actor, empty if none did
+	actor string
+
+	// value is the sum of the outputs of the transaction and fee the
+	// difference with the sum of its inputs
+	value btcutil.Amount
+	fee   btcutil.Amount
+}
+
+// graphSpend is an output of a transaction spent by another one, an edge of
+// the transaction graph
+type graphSpend struct {
+	from, to string
+	vout     uint32
+	amount   btcutil.Amount
+
+	// owner is the actor the output paid, empty if none
+	owner string
+}
+
+// txGraph is the graph of the transactions of the chain, labeled with the
+// actors owning the coins, for chain analysis tools to be tested against
+type txGraph struct {
+	txs    []*graphTx
+	spends []*graphSpend
+}
+
+// graphOutput is an output of the chain while it is walked
+type graphOutput struct {
+	amount btcutil.Amount
+	owner  string
+}
+
+// buildTxGraph walks the best chain of the node from the genesis block and
+// returns the graph of its transactions
+func (com *Communication) buildTxGraph(node *Node) (*txGraph, error) {
+	height, err := node.client.GetBlockCount()
+	if err != nil {
+		return nil, err
+	}
+	actors := com.Actors()
+	outputs := make(map[wire.OutPoint]graphOutput)
+	g := &txGraph{}
+	for h := int64(1); h <= height; h++ {
+		hash, err := node.client.GetBlockHash(h)
+		if err != nil {
+			return nil, err
+		}
+		block, err := node.client.GetBlock(hash)
+		if err != nil {
+			return nil, err
+		}
+		for i, tx := range block.Transactions() {
+			gtx := &graphTx{
+				txid:     tx.Sha().String(),
+				height:   int32(h),
+				coinbase: i == 0,
+			}
+			var in btcutil.Amount
+			for _, txIn := range tx.MsgTx().TxIn {
+				out, ok := outputs[txIn.PreviousOutPoint]
+				if !ok {
+					continue
+				}
+				delete(outputs, txIn.PreviousOutPoint)
+				in += out.amount
+				if gtx.actor == "" {
+					gtx.actor = out.owner
+				}
+				g.spends = append(g.spends, &graphSpend{
+					from:   txIn.PreviousOutPoint.Hash.String(),
+					to:     gtx.txid,
+					vout:   txIn.PreviousOutPoint.Index,
+					amount: out.amount,
+					owner:  out.owner,
+				})
+			}
+			for n, txOut := range tx.MsgTx().TxOut {
+				amt := btcutil.Amount(txOut.Value)
+				gtx.value += amt
+				op := wire.OutPoint{Hash: *tx.Sha(), Index: uint32(n)}
+				outputs[op] = graphOutput{amount: amt, owner: com.ownerOf(actors, txOut)}
+			}
+			if !gtx.coinbase {
+				gtx.fee = in - gtx.value
+			}
+			g.txs = append(g.txs, gtx)
+		}
+	}
+	return g, nil
+}
+
+// ownerOf returns the name of the actor an output pays, empty if it pays
+// none or no single address
+func (com *Communication) ownerOf(actors []*Actor, txOut *wire.TxOut) string {
+	_, addrs, _, err := txscript.ExtractPkScriptAddrs(txOut.PkScript,
+		com.cfg.netParams())
+	if err != nil || len(addrs) != 1 {
+		return ""
+	}
+	a, err := com.getActor(actors, txOut)
+	if err != nil {
+		return ""
+	}
+	return a.String()
+}
+
+// writeTxGraphDOT writes the transaction graph in the DOT language
+func writeTxGraphDOT(w io.Writer, g *txGraph) error {
+	if _, err := fmt.Fprintln(w, "digraph txs {"); err != nil {
+		return err
+	}
+	for _, tx := range g.txs {
+		_, err := fmt.Fprintf(w, "\t%q [actor=%q, height=%d, coinbase=%t, value=%d, fee=%d];\n",
+			tx.txid, tx.actor, tx.height, tx.coinbase, int64(tx.value), int64(tx.fee))
+		if err != nil {
+			return err
+		}
+	}
+	for _, s := range g.spends {
+		_, err := fmt.Fprintf(w, "\t%q -> %q [vout=%d, amount=%d, owner=%q];\n",
+			s.from, s.to, s.vout, int64(s.amount), s.owner)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintln(w, "}")
+	return err
+}
+
+// graphMLHeader declares the attributes of the nodes and edges of the
+// transaction graph in GraphML
+const graphMLHeader = `<?xml version="1.0" encoding="UTF-8"?>
+<graphml xmlns="http://graphml.graphdrawing.org/xmlns">
+  <key id="actor" for="node" attr.name="actor" attr.type="string"/>
+  <key id="height" for="node" attr.name="height" attr.type="int"/>
+  <key id="coinbase" for="node" attr.name="coinbase" attr.type="boolean"/>
+  <key id="value" for="node" attr.name="value" attr.type="long"/>
+  <key id="fee" for="node" attr.name="fee" attr.type="long"/>
+  <key id="vout" for="edge" attr.name="vout" attr.type="int"/>
+  <key id="amount" for="edge" attr.name="amount" attr.type="long"/>
+  <key id="owner" for="edge" attr.name="owner" attr.type="string"/>
+  <graph id="txs" edgedefault="directed">
+`
+
+// writeTxGraphML writes the transaction graph in GraphML
+func writeTxGraphML(w io.Writer, g *txGraph) error {
+	escape := func(s string) string {
+		var b bytes.Buffer
+		xml.EscapeText(&b, []byte(s))
+		return b.String()
+	}
+	if _, err := io.WriteString(w, graphMLHeader); err != nil {
+		return err
+	}
+	for _, tx := range g.txs {
+		_, err := fmt.Fprintf(w, `    <node id="%s"><data key="actor">%s</data><data key="height">%d</data><data key="coinbase">%t</data><data key="value">%d</data><data key="fee">%d</data></node>`+"\n",
+			tx.txid, escape(tx.actor), tx.height, tx.coinbase, int64(tx.value), int64(tx.fee))
+		if err != nil {
+			return err
+		}
+	}
+	for _, s := range g.spends {
+		_, err := fmt.Fprintf(w, `    <edge source="%s" target="%s"><data key="vout">%d</data><data key="amount">%d</data><data key="owner">%s</data></edge>`+"\n",
+			s.from, s.to, s.vout, int64(s.amount), escape(s.owner))
+		if err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w, "  </graph>\n</graphml>\n")
+	return err
+}
+
+// writeTxGraph writes the transaction graph to the given path in GraphML
+// if the path has a .graphml extension, DOT otherwise
+func writeTxGraph(path string, g *txGraph) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if filepath.Ext(path) == ".graphml" {
+		err = writeTxGraphML(file, g)
+	} else {
+		err = writeTxGraphDOT(file, g)
+	}
+	if err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
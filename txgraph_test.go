@@ -0,0 +1,86 @@
+package btcsim
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testTxGraph() *txGraph {
+	return &txGraph{
+		txs: []*graphTx{
+			{txid: "aa", height: 1, coinbase: true, actor: "", value: 5e9},
+			{txid: "bb", height: 2, actor: "actor-0", value: 49e8, fee: 1e8},
+		},
+		spends: []*graphSpend{
+			{from: "aa", to: "bb", vout: 0, amount: 5e9, owner: "actor-0"},
+		},
+	}
+}
+
+func TestWriteTxGraphDOT(t *testing.T) {
+	var b bytes.Buffer
+	if err := writeTxGraphDOT(&b, testTxGraph()); err != nil {
+		t.Fatalf("writeTxGraphDOT error: %v", err)
+	}
+	for _, want := range []string{
+		"digraph txs {",
+		`"bb" [actor="actor-0", height=2, coinbase=false, value=4900000000, fee=100000000];`,
+		`"aa" -> "bb" [vout=0, amount=5000000000, owner="actor-0"];`,
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("DOT graph lacks %q:\n%s", want, b.String())
+		}
+	}
+}
+
+func TestWriteTxGraphML(t *testing.T) {
+	g := testTxGraph()
+	g.txs[1].actor = "a<b>"
+	var b bytes.Buffer
+	if err := writeTxGraphML(&b, g); err != nil {
+		t.Fatalf("writeTxGraphML error: %v", err)
+	}
+	var doc struct {
+		Nodes []struct {
+			ID string `xml:"id,attr"`
+		} `xml:"graph>node"`
+		Edges []struct {
+			Source string `xml:"source,attr"`
+			Target string `xml:"target,attr"`
+		} `xml:"graph>edge"`
+	}
+	if err := xml.Unmarshal(b.Bytes(), &doc); err != nil {
+		t.Fatalf("invalid GraphML: %v\n%s", err, b.String())
+	}
+	if len(doc.Nodes) != 2 || len(doc.Edges) != 1 || doc.Edges[0].Source != "aa" ||
+		doc.Edges[0].Target != "bb" {
+		t.Errorf("got %+v", doc)
+	}
+}
+
+func TestWriteTxGraph(t *testing.T) {
+	dir, err := ioutil.TempDir("", "btcsim-txgraph")
+	if err != nil {
+		t.Fatalf("TempDir error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for name, prefix := range map[string]string{"txs.dot": "digraph", "txs.graphml": "<?xml"} {
+		path := filepath.Join(dir, name)
+		if err := writeTxGraph(path, testTxGraph()); err != nil {
+			t.Fatalf("writeTxGraph(%s) error: %v", name, err)
+		}
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatalf("ReadFile error: %v", err)
+		}
+		if !strings.HasPrefix(string(b), prefix) {
+			t.Errorf("%s starts with %q", name, b[:10])
+		}
+	}
+}
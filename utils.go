@@ -2,21 +2,132 @@
 // Use of this source code is governed by an ISC
 // license that can be found in the LICENSE file.
 
-package main
+package btcsim
 
 import (
 	"encoding/csv"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math/rand"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/btcsuite/btcutil"
 )
 
+// Random streams, each component of the simulation draws from its own
+// stream derived from the seed so that it makes the same decisions given
+// the same seed, regardless of what other components do
+const (
+	comStream int64 = iota
+	minerStream
+	topologyStream
+	attackStream
+	chaosStream
+	matchStream
+	multisigStream
+	dustStream
+	coinjoinStream
+	crashStream
+	invoiceStream
+	faultStream
+	orphanStream
+	fuzzStream
+	timelockStream
+
+	// proxyStream is the stream of the proxy of the first link between
+	// nodes, the following proxies use the following streams
+	proxyStream int64 = 100
+
+	// actorStream is the stream of the first actor, the following
+	// actors use the following streams
+	actorStream int64 = 1000
+)
+
+// runsRoot is the directory holding the run dirs, apart from any other
+// data, so that the data of old runs can be removed with btcsim clean
+var runsRoot = filepath.Join(AppDataDir, "runs")
+
+// runPidFile is the file of the run dir holding the pid of the btcsim process
+// running the simulation, which tells btcsim clean the run is in progress
+const runPidFile = "btcsim.pid"
+
+// initRunDir creates the run dir of c in runsRoot, a uniquely named
+// directory named after the run ID holding the data, log and pid files of
+// the simulation so that several simulations can run on the same machine
+// at once, and writes the pid of the process in it. The run dir is set up
+// under a name btcsim clean ignores and only renamed once it holds the
+// pid, so that it is never taken for the run dir of a finished run.
+func (c *Config) initRunDir() error {
+	if err := os.MkdirAll(runsRoot, 0700); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempDir(runsRoot, fmt.Sprintf(".run-%d-", c.RunID))
+	if err != nil {
+		return err
+	}
+	pid := []byte(fmt.Sprintf("%d\n", os.Getpid()))
+	err = ioutil.WriteFile(filepath.Join(tmp, runPidFile), pid, 0600)
+	if err == nil {
+		dir := filepath.Join(runsRoot, strings.TrimPrefix(filepath.Base(tmp), "."))
+		if err = os.Rename(tmp, dir); err == nil {
+			c.runDir = dir
+			return nil
+		}
+	}
+	os.RemoveAll(tmp)
+	return err
+}
+
+// tempDir creates a uniquely named directory starting with prefix in
+// the run dir, or the system temporary directory if it is not set
+func (c *Config) tempDir(prefix string) (string, error) {
+	return ioutil.TempDir(c.runDir, prefix)
+}
+
+// runPath returns the path of the named file in the run dir, or in AppDataDir
+// if it is not set
+func (c *Config) runPath(name string) string {
+	if c.runDir == "" {
+		return filepath.Join(AppDataDir, name)
+	}
+	return filepath.Join(c.runDir, name)
+}
+
+// lockedSource is a rand.Source which is safe for concurrent use
+type lockedSource struct {
+	mtx sync.Mutex
+	src rand.Source
+}
+
+// Int63 returns a non-negative pseudo-random 63-bit integer as an int64
+func (s *lockedSource) Int63() int64 {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.src.Int63()
+}
+
+// Seed uses the provided seed value to initialize the source
+func (s *lockedSource) Seed(seed int64) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.src.Seed(seed)
+}
+
+// newRand returns a rand.Rand safe for concurrent use which draws from
+// the given random stream of the simulation seed
+func (c *Config) newRand(stream int64) *rand.Rand {
+	// multiply the stream by a large odd constant so that the streams
+	// of consecutive seeds don't overlap
+	src := rand.NewSource(c.Seed ^ (stream+1)*0x5DEECE66D)
+	return rand.New(&lockedSource{src: src})
+}
+
 // Row represents a row in the CSV file
 // and holds the key and value ints
 type Row struct {
@@ -53,15 +164,52 @@ func readCSV(r io.Reader) (map[int32]*Row, error) {
 	return m, nil
 }
 
-func getLogFile(prefix string) (*os.File, error) {
-	return os.Create(filepath.Join(AppDataDir, fmt.Sprintf("%s.log", prefix)))
+// getLogFile creates the rotating log file of the node or wallet with the
+// given prefix in the run directory
+func (c *Config) getLogFile(prefix string) (io.WriteCloser, error) {
+	f, err := newRotatingFile(c.runPath(fmt.Sprintf("%s.log", prefix)), c.MaxLogSize)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// output returns where the progress and the summary of the simulation
+// are printed: the standard output, or the standard error when the events
+// are streamed to the standard output so that the stream stays parseable
+func (c *Config) output() io.Writer {
+	if c.EventsOutPath == "-" {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+// progressOutput returns where the progress is printed, nowhere while the
+// terminal UI draws on the standard output
+func (c *Config) progressOutput() io.Writer {
+	if c.TUI {
+		return ioutil.Discard
+	}
+	return c.output()
+}
+
+// printProgress prints the count of blocks or transactions done so far
+// over the previous one, on the same line of the output
+func (c *Config) printProgress(done, total int) {
+	fmt.Fprintf(c.progressOutput(), "\r%d/%d", done, total)
 }
 
-// genCertPair generates a key/cert pair to the paths provided.
-func genCertPair(certFile, keyFile string) error {
+// endProgress ends the line the progress is printed on
+func (c *Config) endProgress() {
+	fmt.Fprintln(c.progressOutput())
+}
+
+// genCertPair generates a key/cert pair to the paths provided, valid for
+// the extra hosts in addition to the local ones.
+func genCertPair(certFile, keyFile string, extraHosts ...string) error {
 	org := "btcsim autogenerated cert"
 	validUntil := time.Now().Add(10 * 365 * 24 * time.Hour)
-	cert, key, err := btcutil.NewTLSCertPair(org, validUntil, nil)
+	cert, key, err := btcutil.NewTLSCertPair(org, validUntil, extraHosts)
 	if err != nil {
 		return err
 	}
@@ -78,6 +226,22 @@ func genCertPair(certFile, keyFile string) error {
 	return nil
 }
 
+// newCertPair generates a self-signed cert pair for the rpc server of a node
+// in dir, as btcd and btcwallet do on their first start, and returns their
+// paths. Containers reach each other by name, so the cert of a docker run
+// covers the names of the containers too.
+func newCertPair(cfg *Config, dir string) (certFile, keyFile string, err error) {
+	var extraHosts []string
+	if cfg.DockerMode {
+		extraHosts = dockerHosts(cfg.NumNodes)
+	}
+	certFile, keyFile = filepath.Join(dir, "rpc.cert"), filepath.Join(dir, "rpc.key")
+	if err := genCertPair(certFile, keyFile, extraHosts...); err != nil {
+		return "", "", err
+	}
+	return certFile, keyFile, nil
+}
+
 // filesExists reports whether the named file or directory exists.
 func fileExists(name string) bool {
 	if _, err := os.Stat(name); err != nil {
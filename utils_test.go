@@ -1,4 +1,4 @@
-package main
+package btcsim
 
 import (
 	"io"
@@ -60,3 +60,26 @@ func TestReadCSVErrors(t *testing.T) {
 		t.Errorf("readCSV expected error, got %v", err)
 	}
 }
+
+func TestNewRand(t *testing.T) {
+	cfg := &Config{Seed: 42}
+	first := cfg.newRand(actorStream).Int63()
+
+	// the same stream of the same seed yields the same sequence
+	a, b := cfg.newRand(actorStream), cfg.newRand(actorStream)
+	for i := 0; i < 10; i++ {
+		if x, y := a.Int63(), b.Int63(); x != y {
+			t.Fatalf("same stream diverged at %d: %d != %d", i, x, y)
+		}
+	}
+
+	// different streams and seeds yield different sequences
+	c := cfg.newRand(actorStream + 1)
+	if a.Int63() == c.Int63() {
+		t.Errorf("different streams yield the same value")
+	}
+	cfg.Seed = 43
+	if cfg.newRand(actorStream).Int63() == first {
+		t.Errorf("different seeds yield the same value")
+	}
+}
@@ -0,0 +1,199 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+// utxoAgeBounds are the upper bounds in blocks of the age buckets of the
+// utxo snapshots, about an hour, a day and a week of mainnet blocks, the
+// last bucket holding the older outputs
+var utxoAgeBounds = []int32{6, 144, 1008}
+
+// utxoEntry is an unspent output of the chain
+type utxoEntry struct {
+	amount btcutil.Amount
+	height int32
+}
+
+// utxoSet follows the unspent outputs of the chain as the blocks are
+// mined and snapshots it every given number of blocks. Blocks disconnected
+// by a reorg are not rolled back, so the set is approximate after one.
+type utxoSet struct {
+	every int32
+
+	mtx       sync.Mutex
+	utxos     map[wire.OutPoint]utxoEntry
+	snapshots []*UtxoSnapshot
+}
+
+// newUtxoSet returns an empty set snapshotted every given number of blocks
+func newUtxoSet(every int) *utxoSet {
+	return &utxoSet{
+		every: int32(every),
+		utxos: make(map[wire.OutPoint]utxoEntry),
+	}
+}
+
+// mined updates the set with a block mined event and snapshots it every
+// s.every blocks
+func (s *utxoSet) mined(e *Event) {
+	if e.Block.block == nil {
+		return
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.connect(e.Block.block, e.Height)
+	if e.Height%s.every == 0 {
+		s.snapshots = append(s.snapshots, s.snapshot(e.Height))
+	}
+}
+
+// connect spends the outputs spent by the block and adds the spendable
+// outputs it creates, the lock must be held by the caller
+func (s *utxoSet) connect(block *btcutil.Block, height int32) {
+	for i, tx := range block.Transactions() {
+		if i > 0 {
+			for _, txIn := range tx.MsgTx().TxIn {
+				delete(s.utxos, txIn.PreviousOutPoint)
+			}
+		}
+		for n, txOut := range tx.MsgTx().TxOut {
+			if txscript.GetScriptClass(txOut.PkScript) == txscript.NullDataTy {
+				continue
+			}
+			op := wire.OutPoint{Hash: *tx.Sha(), Index: uint32(n)}
+			s.utxos[op] = utxoEntry{amount: btcutil.Amount(txOut.Value), height: height}
+		}
+	}
+}
+
+// snapshot returns the state of the set at the given height, the lock
+// must be held by the caller
+func (s *utxoSet) snapshot(height int32) *UtxoSnapshot {
+	snap := &UtxoSnapshot{
+		Height: height,
+		Count:  len(s.utxos),
+		Ages:   make([]int, len(utxoAgeBounds)+1),
+	}
+	var age int64
+	for _, u := range s.utxos {
+		snap.Value += int64(u.amount)
+		if u.amount < minFee {
+			snap.Dust++
+			snap.DustValue += int64(u.amount)
+		}
+		a := height - u.height
+		age += int64(a)
+		bucket := len(utxoAgeBounds)
+		for i, bound := range utxoAgeBounds {
+			if a < bound {
+				bucket = i
+				break
+			}
+		}
+		snap.Ages[bucket]++
+	}
+	if snap.Count > 0 {
+		snap.MeanAge = float64(age) / float64(snap.Count)
+	}
+	return snap
+}
+
+// Snapshots returns the snapshots of the set taken so far
+func (s *utxoSet) Snapshots() []*UtxoSnapshot {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return append([]*UtxoSnapshot(nil), s.snapshots...)
+}
+
+// UtxoSnapshot is the state of the utxo set at a height
+type UtxoSnapshot struct {
+	Height int32 `json:"height"`
+
+	// Count is the number of unspent outputs and Value their amount,
+	// Dust the number of those worth less than the fee to spend them and
+	// DustValue their amount
+	Count     int   `json:"count"`
+	Value     int64 `json:"value"`
+	Dust      int   `json:"dust"`
+	DustValue int64 `json:"dustvalue"`
+
+	// Ages is the number of outputs in each age bucket, younger than the
+	// bounds of utxoAgeBounds and older, and MeanAge their mean age in
+	// blocks
+	Ages    []int   `json:"ages"`
+	MeanAge float64 `json:"meanage"`
+}
+
+// DustFraction returns the fraction of the outputs which are dust
+func (s *UtxoSnapshot) DustFraction() float64 {
+	if s.Count == 0 {
+		return 0
+	}
+	return float64(s.Dust) / float64(s.Count)
+}
+
+// String returns the snapshot in a line of the summary
+func (s *UtxoSnapshot) String() string {
+	return fmt.Sprintf("%d outputs worth %v at height %d, %.1f%% dust, mean age %.1f blocks",
+		s.Count, btcutil.Amount(s.Value), s.Height, 100*s.DustFraction(), s.MeanAge)
+}
+
+// writeUtxoStatsCSV writes the utxo set snapshots as CSV with a header
+// row, with a column per age bucket
+func writeUtxoStatsCSV(w io.Writer, snapshots []*UtxoSnapshot) error {
+	writer := csv.NewWriter(w)
+	header := []string{"height", "count", "value", "dust", "dustvalue", "dustfraction", "meanage"}
+	for _, bound := range utxoAgeBounds {
+		header = append(header, fmt.Sprintf("age<%d", bound))
+	}
+	header = append(header, fmt.Sprintf("age>=%d", utxoAgeBounds[len(utxoAgeBounds)-1]))
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+	for _, s := range snapshots {
+		row := []string{
+			strconv.Itoa(int(s.Height)),
+			strconv.Itoa(s.Count),
+			strconv.FormatInt(s.Value, 10),
+			strconv.Itoa(s.Dust),
+			strconv.FormatInt(s.DustValue, 10),
+			strconv.FormatFloat(s.DustFraction(), 'f', -1, 64),
+			strconv.FormatFloat(s.MeanAge, 'f', -1, 64),
+		}
+		for _, n := range s.Ages {
+			row = append(row, strconv.Itoa(n))
+		}
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// writeUtxoStats writes the utxo set snapshots to the given path as CSV
+func writeUtxoStats(path string, snapshots []*UtxoSnapshot) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := writeUtxoStatsCSV(file, snapshots); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
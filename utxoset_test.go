@@ -0,0 +1,62 @@
+package btcsim
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+func TestUtxoSetSnapshot(t *testing.T) {
+	s := newUtxoSet(10)
+	outputs := []struct {
+		amount btcutil.Amount
+		height int32
+	}{
+		{5e9, 1},
+		{1e8, 95},
+		{minFee - 1, 98},
+		{2e8, 100},
+	}
+	for i, o := range outputs {
+		s.utxos[wire.OutPoint{Index: uint32(i)}] = utxoEntry{amount: o.amount, height: o.height}
+	}
+
+	snap := s.snapshot(100)
+	if snap.Count != 4 || snap.Value != int64(5e9+1e8+minFee-1+2e8) {
+		t.Errorf("got %d outputs worth %d", snap.Count, snap.Value)
+	}
+	if snap.Dust != 1 || snap.DustFraction() != 0.25 {
+		t.Errorf("got %d dust outputs, fraction %v", snap.Dust, snap.DustFraction())
+	}
+	// ages 99, 5, 2 and 0 blocks
+	if want := []int{3, 1, 0, 0}; len(snap.Ages) != len(want) || snap.Ages[0] != 3 || snap.Ages[1] != 1 {
+		t.Errorf("got ages %v want %v", snap.Ages, want)
+	}
+	if snap.MeanAge != 26.5 {
+		t.Errorf("got mean age %v", snap.MeanAge)
+	}
+	if !strings.Contains(snap.String(), "25.0% dust") {
+		t.Errorf("got %q", snap.String())
+	}
+
+	var b bytes.Buffer
+	if err := writeUtxoStatsCSV(&b, []*UtxoSnapshot{snap}); err != nil {
+		t.Fatalf("writeUtxoStatsCSV error: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 2 || !strings.HasSuffix(lines[0], "age<1008,age>=1008") ||
+		!strings.HasSuffix(lines[1], ",3,1,0,0") {
+		t.Errorf("got CSV:\n%s", b.String())
+	}
+}
+
+func TestUtxoSetMined(t *testing.T) {
+	s := newUtxoSet(2)
+	s.mined(&Event{Kind: eventBlockMined, Height: 2, Block: &blockTxs{height: 2}})
+	if len(s.Snapshots()) != 0 {
+		t.Errorf("snapshotted a block which was not fetched")
+	}
+}
@@ -0,0 +1,188 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// version is a named executable of a program compared with others
+type version struct {
+	name string
+	exe  string
+}
+
+// parseVersions parses the executables of a program to compare given as
+// e.g. "old=/opt/btcd-0.9/btcd,new=btcd", at least two are required
+func parseVersions(program, s string) ([]version, error) {
+	pairs, err := parseAssignments(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(pairs) < 2 {
+		return nil, fmt.Errorf("at least two %s versions are required", program)
+	}
+	seen := make(map[string]bool)
+	var versions []version
+	for _, kv := range pairs {
+		if kv[0] == "" || kv[1] == "" {
+			return nil, fmt.Errorf("invalid %s version %q, expected name=executable", program, kv[0]+"="+kv[1])
+		}
+		if seen[kv[0]] {
+			return nil, fmt.Errorf("duplicate %s version %q", program, kv[0])
+		}
+		seen[kv[0]] = true
+		versions = append(versions, version{name: kv[0], exe: kv[1]})
+	}
+	return versions, nil
+}
+
+// BtcdVersionResult is the aggregate of the runs with a btcd executable
+type BtcdVersionResult struct {
+	Name      string     `json:"name"`
+	Exe       string     `json:"exe"`
+	Aggregate *Aggregate `json:"aggregate"`
+
+	// NodeCPU is the cpu time in seconds used by the btcd processes of
+	// a run and NodeRSS the peak resident set size in MB of a btcd
+	// process, they are only measured with -resourcemonitor
+	NodeCPU Metric `json:"nodecpu"`
+	NodeRSS Metric `json:"noderss"`
+}
+
+// newBtcdVersionResult returns the result of the runs of a btcd version
+// given their summaries, nil for failed runs
+func newBtcdVersionResult(v version, summaries []*Summary) *BtcdVersionResult {
+	var cpu, rss []float64
+	for _, s := range summaries {
+		if s == nil || len(s.Resources) == 0 {
+			continue
+		}
+		var runCPU, runRSS float64
+		for _, u := range s.Resources {
+			if u.Exe != "btcd" {
+				continue
+			}
+			runCPU += u.CPUTime.Seconds()
+			if mb := float64(u.PeakRSS) / 1e6; mb > runRSS {
+				runRSS = mb
+			}
+		}
+		cpu = append(cpu, runCPU)
+		rss = append(rss, runRSS)
+	}
+	return &BtcdVersionResult{
+		Name:      v.name,
+		Exe:       v.exe,
+		Aggregate: NewAggregate(summaries),
+		NodeCPU:   newMetric(cpu),
+		NodeRSS:   newMetric(rss),
+	}
+}
+
+// BtcdComparison compares the runs of a campaign across btcd versions
+type BtcdComparison struct {
+	Results []*BtcdVersionResult `json:"results"`
+}
+
+// Write writes the comparison to w as a table with a column per version,
+// the mean of every metric across the runs of the version and its change
+// relative to the first version
+func (c *BtcdComparison) Write(w io.Writer) error {
+	metrics := []struct {
+		name   string
+		format string
+		value  func(r *BtcdVersionResult) float64
+	}{
+		{"Average transactions per sec", "%.2f", func(r *BtcdVersionResult) float64 { return r.Aggregate.TPS.Mean }},
+		{"Transactions confirmed", "%.0f", func(r *BtcdVersionResult) float64 { return r.Aggregate.Confirmed.Mean }},
+		{"Mean confirmation time (s)", "%.2f", func(r *BtcdVersionResult) float64 { return r.Aggregate.MeanConfTime.Mean }},
+		{"95th percentile confirmation time (s)", "%.2f", func(r *BtcdVersionResult) float64 { return r.Aggregate.P95ConfTime.Mean }},
+		{"Mempool high-water mark", "%.0f", func(r *BtcdVersionResult) float64 { return r.Aggregate.MaxMempool.Mean }},
+		{"btcd cpu time (s)", "%.1f", func(r *BtcdVersionResult) float64 { return r.NodeCPU.Mean }},
+		{"btcd peak rss (MB)", "%.1f", func(r *BtcdVersionResult) float64 { return r.NodeRSS.Mean }},
+	}
+
+	row := func(name string, cells []string) string {
+		line := fmt.Sprintf("%-40s", name)
+		for _, cell := range cells {
+			line += fmt.Sprintf("%-24s", cell)
+		}
+		return strings.TrimRight(line, " ")
+	}
+	var names, runs []string
+	for _, r := range c.Results {
+		names = append(names, r.Name)
+		runs = append(runs, fmt.Sprintf("%d (%d failed)", r.Aggregate.Runs, r.Aggregate.Failed))
+	}
+	lines := []string{row("btcd version", names), row("Runs", runs)}
+	for _, m := range metrics {
+		var cells []string
+		for i, r := range c.Results {
+			v := m.value(r)
+			cell := fmt.Sprintf(m.format, v)
+			if base := m.value(c.Results[0]); i > 0 && base != 0 {
+				cell += fmt.Sprintf(" (%+.1f%%)", (v-base)/base*100)
+			}
+			cells = append(cells, cell)
+		}
+		lines = append(lines, row(m.name, cells))
+	}
+
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runBtcdVersions runs a campaign of n simulations configured by cfg for
+// every btcd version and compares them. Run i of every version uses the
+// seed plus i, so that versions are compared running the same scenario.
+func runBtcdVersions(cfg *Config, versions []version, n int, parallel bool) error {
+	if err := checkRuns(cfg, n, parallel); err != nil {
+		return err
+	}
+	if cfg.BackendName != backendBtcd {
+		return fmt.Errorf("btcd versions cannot be compared with the %s backend", cfg.BackendName)
+	}
+	if cfg.DockerMode {
+		return errors.New("btcd versions cannot be compared with docker, " +
+			"compare images with -dockerconfig instead")
+	}
+
+	c := &BtcdComparison{}
+	failed, code := true, exitPass
+	for i, v := range versions {
+		log.Infof("Running %d simulation(s) with btcd %s (%s)...", n, v.name, v.exe)
+		summaries, batchCode := runBatch(cfg, i*n+1, n, parallel, fmt.Sprintf("-btcd=%s", v.exe))
+		if batchCode > code {
+			code = batchCode
+		}
+		result := newBtcdVersionResult(v, summaries)
+		c.Results = append(c.Results, result)
+		if result.Aggregate.Failed < n {
+			failed = false
+		}
+	}
+
+	log.Infof("btcd version comparison:")
+	c.Write(os.Stdout)
+	if cfg.SummaryPath != "" {
+		if err := writeJSON(cfg.SummaryPath, c); err != nil {
+			log.Errorf("Cannot write btcd version comparison: %v", err)
+			return err
+		}
+	}
+	if failed {
+		return errors.New("all runs failed")
+	}
+	return batchError(code)
+}
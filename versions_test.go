@@ -0,0 +1,73 @@
+package btcsim
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseVersions(t *testing.T) {
+	versions, err := parseVersions("btcd", "old=/opt/btcd-0.9/btcd, new=btcd")
+	if err != nil {
+		t.Fatalf("parseVersions error: %v", err)
+	}
+	want := []version{{"old", "/opt/btcd-0.9/btcd"}, {"new", "btcd"}}
+	if len(versions) != len(want) || versions[0] != want[0] || versions[1] != want[1] {
+		t.Errorf("parseVersions got %v want %v", versions, want)
+	}
+	for _, s := range []string{"", "old=btcd", "old=btcd,new=", "a=btcd,a=btcd", "btcd,btcd"} {
+		if _, err := parseVersions("btcd", s); err == nil {
+			t.Errorf("parseVersions(%q) expected error", s)
+		}
+	}
+}
+
+func TestBtcdComparison(t *testing.T) {
+	resources := func(cpu time.Duration, rss int64) map[string]*ResourceUsage {
+		return map[string]*ResourceUsage{
+			"node":        {Exe: "btcd", CPUTime: cpu, PeakRSS: rss},
+			"miner":       {Exe: "btcd", CPUTime: cpu, PeakRSS: rss / 2},
+			"actor-18557": {Exe: "btcwallet", CPUTime: time.Hour, PeakRSS: 1e9},
+		}
+	}
+	old := newBtcdVersionResult(version{"old", "btcd-old"}, []*Summary{
+		{TPS: 2, Resources: resources(10*time.Second, 100e6)},
+	})
+	if old.NodeCPU.Mean != 20 || old.NodeRSS.Mean != 100 {
+		t.Errorf("got cpu %.1fs and rss %.1f MB want 20s and 100 MB", old.NodeCPU.Mean, old.NodeRSS.Mean)
+	}
+	cur := newBtcdVersionResult(version{"new", "btcd"}, []*Summary{
+		{TPS: 3, Resources: resources(5*time.Second, 150e6)},
+		nil,
+	})
+
+	c := &BtcdComparison{Results: []*BtcdVersionResult{old, cur}}
+	var buf bytes.Buffer
+	if err := c.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(buf.String(), "\n")
+	for _, want := range [][]string{
+		{"btcd version", "old", "new"},
+		{"Runs", "1 (0 failed)", "2 (1 failed)"},
+		{"Average transactions per sec", "2.00", "3.00 (+50.0%)"},
+		{"btcd cpu time (s)", "20.0", "10.0 (-50.0%)"},
+		{"btcd peak rss (MB)", "100.0", "150.0 (+50.0%)"},
+	} {
+		found := false
+		for _, line := range lines {
+			if strings.HasPrefix(line, want[0]) {
+				found = true
+				for _, cell := range want[1:] {
+					if !strings.Contains(line, cell) {
+						t.Errorf("line %q missing %q", line, cell)
+					}
+				}
+			}
+		}
+		if !found {
+			t.Errorf("comparison output missing %q:\n%s", want[0], buf.String())
+		}
+	}
+}
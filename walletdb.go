@@ -0,0 +1,70 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/btcsuite/btcd/wire"
+)
+
+// walletDBName is the name of the database of btcwallet in the network
+// directory of its data directory
+const walletDBName = "wallet.db"
+
+// walletDBFile returns the path of the pre-built wallet database of actor
+// i in dir
+func walletDBFile(dir string, i int) string {
+	return filepath.Join(dir, fmt.Sprintf("wallet-%d.db", i))
+}
+
+// checkWalletDBs returns an error if the actors cannot be warm started
+// from the wallet databases in dir
+func checkWalletDBs(dir string, inProcess bool, loadState string) error {
+	if dir == "" {
+		return nil
+	}
+	if inProcess {
+		return errors.New("in-process wallets cannot be started from wallet databases")
+	}
+	if loadState != "" {
+		return errors.New("wallet databases cannot be imported into a resumed simulation")
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	return nil
+}
+
+// importWalletDB copies the pre-built wallet database of actor i in dir,
+// if any, to the data directory of the wallet of the actor, which then
+// opens it instead of creating a new wallet
+func importWalletDB(a *Actor, dir string, i int) error {
+	src := walletDBFile(dir, i)
+	if !fileExists(src) {
+		return nil
+	}
+	data, err := dataDir(a.Args)
+	if err != nil {
+		return err
+	}
+	netDir := filepath.Join(data, strings.ToLower(wire.SimNet.String()))
+	if err := os.MkdirAll(netDir, 0700); err != nil {
+		return err
+	}
+	if err := copyFile(src, filepath.Join(netDir, walletDBName), 0600); err != nil {
+		return err
+	}
+	a.imported = true
+	return nil
+}
@@ -0,0 +1,66 @@
+package btcsim
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/wire"
+)
+
+func TestCheckWalletDBs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "btcsim-walletdbs")
+	if err != nil {
+		t.Fatalf("TempDir error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := checkWalletDBs("", true, "state"); err != nil {
+		t.Errorf("checkWalletDBs error without databases: %v", err)
+	}
+	if err := checkWalletDBs(dir, false, ""); err != nil {
+		t.Errorf("checkWalletDBs error: %v", err)
+	}
+	for _, test := range []struct {
+		dir       string
+		inProcess bool
+		loadState string
+	}{
+		{dir, true, ""},
+		{dir, false, "state"},
+		{filepath.Join(dir, "missing"), false, ""},
+	} {
+		if err := checkWalletDBs(test.dir, test.inProcess, test.loadState); err == nil {
+			t.Errorf("checkWalletDBs(%q, %v, %q) expected error", test.dir, test.inProcess, test.loadState)
+		}
+	}
+}
+
+func TestImportWalletDB(t *testing.T) {
+	dir, err := ioutil.TempDir("", "btcsim-walletdbs")
+	if err != nil {
+		t.Fatalf("TempDir error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(walletDBFile(dir, 1), []byte("wallet"), 0600); err != nil {
+		t.Fatalf("WriteFile error: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		a := fakeActor("a")
+		args := &btcwalletArgs{DataDir: filepath.Join(dir, "actor-data")}
+		a.Args = args
+		if err := importWalletDB(a, dir, i); err != nil {
+			t.Fatalf("importWalletDB(%d) error: %v", i, err)
+		}
+		if a.imported != (i == 1) {
+			t.Errorf("actor %d got imported %v", i, a.imported)
+		}
+	}
+	db, err := ioutil.ReadFile(filepath.Join(dir, "actor-data", strings.ToLower(wire.SimNet.String()), walletDBName))
+	if err != nil || string(db) != "wallet" {
+		t.Errorf("got wallet database %q, %v", db, err)
+	}
+}
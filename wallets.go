@@ -0,0 +1,132 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// checkWalletVersions returns an error if the actors cannot run different
+// btcwallet versions, which requires btcwallet processes run locally
+func checkWalletVersions(versions []version, inProcess, docker bool) error {
+	if len(versions) == 0 {
+		return nil
+	}
+	if inProcess {
+		return errors.New("in-process wallets cannot run btcwallet versions")
+	}
+	if docker {
+		return errors.New("btcwallet versions cannot be compared with docker, " +
+			"compare images with -dockerconfig instead")
+	}
+	return nil
+}
+
+// assignWallets returns the btcwallet versions of n actors, which are
+// split into contiguous groups of equal size, one per version, unless
+// given per actor assignments, e.g. "0=old,3=new". The names are empty
+// without versions.
+func assignWallets(n int, versions []version, perActor string) ([]string, error) {
+	assigned := make([]string, n)
+	if len(versions) == 0 {
+		if perActor != "" {
+			return nil, errors.New("actor wallets require btcwallet versions")
+		}
+		return assigned, nil
+	}
+	known := make(map[string]bool, len(versions))
+	for _, v := range versions {
+		known[v.name] = true
+	}
+	for i := range assigned {
+		assigned[i] = versions[i*len(versions)/n].name
+	}
+	overrides, err := parseAssignments(perActor)
+	if err != nil {
+		return nil, err
+	}
+	for _, o := range overrides {
+		i, err := strconv.Atoi(o[0])
+		if err != nil || i < 0 || i >= n {
+			return nil, fmt.Errorf("invalid actor index %q", o[0])
+		}
+		if !known[o[1]] {
+			return nil, fmt.Errorf("unknown btcwallet version %q", o[1])
+		}
+		assigned[i] = o[1]
+	}
+	return assigned, nil
+}
+
+// setWallet makes the actor run the btcwallet executable of the named
+// version, it must be called before the actor is started
+func (a *Actor) setWallet(name string, versions []version) {
+	for _, v := range versions {
+		if v.name != name {
+			continue
+		}
+		if args, ok := unwrapArgs(a.Args).(*btcwalletArgs); ok {
+			args.exe = v.exe
+		}
+		a.walletVersion = name
+	}
+}
+
+// WalletVersionStats compares the actors running a btcwallet version
+type WalletVersionStats struct {
+	Name   string `json:"name"`
+	Exe    string `json:"exe"`
+	Actors int    `json:"actors"`
+
+	// confirmed payments sent and received by the actors
+	Sent     int `json:"sent"`
+	Received int `json:"received"`
+
+	// CPUTime is the cpu time used by the wallets and PeakRSS the peak
+	// resident set size of a wallet, they are only measured with
+	// -resourcemonitor
+	CPUTime time.Duration `json:"cputime"`
+	PeakRSS int64         `json:"peakrss"`
+
+	// RPC is the latency of the calls of every method to the wallets,
+	// only measured with -rpcstats
+	RPC []*RPCLatency `json:"rpc,omitempty"`
+}
+
+// newWalletVersionStats returns the stats of the actors running each
+// version given their payments, resource usage and rpc calls by actor
+func newWalletVersionStats(versions []version, actors []*Actor,
+	resources map[string]*ResourceUsage, calls *rpcRecorder) []*WalletVersionStats {
+
+	var stats []*WalletVersionStats
+	for _, v := range versions {
+		w := &WalletVersionStats{Name: v.name, Exe: v.exe}
+		names := make(map[string]bool)
+		for _, a := range actors {
+			if a.walletVersion != v.name {
+				continue
+			}
+			names[a.String()] = true
+			w.Actors++
+			sent, received := a.Payments()
+			w.Sent += int(sent)
+			w.Received += int(received)
+			if u := resources[a.String()]; u != nil {
+				w.CPUTime += u.CPUTime
+				if u.PeakRSS > w.PeakRSS {
+					w.PeakRSS = u.PeakRSS
+				}
+			}
+		}
+		if calls != nil {
+			w.RPC = calls.group(v.name, names)
+		}
+		stats = append(stats, w)
+	}
+	return stats
+}
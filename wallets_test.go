@@ -0,0 +1,83 @@
+package btcsim
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAssignWallets(t *testing.T) {
+	versions := []version{{"old", "btcwallet-old"}, {"new", "btcwallet"}}
+	assigned, err := assignWallets(5, versions, "4=old")
+	if err != nil {
+		t.Fatalf("assignWallets error: %v", err)
+	}
+	if want := []string{"old", "old", "old", "new", "old"}; !reflect.DeepEqual(assigned, want) {
+		t.Errorf("assignWallets got %v want %v", assigned, want)
+	}
+
+	if assigned, err := assignWallets(2, nil, ""); err != nil || assigned[0] != "" {
+		t.Errorf("assignWallets without versions got %v, %v", assigned, err)
+	}
+	for _, perActor := range []string{"5=old", "0=other", "x=new"} {
+		if _, err := assignWallets(5, versions, perActor); err == nil {
+			t.Errorf("assignWallets(%q) expected error", perActor)
+		}
+	}
+	if _, err := assignWallets(5, nil, "0=old"); err == nil {
+		t.Errorf("assignWallets expected error without versions")
+	}
+}
+
+func TestCheckWalletVersions(t *testing.T) {
+	versions := []version{{"old", "btcwallet-old"}, {"new", "btcwallet"}}
+	if err := checkWalletVersions(nil, true, true); err != nil {
+		t.Errorf("checkWalletVersions error without versions: %v", err)
+	}
+	if err := checkWalletVersions(versions, true, false); err == nil {
+		t.Errorf("checkWalletVersions expected error with in-process wallets")
+	}
+	if err := checkWalletVersions(versions, false, true); err == nil {
+		t.Errorf("checkWalletVersions expected error with docker")
+	}
+}
+
+func TestSetWallet(t *testing.T) {
+	a := fakeActor("a")
+	args := &btcwalletArgs{prefix: "a", exe: "btcwallet"}
+	a.Args = args
+	versions := []version{{"old", "/opt/btcwallet-old"}, {"new", "btcwallet"}}
+	a.setWallet("old", versions)
+	if args.exe != "/opt/btcwallet-old" || a.walletVersion != "old" {
+		t.Errorf("got exe %q and version %q", args.exe, a.walletVersion)
+	}
+}
+
+func TestNewWalletVersionStats(t *testing.T) {
+	versions := []version{{"old", "btcwallet-old"}, {"new", "btcwallet"}}
+	a, b, c := fakeActor("a"), fakeActor("b"), fakeActor("c")
+	a.walletVersion, b.walletVersion, c.walletVersion = "old", "old", "new"
+	resources := map[string]*ResourceUsage{
+		a.String(): {CPUTime: time.Second, PeakRSS: 100},
+		b.String(): {CPUTime: 2 * time.Second, PeakRSS: 300},
+	}
+	calls := newRPCRecorder()
+	calls.record(a.String(), "sendrawtransaction", time.Millisecond, nil)
+	calls.record(b.String(), "sendrawtransaction", 3*time.Millisecond, nil)
+	calls.record(c.String(), "sendrawtransaction", time.Second, nil)
+
+	stats := newWalletVersionStats(versions, []*Actor{a, b, c}, resources, calls)
+	if len(stats) != 2 {
+		t.Fatalf("got %d versions want 2", len(stats))
+	}
+	old := stats[0]
+	if old.Actors != 2 || old.CPUTime != 3*time.Second || old.PeakRSS != 300 {
+		t.Errorf("got old stats %+v", old)
+	}
+	if len(old.RPC) != 1 || old.RPC[0].Calls != 2 || old.RPC[0].Mean != 2*time.Millisecond {
+		t.Errorf("got old rpc latency %+v", old.RPC)
+	}
+	if stats[1].Actors != 1 || stats[1].RPC[0].Max != time.Second {
+		t.Errorf("got new stats %+v", stats[1])
+	}
+}
@@ -0,0 +1,278 @@
+// Copyright (c) 2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcsim
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	rpc "github.com/btcsuite/btcrpcclient"
+	"github.com/btcsuite/btcutil"
+)
+
+// watchOnly is a watch-only actor, it holds no keys and cannot spend, it
+// only observes the payments to the addresses of the actor it watches
+// from the notifications of its node and tracks their balance
+type watchOnly struct {
+	name    string
+	watched string
+	addrs   map[string]bool
+	client  *rpcClient
+
+	// height is the height of the chain when the addresses were watched,
+	// only the later payments are notified
+	height int64
+
+	// received are the confirmed outputs paying the addresses and spent
+	// the outputs spent by confirmed transactions, as notified
+	mtx      sync.Mutex
+	received map[wire.OutPoint]btcutil.Amount
+	spent    map[wire.OutPoint]bool
+}
+
+// newWatchOnly returns a watch-only actor of the given addresses, which
+// are not watched until it is connected
+func newWatchOnly(name, watched string, addrs []btcutil.Address) *watchOnly {
+	w := &watchOnly{
+		name:     name,
+		watched:  watched,
+		addrs:    make(map[string]bool, len(addrs)),
+		received: make(map[wire.OutPoint]btcutil.Amount),
+		spent:    make(map[wire.OutPoint]bool),
+	}
+	for _, addr := range addrs {
+		w.addrs[addr.EncodeAddress()] = true
+	}
+	return w
+}
+
+// String returns the name of the watch-only actor
+func (w *watchOnly) String() string {
+	return w.name
+}
+
+// connect connects the watch-only actor to node and registers for the
+// notifications of the payments to its addresses
+func (w *watchOnly) connect(node *Node, addrs []btcutil.Address) error {
+	handlers := &rpc.NotificationHandlers{
+		OnRecvTx:      w.onRecvTx,
+		OnRedeemingTx: w.onRedeemingTx,
+	}
+	conf := node.RPCConnConfig()
+	client, err := rpc.New(&conf, handlers)
+	if err != nil {
+		return err
+	}
+	w.client = newRPCClient(node.cfg, client, w.name)
+	if w.height, err = w.client.GetBlockCount(); err != nil {
+		w.client.Shutdown()
+		return err
+	}
+	if err := w.client.NotifyReceived(addrs); err != nil {
+		w.client.Shutdown()
+		return err
+	}
+	return nil
+}
+
+// pays reports whether pkScript pays one of the watched addresses
+func (w *watchOnly) pays(pkScript []byte) bool {
+	_, addrs, _, err := txscript.ExtractPkScriptAddrs(pkScript, &chaincfg.SimNetParams)
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		if w.addrs[addr.EncodeAddress()] {
+			return true
+		}
+	}
+	return false
+}
+
+// onRecvTx records the outputs of a mined transaction paying the watched
+// addresses, the notifications of mempool transactions are ignored
+func (w *watchOnly) onRecvTx(tx *btcutil.Tx, details *btcjson.BlockDetails) {
+	if details == nil {
+		return
+	}
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	for i, txOut := range tx.MsgTx().TxOut {
+		if w.pays(txOut.PkScript) {
+			w.received[wire.OutPoint{Hash: *tx.Sha(), Index: uint32(i)}] = btcutil.Amount(txOut.Value)
+		}
+	}
+}
+
+// onRedeemingTx records the outputs spent by a mined transaction spending
+// outputs paying the watched addresses
+func (w *watchOnly) onRedeemingTx(tx *btcutil.Tx, details *btcjson.BlockDetails) {
+	if details == nil {
+		return
+	}
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	for _, txIn := range tx.MsgTx().TxIn {
+		w.spent[txIn.PreviousOutPoint] = true
+	}
+}
+
+// ledger is a set of outputs paying the watched addresses and of the
+// outputs spent
+type ledger struct {
+	received map[wire.OutPoint]btcutil.Amount
+	spent    map[wire.OutPoint]bool
+}
+
+// balance returns the amount of the received outputs which are unspent
+func (l *ledger) balance() btcutil.Amount {
+	var balance btcutil.Amount
+	for op, amt := range l.received {
+		if !l.spent[op] {
+			balance += amt
+		}
+	}
+	return balance
+}
+
+// scan returns the ledger of the watched addresses from the blocks mined
+// since they were watched, which is what the notifications should add up
+// to unless blocks were disconnected since
+func (w *watchOnly) scan() (*ledger, error) {
+	l := &ledger{
+		received: make(map[wire.OutPoint]btcutil.Amount),
+		spent:    make(map[wire.OutPoint]bool),
+	}
+	height, err := w.client.GetBlockCount()
+	if err != nil {
+		return nil, err
+	}
+	for h := w.height + 1; h <= height; h++ {
+		hash, err := w.client.GetBlockHash(h)
+		if err != nil {
+			return nil, err
+		}
+		block, err := w.client.GetBlock(hash)
+		if err != nil {
+			return nil, err
+		}
+		for _, tx := range block.Transactions() {
+			for _, txIn := range tx.MsgTx().TxIn {
+				if _, ok := l.received[txIn.PreviousOutPoint]; ok {
+					l.spent[txIn.PreviousOutPoint] = true
+				}
+			}
+			for i, txOut := range tx.MsgTx().TxOut {
+				if w.pays(txOut.PkScript) {
+					l.received[wire.OutPoint{Hash: *tx.Sha(), Index: uint32(i)}] = btcutil.Amount(txOut.Value)
+				}
+			}
+		}
+	}
+	return l, nil
+}
+
+// WatchOnlyStats compares the payments observed by a watch-only actor from
+// its notifications with the chain
+type WatchOnlyStats struct {
+	Name    string `json:"name"`
+	Watched string `json:"watched"`
+
+	// Received is the number of outputs notified, Missed the number of
+	// outputs in the chain which were not and Unexpected the number of
+	// outputs notified which are not in the chain
+	Received   int `json:"received"`
+	Missed     int `json:"missed"`
+	Unexpected int `json:"unexpected"`
+
+	// Balance is the balance tracked from the notifications and Expected
+	// the balance of the outputs in the chain
+	Balance  int64 `json:"balance"`
+	Expected int64 `json:"expected"`
+}
+
+// newWatchOnlyStats compares the notified ledger with the chain ledger
+func newWatchOnlyStats(name, watched string, notified, chain *ledger) *WatchOnlyStats {
+	s := &WatchOnlyStats{
+		Name:     name,
+		Watched:  watched,
+		Received: len(notified.received),
+		Balance:  int64(notified.balance()),
+		Expected: int64(chain.balance()),
+	}
+	for op := range chain.received {
+		if _, ok := notified.received[op]; !ok {
+			s.Missed++
+		}
+	}
+	for op := range notified.received {
+		if _, ok := chain.received[op]; !ok {
+			s.Unexpected++
+		}
+	}
+	return s
+}
+
+// String returns the comparison in a line of the summary
+func (s *WatchOnlyStats) String() string {
+	return fmt.Sprintf("watching %s, %d outputs notified, %d missed and %d unexpected, balance %v, expected %v",
+		s.Watched, s.Received, s.Missed, s.Unexpected,
+		btcutil.Amount(s.Balance), btcutil.Amount(s.Expected))
+}
+
+// check compares the payments notified to the watch-only actor with the
+// chain and disconnects it
+func (w *watchOnly) check() (*WatchOnlyStats, error) {
+	defer w.client.Shutdown()
+	chain, err := w.scan()
+	if err != nil {
+		return nil, err
+	}
+	w.mtx.Lock()
+	notified := &ledger{received: w.received, spent: w.spent}
+	s := newWatchOnlyStats(w.name, w.watched, notified, chain)
+	w.mtx.Unlock()
+	return s, nil
+}
+
+// startWatchOnly starts n watch-only actors, the i-th watching the
+// addresses of the i-th actor from the next node, so that the payments
+// are notified after they propagate
+func (com *Communication) startWatchOnly(n int, actors []*Actor, nodes []*Node) {
+	if len(actors) == 0 {
+		return
+	}
+	for i := 0; i < n; i++ {
+		a := actors[i%len(actors)]
+		node := nodes[(i+1)%len(nodes)]
+		w := newWatchOnly(fmt.Sprintf("watchonly-%d", i), a.String(), a.ownedAddresses)
+		if err := w.connect(node, a.ownedAddresses); err != nil {
+			log.Errorf("%s: Cannot watch %s: %v", w, a, err)
+			continue
+		}
+		log.Debugf("%s: Watching %d addresses of %s on %s", w, len(w.addrs), a, node)
+		com.watchers = append(com.watchers, w)
+	}
+}
+
+// checkWatchOnly checks the watch-only actors before the nodes are shut
+// down
+func (com *Communication) checkWatchOnly() {
+	for _, w := range com.watchers {
+		s, err := w.check()
+		if err != nil {
+			log.Errorf("%s: Cannot check payments: %v", w, err)
+			continue
+		}
+		if s.Missed > 0 || s.Unexpected > 0 || s.Balance != s.Expected {
+			log.Warnf("%s: Notifications differ from the chain: %s", w, s)
+		}
+		com.watchStats = append(com.watchStats, s)
+	}
+}
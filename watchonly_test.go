@@ -0,0 +1,48 @@
+package btcsim
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+func TestWatchOnlyStats(t *testing.T) {
+	op := func(i uint32) wire.OutPoint { return wire.OutPoint{Index: i} }
+	chain := &ledger{
+		received: map[wire.OutPoint]btcutil.Amount{op(0): 100, op(1): 200, op(2): 300},
+		spent:    map[wire.OutPoint]bool{op(0): true},
+	}
+	notified := &ledger{
+		received: map[wire.OutPoint]btcutil.Amount{op(0): 100, op(1): 200, op(3): 400},
+		spent:    map[wire.OutPoint]bool{},
+	}
+	if b := chain.balance(); b != 500 {
+		t.Errorf("got chain balance %v want 500", b)
+	}
+
+	s := newWatchOnlyStats("watchonly-0", "actor-18557", notified, chain)
+	if s.Received != 3 || s.Missed != 1 || s.Unexpected != 1 {
+		t.Errorf("got %d received, %d missed and %d unexpected outputs", s.Received, s.Missed, s.Unexpected)
+	}
+	if s.Balance != 700 || s.Expected != 500 {
+		t.Errorf("got balance %d expected %d", s.Balance, s.Expected)
+	}
+	if !strings.Contains(s.String(), "watching actor-18557") {
+		t.Errorf("got %q", s)
+	}
+}
+
+func TestWatchOnlyIgnoresMempool(t *testing.T) {
+	w := newWatchOnly("watchonly-0", "actor-18557", []btcutil.Address{fakeAddress("a")})
+	if !w.addrs["a"] {
+		t.Fatalf("address a is not watched")
+	}
+	// transactions not mined yet are notified without block details
+	w.onRecvTx(nil, nil)
+	w.onRedeemingTx(nil, nil)
+	if len(w.received) != 0 || len(w.spent) != 0 {
+		t.Errorf("mempool notifications were recorded")
+	}
+}